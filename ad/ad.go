@@ -0,0 +1,244 @@
+// Package ad decodes the binary and encoded attributes of Active
+// Directory: objectGUID, objectSid, userAccountControl, the FILETIME
+// timestamps such as pwdLastSet and lastLogonTimestamp, and logonHours.
+package ad
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DecodeGUID formats an objectGUID value as a UUID string. Its first
+// three fields are stored little-endian.
+func DecodeGUID(b []byte) (string, error) {
+	if len(b) != 16 {
+		return "", fmt.Errorf("ad: GUID is %d bytes, not 16", len(b))
+	}
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]),
+		binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:]), nil
+}
+
+// EncodeGUID is the inverse of DecodeGUID, for searching by objectGUID.
+func EncodeGUID(s string) ([]byte, error) {
+	parts := strings.Split(s, "-")
+	lengths := []int{8, 4, 4, 4, 12}
+	if len(parts) != len(lengths) {
+		return nil, fmt.Errorf("ad: malformed GUID %q", s)
+	}
+	var b []byte
+	for i, p := range parts {
+		v, err := hex.DecodeString(p)
+		if err != nil || len(p) != lengths[i] {
+			return nil, fmt.Errorf("ad: malformed GUID %q", s)
+		}
+		if i < 3 {
+			for j, k := 0, len(v)-1; j < k; j, k = j+1, k-1 {
+				v[j], v[k] = v[k], v[j]
+			}
+		}
+		b = append(b, v...)
+	}
+	return b, nil
+}
+
+// DecodeSID formats an objectSid value, or one of objectSid's relatives
+// such as tokenGroups, in the S-1-5-21-... string form.
+func DecodeSID(b []byte) (string, error) {
+	if len(b) < 8 || len(b) != 8+4*int(b[1]) {
+		return "", fmt.Errorf("ad: malformed SID")
+	}
+	var authority uint64
+	for _, c := range b[2:8] {
+		authority = authority<<8 | uint64(c)
+	}
+	s := fmt.Sprintf("S-%d-%d", b[0], authority)
+	for i := 8; i < len(b); i += 4 {
+		s += "-" + strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b[i:])), 10)
+	}
+	return s, nil
+}
+
+// EncodeSID is the inverse of DecodeSID, for searching by objectSid.
+func EncodeSID(s string) ([]byte, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 3 || len(parts) > 3+255 || parts[0] != "S" {
+		return nil, fmt.Errorf("ad: malformed SID %q", s)
+	}
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("ad: malformed SID %q", s)
+	}
+	authority, err := strconv.ParseUint(parts[2], 10, 48)
+	if err != nil {
+		return nil, fmt.Errorf("ad: malformed SID %q", s)
+	}
+	b := []byte{byte(revision), byte(len(parts) - 3)}
+	for i := 5; i >= 0; i-- {
+		b = append(b, byte(authority>>(8*i)))
+	}
+	for _, p := range parts[3:] {
+		sub, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("ad: malformed SID %q", s)
+		}
+		b = binary.LittleEndian.AppendUint32(b, uint32(sub))
+	}
+	return b, nil
+}
+
+// UserAccountControl holds the flags of the userAccountControl
+// attribute.
+type UserAccountControl uint32
+
+const (
+	Script                     UserAccountControl = 0x00000001
+	AccountDisable             UserAccountControl = 0x00000002
+	HomedirRequired            UserAccountControl = 0x00000008
+	Lockout                    UserAccountControl = 0x00000010
+	PasswdNotReqd              UserAccountControl = 0x00000020
+	PasswdCantChange           UserAccountControl = 0x00000040
+	EncryptedTextPwdAllowed    UserAccountControl = 0x00000080
+	TempDuplicateAccount       UserAccountControl = 0x00000100
+	NormalAccount              UserAccountControl = 0x00000200
+	InterdomainTrustAccount    UserAccountControl = 0x00000800
+	WorkstationTrustAccount    UserAccountControl = 0x00001000
+	ServerTrustAccount         UserAccountControl = 0x00002000
+	DontExpirePassword         UserAccountControl = 0x00010000
+	MNSLogonAccount            UserAccountControl = 0x00020000
+	SmartcardRequired          UserAccountControl = 0x00040000
+	TrustedForDelegation       UserAccountControl = 0x00080000
+	NotDelegated               UserAccountControl = 0x00100000
+	UseDESKeyOnly              UserAccountControl = 0x00200000
+	DontReqPreauth             UserAccountControl = 0x00400000
+	PasswordExpired            UserAccountControl = 0x00800000
+	TrustedToAuthForDelegation UserAccountControl = 0x01000000
+	PartialSecretsAccount      UserAccountControl = 0x04000000
+)
+
+var userAccountControlNames = []struct {
+	flag UserAccountControl
+	name string
+}{
+	{Script, "SCRIPT"},
+	{AccountDisable, "ACCOUNTDISABLE"},
+	{HomedirRequired, "HOMEDIR_REQUIRED"},
+	{Lockout, "LOCKOUT"},
+	{PasswdNotReqd, "PASSWD_NOTREQD"},
+	{PasswdCantChange, "PASSWD_CANT_CHANGE"},
+	{EncryptedTextPwdAllowed, "ENCRYPTED_TEXT_PWD_ALLOWED"},
+	{TempDuplicateAccount, "TEMP_DUPLICATE_ACCOUNT"},
+	{NormalAccount, "NORMAL_ACCOUNT"},
+	{InterdomainTrustAccount, "INTERDOMAIN_TRUST_ACCOUNT"},
+	{WorkstationTrustAccount, "WORKSTATION_TRUST_ACCOUNT"},
+	{ServerTrustAccount, "SERVER_TRUST_ACCOUNT"},
+	{DontExpirePassword, "DONT_EXPIRE_PASSWORD"},
+	{MNSLogonAccount, "MNS_LOGON_ACCOUNT"},
+	{SmartcardRequired, "SMARTCARD_REQUIRED"},
+	{TrustedForDelegation, "TRUSTED_FOR_DELEGATION"},
+	{NotDelegated, "NOT_DELEGATED"},
+	{UseDESKeyOnly, "USE_DES_KEY_ONLY"},
+	{DontReqPreauth, "DONT_REQ_PREAUTH"},
+	{PasswordExpired, "PASSWORD_EXPIRED"},
+	{TrustedToAuthForDelegation, "TRUSTED_TO_AUTH_FOR_DELEGATION"},
+	{PartialSecretsAccount, "PARTIAL_SECRETS_ACCOUNT"},
+}
+
+// ParseUserAccountControl parses a userAccountControl value, which is a
+// signed 32-bit decimal.
+func ParseUserAccountControl(s string) (UserAccountControl, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < -1<<31 || v > 1<<32-1 {
+		return 0, fmt.Errorf("ad: malformed userAccountControl %q", s)
+	}
+	return UserAccountControl(uint32(v)), nil
+}
+
+// Has reports whether all of the flags are set.
+func (u UserAccountControl) Has(flags UserAccountControl) bool {
+	return u&flags == flags
+}
+
+// Flags returns the names of the flags set, as Microsoft documents them,
+// with unknown bits in hex.
+func (u UserAccountControl) Flags() []string {
+	var names []string
+	for _, f := range userAccountControlNames {
+		if u&f.flag != 0 {
+			names = append(names, f.name)
+			u &^= f.flag
+		}
+	}
+	if u != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(u)))
+	}
+	return names
+}
+
+func (u UserAccountControl) String() string {
+	return strings.Join(u.Flags(), "|")
+}
+
+// fileTimeEpoch is the start of FILETIME, 1601-01-01, in Unix seconds.
+const fileTimeEpoch = -11644473600
+
+// ParseFileTime parses a FILETIME attribute such as pwdLastSet,
+// lastLogonTimestamp or accountExpires: a count of 100ns intervals since
+// 1601. Zero and the largest value mean never, and give the zero Time.
+func ParseFileTime(s string) (time.Time, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return time.Time{}, fmt.Errorf("ad: malformed FILETIME %q", s)
+	}
+	if v == 0 || v == 1<<63-1 {
+		return time.Time{}, nil
+	}
+	return time.Unix(fileTimeEpoch+v/1e7, v%1e7*100).UTC(), nil
+}
+
+// FileTime formats t as a FILETIME, for filters and modifications. The
+// zero Time is formatted as 0.
+func FileTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt((t.Unix()-fileTimeEpoch)*1e7+int64(t.Nanosecond())/100, 10)
+}
+
+// LogonHours is a decoded logonHours attribute: whether logging on is
+// allowed in each hour of the week, by weekday and hour in UTC.
+type LogonHours [7][24]bool
+
+// DecodeLogonHours decodes a logonHours value, 21 bytes holding a bit
+// per hour from Sunday 00:00 UTC, least significant bit first.
+func DecodeLogonHours(b []byte) (LogonHours, error) {
+	var h LogonHours
+	if len(b) != 21 {
+		return h, fmt.Errorf("ad: logonHours is %d bytes, not 21", len(b))
+	}
+	for i := 0; i < 7*24; i++ {
+		h[i/24][i%24] = b[i/8]&(1<<(i%8)) != 0
+	}
+	return h, nil
+}
+
+// Encode is the inverse of DecodeLogonHours.
+func (h LogonHours) Encode() []byte {
+	b := make([]byte, 21)
+	for i := 0; i < 7*24; i++ {
+		if h[i/24][i%24] {
+			b[i/8] |= 1 << (i % 8)
+		}
+	}
+	return b
+}
+
+// Allowed reports whether logging on is allowed at t.
+func (h LogonHours) Allowed(t time.Time) bool {
+	t = t.UTC()
+	return h[t.Weekday()][t.Hour()]
+}
@@ -0,0 +1,141 @@
+package ad
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGUID(t *testing.T) {
+	b := []byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	const expected = "00112233-4455-6677-8899-aabbccddeeff"
+	s, err := DecodeGUID(b)
+	if err != nil || s != expected {
+		t.Errorf("Bad result: %q, %v (expected %q)", s, err, expected)
+	}
+	if e, err := EncodeGUID(expected); err != nil || !bytes.Equal(e, b) {
+		t.Errorf("Bad result: %x, %v (expected %x)", e, err, b)
+	}
+	for i, s := range []string{"", "00112233-4455-6677-8899", "0011223-34455-6677-8899-aabbccddeeff", "00112233-4455-6677-8899-aabbccddeefg"} {
+		if _, err := EncodeGUID(s); err == nil {
+			t.Errorf("#%d: Expected an error for %q", i, s)
+		}
+	}
+	if _, err := DecodeGUID(b[1:]); err == nil {
+		t.Error("Expected an error for a short GUID")
+	}
+}
+
+func TestSID(t *testing.T) {
+	tests := []struct {
+		b []byte
+		s string
+	}{
+		{[]byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 32, 2, 0, 0}, "S-1-5-32-544"},
+		{[]byte{1, 5, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 0x15, 0xcd, 0x5b, 0x07, 0xe0, 0x3a, 0x0a, 0x3e, 0x8c, 0xd5, 0x9b, 0x5d, 0xf4, 0x01, 0, 0},
+			"S-1-5-21-123456789-1040857824-1570493836-500"},
+		{[]byte{1, 0, 0, 0, 0, 0, 0, 1}, "S-1-1"},
+	}
+	for i, test := range tests {
+		if s, err := DecodeSID(test.b); err != nil || s != test.s {
+			t.Errorf("#%d: Bad result: %q, %v (expected %q)", i, s, err, test.s)
+		}
+		if b, err := EncodeSID(test.s); err != nil || !bytes.Equal(b, test.b) {
+			t.Errorf("#%d: Bad result: %x, %v (expected %x)", i, b, err, test.b)
+		}
+	}
+	if _, err := DecodeSID([]byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0}); err == nil {
+		t.Error("Expected an error for a truncated SID")
+	}
+	if _, err := EncodeSID("S-1-5-4294967296"); err == nil {
+		t.Error("Expected an error for an out of range sub-authority")
+	}
+}
+
+func TestUserAccountControl(t *testing.T) {
+	tests := []struct {
+		value string
+		flags []string
+	}{
+		{"512", []string{"NORMAL_ACCOUNT"}},
+		{"66050", []string{"ACCOUNTDISABLE", "NORMAL_ACCOUNT", "DONT_EXPIRE_PASSWORD"}},
+		{"-2147483136", []string{"NORMAL_ACCOUNT", "0x80000000"}},
+	}
+	for i, test := range tests {
+		u, err := ParseUserAccountControl(test.value)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if flags := u.Flags(); !reflect.DeepEqual(flags, test.flags) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, flags, test.flags)
+		}
+	}
+	u, _ := ParseUserAccountControl("66050")
+	if !u.Has(AccountDisable|NormalAccount) || u.Has(Lockout) {
+		t.Errorf("Bad flags: %v", u)
+	}
+	if s := u.String(); s != "ACCOUNTDISABLE|NORMAL_ACCOUNT|DONT_EXPIRE_PASSWORD" {
+		t.Errorf("Bad string: %s", s)
+	}
+}
+
+func TestFileTime(t *testing.T) {
+	tests := []struct {
+		value string
+		t     time.Time
+	}{
+		{"0", time.Time{}},
+		{"9223372036854775807", time.Time{}},
+		{"116444736000000000", time.Unix(0, 0).UTC()},
+		{"132223104000000001", time.Date(2020, 1, 1, 0, 0, 0, 100, time.UTC)},
+	}
+	for i, test := range tests {
+		ft, err := ParseFileTime(test.value)
+		if err != nil || !ft.Equal(test.t) {
+			t.Errorf("#%d: Bad result: %v, %v (expected %v)", i, ft, err, test.t)
+		}
+	}
+	if s := FileTime(time.Date(2020, 1, 1, 0, 0, 0, 100, time.UTC)); s != "132223104000000001" {
+		t.Errorf("Bad result: %s", s)
+	}
+	if _, err := ParseFileTime("-1"); err == nil {
+		t.Error("Expected an error for a negative FILETIME")
+	}
+}
+
+func TestLogonHours(t *testing.T) {
+	// Monday to Friday, 08:00 to 18:00 UTC.
+	var h LogonHours
+	for day := time.Monday; day <= time.Friday; day++ {
+		for hour := 8; hour < 18; hour++ {
+			h[day][hour] = true
+		}
+	}
+	b := h.Encode()
+	if b[3] != 0 || b[4] != 0xff || b[5] != 0x03 {
+		t.Errorf("Bad encoding: %x", b)
+	}
+	d, err := DecodeLogonHours(b)
+	if err != nil || d != h {
+		t.Errorf("Bad result: %v, %v", d, err)
+	}
+	tests := []struct {
+		t       time.Time
+		allowed bool
+	}{
+		{time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), true},   // Monday
+		{time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC), false}, // Monday
+		{time.Date(2024, 1, 6, 9, 0, 0, 0, time.UTC), false},  // Saturday
+		{time.Date(2024, 1, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600)), true},
+	}
+	for i, test := range tests {
+		if allowed := d.Allowed(test.t); allowed != test.allowed {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, allowed, test.allowed)
+		}
+	}
+	if _, err := DecodeLogonHours(b[1:]); err == nil {
+		t.Error("Expected an error for short logonHours")
+	}
+}
@@ -0,0 +1,46 @@
+package ad
+
+import (
+	"github.com/stesla/ldap"
+)
+
+// MatchingRuleInChain is LDAP_MATCHING_RULE_IN_CHAIN, which Active
+// Directory evaluates against the transitive closure of a DN-valued
+// attribute such as member or memberOf.
+const MatchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// pageSize is within the MaxPageSize of Active Directory's default
+// query policy.
+const pageSize = 1000
+
+// ExpandGroup is like ldap.ExpandGroup, but has the domain controller
+// resolve the nesting in a single search below base.
+func ExpandGroup(c ldap.Conn, base, groupDN string) ([]string, error) {
+	return chainSearch(c, base, ldap.And(
+		ldap.ExtensibleMatch(MatchingRuleInChain, "memberOf", groupDN, false),
+		ldap.Not(ldap.Equals("objectClass", "group")),
+	))
+}
+
+// GroupsOf is like ldap.GroupsOf, but has the domain controller resolve
+// the nesting in a single search.
+func GroupsOf(c ldap.Conn, base, dn string) ([]string, error) {
+	return chainSearch(c, base, ldap.ExtensibleMatch(MatchingRuleInChain, "member", dn, false))
+}
+
+func chainSearch(c ldap.Conn, base string, filter ldap.Filter) ([]string, error) {
+	results, err := c.SearchWithPaging(ldap.SearchRequest{
+		BaseObject: []byte(base),
+		Scope:      ldap.WholeSubtree,
+		Filter:     filter,
+		Attributes: [][]byte{[]byte("1.1")},
+	}, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	dns := make([]string, len(results))
+	for i, r := range results {
+		dns[i] = r.DN
+	}
+	return dns, nil
+}
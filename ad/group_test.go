@@ -0,0 +1,40 @@
+package ad
+
+import (
+	"github.com/stesla/ldap"
+	"reflect"
+	"testing"
+)
+
+type searchConn struct {
+	ldap.Conn
+	filters []string
+}
+
+func (c *searchConn) SearchWithPaging(req ldap.SearchRequest, pageSize int) ([]ldap.SearchResult, error) {
+	f, err := ldap.DecompileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	c.filters = append(c.filters, f)
+	return []ldap.SearchResult{{DN: "cn=found"}}, nil
+}
+
+func TestGroups(t *testing.T) {
+	c := &searchConn{}
+	const base = "dc=example,dc=com"
+	members, err := ExpandGroup(c, base, "cn=Admins,cn=Users,"+base)
+	if err != nil || !reflect.DeepEqual(members, []string{"cn=found"}) {
+		t.Errorf("Bad result: %v, %v", members, err)
+	}
+	if _, err := GroupsOf(c, base, "cn=Alice (Admin),cn=Users,"+base); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"(&(memberOf:1.2.840.113556.1.4.1941:=cn=Admins,cn=Users,dc=example,dc=com)(!(objectClass=group)))",
+		`(member:1.2.840.113556.1.4.1941:=cn=Alice \28Admin\29,cn=Users,dc=example,dc=com)`,
+	}
+	if !reflect.DeepEqual(c.filters, expected) {
+		t.Errorf("Bad filters: %v (expected %v)", c.filters, expected)
+	}
+}
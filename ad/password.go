@@ -0,0 +1,44 @@
+package ad
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+	"unicode/utf16"
+)
+
+// EncodePassword encodes a password as a unicodePwd value: quoted, in
+// UTF-16LE.
+func EncodePassword(password string) string {
+	u := utf16.Encode([]rune(`"` + password + `"`))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		b[2*i], b[2*i+1] = byte(c), byte(c>>8)
+	}
+	return string(b)
+}
+
+// SetPassword resets the password of the user dn, as an administrator
+// does, regardless of the password history. Active Directory only
+// accepts unicodePwd over an encrypted connection, so c must use LDAPS
+// or StartTLS.
+func SetPassword(c ldap.Conn, dn, password string) error {
+	if c.TLS() == nil {
+		return fmt.Errorf("ad: setting a password requires a TLS connection")
+	}
+	return c.Modify(dn, []ldap.Modification{
+		{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "unicodePwd", Values: []string{EncodePassword(password)}}},
+	})
+}
+
+// ChangePassword changes the password of the user dn from oldPassword, as
+// the user does, subject to the password policy. As for SetPassword, c
+// must use LDAPS or StartTLS. It may be bound as the user.
+func ChangePassword(c ldap.Conn, dn, oldPassword, newPassword string) error {
+	if c.TLS() == nil {
+		return fmt.Errorf("ad: changing a password requires a TLS connection")
+	}
+	return c.Modify(dn, []ldap.Modification{
+		{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "unicodePwd", Values: []string{EncodePassword(oldPassword)}}},
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "unicodePwd", Values: []string{EncodePassword(newPassword)}}},
+	})
+}
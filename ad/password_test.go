@@ -0,0 +1,100 @@
+package ad
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/server"
+	"math/big"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncodePassword(t *testing.T) {
+	expected := "\"\x00p\x00\xe4\x00s\x00s\x00\"\x00"
+	if s := EncodePassword("päss"); s != expected {
+		t.Errorf("Bad result: %q (expected %q)", s, expected)
+	}
+}
+
+type modifyHandler struct {
+	server.BaseHandler
+	mods chan []ldap.Modification
+}
+
+func (h *modifyHandler) Modify(c *server.Conn, req *server.ModifyRequest) error {
+	h.mods <- req.Modifications
+	return nil
+}
+
+func testConn(t *testing.T, h server.Handler, useTLS bool) ldap.Conn {
+	client, srv := net.Pipe()
+	if useTLS {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "dc"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+		srv = tls.Server(srv, &tls.Config{Certificates: []tls.Certificate{cert}})
+		client = tls.Client(client, &tls.Config{InsecureSkipVerify: true})
+	}
+	s := &server.Server{Handler: h}
+	go s.ServeConn(srv)
+	c := ldap.NewConn(client)
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return c
+}
+
+func TestPassword(t *testing.T) {
+	h := &modifyHandler{mods: make(chan []ldap.Modification, 1)}
+	const dn = "cn=Alice,cn=Users,dc=example,dc=com"
+
+	c := testConn(t, h, false)
+	if err := SetPassword(c, dn, "new"); err == nil {
+		t.Error("SetPassword succeeded without TLS")
+	}
+	if err := ChangePassword(c, dn, "old", "new"); err == nil {
+		t.Error("ChangePassword succeeded without TLS")
+	}
+
+	c = testConn(t, h, true)
+	if err := SetPassword(c, dn, "new"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ldap.Modification{
+		{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "unicodePwd", Values: []string{EncodePassword("new")}}},
+	}
+	if mods := <-h.mods; !reflect.DeepEqual(mods, expected) {
+		t.Errorf("Bad result: %v (expected %v)", mods, expected)
+	}
+
+	if err := ChangePassword(c, dn, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	expected = []ldap.Modification{
+		{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "unicodePwd", Values: []string{EncodePassword("old")}}},
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "unicodePwd", Values: []string{EncodePassword("new")}}},
+	}
+	if mods := <-h.mods; !reflect.DeepEqual(mods, expected) {
+		t.Errorf("Bad result: %v (expected %v)", mods, expected)
+	}
+}
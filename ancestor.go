@@ -0,0 +1,175 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MatchedDN returns the matched DN of err, if it is or wraps an Error
+// that has one: for noSuchObject, the DN of the deepest superior of the
+// missing entry that the server found.
+func MatchedDN(err error) (string, bool) {
+	var e *Error
+	if !errors.As(err, &e) || e.MatchedDN == "" {
+		return "", false
+	}
+	return e.MatchedDN, true
+}
+
+// ExistingAncestor returns the DN of the deepest entry that exists at
+// or above dn, or "" if there is none. It reads dn, and trusts the
+// matched DN of a noSuchObject result if the server returns one, or
+// else reads each superior in turn.
+func ExistingAncestor(c Conn, dn string) (string, error) {
+	name, err := ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(name); i++ {
+		base := name[i:].String()
+		if i == 0 {
+			base = dn
+		}
+		_, err := c.Search(SearchRequest{
+			BaseObject: []byte(base),
+			Scope:      BaseObject,
+			Filter:     Present("objectClass"),
+			Attributes: [][]byte{[]byte("1.1")},
+		})
+		if err == nil {
+			return base, nil
+		}
+		if !IsErrorWithCode(err, NoSuchObject) {
+			return "", err
+		}
+		if matched, ok := MatchedDN(err); ok {
+			if j := ancestorIndex(name, matched); j > i {
+				return matched, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// ancestorIndex returns the index i at which name[i:] is the DN
+// matched, or -1 if matched is not name or a superior of it.
+func ancestorIndex(name DN, matched string) int {
+	norm := normalizeDN(matched)
+	for i := range name {
+		if normalizeDN(name[i:].String()) == norm {
+			return i
+		}
+	}
+	return -1
+}
+
+// MissingAncestors returns the DNs of the superiors of dn that do not
+// exist, superiors first, so that a client can add them before dn.
+func MissingAncestors(c Conn, dn string) ([]string, error) {
+	name, err := ParseDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := ExistingAncestor(c, dn)
+	if err != nil {
+		return nil, err
+	}
+	end := len(name)
+	if existing != "" {
+		end = ancestorIndex(name, existing)
+	}
+	var missing []string
+	for i := end - 1; i > 0; i-- {
+		missing = append(missing, name[i:].String())
+	}
+	return missing, nil
+}
+
+// AddWithParents adds entry as Add does, first creating the superiors
+// of it that do not exist, as mkdir -p does for directories. A missing
+// superior is made from the template for the type of its RDN: templates
+// maps attribute types such as "ou" or "dc" to the attributes of the
+// entries to create, to which the values of the RDN are added. The leaf
+// is tried first, so that nothing more is done when its parent exists,
+// and superiors that another client creates meanwhile are not an error.
+// The controls are sent with every Add.
+func (l *conn) AddWithParents(entry *Entry, templates map[string][]Attribute, controls ...Control) error {
+	return addWithParents(l, entry, templates, controls)
+}
+
+func addWithParents(c Conn, entry *Entry, templates map[string][]Attribute, controls []Control) error {
+	name, err := ParseDN(entry.DN)
+	if err != nil {
+		return err
+	}
+	attrs := make([]Attribute, len(entry.Attributes))
+	for i, a := range entry.Attributes {
+		attrs[i] = Attribute{Type: a.Name, Values: a.Values}
+	}
+	err = c.Add(entry.DN, attrs, controls...)
+	if !IsErrorWithCode(err, NoSuchObject) {
+		return err
+	}
+
+	// The superiors to create are name[i:] for 0 < i < end.
+	end := -1
+	if matched, ok := MatchedDN(err); ok {
+		end = ancestorIndex(name, matched)
+	}
+	if end < 1 {
+		existing, err := ExistingAncestor(c, name[1:].String())
+		if err != nil {
+			return err
+		}
+		end = len(name)
+		if existing != "" {
+			end = ancestorIndex(name, existing)
+		}
+	}
+	parents := make([][]Attribute, end)
+	for i := end - 1; i > 0; i-- {
+		if parents[i], err = parentAttributes(name[i], templates); err != nil {
+			return err
+		}
+	}
+	for i := end - 1; i > 0; i-- {
+		err := c.Add(name[i:].String(), parents[i], controls...)
+		if err != nil && !IsErrorWithCode(err, EntryAlreadyExists) {
+			return err
+		}
+	}
+	return c.Add(entry.DN, attrs, controls...)
+}
+
+// parentAttributes returns the attributes of a superior named by rdn,
+// made from templates.
+func parentAttributes(rdn RDN, templates map[string][]Attribute) ([]Attribute, error) {
+	var template []Attribute
+	found := false
+	for typ, attrs := range templates {
+		if strings.EqualFold(typ, rdn[0].Type) {
+			template, found = attrs, true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("ldap: no template for superior %q", rdn.String())
+	}
+	attrs := make([]Attribute, len(template), len(template)+len(rdn))
+	for i, a := range template {
+		attrs[i] = Attribute{Type: a.Type, Values: append([]string(nil), a.Values...)}
+	}
+	for _, atv := range rdn {
+		i := 0
+		for i < len(attrs) && !strings.EqualFold(attrs[i].Type, atv.Type) {
+			i++
+		}
+		switch {
+		case i == len(attrs):
+			attrs = append(attrs, Attribute{Type: atv.Type, Values: []string{atv.Value}})
+		case !contains(attrs[i].Values, atv.Value):
+			attrs[i].Values = append(attrs[i].Values, atv.Value)
+		}
+	}
+	return attrs, nil
+}
@@ -0,0 +1,121 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+)
+
+// matchingConn is a directoryConn that reports matched DNs.
+type matchingConn struct {
+	directoryConn
+	searches int
+}
+
+func (c *matchingConn) Search(req SearchRequest) ([]SearchResult, error) {
+	c.searches++
+	results, err := c.directoryConn.Search(req)
+	if IsErrorWithCode(err, NoSuchObject) {
+		name, _ := ParseDN(string(req.BaseObject))
+		for i := 1; i < len(name); i++ {
+			if _, err := c.directoryConn.Search(SearchRequest{BaseObject: []byte(name[i:].String()), Filter: Present("objectClass")}); err == nil {
+				return nil, &Error{ResultCode: NoSuchObject, MatchedDN: name[i:].String()}
+			}
+		}
+	}
+	return results, err
+}
+
+func TestExistingAncestor(t *testing.T) {
+	const base = "dc=example,dc=com"
+	entries := []*Entry{
+		NewEntry(base, map[string][]string{"objectClass": {"domain"}}),
+		NewEntry("ou=people,"+base, map[string][]string{"objectClass": {"organizationalUnit"}}),
+	}
+
+	tests := []struct {
+		dn       string
+		existing string
+		missing  []string
+		searches int
+	}{
+		{"ou=people," + base, "ou=people," + base, nil, 1},
+		{"uid=alice,ou=people," + base, "ou=people," + base, nil, 1},
+		{"uid=bob,ou=staff,ou=people," + base, "ou=people," + base, []string{"ou=staff,ou=people," + base}, 1},
+		{"uid=carol,ou=a,ou=b,dc=example,dc=org", "", []string{"dc=org", "dc=example,dc=org", "ou=b,dc=example,dc=org", "ou=a,ou=b,dc=example,dc=org"}, 5},
+	}
+	for i, test := range tests {
+		for _, matched := range []bool{false, true} {
+			var c Conn = &directoryConn{entries: entries}
+			mc := &matchingConn{directoryConn: directoryConn{entries: entries}}
+			if matched {
+				c = mc
+			}
+			existing, err := ExistingAncestor(c, test.dn)
+			if err != nil || existing != test.existing {
+				t.Errorf("#%d (matched %v): Bad result: %q, %v (expected %q)", i, matched, existing, err, test.existing)
+			}
+			if matched && mc.searches != test.searches {
+				t.Errorf("#%d: Bad number of searches: %d (expected %d)", i, mc.searches, test.searches)
+			}
+			missing, err := MissingAncestors(c, test.dn)
+			if err != nil || !reflect.DeepEqual(missing, test.missing) {
+				t.Errorf("#%d (matched %v): Bad result: %v, %v (expected %v)", i, matched, missing, err, test.missing)
+			}
+		}
+	}
+
+	if dn, ok := MatchedDN(&Error{ResultCode: NoSuchObject, MatchedDN: base}); !ok || dn != base {
+		t.Errorf("Bad matched DN: %q, %v (expected %q)", dn, ok, base)
+	}
+	if _, ok := MatchedDN(&Error{ResultCode: NoSuchObject}); ok {
+		t.Error("Expected no matched DN")
+	}
+}
+
+// addingConn is a directoryConn that records adds, failing with
+// noSuchObject when the parent of the entry is missing.
+type addingConn struct {
+	directoryConn
+	added []string
+}
+
+func (c *addingConn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	name, _ := ParseDN(dn)
+	if _, err := c.Search(SearchRequest{BaseObject: []byte(name[1:].String()), Filter: Present("objectClass")}); err != nil {
+		return err
+	}
+	e := &Entry{DN: dn}
+	for _, a := range attrs {
+		e.Attributes = append(e.Attributes, NewEntryAttribute(a.Type, a.Values))
+	}
+	c.entries = append(c.entries, e)
+	c.added = append(c.added, dn)
+	return nil
+}
+
+func TestAddWithParents(t *testing.T) {
+	const base = "dc=example,dc=com"
+	c := &addingConn{directoryConn: directoryConn{entries: []*Entry{NewEntry(base, map[string][]string{"objectClass": {"domain"}})}}}
+	templates := map[string][]Attribute{"OU": {{Type: "objectClass", Values: []string{"organizationalUnit"}}}}
+	leaf := NewEntry("cn=alice,ou=staff+l=here,ou=people,"+base, map[string][]string{"objectClass": {"person"}})
+	if err := addWithParents(c, leaf, templates, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ou=people," + base, "ou=staff+l=here,ou=people," + base, leaf.DN}
+	if !reflect.DeepEqual(c.added, expected) {
+		t.Errorf("Bad result: %v (expected %v)", c.added, expected)
+	}
+	staff := c.entries[2].AttributeMap()
+	if ou, l := staff["ou"], staff["l"]; !reflect.DeepEqual(ou, []string{"staff"}) || !reflect.DeepEqual(l, []string{"here"}) {
+		t.Errorf("Bad attributes: %v", staff)
+	}
+
+	c.added = nil
+	if err := addWithParents(c, NewEntry("uid=bob,ou=people,"+base, nil), templates, nil); err != nil || len(c.added) != 1 {
+		t.Errorf("Bad result: %v, %v (expected one add)", c.added, err)
+	}
+	c.added = nil
+	if err := addWithParents(c, NewEntry("uid=carol,cn=ops,"+base, nil), templates, nil); err == nil || len(c.added) != 0 {
+		t.Errorf("Bad result: %v, %v (expected no template error)", c.added, err)
+	}
+}
@@ -0,0 +1,305 @@
+package ldap
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Argon2 (RFC 9106) and the BLAKE2b hash it is built on (RFC 7693), for
+// the {ARGON2} password scheme.
+
+const (
+	argon2d  = 0
+	argon2i  = 1
+	argon2id = 2
+
+	argon2Version    = 0x13
+	argon2SyncPoints = 4
+	argon2BlockWords = 128 // 1 KiB
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2Key derives a keyLen-byte tag from password and salt, with the
+// optional secret key and associated data, using memory KiB.
+func argon2Key(mode int, password, salt, key, data []byte, time, memory uint32, threads uint8, keyLen uint32) []byte {
+	lanes := uint32(threads)
+	h0 := argon2InitHash(mode, password, salt, key, data, time, memory, lanes, keyLen)
+	memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+	if memory < 2*argon2SyncPoints*lanes {
+		memory = 2 * argon2SyncPoints * lanes
+	}
+	laneLen := memory / lanes
+	segmentLen := laneLen / argon2SyncPoints
+	B := make([]argon2Block, memory)
+
+	var buf [1024]byte
+	for lane := uint32(0); lane < lanes; lane++ {
+		for i := uint32(0); i < 2; i++ {
+			binary.LittleEndian.PutUint32(h0[64:], i)
+			binary.LittleEndian.PutUint32(h0[68:], lane)
+			blake2bLong(buf[:], h0[:])
+			b := &B[lane*laneLen+i]
+			for j := range b {
+				b[j] = binary.LittleEndian.Uint64(buf[j*8:])
+			}
+		}
+	}
+
+	segment := func(pass, slice, lane uint32) {
+		var addresses, in, zero argon2Block
+		independent := mode == argon2i || mode == argon2id && pass == 0 && slice < argon2SyncPoints/2
+		if independent {
+			in[0], in[1], in[2] = uint64(pass), uint64(lane), uint64(slice)
+			in[3], in[4], in[5] = uint64(memory), uint64(time), uint64(mode)
+		}
+		nextAddresses := func() {
+			in[6]++
+			argon2Compress(&addresses, &in, &zero, false)
+			argon2Compress(&addresses, &addresses, &zero, false)
+		}
+
+		index := uint32(0)
+		if pass == 0 && slice == 0 {
+			index = 2
+			if independent {
+				nextAddresses()
+			}
+		}
+		offset := lane*laneLen + slice*segmentLen + index
+		for ; index < segmentLen; index, offset = index+1, offset+1 {
+			prev := offset - 1
+			if index == 0 && slice == 0 {
+				prev += laneLen
+			}
+			var random uint64
+			if independent {
+				if index%argon2BlockWords == 0 {
+					nextAddresses()
+				}
+				random = addresses[index%argon2BlockWords]
+			} else {
+				random = B[prev][0]
+			}
+			ref := argon2RefIndex(random, laneLen, segmentLen, lanes, pass, slice, lane, index)
+			argon2Compress(&B[offset], &B[prev], &B[ref], pass > 0)
+		}
+	}
+	for pass := uint32(0); pass < time; pass++ {
+		for slice := uint32(0); slice < argon2SyncPoints; slice++ {
+			for lane := uint32(0); lane < lanes; lane++ {
+				segment(pass, slice, lane)
+			}
+		}
+	}
+
+	final := B[laneLen-1]
+	for lane := uint32(1); lane < lanes; lane++ {
+		last := &B[lane*laneLen+laneLen-1]
+		for i := range final {
+			final[i] ^= last[i]
+		}
+	}
+	for i, w := range final {
+		binary.LittleEndian.PutUint64(buf[i*8:], w)
+	}
+	out := make([]byte, keyLen)
+	blake2bLong(out, buf[:])
+	return out
+}
+
+// argon2InitHash returns H0, with room after it for the block and lane
+// numbers of the first blocks.
+func argon2InitHash(mode int, password, salt, key, data []byte, time, memory, lanes, keyLen uint32) [72]byte {
+	var in []byte
+	le32 := func(v uint32) {
+		in = binary.LittleEndian.AppendUint32(in, v)
+	}
+	le32(lanes)
+	le32(keyLen)
+	le32(memory)
+	le32(time)
+	le32(argon2Version)
+	le32(uint32(mode))
+	for _, b := range [][]byte{password, salt, key, data} {
+		le32(uint32(len(b)))
+		in = append(in, b...)
+	}
+	var h0 [72]byte
+	copy(h0[:], blake2bSum(64, in))
+	return h0
+}
+
+// argon2RefIndex maps the pseudo-random value of a block to the index of
+// the block it is computed from.
+func argon2RefIndex(random uint64, laneLen, segmentLen, lanes, pass, slice, lane, index uint32) uint32 {
+	refLane := uint32(random>>32) % lanes
+	if pass == 0 && slice == 0 {
+		refLane = lane
+	}
+	// The reference area is every finished block but the previous one,
+	// of all segments but the current one in other lanes.
+	size, start := 3*segmentLen, ((slice+1)%argon2SyncPoints)*segmentLen
+	if lane == refLane {
+		size += index
+	}
+	if pass == 0 {
+		size, start = slice*segmentLen, 0
+		if slice == 0 || lane == refLane {
+			size += index
+		}
+	}
+	if index == 0 || lane == refLane {
+		size--
+	}
+	p := random & 0xffffffff
+	p = p * p >> 32
+	p = p * uint64(size) >> 32
+	return refLane*laneLen + uint32((uint64(start)+uint64(size)-(p+1))%uint64(laneLen))
+}
+
+// argon2Compress sets out to G(x, y), or XORs it in.
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, t argon2Block
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	t = r
+	for i := 0; i < argon2BlockWords; i += 16 {
+		blamka(&t[i], &t[i+1], &t[i+2], &t[i+3], &t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11], &t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < 16; i += 2 {
+		blamka(&t[i], &t[i+1], &t[16+i], &t[16+i+1], &t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1], &t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+	for i := range out {
+		if xor {
+			out[i] ^= r[i] ^ t[i]
+		} else {
+			out[i] = r[i] ^ t[i]
+		}
+	}
+}
+
+// blamka is the permutation P of Argon2: a BLAKE2b round whose additions
+// are hardened with multiplications.
+func blamka(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	gb := func(a, b, c, d *uint64) {
+		*a += *b + 2*uint64(uint32(*a))*uint64(uint32(*b))
+		*d = bits.RotateLeft64(*d^*a, -32)
+		*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+		*b = bits.RotateLeft64(*b^*c, -24)
+		*a += *b + 2*uint64(uint32(*a))*uint64(uint32(*b))
+		*d = bits.RotateLeft64(*d^*a, -16)
+		*c += *d + 2*uint64(uint32(*c))*uint64(uint32(*d))
+		*b = bits.RotateLeft64(*b^*c, -63)
+	}
+	gb(v0, v4, v8, v12)
+	gb(v1, v5, v9, v13)
+	gb(v2, v6, v10, v14)
+	gb(v3, v7, v11, v15)
+	gb(v0, v5, v10, v15)
+	gb(v1, v6, v11, v12)
+	gb(v2, v7, v8, v13)
+	gb(v3, v4, v9, v14)
+}
+
+// blake2bLong is the variable-length hash H' of Argon2.
+func blake2bLong(out, in []byte) {
+	prefixed := binary.LittleEndian.AppendUint32(nil, uint32(len(out)))
+	prefixed = append(prefixed, in...)
+	if len(out) <= 64 {
+		copy(out, blake2bSum(len(out), prefixed))
+		return
+	}
+	v := blake2bSum(64, prefixed)
+	for len(out) > 64 {
+		copy(out, v[:32])
+		out = out[32:]
+		if len(out) > 64 {
+			v = blake2bSum(64, v)
+		}
+	}
+	copy(out, blake2bSum(len(out), v))
+}
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [10][16]int{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+}
+
+// blake2bSum computes the unkeyed BLAKE2b digest of msg, size bytes long.
+func blake2bSum(size int, msg []byte) []byte {
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ uint64(size)
+
+	var t uint64
+	for len(msg) > 128 {
+		t += 128
+		blake2bCompress(&h, msg[:128], t, false)
+		msg = msg[128:]
+	}
+	var last [128]byte
+	copy(last[:], msg)
+	t += uint64(len(msg))
+	blake2bCompress(&h, last[:], t, true)
+
+	out := make([]byte, 64)
+	for i, w := range h {
+		binary.LittleEndian.PutUint64(out[i*8:], w)
+	}
+	return out[:size]
+}
+
+func blake2bCompress(h *[8]uint64, block []byte, t uint64, final bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[i*8:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= t
+	if final {
+		v[14] = ^v[14]
+	}
+
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for r := 0; r < 12; r++ {
+		s := &blake2bSigma[r%10]
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
@@ -1,9 +1,11 @@
 package asn1
 
 import (
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const ( // ASN.1 Classes
@@ -65,66 +67,201 @@ type OptionValue struct {
 	Value interface{}
 }
 
+// fieldOptions are the parsed options of a field. If the options could
+// not be parsed, only err is set, and encoding or decoding the field
+// fails with it.
 type fieldOptions struct {
-	tag         *int
-	implicit    *bool
-	application bool
-	optional    bool
-	enum        bool
-	set         bool
-	components  bool
+	tag        *int
+	class      int // of a tagged field
+	implicit   *bool
+	optional   bool
+	def        *string
+	enum       bool
+	set        bool
+	components bool
+	err        error
 }
 
 var (
 	optionValueType = reflect.TypeOf(OptionValue{})
 	rawValueType    = reflect.TypeOf(RawValue{})
+
+	boolType           = reflect.TypeOf(false)
+	int64Type          = reflect.TypeOf(int64(0))
+	byteSliceType      = reflect.TypeOf([]byte{})
+	interfaceSliceType = reflect.TypeOf([]interface{}{})
 )
 
-func parseFieldOptions(s string) (ret fieldOptions) {
+// parseFieldOptions parses the options of a field, given in an asn1
+// struct tag or in OptionValue.Opts, as a comma-separated list of:
+//
+//	tag:N        the field has tag N rather than its universal one
+//	class:C      the class of the tag: application, context (the
+//	             default) or private
+//	application  the same as class:application
+//	implicit     the tag replaces the universal one
+//	explicit     the tag is wrapped around the universal one (the
+//	             default, unless the Encoder or Decoder is Implicit)
+//	optional     the field may be absent, and is not encoded if zero
+//	default:V    the field may be absent, in which case it is V, and is
+//	             not encoded if V; for integers, booleans and byte slices
+//	enum         an integer is ENUMERATED rather than INTEGER
+//	set          a slice is a SET OF rather than a SEQUENCE OF
+//	components   the fields of a struct are those of the enclosing one,
+//	             as with COMPONENTS OF
+//
+// class, implicit and explicit imply tag:0 if no tag is given. Unknown
+// or repeated options, malformed values and options that conflict are
+// errors.
+func parseFieldOptions(s string) (ret fieldOptions, err error) {
+	if s == "" {
+		return
+	}
+	seen := make(map[string]bool)
 	for _, part := range strings.Split(s, ",") {
-		switch {
-		case part == "application":
-			ret.application = true
-			if ret.tag == nil {
-				ret.tag = new(int)
+		key, value, hasValue := strings.Cut(part, ":")
+		if key == "application" {
+			key, value, hasValue = "class", "application", true
+		}
+		if seen[key] {
+			return fieldOptions{}, StructuralError(fmt.Sprintf("repeated option %q in %q", key, s))
+		}
+		seen[key] = true
+		switch key {
+		case "tag", "class", "default":
+			if !hasValue {
+				return fieldOptions{}, StructuralError(fmt.Sprintf("option %q without a value in %q", key, s))
 			}
-		case part == "implicit":
-			ret.implicit = new(bool)
-			*ret.implicit = true
-			if ret.tag == nil {
-				ret.tag = new(int)
+		default:
+			if hasValue {
+				return fieldOptions{}, StructuralError(fmt.Sprintf("option %q takes no value in %q", key, s))
 			}
-		case part == "explicit":
-			ret.implicit = new(bool)
-			if ret.tag == nil {
-				ret.tag = new(int)
+		}
+		switch key {
+		case "tag":
+			i, err := strconv.Atoi(value)
+			if err != nil || i < 0 {
+				return fieldOptions{}, StructuralError(fmt.Sprintf("bad tag %q in %q", value, s))
+			}
+			ret.tag = &i
+		case "class":
+			switch value {
+			case "application":
+				ret.class = ClassApplication
+			case "context":
+				ret.class = ClassContextSpecific
+			case "private":
+				ret.class = ClassPrivate
+			default:
+				return fieldOptions{}, StructuralError(fmt.Sprintf("bad class %q in %q", value, s))
 			}
-		case strings.HasPrefix(part, "tag:"):
-			i, err := strconv.Atoi(part[4:])
-			if err == nil {
-				ret.tag = new(int)
-				*ret.tag = i
+		case "implicit", "explicit":
+			if ret.implicit != nil {
+				return fieldOptions{}, StructuralError(fmt.Sprintf("implicit and explicit in %q", s))
 			}
-		case part == "optional":
+			implicit := key == "implicit"
+			ret.implicit = &implicit
+		case "optional":
 			ret.optional = true
-		case part == "enum":
+		case "default":
+			ret.def = &value
+		case "enum":
 			ret.enum = true
-		case part == "set":
+		case "set":
 			ret.set = true
-		case part == "components":
+		case "components":
 			ret.components = true
+		default:
+			return fieldOptions{}, StructuralError(fmt.Sprintf("unknown option %q in %q", part, s))
 		}
 	}
+	if ret.optional && ret.def != nil {
+		return fieldOptions{}, StructuralError(fmt.Sprintf("optional and default in %q", s))
+	}
+	if ret.tag == nil && (ret.class != 0 || ret.implicit != nil) {
+		ret.tag = new(int)
+	}
+	if ret.tag != nil && ret.class == 0 {
+		ret.class = ClassContextSpecific
+	}
+	if ret.components && ret.tag != nil {
+		return fieldOptions{}, StructuralError(fmt.Sprintf("components of a tagged field in %q", s))
+	}
 	return
 }
 
+// Parsed options are cached, by struct type and by option string, so
+// that steady-state encoding and decoding skips the tag parsing. Cached
+// options are shared and must not be modified.
+var (
+	structOptionsCache sync.Map // reflect.Type -> []fieldOptions
+	optionsCache       sync.Map // string -> fieldOptions
+)
+
+// structOptions returns the options of each field of the struct type t.
+func structOptions(t reflect.Type) []fieldOptions {
+	if opts, ok := structOptionsCache.Load(t); ok {
+		return opts.([]fieldOptions)
+	}
+	opts := make([]fieldOptions, t.NumField())
+	for i := range opts {
+		opts[i] = parsedFieldOptions(t.Field(i).Tag.Get("asn1"))
+	}
+	structOptionsCache.Store(t, opts)
+	return opts
+}
+
+func cachedFieldOptions(s string) fieldOptions {
+	if opts, ok := optionsCache.Load(s); ok {
+		return opts.(fieldOptions)
+	}
+	opts := parsedFieldOptions(s)
+	optionsCache.Store(s, opts)
+	return opts
+}
+
+// defaultValue returns the value of type t given by the default option
+// v.
+func defaultValue(t reflect.Type, v string) (reflect.Value, error) {
+	def := reflect.New(t).Elem()
+	var err error
+	switch {
+	case t.Kind() == reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(v)
+		def.SetBool(b)
+	case reflect.Int <= t.Kind() && t.Kind() <= reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(v, 10, t.Bits())
+		def.SetInt(i)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		def.SetBytes([]byte(v))
+	default:
+		return def, StructuralError(fmt.Sprintf("default for unsupported type %v", t))
+	}
+	if err != nil {
+		return def, StructuralError(fmt.Sprintf("bad default %q for %v", v, t))
+	}
+	return def, nil
+}
+
+// parsedFieldOptions returns the options of s, with err set if they
+// could not be parsed.
+func parsedFieldOptions(s string) fieldOptions {
+	opts, err := parseFieldOptions(s)
+	if err != nil {
+		opts.err = err
+	}
+	return opts
+}
+
 func dereference(v reflect.Value, opts fieldOptions) (reflect.Value, fieldOptions) {
 	for {
 		if v.Type() == optionValueType {
 			vv := v.Interface().(OptionValue)
-			opts = parseFieldOptions(vv.Opts)
+			opts = cachedFieldOptions(vv.Opts)
 			v = reflect.ValueOf(vv.Value)
-		} else if k := v.Kind(); k == reflect.Ptr || k == reflect.Interface {
+		} else if k := v.Kind(); k == reflect.Ptr || k == reflect.Interface && !v.IsNil() {
 			v = v.Elem()
 		} else {
 			break
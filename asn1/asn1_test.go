@@ -0,0 +1,71 @@
+package asn1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldOptions(t *testing.T) {
+	intp := func(i int) *int { return &i }
+	boolp := func(b bool) *bool { return &b }
+	strp := func(s string) *string { return &s }
+	tests := []struct {
+		in  string
+		ok  bool
+		out fieldOptions
+	}{
+		{"", true, fieldOptions{}},
+		{"optional", true, fieldOptions{optional: true}},
+		{"tag:3", true, fieldOptions{tag: intp(3), class: ClassContextSpecific}},
+		{"application,tag:3", true, fieldOptions{tag: intp(3), class: ClassApplication}},
+		{"tag:3,class:private,implicit", true, fieldOptions{tag: intp(3), class: ClassPrivate, implicit: boolp(true)}},
+		{"class:context,explicit", true, fieldOptions{tag: intp(0), class: ClassContextSpecific, implicit: boolp(false)}},
+		{"implicit", true, fieldOptions{tag: intp(0), class: ClassContextSpecific, implicit: boolp(true)}},
+		{"tag:0,default:10", true, fieldOptions{tag: intp(0), class: ClassContextSpecific, def: strp("10")}},
+		{"default:", true, fieldOptions{def: strp("")}},
+		{"enum,set,components", true, fieldOptions{enum: true, set: true, components: true}},
+		{"optinal", false, fieldOptions{}},
+		{"tag:0,", false, fieldOptions{}},
+		{"tag:", false, fieldOptions{}},
+		{"tag:x", false, fieldOptions{}},
+		{"tag:-1", false, fieldOptions{}},
+		{"tag", false, fieldOptions{}},
+		{"tag:1,tag:2", false, fieldOptions{}},
+		{"optional,optional", false, fieldOptions{}},
+		{"optional:true", false, fieldOptions{}},
+		{"tag:1,implicit,explicit", false, fieldOptions{}},
+		{"class:universal,tag:1", false, fieldOptions{}},
+		{"application,class:private,tag:1", false, fieldOptions{}},
+		{"optional,default:1", false, fieldOptions{}},
+		{"components,tag:1", false, fieldOptions{}},
+		{" optional", false, fieldOptions{}},
+	}
+	for i, test := range tests {
+		out, err := parseFieldOptions(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: %q: Incorrect error result (passed? %v, expected %v): %v", i, test.in, err == nil, test.ok, err)
+		} else if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("#%d: %q: Bad result: %+v (expected %+v)", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestBadFieldOptions(t *testing.T) {
+	type bad struct {
+		X int `asn1:"tag:0,optinal"`
+	}
+	if _, err := AppendValue(nil, bad{}, ""); err == nil {
+		t.Errorf("Encode: Expected error")
+	}
+	var out bad
+	if err := UnmarshalValue([]byte{0x30, 0x03, 0x80, 0x01, 0x01}, &out, ""); err == nil {
+		t.Errorf("Decode: Expected error")
+	}
+	if _, err := AppendValue(nil, 1, "tag:1,implicit,explicit"); err == nil {
+		t.Errorf("Encode OptionValue: Expected error")
+	}
+	var x interface{}
+	if err := UnmarshalValue([]byte{0x80, 0x01, 0x01}, &x, "tag:0,bogus"); err == nil {
+		t.Errorf("Decode interface: Expected error")
+	}
+}
@@ -0,0 +1,76 @@
+package asn1
+
+import (
+	"bytes"
+	"testing"
+)
+
+// benchMessage is shaped like an LDAP search request.
+type benchMessage struct {
+	MessageID int
+	Op        benchSearch    `asn1:"application,tag:3"`
+	Controls  []benchControl `asn1:"tag:0,optional"`
+}
+
+type benchSearch struct {
+	Base       []byte
+	Scope      int `asn1:"enum"`
+	Deref      int `asn1:"enum"`
+	SizeLimit  int
+	TimeLimit  int
+	TypesOnly  bool
+	Filter     OptionValue
+	Attributes [][]byte
+}
+
+type benchControl struct {
+	Type        []byte
+	Criticality bool   `asn1:"optional"`
+	Value       []byte `asn1:"optional"`
+}
+
+var benchValue = benchMessage{
+	MessageID: 7,
+	Op: benchSearch{
+		Base:       []byte("dc=example,dc=com"),
+		Scope:      2,
+		Filter:     OptionValue{"tag:3", [][]byte{[]byte("uid"), []byte("jdoe")}},
+		Attributes: [][]byte{[]byte("cn"), []byte("mail")},
+	},
+	Controls: []benchControl{{Type: []byte("1.2.840.113556.1.4.319"), Value: []byte{0x30, 0x00}}},
+}
+
+func BenchmarkEncode(b *testing.B) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Implicit = true
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := enc.Encode(benchValue); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Implicit = true
+	if err := enc.Encode(benchValue); err != nil {
+		b.Fatal(err)
+	}
+	in := buf.Bytes()
+	r := bytes.NewReader(in)
+	dec := NewDecoder(r)
+	dec.Implicit = true
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(in)
+		dec.Reset(r)
+		var out benchMessage
+		out.Op.Filter.Value = &RawValue{}
+		if err := dec.Decode(&out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
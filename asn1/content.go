@@ -0,0 +1,206 @@
+package asn1
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// A ContentMarshaler encodes the contents of the SEQUENCE it is encoded
+// as, appending them to b, so that an Encoder need not walk its fields
+// by reflection. The methods generated by cmd/asn1gen assume IMPLICIT
+// TAGS, as LDAP does, so an Encoder only uses them when Implicit is set.
+type ContentMarshaler interface {
+	MarshalASN1Content(b []byte) ([]byte, error)
+}
+
+// A ContentUnmarshaler decodes the contents of the SEQUENCE it is
+// decoded from, and may keep references to b. A Decoder only uses it
+// when Implicit is set.
+type ContentUnmarshaler interface {
+	UnmarshalASN1Content(b []byte) error
+}
+
+var (
+	contentMarshalerType   = reflect.TypeOf((*ContentMarshaler)(nil)).Elem()
+	contentUnmarshalerType = reflect.TypeOf((*ContentUnmarshaler)(nil)).Elem()
+)
+
+// The functions below are the building blocks of the methods generated
+// by cmd/asn1gen. They encode exactly as an implicit Encoder does.
+
+// AppendHeader appends the identifier and length octets of an element.
+func AppendHeader(b []byte, class, tag int, constructed bool, length int) []byte {
+	ident := uint8(class<<6 + tag)
+	if constructed {
+		ident += 0x20
+	}
+	return appendLength(append(b, ident), length)
+}
+
+func appendLength(b []byte, length int) []byte {
+	bs, _ := encodeInt64(int64(length))
+	if len(bs) > 1 || bs[0]&0x80 == 0x80 {
+		b = append(b, uint8(0x80|len(bs)))
+	}
+	return append(b, bs...)
+}
+
+// InsertHeader inserts the header of a constructed element in front of
+// its contents, b[start:].
+func InsertHeader(b []byte, start, class, tag int) []byte {
+	header := AppendHeader(nil, class, tag, true, len(b)-start)
+	b = append(b, header...)
+	copy(b[start+len(header):], b[start:len(b)-len(header)])
+	copy(b[start:], header)
+	return b
+}
+
+func AppendInteger(b []byte, class, tag int, i int64) []byte {
+	bs, _ := encodeInt64(i)
+	return append(AppendHeader(b, class, tag, false, len(bs)), bs...)
+}
+
+func AppendBoolean(b []byte, class, tag int, v bool) []byte {
+	b = AppendHeader(b, class, tag, false, 1)
+	if v {
+		return append(b, 0xff)
+	}
+	return append(b, 0x00)
+}
+
+func AppendOctetString(b []byte, class, tag int, v []byte) []byte {
+	return append(AppendHeader(b, class, tag, false, len(v)), v...)
+}
+
+// AppendValue appends v as an implicit Encoder encodes it with the
+// field options opts, for the fields generated code cannot handle.
+func AppendValue(b []byte, v interface{}, opts string) ([]byte, error) {
+	if opts != "" {
+		v = OptionValue{Opts: opts, Value: v}
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Implicit = true
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return append(b, buf.Bytes()...), nil
+}
+
+// ParseRawValue splits the first element off b. The Bytes and RawBytes
+// of the element share b's storage.
+func ParseRawValue(b []byte) (raw RawValue, rest []byte, err error) {
+	n, err := parseElement(b, &raw, 0)
+	if err != nil {
+		return RawValue{}, b, err
+	}
+	raw.RawBytes = b[:n:n]
+	return raw, b[n:], nil
+}
+
+// parseElement parses the element at the start of b into raw, returning
+// its length.
+func parseElement(b []byte, raw *RawValue, depth int) (n int, err error) {
+	if len(b) < 2 {
+		return 0, SyntaxError("truncated element")
+	}
+	raw.Class = int(b[0] >> 6)
+	raw.Constructed = b[0]&0x20 == 0x20
+	raw.Tag = int(b[0] & 0x1f)
+	n = 1
+	if raw.Tag == 0x1f {
+		raw.Tag = 0
+		for {
+			if n == len(b) || n > 4 {
+				return 0, SyntaxError("truncated or overlong tag")
+			}
+			raw.Tag = raw.Tag<<7 | int(b[n]&0x7f)
+			n++
+			if b[n-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+	if n == len(b) {
+		return 0, SyntaxError("truncated element")
+	}
+	c := b[n]
+	n++
+	length := 0
+	switch {
+	case c < 0x80:
+		length = int(c)
+	case c == 0x80:
+		if !raw.Constructed || depth == maxNesting {
+			return 0, SyntaxError("invalid indefinite length")
+		}
+		start := n
+		for {
+			var inner RawValue
+			m, err := parseElement(b[n:], &inner, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			if inner.Class == 0 && inner.Tag == 0 {
+				raw.Bytes = b[start:n:n]
+				return n + m, nil
+			}
+			n += m
+		}
+	case c == 0xff:
+		return 0, SyntaxError("long-form length")
+	default:
+		width := int(c & 0x7f)
+		if width > 4 || n+width > len(b) {
+			return 0, SyntaxError("invalid length")
+		}
+		for _, c := range b[n : n+width] {
+			length = length<<8 | int(c)
+		}
+		n += width
+	}
+	if length > len(b)-n {
+		return 0, SyntaxError("truncated element")
+	}
+	raw.Bytes = b[n : n+length : n+length]
+	return n + length, nil
+}
+
+// ParseInteger decodes the contents of an INTEGER or ENUMERATED as a
+// Decoder does, checking that it fits in bitSize bits (0 meaning int).
+func ParseInteger(b []byte, bitSize int) (int64, error) {
+	if len(b) == 0 {
+		return 0, SyntaxError("integer must have at least one byte of content")
+	}
+	if bitSize == 0 {
+		bitSize = 32 << (^uint(0) >> 63)
+	}
+	var i int64
+	for _, b := range b {
+		i = i<<8 + int64(b)
+	}
+	if shift := 64 - uint(bitSize); i<<shift>>shift != i {
+		return 0, StructuralError("integer overflow")
+	}
+	return i, nil
+}
+
+func ParseBoolean(b []byte) (bool, error) {
+	if len(b) != 1 {
+		return false, SyntaxError(fmt.Sprintf("booleans must be only one byte (len = %d)", len(b)))
+	}
+	return b[0] != 0, nil
+}
+
+// UnmarshalValue decodes the element b into v as an implicit Decoder
+// does with the field options opts, for the fields generated code
+// cannot handle.
+func UnmarshalValue(b []byte, v interface{}, opts string) error {
+	if opts != "" {
+		v = OptionValue{Opts: opts, Value: v}
+	}
+	dec := NewDecoder(bytes.NewReader(b))
+	dec.Implicit = true
+	return dec.Decode(v)
+}
@@ -0,0 +1,109 @@
+package asn1
+
+import (
+	"bytes"
+	"testing"
+)
+
+// pair encodes and decodes itself like a struct{ A, B int } would.
+type pair struct {
+	A, B  int
+	calls int
+}
+
+func (p pair) MarshalASN1Content(b []byte) ([]byte, error) {
+	b = AppendInteger(b, ClassUniversal, TagInteger, int64(p.A))
+	return AppendInteger(b, ClassUniversal, TagInteger, int64(p.B)), nil
+}
+
+func (p *pair) UnmarshalASN1Content(b []byte) error {
+	var s struct{ A, B int }
+	if err := UnmarshalValue(append(AppendHeader(nil, ClassUniversal, TagSequence, true, len(b)), b...), &s, ""); err != nil {
+		return err
+	}
+	p.A, p.B, p.calls = s.A, s.B, p.calls+1
+	return nil
+}
+
+func TestContentMarshaler(t *testing.T) {
+	in := struct {
+		P pair `asn1:"tag:1"`
+		N int
+	}{P: pair{A: 1, B: 2}, N: 3}
+	expected := []byte{0x30, 0x0b, 0xa1, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02, 0x02, 0x01, 0x03}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Implicit = true
+	if err := enc.Encode(in); err != nil || !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Bad result: % x, %v (expected % x)", buf.Bytes(), err, expected)
+	}
+
+	out := in
+	out.P, out.N = pair{}, 0
+	dec := NewDecoder(bytes.NewReader(expected))
+	dec.Implicit = true
+	if err := dec.Decode(&out); err != nil || out.P.A != 1 || out.P.B != 2 || out.P.calls != 1 || out.N != 3 {
+		t.Errorf("Bad result: %+v, %v", out, err)
+	}
+}
+
+func TestInsertHeader(t *testing.T) {
+	content := bytes.Repeat([]byte{0x05, 0x00}, 100)
+	b := InsertHeader(append([]byte{0xff}, content...), 1, ClassUniversal, TagSequence)
+	expected := append([]byte{0xff, 0x30, 0x81, 0xc8}, content...)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("Bad result: % x (expected % x)", b, expected)
+	}
+}
+
+func TestParseRawValue(t *testing.T) {
+	tests := []struct {
+		in   []byte
+		ok   bool
+		raw  RawValue
+		rest int
+	}{
+		{[]byte{0x04, 0x01, 'a', 0x05}, true, RawValue{0, 4, false, []byte("a"), []byte{0x04, 0x01, 'a'}}, 1},
+		{[]byte{0x04, 0x81, 0x01, 'a'}, true, RawValue{0, 4, false, []byte("a"), []byte{0x04, 0x81, 0x01, 'a'}}, 0},
+		{[]byte{0x30, 0x80, 0x05, 0x00, 0x00, 0x00}, true,
+			RawValue{0, 16, true, []byte{0x05, 0x00}, []byte{0x30, 0x80, 0x05, 0x00, 0x00, 0x00}}, 0},
+		{[]byte{0x5f, 0x81, 0x01, 0x00}, true, RawValue{1, 129, false, []byte{}, []byte{0x5f, 0x81, 0x01, 0x00}}, 0},
+		{[]byte{0x04, 0x02, 'a'}, false, RawValue{}, 0},
+		{[]byte{0x04, 0x80, 0x00, 0x00}, false, RawValue{}, 0},
+		{[]byte{0x30, 0x80, 0x05, 0x00}, false, RawValue{}, 0},
+		{[]byte{0x04}, false, RawValue{}, 0},
+	}
+	for i, test := range tests {
+		raw, rest, err := ParseRawValue(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Incorrect error result: %v (expected ok %v)", i, err, test.ok)
+			continue
+		}
+		if err == nil && (raw.Class != test.raw.Class || raw.Tag != test.raw.Tag || raw.Constructed != test.raw.Constructed ||
+			!bytes.Equal(raw.Bytes, test.raw.Bytes) || !bytes.Equal(raw.RawBytes, test.raw.RawBytes) || len(rest) != test.rest) {
+			t.Errorf("#%d: Bad result: %+v, % x (expected %+v)", i, raw, rest, test.raw)
+		}
+	}
+}
+
+func TestParseInteger(t *testing.T) {
+	tests := []struct {
+		in      []byte
+		bitSize int
+		ok      bool
+		out     int64
+	}{
+		{[]byte{0x2a}, 0, true, 42},
+		{[]byte{0x7f}, 8, true, 127},
+		{[]byte{0x01, 0x00}, 8, false, 0},
+		{[]byte{0x12, 0x34}, 16, true, 0x1234},
+		{[]byte{}, 0, false, 0},
+	}
+	for i, test := range tests {
+		out, err := ParseInteger(test.in, test.bitSize)
+		if (err == nil) != test.ok || out != test.out {
+			t.Errorf("#%d: Bad result: %v, %v (expected %v)", i, out, err, test.out)
+		}
+	}
+}
@@ -9,12 +9,22 @@ import (
 
 var EOC = fmt.Errorf("End-Of-Content")
 
+// ErrTooLong is returned by a Decoder for an element longer than its
+// MaxLength.
+var ErrTooLong = SyntaxError("element longer than MaxLength")
+
 type Decoder struct {
 	Implicit bool
-	r        io.Reader
-	b        []byte
-	typeb    []byte
-	lenb     []byte
+	// MaxLength, if not zero, limits the length of the content of each
+	// element decoded, which is checked before it is read, so that a
+	// decoder reading from a peer does not allocate what it claims.
+	MaxLength int
+	r         io.Reader
+	b         []byte
+	typeb     []byte
+	lenb      []byte
+	nested    int   // how deep r is within the contents of elements read whole
+	offset    int64 // bytes consumed from the reader given to the decoder
 }
 
 func NewDecoder(r io.Reader) *Decoder {
@@ -27,6 +37,23 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 }
 
+// Reset makes the decoder read from r, discarding anything buffered
+// from its previous reader, so that it can be reused.
+func (dec *Decoder) Reset(r io.Reader) {
+	dec.r = r
+	dec.b = dec.b[:0]
+	dec.offset = 0
+}
+
+// InputOffset returns how many bytes of its input the decoder has
+// consumed since it was created or Reset. The decoder reads no further
+// than the end of each element it decodes, so after Decode it is the
+// offset of whatever follows, such as the next message or trailing
+// garbage.
+func (dec *Decoder) InputOffset() int64 {
+	return dec.offset
+}
+
 func (dec *Decoder) Read(out []byte) (n int, err error) {
 	if len(dec.b) > 0 {
 		n = copy(out, dec.b)
@@ -37,9 +64,20 @@ func (dec *Decoder) Read(out []byte) (n int, err error) {
 		nn, err = dec.r.Read(out[n:])
 		n += nn
 	}
+	if dec.nested == 0 {
+		dec.offset += int64(n)
+	}
 	return
 }
 
+// unread pushes b back, to be read again.
+func (dec *Decoder) unread(b []byte) {
+	dec.b = append(append([]byte{}, b...), dec.b...)
+	if dec.nested == 0 {
+		dec.offset -= int64(len(b))
+	}
+}
+
 func (dec *Decoder) Decode(out interface{}) error {
 	v := reflect.Indirect(reflect.ValueOf(out))
 	return dec.decodeField(v, fieldOptions{})
@@ -62,6 +100,11 @@ func (dec *Decoder) decodeField(v reflect.Value, opts fieldOptions) (err error)
 	}
 
 	v, opts = dereference(v, opts)
+	if opts.err != nil {
+		return opts.err
+	} else if emptyInterface(v) {
+		return dec.decodeInterface(v, class, tag, constructed, opts)
+	}
 
 	if !v.IsValid() {
 		return StructuralError("IsValid = false")
@@ -106,6 +149,66 @@ func (dec *Decoder) decodeRawValue(v reflect.Value, class, tag int, constructed
 	return nil
 }
 
+// emptyInterface reports whether v is a nil interface{}, which is decoded
+// into by decodeInterface.
+func emptyInterface(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0 && v.IsNil()
+}
+
+// decodeInterface decodes an element into an empty interface{} by its
+// universal tag: BOOLEAN as bool, INTEGER and ENUMERATED as int64, OCTET
+// STRING as []byte, NULL as nil, and SEQUENCE and SET as []interface{}
+// of their elements. Anything else, including tagged elements, is
+// decoded as a RawValue.
+func (dec *Decoder) decodeInterface(v reflect.Value, class, tag int, constructed bool, opts fieldOptions) (err error) {
+	if !v.CanSet() {
+		return StructuralError("CanSet = false")
+	}
+	if opts.tag != nil {
+		if err = dec.checkTag(class, tag, constructed, opts, v); err != nil {
+			return
+		}
+	}
+
+	var out reflect.Value
+	if class == ClassUniversal && opts.tag == nil {
+		switch {
+		case constructed && (tag == TagSequence || tag == TagSet):
+			out = reflect.New(interfaceSliceType).Elem()
+		case constructed:
+		case tag == TagBoolean:
+			out = reflect.New(boolType).Elem()
+		case tag == TagInteger, tag == TagEnumerated:
+			out = reflect.New(int64Type).Elem()
+		case tag == TagOctetString:
+			out = reflect.New(byteSliceType).Elem()
+		case tag == TagNull:
+			b, _, err := dec.decodeLengthAndContent()
+			if err != nil {
+				return err
+			} else if len(b) != 0 {
+				return SyntaxError(fmt.Sprintf("NULL with non-zero length %d", len(b)))
+			}
+			return nil
+		}
+	}
+
+	switch {
+	case !out.IsValid():
+		out = reflect.New(rawValueType).Elem()
+		err = dec.decodeRawValue(out, class, tag, constructed)
+	case constructed:
+		err = dec.decodeConstructed(out, fieldOptions{})
+	default:
+		err = dec.decodePrimitive(out)
+	}
+	if err != nil {
+		return
+	}
+	v.Set(out)
+	return
+}
+
 func (dec *Decoder) decodeConstructed(v reflect.Value, opts fieldOptions) (err error) {
 	length, indefinite, err := dec.decodeLength()
 	if err != nil {
@@ -117,11 +220,16 @@ func (dec *Decoder) decodeConstructed(v reflect.Value, opts fieldOptions) (err e
 		if err != nil {
 			return err
 		}
+		if u, ok := dec.unmarshaler(v); ok {
+			return u.UnmarshalASN1Content(b)
+		}
 		defer func(r io.Reader) {
 			dec.r = r
+			dec.nested--
 		}(dec.r)
 		b = append(b, 0x00, 0x00)
 		dec.r = bytes.NewReader(b)
+		dec.nested++
 	}
 
 	if opts.tag != nil && (opts.implicit == nil || !*opts.implicit) && !dec.Implicit {
@@ -145,6 +253,17 @@ func (dec *Decoder) decodeConstructed(v reflect.Value, opts fieldOptions) (err e
 	return StructuralError(fmt.Sprintf("Unsupported Type: %v", v.Type()))
 }
 
+// unmarshaler returns v's ContentUnmarshaler, if it has one that the
+// decoder can use.
+func (dec *Decoder) unmarshaler(v reflect.Value) (ContentUnmarshaler, bool) {
+	if !dec.Implicit || v.Kind() != reflect.Struct || !v.CanAddr() || !v.CanInterface() ||
+		!reflect.PtrTo(v.Type()).Implements(contentUnmarshalerType) {
+		return nil, false
+	}
+	u, ok := v.Addr().Interface().(ContentUnmarshaler)
+	return u, ok
+}
+
 func (dec *Decoder) decodeSequenceSlice(v reflect.Value) (err error) {
 	t := v.Type().Elem()
 	v.Set(reflect.MakeSlice(v.Type(), 0, 0))
@@ -163,28 +282,31 @@ func (dec *Decoder) decodeSequenceSlice(v reflect.Value) (err error) {
 }
 
 func (dec *Decoder) decodeSequenceStruct(v reflect.Value) (err error) {
-	max := v.NumField()
-	for i := 0; i < max; i++ {
-		vv := v.Field(i)
-		vt := v.Type().Field(i)
-		opts := parseFieldOptions(vt.Tag.Get("asn1"))
-		vv, opts = dereference(vv, opts)
+	for i, opts := range structOptions(v.Type()) {
+		vv, opts := dereference(v.Field(i), opts)
 		if opts.components && vv.Kind() == reflect.Struct {
 			err = dec.decodeSequenceStruct(vv)
 		} else {
 			err = dec.decodeField(vv, opts)
 		}
 		if err != nil {
-			if !opts.optional {
+			if !opts.optional && opts.def == nil {
 				return
 			}
+			// Push back what was read so that the next field sees it.
 			if err == EOC {
-				dec.b = append(dec.b, 0x00, 0x00)
+				dec.unread([]byte{0x00, 0x00})
 			} else {
-				n := copy(dec.b[:cap(dec.b)], dec.typeb)
-				dec.b = dec.b[:n]
+				dec.unread(dec.typeb)
 			}
 			err = nil
+			if opts.def != nil {
+				def, err := defaultValue(vv.Type(), *opts.def)
+				if err != nil {
+					return err
+				}
+				vv.Set(def)
+			}
 		}
 	}
 	return
@@ -279,6 +401,9 @@ func (dec *Decoder) decodeContent(length int, indefinite bool) (b []byte, err er
 				b = b[:len(b)-2]
 				break
 			}
+			if dec.MaxLength > 0 && len(b) > dec.MaxLength+2 {
+				return nil, ErrTooLong
+			}
 			if len(b) == cap(b) {
 				bb := make([]byte, len(b), 2*len(b))
 				copy(bb, b)
@@ -301,7 +426,8 @@ func (dec *Decoder) decodeContent(length int, indefinite bool) (b []byte, err er
 }
 
 func (dec *Decoder) decodeLength() (length int, isIndefinite bool, err error) {
-	_, err = dec.Read(dec.lenb[0:1])
+	dec.lenb = dec.lenb[:1]
+	_, err = dec.Read(dec.lenb)
 	if err != nil {
 		return
 	}
@@ -315,6 +441,10 @@ func (dec *Decoder) decodeLength() (length int, isIndefinite bool, err error) {
 		return
 	} else {
 		width := c & 0x7f
+		if width > 8 {
+			err = SyntaxError(fmt.Sprintf("length of %d bytes", width))
+			return
+		}
 		dec.lenb = dec.lenb[:1+width]
 		_, err = io.ReadFull(dec, dec.lenb[1:1+width])
 		if err != nil {
@@ -323,6 +453,13 @@ func (dec *Decoder) decodeLength() (length int, isIndefinite bool, err error) {
 		for _, b := range dec.lenb[1 : 1+width] {
 			length = length<<8 | int(b)
 		}
+		if length < 0 {
+			err = SyntaxError("length overflows int")
+			return
+		}
+	}
+	if dec.MaxLength > 0 && length > dec.MaxLength {
+		err = ErrTooLong
 	}
 	return
 }
@@ -333,7 +470,7 @@ func (dec *Decoder) checkTag(class, tag int, constructed bool, opts fieldOptions
 	if opts.tag != nil {
 		ok = tag == *opts.tag &&
 			((opts.implicit != nil && *opts.implicit) || dec.Implicit || constructed) &&
-			((opts.application && class == ClassApplication) || class == ClassContextSpecific)
+			(class == opts.class || class == ClassContextSpecific)
 	} else if class == ClassUniversal {
 		switch tag {
 		case TagBoolean:
@@ -99,10 +99,39 @@ func TestDecodeLength(t *testing.T) {
 		{[]byte{}, false, tlvLength{}},
 		{[]byte{0x83, 0x01, 0x00}, false, tlvLength{}},
 		{[]byte{0xff}, false, tlvLength{}},
+		{[]byte{0x89, 1, 0, 0, 0, 0, 0, 0, 0, 0}, false, tlvLength{}},
+		{[]byte{0x88, 0x80, 0, 0, 0, 0, 0, 0, 0}, false, tlvLength{}},
 	}
 	runDecoderTests(t, tests, fn)
 }
 
+func TestDecoderMaxLength(t *testing.T) {
+	var (
+		value []byte
+		raw   RawValue
+		seq   struct{ Value []byte }
+	)
+	tests := []struct {
+		in  []byte
+		out interface{}
+		err error
+	}{
+		{[]byte{0x04, 0x04, 'a', 'b', 'c', 'd'}, &value, nil},
+		{[]byte{0x04, 0x05, 'a', 'b', 'c', 'd', 'e'}, &value, ErrTooLong},
+		{[]byte{0x04, 0x84, 0x7f, 0xff, 0xff, 0xff}, &value, ErrTooLong},
+		{[]byte{0x30, 0x80, 0x04, 0x01, 'a', 0x04, 0x01, 'b', 0x00, 0x00}, &raw, ErrTooLong},
+		// The content of a sequence claims more than it holds.
+		{[]byte{0x30, 0x04, 0x04, 0x82, 0x10, 0x00}, &seq, ErrTooLong},
+	}
+	for i, test := range tests {
+		dec := NewDecoder(bytes.NewReader(test.in))
+		dec.MaxLength = 4
+		if err := dec.Decode(test.out); err != test.err {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, err, test.err)
+		}
+	}
+}
+
 func TestDecodeRawValue(t *testing.T) {
 	tests := []decoderTest{
 		{[]byte{0x05, 0x00}, true, RawValue{0, 5, false, []byte{}, []byte{0x05, 0x00}}},
@@ -117,6 +146,40 @@ func TestDecodeRawValue(t *testing.T) {
 	runDecoderTests(t, tests, withValue(&out))
 }
 
+func TestDecodeRawValueAfterLongFormLength(t *testing.T) {
+	in := []byte{0x04, 0x81, 0x01, 'a', 0x04, 0x01, 'b'}
+	dec := NewDecoder(bytes.NewReader(in))
+	var raw RawValue
+	for i, expected := range [][]byte{in[:4], in[4:]} {
+		if err := dec.Decode(&raw); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !bytes.Equal(raw.RawBytes, expected) {
+			t.Errorf("#%d: Bad result: % x (expected % x)", i, raw.RawBytes, expected)
+		}
+	}
+}
+
+// Each skipped optional field pushes its tag back, which used to be lost
+// once reads had used up the capacity of the pushback buffer.
+func TestDecodeOptionalFieldsInStream(t *testing.T) {
+	type item struct {
+		Flag  bool   `asn1:"optional"`
+		Value []byte `asn1:"optional"`
+	}
+	in := bytes.Repeat([]byte{0x30, 0x03, 0x04, 0x01, 'x'}, 20)
+	dec := NewDecoder(bytes.NewReader(in))
+	for i := 0; i < 20; i++ {
+		var out item
+		if err := dec.Decode(&out); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if string(out.Value) != "x" {
+			t.Errorf("#%d: Bad result: %q (expected \"x\")", i, out.Value)
+		}
+	}
+}
+
 func TestDecodeBool(t *testing.T) {
 	tests := []decoderTest{
 		{[]byte{0x01, 0x01, 0x00}, true, false},
@@ -293,6 +356,10 @@ func TestDecodeTagging(t *testing.T) {
 		{[]byte{0xA3, 0x03, 0x01, 0x01, 0x00}, true, false},
 		{[]byte{0xA4, 0x03, 0x01, 0x01, 0x01}, true, true},
 		{[]byte{0x85, 0x01, 0x00}, false, false},
+		{[]byte{0x61, 0x03, 0x01, 0x01, 0x01}, true, true},
+		{[]byte{0xc6, 0x01, 0x01}, true, true},
+		{[]byte{0x86, 0x01, 0x00}, true, false},
+		{[]byte{0x81, 0x01, 0x00}, false, false},
 	}
 	opts := map[int]string{
 		1: "tag:1,implicit",
@@ -301,6 +368,9 @@ func TestDecodeTagging(t *testing.T) {
 		4: "tag:4",
 		5: "tag:5",
 		6: "tag:1,application",
+		7: "tag:6,class:private,implicit",
+		8: "tag:6,class:private,implicit",
+		9: "tag:1,optional,default:true",
 	}
 	var out bool
 	runDecoderTests(t, tests, withValueOptions(&out, opts))
@@ -367,6 +437,16 @@ func TestDecodeStructOptionStruct(t *testing.T) {
 	}
 }
 
+func TestDecodeDefault(t *testing.T) {
+	tests := []decoderTest{
+		{[]byte{0x30, 0x00}, true, versioned{3, []byte("x"), true}},
+		{[]byte{0x30, 0x03, 0x80, 0x01, 'y'}, true, versioned{3, []byte("y"), true}},
+		{[]byte{0x30, 0x09, 0x02, 0x01, 0x02, 0x80, 0x01, 'y', 0x01, 0x01, 0x00}, true, versioned{2, []byte("y"), false}},
+	}
+	var out versioned
+	runDecoderTests(t, tests, withValue(&out))
+}
+
 func TestDecodeSet(t *testing.T) {
 	tests := []decoderTest{
 		{[]byte{0x31, 0x00}, true, []int{}},
@@ -390,3 +470,96 @@ func TestDecodeComponentsOf(t *testing.T) {
 	var out line
 	runDecoderTests(t, tests, withValue(&out))
 }
+
+func TestDecoderReset(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader([]byte{0x02, 0x01, 0x01, 0x02, 0x01, 0x02}))
+	var n int
+	if err := dec.Decode(&n); err != nil {
+		t.Fatal(err)
+	}
+	dec.Reset(bytes.NewReader([]byte{0x01, 0x01, 0xff}))
+	var b bool
+	if err := dec.Decode(&b); err != nil || !b {
+		t.Errorf("Bad result: %v, %v (expected true)", b, err)
+	}
+}
+
+func TestDecoderInputOffset(t *testing.T) {
+	type item struct {
+		Flag  bool   `asn1:"optional"`
+		Value []byte `asn1:"optional"`
+	}
+	tests := []struct {
+		in      []byte
+		out     interface{}
+		offsets []int64
+	}{
+		{[]byte{0x02, 0x01, 0x07, 0x02, 0x02, 0x01, 0x00}, new(int), []int64{3, 7}},
+		{[]byte{0x30, 0x03, 0x04, 0x01, 'x', 0xde, 0xad}, new(item), []int64{5}},
+		{[]byte{0x30, 0x80, 0x04, 0x01, 'x', 0x00, 0x00, 0x01, 0x01, 0xff}, new(item), []int64{7}},
+	}
+	for i, test := range tests {
+		dec := NewDecoder(bytes.NewReader(test.in))
+		for j, offset := range test.offsets {
+			if err := dec.Decode(test.out); err != nil {
+				t.Fatalf("#%d.%d: %v", i, j, err)
+			}
+			if n := dec.InputOffset(); n != offset {
+				t.Errorf("#%d.%d: Bad result: %d (expected %d)", i, j, n, offset)
+			}
+		}
+	}
+
+	dec := NewDecoder(bytes.NewReader([]byte{0x02, 0x01, 0x07}))
+	var n int
+	dec.Decode(&n)
+	dec.Reset(bytes.NewReader([]byte{0x01, 0x01, 0xff}))
+	if dec.InputOffset() != 0 {
+		t.Errorf("Bad result after Reset: %d", dec.InputOffset())
+	}
+}
+
+func TestDecodeInterface(t *testing.T) {
+	tests := []decoderTest{
+		{[]byte{0x01, 0x01, 0xff}, true, true},
+		{[]byte{0x02, 0x02, 0x01, 0x00}, true, int64(256)},
+		{[]byte{0x0a, 0x01, 0x02}, true, int64(2)},
+		{[]byte{0x04, 0x03, 'f', 'o', 'o'}, true, []byte("foo")},
+		{[]byte{0x05, 0x00}, true, nil},
+		{[]byte{0x05, 0x01, 0x00}, false, nil},
+		{[]byte{0x30, 0x00}, true, []interface{}{}},
+		{[]byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x05, 0x00, 0x00, 0x00}, true, []interface{}{int64(1), nil}},
+		{[]byte{0x31, 0x08, 0x01, 0x01, 0x00, 0x30, 0x03, 0x04, 0x01, 'x'}, true,
+			[]interface{}{false, []interface{}{[]byte("x")}}},
+		{[]byte{0x80, 0x01, 0x07}, true,
+			RawValue{Class: ClassContextSpecific, Tag: 0, Bytes: []byte{0x07}, RawBytes: []byte{0x80, 0x01, 0x07}}},
+		{[]byte{0x06, 0x01, 0x2a}, true,
+			RawValue{Class: ClassUniversal, Tag: 6, Bytes: []byte{0x2a}, RawBytes: []byte{0x06, 0x01, 0x2a}}},
+		{[]byte{0x30, 0x03, 0x02, 0x01}, false, nil},
+	}
+	runDecoderTests(t, tests, withDecoder(func(i int, dec *Decoder) (interface{}, error) {
+		var out interface{}
+		err := dec.Decode(&out)
+		return out, err
+	}))
+}
+
+func TestDecodeInterfaceStructFields(t *testing.T) {
+	type message struct {
+		ID    int
+		Op    interface{}
+		Extra interface{} `asn1:"optional,tag:0"`
+	}
+	tests := []decoderTest{
+		{[]byte{0x30, 0x0b, 0x02, 0x01, 0x01, 0x30, 0x06, 0x04, 0x01, 'a', 0x01, 0x01, 0xff}, true,
+			message{1, []interface{}{[]byte("a"), true}, nil}},
+		{[]byte{0x30, 0x0a, 0x02, 0x01, 0x02, 0x04, 0x01, 'b', 0xa0, 0x02, 0x05, 0x00}, true,
+			message{2, []byte("b"), RawValue{Class: ClassContextSpecific, Tag: 0, Constructed: true,
+				Bytes: []byte{0x05, 0x00}, RawBytes: []byte{0xa0, 0x02, 0x05, 0x00}}}},
+	}
+	runDecoderTests(t, tests, withDecoder(func(i int, dec *Decoder) (interface{}, error) {
+		var out message
+		err := dec.Decode(&out)
+		return out, err
+	}))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
 )
 
 type Encoder struct {
@@ -24,6 +25,14 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// Reset makes the encoder write to w, discarding any partly encoded
+// value, so that it can be reused.
+func (enc *Encoder) Reset(w io.Writer) {
+	enc.b.Reset()
+	enc.w = enc.b
+	enc.ww = w
+}
+
 func (enc *Encoder) Encode(in interface{}) (err error) {
 	v := reflect.Indirect(reflect.ValueOf(in))
 	if err = enc.encodeField(v, fieldOptions{}); err != nil {
@@ -34,11 +43,23 @@ func (enc *Encoder) Encode(in interface{}) (err error) {
 }
 
 func (enc *Encoder) encodeField(v reflect.Value, opts fieldOptions) (err error) {
+	v = explicitChoice(v)
 	v, opts = dereference(v, opts)
+	if opts.err != nil {
+		return opts.err
+	}
 
 	if opts.optional && reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface()) {
 		return
 	}
+	if opts.def != nil {
+		def, err := defaultValue(v.Type(), *opts.def)
+		if err != nil {
+			return err
+		} else if reflect.DeepEqual(v.Interface(), def.Interface()) {
+			return nil
+		}
+	}
 
 	if opts.tag != nil && !(opts.implicit != nil && *opts.implicit) && !enc.Implicit {
 		v = reflect.ValueOf([]interface{}{v.Interface()})
@@ -109,12 +130,7 @@ func (enc *Encoder) encodeType(v reflect.Value, opts fieldOptions) (err error) {
 
 	if opts.tag != nil {
 		tag = *opts.tag
-
-		if opts.application {
-			class = ClassApplication
-		} else {
-			class = ClassContextSpecific
-		}
+		class = opts.class
 	}
 
 	ident := uint8(class<<6 + tag)
@@ -126,19 +142,7 @@ func (enc *Encoder) encodeType(v reflect.Value, opts fieldOptions) (err error) {
 }
 
 func (enc *Encoder) encodeLength(length int) (err error) {
-	var bs []byte
-
-	if bs, err = encodeInt64(int64(length)); err != nil {
-		return err
-	}
-
-	if len(bs) > 1 || bs[0]&0x80 == 0x80 {
-		if _, err = enc.w.Write([]byte{uint8(0x80 | len(bs))}); err != nil {
-			return err
-		}
-	}
-
-	_, err = enc.w.Write(bs)
+	_, err = enc.w.Write(appendLength(nil, length))
 	return err
 }
 
@@ -177,14 +181,19 @@ func (enc *Encoder) encodeContent(v reflect.Value) (buf bytes.Buffer, err error)
 				}
 			}
 		case reflect.Struct:
+			if enc.Implicit && t.Implements(contentMarshalerType) && v.CanInterface() {
+				var b []byte
+				if b, err = v.Interface().(ContentMarshaler).MarshalASN1Content(nil); err == nil {
+					buf.Write(b)
+				}
+				return
+			}
 			defer func(w io.Writer) {
 				enc.w = w
 			}(enc.w)
 			enc.w = &buf
-			for i := 0; i < v.NumField(); i++ {
-				vv := v.Field(i)
-				opts := parseFieldOptions(v.Type().Field(i).Tag.Get("asn1"))
-				if err = enc.encodeField(vv, opts); err != nil {
+			for i, opts := range structOptions(t) {
+				if err = enc.encodeField(v.Field(i), opts); err != nil {
 					return
 				}
 			}
@@ -210,3 +219,26 @@ func encodeInt64(i int64) ([]byte, error) {
 	}
 	return bs, nil
 }
+
+// explicitChoice rewrites a tagged OptionValue that holds another
+// OptionValue, as a tagged CHOICE does, so that the inner value keeps
+// its own tag inside the outer one instead of losing it.
+func explicitChoice(v reflect.Value) reflect.Value {
+	for k := v.Kind(); (k == reflect.Ptr || k == reflect.Interface) && !v.IsNil(); k = v.Kind() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Type() != optionValueType {
+		return v
+	}
+	ov := v.Interface().(OptionValue)
+	if _, nested := ov.Value.(OptionValue); !nested || cachedFieldOptions(ov.Opts).tag == nil {
+		return v
+	}
+	opts := []string{"implicit"}
+	for _, part := range strings.Split(ov.Opts, ",") {
+		if part != "implicit" && part != "explicit" {
+			opts = append(opts, part)
+		}
+	}
+	return reflect.ValueOf(OptionValue{Opts: strings.Join(opts, ","), Value: []interface{}{ov.Value}})
+}
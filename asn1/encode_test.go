@@ -106,6 +106,25 @@ func TestEncodeTags(t *testing.T) {
 		{OptionValue{"tag:2,implicit,application", true}, true, []byte{0x42, 0x01, 0xff}},
 		{OptionValue{"tag:3,explicit", true}, true, []byte{0xa3, 0x03, 0x01, 0x01, 0xff}},
 		{OptionValue{"tag:4", true}, true, []byte{0xa4, 0x03, 0x01, 0x01, 0xff}},
+		{OptionValue{"tag:5", OptionValue{"tag:1,implicit", true}}, true, []byte{0xa5, 0x03, 0x81, 0x01, 0xff}},
+		{OptionValue{"tag:6,class:private,implicit", true}, true, []byte{0xc6, 0x01, 0xff}},
+		{OptionValue{"tag:5,explicit", OptionValue{"tag:1,implicit", true}}, true, []byte{0xa5, 0x03, 0x81, 0x01, 0xff}},
+		{OptionValue{"tag:1,implicit,explicit", true}, false, nil},
+	}
+	runEncoderTests(t, tests)
+}
+
+type versioned struct {
+	Version int    `asn1:"default:3"`
+	Name    []byte `asn1:"tag:0,implicit,default:x"`
+	Flag    bool   `asn1:"default:true"`
+}
+
+func TestEncodeDefault(t *testing.T) {
+	tests := []encoderTest{
+		{versioned{3, []byte("x"), true}, true, []byte{0x30, 0x00}},
+		{versioned{2, []byte("y"), false}, true, []byte{0x30, 0x09, 0x02, 0x01, 0x02, 0x80, 0x01, 'y', 0x01, 0x01, 0x00}},
+		{OptionValue{"default:x", 1}, false, nil},
 	}
 	runEncoderTests(t, tests)
 }
@@ -173,3 +192,18 @@ func TestEncodeLongLength(t *testing.T) {
 		t.Errorf("Bad result: %v (expected %v)", actual, expected)
 	}
 }
+
+func TestEncoderReset(t *testing.T) {
+	var first, second bytes.Buffer
+	enc := NewEncoder(&first)
+	if err := enc.Encode(struct{ A func() }{}); err == nil {
+		t.Fatal("Expected error for an unencodable value")
+	}
+	enc.Reset(&second)
+	if err := enc.Encode(true); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x01, 0x01, 0xff}; first.Len() != 0 || !bytes.Equal(second.Bytes(), expected) {
+		t.Errorf("Bad result: % x, % x (expected nothing, % x)", first.Bytes(), second.Bytes(), expected)
+	}
+}
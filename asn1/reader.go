@@ -0,0 +1,234 @@
+package asn1
+
+import (
+	"fmt"
+	"io"
+)
+
+// maxNesting bounds the nesting of indefinite-length elements that a
+// MessageReader follows to find the end of a message.
+const maxNesting = 64
+
+// ErrMessageTooLarge is returned by a MessageReader for a message larger
+// than its MaxSize.
+var ErrMessageTooLarge = StructuralError("message larger than MaxSize")
+
+// A MessageReader splits a stream, such as a TCP connection, into whole
+// BER elements. It reads no further than the end of each message, so
+// the stream can be handed over (to TLS, say) between messages.
+type MessageReader struct {
+	// MaxSize, if positive, is the largest message that ReadMessage
+	// accepts; it returns ErrMessageTooLarge for larger ones before
+	// reading their contents.
+	MaxSize int
+
+	// Spill, if set, is offered each primitive element whose content is
+	// longer than SpillSize, as soon as its header has been read. path
+	// holds the element and those enclosing it, outermost first, with
+	// their contents as read so far. If Spill returns a writer, the
+	// content is copied to it instead of into the message, where the
+	// element is replaced by elem and the lengths of the elements
+	// enclosing it are adjusted to match. Spilled content does not count
+	// towards MaxSize. path shares the message's storage, so it is only
+	// valid during the call.
+	Spill     func(path []RawValue, length int) (w io.Writer, elem []byte)
+	SpillSize int
+
+	r        io.Reader
+	open     []openElement
+	consumed int // bytes read from r
+}
+
+// An openElement is an element of the message being read that encloses
+// the current one.
+type openElement struct {
+	start, content int
+}
+
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{r: r}
+}
+
+// ReadMessage returns the next element of the stream, with its tag and
+// length, once all of it has arrived. It returns io.EOF if the stream
+// ends between messages, and io.ErrUnexpectedEOF if it ends within one.
+func (mr *MessageReader) ReadMessage() ([]byte, error) {
+	mr.open = mr.open[:0]
+	b, err := mr.readElement(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readElement appends the next element to b.
+func (mr *MessageReader) readElement(b []byte, depth int) ([]byte, error) {
+	start := len(b)
+	b, err := mr.read(b, 1)
+	if err != nil {
+		return b, err
+	}
+	constructed := b[start]&0x20 == 0x20
+	if b[start]&0x1f == 0x1f {
+		for {
+			if b, err = mr.read(b, 1); err != nil {
+				return b, err
+			}
+			if b[len(b)-1]&0x80 == 0 {
+				break
+			}
+			if len(b)-start > 5 {
+				return b, SyntaxError("tag too long")
+			}
+		}
+	}
+
+	if b, err = mr.read(b, 1); err != nil {
+		return b, err
+	}
+	length := 0
+	switch c := b[len(b)-1]; {
+	case c < 0x80:
+		length = int(c)
+	case c == 0x80:
+		if !constructed {
+			return b, SyntaxError("indefinite length of primitive element")
+		}
+		if depth == maxNesting {
+			return b, StructuralError("elements nested too deeply")
+		}
+		mr.open = append(mr.open, openElement{start, len(b)})
+		defer func() { mr.open = mr.open[:len(mr.open)-1] }()
+		for {
+			n := len(b)
+			if b, err = mr.readElement(b, depth+1); err != nil {
+				return b, err
+			}
+			if b[n] == 0x00 {
+				if len(b) != n+2 {
+					return b, SyntaxError(fmt.Sprintf("End-Of-Content tag with non-zero length byte %#x", b[n+1]))
+				}
+				return b, nil
+			}
+		}
+	case c == 0xff:
+		return b, SyntaxError("long-form length")
+	default:
+		width := int(c & 0x7f)
+		if width > 4 {
+			return b, SyntaxError("length too large")
+		}
+		if b, err = mr.read(b, width); err != nil {
+			return b, err
+		}
+		for _, c := range b[len(b)-width:] {
+			length = length<<8 | int(c)
+		}
+	}
+	if mr.Spill != nil && length > mr.SpillSize {
+		switch {
+		case constructed && depth < maxNesting:
+			return mr.readContents(b, start, length, depth)
+		case !constructed:
+			if w, elem := mr.Spill(mr.path(b, start), length); w != nil {
+				n, err := io.CopyN(w, mr.r, int64(length))
+				mr.consumed += int(n)
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return append(b[:start], elem...), err
+			}
+		}
+	}
+	return mr.read(b, length)
+}
+
+// readContents reads the length bytes of contents of the constructed
+// element at b[start:] one element at a time, so that those within may
+// be spilled, and then rewrites its length to match what was kept.
+func (mr *MessageReader) readContents(b []byte, start, length, depth int) ([]byte, error) {
+	content := len(b)
+	mr.open = append(mr.open, openElement{start, content})
+	defer func() { mr.open = mr.open[:len(mr.open)-1] }()
+	end := mr.consumed + length
+	for mr.consumed < end {
+		var err error
+		if b, err = mr.readElement(b, depth+1); err != nil {
+			return b, err
+		}
+	}
+	if mr.consumed != end {
+		return b, SyntaxError("element overruns the element enclosing it")
+	}
+	if len(b)-content == length {
+		return b, nil
+	}
+	header := appendLength(append([]byte(nil), b[start:tagEnd(b, start)]...), len(b)-content)
+	return append(b[:start], append(header, b[content:]...)...), nil
+}
+
+// read appends the next n bytes of the stream to b, growing b as the
+// bytes arrive rather than trusting a length that may be bogus.
+func (mr *MessageReader) read(b []byte, n int) ([]byte, error) {
+	if mr.MaxSize > 0 && len(b)+n > mr.MaxSize {
+		return b, ErrMessageTooLarge
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > 64*1024 {
+			chunk = 64 * 1024
+		}
+		start := len(b)
+		if cap(b)-start < chunk {
+			bb := make([]byte, start, 2*cap(b)+chunk)
+			copy(bb, b)
+			b = bb
+		}
+		b = b[:start+chunk]
+		m, err := io.ReadFull(mr.r, b[start:])
+		mr.consumed += m
+		if err != nil {
+			if err == io.EOF && start > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return b[:start], err
+		}
+		n -= chunk
+	}
+	return b, nil
+}
+
+// tagEnd returns the end of the identifier octets of the element at
+// b[start:].
+func tagEnd(b []byte, start int) int {
+	n := start + 1
+	if b[start]&0x1f == 0x1f {
+		for b[n]&0x80 == 0x80 {
+			n++
+		}
+		n++
+	}
+	return n
+}
+
+// path describes the element at b[start:], whose header has been read,
+// and those enclosing it for Spill.
+func (mr *MessageReader) path(b []byte, start int) []RawValue {
+	open := append(mr.open, openElement{start, len(b)})
+	path := make([]RawValue, len(open))
+	for i, e := range open {
+		raw := &path[i]
+		raw.Class = int(b[e.start] >> 6)
+		raw.Constructed = b[e.start]&0x20 == 0x20
+		raw.Tag = int(b[e.start] & 0x1f)
+		if raw.Tag == 0x1f {
+			raw.Tag = 0
+			for _, c := range b[e.start+1 : tagEnd(b, e.start)] {
+				raw.Tag = raw.Tag<<7 | int(c&0x7f)
+			}
+		}
+		raw.Bytes = b[e.content:len(b):len(b)]
+		raw.RawBytes = b[e.start:len(b):len(b)]
+	}
+	return path
+}
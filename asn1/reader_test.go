@@ -0,0 +1,97 @@
+package asn1
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMessageReader(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		out []byte
+		err error
+	}{
+		{[]byte{0x30, 0x03, 0x02, 0x01, 0x07, 0x04}, []byte{0x30, 0x03, 0x02, 0x01, 0x07}, nil},
+		{[]byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x07}, []byte{0x30, 0x81, 0x03, 0x02, 0x01, 0x07}, nil},
+		{[]byte{0x30, 0x80, 0x02, 0x01, 0x07, 0x30, 0x80, 0x00, 0x00, 0x00, 0x00, 0x04},
+			[]byte{0x30, 0x80, 0x02, 0x01, 0x07, 0x30, 0x80, 0x00, 0x00, 0x00, 0x00}, nil},
+		{[]byte{0x5f, 0x81, 0x01, 0x01, 0xff}, []byte{0x5f, 0x81, 0x01, 0x01, 0xff}, nil},
+		{[]byte{}, nil, io.EOF},
+		{[]byte{0x30, 0x03, 0x02, 0x01}, nil, io.ErrUnexpectedEOF},
+		{[]byte{0x30, 0x80, 0x02, 0x01, 0x07}, nil, io.ErrUnexpectedEOF},
+		{[]byte{0x04, 0x80, 0x00, 0x00}, nil, SyntaxError("indefinite length of primitive element")},
+		{[]byte{0x30, 0x80, 0x00, 0x01, 0x00}, nil, SyntaxError("End-Of-Content tag with non-zero length byte 0x1")},
+		{[]byte{0x30, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, nil, SyntaxError("length too large")},
+	}
+	for i, test := range tests {
+		mr := NewMessageReader(iotest.OneByteReader(bytes.NewReader(test.in)))
+		out, err := mr.ReadMessage()
+		if err != test.err || !bytes.Equal(out, test.out) {
+			t.Errorf("#%d: Bad result: %x, %v (expected %x, %v)", i, out, err, test.out, test.err)
+		}
+	}
+}
+
+func TestMessageReaderStream(t *testing.T) {
+	in := []byte{0x30, 0x03, 0x02, 0x01, 0x01, 0x30, 0x03, 0x02, 0x01, 0x02}
+	mr := NewMessageReader(bytes.NewReader(in))
+	for i, expected := range [][]byte{in[:5], in[5:]} {
+		out, err := mr.ReadMessage()
+		if err != nil || !bytes.Equal(out, expected) {
+			t.Errorf("#%d: Bad result: %x, %v (expected %x)", i, out, err, expected)
+		}
+	}
+	if _, err := mr.ReadMessage(); err != io.EOF {
+		t.Errorf("Bad result: %v (expected %v)", err, io.EOF)
+	}
+
+	mr = NewMessageReader(bytes.NewReader([]byte{0x04, 0x82, 0x01, 0x00}))
+	mr.MaxSize = 100
+	if _, err := mr.ReadMessage(); err != ErrMessageTooLarge {
+		t.Errorf("Bad result: %v (expected %v)", err, ErrMessageTooLarge)
+	}
+}
+
+func TestMessageReaderSpill(t *testing.T) {
+	big := bytes.Repeat([]byte{'x'}, 300)
+	msg := append([]byte{0x30, 0x82, 0x01, 0x36, 0x02, 0x01, 0x07, 0x04, 0x82, 0x01, 0x2c}, big...)
+	msg = append(msg, 0x04, 0x01, 'y')
+	var spilled bytes.Buffer
+	pathOK := false
+	mr := NewMessageReader(iotest.OneByteReader(bytes.NewReader(msg)))
+	mr.SpillSize = 100
+	mr.Spill = func(p []RawValue, length int) (io.Writer, []byte) {
+		pathOK = len(p) == 2 && p[0].Tag == 16 && p[0].Constructed && bytes.HasPrefix(p[0].Bytes, []byte{0x02, 0x01, 0x07, 0x04}) && p[1].Tag == 4 && length == len(big)
+		return &spilled, []byte{0x80, 0x00}
+	}
+	out, err := mr.ReadMessage()
+	expected := []byte{0x30, 0x08, 0x02, 0x01, 0x07, 0x80, 0x00, 0x04, 0x01, 'y'}
+	if err != nil || !bytes.Equal(out, expected) {
+		t.Errorf("Bad result: %x, %v (expected %x)", out, err, expected)
+	}
+	if !bytes.Equal(spilled.Bytes(), big) {
+		t.Errorf("Bad spilled content: %d bytes", spilled.Len())
+	}
+	if !pathOK {
+		t.Errorf("Bad path")
+	}
+
+	// Elements that are not spilled are kept as they are.
+	mr = NewMessageReader(bytes.NewReader(msg))
+	mr.SpillSize = 100
+	mr.Spill = func([]RawValue, int) (io.Writer, []byte) { return nil, nil }
+	if out, err := mr.ReadMessage(); err != nil || !bytes.Equal(out, msg) {
+		t.Errorf("Bad result: %x, %v (expected %x)", out, err, msg)
+	}
+
+	// A length that overruns the enclosing element is an error.
+	bad := append([]byte{0x30, 0x81, 0x90, 0x04, 0x81, 0x91}, bytes.Repeat([]byte{'x'}, 0x91)...)
+	mr = NewMessageReader(bytes.NewReader(bad))
+	mr.SpillSize = 100
+	mr.Spill = func([]RawValue, int) (io.Writer, []byte) { return io.Discard, []byte{0x80, 0x00} }
+	if _, err := mr.ReadMessage(); err == nil {
+		t.Errorf("Expected error for an overrunning element")
+	}
+}
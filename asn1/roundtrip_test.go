@@ -0,0 +1,195 @@
+package asn1
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// roundTrip has a field of each kind the encoder and decoder support.
+// New kinds and options should be added to it, so that they are covered
+// by TestRoundTrip.
+type roundTrip struct {
+	Int      int
+	Small    int8
+	Medium   int32
+	Flag     bool
+	Bytes    []byte
+	List     [][]byte
+	Set      []int  `asn1:"set"`
+	Enum     int    `asn1:"enum"`
+	Implicit []byte `asn1:"tag:0,implicit"`
+	Explicit bool   `asn1:"tag:1,explicit"`
+	App      int    `asn1:"application,tag:2,implicit"`
+	Private  []byte `asn1:"class:private,tag:3,implicit"`
+	Nested   point
+	Points   []point
+	Optional int    `asn1:"tag:4,implicit,optional"`
+	Default  int    `asn1:"tag:5,implicit,default:7"`
+	Tail     tpoint `asn1:"components"`
+}
+
+// randomValue sets v to a random value of its type. Byte slices are now
+// and then long enough to need a long-form length.
+func randomValue(r *rand.Rand, v reflect.Value) {
+	switch t := v.Type(); t.Kind() {
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := r.Int63() >> uint(r.Intn(63))
+		if r.Intn(2) == 0 {
+			i = -i
+		}
+		shift := 64 - uint(t.Bits())
+		v.SetInt(i << shift >> shift)
+	case reflect.Slice:
+		n := r.Intn(4)
+		if t.Elem().Kind() == reflect.Uint8 && r.Intn(8) == 0 {
+			n = 128 + r.Intn(512)
+		}
+		v.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			randomValue(r, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			randomValue(r, v.Field(i))
+		}
+	}
+}
+
+// equalValues reports whether a and b are deeply equal, except that a
+// nil slice equals an empty one, since the decoder cannot tell them
+// apart.
+func equalValues(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Interface && b.Kind() == reflect.Interface {
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		a, b = a.Elem(), b.Elem()
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !equalValues(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func encodeTest(in interface{}, implicit bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Implicit = implicit
+	err := enc.Encode(in)
+	return buf.Bytes(), err
+}
+
+// decodeTest decodes all of b into out.
+func decodeTest(b []byte, out interface{}, implicit bool) error {
+	dec := NewDecoder(bytes.NewReader(b))
+	dec.Implicit = implicit
+	if err := dec.Decode(out); err != nil {
+		return err
+	} else if n := dec.InputOffset(); n != int64(len(b)) {
+		return SyntaxError("trailing data")
+	}
+	return nil
+}
+
+// TestRoundTrip checks that random values decode to what was encoded,
+// and encode again to the same bytes.
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, implicit := range []bool{false, true} {
+		for i := 0; i < 500; i++ {
+			var in, out roundTrip
+			randomValue(r, reflect.ValueOf(&in).Elem())
+			b, err := encodeTest(in, implicit)
+			if err != nil {
+				t.Fatalf("#%d: Encode %+v: %v", i, in, err)
+			}
+			if err := decodeTest(b, &out, implicit); err != nil {
+				t.Fatalf("#%d: Decode % x: %v", i, b, err)
+			}
+			if !equalValues(reflect.ValueOf(in), reflect.ValueOf(out)) {
+				t.Fatalf("#%d: Bad result: %+v (expected %+v)", i, out, in)
+			}
+			if again, err := encodeTest(out, implicit); err != nil || !bytes.Equal(again, b) {
+				t.Fatalf("#%d: Bad encoding: % x, %v (expected % x)", i, again, err, b)
+			}
+		}
+	}
+}
+
+// TestRoundTripCorpus checks that BER encodings, including ones the
+// encoder does not produce, decode to values that are unchanged by
+// encoding and decoding them again, and whose encoding is stable.
+func TestRoundTripCorpus(t *testing.T) {
+	longBytes := append([]byte{0x04, 0x82, 0x01, 0x00}, make([]byte, 256)...)
+	tests := []struct {
+		in  []byte
+		out func() interface{}
+	}{
+		{[]byte{0x30, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02}, func() interface{} { return new(point) }},
+		{[]byte{0x30, 0x80, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02, 0x00, 0x00}, func() interface{} { return new(point) }},
+		{[]byte{0x30, 0x81, 0x06, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02}, func() interface{} { return new(point) }},
+		{[]byte{0x30, 0x07, 0x02, 0x02, 0x00, 0x05, 0x02, 0x01, 0x02}, func() interface{} { return new(point) }},
+		{[]byte{0x30, 0x80,
+			0x30, 0x80, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02, 0x00, 0x00,
+			0x04, 0x81, 0x01, 'a',
+			0x00, 0x00}, func() interface{} { return new(namedPoint) }},
+		{[]byte{0x30, 0x06, 0x80, 0x01, 0x04, 0x81, 0x01, 0x02}, func() interface{} { return new(tpoint) }},
+		{[]byte{0x30, 0x03, 0x80, 0x01, 0x04}, func() interface{} { return new(opoint) }},
+		{[]byte{0x01, 0x01, 0x01}, func() interface{} { return new(bool) }},
+		{longBytes, func() interface{} { return new([]byte) }},
+		{[]byte{0x31, 0x80, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02, 0x00, 0x00}, func() interface{} { return &OptionValue{"set", new([]int)} }},
+		{[]byte{0x30, 0x80,
+			0x02, 0x01, 0x05,
+			0x04, 0x01, 'x',
+			0x01, 0x01, 0x01,
+			0x31, 0x03, 0x0a, 0x01, 0x01,
+			0x80, 0x01, 0x07,
+			0x00, 0x00}, func() interface{} { return new(interface{}) }},
+	}
+	for i, test := range tests {
+		first := test.out()
+		if err := decodeTest(test.in, first, false); err != nil {
+			t.Errorf("#%d: Decode: %v", i, err)
+			continue
+		}
+		b, err := encodeTest(first, false)
+		if err != nil {
+			t.Errorf("#%d: Encode: %v", i, err)
+			continue
+		}
+		second := test.out()
+		if err := decodeTest(b, second, false); err != nil {
+			t.Errorf("#%d: Decode % x: %v", i, b, err)
+			continue
+		}
+		if !equalValues(reflect.ValueOf(first).Elem(), reflect.ValueOf(second).Elem()) {
+			t.Errorf("#%d: Bad result: %+v (expected %+v)", i, second, first)
+		}
+		if again, err := encodeTest(second, false); err != nil || !bytes.Equal(again, b) {
+			t.Errorf("#%d: Bad encoding: % x, %v (expected % x)", i, again, err, b)
+		}
+	}
+}
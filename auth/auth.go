@@ -0,0 +1,140 @@
+// Package auth authenticates users against a directory the usual way:
+// find the user's entry with a service connection, then bind as it.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap"
+	"strings"
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown user, a wrong
+	// password, or an empty one, without saying which.
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+	// ErrNotInGroup is returned for a user who authenticated but is a
+	// member of none of the required groups.
+	ErrNotInGroup = errors.New("auth: user is not a member of a required group")
+)
+
+// A Verifier checks usernames and passwords.
+type Verifier struct {
+	// Pool provides the connections searches are made on, which
+	// should be bound as a user allowed to read the users' entries.
+	// The bind as the user is made on a connection of its own, from
+	// Pool.Dial.
+	Pool *ldap.Pool
+	// BaseDN is where users are searched for.
+	BaseDN string
+	// Filter finds a user by name, which replaces each %s in it,
+	// escaped, e.g. "(&(objectClass=person)(uid=%s))".
+	Filter string
+	// Attributes are read from the user's entry. If nil, only its DN
+	// is returned.
+	Attributes []string
+	// Groups, if set, restricts the users who may authenticate to the
+	// members of one of these groups, directly or through nested
+	// groups, found below GroupBaseDN, or BaseDN if it is empty.
+	Groups      []string
+	GroupBaseDN string
+}
+
+// Verify is a shortcut for a Verifier without attributes or groups.
+func Verify(pool *ldap.Pool, baseDN, filterTemplate, username, password string) (*ldap.Entry, error) {
+	v := &Verifier{Pool: pool, BaseDN: baseDN, Filter: filterTemplate}
+	return v.Verify(username, password)
+}
+
+// Verify returns the entry of the user if the password is theirs. A
+// password must be given, since a bind with none would be anonymous and
+// succeed for any user (RFC 4513 §5.1.2).
+func (v *Verifier) Verify(username, password string) (*ldap.Entry, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	var user *ldap.Entry
+	err := v.Pool.WithConn(func(c ldap.Conn) error {
+		var err error
+		user, err = v.find(c, username)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := v.Pool.Dial()
+	if err != nil {
+		return nil, err
+	}
+	err = c.Bind(user.DN, password)
+	c.Close()
+	var e *ldap.Error
+	if errors.As(err, &e) && e.ResultCode == ldap.InvalidCredentials {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(v.Groups) > 0 {
+		if err := v.Pool.WithConn(func(c ldap.Conn) error { return v.checkGroups(c, user.DN) }); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (v *Verifier) find(c ldap.Conn, username string) (*ldap.Entry, error) {
+	filter, err := ldap.CompileFilter(strings.ReplaceAll(v.Filter, "%s", ldap.EscapeFilter(username)))
+	if err != nil {
+		return nil, err
+	}
+	attrs := [][]byte{[]byte("1.1")}
+	if v.Attributes != nil {
+		attrs = attrs[:0]
+		for _, a := range v.Attributes {
+			attrs = append(attrs, []byte(a))
+		}
+	}
+	results, err := c.Search(ldap.SearchRequest{
+		BaseObject: []byte(v.BaseDN),
+		Scope:      ldap.WholeSubtree,
+		Filter:     filter,
+		SizeLimit:  2,
+		Attributes: attrs,
+	})
+	var e *ldap.Error
+	if errors.As(err, &e) && e.ResultCode == ldap.SizeLimitExceeded {
+		return nil, fmt.Errorf("auth: more than one user named %q", username)
+	} else if err != nil {
+		return nil, err
+	}
+	switch len(results) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+		return results[0].Entry(), nil
+	}
+	return nil, fmt.Errorf("auth: more than one user named %q", username)
+}
+
+func (v *Verifier) checkGroups(c ldap.Conn, dn string) error {
+	base := v.GroupBaseDN
+	if base == "" {
+		base = v.BaseDN
+	}
+	groups, err := ldap.GroupsOf(c, base, dn)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		norm, _ := ldap.DistinguishedNameMatch.Normalize(g)
+		for _, required := range v.Groups {
+			if r, _ := ldap.DistinguishedNameMatch.Normalize(required); r == norm {
+				return nil
+			}
+		}
+	}
+	return ErrNotInGroup
+}
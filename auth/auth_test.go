@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldaptest"
+	"testing"
+)
+
+const fixture = `dn: dc=example,dc=com
+objectClass: domain
+dc: example
+
+dn: uid=alice,dc=example,dc=com
+objectClass: person
+uid: alice
+cn: Alice
+userPassword: {SSHA}NGAMOZHhQA/L7qBLdB7d3v8jcA/HG8OGfpdiIw==
+
+dn: uid=bob,dc=example,dc=com
+objectClass: person
+uid: bob
+cn: Bob
+userPassword: bob
+
+dn: cn=twin,dc=example,dc=com
+objectClass: person
+uid: twin
+
+dn: cn=twin2,dc=example,dc=com
+objectClass: person
+uid: twin
+
+dn: cn=admins,dc=example,dc=com
+objectClass: groupOfNames
+member: cn=staff,dc=example,dc=com
+
+dn: cn=staff,dc=example,dc=com
+objectClass: groupOfNames
+member: uid=alice,dc=example,dc=com
+`
+
+func TestVerify(t *testing.T) {
+	s := ldaptest.StartServer(t)
+	s.Load(fixture)
+	pool, err := ldap.NewPool(func() (ldap.Conn, error) { return ldap.Dial(s.Addr) }, ldap.PoolOptions{
+		Bind: func(c ldap.Conn) error { return c.Bind(ldaptest.AdminDN, ldaptest.AdminPassword) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	const filter = "(&(objectClass=person)(uid=%s))"
+	tests := []struct {
+		username, password string
+		dn                 string
+		err                error
+	}{
+		{"alice", "secret", "uid=alice,dc=example,dc=com", nil},
+		{"bob", "bob", "uid=bob,dc=example,dc=com", nil},
+		{"alice", "wrong", "", ErrInvalidCredentials},
+		{"alice", "", "", ErrInvalidCredentials},
+		{"", "secret", "", ErrInvalidCredentials},
+		{"carol", "secret", "", ErrInvalidCredentials},
+		{"*", "secret", "", ErrInvalidCredentials},
+	}
+	for i, test := range tests {
+		e, err := Verify(pool, "dc=example,dc=com", filter, test.username, test.password)
+		if err != test.err {
+			t.Errorf("#%d: Bad error: %v (expected %v)", i, err, test.err)
+		} else if err == nil && e.DN != test.dn {
+			t.Errorf("#%d: Bad result: %s (expected %s)", i, e.DN, test.dn)
+		}
+	}
+	if _, err := Verify(pool, "dc=example,dc=com", filter, "twin", "secret"); err == nil || err == ErrInvalidCredentials {
+		t.Errorf("Bad error for an ambiguous user: %v", err)
+	}
+
+	v := &Verifier{
+		Pool:       pool,
+		BaseDN:     "dc=example,dc=com",
+		Filter:     filter,
+		Attributes: []string{"cn"},
+		Groups:     []string{"CN=Admins,dc=example,dc=com"},
+	}
+	e, err := v.Verify("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := e.GetAttributeValue("cn"); cn != "Alice" {
+		t.Errorf("Bad cn: %q (expected %q)", cn, "Alice")
+	}
+	if _, err := v.Verify("bob", "bob"); err != ErrNotInGroup {
+		t.Errorf("Bad error: %v (expected %v)", err, ErrNotInGroup)
+	}
+}
@@ -0,0 +1,220 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+type BulkOpType int
+
+const (
+	BulkAdd BulkOpType = iota
+	BulkModify
+	BulkDelete
+)
+
+var bulkOpNames = map[BulkOpType]string{BulkAdd: "add", BulkModify: "modify", BulkDelete: "delete"}
+
+func (t BulkOpType) String() string { return bulkOpNames[t] }
+
+// A BulkOp is one update of a Bulk run.
+type BulkOp struct {
+	Type          BulkOpType
+	DN            string
+	Attributes    []Attribute    // for BulkAdd
+	Modifications []Modification // for BulkModify
+	Controls      []Control
+}
+
+func AddOp(dn string, attrs []Attribute, controls ...Control) BulkOp {
+	return BulkOp{Type: BulkAdd, DN: dn, Attributes: attrs, Controls: controls}
+}
+
+func ModifyOp(dn string, mods []Modification, controls ...Control) BulkOp {
+	return BulkOp{Type: BulkModify, DN: dn, Modifications: mods, Controls: controls}
+}
+
+func DelOp(dn string, controls ...Control) BulkOp {
+	return BulkOp{Type: BulkDelete, DN: dn, Controls: controls}
+}
+
+func (op BulkOp) apply(c Conn) error {
+	switch op.Type {
+	case BulkAdd:
+		return c.Add(op.DN, op.Attributes, op.Controls...)
+	case BulkModify:
+		return c.Modify(op.DN, op.Modifications, op.Controls...)
+	case BulkDelete:
+		return c.Del(op.DN, op.Controls...)
+	}
+	return fmt.Errorf("ldap: unknown bulk operation %d", op.Type)
+}
+
+// Bulk applies large batches of updates with connections from a pool,
+// several at a time. Updates of the same entry are applied in the order
+// given; others are not ordered, so an entry and its superior must not
+// be added in the same run unless Parallelism is 1.
+type Bulk struct {
+	Pool *Pool
+	// Parallelism is the number of updates in flight. It defaults to 1.
+	Parallelism int
+	// StopOnError stops the run at the first failure, rather than
+	// applying every update that can be.
+	StopOnError bool
+	// Progress, if set, is called after each update with the numbers
+	// of updates done and failed so far. Calls are not concurrent.
+	Progress func(done, failed int)
+}
+
+// A BulkFailure is an update that failed, with its position in the run.
+type BulkFailure struct {
+	Index int
+	Op    BulkOp
+	Err   error
+}
+
+// A BulkError reports the updates of a run that failed, and how many
+// were not attempted because the run stopped early.
+type BulkError struct {
+	Failures []BulkFailure
+	Skipped  int
+}
+
+func (e *BulkError) Error() string {
+	msg := fmt.Sprintf("ldap: %d bulk operations failed", len(e.Failures))
+	if len(e.Failures) > 0 {
+		f := e.Failures[0]
+		msg += fmt.Sprintf(", first #%d (%s %s): %v", f.Index, f.Op.Type, f.Op.DN, f.Err)
+	}
+	if e.Skipped > 0 {
+		msg += fmt.Sprintf("; %d skipped", e.Skipped)
+	}
+	return msg
+}
+
+// Run applies ops, returning a *BulkError if any failed or were skipped.
+func (b *Bulk) Run(ctx context.Context, ops []BulkOp) error {
+	ch := make(chan BulkOp)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(ch)
+		for _, op := range ops {
+			select {
+			case ch <- op:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	done, failures, _, err := b.stream(ctx, ch)
+	return bulkResult(failures, len(ops)-done, err)
+}
+
+// Stream is like Run, but reads the updates from a channel until it is
+// closed, so that they need not all be held in memory. Updates left
+// unread in ops when the run stops early are not counted as skipped.
+func (b *Bulk) Stream(ctx context.Context, ops <-chan BulkOp) error {
+	_, failures, skipped, err := b.stream(ctx, ops)
+	return bulkResult(failures, skipped, err)
+}
+
+func bulkResult(failures []BulkFailure, skipped int, err error) error {
+	if len(failures) == 0 && skipped == 0 {
+		return err
+	}
+	return &BulkError{Failures: failures, Skipped: skipped}
+}
+
+// stream returns the number of updates attempted, those that failed, the
+// number read but skipped, and the error of ctx, if any.
+func (b *Bulk) stream(ctx context.Context, ops <-chan BulkOp) (int, []BulkFailure, int, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type indexedOp struct {
+		index int
+		op    BulkOp
+	}
+	n := b.Parallelism
+	if n < 1 {
+		n = 1
+	}
+	queues := make([]chan indexedOp, n)
+
+	var mu sync.Mutex
+	done, skipped := 0, 0
+	var failures []BulkFailure
+	report := func(i int, op BulkOp, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			if b.StopOnError && len(failures) > 0 {
+				// Interrupted by the run being stopped.
+				skipped++
+				return
+			}
+			failures = append(failures, BulkFailure{i, op, err})
+			if b.StopOnError {
+				cancel()
+			}
+		}
+		done++
+		if b.Progress != nil {
+			b.Progress(done, len(failures))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan indexedOp, 16)
+		wg.Add(1)
+		go func(queue chan indexedOp) {
+			defer wg.Done()
+			for q := range queue {
+				if ctx.Err() != nil {
+					mu.Lock()
+					skipped++
+					mu.Unlock()
+					continue
+				}
+				report(q.index, q.op, b.Pool.WithConnContext(ctx, q.op.apply))
+			}
+		}(queues[i])
+	}
+
+	// Updates of an entry go to the same worker, which applies them in
+	// order.
+	index := 0
+dispatch:
+	for {
+		select {
+		case op, ok := <-ops:
+			if !ok {
+				break dispatch
+			}
+			h := fnv.New32a()
+			h.Write([]byte(normalizeDN(op.DN)))
+			select {
+			case queues[h.Sum32()%uint32(n)] <- indexedOp{index, op}:
+			case <-ctx.Done():
+				mu.Lock()
+				skipped++
+				mu.Unlock()
+				break dispatch
+			}
+			index++
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+
+	return done, failures, skipped, parent.Err()
+}
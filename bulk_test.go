@@ -0,0 +1,121 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type bulkTestConn struct {
+	Conn
+	mu  *sync.Mutex
+	log map[string][]string
+}
+
+func (c *bulkTestConn) record(op, dn string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if strings.HasPrefix(dn, "cn=bad") {
+		return fmt.Errorf("refused")
+	}
+	dn = strings.ToLower(dn)
+	c.log[dn] = append(c.log[dn], op)
+	return nil
+}
+
+func (c *bulkTestConn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	return c.record("add", dn)
+}
+
+func (c *bulkTestConn) Modify(dn string, mods []Modification, controls ...Control) error {
+	return c.record(mods[0].Values[0], dn)
+}
+
+func (c *bulkTestConn) Del(dn string, controls ...Control) error {
+	return c.record("delete", dn)
+}
+
+func (c *bulkTestConn) WithContext(ctx context.Context) Conn { return c }
+
+func (c *bulkTestConn) Close() error { return nil }
+
+func newBulkTestPool(t *testing.T) (*Pool, map[string][]string) {
+	var mu sync.Mutex
+	log := map[string][]string{}
+	p, err := NewPool(func() (Conn, error) {
+		return &bulkTestConn{mu: &mu, log: log}, nil
+	}, PoolOptions{MaxConns: 4, HealthCheck: func(Conn) error { return nil }})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return p, log
+}
+
+func TestBulkOrdersUpdatesOfAnEntry(t *testing.T) {
+	p, log := newBulkTestPool(t)
+	defer p.Close()
+
+	var ops []BulkOp
+	for i := 0; i < 50; i++ {
+		dn := fmt.Sprintf("cn=u%d,dc=example,dc=com", i)
+		ops = append(ops, AddOp(dn, nil))
+		for j := 0; j < 5; j++ {
+			// Differently cased DNs name the same entry.
+			ops = append(ops, ModifyOp(strings.ToUpper(dn), []Modification{{ReplaceValues, Attribute{"description", []string{fmt.Sprint(j)}}}}))
+		}
+		ops = append(ops, DelOp(dn))
+	}
+	calls := 0
+	b := &Bulk{Pool: p, Parallelism: 4, Progress: func(done, failed int) { calls++ }}
+	if err := b.Run(context.Background(), ops); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != len(ops) {
+		t.Errorf("Bad progress calls: %d (expected %d)", calls, len(ops))
+	}
+	expected := "add 0 1 2 3 4 delete"
+	for i := 0; i < 50; i++ {
+		dn := fmt.Sprintf("cn=u%d,dc=example,dc=com", i)
+		if actual := strings.Join(log[dn], " "); actual != expected {
+			t.Errorf("%s: Bad result: %v (expected %v)", dn, actual, expected)
+		}
+	}
+}
+
+func TestBulkReportsFailures(t *testing.T) {
+	tests := []struct {
+		stopOnError bool
+		failures    int
+	}{
+		{false, 2},
+		{true, 1},
+	}
+	for i, test := range tests {
+		p, _ := newBulkTestPool(t)
+		ops := []BulkOp{
+			AddOp("cn=a,dc=example,dc=com", nil),
+			DelOp("cn=bad1,dc=example,dc=com"),
+			AddOp("cn=b,dc=example,dc=com", nil),
+			DelOp("cn=bad2,dc=example,dc=com"),
+			AddOp("cn=c,dc=example,dc=com", nil),
+		}
+		err := (&Bulk{Pool: p, StopOnError: test.stopOnError}).Run(context.Background(), ops)
+		p.Close()
+		berr, ok := err.(*BulkError)
+		if !ok {
+			t.Errorf("#%d: Expected *BulkError, got %v", i, err)
+			continue
+		}
+		if len(berr.Failures) != test.failures {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, len(berr.Failures), test.failures)
+		}
+		if f := berr.Failures[0]; f.Index != 1 || f.Op.DN != "cn=bad1,dc=example,dc=com" {
+			t.Errorf("#%d: Bad first failure: %+v", i, f)
+		}
+		if test.stopOnError && berr.Skipped == 0 {
+			t.Errorf("#%d: Expected skipped updates", i)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package ldap
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A SearchCache keeps the results of searches for a while, so that
+// frequent lookups such as a user's entry or groups are not repeated
+// against the directory. Searches are cached by everything in the
+// request: base, scope, filter, attributes and limits.
+//
+// Results depend on the identity a connection is bound as, so a cache
+// must only be shared between connections bound the same way, and
+// binding or unbinding through a cached view of a connection purges it.
+// Updates made through a view invalidate the searches they may affect,
+// and those whose effect it cannot tell, such as a committed
+// transaction or an unknown extended operation, purge the cache;
+// changes made elsewhere can be fed to the cache by a persistent search
+// or DirSync, with OnChange and OnDirSync:
+//
+//	go conn.PersistentSearch(req, &ldap.ControlPersistentSearch{ChangeTypes: ldap.ChangeAny, ChangesOnly: true}, cache.OnChange)
+type SearchCache struct {
+	// TTL is how long results are kept. Zero means until invalidated.
+	TTL time.Duration
+	// MaxEntries limits the number of searches kept, the least
+	// recently used being dropped first. Zero means no limit.
+	MaxEntries int
+
+	mu      sync.Mutex
+	lru     *list.List // of *cachedSearch, most recently used first
+	entries map[string]*list.Element
+	// gen counts invalidations, so that the results of a search that
+	// one overtook are not cached.
+	gen uint64
+}
+
+type cachedSearch struct {
+	key     string
+	base    []string // normalized RDNs, leaf first
+	scope   SearchScope
+	dns     map[string]bool
+	results []SearchResult
+	expires time.Time
+}
+
+// NewSearchCache returns a cache keeping up to maxEntries searches for
+// ttl.
+func NewSearchCache(ttl time.Duration, maxEntries int) *SearchCache {
+	return &SearchCache{TTL: ttl, MaxEntries: maxEntries}
+}
+
+// Conn returns a view of conn whose Search is answered from the cache
+// when possible, and whose updates invalidate it.
+func (c *SearchCache) Conn(conn Conn) Conn {
+	return &cachingConn{conn, c}
+}
+
+// Search returns the results of req from the cache, or performs it on
+// conn and caches them.
+func (c *SearchCache) Search(conn Conn, req SearchRequest) ([]SearchResult, error) {
+	key, err := searchKey(req)
+	if err != nil {
+		return conn.Search(req)
+	}
+	results, gen, ok := c.get(key)
+	if ok {
+		return results, nil
+	}
+	results, err = conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, req, results, gen)
+	return copyResults(results), nil
+}
+
+// get returns the cached results for key, if any, and the current
+// generation, to be passed to put on a miss.
+func (c *SearchCache) get(key string) ([]SearchResult, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, c.gen, false
+	}
+	s := el.Value.(*cachedSearch)
+	if !s.expires.IsZero() && time.Now().After(s.expires) {
+		c.remove(el)
+		return nil, c.gen, false
+	}
+	c.lru.MoveToFront(el)
+	return copyResults(s.results), c.gen, true
+}
+
+// put caches results for key, unless the cache has been invalidated
+// since generation gen, when the search began, as the results may then
+// be stale.
+func (c *SearchCache) put(key string, req SearchRequest, results []SearchResult, gen uint64) {
+	s := &cachedSearch{
+		key:     key,
+		base:    dnComponents(string(req.BaseObject)),
+		scope:   req.Scope,
+		dns:     map[string]bool{},
+		results: copyResults(results),
+	}
+	for _, r := range results {
+		s.dns[normalizeDN(r.DN)] = true
+	}
+	if c.TTL > 0 {
+		s.expires = time.Now().Add(c.TTL)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen != gen {
+		return
+	}
+	if c.entries == nil {
+		c.entries = map[string]*list.Element{}
+		c.lru = list.New()
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(s)
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops a cached search. c.mu must be held.
+func (c *SearchCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cachedSearch).key)
+}
+
+// Invalidate drops the cached searches that the entry dn was returned
+// by, or that it is in the scope of and so might now be returned by.
+func (c *SearchCache) Invalidate(dn string) {
+	c.invalidate(dn, false)
+}
+
+// InvalidateSubtree drops the searches Invalidate does, and those based
+// at or below dn, for changes that affect the descendants of an entry
+// too, such as a subtree delete or the rename of a non-leaf entry.
+func (c *SearchCache) InvalidateSubtree(dn string) {
+	c.invalidate(dn, true)
+}
+
+func (c *SearchCache) invalidate(dn string, subtree bool) {
+	components := dnComponents(dn)
+	norm := normalizeDN(dn)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if c.lru == nil {
+		return
+	}
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		s := el.Value.(*cachedSearch)
+		if s.dns[norm] || s.inScope(components) || subtree && componentsInScope(s.base, components, WholeSubtree) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// Purge drops every cached search.
+func (c *SearchCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.entries, c.lru = nil, nil
+}
+
+// Len returns the number of cached searches.
+func (c *SearchCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// OnChange invalidates the searches affected by a change reported by a
+// persistent search, including, for a renamed entry, its old DN and the
+// searches below it, and for a deleted entry the searches below it. It
+// has the signature of the callback of PersistentSearch.
+func (c *SearchCache) OnChange(r SearchResult, ecn *ControlEntryChangeNotification) error {
+	if ecn != nil && ecn.ChangeType&(ChangeDelete|ChangeModDN) != 0 {
+		c.InvalidateSubtree(r.DN)
+	} else {
+		c.Invalidate(r.DN)
+	}
+	if ecn != nil && ecn.PreviousDN != "" {
+		c.InvalidateSubtree(ecn.PreviousDN)
+	}
+	return nil
+}
+
+// OnDirSync invalidates the searches affected by a change reported by
+// DirSync. It has the signature of the callback of DirSync.
+func (c *SearchCache) OnDirSync(r SearchResult) error {
+	c.Invalidate(r.DN)
+	return nil
+}
+
+// inScope reports whether the entry with the normalized RDNs components
+// is within the scope of the search.
+func (s *cachedSearch) inScope(components []string) bool {
+	return componentsInScope(components, s.base, s.scope)
+}
+
+// componentsInScope reports whether the entry with the normalized RDNs
+// components is within scope of the base with the RDNs base, counting
+// the base itself as in the scope of a one-level search.
+func componentsInScope(components, base []string, scope SearchScope) bool {
+	depth := len(components) - len(base)
+	if depth < 0 || base == nil && len(components) == 0 {
+		return false
+	}
+	for i := range base {
+		if components[depth+i] != base[i] {
+			return false
+		}
+	}
+	switch scope {
+	case BaseObject:
+		return depth == 0
+	case SingleLevel:
+		return depth <= 1
+	}
+	return true
+}
+
+// dnComponents returns the normalized RDNs of dn, leaf first.
+func dnComponents(dn string) []string {
+	dn = normalizeDN(dn)
+	if dn == "" {
+		return nil
+	}
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return strings.Split(dn, ",")
+	}
+	components := make([]string, len(parsed))
+	for i, rdn := range parsed {
+		components[i] = rdn.String()
+	}
+	return components
+}
+
+func searchKey(req SearchRequest) (string, error) {
+	filter, err := DecompileFilter(req.Filter)
+	if err != nil {
+		return "", err
+	}
+	attrs := make([]string, len(req.Attributes))
+	for i, a := range req.Attributes {
+		attrs[i] = strings.ToLower(string(a))
+	}
+	return fmt.Sprintf("%s\x00%d\x00%d\x00%d\x00%d\x00%t\x00%s\x00%s",
+		normalizeDN(string(req.BaseObject)), req.Scope, req.Deref, req.SizeLimit, req.TimeLimit,
+		req.TypesOnly, filter, strings.Join(attrs, ",")), nil
+}
+
+func copyResults(results []SearchResult) []SearchResult {
+	out := make([]SearchResult, len(results))
+	for i, r := range results {
+		attrs := make(map[string][]string, len(r.Attributes))
+		for k, v := range r.Attributes {
+			attrs[k] = append([]string(nil), v...)
+		}
+		out[i] = SearchResult{DN: r.DN, Attributes: attrs}
+	}
+	return out
+}
+
+type cachingConn struct {
+	Conn
+	cache *SearchCache
+}
+
+func (c *cachingConn) Search(req SearchRequest) ([]SearchResult, error) {
+	return c.cache.Search(c.Conn, req)
+}
+
+func (c *cachingConn) SearchIter(req SearchRequest, pageSize int) *SearchIterator {
+	return searchIter(c, req, pageSize)
+}
+
+func (c *cachingConn) GetEntry(dn string, attrs ...string) (*Entry, error) {
+	return getEntry(c, dn, attrs)
+}
+
+func (c *cachingConn) Exists(dn string) (bool, error) {
+	return exists(c, dn)
+}
+
+func (c *cachingConn) Ensure(entry *Entry) (bool, error) {
+	return ensure(c, entry)
+}
+
+func (c *cachingConn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	defer c.cache.Invalidate(dn)
+	return c.Conn.Add(dn, attrs, controls...)
+}
+
+func (c *cachingConn) AddWithParents(entry *Entry, templates map[string][]Attribute, controls ...Control) error {
+	return addWithParents(c, entry, templates, controls)
+}
+
+func (c *cachingConn) Modify(dn string, mods []Modification, controls ...Control) error {
+	defer c.cache.Invalidate(dn)
+	return c.Conn.Modify(dn, mods, controls...)
+}
+
+func (c *cachingConn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error {
+	defer c.cache.InvalidateSubtree(dn)
+	defer c.cache.InvalidateSubtree(renamedDN(dn, newRDN, newSuperior))
+	return c.Conn.ModifyDN(dn, newRDN, deleteOldRDN, newSuperior, controls...)
+}
+
+// renamedDN returns the DN of the entry dn after ModifyDN.
+func renamedDN(dn, newRDN, newSuperior string) string {
+	parent := newSuperior
+	if parsed, err := ParseDN(dn); err == nil && parent == "" && len(parsed) > 0 {
+		parent = parsed[1:].String()
+	}
+	if parent == "" {
+		return newRDN
+	}
+	return newRDN + "," + parent
+}
+
+// Del invalidates the searches below dn as well, as with
+// ControlSubtreeDelete its descendants are deleted too.
+func (c *cachingConn) Del(dn string, controls ...Control) error {
+	defer c.cache.InvalidateSubtree(dn)
+	return c.Conn.Del(dn, controls...)
+}
+
+func (c *cachingConn) Bind(user, password string) error {
+	defer c.cache.Purge()
+	return c.Conn.Bind(user, password)
+}
+
+func (c *cachingConn) BindWithControls(user, password string, controls ...Control) ([]Control, error) {
+	defer c.cache.Purge()
+	return c.Conn.BindWithControls(user, password, controls...)
+}
+
+func (c *cachingConn) BindWithPasswordPolicy(user, password string) (*ControlPasswordPolicy, error) {
+	defer c.cache.Purge()
+	return c.Conn.BindWithPasswordPolicy(user, password)
+}
+
+func (c *cachingConn) SASLBind(mech SASLMechanism) error {
+	defer c.cache.Purge()
+	return c.Conn.SASLBind(mech)
+}
+
+func (c *cachingConn) SASLBindAny(mechs ...SASLMechanism) error {
+	defer c.cache.Purge()
+	return c.Conn.SASLBindAny(mechs...)
+}
+
+func (c *cachingConn) ExternalBind(authzID string) error {
+	defer c.cache.Purge()
+	return c.Conn.ExternalBind(authzID)
+}
+
+func (c *cachingConn) NTLMBind(creds NTLMCredentials) error {
+	defer c.cache.Purge()
+	return c.Conn.NTLMBind(creds)
+}
+
+func (c *cachingConn) Unbind() error {
+	defer c.cache.Purge()
+	return c.Conn.Unbind()
+}
+
+// PasswordModify invalidates the searches of the user's entry, or
+// purges the cache if the user is not named by a DN.
+func (c *cachingConn) PasswordModify(user, oldPassword, newPassword string) (string, error) {
+	dn := strings.TrimPrefix(user, "dn:")
+	if _, err := ParseDN(dn); err == nil && strings.Contains(dn, "=") {
+		defer c.cache.Invalidate(dn)
+	} else {
+		defer c.cache.Purge()
+	}
+	return c.Conn.PasswordModify(user, oldPassword, newPassword)
+}
+
+// Extended purges the cache, as the operation may change anything.
+func (c *cachingConn) Extended(name string, value []byte, fn func(IntermediateResponse, []Control) error, controls ...Control) (*ExtendedResponse, error) {
+	defer c.cache.Purge()
+	return c.Conn.Extended(name, value, fn, controls...)
+}
+
+// EndTransaction purges the cache if the transaction is committed, as
+// its updates may have been cached in between.
+func (c *cachingConn) EndTransaction(id []byte, commit bool) error {
+	if commit {
+		defer c.cache.Purge()
+	}
+	return c.Conn.EndTransaction(id, commit)
+}
+
+func (c *cachingConn) WithContext(ctx context.Context) Conn {
+	return &cachingConn{c.Conn.WithContext(ctx), c.cache}
+}
@@ -0,0 +1,146 @@
+package ldap
+
+import (
+	"testing"
+	"time"
+)
+
+type countingConn struct {
+	directoryConn
+	searches int
+	// during, if set, is called while a search is outstanding.
+	during func()
+}
+
+func (c *countingConn) Search(req SearchRequest) ([]SearchResult, error) {
+	c.searches++
+	if c.during != nil {
+		c.during()
+	}
+	return c.directoryConn.Search(req)
+}
+
+func (c *countingConn) Modify(dn string, mods []Modification, controls ...Control) error {
+	return nil
+}
+
+func (c *countingConn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error {
+	return nil
+}
+
+func (c *countingConn) Del(dn string, controls ...Control) error {
+	return nil
+}
+
+func (c *countingConn) Bind(user, password string) error {
+	return nil
+}
+
+func (c *countingConn) PasswordModify(user, oldPassword, newPassword string) (string, error) {
+	return "", nil
+}
+
+func (c *countingConn) Extended(name string, value []byte, fn func(IntermediateResponse, []Control) error, controls ...Control) (*ExtendedResponse, error) {
+	return &ExtendedResponse{}, nil
+}
+
+func (c *countingConn) EndTransaction(id []byte, commit bool) error {
+	return nil
+}
+
+func TestSearchCache(t *testing.T) {
+	const base = "dc=example,dc=com"
+	conn := &countingConn{directoryConn: directoryConn{entries: []*Entry{
+		NewEntry(base, map[string][]string{"objectClass": {"domain"}}),
+		NewEntry("ou=People,"+base, map[string][]string{"objectClass": {"organizationalUnit"}}),
+		NewEntry("uid=alice,ou=People,"+base, map[string][]string{"objectClass": {"person"}, "cn": {"Alice"}}),
+		NewEntry("uid=bob,ou=People,"+base, map[string][]string{"objectClass": {"person"}, "cn": {"Bob"}}),
+		NewEntry("ou=Groups,"+base, map[string][]string{"objectClass": {"organizationalUnit"}}),
+	}}}
+	cache := NewSearchCache(time.Hour, 2)
+	c := cache.Conn(conn)
+
+	alice := SearchRequest{BaseObject: []byte("uid=alice,ou=People," + base), Scope: BaseObject, Filter: Present("objectClass")}
+	people := SearchRequest{BaseObject: []byte("ou=People," + base), Scope: SingleLevel, Filter: Equals("cn", "Bob")}
+	groups := SearchRequest{BaseObject: []byte("ou=Groups," + base), Scope: WholeSubtree, Filter: Present("objectClass")}
+
+	steps := []struct {
+		do       func()
+		searches int
+		cached   int
+	}{
+		{func() { c.Search(alice) }, 1, 1},
+		{func() { c.Search(alice) }, 1, 1},
+		// Results are copied, so callers cannot change the cache.
+		{func() {
+			r, _ := c.Search(alice)
+			r[0].Attributes["cn"][0] = "Mallory"
+			if r, _ := c.Search(alice); r[0].Attributes["cn"][0] != "Alice" {
+				t.Errorf("Cached result changed: %v", r)
+			}
+		}, 1, 1},
+		{func() { c.Search(people) }, 2, 2},
+		// A changed entry invalidates the searches returning it and
+		// those it is in the scope of, but no others.
+		{func() { c.Modify("UID=Alice,ou=People,"+base, nil) }, 2, 0},
+		{func() { c.Search(alice) }, 3, 1},
+		{func() { c.Search(people) }, 4, 2},
+		{func() { cache.OnChange(SearchResult{DN: "uid=carol,ou=People," + base}, nil) }, 4, 1},
+		{func() { c.Search(people) }, 5, 2},
+		// The least recently used search is dropped.
+		{func() { c.Search(groups) }, 6, 2},
+		{func() { c.Search(people) }, 6, 2},
+		{func() { c.Search(alice) }, 7, 2},
+		{func() { cache.OnDirSync(SearchResult{DN: "cn=admins,ou=Groups," + base}) }, 7, 2},
+		{func() {
+			cache.OnChange(SearchResult{DN: "uid=alicia,ou=Groups," + base}, &ControlEntryChangeNotification{PreviousDN: "uid=alice,ou=People," + base})
+		}, 7, 0},
+		// A deleted or renamed entry invalidates the searches below it.
+		{func() { c.Search(alice); c.Search(groups) }, 9, 2},
+		{func() { c.Del("ou=People,"+base, &ControlSubtreeDelete{}) }, 9, 1},
+		{func() { c.Search(alice) }, 10, 2},
+		{func() { c.ModifyDN("ou=People,"+base, "ou=Staff", true, "") }, 10, 1},
+		{func() {
+			cache.OnChange(SearchResult{DN: "ou=Groups," + base}, &ControlEntryChangeNotification{ChangeType: ChangeDelete})
+		}, 10, 0},
+		// Binding changes what searches return.
+		{func() { c.Search(alice); c.Search(groups) }, 12, 2},
+		{func() { c.Bind("uid=bob,ou=People,"+base, "secret") }, 12, 0},
+		// So may operations whose effect the cache cannot tell.
+		{func() { c.Search(alice); c.Search(groups) }, 14, 2},
+		{func() { c.PasswordModify("uid=alice,ou=People,"+base, "", "new") }, 14, 1},
+		{func() { c.PasswordModify("u:bob", "", "new") }, 14, 0},
+		{func() { c.Search(alice); c.Search(groups) }, 16, 2},
+		{func() { c.EndTransaction([]byte("1"), false) }, 16, 2},
+		{func() { c.EndTransaction([]byte("1"), true) }, 16, 0},
+		{func() { c.Search(alice); c.Search(groups) }, 18, 2},
+		{func() { c.Extended("1.2.3.4", nil, nil) }, 18, 0},
+	}
+	for i, step := range steps {
+		step.do()
+		if conn.searches != step.searches || cache.Len() != step.cached {
+			t.Errorf("#%d: Bad result: %d searches, %d cached (expected %d, %d)", i, conn.searches, cache.Len(), step.searches, step.cached)
+		}
+	}
+
+	cache = NewSearchCache(time.Millisecond, 0)
+	cache.Search(conn, alice)
+	time.Sleep(2 * time.Millisecond)
+	cache.Search(conn, alice)
+	if conn.searches != 20 {
+		t.Errorf("Bad result: %d searches (expected 20)", conn.searches)
+	}
+
+	// The results of a search that a change overtakes are not cached.
+	cache = NewSearchCache(0, 0)
+	conn.during = func() { cache.Invalidate("uid=alice,ou=People," + base) }
+	cache.Search(conn, alice)
+	if cache.Len() != 0 {
+		t.Errorf("Bad result: %d cached (expected 0)", cache.Len())
+	}
+	conn.during = nil
+	cache.Search(conn, alice)
+	if cache.Len() != 1 {
+		t.Errorf("Bad result: %d cached (expected 1)", cache.Len())
+	}
+}
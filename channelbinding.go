@@ -0,0 +1,74 @@
+package ldap
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"hash"
+)
+
+// Channel binding types (RFC 5929).
+const (
+	ChannelBindingTLSServerEndPoint = "tls-server-end-point"
+	ChannelBindingTLSUnique         = "tls-unique"
+)
+
+// A ChannelBindingMechanism is a SASL mechanism that can tie the
+// authentication to the underlying TLS channel. SASLBind supplies the
+// binding data before starting the exchange whenever the connection is
+// protected by TLS. Domain controllers enforcing LDAP channel binding
+// reject GSSAPI and NTLM binds that lack it.
+type ChannelBindingMechanism interface {
+	SASLMechanism
+	// ChannelBindingType returns the kind of binding the mechanism
+	// wants, e.g. ChannelBindingTLSServerEndPoint.
+	ChannelBindingType() string
+	SetChannelBinding(data []byte)
+}
+
+// ChannelBinding returns the channel binding data of the given type for
+// the connection's TLS session, performing the handshake first if it has
+// not happened yet. The data is returned without the "<type>:" prefix
+// some mechanisms add.
+func (l *conn) ChannelBinding(kind string) ([]byte, error) {
+	tc, ok := l.Conn.(*tls.Conn)
+	if !ok {
+		return nil, fmt.Errorf("channel binding requires a TLS connection")
+	}
+	if err := tc.HandshakeContext(l.ctx); err != nil {
+		return nil, err
+	}
+	state := tc.ConnectionState()
+
+	switch kind {
+	case ChannelBindingTLSServerEndPoint:
+		if len(state.PeerCertificates) == 0 {
+			return nil, fmt.Errorf("server sent no certificate")
+		}
+		cert := state.PeerCertificates[0]
+		h := endPointHash(cert)
+		h.Write(cert.Raw)
+		return h.Sum(nil), nil
+	case ChannelBindingTLSUnique:
+		if state.TLSUnique == nil {
+			return nil, fmt.Errorf("tls-unique is not available for this TLS version")
+		}
+		return state.TLSUnique, nil
+	}
+	return nil, fmt.Errorf("unsupported channel binding type %q", kind)
+}
+
+// endPointHash picks the hash function for tls-server-end-point: the one
+// used in the certificate's signature, with MD5 and SHA-1 upgraded to
+// SHA-256 (RFC 5929 §4.1).
+func endPointHash(cert *x509.Certificate) hash.Hash {
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384, x509.SHA384WithRSAPSS:
+		return sha512.New384()
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512, x509.SHA512WithRSAPSS:
+		return sha512.New()
+	}
+	return sha256.New()
+}
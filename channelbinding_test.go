@@ -0,0 +1,107 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+)
+
+// tlsTestConn returns a client connection using TLS with at most
+// version, and the server end of it presenting cert.
+func tlsTestConn(t *testing.T, cert tls.Certificate, version uint16) (*conn, *tls.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}})
+	go server.Handshake()
+	client := tls.Client(c, &tls.Config{InsecureSkipVerify: true, MaxVersion: version})
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return newConn(client), server
+}
+
+func TestChannelBinding(t *testing.T) {
+	cert := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ldap.example.com"}}, nil)
+	endPoint := sha256.Sum256(cert.Certificate[0])
+
+	tests := []struct {
+		version uint16
+		kind    string
+		ok      bool
+	}{
+		{tls.VersionTLS13, ChannelBindingTLSServerEndPoint, true},
+		{tls.VersionTLS12, ChannelBindingTLSServerEndPoint, true},
+		{tls.VersionTLS12, ChannelBindingTLSUnique, true},
+		// TLS 1.3 has no tls-unique.
+		{tls.VersionTLS13, ChannelBindingTLSUnique, false},
+		{tls.VersionTLS13, "tls-exporter", false},
+	}
+	for i, test := range tests {
+		c, server := tlsTestConn(t, cert, test.version)
+		out, err := c.ChannelBinding(test.kind)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Bad result: %v (expected ok = %v)", i, err, test.ok)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		expected := endPoint[:]
+		if test.kind == ChannelBindingTLSUnique {
+			expected = server.ConnectionState().TLSUnique
+		}
+		if len(expected) == 0 || !bytes.Equal(out, expected) {
+			t.Errorf("#%d: Bad result: % x (expected % x)", i, out, expected)
+		}
+	}
+
+	client, _ := net.Pipe()
+	plain := newConn(client)
+	defer plain.Close()
+	if _, err := plain.ChannelBinding(ChannelBindingTLSServerEndPoint); err == nil {
+		t.Errorf("ChannelBinding succeeded without TLS")
+	}
+}
+
+// bindingMechanism records the channel binding data SASLBind gives it.
+type bindingMechanism struct {
+	SASLExternal
+	data []byte
+}
+
+func (m *bindingMechanism) ChannelBindingType() string    { return ChannelBindingTLSServerEndPoint }
+func (m *bindingMechanism) SetChannelBinding(data []byte) { m.data = data }
+
+func TestSASLBindChannelBinding(t *testing.T) {
+	cert := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ldap.example.com"}}, nil)
+	endPoint := sha256.Sum256(cert.Certificate[0])
+	c, server := tlsTestConn(t, cert, tls.VersionTLS13)
+
+	go func() {
+		m, err := readTestMessage(server)
+		if err != nil {
+			return
+		}
+		writeTestMessage(server, m.MessageId, "application,tag:1", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+	m := &bindingMechanism{}
+	if err := c.SASLBind(m); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.data, endPoint[:]) {
+		t.Errorf("Bad result: % x (expected % x)", m.data, endPoint)
+	}
+}
@@ -0,0 +1,566 @@
+// Command asn1gen generates the asn1.ContentMarshaler and
+// asn1.ContentUnmarshaler methods of struct types, so that encoding and
+// decoding them needs no reflection. It is run by go generate in the
+// directory of the package that declares the types:
+//
+//	//go:generate go run ../cmd/asn1gen -type ldapMessage,ldapResult -output protocol_asn1.go
+//
+// The struct tags are those of the asn1 package, and the generated code
+// assumes IMPLICIT TAGS. Fields of other types than integers, booleans,
+// byte slices, asn1.RawValue and the generated structs (and slices of
+// them) are encoded and decoded by reflection.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	types := flag.String("type", "", "comma-separated `names` of the struct types")
+	output := flag.String("output", "", "`file` to write, by default <package>_asn1.go")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("asn1gen: ")
+	if *types == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	g, err := newGenerator(".", *output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	src, err := g.generate(strings.Split(*types, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(g.output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// A generator holds what it knows of the package's declarations.
+type generator struct {
+	pkg     string
+	output  string
+	structs map[string]*ast.StructType
+	ints    map[string]int // named integer types, by bit size
+	ifaces  map[string]bool
+	paths   map[string]string // import paths, by package name
+	used    map[string]bool   // package names the generated code uses
+	gen     map[string]bool   // the types being generated
+
+	buf bytes.Buffer
+}
+
+func newGenerator(dir, output string) (*generator, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != filepath.Base(output)
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("found %d packages in %s, expected 1", len(pkgs), dir)
+	}
+	g := &generator{
+		output:  output,
+		structs: map[string]*ast.StructType{},
+		ints:    map[string]int{},
+		ifaces:  map[string]bool{},
+		paths:   map[string]string{},
+		used:    map[string]bool{"asn1": true},
+		gen:     map[string]bool{},
+	}
+	for name, pkg := range pkgs {
+		g.pkg = name
+		for _, f := range pkg.Files {
+			g.collect(f)
+		}
+	}
+	if g.output == "" {
+		g.output = g.pkg + "_asn1.go"
+	}
+	return g, nil
+}
+
+func (g *generator) collect(f *ast.File) {
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		g.paths[name] = path
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		ts, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		switch t := ts.Type.(type) {
+		case *ast.StructType:
+			g.structs[ts.Name.Name] = t
+		case *ast.InterfaceType:
+			g.ifaces[ts.Name.Name] = true
+		case *ast.Ident:
+			if size, ok := intSizes[t.Name]; ok {
+				g.ints[ts.Name.Name] = size
+			}
+		}
+		return false
+	})
+}
+
+var intSizes = map[string]int{"int": 0, "int8": 8, "int16": 16, "int32": 32, "int64": 64}
+
+func (g *generator) generate(types []string) ([]byte, error) {
+	for _, name := range types {
+		if g.structs[name] == nil {
+			return nil, fmt.Errorf("no struct type %s in package %s", name, g.pkg)
+		}
+		g.gen[name] = true
+	}
+
+	var body bytes.Buffer
+	for _, name := range types {
+		fields, err := g.fields(name)
+		if err != nil {
+			return nil, err
+		}
+		g.buf.Reset()
+		g.marshal(name, fields)
+		g.unmarshal(name, fields)
+		body.Write(g.buf.Bytes())
+	}
+
+	g.buf.Reset()
+	g.printf("// Code generated by asn1gen; DO NOT EDIT.\n\npackage %s\n\nimport (\n", g.pkg)
+	var imports []string
+	for name := range g.used {
+		path := g.paths[name]
+		if name == "asn1" && path == "" {
+			path = "github.com/stesla/ldap/asn1"
+		}
+		imports = append(imports, strconv.Quote(path))
+	}
+	sort.Strings(imports)
+	g.printf("%s\n)\n", strings.Join(imports, "\n"))
+	g.buf.Write(body.Bytes())
+	return format.Source(g.buf.Bytes())
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+type kind int
+
+const (
+	reflected kind = iota // encoded and decoded by reflection
+	integer
+	boolean
+	octetString
+	octetStrings
+	rawValue
+	sequence
+	sequences
+)
+
+type options struct {
+	tag                                           int
+	tagged, application, explicit, optional, enum bool
+	private, set, components                      bool
+}
+
+// parseOptions parses an asn1 struct tag as the asn1 package does, and
+// rejects what it would, as well as the default option, which the
+// generated code does not support.
+func parseOptions(s string) (o options, err error) {
+	if s == "" {
+		return
+	}
+	seen := make(map[string]bool)
+	implicit := false
+	for _, part := range strings.Split(s, ",") {
+		key, value, hasValue := strings.Cut(part, ":")
+		if key == "application" {
+			key, value, hasValue = "class", "application", true
+		}
+		if seen[key] {
+			return o, fmt.Errorf("repeated option %q in %q", key, s)
+		} else if hasValue != (key == "tag" || key == "class" || key == "default") {
+			return o, fmt.Errorf("bad option %q in %q", part, s)
+		}
+		seen[key] = true
+		switch key {
+		case "tag":
+			i, err := strconv.Atoi(value)
+			if err != nil || i < 0 {
+				return o, fmt.Errorf("bad tag %q in %q", value, s)
+			}
+			o.tagged, o.tag = true, i
+		case "class":
+			switch value {
+			case "application":
+				o.application = true
+			case "private":
+				o.private = true
+			case "context":
+			default:
+				return o, fmt.Errorf("bad class %q in %q", value, s)
+			}
+			o.tagged = true
+		case "implicit":
+			o.tagged, implicit = true, true
+		case "explicit":
+			o.tagged, o.explicit = true, true
+		case "optional":
+			o.optional = true
+		case "default":
+			return o, fmt.Errorf("default is not supported in %q", s)
+		case "enum":
+			o.enum = true
+		case "set":
+			o.set = true
+		case "components":
+			o.components = true
+		default:
+			return o, fmt.Errorf("unknown option %q in %q", part, s)
+		}
+	}
+	if implicit && o.explicit {
+		return o, fmt.Errorf("implicit and explicit in %q", s)
+	}
+	if o.components && o.tagged {
+		return o, fmt.Errorf("components of a tagged field in %q", s)
+	}
+	return
+}
+
+type field struct {
+	name     string
+	typ      ast.Expr
+	tag      string // the asn1 struct tag
+	opts     options
+	kind     kind
+	elem     ast.Expr // of slices of sequences
+	bitSize  int      // of integers
+	iface    bool     // an interface, decoded into what it holds
+	typeName string
+}
+
+func (g *generator) fields(name string) ([]*field, error) {
+	var fields []*field
+	for _, f := range g.structs[name].Fields.List {
+		tag := ""
+		if f.Tag != nil {
+			s, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(s).Get("asn1")
+		}
+		names := f.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{embeddedName(f.Type)}
+		}
+		for _, n := range names {
+			opts, err := parseOptions(tag)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", name, n.Name, err)
+			}
+			fd := &field{name: n.Name, typ: f.Type, tag: tag, opts: opts}
+			g.classify(fd)
+			if fd.opts.components && (fd.kind != sequence || fd.opts.optional) {
+				return nil, fmt.Errorf("%s.%s: components must be a generated, required struct", name, fd.name)
+			}
+			fields = append(fields, fd)
+		}
+	}
+	return fields, nil
+}
+
+func embeddedName(t ast.Expr) *ast.Ident {
+	switch t := t.(type) {
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel
+	}
+	return t.(*ast.Ident)
+}
+
+func (g *generator) classify(f *field) {
+	if f.opts.explicit {
+		return
+	}
+	switch t := f.typ.(type) {
+	case *ast.Ident:
+		if size, ok := intSizes[t.Name]; ok {
+			f.kind, f.bitSize = integer, size
+		} else if size, ok := g.ints[t.Name]; ok {
+			f.kind, f.bitSize = integer, size
+		} else if t.Name == "bool" {
+			f.kind = boolean
+		} else if g.gen[t.Name] && !f.opts.optional {
+			f.kind = sequence
+		}
+		f.iface = g.ifaces[t.Name]
+	case *ast.SelectorExpr:
+		if isRawValue(t) {
+			if !f.opts.optional {
+				f.kind = rawValue
+			}
+		} else if f.opts.enum {
+			f.kind = integer
+		}
+	case *ast.ArrayType:
+		if t.Len != nil {
+			break
+		}
+		if isByte(t.Elt) {
+			f.kind = octetString
+		} else if elt, ok := t.Elt.(*ast.ArrayType); ok && elt.Len == nil && isByte(elt.Elt) {
+			f.kind = octetStrings
+		} else if elt, ok := t.Elt.(*ast.Ident); ok && g.gen[elt.Name] {
+			f.kind, f.elem = sequences, elt
+		}
+	case *ast.InterfaceType:
+		f.iface = true
+	}
+	if f.kind == integer || f.kind == octetStrings || f.kind == sequences {
+		f.typeName = g.typeString(f.typ)
+	}
+}
+
+func isRawValue(t *ast.SelectorExpr) bool {
+	x, ok := t.X.(*ast.Ident)
+	return ok && x.Name == "asn1" && t.Sel.Name == "RawValue"
+}
+
+func isByte(t ast.Expr) bool {
+	id, ok := t.(*ast.Ident)
+	return ok && (id.Name == "byte" || id.Name == "uint8")
+}
+
+// typeString returns t as written, noting the packages it uses.
+func (g *generator) typeString(t ast.Expr) string {
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		pkg := t.X.(*ast.Ident).Name
+		g.used[pkg] = true
+		return pkg + "." + t.Sel.Name
+	case *ast.ArrayType:
+		return "[]" + g.typeString(t.Elt)
+	case *ast.StarExpr:
+		return "*" + g.typeString(t.X)
+	}
+	panic(fmt.Sprintf("unexpected type %T", t))
+}
+
+// class and tag return the identifier of the field's element.
+func (f *field) class() string {
+	switch {
+	case f.opts.application:
+		return "asn1.ClassApplication"
+	case f.opts.private:
+		return "asn1.ClassPrivate"
+	case f.opts.tagged:
+		return "asn1.ClassContextSpecific"
+	}
+	return "asn1.ClassUniversal"
+}
+
+func (f *field) tagNumber() string {
+	if f.opts.tagged {
+		return strconv.Itoa(f.opts.tag)
+	}
+	switch f.kind {
+	case integer:
+		if f.opts.enum {
+			return "asn1.TagEnumerated"
+		}
+		return "asn1.TagInteger"
+	case boolean:
+		return "asn1.TagBoolean"
+	case octetString:
+		return "asn1.TagOctetString"
+	case octetStrings, sequences:
+		if f.opts.set {
+			return "asn1.TagSet"
+		}
+	}
+	return "asn1.TagSequence"
+}
+
+func (g *generator) marshal(name string, fields []*field) {
+	var body bytes.Buffer
+	usesErr := false
+	for _, f := range fields {
+		v := "x." + f.name
+		c, t := f.class(), f.tagNumber()
+		var code string
+		switch f.kind {
+		case integer:
+			code = fmt.Sprintf("b = asn1.AppendInteger(b, %s, %s, int64(%s))\n", c, t, v)
+			if f.opts.optional {
+				code = fmt.Sprintf("if %s != 0 {\n%s}\n", v, code)
+			}
+		case boolean:
+			code = fmt.Sprintf("b = asn1.AppendBoolean(b, %s, %s, %s)\n", c, t, v)
+			if f.opts.optional {
+				code = fmt.Sprintf("if %s {\n%s}\n", v, code)
+			}
+		case octetString:
+			code = fmt.Sprintf("b = asn1.AppendOctetString(b, %s, %s, %s)\n", c, t, v)
+		case octetStrings:
+			code = fmt.Sprintf("start := len(b)\nfor _, v := range %s {\nb = asn1.AppendOctetString(b, asn1.ClassUniversal, asn1.TagOctetString, v)\n}\nb = asn1.InsertHeader(b, start, %s, %s)\n", v, c, t)
+		case rawValue:
+			if !f.opts.tagged {
+				c, t = v+".Class", v+".Tag"
+			}
+			code = fmt.Sprintf("b = asn1.AppendHeader(b, %s, %s, %s.Constructed, len(%s.Bytes))\nb = append(b, %s.Bytes...)\n", c, t, v, v, v)
+		case sequence:
+			code = fmt.Sprintf("if b, err = %s.MarshalASN1Content(b); err != nil {\nreturn nil, err\n}\n", v)
+			if !f.opts.components {
+				code = fmt.Sprintf("start := len(b)\n%sb = asn1.InsertHeader(b, start, %s, %s)\n", code, c, t)
+			}
+			usesErr = true
+		case sequences:
+			code = fmt.Sprintf("start := len(b)\nfor _, v := range %s {\nn := len(b)\nif b, err = v.MarshalASN1Content(b); err != nil {\nreturn nil, err\n}\nb = asn1.InsertHeader(b, n, asn1.ClassUniversal, asn1.TagSequence)\n}\nb = asn1.InsertHeader(b, start, %s, %s)\n", v, c, t)
+			usesErr = true
+		default:
+			code = fmt.Sprintf("if b, err = asn1.AppendValue(b, %s, %q); err != nil {\nreturn nil, err\n}\n", v, f.tag)
+			usesErr = true
+		}
+		if (f.kind == octetString || f.kind == octetStrings || f.kind == sequences) && f.opts.optional {
+			code = fmt.Sprintf("if %s != nil {\n%s}\n", v, code)
+		} else if strings.HasPrefix(code, "start :=") {
+			code = "{\n" + code + "}\n"
+		}
+		body.WriteString(code)
+	}
+
+	g.printf("\nfunc (x %s) MarshalASN1Content(b []byte) ([]byte, error) {\n", name)
+	if usesErr {
+		g.printf("var err error\n")
+	}
+	g.printf("%sreturn b, nil\n}\n", body.Bytes())
+}
+
+// match returns the condition that the element v is the field's.
+func (f *field) match(v string) string {
+	if f.opts.tagged {
+		if f.opts.application || f.opts.private {
+			return fmt.Sprintf("(%s.Class == %s || %s.Class == asn1.ClassContextSpecific) && %s.Tag == %d", v, f.class(), v, v, f.opts.tag)
+		}
+		return fmt.Sprintf("%s.Class == asn1.ClassContextSpecific && %s.Tag == %d", v, v, f.opts.tag)
+	}
+	universal := func(cond string) string {
+		return fmt.Sprintf("%s.Class == asn1.ClassUniversal && %s", v, cond)
+	}
+	switch f.kind {
+	case integer:
+		return universal(fmt.Sprintf("(%s.Tag == asn1.TagInteger || %s.Tag == asn1.TagEnumerated) && !%s.Constructed", v, v, v))
+	case boolean:
+		return universal(fmt.Sprintf("%s.Tag == asn1.TagBoolean && !%s.Constructed", v, v))
+	case octetString:
+		return universal(fmt.Sprintf("%s.Tag == asn1.TagOctetString && !%s.Constructed", v, v))
+	case octetStrings, sequence, sequences:
+		if f.opts.set {
+			return universal(fmt.Sprintf("(%s.Tag == asn1.TagSequence || %s.Tag == asn1.TagSet) && %s.Constructed", v, v, v))
+		}
+		return universal(fmt.Sprintf("%s.Tag == asn1.TagSequence && %s.Constructed", v, v))
+	}
+	return "true"
+}
+
+// decode returns the statements that decode raw into the field.
+func (f *field) decode(name string) string {
+	v := "x." + f.name
+	mismatch := fmt.Sprintf("return nil, asn1.StructuralError(%q)\n", "tag mismatch in "+name+"."+f.name)
+	switch f.kind {
+	case integer:
+		return fmt.Sprintf("i, err := asn1.ParseInteger(raw.Bytes, %d)\nif err != nil {\nreturn nil, err\n}\n%s = %s(i)\n", f.bitSize, v, f.typeName)
+	case boolean:
+		return fmt.Sprintf("if %s, err = asn1.ParseBoolean(raw.Bytes); err != nil {\nreturn nil, err\n}\n", v)
+	case octetString:
+		return fmt.Sprintf("%s = raw.Bytes\n", v)
+	case rawValue:
+		return fmt.Sprintf("%s = raw\n", v)
+	case sequence:
+		return fmt.Sprintf("if err = %s.UnmarshalASN1Content(raw.Bytes); err != nil {\nreturn nil, err\n}\n", v)
+	case octetStrings, sequences:
+		elem := &field{kind: octetString}
+		add := fmt.Sprintf("%s = append(%s, e.Bytes)\n", v, v)
+		if f.kind == sequences {
+			elem.kind = sequence
+			add = fmt.Sprintf("var s %s\nif err = s.UnmarshalASN1Content(e.Bytes); err != nil {\nreturn nil, err\n}\n%s = append(%s, s)\n", f.elem, v, v)
+		}
+		return fmt.Sprintf("%s = %s{}\nfor c := raw.Bytes; len(c) > 0; {\nvar e asn1.RawValue\nif e, c, err = asn1.ParseRawValue(c); err != nil {\nreturn nil, err\n}\nif !(%s) {\n%s}\n%s}\n",
+			v, f.typeName, elem.match("e"), mismatch, add)
+	}
+	ptr := "&" + v
+	if f.iface {
+		ptr = v
+	}
+	return fmt.Sprintf("if err = asn1.UnmarshalValue(raw.RawBytes, %s, %q); err != nil {\nreturn nil, err\n}\n", ptr, f.tag)
+}
+
+func (g *generator) unmarshal(name string, fields []*field) {
+	g.printf("\nfunc (x *%s) UnmarshalASN1Content(b []byte) error {\n", name)
+	g.printf("b, err := x.unmarshalASN1Fields(b)\nif err == nil && len(b) > 0 {\nerr = asn1.StructuralError(%q)\n}\nreturn err\n}\n", "trailing data in "+name)
+
+	var body bytes.Buffer
+	usesRaw, usesErr := false, false
+	for _, f := range fields {
+		ptr := "&x." + f.name
+		if f.iface {
+			ptr = "x." + f.name
+		}
+		switch {
+		case f.opts.components:
+			fmt.Fprintf(&body, "if b, err = x.%s.unmarshalASN1Fields(b); err != nil {\nreturn nil, err\n}\n", f.name)
+			usesErr = true
+		case f.opts.optional && f.kind == reflected:
+			fmt.Fprintf(&body, "if r, rest, err := asn1.ParseRawValue(b); err == nil && asn1.UnmarshalValue(r.RawBytes, %s, %q) == nil {\nb = rest\n}\n", ptr, f.tag)
+		case f.opts.optional:
+			fmt.Fprintf(&body, "if r, rest, err := asn1.ParseRawValue(b); err == nil && %s {\nraw, b = r, rest\n%s}\n", f.match("r"), f.decode(name))
+			usesRaw = true
+		default:
+			fmt.Fprintf(&body, "if raw, b, err = asn1.ParseRawValue(b); err != nil {\nreturn nil, err\n}\n")
+			if cond := f.match("raw"); cond != "true" {
+				fmt.Fprintf(&body, "if !(%s) {\nreturn nil, asn1.StructuralError(%q)\n}\n", cond, "tag mismatch in "+name+"."+f.name)
+			}
+			decode := f.decode(name)
+			if f.kind == integer {
+				decode = "{\n" + decode + "}\n"
+			}
+			body.WriteString(decode)
+			usesRaw, usesErr = true, true
+		}
+	}
+
+	g.printf("\nfunc (x *%s) unmarshalASN1Fields(b []byte) ([]byte, error) {\n", name)
+	if usesRaw {
+		g.printf("var raw asn1.RawValue\n")
+	}
+	if usesErr {
+		g.printf("var err error\n")
+	}
+	g.printf("%sreturn b, nil\n}\n", body.Bytes())
+}
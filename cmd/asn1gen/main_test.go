@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var generateLine = regexp.MustCompile(`//go:generate go run \S+ -type (\S+)(?: -output (\S+))?`)
+
+// TestGeneratedFiles checks that the generated files of the repository
+// are what asn1gen now generates.
+func TestGeneratedFiles(t *testing.T) {
+	tests := []struct{ dir, file string }{
+		{"../..", "ldap.go"},
+		{"../../server", "protocol.go"},
+	}
+	for _, test := range tests {
+		src, err := os.ReadFile(filepath.Join(test.dir, test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := generateLine.FindSubmatch(src)
+		if m == nil {
+			t.Fatalf("%s: no go:generate line", test.file)
+		}
+		g, err := newGenerator(test.dir, string(m[2]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := g.generate(strings.Split(string(m[1]), ","))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := os.ReadFile(filepath.Join(test.dir, g.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("%s is out of date; run go generate in %s", g.output, test.dir)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"", true},
+		{"tag:0,implicit,optional", true},
+		{"application,tag:3", true},
+		{"tag:1,class:private", true},
+		{"optinal", false},
+		{"tag:x", false},
+		{"tag:0,implicit,explicit", false},
+		{"tag:1,tag:2", false},
+		{"default:3", false},
+	}
+	for i, test := range tests {
+		if _, err := parseOptions(test.in); (err == nil) != test.ok {
+			t.Errorf("#%d: %q: Incorrect error result (passed? %v, expected %v): %v", i, test.in, err == nil, test.ok, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldif"
+	"strconv"
+	"strings"
+)
+
+var scopes = map[string]ldap.SearchScope{
+	"base": ldap.BaseObject,
+	"one":  ldap.SingleLevel,
+	"sub":  ldap.WholeSubtree,
+}
+
+var derefs = map[string]ldap.DerefAliases{
+	"never":  ldap.NeverDerefAliases,
+	"search": ldap.DerefInSearching,
+	"find":   ldap.DerefFindingBaseObj,
+	"always": ldap.DerefAlways,
+}
+
+func search(c *cli, args []string) error {
+	base := c.flags.String("b", "", "`searchbase` to start from")
+	scope := c.flags.String("s", "sub", "`scope` of the search: base, one or sub")
+	deref := c.flags.String("a", "never", "how to `deref` aliases: never, search, find or always")
+	sizeLimit := c.flags.Int("z", 0, "`sizelimit` of the search")
+	timeLimit := c.flags.Int("l", 0, "`timelimit` of the search, in seconds")
+	typesOnly := c.flags.Bool("A", false, "retrieve attribute names only")
+	extension := c.flags.String("E", "", "search `extension`: pr=<size>[/noprompt] for paged results")
+	c.flags.Bool("L", false, "write LDIF (always done)")
+	c.flags.Bool("LL", false, "write LDIF without comments (always done)")
+	noVersion := c.flags.Bool("LLL", false, "write LDIF without comments or version")
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+
+	req := ldap.SearchRequest{
+		BaseObject: []byte(*base),
+		SizeLimit:  *sizeLimit,
+		TimeLimit:  *timeLimit,
+		TypesOnly:  *typesOnly,
+	}
+	var ok bool
+	if req.Scope, ok = scopes[*scope]; !ok {
+		return fmt.Errorf("invalid scope %q", *scope)
+	}
+	if req.Deref, ok = derefs[*deref]; !ok {
+		return fmt.Errorf("invalid alias dereferencing %q", *deref)
+	}
+	filter := "(objectClass=*)"
+	if c.flags.NArg() > 0 {
+		filter = c.flags.Arg(0)
+	}
+	var err error
+	if req.Filter, err = ldap.CompileFilter(filter); err != nil {
+		return err
+	}
+	if c.flags.NArg() > 1 {
+		for _, a := range c.flags.Args()[1:] {
+			req.Attributes = append(req.Attributes, []byte(a))
+		}
+	}
+	pageSize := 0
+	if *extension != "" {
+		size := strings.TrimSuffix(strings.TrimPrefix(*extension, "pr="), "/noprompt")
+		if pageSize, err = strconv.Atoi(size); err != nil || !strings.HasPrefix(*extension, "pr=") || pageSize < 1 {
+			return fmt.Errorf("unsupported search extension %q", *extension)
+		}
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	w := ldif.NewWriter(c.stdout)
+	if *noVersion {
+		w.Version = 0
+	}
+	if pageSize > 0 {
+		results, err := conn.SearchWithPaging(req, pageSize)
+		for _, r := range results {
+			if err := w.WriteEntry(r.Entry()); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	_, err = conn.SearchFunc(req, func(r ldap.SearchResult, _ []ldap.Control) error {
+		return w.WriteEntry(r.Entry())
+	})
+	return err
+}
+
+func add(c *cli, args []string) error {
+	addContent := true
+	return apply(c, args, &addContent)
+}
+
+func modify(c *cli, args []string) error {
+	return apply(c, args, c.flags.Bool("a", false, "add the entries of content records"))
+}
+
+// applied describes an applied record, as ldapmodify does.
+var applied = map[ldif.ChangeType]string{
+	ldif.NoChange: "adding new entry",
+	ldif.Add:      "adding new entry",
+	ldif.Delete:   "deleting entry",
+	ldif.Modify:   "modifying entry",
+	ldif.ModRDN:   "modifying rdn of entry",
+	ldif.ModDN:    "modifying rdn of entry",
+}
+
+// apply applies LDIF records, like ldapmodify, adding the entries of
+// content records if *addContent is set once the flags are parsed.
+func apply(c *cli, args []string, addContent *bool) error {
+	file := c.flags.String("f", "", "read LDIF from `file` instead of standard input")
+	continueOnError := c.flags.Bool("c", false, "continue after errors")
+	dryRun := c.flags.Bool("n", false, "check the records without applying them")
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+
+	in, err := c.input(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = ldif.Apply(conn, ldif.NewReader(in), ldif.ApplyOptions{
+		ContinueOnError:   *continueOnError,
+		DryRun:            *dryRun,
+		AddContentRecords: *addContent,
+		OnRecord: func(rec *ldif.Record, err error) {
+			if err == nil {
+				fmt.Fprintf(c.stdout, "%s %q\n", applied[rec.ChangeType], rec.DN)
+			}
+		},
+	})
+	if errs, ok := err.(ldif.ApplyErrors); ok {
+		for _, e := range errs[:len(errs)-1] {
+			fmt.Fprintf(c.stderr, "ldapcli: %v\n", e)
+		}
+		return errs[len(errs)-1]
+	}
+	return err
+}
+
+func del(c *cli, args []string) error {
+	file := c.flags.String("f", "", "read DNs from `file` instead of the arguments or standard input")
+	continueOnError := c.flags.Bool("c", false, "continue after errors")
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	dns := c.flags.Args()
+	if len(dns) == 0 || *file != "" {
+		in, err := c.input(*file)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		s := bufio.NewScanner(in)
+		for s.Scan() {
+			if dn := strings.TrimSpace(s.Text()); dn != "" {
+				dns = append(dns, dn)
+			}
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var last error
+	for _, dn := range dns {
+		if err := conn.Del(dn); err != nil {
+			if !*continueOnError {
+				return fmt.Errorf("delete %q: %w", dn, err)
+			}
+			if last != nil {
+				fmt.Fprintf(c.stderr, "ldapcli: %v\n", last)
+			}
+			last = fmt.Errorf("delete %q: %w", dn, err)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "deleting entry %q\n", dn)
+	}
+	return last
+}
+
+func whoami(c *cli, args []string) error {
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	id, err := conn.WhoAmI()
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		id = "anonymous"
+	}
+	fmt.Fprintln(c.stdout, id)
+	return nil
+}
+
+func passwd(c *cli, args []string) error {
+	oldPassword := c.flags.String("a", "", "the old `passwd`")
+	newPassword := c.flags.String("s", "", "the new `passwd`; the server generates one if omitted")
+	if err := c.flags.Parse(args); err != nil {
+		return err
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	generated, err := conn.PasswordModify(c.flags.Arg(0), *oldPassword, *newPassword)
+	if err != nil {
+		return err
+	}
+	if generated != "" {
+		fmt.Fprintf(c.stdout, "New password: %s\n", generated)
+	}
+	return nil
+}
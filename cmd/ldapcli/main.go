@@ -0,0 +1,145 @@
+// Command ldapcli searches and updates an LDAP directory. Its
+// subcommands take the common flags of the OpenLDAP tools:
+//
+//	ldapcli search [-H uri] [-D binddn] [-w passwd] [-b base] [-s scope] [filter [attrs...]]
+//	ldapcli add [-H uri] [-D binddn] [-w passwd] [-c] [-n] [-f file]
+//	ldapcli modify [-H uri] [-D binddn] [-w passwd] [-a] [-c] [-n] [-f file]
+//	ldapcli delete [-H uri] [-D binddn] [-w passwd] [-c] [-f file] [dn...]
+//	ldapcli whoami [-H uri] [-D binddn] [-w passwd]
+//	ldapcli passwd [-H uri] [-D binddn] [-w passwd] [-a oldpasswd] [-s newpasswd] [user]
+//
+// Entries are read and written as LDIF. A failed operation exits with
+// its LDAP result code, and other errors with 1.
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/stesla/ldap"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+type command struct {
+	run   func(c *cli, args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"search": {search, "search the directory, writing the entries found as LDIF"},
+	"add":    {add, "add the entries of LDIF content or change records"},
+	"modify": {modify, "apply LDIF change records"},
+	"delete": {del, "delete entries, named as arguments or one per line of input"},
+	"whoami": {whoami, "print the authorization identity of the connection"},
+	"passwd": {passwd, "change a password with the Password Modify operation"},
+}
+
+// A cli holds the state of a subcommand: its flags, and the standard
+// streams it uses.
+type cli struct {
+	flags  *flag.FlagSet
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	uri          string
+	bindDN       string
+	password     string
+	passwordFile string
+	startTLS     bool
+	requireTLS   bool
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || commands[args[0]].run == nil {
+		fmt.Fprintln(stderr, "usage: ldapcli <command> [flags] [args]")
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stderr, "  %-8s %s\n", name, commands[name].usage)
+		}
+		return 2
+	}
+	cmd := commands[args[0]]
+
+	c := &cli{flags: flag.NewFlagSet("ldapcli "+args[0], flag.ContinueOnError), stdin: stdin, stdout: stdout, stderr: stderr}
+	c.flags.SetOutput(stderr)
+	c.flags.StringVar(&c.uri, "H", "ldap://localhost", "LDAP `uri` of the server")
+	c.flags.StringVar(&c.bindDN, "D", "", "`binddn` to bind as")
+	c.flags.StringVar(&c.password, "w", "", "`passwd` to bind with")
+	c.flags.StringVar(&c.passwordFile, "y", "", "read the bind password from `file`")
+	c.flags.BoolVar(&c.startTLS, "Z", false, "try StartTLS")
+	c.flags.BoolVar(&c.requireTLS, "ZZ", false, "require StartTLS to succeed")
+	c.flags.Bool("x", true, "use simple authentication (the only kind supported)")
+
+	if err := cmd.run(c, args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(stderr, "ldapcli: %v\n", err)
+		var e *ldap.Error
+		if errors.As(err, &e) && e.ResultCode != ldap.Success {
+			return int(e.ResultCode)
+		}
+		return 1
+	}
+	return 0
+}
+
+// connect dials the server and binds, if a DN or password was given.
+func (c *cli) connect() (ldap.Conn, error) {
+	u, err := url.Parse(c.uri)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := ldap.DialURL(c.uri, &tls.Config{ServerName: u.Hostname()})
+	if err != nil {
+		return nil, err
+	}
+	if c.startTLS || c.requireTLS {
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			if c.requireTLS {
+				conn.Close()
+				return nil, fmt.Errorf("StartTLS: %w", err)
+			}
+			fmt.Fprintf(c.stderr, "ldapcli: StartTLS: %v (continuing)\n", err)
+		}
+	}
+
+	password := c.password
+	if c.passwordFile != "" {
+		b, err := os.ReadFile(c.passwordFile)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		password = strings.TrimRight(string(b), "\r\n")
+	}
+	if c.bindDN != "" || password != "" {
+		if err := conn.Bind(c.bindDN, password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// input returns the named file, or standard input if name is empty.
+func (c *cli) input(name string) (io.ReadCloser, error) {
+	if name == "" {
+		return io.NopCloser(c.stdin), nil
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldaptest"
+	"strings"
+	"testing"
+)
+
+const fixture = `dn: dc=example,dc=com
+objectClass: domain
+dc: example
+
+dn: ou=People,dc=example,dc=com
+objectClass: organizationalUnit
+ou: People
+
+dn: cn=Alice,ou=People,dc=example,dc=com
+objectClass: person
+cn: Alice
+sn: Smith
+userPassword: alice
+`
+
+func runCLI(t *testing.T, stdin string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestCLI(t *testing.T) {
+	s := ldaptest.StartServer(t)
+	s.Load(fixture)
+	admin := []string{"-H", s.URL, "-x", "-D", ldaptest.AdminDN, "-w", ldaptest.AdminPassword}
+	alice := []string{"-H", s.URL, "-D", "cn=Alice,ou=People,dc=example,dc=com", "-w", "alice"}
+	args := func(cmd string, common []string, rest ...string) []string {
+		return append(append([]string{cmd}, common...), rest...)
+	}
+
+	tests := []struct {
+		args   []string
+		stdin  string
+		stdout string
+		code   int
+	}{
+		{args("search", alice, "-LLL", "-b", "dc=example,dc=com", "(cn=Alice)", "sn"), "",
+			"dn: cn=Alice,ou=People,dc=example,dc=com\nsn: Smith\n", 0},
+		{args("search", alice, "-b", "ou=People,dc=example,dc=com", "-s", "base", "-E", "pr=1/noprompt", "(objectClass=*)", "ou"), "",
+			"version: 1\ndn: ou=People,dc=example,dc=com\nou: People\n", 0},
+		{args("search", alice, "-b", "ou=Nowhere,dc=example,dc=com"), "", "", int(ldap.NoSuchObject)},
+		{args("add", admin), "dn: cn=Bob,ou=People,dc=example,dc=com\nobjectClass: person\ncn: Bob\nsn: Brown\n",
+			"adding new entry \"cn=Bob,ou=People,dc=example,dc=com\"\n", 0},
+		{args("modify", admin), "dn: cn=Bob,ou=People,dc=example,dc=com\nchangetype: modify\nreplace: sn\nsn: Jones\n-\n",
+			"modifying entry \"cn=Bob,ou=People,dc=example,dc=com\"\n", 0},
+		{args("modify", admin), "dn: cn=Carol,ou=People,dc=example,dc=com\nobjectClass: person\ncn: Carol\n",
+			"", 1},
+		{args("search", alice, "-LLL", "-b", "dc=example,dc=com", "(sn=Jones)", "cn"), "",
+			"dn: cn=Bob,ou=People,dc=example,dc=com\ncn: Bob\n", 0},
+		{args("delete", admin, "-c", "cn=Nobody,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"), "",
+			"deleting entry \"cn=Bob,ou=People,dc=example,dc=com\"\n", int(ldap.NoSuchObject)},
+		{args("whoami", alice), "", "dn:cn=Alice,ou=People,dc=example,dc=com\n", 0},
+		{args("whoami", []string{"-H", s.URL}), "", "anonymous\n", 0},
+		{args("passwd", alice, "-a", "alice", "-s", "changed"), "", "", 0},
+		{args("whoami", alice), "", "", int(ldap.InvalidCredentials)},
+		{args("search", admin, "-s", "everything"), "", "", 1},
+		{[]string{"frobnicate"}, "", "", 2},
+	}
+	for i, test := range tests {
+		stdout, stderr, code := runCLI(t, test.stdin, test.args...)
+		if stdout != test.stdout || code != test.code {
+			t.Errorf("#%d: Bad result: %q, %d (expected %q, %d); stderr: %s", i, stdout, code, test.stdout, test.code, stderr)
+		}
+	}
+
+	stdout, _, code := runCLI(t, "", args("passwd", admin, "cn=Alice,ou=People,dc=example,dc=com")...)
+	generated := strings.TrimPrefix(strings.TrimSpace(stdout), "New password: ")
+	if code != 0 || generated == "" || generated == stdout {
+		t.Fatalf("Bad passwd result: %q, %d", stdout, code)
+	}
+	alice[len(alice)-1] = generated
+	if stdout, _, code := runCLI(t, "", args("whoami", alice)...); code != 0 {
+		t.Errorf("Bad result with generated password: %q, %d", stdout, code)
+	}
+}
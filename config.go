@@ -0,0 +1,403 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ReferralPolicy int
+
+const (
+	// ReturnReferrals returns a referral to the caller as an *Error
+	// with ResultCode Referral.
+	ReturnReferrals ReferralPolicy = iota
+	// FollowReferrals repeats an operation against the server a
+	// referral names, binding to it with the same credentials.
+	FollowReferrals
+)
+
+const DefaultMaxReferralHops = 5
+
+// A Config describes how to connect to a directory, so that a client
+// can be set up from a configuration file and Connect.
+type Config struct {
+	// URLs lists equivalent servers as ldap://, ldaps:// or ldapi://
+	// URLs, tried according to Strategy.
+	URLs     []string
+	Strategy Strategy
+	// TLSConfig is used for ldaps:// URLs and StartTLS. If it is nil,
+	// one is built with NewTLSConfig from the TLS options below.
+	TLSConfig             *tls.Config
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+	// TLSPins are the certificate pins of TLSOptions.
+	TLSPins map[string][]string
+	// StartTLS upgrades ldap:// connections to TLS before binding.
+	StartTLS bool
+
+	// BindDN and BindPassword are used for a simple bind. Leave both
+	// empty, and SASLMechanism too, to stay anonymous.
+	BindDN       string
+	BindPassword string
+	// SASLMechanism selects a SASL bind instead: "EXTERNAL", "PLAIN"
+	// or "DIGEST-MD5". PLAIN and DIGEST-MD5 authenticate SASLUsername
+	// with BindPassword.
+	SASLMechanism string
+	SASLUsername  string
+	SASLAuthzID   string
+	SASLRealm     string
+	// SASLHost is the server name used by DIGEST-MD5. It defaults to
+	// the host of the first URL.
+	SASLHost string
+
+	// Proxy, if set, is the URL of a SOCKS5 or HTTP proxy to connect
+	// through. See ProxyDialer.
+	Proxy string
+
+	ConnectTimeout time.Duration
+	FallbackDelay  time.Duration
+	Timeout        time.Duration
+	IdleTimeout    time.Duration
+	KeepAlive      time.Duration
+
+	PoolMinConns        int
+	PoolMaxConns        int
+	HealthCheckInterval time.Duration
+
+	Referrals ReferralPolicy
+	// MaxReferralHops limits the referrals followed for one operation.
+	// It defaults to DefaultMaxReferralHops.
+	MaxReferralHops int
+}
+
+// Validate reports the first problem with cfg, if any.
+func (cfg *Config) Validate() error {
+	if len(cfg.URLs) == 0 {
+		return fmt.Errorf("ldap: no URLs configured")
+	}
+	for _, u := range cfg.URLs {
+		network, _, secure, err := parseURL(u)
+		if err != nil {
+			return fmt.Errorf("ldap: bad URL %q: %v", u, err)
+		}
+		if cfg.StartTLS && (secure || network == "unix") {
+			return fmt.Errorf("ldap: StartTLS cannot be used with %q", u)
+		}
+	}
+	if cfg.Proxy != "" {
+		if _, err := ProxyDialer(cfg.Proxy); err != nil {
+			return err
+		}
+	}
+	if cfg.TLSConfig != nil && (cfg.TLSCAFile != "" || cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" || cfg.TLSInsecureSkipVerify || len(cfg.TLSPins) > 0) {
+		return fmt.Errorf("ldap: TLSConfig cannot be combined with other TLS options")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("ldap: TLSCertFile and TLSKeyFile must be given together")
+	}
+	switch strings.ToUpper(cfg.SASLMechanism) {
+	case "":
+		if cfg.BindPassword != "" && cfg.BindDN == "" {
+			return fmt.Errorf("ldap: BindPassword given without BindDN")
+		}
+	case "EXTERNAL":
+		if cfg.BindDN != "" {
+			return fmt.Errorf("ldap: BindDN cannot be used with SASLMechanism")
+		}
+	case "PLAIN", "DIGEST-MD5":
+		if cfg.BindDN != "" {
+			return fmt.Errorf("ldap: BindDN cannot be used with SASLMechanism")
+		}
+		if cfg.SASLUsername == "" || cfg.BindPassword == "" {
+			return fmt.Errorf("ldap: %s requires SASLUsername and BindPassword", cfg.SASLMechanism)
+		}
+	default:
+		return fmt.Errorf("ldap: unsupported SASL mechanism %q", cfg.SASLMechanism)
+	}
+	durations := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"ConnectTimeout", cfg.ConnectTimeout},
+		{"Timeout", cfg.Timeout},
+		{"IdleTimeout", cfg.IdleTimeout},
+		{"HealthCheckInterval", cfg.HealthCheckInterval},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			return fmt.Errorf("ldap: %s is negative", d.name)
+		}
+	}
+	if cfg.PoolMinConns < 0 || cfg.PoolMaxConns < 0 || cfg.MaxReferralHops < 0 {
+		return fmt.Errorf("ldap: pool sizes and MaxReferralHops must not be negative")
+	}
+	if cfg.PoolMaxConns > 0 && cfg.PoolMinConns > cfg.PoolMaxConns {
+		return fmt.Errorf("ldap: PoolMinConns (%d) exceeds PoolMaxConns (%d)", cfg.PoolMinConns, cfg.PoolMaxConns)
+	}
+	if cfg.Referrals != ReturnReferrals && cfg.Referrals != FollowReferrals {
+		return fmt.Errorf("ldap: unknown referral policy %d", cfg.Referrals)
+	}
+	return nil
+}
+
+// Connect validates cfg and returns a pool of connections to the
+// servers it names, bound as it says. ctx bounds the connections opened
+// before Connect returns, at least one of which is opened to check the
+// configuration; later dials are bounded by ConnectTimeout alone.
+func Connect(ctx context.Context, cfg *Config) (*Pool, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	d := &configDialer{cfg: cfg, tlsConfig: cfg.TLSConfig, ctx: ctx}
+	if d.tlsConfig == nil {
+		var err error
+		d.tlsConfig, err = NewTLSConfig(TLSOptions{
+			CAFile:             cfg.TLSCAFile,
+			CertFile:           cfg.TLSCertFile,
+			KeyFile:            cfg.TLSKeyFile,
+			InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+			Pins:               cfg.TLSPins,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Proxy != "" {
+		d.proxy, _ = ProxyDialer(cfg.Proxy)
+	}
+	set := &ServerSet{
+		URLs:      cfg.URLs,
+		Strategy:  cfg.Strategy,
+		TLSConfig: d.tlsConfig,
+		DialURL: func(rawurl string, _ *tls.Config) (Conn, error) {
+			return d.dialURL(rawurl)
+		},
+	}
+	pool, err := NewPool(set.Dial, PoolOptions{
+		MinConns:            cfg.PoolMinConns,
+		MaxConns:            cfg.PoolMaxConns,
+		Bind:                cfg.bind,
+		HealthCheckInterval: cfg.HealthCheckInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PoolMinConns == 0 {
+		c, err := pool.Get()
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.Put(c)
+	}
+	d.mu.Lock()
+	d.ctx = context.Background()
+	d.mu.Unlock()
+	return pool, nil
+}
+
+type configDialer struct {
+	cfg       *Config
+	tlsConfig *tls.Config
+	proxy     func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+func (d *configDialer) dialURL(rawurl string) (Conn, error) {
+	d.mu.Lock()
+	ctx := d.ctx
+	d.mu.Unlock()
+
+	cfg := d.cfg
+	c, err := DialWithOpts(ctx, rawurl, DialOpts{
+		ConnectTimeout: cfg.ConnectTimeout,
+		FallbackDelay:  cfg.FallbackDelay,
+		KeepAlive:      cfg.KeepAlive,
+		Timeout:        cfg.Timeout,
+		IdleTimeout:    cfg.IdleTimeout,
+		TLSConfig:      d.tlsConfig,
+		DialContext:    d.proxy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StartTLS {
+		_, addr, _, _ := parseURL(rawurl)
+		if err := c.WithContext(ctx).StartTLS(tlsConfigFor(d.tlsConfig, addr)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if cfg.Referrals == FollowReferrals {
+		c = &referralConn{Conn: c, dial: d.dialReferral, hops: cfg.maxReferralHops()}
+	}
+	return c, nil
+}
+
+func (d *configDialer) dialReferral(rawurl string) (Conn, error) {
+	c, err := d.dialURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.cfg.bind(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (cfg *Config) maxReferralHops() int {
+	if cfg.MaxReferralHops > 0 {
+		return cfg.MaxReferralHops
+	}
+	return DefaultMaxReferralHops
+}
+
+func (cfg *Config) bind(c Conn) error {
+	switch strings.ToUpper(cfg.SASLMechanism) {
+	case "EXTERNAL":
+		return c.SASLBind(&SASLExternal{AuthzID: cfg.SASLAuthzID})
+	case "PLAIN":
+		return c.SASLBind(&SASLPlain{AuthzID: cfg.SASLAuthzID, Username: cfg.SASLUsername, Password: cfg.BindPassword})
+	case "DIGEST-MD5":
+		return c.SASLBind(&SASLDigestMD5{
+			AuthzID:  cfg.SASLAuthzID,
+			Username: cfg.SASLUsername,
+			Password: cfg.BindPassword,
+			Realm:    cfg.SASLRealm,
+			Host:     cfg.saslHost(),
+		})
+	}
+	if cfg.BindDN == "" {
+		return nil
+	}
+	return c.Bind(cfg.BindDN, cfg.BindPassword)
+}
+
+func (cfg *Config) saslHost() string {
+	if cfg.SASLHost != "" {
+		return cfg.SASLHost
+	}
+	if u, err := url.Parse(cfg.URLs[0]); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// A referralConn repeats operations that return a referral against the
+// server it names, up to hops times.
+type referralConn struct {
+	Conn
+	ctx  context.Context
+	dial func(rawurl string) (Conn, error)
+	hops int
+}
+
+// chase calls op with the connection and dn, and again for each
+// referral returned, with the DN of the referral URL if it has one.
+func (c *referralConn) chase(dn string, op func(Conn, string) error) error {
+	err := op(c.Conn, dn)
+	for hop := 0; hop < c.hops; hop++ {
+		e, ok := err.(*Error)
+		if !ok || e.ResultCode != Referral || len(e.Referrals) == 0 {
+			return err
+		}
+		rawurl := e.Referrals[0]
+		rc, derr := c.dial(rawurl)
+		if derr != nil {
+			return derr
+		}
+		if target := referralDN(rawurl); target != "" {
+			dn = target
+		}
+		if c.ctx != nil {
+			err = op(rc.WithContext(c.ctx), dn)
+		} else {
+			err = op(rc, dn)
+		}
+		rc.Close()
+	}
+	return err
+}
+
+// referralDN returns the DN of an LDAP URL (RFC 4516), or "" if it has
+// none.
+func referralDN(rawurl string) string {
+	i := strings.Index(rawurl, "://")
+	if i < 0 {
+		return ""
+	}
+	rest := rawurl[i+3:]
+	j := strings.IndexByte(rest, '/')
+	if j < 0 {
+		return ""
+	}
+	rest = rest[j+1:]
+	if k := strings.IndexByte(rest, '?'); k >= 0 {
+		rest = rest[:k]
+	}
+	dn, err := url.PathUnescape(rest)
+	if err != nil {
+		return ""
+	}
+	return dn
+}
+
+func (c *referralConn) Search(req SearchRequest) (out []SearchResult, err error) {
+	err = c.chase(string(req.BaseObject), func(conn Conn, dn string) error {
+		req.BaseObject = []byte(dn)
+		out, err = conn.Search(req)
+		return err
+	})
+	return
+}
+
+func (c *referralConn) SearchIter(req SearchRequest, pageSize int) *SearchIterator {
+	return searchIter(c, req, pageSize)
+}
+
+func (c *referralConn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	return c.chase(dn, func(conn Conn, dn string) error {
+		return conn.Add(dn, attrs, controls...)
+	})
+}
+
+func (c *referralConn) Modify(dn string, mods []Modification, controls ...Control) error {
+	return c.chase(dn, func(conn Conn, dn string) error {
+		return conn.Modify(dn, mods, controls...)
+	})
+}
+
+func (c *referralConn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error {
+	return c.chase(dn, func(conn Conn, dn string) error {
+		return conn.ModifyDN(dn, newRDN, deleteOldRDN, newSuperior, controls...)
+	})
+}
+
+func (c *referralConn) Del(dn string, controls ...Control) error {
+	return c.chase(dn, func(conn Conn, dn string) error {
+		return conn.Del(dn, controls...)
+	})
+}
+
+func (c *referralConn) Compare(dn, attr, value string, controls ...Control) (ok bool, err error) {
+	err = c.chase(dn, func(conn Conn, dn string) error {
+		ok, err = conn.Compare(dn, attr, value, controls...)
+		return err
+	})
+	return
+}
+
+func (c *referralConn) WithContext(ctx context.Context) Conn {
+	return &referralConn{Conn: c.Conn.WithContext(ctx), ctx: ctx, dial: c.dial, hops: c.hops}
+}
@@ -0,0 +1,112 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	urls := []string{"ldap://a", "ldaps://b"}
+	tests := []struct {
+		cfg Config
+		err string
+	}{
+		{Config{URLs: urls}, ""},
+		{Config{URLs: urls, BindDN: "cn=admin", BindPassword: "secret"}, ""},
+		{Config{URLs: urls, SASLMechanism: "external"}, ""},
+		{Config{URLs: urls, SASLMechanism: "PLAIN", SASLUsername: "u", BindPassword: "p"}, ""},
+		{Config{URLs: []string{"ldap://a"}, StartTLS: true}, ""},
+		{Config{}, "no URLs"},
+		{Config{URLs: []string{"http://a"}}, "bad URL"},
+		{Config{URLs: urls, StartTLS: true}, "StartTLS"},
+		{Config{URLs: urls, BindPassword: "secret"}, "without BindDN"},
+		{Config{URLs: urls, BindDN: "cn=admin", SASLMechanism: "EXTERNAL"}, "BindDN cannot"},
+		{Config{URLs: urls, SASLMechanism: "DIGEST-MD5", SASLUsername: "u"}, "requires"},
+		{Config{URLs: urls, SASLMechanism: "GSSAPI"}, "unsupported"},
+		{Config{URLs: urls, Timeout: -1}, "Timeout is negative"},
+		{Config{URLs: urls, PoolMinConns: 3, PoolMaxConns: 2}, "exceeds"},
+		{Config{URLs: urls, Referrals: 2}, "referral policy"},
+		{Config{URLs: urls, TLSConfig: &tls.Config{}, TLSCAFile: "ca.pem"}, "cannot be combined"},
+		{Config{URLs: urls, TLSCertFile: "client.pem"}, "together"},
+		{Config{URLs: urls, Proxy: "ftp://proxy"}, "proxy scheme"},
+	}
+	for i, test := range tests {
+		err := test.cfg.Validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("#%d: Bad result: %v (expected %q)", i, err, test.err)
+		}
+	}
+}
+
+func TestReferralDN(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"ldap://b", ""},
+		{"ldap://b/", ""},
+		{"ldap://b/ou=people,dc=example,dc=com", "ou=people,dc=example,dc=com"},
+		{"ldap://b/cn=a%20b,dc=com??sub", "cn=a b,dc=com"},
+	}
+	for i, test := range tests {
+		if out := referralDN(test.in); out != test.out {
+			t.Errorf("#%d: Bad result: %q (expected %q)", i, out, test.out)
+		}
+	}
+}
+
+// referringConn refers every delete to its referral, if any.
+type referringConn struct {
+	Conn
+	referral string
+	deleted  []string
+	closed   bool
+}
+
+func (c *referringConn) Del(dn string, controls ...Control) error {
+	if c.referral != "" {
+		return &Error{ResultCode: Referral, Referrals: []string{c.referral}}
+	}
+	c.deleted = append(c.deleted, dn)
+	return nil
+}
+
+func (c *referringConn) Close() error { c.closed = true; return nil }
+
+func TestReferralConn(t *testing.T) {
+	servers := map[string]*referringConn{
+		"ldap://b/cn=x,dc=b": {referral: "ldap://c"},
+		"ldap://c":           {},
+		"ldap://loop":        {referral: "ldap://loop"},
+	}
+	var dialed []string
+	dial := func(rawurl string) (Conn, error) {
+		dialed = append(dialed, rawurl)
+		return servers[rawurl], nil
+	}
+
+	c := &referralConn{Conn: &referringConn{referral: "ldap://b/cn=x,dc=b"}, dial: dial, hops: 3}
+	if err := c.Del("cn=x,dc=a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"ldap://b/cn=x,dc=b", "ldap://c"}; !reflect.DeepEqual(dialed, expected) {
+		t.Errorf("Bad result: %v (expected %v)", dialed, expected)
+	}
+	if deleted := servers["ldap://c"].deleted; !reflect.DeepEqual(deleted, []string{"cn=x,dc=b"}) {
+		t.Errorf("Bad result: %v (expected %v)", deleted, []string{"cn=x,dc=b"})
+	}
+	if !servers["ldap://b/cn=x,dc=b"].closed || !servers["ldap://c"].closed {
+		t.Errorf("Referred connections were not closed")
+	}
+
+	dialed = nil
+	c = &referralConn{Conn: &referringConn{referral: "ldap://loop"}, dial: dial, hops: 3}
+	err := c.Del("cn=x,dc=a")
+	if e, ok := err.(*Error); !ok || e.ResultCode != Referral {
+		t.Errorf("Bad result: %v (expected referral)", err)
+	}
+	if len(dialed) != 3 {
+		t.Errorf("Bad result: %d dials (expected 3)", len(dialed))
+	}
+}
@@ -0,0 +1,314 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReadYourWrites gives read-your-writes consistency across the servers
+// of a replicated directory. Writes made through one of its views record
+// the change marker of the entry they changed, read back from the server
+// that made them; reads through any view wait until the server they go
+// to has the changes in their scope, so that a client writing to one
+// server and reading from another sees its own writes.
+//
+// Markers are compared as numbers if both are, or else as strings, which
+// orders entryCSN values. A change is tracked for Retention, since one
+// server having it says nothing of the others; until then every read in
+// its scope checks that its server has it.
+type ReadYourWrites struct {
+	// Attribute is the operational attribute that marks changes to an
+	// entry. It defaults to "entryCSN". Active Directory's uSNChanged is
+	// local to each domain controller, so only orders the changes seen
+	// through one.
+	Attribute string
+	// Timeout limits how long a read waits, failing with ErrTimeout. It
+	// defaults to 5 seconds.
+	Timeout time.Duration
+	// RetryInterval is how long a read waits between checks. It
+	// defaults to 100 milliseconds.
+	RetryInterval time.Duration
+	// Retention is how long a write is tracked, after which it is
+	// assumed to have reached every server. It defaults to 1 minute.
+	Retention time.Duration
+
+	mu     sync.Mutex
+	writes map[string]*trackedWrite // by normalized DN
+}
+
+type trackedWrite struct {
+	dn         string
+	components []string
+	marker     string // "" once deleted
+	at         time.Time
+}
+
+// Conn returns a view of conn whose writes are tracked, and whose reads
+// wait for the tracked writes in their scope.
+func (r *ReadYourWrites) Conn(conn Conn) Conn {
+	return &consistentConn{conn, r, context.Background()}
+}
+
+func (r *ReadYourWrites) attribute() string {
+	if r.Attribute == "" {
+		return "entryCSN"
+	}
+	return r.Attribute
+}
+
+// record reads the marker of dn back through conn, which made a change
+// to it, and tracks it. An entry without the marker is not tracked.
+func (r *ReadYourWrites) record(conn Conn, dn string) {
+	results, err := conn.Search(SearchRequest{
+		BaseObject: []byte(dn),
+		Scope:      BaseObject,
+		Filter:     Present("objectClass"),
+		Attributes: [][]byte{[]byte(r.attribute())},
+	})
+	if err != nil || len(results) != 1 {
+		return
+	}
+	if marker := results[0].Entry().GetAttributeValue(r.attribute()); marker != "" {
+		r.track(dn, marker)
+	}
+}
+
+// track records that dn has the given marker, or was deleted if it is
+// "".
+func (r *ReadYourWrites) track(dn, marker string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writes == nil {
+		r.writes = map[string]*trackedWrite{}
+	}
+	r.writes[normalizeDN(dn)] = &trackedWrite{dn, dnComponents(dn), marker, time.Now()}
+}
+
+// wait waits until conn shows the tracked writes in scope of base, or
+// ctx is done.
+func (r *ReadYourWrites) wait(ctx context.Context, conn Conn, base string, scope SearchScope) error {
+	retention := r.Retention
+	if retention == 0 {
+		retention = time.Minute
+	}
+	components := dnComponents(base)
+	now := time.Now()
+	r.mu.Lock()
+	var pending []*trackedWrite
+	for key, w := range r.writes {
+		if now.Sub(w.at) > retention {
+			delete(r.writes, key)
+		} else if componentsInScope(w.components, components, scope) {
+			pending = append(pending, w)
+		}
+	}
+	r.mu.Unlock()
+
+	timeout, interval := r.Timeout, r.RetryInterval
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	if interval == 0 {
+		interval = 100 * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+	for _, w := range pending {
+		for {
+			ok, err := r.visible(conn, w)
+			if err != nil {
+				return err
+			}
+			if ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("ldap: change to %q not yet replicated: %w", w.dn, ErrTimeout)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+	return nil
+}
+
+// visible reports whether conn shows the write w.
+func (r *ReadYourWrites) visible(conn Conn, w *trackedWrite) (bool, error) {
+	results, err := conn.Search(SearchRequest{
+		BaseObject: []byte(w.dn),
+		Scope:      BaseObject,
+		Filter:     Present("objectClass"),
+		Attributes: [][]byte{[]byte(r.attribute())},
+	})
+	switch {
+	case IsErrorWithCode(err, NoSuchObject):
+		return w.marker == "", nil
+	case err != nil:
+		return false, err
+	case len(results) == 0:
+		return w.marker == "", nil
+	case w.marker == "":
+		return false, nil
+	}
+	return compareMarkers(results[0].Entry().GetAttributeValue(r.attribute()), w.marker) >= 0, nil
+}
+
+func compareMarkers(a, b string) int {
+	x, errx := strconv.ParseInt(a, 10, 64)
+	y, erry := strconv.ParseInt(b, 10, 64)
+	switch {
+	case errx != nil || erry != nil:
+		return strings.Compare(a, b)
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+type consistentConn struct {
+	Conn
+	rw  *ReadYourWrites
+	ctx context.Context
+}
+
+func (c *consistentConn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	if err := c.Conn.Add(dn, attrs, controls...); err != nil {
+		return err
+	}
+	c.rw.record(c.Conn, dn)
+	return nil
+}
+
+func (c *consistentConn) AddWithParents(entry *Entry, templates map[string][]Attribute, controls ...Control) error {
+	return addWithParents(c, entry, templates, controls)
+}
+
+func (c *consistentConn) Ensure(entry *Entry) (bool, error) {
+	return ensure(c, entry)
+}
+
+func (c *consistentConn) Modify(dn string, mods []Modification, controls ...Control) error {
+	if err := c.Conn.Modify(dn, mods, controls...); err != nil {
+		return err
+	}
+	c.rw.record(c.Conn, dn)
+	return nil
+}
+
+func (c *consistentConn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error {
+	if err := c.Conn.ModifyDN(dn, newRDN, deleteOldRDN, newSuperior, controls...); err != nil {
+		return err
+	}
+	c.rw.track(dn, "")
+	c.rw.record(c.Conn, renamedDN(dn, newRDN, newSuperior))
+	return nil
+}
+
+func (c *consistentConn) Del(dn string, controls ...Control) error {
+	if err := c.Conn.Del(dn, controls...); err != nil {
+		return err
+	}
+	c.rw.track(dn, "")
+	return nil
+}
+
+func (c *consistentConn) Search(req SearchRequest) ([]SearchResult, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.Search(req)
+}
+
+func (c *consistentConn) SearchWithControls(req SearchRequest, controls ...Control) (*SearchResponse, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchWithControls(req, controls...)
+}
+
+func (c *consistentConn) SearchFunc(req SearchRequest, fn func(SearchResult, []Control) error, controls ...Control) ([]Control, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchFunc(req, fn, controls...)
+}
+
+func (c *consistentConn) SearchWithHandler(req SearchRequest, h SearchHandler, controls ...Control) ([]Control, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchWithHandler(req, h, controls...)
+}
+
+func (c *consistentConn) SearchStream(req SearchRequest, attrs []string, fn func(StreamedResult) error, controls ...Control) ([]Control, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchStream(req, attrs, fn, controls...)
+}
+
+func (c *consistentConn) DirSync(req SearchRequest, flags int64, cookie []byte, fn func(SearchResult) error, controls ...Control) ([]byte, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.DirSync(req, flags, cookie, fn, controls...)
+}
+
+func (c *consistentConn) PersistentSearch(req SearchRequest, psearch *ControlPersistentSearch, fn func(SearchResult, *ControlEntryChangeNotification) error) error {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return err
+	}
+	return c.Conn.PersistentSearch(req, psearch, fn)
+}
+
+func (c *consistentConn) SearchPage(req SearchRequest, paging *ControlPaging) ([]SearchResult, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchPage(req, paging)
+}
+
+func (c *consistentConn) SearchWithPaging(req SearchRequest, pageSize int) ([]SearchResult, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchWithPaging(req, pageSize)
+}
+
+func (c *consistentConn) SearchIter(req SearchRequest, pageSize int) *SearchIterator {
+	return searchIter(c, req, pageSize)
+}
+
+func (c *consistentConn) SearchSorted(req SearchRequest, keys []SortKey, controls ...Control) ([]SearchResult, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, string(req.BaseObject), req.Scope); err != nil {
+		return nil, err
+	}
+	return c.Conn.SearchSorted(req, keys, controls...)
+}
+
+func (c *consistentConn) GetEntry(dn string, attrs ...string) (*Entry, error) {
+	return getEntry(c, dn, attrs)
+}
+
+func (c *consistentConn) Exists(dn string) (bool, error) {
+	return exists(c, dn)
+}
+
+func (c *consistentConn) Compare(dn, attr, value string, controls ...Control) (bool, error) {
+	if err := c.rw.wait(c.ctx, c.Conn, dn, BaseObject); err != nil {
+		return false, err
+	}
+	return c.Conn.Compare(dn, attr, value, controls...)
+}
+
+func (c *consistentConn) WithContext(ctx context.Context) Conn {
+	return &consistentConn{c.Conn.WithContext(ctx), c.rw, ctx}
+}
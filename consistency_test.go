@@ -0,0 +1,174 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// replicatedDirectory has a primary that takes writes and a replica
+// that catches up with it every lag searches.
+type replicatedDirectory struct {
+	primary, replica directoryConn
+	csn              int
+	lag, searches    int
+}
+
+type primaryConn struct {
+	Conn
+	d *replicatedDirectory
+}
+
+func (c primaryConn) Search(req SearchRequest) ([]SearchResult, error) {
+	return c.d.primary.Search(req)
+}
+
+func (c primaryConn) Modify(dn string, mods []Modification, controls ...Control) error {
+	for i, e := range c.d.primary.entries {
+		if normalizeDN(e.DN) == normalizeDN(dn) {
+			c.d.csn++
+			values := e.AttributeMap()
+			values["entrycsn"] = []string{fmt.Sprintf("%03d", c.d.csn)}
+			for _, m := range mods {
+				values[m.Type] = m.Values
+			}
+			c.d.primary.entries[i] = NewEntry(dn, values)
+			return nil
+		}
+	}
+	return &Error{ResultCode: NoSuchObject}
+}
+
+func (c primaryConn) Del(dn string, controls ...Control) error {
+	entries := c.d.primary.entries[:0]
+	for _, e := range c.d.primary.entries {
+		if normalizeDN(e.DN) != normalizeDN(dn) {
+			entries = append(entries, e)
+		}
+	}
+	c.d.primary.entries = entries
+	return nil
+}
+
+type replicaConn struct {
+	Conn
+	d *replicatedDirectory
+}
+
+func (c replicaConn) Search(req SearchRequest) ([]SearchResult, error) {
+	if c.d.searches++; c.d.searches%c.d.lag == 0 {
+		c.d.replica.entries = append([]*Entry(nil), c.d.primary.entries...)
+	}
+	return c.d.replica.Search(req)
+}
+
+// frozenConn is a replica that never catches up.
+type frozenConn struct {
+	directoryConn
+}
+
+func (c *frozenConn) WithContext(ctx context.Context) Conn {
+	return c
+}
+
+func TestReadYourWrites(t *testing.T) {
+	const base = "dc=example,dc=com"
+	entries := []*Entry{
+		NewEntry(base, map[string][]string{"objectClass": {"top"}, "entryCSN": {"000"}}),
+		NewEntry("cn=a,"+base, map[string][]string{"objectClass": {"top"}, "entryCSN": {"000"}, "sn": {"old"}}),
+		NewEntry("cn=b,"+base, map[string][]string{"objectClass": {"top"}, "entryCSN": {"000"}}),
+	}
+	d := &replicatedDirectory{lag: 3}
+	d.primary.entries = append([]*Entry(nil), entries...)
+	d.replica.entries = append([]*Entry(nil), entries...)
+	rw := &ReadYourWrites{RetryInterval: time.Millisecond}
+	writer := rw.Conn(primaryConn{d: d})
+	reader := rw.Conn(replicaConn{d: d})
+
+	if err := writer.Modify("cn=a,"+base, []Modification{{Operation: ReplaceValues, Attribute: Attribute{Type: "sn", Values: []string{"new"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	// A search out of scope of the change does not wait.
+	if _, err := reader.Search(SearchRequest{BaseObject: []byte("cn=b," + base), Filter: Present("objectClass")}); err != nil || d.searches != 1 {
+		t.Errorf("Bad result: %v after %d searches (expected 1)", err, d.searches)
+	}
+	e, err := reader.GetEntry("CN=A,"+base, "sn")
+	if err != nil || e.GetAttributeValue("sn") != "new" {
+		t.Errorf("Bad result: %v, %v (expected sn new)", e, err)
+	}
+
+	if err := writer.Del("cn=b," + base); err != nil {
+		t.Fatal(err)
+	}
+	results, err := reader.Search(SearchRequest{BaseObject: []byte(base), Scope: WholeSubtree, Filter: Present("objectClass")})
+	if err != nil || len(results) != 2 {
+		t.Errorf("Bad result: %v, %v (expected 2 entries)", results, err)
+	}
+
+	d.lag = 1000
+	rw.Timeout = 5 * time.Millisecond
+	writer.Modify("cn=a,"+base, nil)
+	if _, err := reader.Exists("cn=a," + base); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Bad result: %v (expected ErrTimeout)", err)
+	}
+
+	for _, test := range []struct {
+		a, b     string
+		expected int
+	}{{"9", "10", -1}, {"10", "10", 0}, {"20240101000000.000000Z#000000#001#000000", "20231231000000.000000Z#000000#001#000000", 1}} {
+		if cmp := compareMarkers(test.a, test.b); cmp != test.expected {
+			t.Errorf("compareMarkers(%q, %q): Bad result: %d (expected %d)", test.a, test.b, cmp, test.expected)
+		}
+	}
+}
+
+func TestReadYourWritesReplicas(t *testing.T) {
+	const base = "dc=example,dc=com"
+	entries := []*Entry{
+		NewEntry(base, map[string][]string{"objectClass": {"top"}, "entryCSN": {"000"}}),
+		NewEntry("cn=a,"+base, map[string][]string{"objectClass": {"top"}, "entryCSN": {"000"}}),
+	}
+	d := &replicatedDirectory{lag: 1}
+	d.primary.entries = append([]*Entry(nil), entries...)
+	d.replica.entries = append([]*Entry(nil), entries...)
+	rw := &ReadYourWrites{RetryInterval: time.Millisecond, Timeout: 5 * time.Millisecond}
+	writer := rw.Conn(primaryConn{d: d})
+	reader := rw.Conn(replicaConn{d: d})
+	stale := rw.Conn(&frozenConn{directoryConn{entries: entries}})
+
+	if err := writer.Modify("cn=a,"+base, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.GetEntry("cn=a," + base); err != nil {
+		t.Errorf("Bad result: %v", err)
+	}
+	// One server having seen a change does not stop reads from another
+	// waiting for it, by any kind of search.
+	if _, err := stale.GetEntry("cn=a," + base); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Bad result: %v (expected ErrTimeout)", err)
+	}
+	req := SearchRequest{BaseObject: []byte(base), Scope: WholeSubtree, Filter: Present("objectClass")}
+	if _, err := stale.DirSync(req, 0, nil, func(SearchResult) error { return nil }); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Bad DirSync result: %v (expected ErrTimeout)", err)
+	}
+	if _, err := stale.SearchStream(req, nil, func(StreamedResult) error { return nil }); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Bad SearchStream result: %v (expected ErrTimeout)", err)
+	}
+
+	// The wait ends with the caller's context.
+	rw.Timeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := stale.WithContext(ctx).GetEntry("cn=a," + base); !errors.Is(err, context.Canceled) {
+		t.Errorf("Bad result: %v (expected %v)", err, context.Canceled)
+	}
+
+	// Writes are tracked only for Retention.
+	rw.Retention = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	if _, err := stale.GetEntry("cn=a," + base); err != nil {
+		t.Errorf("Bad result after retention: %v", err)
+	}
+}
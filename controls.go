@@ -0,0 +1,337 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"sync"
+)
+
+const (
+	ControlTypePaging                 = "1.2.840.113556.1.4.319"
+	ControlTypeServerSideSort         = "1.2.840.113556.1.4.473"
+	ControlTypeServerSideSortResponse = "1.2.840.113556.1.4.474"
+	ControlTypeVLV                    = "2.16.840.1.113730.3.4.9"
+	ControlTypeVLVResponse            = "2.16.840.1.113730.3.4.10"
+	ControlTypeManageDsaIT            = "2.16.840.1.113730.3.4.2"
+	ControlTypeSubtreeDelete          = "1.2.840.113556.1.4.805"
+)
+
+// A Control extends an LDAP operation (RFC 4511 §4.1.11). Controls are
+// attached to requests and may be returned by the server on responses.
+type Control interface {
+	ControlType() string
+	Critical() bool
+	ControlValue() ([]byte, error)
+}
+
+type control struct {
+	Type        []byte
+	Criticality bool   `asn1:"optional"`
+	Value       []byte `asn1:"optional"`
+}
+
+func encodeControls(controls []Control) ([]control, error) {
+	if len(controls) == 0 {
+		return nil, nil
+	}
+	out := make([]control, len(controls))
+	for i, c := range controls {
+		value, err := c.ControlValue()
+		if err != nil {
+			return nil, fmt.Errorf("Control %s: %v", c.ControlType(), err)
+		}
+		out[i] = control{[]byte(c.ControlType()), c.Critical(), value}
+	}
+	return out, nil
+}
+
+// ControlRaw is a control as it appears on the wire. Response controls
+// of types with no registered decoder are returned as ControlRaw, and
+// requests may carry a ControlRaw to pass a control through unchanged.
+type ControlRaw struct {
+	Type        string
+	Criticality bool
+	Value       []byte
+}
+
+func (c *ControlRaw) ControlType() string           { return c.Type }
+func (c *ControlRaw) Critical() bool                { return c.Criticality }
+func (c *ControlRaw) ControlValue() ([]byte, error) { return c.Value, nil }
+
+var (
+	controlDecodersMu sync.RWMutex
+	controlDecoders   = map[string]func(control) (Control, error){
+		ControlTypePaging:                  decodeControlPaging,
+		ControlTypeServerSideSortResponse:  decodeControlServerSideSortResponse,
+		ControlTypeVLVResponse:             decodeControlVLVResponse,
+		ControlTypePasswordPolicy:          decodeControlPasswordPolicy,
+		ControlTypeEntryChangeNotification: decodeControlEntryChangeNotification,
+		ControlTypeSyncState:               decodeControlSyncState,
+		ControlTypeSyncDone:                decodeControlSyncDone,
+		ControlTypeDirSync:                 decodeControlDirSync,
+	}
+)
+
+// RegisterControl makes response controls of controlType decode into
+// the Control that decode returns for their criticality and value (nil
+// if absent), in place of a ControlRaw. It replaces any decoder already
+// registered for the type, including the package's own.
+func RegisterControl(controlType string, decode func(critical bool, value []byte) (Control, error)) {
+	controlDecodersMu.Lock()
+	defer controlDecodersMu.Unlock()
+	controlDecoders[controlType] = func(c control) (Control, error) {
+		return decode(c.Criticality, c.Value)
+	}
+}
+
+// decodeControls decodes the controls of a message, keeping their order
+// and any repeats of a type.
+func decodeControls(controls []control) ([]Control, error) {
+	out := make([]Control, 0, len(controls))
+	for _, c := range controls {
+		controlDecodersMu.RLock()
+		decode := controlDecoders[string(c.Type)]
+		controlDecodersMu.RUnlock()
+		if decode == nil {
+			out = append(out, &ControlRaw{string(c.Type), c.Criticality, c.Value})
+			continue
+		}
+		ctrl, err := decode(c)
+		if err != nil {
+			return nil, fmt.Errorf("Decode Control %s: %v", c.Type, err)
+		}
+		out = append(out, ctrl)
+	}
+	return out, nil
+}
+
+// FindControl returns the first of controls of type controlType, or nil
+// if there is none.
+func FindControl(controls []Control, controlType string) Control {
+	for _, c := range controls {
+		if c.ControlType() == controlType {
+			return c
+		}
+	}
+	return nil
+}
+
+// FindAllControls returns the controls of type controlType, in the order
+// the server sent them, since a server may attach several of a type to a
+// message.
+func FindAllControls(controls []Control, controlType string) []Control {
+	var out []Control
+	for _, c := range controls {
+		if c.ControlType() == controlType {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func encodeValue(in interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := asn1.NewEncoder(&buf)
+	enc.Implicit = true
+	if err := enc.Encode(in); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// optionalBytes converts s for use in an optional field, which the
+// encoder only omits when it is nil.
+func optionalBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+func decodeValue(b []byte, out interface{}) error {
+	dec := asn1.NewDecoder(bytes.NewReader(b))
+	dec.Implicit = true
+	// No element in b can be longer than b.
+	dec.MaxLength = len(b)
+	return dec.Decode(out)
+}
+
+// ControlPaging implements the Simple Paged Results control (RFC
+// 2696). On requests Size is the page size; on responses it is the
+// server's estimate of the total result set size. An empty Cookie on a
+// response means there are no more pages.
+type ControlPaging struct {
+	Size        int
+	Cookie      []byte
+	Criticality bool
+}
+
+type pagingValue struct {
+	Size   int
+	Cookie []byte
+}
+
+func (c *ControlPaging) ControlType() string { return ControlTypePaging }
+func (c *ControlPaging) Critical() bool      { return c.Criticality }
+
+func (c *ControlPaging) ControlValue() ([]byte, error) {
+	return encodeValue(pagingValue{c.Size, c.Cookie})
+}
+
+func decodeControlPaging(c control) (Control, error) {
+	var v pagingValue
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, err
+	}
+	return &ControlPaging{Size: v.Size, Cookie: v.Cookie, Criticality: c.Criticality}, nil
+}
+
+type SortKey struct {
+	AttributeType string
+	MatchingRule  string
+	Reverse       bool
+}
+
+// ControlServerSideSort asks the server to sort the entries of a search
+// by the given keys (RFC 2891).
+type ControlServerSideSort struct {
+	SortKeys    []SortKey
+	Criticality bool
+}
+
+type sortKey struct {
+	AttributeType []byte
+	OrderingRule  []byte `asn1:"tag:0,optional"`
+	ReverseOrder  bool   `asn1:"tag:1,optional"`
+}
+
+func (c *ControlServerSideSort) ControlType() string { return ControlTypeServerSideSort }
+func (c *ControlServerSideSort) Critical() bool      { return c.Criticality }
+
+func (c *ControlServerSideSort) ControlValue() ([]byte, error) {
+	keys := make([]sortKey, len(c.SortKeys))
+	for i, k := range c.SortKeys {
+		keys[i] = sortKey{[]byte(k.AttributeType), optionalBytes(k.MatchingRule), k.Reverse}
+	}
+	return encodeValue(keys)
+}
+
+// ControlServerSideSortResponse reports the outcome of a sort request.
+// AttributeType names the offending sort key when the sort failed.
+type ControlServerSideSortResponse struct {
+	Result        ResultCode
+	AttributeType string
+}
+
+type sortResult struct {
+	Result        ResultCode `asn1:"enum"`
+	AttributeType []byte     `asn1:"tag:0,optional"`
+}
+
+func (c *ControlServerSideSortResponse) ControlType() string {
+	return ControlTypeServerSideSortResponse
+}
+func (c *ControlServerSideSortResponse) Critical() bool { return false }
+
+func (c *ControlServerSideSortResponse) ControlValue() ([]byte, error) {
+	return encodeValue(sortResult{c.Result, optionalBytes(c.AttributeType)})
+}
+
+func decodeControlServerSideSortResponse(c control) (Control, error) {
+	var v sortResult
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, err
+	}
+	return &ControlServerSideSortResponse{v.Result, string(v.AttributeType)}, nil
+}
+
+// ControlVLV requests a window of a sorted result set (Virtual List
+// View). The target entry is selected by Offset and ContentCount unless
+// GreaterThanOrEqual is non-nil, in which case the target is the first
+// entry whose sort key is greater than or equal to that value. ContextID
+// should be copied from the previous ControlVLVResponse, if any.
+type ControlVLV struct {
+	BeforeCount        int
+	AfterCount         int
+	Offset             int
+	ContentCount       int
+	GreaterThanOrEqual []byte
+	ContextID          []byte
+	Criticality        bool
+}
+
+type vlvRequest struct {
+	BeforeCount int
+	AfterCount  int
+	Target      interface{}
+	ContextID   []byte `asn1:"optional"`
+}
+
+type vlvByOffset struct {
+	Offset       int
+	ContentCount int
+}
+
+func (c *ControlVLV) ControlType() string { return ControlTypeVLV }
+func (c *ControlVLV) Critical() bool      { return c.Criticality }
+
+func (c *ControlVLV) ControlValue() ([]byte, error) {
+	v := vlvRequest{BeforeCount: c.BeforeCount, AfterCount: c.AfterCount, ContextID: c.ContextID}
+	if c.GreaterThanOrEqual != nil {
+		v.Target = asn1.OptionValue{Opts: "tag:1", Value: c.GreaterThanOrEqual}
+	} else {
+		v.Target = asn1.OptionValue{Opts: "tag:0", Value: vlvByOffset{c.Offset, c.ContentCount}}
+	}
+	return encodeValue(v)
+}
+
+type ControlVLVResponse struct {
+	TargetPosition int
+	ContentCount   int
+	Result         ResultCode
+	ContextID      []byte
+}
+
+type vlvResponse struct {
+	TargetPosition int
+	ContentCount   int
+	Result         ResultCode `asn1:"enum"`
+	ContextID      []byte     `asn1:"optional"`
+}
+
+func (c *ControlVLVResponse) ControlType() string { return ControlTypeVLVResponse }
+func (c *ControlVLVResponse) Critical() bool      { return false }
+
+func (c *ControlVLVResponse) ControlValue() ([]byte, error) {
+	return encodeValue(vlvResponse{c.TargetPosition, c.ContentCount, c.Result, c.ContextID})
+}
+
+func decodeControlVLVResponse(c control) (Control, error) {
+	var v vlvResponse
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, err
+	}
+	return &ControlVLVResponse{v.TargetPosition, v.ContentCount, v.Result, v.ContextID}, nil
+}
+
+// ControlManageDsaIT makes the server treat referral and other special
+// entries as ordinary objects (RFC 3296).
+type ControlManageDsaIT struct {
+	Criticality bool
+}
+
+func (c *ControlManageDsaIT) ControlType() string           { return ControlTypeManageDsaIT }
+func (c *ControlManageDsaIT) Critical() bool                { return c.Criticality }
+func (c *ControlManageDsaIT) ControlValue() ([]byte, error) { return nil, nil }
+
+// ControlSubtreeDelete asks the server to delete the target entry along
+// with all of its subordinates. Active Directory calls this the Tree
+// Delete control.
+type ControlSubtreeDelete struct {
+	Criticality bool
+}
+
+func (c *ControlSubtreeDelete) ControlType() string           { return ControlTypeSubtreeDelete }
+func (c *ControlSubtreeDelete) Critical() bool                { return c.Criticality }
+func (c *ControlSubtreeDelete) ControlValue() ([]byte, error) { return nil, nil }
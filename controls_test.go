@@ -0,0 +1,209 @@
+package ldap
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestControlRoundTrip(t *testing.T) {
+	tests := []Control{
+		&ControlPaging{Size: 100, Cookie: []byte("cookie")},
+		&ControlPaging{Size: 0, Cookie: []byte{}},
+		&ControlServerSideSortResponse{Result: Success},
+		&ControlServerSideSortResponse{Result: InsufficientAccessRights, AttributeType: "cn"},
+		&ControlVLVResponse{TargetPosition: 3, ContentCount: 10, ContextID: []byte("ctx")},
+		&ControlPasswordPolicy{TimeBeforeExpiration: 300, GraceAuthNsRemaining: -1, Error: -1},
+		&ControlPasswordPolicy{TimeBeforeExpiration: -1, GraceAuthNsRemaining: 2, Error: AccountLocked},
+		NewControlPasswordPolicy(),
+		&ControlEntryChangeNotification{ChangeType: ChangeAdd},
+		&ControlEntryChangeNotification{ChangeType: ChangeModDN, PreviousDN: "cn=old", ChangeNumber: 42},
+		&ControlEntryChangeNotification{ChangeType: ChangeModify, ChangeNumber: 7},
+		&ControlSyncState{State: SyncAdd, EntryUUID: []byte("0123456789abcdef"), Cookie: []byte("csn")},
+		&ControlSyncState{State: SyncDelete, EntryUUID: []byte("0123456789abcdef")},
+		&ControlSyncDone{Cookie: []byte("csn"), RefreshDeletes: true},
+		&ControlSyncDone{},
+		&ControlDirSync{Flags: DirSyncIncrementalValues, MaxAttrCount: 0, Cookie: []byte("cookie")},
+		&ControlDirSync{Flags: 0, Cookie: []byte{}},
+		&ControlRaw{Type: "1.3.6.1.4.1.42.2.27.9.5.8", Value: []byte{0x80, 0x01, 0x00}},
+	}
+	for i, test := range tests {
+		value, err := test.ControlValue()
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		out, err := decodeControls([]control{{Type: []byte(test.ControlType()), Value: value}})
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		if len(out) != 1 || !reflect.DeepEqual(test, out[0]) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, out, test)
+		}
+	}
+}
+
+type controlAccountUsable struct {
+	critical  bool
+	available bool
+}
+
+func (c *controlAccountUsable) ControlType() string           { return "1.3.6.1.4.1.42.2.27.9.5.8" }
+func (c *controlAccountUsable) Critical() bool                { return c.critical }
+func (c *controlAccountUsable) ControlValue() ([]byte, error) { return nil, nil }
+
+func TestRegisterControl(t *testing.T) {
+	const oid = "1.3.6.1.4.1.42.2.27.9.5.8"
+	defer func() {
+		controlDecodersMu.Lock()
+		delete(controlDecoders, oid)
+		controlDecodersMu.Unlock()
+	}()
+	RegisterControl(oid, func(critical bool, value []byte) (Control, error) {
+		if len(value) != 3 {
+			return nil, fmt.Errorf("bad value")
+		}
+		return &controlAccountUsable{critical, value[0] == 0x80}, nil
+	})
+
+	tests := []struct {
+		in  control
+		ok  bool
+		out Control
+	}{
+		{control{Type: []byte(oid), Value: []byte{0x80, 0x01, 0x00}}, true, &controlAccountUsable{available: true}},
+		{control{Type: []byte(oid), Criticality: true, Value: []byte{0xa1, 0x01, 0x00}}, true, &controlAccountUsable{critical: true}},
+		{control{Type: []byte(oid)}, false, nil},
+		{control{Type: []byte("1.2.3"), Value: []byte("x")}, true, &ControlRaw{Type: "1.2.3", Value: []byte("x")}},
+	}
+	for i, test := range tests {
+		out, err := decodeControls([]control{test.in})
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Incorrect error result: %v (expected ok %v)", i, err, test.ok)
+		} else if err == nil && (len(out) != 1 || !reflect.DeepEqual(out[0], test.out)) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, out, test.out)
+		}
+	}
+}
+
+func TestFindControls(t *testing.T) {
+	var in []control
+	for _, ecn := range []*ControlEntryChangeNotification{{ChangeType: ChangeAdd}, {ChangeType: ChangeModify}} {
+		value, _ := ecn.ControlValue()
+		in = append(in, control{Type: []byte(ControlTypeEntryChangeNotification), Value: value}, control{Type: []byte("1.2.3")})
+	}
+	out, err := decodeControls(in)
+	if err != nil || len(out) != 4 {
+		t.Fatalf("Bad result: %v, %v", out, err)
+	}
+	if c := FindControl(out, ControlTypeEntryChangeNotification); c != out[0] {
+		t.Errorf("Bad result: %v (expected %v)", c, out[0])
+	}
+	if c := FindControl(out, "1.2.4"); c != nil {
+		t.Errorf("Bad result: %v (expected nil)", c)
+	}
+	all := FindAllControls(out, ControlTypeEntryChangeNotification)
+	if len(all) != 2 || all[0].(*ControlEntryChangeNotification).ChangeType != ChangeAdd || all[1].(*ControlEntryChangeNotification).ChangeType != ChangeModify {
+		t.Errorf("Bad result: %v", all)
+	}
+	if all := FindAllControls(out, "1.2.4"); len(all) != 0 {
+		t.Errorf("Bad result: %v (expected none)", all)
+	}
+}
+
+func TestDecodeSyncInfo(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		ok  bool
+		out *syncInfo
+	}{
+		{[]byte{0x80, 0x03, 'c', 's', 'n'}, true,
+			&syncInfo{kind: syncNewCookie, cookie: []byte("csn"), refreshDone: true}},
+		{[]byte{0xa1, 0x00}, true,
+			&syncInfo{kind: syncRefreshDelete, refreshDone: true}},
+		{[]byte{0xa2, 0x08, 0x04, 0x03, 'c', 's', 'n', 0x01, 0x01, 0x00}, true,
+			&syncInfo{kind: syncRefreshPresent, cookie: []byte("csn")}},
+		{[]byte{0xa3, 0x0b, 0x01, 0x01, 0xff, 0x31, 0x06, 0x04, 0x01, 'a', 0x04, 0x01, 'b'}, true,
+			&syncInfo{kind: syncIdSet, refreshDone: true, refreshDeletes: true, uuids: [][]byte{[]byte("a"), []byte("b")}}},
+		{[]byte{0xa4, 0x00}, false, nil},
+	}
+	for i, test := range tests {
+		out, err := decodeSyncInfo(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("#%d: Incorrect error result (passed? %v, expected %v): %s",
+				i, err == nil, test.ok, err)
+		}
+		if err == nil && !reflect.DeepEqual(test.out, out) {
+			t.Errorf("#%d: Bad result: %+v (expected %+v)", i, out, test.out)
+		}
+	}
+}
+
+func TestSortAndVLVControlValues(t *testing.T) {
+	tests := []struct {
+		in  Control
+		out []byte
+	}{
+		{&ControlServerSideSort{SortKeys: []SortKey{{AttributeType: "cn"}}},
+			[]byte{0x30, 0x06, 0x30, 0x04, 0x04, 0x02, 'c', 'n'}},
+		{&ControlServerSideSort{SortKeys: []SortKey{{AttributeType: "cn"}, {AttributeType: "sn", MatchingRule: "2.5.13.3", Reverse: true}}},
+			[]byte{0x30, 0x19, 0x30, 0x04, 0x04, 0x02, 'c', 'n',
+				0x30, 0x11, 0x04, 0x02, 's', 'n', 0x80, 0x08, '2', '.', '5', '.', '1', '3', '.', '3', 0x81, 0x01, 0xff}},
+		{&ControlVLV{BeforeCount: 1, AfterCount: 2, Offset: 3},
+			[]byte{0x30, 0x0e, 0x02, 0x01, 0x01, 0x02, 0x01, 0x02, 0xa0, 0x06, 0x02, 0x01, 0x03, 0x02, 0x01, 0x00}},
+		{&ControlVLV{AfterCount: 5, GreaterThanOrEqual: []byte("m"), ContextID: []byte("ctx")},
+			[]byte{0x30, 0x0e, 0x02, 0x01, 0x00, 0x02, 0x01, 0x05, 0x81, 0x01, 'm', 0x04, 0x03, 'c', 't', 'x'}},
+	}
+	for i, test := range tests {
+		out, err := test.in.ControlValue()
+		if err != nil || !reflect.DeepEqual(out, test.out) {
+			t.Errorf("#%d: Bad result: % x, %v (expected % x)", i, out, err, test.out)
+		}
+	}
+}
+
+func TestSortAndVLVSearch(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	sortResp := &ControlServerSideSortResponse{Result: Success}
+	vlvResp := &ControlVLVResponse{TargetPosition: 3, ContentCount: 10, ContextID: []byte("ctx")}
+	requested := make(chan []string, 1)
+	go func() {
+		m, err := readControlMessage(server)
+		if err != nil {
+			return
+		}
+		var types []string
+		for _, ctl := range m.Controls {
+			types = append(types, string(ctl.Type))
+		}
+		requested <- types
+		for _, dn := range []string{"cn=b", "cn=c", "cn=d"} {
+			writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte(dn), []partialAttribute{}})
+		}
+		writeControlMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}}, sortResp, vlvResp)
+	}()
+
+	resp, err := c.SearchWithControls(SearchRequest{Filter: Present("objectClass")},
+		&ControlServerSideSort{SortKeys: []SortKey{{AttributeType: "cn"}}},
+		&ControlVLV{BeforeCount: 1, AfterCount: 1, Offset: 3, Criticality: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types := <-requested; !reflect.DeepEqual(types, []string{ControlTypeServerSideSort, ControlTypeVLV}) {
+		t.Errorf("Bad request controls: %v", types)
+	}
+	if len(resp.Results) != 3 {
+		t.Errorf("Bad results: %v", resp.Results)
+	}
+	if c := FindControl(resp.Controls, ControlTypeServerSideSortResponse); !reflect.DeepEqual(c, sortResp) {
+		t.Errorf("Bad sort response: %#v (expected %#v)", c, sortResp)
+	}
+	if c := FindControl(resp.Controls, ControlTypeVLVResponse); !reflect.DeepEqual(c, vlvResp) {
+		t.Errorf("Bad VLV response: %#v (expected %#v)", c, vlvResp)
+	}
+}
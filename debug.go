@@ -0,0 +1,377 @@
+package ldap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// SetDebug makes the connection write each message it sends and
+// receives to w as a tree of its BER elements, labelled with the names
+// of the protocol's operations, until it is called with nil. Passwords
+// and SASL credentials are left out. After StartTLS the messages are
+// shown decrypted.
+func (s *session) SetDebug(w io.Writer) {
+	s.dmu.Lock()
+	s.debugText = w
+	s.updateDebugging()
+	s.dmu.Unlock()
+}
+
+// SetCapture makes the connection write the messages it sends and
+// receives to w as a pcap capture, until it is called with nil. The
+// messages are wrapped in made-up IPv4 and TCP headers, from port 49152
+// on the client to port 389 on the server at 127.0.0.1, so that tools
+// such as Wireshark decode them as LDAP. Unlike SetDebug, credentials
+// are included.
+func (s *session) SetCapture(w io.Writer) {
+	s.dmu.Lock()
+	if w != nil {
+		s.capture = &pcapWriter{w: w}
+	} else {
+		s.capture = nil
+	}
+	s.updateDebugging()
+	s.dmu.Unlock()
+}
+
+func (s *session) updateDebugging() {
+	var on int32
+	if s.debugText != nil || s.capture != nil {
+		on = 1
+	}
+	atomic.StoreInt32(&s.debugging, on)
+}
+
+// dump writes a message to the debug writers, if any.
+func (s *session) dump(sent bool, b []byte) {
+	if atomic.LoadInt32(&s.debugging) == 0 {
+		return
+	}
+	s.dmu.Lock()
+	defer s.dmu.Unlock()
+	now := time.Now()
+	if s.debugText != nil {
+		dir := "received"
+		if sent {
+			dir = "sent"
+		}
+		var out strings.Builder
+		fmt.Fprintf(&out, "%s %s %d bytes\n", now.Format("15:04:05.000000"), dir, len(b))
+		dumpElements(&out, b, 1, "message")
+		io.WriteString(s.debugText, out.String())
+	}
+	if s.capture != nil {
+		s.capture.packet(now, sent, b)
+	}
+}
+
+var pduNames = map[int]string{
+	0: "bindRequest", 1: "bindResponse",
+	2: "unbindRequest",
+	3: "searchRequest", 4: "searchResEntry", 5: "searchResDone", 19: "searchResRef",
+	6: "modifyRequest", 7: "modifyResponse",
+	8: "addRequest", 9: "addResponse",
+	10: "delRequest", 11: "delResponse",
+	12: "modDNRequest", 13: "modDNResponse",
+	14: "compareRequest", 15: "compareResponse",
+	16: "abandonRequest",
+	23: "extendedReq", 24: "extendedResp",
+	25: "intermediateResponse",
+}
+
+var universalNames = map[int]string{
+	1: "BOOLEAN", 2: "INTEGER", 4: "OCTET STRING", 5: "NULL",
+	10: "ENUMERATED", 16: "SEQUENCE", 17: "SET",
+}
+
+// An element is a BER element: its tag and its content.
+type element struct {
+	class       int // 0 universal, 1 application, 2 context, 3 private
+	constructed bool
+	tag         int
+	content     []byte
+}
+
+// nextElement parses the element at the start of b, returning it and
+// the bytes after it.
+func nextElement(b []byte) (element, []byte, error) {
+	if len(b) < 2 {
+		return element{}, nil, fmt.Errorf("truncated element")
+	}
+	e := element{class: int(b[0] >> 6), constructed: b[0]&0x20 != 0, tag: int(b[0] & 0x1f)}
+	i := 1
+	if e.tag == 0x1f {
+		for e.tag = 0; ; i++ {
+			if i >= len(b) || i > 4 {
+				return element{}, nil, fmt.Errorf("bad tag")
+			}
+			e.tag = e.tag<<7 | int(b[i]&0x7f)
+			if b[i]&0x80 == 0 {
+				i++
+				break
+			}
+		}
+	}
+	if i >= len(b) {
+		return element{}, nil, fmt.Errorf("truncated element")
+	}
+	length := int(b[i])
+	i++
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || i+n > len(b) {
+			return element{}, nil, fmt.Errorf("unsupported length")
+		}
+		length = 0
+		for _, x := range b[i : i+n] {
+			length = length<<8 | int(x)
+		}
+		i += n
+	}
+	if i+length > len(b) {
+		return element{}, nil, fmt.Errorf("truncated element")
+	}
+	e.content = b[i : i+length]
+	return e, b[i+length:], nil
+}
+
+// dumpElements writes the elements in b as a tree, starting at the
+// given depth. context says where they are, for labelling: "message"
+// for the LDAPMessage itself, or the name of the enclosing element.
+func dumpElements(out *strings.Builder, b []byte, depth int, context string) {
+	for i := 0; len(b) > 0; i++ {
+		e, rest, err := nextElement(b)
+		indent := strings.Repeat("  ", depth)
+		if err != nil {
+			fmt.Fprintf(out, "%s%v: %x\n", indent, err, b)
+			return
+		}
+		b = rest
+
+		label := e.typeName()
+		if name := elementName(context, i, e); name != "" {
+			label = name + " " + label
+		}
+		switch {
+		case redacted(context, i, e):
+			fmt.Fprintf(out, "%s%s (%d bytes redacted)\n", indent, label, len(e.content))
+		case e.constructed:
+			fmt.Fprintf(out, "%s%s\n", indent, label)
+			child := context
+			switch {
+			case context == "message" && e.class == 0:
+				child = "LDAPMessage"
+			case context == "LDAPMessage" && e.class == 1:
+				child = pduNames[e.tag]
+			case context == "LDAPMessage" && e.class == 2:
+				child = "controls"
+			case context == "bindRequest" && e.class == 2 && e.tag == 3:
+				child = "sasl"
+			default:
+				child = ""
+			}
+			dumpElements(out, e.content, depth+1, child)
+		default:
+			fmt.Fprintf(out, "%s%s %s\n", indent, label, e.value(context, i))
+		}
+	}
+}
+
+// elementName names the i'th element of context, if it has a name.
+func elementName(context string, i int, e element) string {
+	switch {
+	case context == "message":
+		return "LDAPMessage"
+	case context == "LDAPMessage" && i == 0:
+		return "messageID"
+	case context == "LDAPMessage" && e.class == 1:
+		return pduNames[e.tag]
+	case context == "LDAPMessage" && e.class == 2 && e.tag == 0:
+		return "controls"
+	case isResult(context) && i == 0:
+		return "resultCode"
+	case isResult(context) && i == 1:
+		return "matchedDN"
+	case isResult(context) && i == 2:
+		return "diagnosticMessage"
+	}
+	return ""
+}
+
+func isResult(context string) bool {
+	switch context {
+	case "bindResponse", "searchResDone", "modifyResponse", "addResponse", "delResponse",
+		"modDNResponse", "compareResponse", "extendedResp":
+		return true
+	}
+	return false
+}
+
+// redacted reports whether an element holds credentials: the password
+// of a simple bind, or SASL credentials.
+func redacted(context string, i int, e element) bool {
+	return context == "bindRequest" && e.class == 2 && e.tag == 0 ||
+		context == "sasl" && i == 1
+}
+
+func (e element) typeName() string {
+	switch e.class {
+	case 0:
+		if name, ok := universalNames[e.tag]; ok {
+			return name
+		}
+		return fmt.Sprintf("[UNIVERSAL %d]", e.tag)
+	case 1:
+		return fmt.Sprintf("[APPLICATION %d]", e.tag)
+	case 2:
+		return fmt.Sprintf("[%d]", e.tag)
+	}
+	return fmt.Sprintf("[PRIVATE %d]", e.tag)
+}
+
+// value formats the content of a primitive element.
+func (e element) value(context string, i int) string {
+	isInt := e.class == 0 && (e.tag == 2 || e.tag == 10) || context == "LDAPMessage" && e.class == 1 && e.tag == 16
+	switch {
+	case e.class == 0 && e.tag == 1 && len(e.content) == 1:
+		return strconv.FormatBool(e.content[0] != 0)
+	case e.class == 0 && e.tag == 5:
+		return ""
+	case isInt && len(e.content) > 0 && len(e.content) <= 8:
+		var n int64
+		if e.content[0]&0x80 != 0 {
+			n = -1
+		}
+		for _, x := range e.content {
+			n = n<<8 | int64(x)
+		}
+		if isResult(context) && i == 0 {
+			return fmt.Sprintf("%d (%s)", n, ResultCode(n))
+		}
+		return strconv.FormatInt(n, 10)
+	case utf8.Valid(e.content) && printable(string(e.content)):
+		return strconv.Quote(string(e.content))
+	}
+	return fmt.Sprintf("%x", e.content)
+}
+
+func printable(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// A pcapWriter writes messages as TCP segments in a pcap capture.
+type pcapWriter struct {
+	w       io.Writer
+	started bool
+	seq     [2]uint32 // the next sequence numbers of the client and server
+}
+
+const (
+	pcapClientPort = 49152
+	pcapServerPort = 389
+	pcapMaxSegment = 65495 // what fits in an IPv4 packet
+)
+
+func (p *pcapWriter) packet(t time.Time, sent bool, b []byte) error {
+	if !p.started {
+		header := make([]byte, 24)
+		binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+		binary.LittleEndian.PutUint16(header[4:], 2)
+		binary.LittleEndian.PutUint16(header[6:], 4)
+		binary.LittleEndian.PutUint32(header[16:], 262144)
+		binary.LittleEndian.PutUint32(header[20:], 101) // LINKTYPE_RAW
+		if _, err := p.w.Write(header); err != nil {
+			return err
+		}
+		p.started = true
+	}
+	for len(b) > 0 {
+		n := len(b)
+		if n > pcapMaxSegment {
+			n = pcapMaxSegment
+		}
+		if err := p.segment(t, sent, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (p *pcapWriter) segment(t time.Time, sent bool, data []byte) error {
+	from, to := 0, 1
+	srcPort, dstPort := uint16(pcapClientPort), uint16(pcapServerPort)
+	src, dst := []byte{127, 0, 0, 1}, []byte{127, 0, 0, 1}
+	if !sent {
+		from, to = 1, 0
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	pkt := make([]byte, 40+len(data))
+	ip := pkt[:20]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+	ip[8] = 64
+	ip[9] = 6 // TCP
+	copy(ip[12:], src)
+	copy(ip[16:], dst)
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+	tcp := pkt[20:]
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], p.seq[from])
+	binary.BigEndian.PutUint32(tcp[8:], p.seq[to])
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], data)
+	pseudo := sum16(src) + sum16(dst) + 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, pseudo))
+	p.seq[from] += uint32(len(data))
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(pkt)))
+	if _, err := p.w.Write(record); err != nil {
+		return err
+	}
+	_, err := p.w.Write(pkt)
+	return err
+}
+
+// checksum computes the Internet checksum of b, adding in the sum of a
+// pseudo-header.
+func checksum(b []byte, pseudo uint32) uint16 {
+	sum := pseudo + sum16(b)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// sum16 adds up b as big-endian 16-bit words.
+func sum16(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 + uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	return sum
+}
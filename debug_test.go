@@ -0,0 +1,73 @@
+package ldap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSetDebug(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+	var text, capture bytes.Buffer
+	c.SetDebug(&text)
+	c.SetCapture(&capture)
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:1", ldapResult{ResultCode: InvalidCredentials, MatchedDN: []byte{}, Message: []byte("bad password")})
+	}()
+	c.Bind("cn=admin", "secret")
+	c.SetDebug(nil)
+	c.SetCapture(nil)
+
+	out := text.String()
+	for _, expected := range []string{
+		"sent ",
+		"  LDAPMessage SEQUENCE\n",
+		"    messageID INTEGER 1\n",
+		"    bindRequest [APPLICATION 0]\n",
+		"      OCTET STRING \"cn=admin\"\n",
+		"      [0] (6 bytes redacted)\n",
+		"      resultCode ENUMERATED 49 (invalidCredentials)\n",
+		"      diagnosticMessage OCTET STRING \"bad password\"\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Missing %q in:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("Password dumped:\n%s", out)
+	}
+
+	b := capture.Bytes()
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(b[20:]) != 101 {
+		t.Fatalf("Bad pcap header: %x", b)
+	}
+	var packets [][]byte
+	for b = b[24:]; len(b) >= 16; {
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		packets = append(packets, b[16:16+n])
+		b = b[16+n:]
+	}
+	if len(packets) != 2 {
+		t.Fatalf("Bad packet count: %d (expected 2)", len(packets))
+	}
+	for i, p := range packets {
+		if checksum(p[:20], 0) != 0 {
+			t.Errorf("#%d: Bad IP checksum", i)
+		}
+		if port := binary.BigEndian.Uint16(p[22-2*i:]); port != 389 {
+			t.Errorf("#%d: Bad server port: %d (expected 389)", i, port)
+		}
+	}
+	if !bytes.Contains(packets[0], []byte("secret")) {
+		t.Errorf("Captured request lacks the password")
+	}
+	if seq := binary.BigEndian.Uint32(packets[1][28:]); seq != uint32(len(packets[0])-40) {
+		t.Errorf("Bad acknowledgement: %d (expected %d)", seq, len(packets[0])-40)
+	}
+}
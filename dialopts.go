@@ -0,0 +1,202 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+type DialOpts struct {
+	// ConnectTimeout bounds connecting, including the TLS handshake of
+	// an ldaps:// connection. Zero means no timeout beyond the context.
+	ConnectTimeout time.Duration
+	// FallbackDelay is how long to wait for a connection to one
+	// address of a host before also trying the next. Zero means
+	// DefaultFallbackDelay and a negative value tries the addresses
+	// one at a time.
+	FallbackDelay time.Duration
+	// DialContext, if set, opens connections instead of a net.Dialer,
+	// e.g. through a proxy: see ProxyDialer. FallbackDelay and
+	// KeepAlive are then up to it.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// KeepAlive is the TCP keepalive period. Zero uses the operating
+	// system's default and a negative value disables keepalives.
+	KeepAlive time.Duration
+	// Timeout bounds each operation made directly on the connection.
+	// Operations on a view returned by WithContext are bounded by its
+	// context instead, so that long-running searches can opt out.
+	Timeout time.Duration
+	// IdleTimeout, if set, causes the root DSE to be read whenever the
+	// connection has been idle that long, keeping firewalls and servers
+	// from dropping it. If the read fails the connection is closed.
+	IdleTimeout time.Duration
+	// MaxInFlight limits the number of requests awaiting a response;
+	// further requests wait for one to complete. It defaults to
+	// DefaultMaxInFlight.
+	MaxInFlight int
+	// TLSConfig is used for ldaps:// URLs.
+	TLSConfig *tls.Config
+	// Logger, if set, receives a record of the dial at the Info level,
+	// each request at the Debug level, and each response with its
+	// result code and duration at the Info level, or Warn if the
+	// operation failed. Passwords and SASL credentials are redacted.
+	Logger *slog.Logger
+	// Metrics, if set, receives the bytes sent and received, and the
+	// result code and latency of each operation.
+	Metrics MetricsCollector
+	// Tracer, if set, creates a span for each request.
+	Tracer Tracer
+	// HashFilters replaces search filters in logs and spans with their
+	// SHA-256 hash, as they may hold personal data.
+	HashFilters bool
+	// NormalizeFilters sends search filters through NormalizeFilter.
+	NormalizeFilters bool
+	// MaxQueuedResponses, if set, bounds the responses to a request,
+	// such as the entries of a search, that may wait for a consumer that
+	// has fallen behind. A search exceeding it is abandoned and returns
+	// a *SlowConsumerError. Zero means no limit.
+	MaxQueuedResponses int
+	// Strict fails the connection with a *ProtocolViolationError when
+	// the server sends a response that does not conform to RFC 4511:
+	// one of the wrong type for its request, with a critical control,
+	// with a message ID not in use, or after the final response to its
+	// request. Otherwise such responses are dropped, with a warning.
+	Strict bool
+	// Quirks are the deviations from the protocol tolerated from the
+	// server. With DetectQuirks, those QuirksFor returns for the server
+	// are added once its root DSE has been read.
+	Quirks       Quirks
+	DetectQuirks bool
+	// Interceptors are called around each operation, the first
+	// outermost.
+	Interceptors []Interceptor
+	// ReadOnly makes Add, Modify, Del, ModifyDN and PasswordModify, and
+	// the operations built on them, fail with ErrReadOnly without
+	// sending anything, for consumers that must never write to the
+	// directory.
+	ReadOnly bool
+}
+
+const DefaultMaxInFlight = 256
+
+func (opts DialOpts) maxInFlight() int {
+	if opts.MaxInFlight > 0 {
+		return opts.MaxInFlight
+	}
+	return DefaultMaxInFlight
+}
+
+var ErrTimeout = fmt.Errorf("ldap: operation timed out")
+
+// A SlowConsumerError is returned by a search abandoned because more than
+// DialOpts.MaxQueuedResponses of its responses were waiting to be
+// consumed.
+type SlowConsumerError struct {
+	Queued int
+}
+
+func (e *SlowConsumerError) Error() string {
+	return fmt.Sprintf("ldap: consumer fell behind with %d responses queued; request abandoned", e.Queued)
+}
+
+// DialWithOpts connects to the server named by an ldap://, ldaps:// or
+// ldapi:// URL, as DialURL does, configured by opts.
+func DialWithOpts(ctx context.Context, rawurl string, opts DialOpts) (Conn, error) {
+	network, addr, secure, err := parseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return dial(ctx, network, addr, secure, opts)
+}
+
+func dial(ctx context.Context, network, addr string, secure bool, opts DialOpts) (Conn, error) {
+	d := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: opts.KeepAlive,
+	}
+	if opts.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.ConnectTimeout)
+		defer cancel()
+	}
+	var c net.Conn
+	var err error
+	start := time.Now()
+	if secure {
+		c, err = dialTLS(ctx, d, network, addr, opts)
+	} else {
+		c, err = opts.dialRaw(ctx, d, network, addr)
+	}
+	logDial(opts.Logger, network, addr, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return newConnWithOpts(c, opts), nil
+}
+
+// dialRaw opens the connection underlying an LDAP connection: with
+// DialContext if set, or else directly.
+func (opts DialOpts) dialRaw(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	switch {
+	case opts.DialContext != nil:
+		return opts.DialContext(ctx, network, addr)
+	case network == "tcp":
+		return dialTCP(ctx, d, addr, opts.FallbackDelay)
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// dialTLS connects to addr as dialRaw does and performs the TLS
+// handshake.
+func dialTLS(ctx context.Context, d *net.Dialer, network, addr string, opts DialOpts) (net.Conn, error) {
+	c, err := opts.dialRaw(ctx, d, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(c, tlsConfigFor(opts.TLSConfig, addr))
+	if err := tc.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// keepAlive reads the root DSE whenever the connection has been idle
+// for d, until the connection is closed.
+func (l *conn) keepAlive(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-timer.C:
+		}
+
+		if l.busy() {
+			timer.Reset(d)
+			continue
+		}
+		if idle := l.idle(); idle < d {
+			timer.Reset(d - idle)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		err := readRootDSE(l.WithContext(ctx))
+		cancel()
+		if err != nil {
+			l.Close()
+			return
+		}
+		timer.Reset(d)
+	}
+}
+
+func (s *session) busy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) > 0
+}
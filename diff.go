@@ -0,0 +1,73 @@
+package ldap
+
+// DiffEntry returns the modifications that turn old's attributes into
+// new's. An attribute that only gains or loses values is changed value
+// by value; one whose values all change is replaced. The values of each
+// attribute are compared with the matching rule rules returns for it;
+// rules may return nil, or be nil, to compare values exactly.
+func DiffEntry(old, new *Entry, rules func(attribute string) MatchingRule) []Modification {
+	var mods []Modification
+	for _, a := range new.Attributes {
+		var rule MatchingRule
+		if rules != nil {
+			rule = rules(a.Name)
+		}
+		o := old.GetAttribute(a.Name)
+		if o == nil {
+			if len(a.Values) > 0 {
+				mods = append(mods, modification(AddValues, a.Name, a.Values))
+			}
+			continue
+		}
+		removed := missingValues(o.Values, a.Values, rule)
+		added := missingValues(a.Values, o.Values, rule)
+		switch {
+		case len(removed) == 0 && len(added) == 0:
+		case len(a.Values) == 0:
+			mods = append(mods, modification(DeleteValues, a.Name, nil))
+		case len(removed) == len(o.Values):
+			mods = append(mods, modification(ReplaceValues, a.Name, a.Values))
+		default:
+			if len(removed) > 0 {
+				mods = append(mods, modification(DeleteValues, a.Name, removed))
+			}
+			if len(added) > 0 {
+				mods = append(mods, modification(AddValues, a.Name, added))
+			}
+		}
+	}
+	for _, o := range old.Attributes {
+		if new.GetAttribute(o.Name) == nil {
+			mods = append(mods, modification(DeleteValues, o.Name, nil))
+		}
+	}
+	return mods
+}
+
+func modification(op ModifyOperation, name string, values []string) Modification {
+	return Modification{Operation: op, Attribute: Attribute{Type: name, Values: values}}
+}
+
+// missingValues returns the values of a that are not in b. Values are
+// compared exactly if rule is nil, or if they are invalid under it.
+func missingValues(a, b []string, rule MatchingRule) []string {
+	normalize := func(v string) string {
+		if rule != nil {
+			if n, err := rule.Normalize(v); err == nil {
+				return n
+			}
+		}
+		return v
+	}
+	in := make(map[string]bool, len(b))
+	for _, v := range b {
+		in[normalize(v)] = true
+	}
+	var missing []string
+	for _, v := range a {
+		if !in[normalize(v)] {
+			missing = append(missing, v)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,80 @@
+package ldap
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/stesla/ldap/internal/digestmd5"
+	"strings"
+)
+
+// SASLDigestMD5 implements the DIGEST-MD5 mechanism (RFC 2831) for
+// initial authentication, with the auth quality of protection only: it
+// authenticates the client without protecting the rest of the session,
+// so use it over TLS. Host is the name of the server, used in the
+// digest-uri. Realm is the realm to authenticate in; if empty, the
+// first the server offers is used.
+type SASLDigestMD5 struct {
+	AuthzID  string
+	Username string
+	Password string
+	Realm    string
+	Host     string
+
+	rspauth string
+}
+
+func (m *SASLDigestMD5) Name() string { return "DIGEST-MD5" }
+
+func (m *SASLDigestMD5) Start() ([]byte, error) {
+	m.rspauth = ""
+	return nil, nil
+}
+
+func (m *SASLDigestMD5) Next(challenge []byte) ([]byte, error) {
+	// A repeated realm lists the realms the server offers.
+	d, err := digestmd5.ParseDirectives(string(challenge), "realm")
+	if err != nil {
+		return nil, fmt.Errorf("DIGEST-MD5: %v", err)
+	}
+	if m.rspauth != "" {
+		if d["rspauth"] != m.rspauth {
+			return nil, fmt.Errorf("DIGEST-MD5: server failed to authenticate")
+		}
+		return nil, nil
+	}
+	if d["nonce"] == "" {
+		return nil, fmt.Errorf("DIGEST-MD5: challenge without nonce")
+	}
+	if qop := d["qop"]; qop != "" && !containsToken(qop, "auth") {
+		return nil, fmt.Errorf("DIGEST-MD5: server does not offer qop auth")
+	}
+	realm := m.Realm
+	if realm == "" {
+		realm = d["realm"]
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	cnonce := base64.RawStdEncoding.EncodeToString(b)
+	digestURI := "ldap/" + m.Host
+
+	var response string
+	response, m.rspauth = digestmd5.Response(m.Username, realm, m.Password, d["nonce"], cnonce, m.AuthzID, digestURI)
+	r := fmt.Sprintf(`charset=utf-8,username=%s,realm=%s,nonce=%s,nc=00000001,cnonce=%s,digest-uri=%s,response=%s,qop=auth`,
+		digestmd5.Quote(m.Username), digestmd5.Quote(realm), digestmd5.Quote(d["nonce"]), digestmd5.Quote(cnonce), digestmd5.Quote(digestURI), response)
+	if m.AuthzID != "" {
+		r += ",authzid=" + digestmd5.Quote(m.AuthzID)
+	}
+	return []byte(r), nil
+}
+
+func containsToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.TrimSpace(t) == token {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package ldap
+
+import (
+	"fmt"
+)
+
+const (
+	ControlTypeDirSync      = "1.2.840.113556.1.4.841"
+	ControlTypeShowDeleted  = "1.2.840.113556.1.4.417"
+	ControlTypeShowRecycled = "1.2.840.113556.1.4.2064"
+)
+
+const ( // DirSync flags
+	DirSyncObjectSecurity      = 0x00000001
+	DirSyncAncestorsFirstOrder = 0x00000800
+	DirSyncPublicDataOnly      = 0x00002000
+	DirSyncIncrementalValues   = 0x80000000
+)
+
+// ControlDirSync implements Active Directory's DirSync control, which
+// returns the objects changed since the state captured in Cookie. On
+// responses a non-zero Flags value means more changes are pending.
+type ControlDirSync struct {
+	Flags        int64
+	MaxAttrCount int
+	Cookie       []byte
+	Criticality  bool
+}
+
+type dirSyncValue struct {
+	Flags        int64
+	MaxAttrCount int
+	Cookie       []byte
+}
+
+// NewControlDirSync returns a critical DirSync control, as required by
+// Active Directory, resuming from cookie.
+func NewControlDirSync(flags int64, maxAttrCount int, cookie []byte) *ControlDirSync {
+	return &ControlDirSync{flags, maxAttrCount, cookie, true}
+}
+
+func (c *ControlDirSync) ControlType() string { return ControlTypeDirSync }
+func (c *ControlDirSync) Critical() bool      { return c.Criticality }
+
+func (c *ControlDirSync) ControlValue() ([]byte, error) {
+	return encodeValue(dirSyncValue{c.Flags, c.MaxAttrCount, c.Cookie})
+}
+
+func decodeControlDirSync(c control) (Control, error) {
+	var v dirSyncValue
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, err
+	}
+	return &ControlDirSync{v.Flags, v.MaxAttrCount, v.Cookie, c.Criticality}, nil
+}
+
+// ControlShowDeleted includes tombstones of deleted objects in search
+// results.
+type ControlShowDeleted struct {
+	Criticality bool
+}
+
+func (c *ControlShowDeleted) ControlType() string           { return ControlTypeShowDeleted }
+func (c *ControlShowDeleted) Critical() bool                { return c.Criticality }
+func (c *ControlShowDeleted) ControlValue() ([]byte, error) { return nil, nil }
+
+// ControlShowRecycled includes recycled objects in search results when
+// the Active Directory Recycle Bin is enabled.
+type ControlShowRecycled struct {
+	Criticality bool
+}
+
+func (c *ControlShowRecycled) ControlType() string           { return ControlTypeShowRecycled }
+func (c *ControlShowRecycled) Critical() bool                { return c.Criticality }
+func (c *ControlShowRecycled) ControlValue() ([]byte, error) { return nil, nil }
+
+// DirSync streams the objects that changed since cookie to fn, issuing
+// DirSync searches until the server reports no more changes, and returns
+// the cookie to resume from next time. An empty cookie starts a full
+// synchronization. Additional controls such as ControlShowDeleted are
+// sent with every search.
+func (l *conn) DirSync(req SearchRequest, flags int64, cookie []byte, fn func(SearchResult) error, controls ...Control) ([]byte, error) {
+	for {
+		ctrl := NewControlDirSync(flags, 0, cookie)
+		ctrls, err := l.SearchFunc(req, func(result SearchResult, _ []Control) error {
+			return fn(result)
+		}, append([]Control{ctrl}, controls...)...)
+		if err != nil {
+			return cookie, err
+		}
+		resp, ok := FindControl(ctrls, ControlTypeDirSync).(*ControlDirSync)
+		if !ok {
+			return cookie, fmt.Errorf("server did not return a DirSync control")
+		}
+		cookie = resp.Cookie
+		if resp.Flags == 0 {
+			return cookie, nil
+		}
+	}
+}
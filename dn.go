@@ -0,0 +1,180 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EscapeDN escapes an attribute value for use in a distinguished name as
+// described in RFC 4514 §2.4, so that e.g. a cn taken from user input
+// can be embedded with "cn=" + EscapeDN(cn) + ",ou=people".
+func EscapeDN(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0:
+			buf.WriteString(`\00`)
+		case strings.IndexByte(`"+,;<>\`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(s)-1 && c == ' ':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// UnescapeDN reverses EscapeDN, accepting both the \<char> and \XX
+// forms of escape.
+func UnescapeDN(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("ldap: trailing backslash in %q", s)
+		}
+		if strings.IndexByte(` "#+,;<=>\`, s[i+1]) >= 0 {
+			buf.WriteByte(s[i+1])
+			i++
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("ldap: truncated escape in %q", s)
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("ldap: invalid escape in %q", s)
+		}
+		buf.WriteByte(byte(b))
+		i += 2
+	}
+	return buf.String(), nil
+}
+
+// A DN is a parsed distinguished name, most specific RDN first.
+type DN []RDN
+
+// An RDN is a relative distinguished name: one or more attribute values
+// joined by "+".
+type RDN []AttributeTypeAndValue
+
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// ParseDN parses the string representation of a distinguished name
+// defined by RFC 4514. It tolerates spaces around the separators, as
+// older RFC 2253 implementations emit them.
+func ParseDN(s string) (DN, error) {
+	dn := DN{}
+	if strings.TrimSpace(s) == "" {
+		return dn, nil
+	}
+
+	rdn := RDN{}
+	for i := 0; ; {
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("ldap: invalid DN %q: missing '='", s)
+		}
+		typ := strings.TrimSpace(s[i : i+eq])
+		if typ == "" {
+			return nil, fmt.Errorf("ldap: invalid DN %q: empty attribute type", s)
+		}
+		i += eq + 1
+
+		end := i
+		for end < len(s) && s[end] != ',' && s[end] != '+' && s[end] != ';' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end > len(s) {
+			return nil, fmt.Errorf("ldap: invalid DN %q: trailing backslash", s)
+		}
+		value, err := parseDNValue(s[i:end])
+		if err != nil {
+			return nil, fmt.Errorf("ldap: invalid DN %q: %v", s, err)
+		}
+		rdn = append(rdn, AttributeTypeAndValue{typ, value})
+
+		if end == len(s) {
+			return append(dn, rdn), nil
+		}
+		if s[end] != '+' {
+			dn = append(dn, rdn)
+			rdn = RDN{}
+		}
+		i = end + 1
+	}
+}
+
+func parseDNValue(raw string) (string, error) {
+	// Unescaped spaces at either end are insignificant; an escaped
+	// trailing space is kept.
+	raw = strings.TrimLeft(raw, " ")
+	for strings.HasSuffix(raw, " ") && !strings.HasSuffix(raw, `\ `) {
+		raw = raw[:len(raw)-1]
+	}
+	if strings.HasPrefix(raw, "#") {
+		return parseHexDNValue(raw[1:])
+	}
+	return UnescapeDN(raw)
+}
+
+// parseHexDNValue decodes the #hexstring form, the BER encoding of the
+// value, returning the contents of a primitive encoding.
+func parseHexDNValue(h string) (string, error) {
+	b := make([]byte, len(h)/2)
+	for i := range b {
+		v, err := strconv.ParseUint(h[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid hex string %q", h)
+		}
+		b[i] = byte(v)
+	}
+	if len(h)%2 != 0 || len(b) < 2 {
+		return "", fmt.Errorf("invalid hex string %q", h)
+	}
+	n, off := int(b[1]), 2
+	if n&0x80 != 0 {
+		off += n & 0x7f
+		n = 0
+		for _, c := range b[2:min(off, len(b))] {
+			n = n<<8 | int(c)
+		}
+	}
+	if off+n != len(b) {
+		return "", fmt.Errorf("invalid BER value %q", h)
+	}
+	return string(b[off:]), nil
+}
+
+func (dn DN) String() string {
+	parts := make([]string, len(dn))
+	for i, rdn := range dn {
+		parts[i] = rdn.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (rdn RDN) String() string {
+	parts := make([]string, len(rdn))
+	for i, atv := range rdn {
+		parts[i] = atv.Type + "=" + EscapeDN(atv.Value)
+	}
+	return strings.Join(parts, "+")
+}
@@ -0,0 +1,103 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscapeDN(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"Jane Doe", "Jane Doe"},
+		{"Doe, Jane", `Doe\, Jane`},
+		{" #lead", `\ #lead`},
+		{"#hash", `\#hash`},
+		{"trail ", `trail\ `},
+		{`a+b;c<d>e"f\g`, `a\+b\;c\<d\>e\"f\\g`},
+		{"nul\x00", `nul\00`},
+		{"caf\xc3\xa9", "caf\xc3\xa9"},
+	}
+	for i, test := range tests {
+		result := EscapeDN(test.in)
+		if result != test.expected {
+			t.Errorf("#%d: Bad result: %q (expected %q)", i, result, test.expected)
+		}
+		if back, err := UnescapeDN(result); err != nil || back != test.in {
+			t.Errorf("#%d: Bad round trip: %q, %v (expected %q)", i, back, err, test.in)
+		}
+	}
+}
+
+func TestUnescapeDN(t *testing.T) {
+	tests := []struct {
+		in, expected string
+		ok           bool
+	}{
+		{`caf\C3\A9`, "caf\xc3\xa9", true},
+		{`a\=b`, "a=b", true},
+		{`a\`, "", false},
+		{`a\4`, "", false},
+		{`a\zz`, "", false},
+	}
+	for i, test := range tests {
+		result, err := UnescapeDN(test.in)
+		if (err == nil) != test.ok || result != test.expected {
+			t.Errorf("#%d: Bad result: %q, %v (expected %q)", i, result, err, test.expected)
+		}
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"Jane", "Jane"},
+		{"*)(uid=*", `\2a\29\28uid=\2a`},
+		{`back\slash`, `back\5cslash`},
+		{"nul\x00", `nul\00`},
+		{"\xc3\xa9", "\xc3\xa9"},
+		{"\x01\xff\xfe", "\x01\\ff\\fe"},
+	}
+	for i, test := range tests {
+		if result := EscapeFilter(test.in); result != test.expected {
+			t.Errorf("#%d: Bad result: %q (expected %q)", i, result, test.expected)
+		}
+	}
+}
+
+func TestParseDN(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected DN
+		str      string
+	}{
+		{"", DN{}, ""},
+		{"cn=Jane Doe,dc=example,dc=com", DN{
+			{{"cn", "Jane Doe"}}, {{"dc", "example"}}, {{"dc", "com"}},
+		}, "cn=Jane Doe,dc=example,dc=com"},
+		{"cn = Jane , ou=people", DN{{{"cn", "Jane"}}, {{"ou", "people"}}}, "cn=Jane,ou=people"},
+		{"cn=Doe\\, Jane+uid=jd,o=x", DN{{{"cn", "Doe, Jane"}, {"uid", "jd"}}, {{"o", "x"}}}, "cn=Doe\\, Jane+uid=jd,o=x"},
+		{"cn=trail\\ ,o=x", DN{{{"cn", "trail "}}, {{"o", "x"}}}, "cn=trail\\ ,o=x"},
+		{"1.3.6.1.4.1.1466.0=#04024869,o=x", DN{{{"1.3.6.1.4.1.1466.0", "Hi"}}, {{"o", "x"}}}, "1.3.6.1.4.1.1466.0=Hi,o=x"},
+	}
+	for i, test := range tests {
+		result, err := ParseDN(test.in)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("#%d: Bad result: %#v (expected %#v)", i, result, test.expected)
+		}
+		if s := result.String(); s != test.str {
+			t.Errorf("#%d: Bad string: %q (expected %q)", i, s, test.str)
+		}
+	}
+
+	for i, bad := range []string{"cn", "=x", "cn=x\\", "cn=#0", "cn=#0403ab"} {
+		if dn, err := ParseDN(bad); err == nil {
+			t.Errorf("#%d: Expected an error for %q, got %#v", i, bad, dn)
+		}
+	}
+}
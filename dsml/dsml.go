@@ -0,0 +1,237 @@
+// Package dsml reads and writes the batch requests and responses of
+// DSMLv2, the XML form of LDAP operations used by SOAP-based directory
+// services and as an export format alongside LDIF.
+package dsml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldif"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	Namespace = "urn:oasis:names:tc:DSML:2:0:core"
+	xsiNS     = "http://www.w3.org/2001/XMLSchema-instance"
+	xsdNS     = "http://www.w3.org/2001/XMLSchema"
+)
+
+// A Request is an operation of a batchRequest: a search, or a change
+// described by an LDIF record. Controls apply to a search; those of a
+// change are in its record.
+type Request struct {
+	RequestID string
+	Search    *ldap.SearchRequest
+	Controls  []ldif.Control
+	Change    *ldif.Record
+}
+
+type ResponseType string
+
+const (
+	SearchResponse ResponseType = "searchResponse"
+	AddResponse    ResponseType = "addResponse"
+	ModifyResponse ResponseType = "modifyResponse"
+	DelResponse    ResponseType = "delResponse"
+	ModDNResponse  ResponseType = "modDNResponse"
+	ErrorResponse  ResponseType = "errorResponse"
+)
+
+// A Response is an element of a batchResponse. Results and References
+// are those of a search; ErrorType and ErrorMessage describe an
+// errorResponse, ErrorMessage being the diagnostic message otherwise.
+type Response struct {
+	Type      ResponseType
+	RequestID string
+	Controls  []ldif.Control
+
+	Results    []ldap.SearchResult
+	References []string
+
+	ResultCode   ldap.ResultCode
+	MatchedDN    string
+	ErrorMessage string
+	Referrals    []string
+	ErrorType    string
+}
+
+// Err returns the failure a response reports, if any: an *ldap.Error for
+// an LDAP result other than success.
+func (r *Response) Err() error {
+	if r.Type == ErrorResponse {
+		return fmt.Errorf("dsml: %s: %s", r.ErrorType, r.ErrorMessage)
+	}
+	if r.ResultCode != ldap.Success {
+		return &ldap.Error{
+			ResultCode:        r.ResultCode,
+			MatchedDN:         r.MatchedDN,
+			DiagnosticMessage: r.ErrorMessage,
+			Referrals:         r.Referrals,
+		}
+	}
+	return nil
+}
+
+// value is a DsmlValue: a string, or base64 if it is not valid XML
+// text.
+type value string
+
+func (v value) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalValue(e, start, string(v), !xmlSafe(string(v)))
+}
+
+func (v *value) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	binary := false
+	for _, a := range start.Attr {
+		if a.Name.Local == "type" && strings.HasSuffix(a.Value, "base64Binary") {
+			binary = true
+		}
+	}
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+	if binary {
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+		if err != nil {
+			return fmt.Errorf("dsml: invalid base64 value: %v", err)
+		}
+		s = string(b)
+	}
+	*v = value(s)
+	return nil
+}
+
+// binaryValue is always written as base64, as control values are.
+type binaryValue []byte
+
+func (v binaryValue) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalValue(e, start, string(v), true)
+}
+
+func (v *binaryValue) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s value
+	if err := s.UnmarshalXML(d, start); err != nil {
+		return err
+	}
+	*v = binaryValue(s)
+	return nil
+}
+
+func marshalValue(e *xml.Encoder, start xml.StartElement, s string, binary bool) error {
+	if binary {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xsi:type"}, Value: "xsd:base64Binary"})
+		s = base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return e.EncodeElement(s, start)
+}
+
+func xmlSafe(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' || r == '\r' || r == 0xfffe || r == 0xffff {
+			return false
+		}
+	}
+	return true
+}
+
+func values(vs []string) []value {
+	out := make([]value, len(vs))
+	for i, v := range vs {
+		out[i] = value(v)
+	}
+	return out
+}
+
+func strs(vs []value) []string {
+	out := make([]string, len(vs))
+	for i, v := range vs {
+		out[i] = string(v)
+	}
+	return out
+}
+
+type xmlControl struct {
+	Type        string       `xml:"type,attr"`
+	Criticality bool         `xml:"criticality,attr,omitempty"`
+	Value       *binaryValue `xml:"controlValue"`
+}
+
+func controlsXML(controls []ldif.Control) []xmlControl {
+	out := make([]xmlControl, len(controls))
+	for i, c := range controls {
+		out[i] = xmlControl{Type: c.OID, Criticality: c.Criticality}
+		if c.Value != nil {
+			v := binaryValue(c.Value)
+			out[i].Value = &v
+		}
+	}
+	return out
+}
+
+func controlsFromXML(controls []xmlControl) []ldif.Control {
+	var out []ldif.Control
+	for _, c := range controls {
+		ctrl := ldif.Control{OID: c.Type, Criticality: c.Criticality}
+		if c.Value != nil {
+			ctrl.Value = []byte(*c.Value)
+		}
+		out = append(out, ctrl)
+	}
+	return out
+}
+
+type xmlAttr struct {
+	Name   string  `xml:"name,attr"`
+	Values []value `xml:"value"`
+}
+
+// batchStart is the start of a batch element, declaring the namespaces
+// its content uses.
+func batchStart(name string) xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Space: Namespace, Local: name},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns:xsd"}, Value: xsdNS},
+			{Name: xml.Name{Local: "xmlns:xsi"}, Value: xsiNS},
+		},
+	}
+}
+
+// findBatch reads up to the start of the named batch element, which
+// may be wrapped in a SOAP envelope.
+func findBatch(d *xml.Decoder, name string) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("dsml: no %s found: %v", name, err)
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == name {
+			return nil
+		}
+	}
+}
+
+// readBatch calls fn with each element of a batch, up to its end.
+func readBatch(d *xml.Decoder, fn func(xml.StartElement) error) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("dsml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if err := fn(t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
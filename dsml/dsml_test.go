@@ -0,0 +1,171 @@
+package dsml
+
+import (
+	"bytes"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldif"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequestRoundTrip(t *testing.T) {
+	f, err := ldap.CompileFilter("(&(objectClass=person)(|(cn=a*b*c)(cn=*z))(!(age>=30))(age<=9)(sn~=smyth)(mail=*)(cn:dn:2.5.13.2:=x))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := []Request{
+		{
+			RequestID: "1",
+			Search: &ldap.SearchRequest{
+				BaseObject: []byte("dc=example,dc=com"),
+				Scope:      ldap.WholeSubtree,
+				Deref:      ldap.DerefAlways,
+				SizeLimit:  10,
+				Filter:     f,
+				Attributes: [][]byte{[]byte("cn"), []byte("mail")},
+			},
+			Controls: []ldif.Control{{OID: "1.2.840.113556.1.4.319", Criticality: true, Value: []byte{0x30, 0x00}}},
+		},
+		{RequestID: "2", Change: &ldif.Record{
+			DN: "cn=a,dc=example,dc=com", ChangeType: ldif.Add,
+			Attributes: []ldap.Attribute{
+				{Type: "cn", Values: []string{"a"}},
+				{Type: "jpegPhoto", Values: []string{"\xff\xd8\x00binary"}},
+			},
+		}},
+		{Change: &ldif.Record{
+			DN: "cn=a,dc=example,dc=com", ChangeType: ldif.Modify,
+			Modifications: []ldap.Modification{
+				{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"<&>"}}},
+				{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "mail", Values: []string{}}},
+			},
+		}},
+		{Change: &ldif.Record{
+			DN: "cn=a,dc=example,dc=com", ChangeType: ldif.ModDN,
+			NewRDN: "cn=b", DeleteOldRDN: true, NewSuperior: "ou=people,dc=example,dc=com",
+		}},
+		{Change: &ldif.Record{DN: "cn=b,ou=people,dc=example,dc=com", ChangeType: ldif.Delete}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBatchRequest(&buf, reqs...); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `xsi:type="xsd:base64Binary"`) {
+		t.Errorf("Binary value not written as base64:\n%s", buf.String())
+	}
+	actual, err := ReadBatchRequest(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, reqs) {
+		t.Errorf("Bad result: %+v (expected %+v)", actual, reqs)
+	}
+	expected, _ := ldap.DecompileFilter(f)
+	if s, _ := ldap.DecompileFilter(actual[0].Search.Filter); s != expected {
+		t.Errorf("Bad filter: %s (expected %s)", s, expected)
+	}
+}
+
+const soapRequest = `<?xml version="1.0" encoding="UTF-8"?>
+<soap-env:Envelope xmlns:soap-env="http://schemas.xmlsoap.org/soap/envelope/">
+  <soap-env:Body>
+    <dsml:batchRequest xmlns:dsml="urn:oasis:names:tc:DSML:2:0:core">
+      <dsml:searchRequest requestID="r1" dn="ou=people,dc=example,dc=com" scope="singleLevel" derefAliases="neverDerefAliases">
+        <dsml:filter>
+          <dsml:substrings name="cn"><dsml:initial>Bar</dsml:initial></dsml:substrings>
+        </dsml:filter>
+        <dsml:attributes><dsml:attribute name="cn"/></dsml:attributes>
+      </dsml:searchRequest>
+      <dsml:delRequest dn="cn=x,dc=example,dc=com"/>
+    </dsml:batchRequest>
+  </soap-env:Body>
+</soap-env:Envelope>`
+
+func TestReadSOAPRequest(t *testing.T) {
+	reqs, err := ReadBatchRequest(strings.NewReader(soapRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 2 {
+		t.Fatalf("Bad number of requests: %d (expected 2)", len(reqs))
+	}
+	s := reqs[0].Search
+	if reqs[0].RequestID != "r1" || string(s.BaseObject) != "ou=people,dc=example,dc=com" || s.Scope != ldap.SingleLevel {
+		t.Errorf("Bad search: %+v", reqs[0])
+	}
+	if filter, _ := ldap.DecompileFilter(s.Filter); filter != "(cn=Bar*)" {
+		t.Errorf("Bad result: %v (expected %v)", filter, "(cn=Bar*)")
+	}
+	if c := reqs[1].Change; c.ChangeType != ldif.Delete || c.DN != "cn=x,dc=example,dc=com" {
+		t.Errorf("Bad change: %+v", c)
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	resps := []Response{
+		{
+			Type:      SearchResponse,
+			RequestID: "1",
+			Results: []ldap.SearchResult{
+				{DN: "cn=a,dc=example,dc=com", Attributes: map[string][]string{"cn": {"a"}, "objectClass": {"top", "person"}}},
+				{DN: "cn=b,dc=example,dc=com", Attributes: map[string][]string{"userCertificate;binary": {"\x30\x82\x01"}}},
+			},
+			References: []string{"ldap://other.example.com/dc=example,dc=com"},
+		},
+		{Type: ModifyResponse, RequestID: "2", ResultCode: ldap.NoSuchObject, MatchedDN: "dc=example,dc=com", ErrorMessage: "no such entry"},
+		{Type: ErrorResponse, RequestID: "3", ErrorType: "notAttempted", ErrorMessage: "batch aborted"},
+	}
+	var buf bytes.Buffer
+	if err := WriteBatchResponse(&buf, resps...); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<resultCode code="32" descr="noSuchObject"></resultCode>`) {
+		t.Errorf("Bad result code in:\n%s", buf.String())
+	}
+	actual, err := ReadBatchResponse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, resps) {
+		t.Errorf("Bad result: %+v (expected %+v)", actual, resps)
+	}
+
+	if err := actual[0].Err(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := actual[1].Err(); !ldap.IsErrorWithCode(err, ldap.NoSuchObject) {
+		t.Errorf("Bad error: %v", err)
+	}
+	if err := actual[2].Err(); err == nil || !strings.Contains(err.Error(), "notAttempted") {
+		t.Errorf("Bad error: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		in, err string
+	}{
+		{`<foo/>`, "no batchRequest"},
+		{`<batchRequest><compareRequest dn="x"/></batchRequest>`, "unsupported request compareRequest"},
+		{`<batchRequest><searchRequest dn="x" scope="all" derefAliases="derefAlways"><filter><present name="cn"/></filter></searchRequest></batchRequest>`, "invalid search scope"},
+		{`<batchRequest><searchRequest dn="x" scope="baseObject" derefAliases="derefAlways"><filter><present name="cn"/><present name="sn"/></filter></searchRequest></batchRequest>`, "filter has 2 components"},
+		{`<batchRequest><searchRequest dn="x" scope="baseObject" derefAliases="derefAlways"><filter><bogus/></filter></searchRequest></batchRequest>`, "unsupported filter element bogus"},
+		{`<batchRequest><modifyRequest dn="x"><modification name="cn" operation="increment"/></modifyRequest></batchRequest>`, "unsupported modification operation"},
+		{`<batchRequest><modDNRequest dn="x"/></batchRequest>`, "lacks newrdn"},
+		{`<batchRequest><addRequest dn="x"><attr name="a"><value xsi:type="xsd:base64Binary">!!</value></attr></addRequest></batchRequest>`, "invalid base64"},
+	}
+	for i, test := range tests {
+		_, err := ReadBatchRequest(strings.NewReader(test.in))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, err, test.err)
+		}
+	}
+
+	mod := Request{Change: &ldif.Record{DN: "x", ChangeType: ldif.Modify, Modifications: []ldap.Modification{
+		{Operation: ldap.IncrementValue, Attribute: ldap.Attribute{Type: "n", Values: []string{"1"}}}}}}
+	if err := WriteBatchRequest(&bytes.Buffer{}, mod); err == nil {
+		t.Errorf("Expected error writing an increment")
+	}
+}
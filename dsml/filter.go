@@ -0,0 +1,201 @@
+package dsml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/stesla/ldap"
+	"strings"
+)
+
+// filter is the filter of a searchRequest.
+type filter struct {
+	ldap.Filter
+}
+
+func (f filter) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := encodeFilter(e, f.Filter); err != nil {
+		return err
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (f *filter) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	filters, err := decodeFilters(d)
+	if err != nil {
+		return err
+	}
+	if len(filters) != 1 {
+		return fmt.Errorf("dsml: filter has %d components", len(filters))
+	}
+	f.Filter = filters[0]
+	return nil
+}
+
+type xmlAssertion struct {
+	Name  string `xml:"name,attr"`
+	Value value  `xml:"value"`
+}
+
+type xmlSubstrings struct {
+	Name    string  `xml:"name,attr"`
+	Initial *value  `xml:"initial"`
+	Any     []value `xml:"any"`
+	Final   *value  `xml:"final"`
+}
+
+type xmlPresent struct {
+	Name string `xml:"name,attr"`
+}
+
+type xmlExtensibleMatch struct {
+	Name         string `xml:"name,attr,omitempty"`
+	MatchingRule string `xml:"matchingRule,attr,omitempty"`
+	DnAttributes bool   `xml:"dnAttributes,attr,omitempty"`
+	Value        value  `xml:"value"`
+}
+
+func element(name string) xml.StartElement {
+	return xml.StartElement{Name: xml.Name{Local: name}}
+}
+
+func encodeFilter(e *xml.Encoder, f ldap.Filter) error {
+	set := func(name string, subs []ldap.Filter) error {
+		start := element(name)
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if err := encodeFilter(e, sub); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+	if subs, ok := ldap.AndFilters(f); ok {
+		return set("and", subs)
+	}
+	if subs, ok := ldap.OrFilters(f); ok {
+		return set("or", subs)
+	}
+	if sub, ok := ldap.NotFilter(f); ok {
+		return set("not", []ldap.Filter{sub})
+	}
+	for name, assertion := range map[string]func(ldap.Filter) (string, string, bool){
+		"equalityMatch":  ldap.EqualityAssertion,
+		"greaterOrEqual": ldap.GreaterOrEqualAssertion,
+		"lessOrEqual":    ldap.LessOrEqualAssertion,
+		"approxMatch":    ldap.ApproxAssertion,
+	} {
+		if attr, val, ok := assertion(f); ok {
+			return e.EncodeElement(xmlAssertion{attr, value(val)}, element(name))
+		}
+	}
+	if attr, initial, any, final, ok := ldap.SubstringAssertion(f); ok {
+		x := xmlSubstrings{Name: attr, Any: values(any)}
+		if initial != "" {
+			x.Initial = (*value)(&initial)
+		}
+		if final != "" {
+			x.Final = (*value)(&final)
+		}
+		return e.EncodeElement(x, element("substrings"))
+	}
+	if attr, ok := ldap.PresenceAssertion(f); ok {
+		return e.EncodeElement(xmlPresent{attr}, element("present"))
+	}
+	if rule, attr, val, dn, ok := ldap.ExtensibleAssertion(f); ok {
+		return e.EncodeElement(xmlExtensibleMatch{attr, rule, dn, value(val)}, element("extensibleMatch"))
+	}
+	return fmt.Errorf("dsml: unsupported filter %#v", f)
+}
+
+// decodeFilters decodes the filters within an element, up to its end.
+func decodeFilters(d *xml.Decoder) ([]ldap.Filter, error) {
+	var filters []ldap.Filter
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			f, err := decodeFilter(d, t)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+		case xml.EndElement:
+			return filters, nil
+		}
+	}
+}
+
+func decodeFilter(d *xml.Decoder, start xml.StartElement) (ldap.Filter, error) {
+	switch name := start.Name.Local; name {
+	case "and", "or", "not":
+		subs, err := decodeFilters(d)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case name == "and":
+			return ldap.And(subs...), nil
+		case name == "or":
+			return ldap.Or(subs...), nil
+		case len(subs) != 1:
+			return nil, fmt.Errorf("dsml: not filter has %d components", len(subs))
+		}
+		return ldap.Not(subs[0]), nil
+	case "equalityMatch", "greaterOrEqual", "lessOrEqual", "approxMatch":
+		var x xmlAssertion
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return nil, err
+		}
+		construct := map[string]func(string, string) ldap.Filter{
+			"equalityMatch":  ldap.Equals,
+			"greaterOrEqual": ldap.GreaterOrEqual,
+			"lessOrEqual":    ldap.LessOrEqual,
+			"approxMatch":    ldap.ApproxMatch,
+		}[name]
+		return construct(x.Name, string(x.Value)), nil
+	case "substrings":
+		var x xmlSubstrings
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return nil, err
+		}
+		// The substrings are assembled in the string form, which is
+		// what the ldap package builds substring filters from.
+		parts := []string{""}
+		if x.Initial != nil {
+			parts[0] = ldap.EscapeFilter(string(*x.Initial))
+		}
+		for _, v := range x.Any {
+			parts = append(parts, ldap.EscapeFilter(string(v)))
+		}
+		if x.Final != nil {
+			parts = append(parts, ldap.EscapeFilter(string(*x.Final)))
+		} else {
+			parts = append(parts, "")
+		}
+		if len(parts) == 2 && parts[0] == "" && parts[1] == "" {
+			return nil, fmt.Errorf("dsml: substrings filter without substrings")
+		}
+		return ldap.CompileFilter("(" + x.Name + "=" + strings.Join(parts, "*") + ")")
+	case "present":
+		var x xmlPresent
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return nil, err
+		}
+		return ldap.Present(x.Name), nil
+	case "extensibleMatch":
+		var x xmlExtensibleMatch
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return nil, err
+		}
+		return ldap.ExtensibleMatch(x.MatchingRule, x.Name, string(x.Value), x.DnAttributes), nil
+	}
+	return nil, fmt.Errorf("dsml: unsupported filter element %s", start.Name.Local)
+}
@@ -0,0 +1,255 @@
+package dsml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldif"
+	"io"
+)
+
+var scopeNames = map[ldap.SearchScope]string{
+	ldap.BaseObject:   "baseObject",
+	ldap.SingleLevel:  "singleLevel",
+	ldap.WholeSubtree: "wholeSubtree",
+}
+
+var derefNames = map[ldap.DerefAliases]string{
+	ldap.NeverDerefAliases:   "neverDerefAliases",
+	ldap.DerefInSearching:    "derefInSearching",
+	ldap.DerefFindingBaseObj: "derefFindingBaseObj",
+	ldap.DerefAlways:         "derefAlways",
+}
+
+var operationNames = map[ldap.ModifyOperation]string{
+	ldap.AddValues:     "add",
+	ldap.DeleteValues:  "delete",
+	ldap.ReplaceValues: "replace",
+}
+
+type xmlSearchRequest struct {
+	RequestID    string       `xml:"requestID,attr,omitempty"`
+	DN           string       `xml:"dn,attr"`
+	Scope        string       `xml:"scope,attr"`
+	DerefAliases string       `xml:"derefAliases,attr"`
+	SizeLimit    int          `xml:"sizeLimit,attr,omitempty"`
+	TimeLimit    int          `xml:"timeLimit,attr,omitempty"`
+	TypesOnly    bool         `xml:"typesOnly,attr,omitempty"`
+	Controls     []xmlControl `xml:"control"`
+	Filter       filter       `xml:"filter"`
+	Attributes   []xmlPresent `xml:"attributes>attribute"`
+}
+
+type xmlModification struct {
+	Name      string  `xml:"name,attr"`
+	Operation string  `xml:"operation,attr"`
+	Values    []value `xml:"value"`
+}
+
+type xmlChangeRequest struct {
+	RequestID     string            `xml:"requestID,attr,omitempty"`
+	DN            string            `xml:"dn,attr"`
+	NewRDN        string            `xml:"newrdn,attr,omitempty"`
+	DeleteOldRDN  *bool             `xml:"deleteoldrdn,attr"`
+	NewSuperior   string            `xml:"newSuperior,attr,omitempty"`
+	Controls      []xmlControl      `xml:"control"`
+	Modifications []xmlModification `xml:"modification"`
+	Attributes    []xmlAttr         `xml:"attr"`
+}
+
+var changeElements = map[ldif.ChangeType]string{
+	ldif.NoChange: "addRequest",
+	ldif.Add:      "addRequest",
+	ldif.Delete:   "delRequest",
+	ldif.Modify:   "modifyRequest",
+	ldif.ModRDN:   "modDNRequest",
+	ldif.ModDN:    "modDNRequest",
+}
+
+// WriteBatchRequest writes reqs as a batchRequest. A content record is
+// written as an addRequest.
+func WriteBatchRequest(w io.Writer, reqs ...Request) error {
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	start := batchStart("batchRequest")
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		v, name, err := requestXML(req)
+		if err != nil {
+			return err
+		}
+		if err := e.EncodeElement(v, element(name)); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func requestXML(req Request) (interface{}, string, error) {
+	if s := req.Search; s != nil {
+		x := &xmlSearchRequest{
+			RequestID:    req.RequestID,
+			DN:           string(s.BaseObject),
+			Scope:        scopeNames[s.Scope],
+			DerefAliases: derefNames[s.Deref],
+			SizeLimit:    s.SizeLimit,
+			TimeLimit:    s.TimeLimit,
+			TypesOnly:    s.TypesOnly,
+			Controls:     controlsXML(req.Controls),
+			Filter:       filter{s.Filter},
+		}
+		if x.Scope == "" || x.DerefAliases == "" {
+			return nil, "", fmt.Errorf("dsml: invalid scope or alias dereferencing of search of %q", x.DN)
+		}
+		if s.Filter == nil {
+			x.Filter.Filter = ldap.Present("objectClass")
+		}
+		for _, a := range s.Attributes {
+			x.Attributes = append(x.Attributes, xmlPresent{string(a)})
+		}
+		return x, "searchRequest", nil
+	}
+
+	rec := req.Change
+	if rec == nil {
+		return nil, "", fmt.Errorf("dsml: request has neither a search nor a change")
+	}
+	name, ok := changeElements[rec.ChangeType]
+	if !ok {
+		return nil, "", fmt.Errorf("dsml: unsupported change type %q", rec.ChangeType)
+	}
+	x := &xmlChangeRequest{RequestID: req.RequestID, DN: rec.DN, Controls: controlsXML(rec.Controls)}
+	switch name {
+	case "addRequest":
+		for _, a := range rec.Attributes {
+			x.Attributes = append(x.Attributes, xmlAttr{a.Type, values(a.Values)})
+		}
+	case "modifyRequest":
+		for _, m := range rec.Modifications {
+			op, ok := operationNames[m.Operation]
+			if !ok {
+				return nil, "", fmt.Errorf("dsml: unsupported modification of %s in %q", m.Type, rec.DN)
+			}
+			x.Modifications = append(x.Modifications, xmlModification{m.Type, op, values(m.Values)})
+		}
+	case "modDNRequest":
+		x.NewRDN, x.NewSuperior = rec.NewRDN, rec.NewSuperior
+		x.DeleteOldRDN = &rec.DeleteOldRDN
+	}
+	return x, name, nil
+}
+
+// ReadBatchRequest reads the requests of a batchRequest, which may be
+// wrapped in a SOAP envelope.
+func ReadBatchRequest(r io.Reader) ([]Request, error) {
+	d := xml.NewDecoder(r)
+	if err := findBatch(d, "batchRequest"); err != nil {
+		return nil, err
+	}
+	var reqs []Request
+	err := readBatch(d, func(start xml.StartElement) error {
+		req, err := readRequest(d, start)
+		if err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reqs, nil
+}
+
+func readRequest(d *xml.Decoder, start xml.StartElement) (Request, error) {
+	if start.Name.Local == "searchRequest" {
+		var x xmlSearchRequest
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return Request{}, fmt.Errorf("dsml: %v", err)
+		}
+		return searchFromXML(&x)
+	}
+
+	var changeType ldif.ChangeType
+	switch start.Name.Local {
+	case "addRequest":
+		changeType = ldif.Add
+	case "delRequest":
+		changeType = ldif.Delete
+	case "modifyRequest":
+		changeType = ldif.Modify
+	case "modDNRequest":
+		changeType = ldif.ModDN
+	default:
+		return Request{}, fmt.Errorf("dsml: unsupported request %s", start.Name.Local)
+	}
+	var x xmlChangeRequest
+	if err := d.DecodeElement(&x, &start); err != nil {
+		return Request{}, fmt.Errorf("dsml: %v", err)
+	}
+	rec := &ldif.Record{DN: x.DN, ChangeType: changeType, Controls: controlsFromXML(x.Controls)}
+	for _, a := range x.Attributes {
+		rec.Attributes = append(rec.Attributes, ldap.Attribute{Type: a.Name, Values: strs(a.Values)})
+	}
+	for _, m := range x.Modifications {
+		var op ldap.ModifyOperation = -1
+		for o, name := range operationNames {
+			if name == m.Operation {
+				op = o
+			}
+		}
+		if op < 0 {
+			return Request{}, fmt.Errorf("dsml: unsupported modification operation %q", m.Operation)
+		}
+		rec.Modifications = append(rec.Modifications, ldap.Modification{
+			Operation: op, Attribute: ldap.Attribute{Type: m.Name, Values: strs(m.Values)}})
+	}
+	if changeType == ldif.ModDN {
+		if x.NewRDN == "" || x.DeleteOldRDN == nil {
+			return Request{}, fmt.Errorf("dsml: modDNRequest of %q lacks newrdn or deleteoldrdn", x.DN)
+		}
+		rec.NewRDN, rec.DeleteOldRDN, rec.NewSuperior = x.NewRDN, *x.DeleteOldRDN, x.NewSuperior
+	}
+	return Request{RequestID: x.RequestID, Change: rec}, nil
+}
+
+func searchFromXML(x *xmlSearchRequest) (Request, error) {
+	s := &ldap.SearchRequest{
+		BaseObject: []byte(x.DN),
+		SizeLimit:  x.SizeLimit,
+		TimeLimit:  x.TimeLimit,
+		TypesOnly:  x.TypesOnly,
+		Filter:     x.Filter.Filter,
+		Scope:      -1,
+	}
+	for scope, name := range scopeNames {
+		if name == x.Scope {
+			s.Scope = scope
+		}
+	}
+	if s.Scope < 0 {
+		return Request{}, fmt.Errorf("dsml: invalid search scope %q", x.Scope)
+	}
+	deref, ok := ldap.DerefAliases(-1), false
+	for d, name := range derefNames {
+		if name == x.DerefAliases {
+			deref, ok = d, true
+		}
+	}
+	if !ok {
+		return Request{}, fmt.Errorf("dsml: invalid alias dereferencing %q", x.DerefAliases)
+	}
+	s.Deref = deref
+	if s.Filter == nil {
+		return Request{}, fmt.Errorf("dsml: search of %q has no filter", x.DN)
+	}
+	for _, a := range x.Attributes {
+		s.Attributes = append(s.Attributes, []byte(a.Name))
+	}
+	return Request{RequestID: x.RequestID, Search: s, Controls: controlsFromXML(x.Controls)}, nil
+}
@@ -0,0 +1,184 @@
+package dsml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/stesla/ldap"
+	"io"
+	"sort"
+)
+
+type xmlResultCode struct {
+	Code  int    `xml:"code,attr"`
+	Descr string `xml:"descr,attr,omitempty"`
+}
+
+type xmlResult struct {
+	RequestID    string        `xml:"requestID,attr,omitempty"`
+	MatchedDN    string        `xml:"matchedDN,attr,omitempty"`
+	Controls     []xmlControl  `xml:"control"`
+	ResultCode   xmlResultCode `xml:"resultCode"`
+	ErrorMessage string        `xml:"errorMessage,omitempty"`
+	Referrals    []string      `xml:"referral"`
+}
+
+type xmlEntry struct {
+	DN         string    `xml:"dn,attr"`
+	Attributes []xmlAttr `xml:"attr"`
+}
+
+type xmlReference struct {
+	Refs []string `xml:"ref"`
+}
+
+type xmlSearchResponse struct {
+	RequestID  string         `xml:"requestID,attr,omitempty"`
+	Entries    []xmlEntry     `xml:"searchResultEntry"`
+	References []xmlReference `xml:"searchResultReference"`
+	Done       xmlResult      `xml:"searchResultDone"`
+}
+
+type xmlErrorResponse struct {
+	RequestID string `xml:"requestID,attr,omitempty"`
+	Type      string `xml:"type,attr"`
+	Message   string `xml:"message,omitempty"`
+}
+
+// WriteBatchResponse writes resps as a batchResponse. A search response
+// holding entries serves as a DSML export of them.
+func WriteBatchResponse(w io.Writer, resps ...Response) error {
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	start := batchStart("batchResponse")
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, resp := range resps {
+		v, err := responseXML(&resp)
+		if err != nil {
+			return err
+		}
+		if err := e.EncodeElement(v, element(string(resp.Type))); err != nil {
+			return err
+		}
+	}
+	if err := e.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+func resultXML(r *Response) xmlResult {
+	x := xmlResult{
+		RequestID:    r.RequestID,
+		MatchedDN:    r.MatchedDN,
+		Controls:     controlsXML(r.Controls),
+		ResultCode:   xmlResultCode{Code: int(r.ResultCode)},
+		ErrorMessage: r.ErrorMessage,
+		Referrals:    r.Referrals,
+	}
+	// The schema enumerates the descriptions of the codes of RFC 2251.
+	if r.ResultCode <= ldap.Other {
+		x.ResultCode.Descr = r.ResultCode.String()
+	}
+	return x
+}
+
+func responseXML(r *Response) (interface{}, error) {
+	switch r.Type {
+	case SearchResponse:
+		x := &xmlSearchResponse{RequestID: r.RequestID, Done: resultXML(r)}
+		x.Done.RequestID = ""
+		for _, result := range r.Results {
+			entry := xmlEntry{DN: result.DN}
+			names := make([]string, 0, len(result.Attributes))
+			for name := range result.Attributes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				entry.Attributes = append(entry.Attributes, xmlAttr{name, values(result.Attributes[name])})
+			}
+			x.Entries = append(x.Entries, entry)
+		}
+		if len(r.References) > 0 {
+			x.References = []xmlReference{{r.References}}
+		}
+		return x, nil
+	case AddResponse, ModifyResponse, DelResponse, ModDNResponse:
+		x := resultXML(r)
+		return &x, nil
+	case ErrorResponse:
+		return &xmlErrorResponse{r.RequestID, r.ErrorType, r.ErrorMessage}, nil
+	}
+	return nil, fmt.Errorf("dsml: unsupported response type %q", r.Type)
+}
+
+// ReadBatchResponse reads the responses of a batchResponse, which may be
+// wrapped in a SOAP envelope.
+func ReadBatchResponse(r io.Reader) ([]Response, error) {
+	d := xml.NewDecoder(r)
+	if err := findBatch(d, "batchResponse"); err != nil {
+		return nil, err
+	}
+	var resps []Response
+	err := readBatch(d, func(start xml.StartElement) error {
+		resp, err := readResponse(d, start)
+		if err != nil {
+			return err
+		}
+		resps = append(resps, resp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resps, nil
+}
+
+func readResponse(d *xml.Decoder, start xml.StartElement) (Response, error) {
+	resp := Response{Type: ResponseType(start.Name.Local)}
+	fromResult := func(x *xmlResult) {
+		resp.Controls = controlsFromXML(x.Controls)
+		resp.ResultCode = ldap.ResultCode(x.ResultCode.Code)
+		resp.MatchedDN = x.MatchedDN
+		resp.ErrorMessage = x.ErrorMessage
+		resp.Referrals = x.Referrals
+	}
+	switch resp.Type {
+	case SearchResponse:
+		var x xmlSearchResponse
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return Response{}, fmt.Errorf("dsml: %v", err)
+		}
+		resp.RequestID = x.RequestID
+		resp.Results = []ldap.SearchResult{}
+		for _, entry := range x.Entries {
+			result := ldap.SearchResult{DN: entry.DN, Attributes: map[string][]string{}}
+			for _, a := range entry.Attributes {
+				result.Attributes[a.Name] = append(result.Attributes[a.Name], strs(a.Values)...)
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		for _, ref := range x.References {
+			resp.References = append(resp.References, ref.Refs...)
+		}
+		fromResult(&x.Done)
+	case AddResponse, ModifyResponse, DelResponse, ModDNResponse:
+		var x xmlResult
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return Response{}, fmt.Errorf("dsml: %v", err)
+		}
+		resp.RequestID = x.RequestID
+		fromResult(&x)
+	case ErrorResponse:
+		var x xmlErrorResponse
+		if err := d.DecodeElement(&x, &start); err != nil {
+			return Response{}, fmt.Errorf("dsml: %v", err)
+		}
+		resp.RequestID, resp.ErrorType, resp.ErrorMessage = x.RequestID, x.Type, x.Message
+	default:
+		return Response{}, fmt.Errorf("dsml: unsupported response %s", start.Name.Local)
+	}
+	return resp, nil
+}
@@ -0,0 +1,203 @@
+package ldap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// An Entry is a directory entry with its attributes in a fixed order.
+// Attribute names are matched case-insensitively, as in LDAP.
+type Entry struct {
+	DN         string
+	Attributes []*EntryAttribute
+}
+
+type EntryAttribute struct {
+	Name       string
+	Values     []string
+	ByteValues [][]byte
+}
+
+func NewEntryAttribute(name string, values []string) *EntryAttribute {
+	a := &EntryAttribute{Name: name, Values: values}
+	for _, v := range values {
+		a.ByteValues = append(a.ByteValues, []byte(v))
+	}
+	return a
+}
+
+// NewEntry returns an entry with the given attributes, sorted by name.
+func NewEntry(dn string, attributes map[string][]string) *Entry {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e := &Entry{DN: dn}
+	for _, name := range names {
+		e.Attributes = append(e.Attributes, NewEntryAttribute(name, attributes[name]))
+	}
+	return e
+}
+
+// Entry returns the result as an Entry.
+func (r SearchResult) Entry() *Entry {
+	return NewEntry(r.DN, r.Attributes)
+}
+
+// GetAttribute returns the attribute with the given name, or nil.
+func (e *Entry) GetAttribute(name string) *EntryAttribute {
+	for _, a := range e.Attributes {
+		if strings.EqualFold(a.Name, name) {
+			return a
+		}
+	}
+	return nil
+}
+
+func (e *Entry) GetAttributeValues(name string) []string {
+	if a := e.GetAttribute(name); a != nil {
+		return a.Values
+	}
+	return []string{}
+}
+
+// GetAttributeValue returns the first value of the named attribute, or
+// "" if it has none.
+func (e *Entry) GetAttributeValue(name string) string {
+	if values := e.GetAttributeValues(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (e *Entry) GetRawAttributeValues(name string) [][]byte {
+	if a := e.GetAttribute(name); a != nil {
+		return a.ByteValues
+	}
+	return [][]byte{}
+}
+
+func (e *Entry) GetRawAttributeValue(name string) []byte {
+	if values := e.GetRawAttributeValues(name); len(values) > 0 {
+		return values[0]
+	}
+	return []byte{}
+}
+
+// SplitAttributeDescription splits an attribute description (RFC 4512
+// §2.5), such as "cn;lang-en", into its type and its options, which are
+// lower-cased.
+func SplitAttributeDescription(desc string) (attrType string, options []string) {
+	parts := strings.Split(strings.TrimSpace(desc), ";")
+	for _, o := range parts[1:] {
+		if o != "" {
+			options = append(options, strings.ToLower(o))
+		}
+	}
+	return parts[0], options
+}
+
+// normalizeAttributeDescription lower-cases and sorts the options of
+// desc, which are unordered.
+func normalizeAttributeDescription(desc string) string {
+	attrType, options := SplitAttributeDescription(desc)
+	sort.Strings(options)
+	return strings.Join(append([]string{attrType}, options...), ";")
+}
+
+// AttributeList returns names for SearchRequest.Attributes, with their
+// options normalized and repeats, compared case-insensitively, removed.
+func AttributeList(names ...string) [][]byte {
+	seen := map[string]bool{}
+	out := [][]byte{}
+	for _, name := range names {
+		desc := normalizeAttributeDescription(name)
+		if key := strings.ToLower(desc); desc != "" && !seen[key] {
+			seen[key] = true
+			out = append(out, []byte(desc))
+		}
+	}
+	return out
+}
+
+// GetAttributesByType returns the attributes that name describes: those
+// of its type with at least its options, so that "cn" matches
+// "cn;lang-en" as well as "cn", which GetAttribute does not.
+func (e *Entry) GetAttributesByType(name string) []*EntryAttribute {
+	var out []*EntryAttribute
+	for _, a := range e.Attributes {
+		if describes(name, a.Name) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// describes reports whether the attribute description name selects the
+// attribute desc: whether they have the same type and desc has all of
+// name's options.
+func describes(name, desc string) bool {
+	attrType, options := SplitAttributeDescription(name)
+	t, opts := SplitAttributeDescription(desc)
+	if !strings.EqualFold(t, attrType) {
+		return false
+	}
+	for _, o := range options {
+		if !contains(opts, o) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetValuesByType returns the values of the attributes that name
+// describes, as GetAttributesByType matches them, without repeats.
+func (e *Entry) GetValuesByType(name string) []string {
+	seen := map[string]bool{}
+	values := []string{}
+	for _, a := range e.GetAttributesByType(name) {
+		for _, v := range a.Values {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// AttributeMap returns the attributes keyed by lower-cased name, merging
+// the values of attributes whose names differ only in case.
+func (e *Entry) AttributeMap() map[string][]string {
+	m := make(map[string][]string, len(e.Attributes))
+	for _, a := range e.Attributes {
+		key := strings.ToLower(a.Name)
+		m[key] = append(m[key], a.Values...)
+	}
+	return m
+}
+
+func (e *Entry) Print() {
+	e.PrettyPrint(0)
+}
+
+// PrettyPrint writes the entry to standard output in an LDIF-like form,
+// indented by indent spaces.
+func (e *Entry) PrettyPrint(indent int) {
+	pad := strings.Repeat(" ", indent)
+	fmt.Printf("%sDN: %s\n", pad, e.DN)
+	for _, a := range e.Attributes {
+		a.PrettyPrint(indent + 2)
+	}
+}
+
+func (a *EntryAttribute) Print() {
+	a.PrettyPrint(0)
+}
+
+func (a *EntryAttribute) PrettyPrint(indent int) {
+	fmt.Printf("%s%s: %s\n", strings.Repeat(" ", indent), a.Name, a.Values)
+}
@@ -0,0 +1,117 @@
+package ldap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEntryAccessors(t *testing.T) {
+	e := SearchResult{"cn=Jane,dc=example,dc=com", map[string][]string{
+		"objectClass": {"top", "person"},
+		"cn":          {"Jane"},
+	}}.Entry()
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"cn", []string{"Jane"}},
+		{"OBJECTCLASS", []string{"top", "person"}},
+		{"sn", []string{}},
+	}
+	for i, test := range tests {
+		if result := e.GetAttributeValues(test.name); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, result, test.expected)
+		}
+	}
+
+	if result := e.GetAttributeValue("objectclass"); result != "top" {
+		t.Errorf("Bad result: %q (expected %q)", result, "top")
+	}
+	if result := e.GetRawAttributeValue("ObjectClass"); string(result) != "top" {
+		t.Errorf("Bad result: %q (expected %q)", result, "top")
+	}
+	if result := e.GetAttributeValue("sn"); result != "" {
+		t.Errorf("Bad result: %q (expected %q)", result, "")
+	}
+
+	expected := map[string][]string{
+		"cn":          {"Jane"},
+		"objectclass": {"top", "person"},
+	}
+	if result := e.AttributeMap(); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Bad result: %v (expected %v)", result, expected)
+	}
+}
+
+func TestAttributeDescriptions(t *testing.T) {
+	e := SearchResult{"cn=Jane,dc=example,dc=com", map[string][]string{
+		"cn":                  {"Jane"},
+		"CN;lang-EN":          {"Jane", "Janie"},
+		"cn;lang-en;phonetic": {"Jein"},
+		"cnx":                 {"x"},
+	}}.Entry()
+
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"cn", []string{"Jane", "Janie", "Jein"}},
+		{"CN;Lang-En", []string{"Jane", "Janie", "Jein"}},
+		{"cn;phonetic", []string{"Jein"}},
+		{"cn;lang-fr", []string{}},
+	}
+	for i, test := range tests {
+		if result := e.GetValuesByType(test.name); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, result, test.expected)
+		}
+	}
+
+	attrType, options := SplitAttributeDescription(" member;Range=0-1499 ")
+	if attrType != "member" || !reflect.DeepEqual(options, []string{"range=0-1499"}) {
+		t.Errorf("Bad result: %q, %q", attrType, options)
+	}
+
+	list := AttributeList("cn", "CN", "cn;lang-EN;binary", "cn;binary;lang-en", "", "mail")
+	expected := [][]byte{[]byte("cn"), []byte("cn;binary;lang-en"), []byte("mail")}
+	if !reflect.DeepEqual(list, expected) {
+		t.Errorf("Bad result: %q (expected %q)", list, expected)
+	}
+}
+
+// duplicatingConn returns every search result twice.
+type duplicatingConn struct {
+	directoryConn
+}
+
+func (c *duplicatingConn) Search(req SearchRequest) ([]SearchResult, error) {
+	results, err := c.directoryConn.Search(req)
+	return append(results, results...), err
+}
+
+func TestGetEntry(t *testing.T) {
+	const base = "dc=example,dc=com"
+	c := &directoryConn{entries: []*Entry{
+		NewEntry(base, map[string][]string{"objectClass": {"domain"}, "dc": {"example"}}),
+		NewEntry("cn=hidden,"+base, map[string][]string{"cn": {"hidden"}}),
+	}}
+
+	e, err := getEntry(c, "DC=Example,dc=com", []string{"dc"})
+	if err != nil || e.DN != base || e.GetAttributeValue("dc") != "example" {
+		t.Errorf("Bad result: %v, %v", e, err)
+	}
+	var ldapErr *Error
+	if _, err := getEntry(c, "cn=missing,"+base, nil); !errors.Is(err, ErrNotFound) || !errors.As(err, &ldapErr) {
+		t.Errorf("Bad result: %v (expected a noSuchObject ErrNotFound)", err)
+	}
+	if _, err := getEntry(c, "cn=hidden,"+base, nil); err != ErrNotFound {
+		t.Errorf("Bad result: %v (expected ErrNotFound)", err)
+	}
+	if _, err := getEntry(&duplicatingConn{*c}, base, nil); err != ErrTooManyEntries {
+		t.Errorf("Bad result: %v (expected ErrTooManyEntries)", err)
+	}
+	if errors.Is(&Error{ResultCode: NoSuchAttribute}, ErrNotFound) {
+		t.Error("Expected only noSuchObject to match ErrNotFound")
+	}
+}
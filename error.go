@@ -1,5 +1,11 @@
 package ldap
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
 type LDAPError struct {
 	Msg string
 }
@@ -7,3 +13,178 @@ type LDAPError struct {
 func (e LDAPError) Error() string { return "LDAP error: " + e.Msg }
 
 var notimpl = LDAPError{"Not Implemented"}
+
+var (
+	// ErrNotFound is returned by GetEntry when the entry does not exist
+	// or cannot be read. An Error with the result code noSuchObject also
+	// matches it with errors.Is.
+	ErrNotFound = errors.New("ldap: entry not found")
+	// ErrTooManyEntries is returned by GetEntry when the server returns
+	// more than one entry.
+	ErrTooManyEntries = errors.New("ldap: more than one entry found")
+)
+
+type ResultCode int16
+
+// Result codes from RFC 4511 §4.1.9 and the extensions this package
+// supports.
+const (
+	Success                      ResultCode = 0
+	OperationsError              ResultCode = 1
+	ProtocolError                ResultCode = 2
+	TimeLimitExceeded            ResultCode = 3
+	SizeLimitExceeded            ResultCode = 4
+	CompareFalse                 ResultCode = 5
+	CompareTrue                  ResultCode = 6
+	AuthMethodNotSupported       ResultCode = 7
+	StrongerAuthRequired         ResultCode = 8
+	Referral                     ResultCode = 10
+	AdminLimitExceeded           ResultCode = 11
+	UnavailableCriticalExtension ResultCode = 12
+	ConfidentialityRequired      ResultCode = 13
+	SaslBindInProgress           ResultCode = 14
+	NoSuchAttribute              ResultCode = 16
+	UndefinedAttributeType       ResultCode = 17
+	InappropriateMatching        ResultCode = 18
+	ConstraintViolation          ResultCode = 19
+	AttributeOrValueExists       ResultCode = 20
+	InvalidAttributeSyntax       ResultCode = 21
+	NoSuchObject                 ResultCode = 32
+	AliasProblem                 ResultCode = 33
+	InvalidDNSyntax              ResultCode = 34
+	AliasDereferencingProblem    ResultCode = 36
+	InappropriateAuthentication  ResultCode = 48
+	InvalidCredentials           ResultCode = 49
+	InsufficientAccessRights     ResultCode = 50
+	Busy                         ResultCode = 51
+	Unavailable                  ResultCode = 52
+	UnwillingToPerform           ResultCode = 53
+	LoopDetect                   ResultCode = 54
+	NamingViolation              ResultCode = 64
+	ObjectClassViolation         ResultCode = 65
+	NotAllowedOnNonLeaf          ResultCode = 66
+	NotAllowedOnRDN              ResultCode = 67
+	EntryAlreadyExists           ResultCode = 68
+	ObjectClassModsProhibited    ResultCode = 69
+	AffectsMultipleDSAs          ResultCode = 71
+	Other                        ResultCode = 80
+
+	// RFC 3909
+	Canceled        ResultCode = 118
+	NoSuchOperation ResultCode = 119
+	TooLate         ResultCode = 120
+	CannotCancel    ResultCode = 121
+	// RFC 4528
+	AssertionFailed ResultCode = 122
+	// RFC 4370
+	AuthorizationDenied ResultCode = 123
+	// RFC 4533
+	SyncRefreshRequired ResultCode = 4096
+)
+
+var resultCodeNames = map[ResultCode]string{
+	Success:                      "success",
+	OperationsError:              "operationsError",
+	ProtocolError:                "protocolError",
+	TimeLimitExceeded:            "timeLimitExceeded",
+	SizeLimitExceeded:            "sizeLimitExceeded",
+	CompareFalse:                 "compareFalse",
+	CompareTrue:                  "compareTrue",
+	AuthMethodNotSupported:       "authMethodNotSupported",
+	StrongerAuthRequired:         "strongerAuthRequired",
+	Referral:                     "referral",
+	AdminLimitExceeded:           "adminLimitExceeded",
+	UnavailableCriticalExtension: "unavailableCriticalExtension",
+	ConfidentialityRequired:      "confidentialityRequired",
+	SaslBindInProgress:           "saslBindInProgress",
+	NoSuchAttribute:              "noSuchAttribute",
+	UndefinedAttributeType:       "undefinedAttributeType",
+	InappropriateMatching:        "inappropriateMatching",
+	ConstraintViolation:          "constraintViolation",
+	AttributeOrValueExists:       "attributeOrValueExists",
+	InvalidAttributeSyntax:       "invalidAttributeSyntax",
+	NoSuchObject:                 "noSuchObject",
+	AliasProblem:                 "aliasProblem",
+	InvalidDNSyntax:              "invalidDNSyntax",
+	AliasDereferencingProblem:    "aliasDereferencingProblem",
+	InappropriateAuthentication:  "inappropriateAuthentication",
+	InvalidCredentials:           "invalidCredentials",
+	InsufficientAccessRights:     "insufficientAccessRights",
+	Busy:                         "busy",
+	Unavailable:                  "unavailable",
+	UnwillingToPerform:           "unwillingToPerform",
+	LoopDetect:                   "loopDetect",
+	NamingViolation:              "namingViolation",
+	ObjectClassViolation:         "objectClassViolation",
+	NotAllowedOnNonLeaf:          "notAllowedOnNonLeaf",
+	NotAllowedOnRDN:              "notAllowedOnRDN",
+	EntryAlreadyExists:           "entryAlreadyExists",
+	ObjectClassModsProhibited:    "objectClassModsProhibited",
+	AffectsMultipleDSAs:          "affectsMultipleDSAs",
+	Other:                        "other",
+	Canceled:                     "canceled",
+	NoSuchOperation:              "noSuchOperation",
+	TooLate:                      "tooLate",
+	CannotCancel:                 "cannotCancel",
+	AssertionFailed:              "assertionFailed",
+	AuthorizationDenied:          "authorizationDenied",
+	SyncRefreshRequired:          "e-syncRefreshRequired",
+}
+
+func (c ResultCode) String() string {
+	if name, ok := resultCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("resultCode(%d)", int(c))
+}
+
+// An Error is a failure reported by the server in an LDAPResult.
+// errors.Is matches an Error against another with the same ResultCode,
+// so callers can write
+//
+//	if errors.Is(err, &ldap.Error{ResultCode: ldap.NoSuchObject}) {
+//
+// or use IsErrorWithCode.
+type Error struct {
+	ResultCode        ResultCode
+	MatchedDN         string
+	DiagnosticMessage string
+	Referrals         []string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("ldap: %v (%d)", e.ResultCode, int(e.ResultCode))
+	if e.DiagnosticMessage != "" {
+		msg += ": " + e.DiagnosticMessage
+	}
+	if e.MatchedDN != "" {
+		msg += fmt.Sprintf(" (matched DN %q)", e.MatchedDN)
+	}
+	if len(e.Referrals) > 0 {
+		msg += " (referrals: " + strings.Join(e.Referrals, " ") + ")"
+	}
+	return msg
+}
+
+func (e *Error) Is(target error) bool {
+	if target == ErrNotFound {
+		return e.ResultCode == NoSuchObject
+	}
+	t, ok := target.(*Error)
+	return ok && t.ResultCode == e.ResultCode
+}
+
+// IsErrorWithCode reports whether err is, or wraps, an Error with one of
+// the given result codes.
+func IsErrorWithCode(err error, codes ...ResultCode) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	for _, code := range codes {
+		if e.ResultCode == code {
+			return true
+		}
+	}
+	return false
+}
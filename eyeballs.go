@@ -0,0 +1,128 @@
+package ldap
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultFallbackDelay is the delay between connection attempts to the
+// addresses of a host recommended by RFC 8305.
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// lookupIPAddr is replaced by tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// dialTCP connects to addr. If its host has several addresses they are
+// raced (RFC 8305): an attempt starts every delay, or as soon as the
+// previous one fails, and the first to connect wins. A negative delay
+// dials as net.Dialer does.
+func dialTCP(ctx context.Context, d *net.Dialer, addr string, delay time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || delay < 0 || net.ParseIP(host) != nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	ips, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range interleaveFamilies(ips) {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	if delay == 0 {
+		delay = DefaultFallbackDelay
+	}
+	return raceDial(ctx, addrs, delay, func(ctx context.Context, addr string) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", addr)
+	})
+}
+
+// interleaveFamilies orders ips alternating between IPv6 and IPv4,
+// starting with the family of the first, as RFC 8305 §4 recommends.
+func interleaveFamilies(ips []net.IPAddr) []net.IPAddr {
+	var first, second []net.IPAddr
+	for _, ip := range ips {
+		if (ip.IP.To4() == nil) == (ips[0].IP.To4() == nil) {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	out := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// raceDial dials addrs in order, starting an attempt every delay or when
+// the previous one fails, and returns the first connection made, closing
+// any others. If all fail it returns the first error.
+func raceDial(ctx context.Context, addrs []string, delay time.Duration, dial func(context.Context, string) (net.Conn, error)) (net.Conn, error) {
+	if len(addrs) == 1 {
+		return dial(ctx, addrs[0])
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			c, err := dial(ctx, addr)
+			results <- result{c, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.c != nil {
+							r.c.Close()
+						}
+					}
+				}(pending)
+				return r.c, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(addrs) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, firstErr
+}
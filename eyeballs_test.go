@@ -0,0 +1,158 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	ip := func(s string) net.IPAddr { return net.IPAddr{IP: net.ParseIP(s)} }
+	tests := []struct {
+		in, out []string
+	}{
+		{[]string{"::1", "::2", "10.0.0.1", "10.0.0.2", "10.0.0.3"}, []string{"::1", "10.0.0.1", "::2", "10.0.0.2", "10.0.0.3"}},
+		{[]string{"10.0.0.1", "10.0.0.2", "::1"}, []string{"10.0.0.1", "::1", "10.0.0.2"}},
+		{[]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1", "10.0.0.2"}},
+	}
+	for i, test := range tests {
+		var in []net.IPAddr
+		for _, s := range test.in {
+			in = append(in, ip(s))
+		}
+		var out []string
+		for _, a := range interleaveFamilies(in) {
+			out = append(out, a.IP.String())
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, out, test.out)
+		}
+	}
+}
+
+// raceTest dials "hang" addresses until cancelled, fails "fail" ones,
+// and connects to the rest, recording when each attempt started.
+type raceTest struct {
+	mu      sync.Mutex
+	started []string
+	closed  []string
+}
+
+type raceTestConn struct {
+	net.Conn
+	t    *raceTest
+	addr string
+}
+
+func (c *raceTestConn) Close() error {
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+	c.t.closed = append(c.t.closed, c.addr)
+	return nil
+}
+
+func (t *raceTest) dial(ctx context.Context, addr string) (net.Conn, error) {
+	t.mu.Lock()
+	t.started = append(t.started, addr)
+	t.mu.Unlock()
+	switch addr {
+	case "hang":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case "fail":
+		return nil, fmt.Errorf("refused")
+	case "slow":
+		time.Sleep(50 * time.Millisecond)
+	}
+	return &raceTestConn{t: t, addr: addr}, nil
+}
+
+func TestRaceDial(t *testing.T) {
+	tests := []struct {
+		addrs   []string
+		delay   time.Duration
+		winner  string
+		started []string
+	}{
+		{[]string{"a", "b"}, time.Hour, "a", []string{"a"}},
+		{[]string{"fail", "fail", "a"}, time.Hour, "a", []string{"fail", "fail", "a"}},
+		{[]string{"hang", "a"}, 10 * time.Millisecond, "a", []string{"hang", "a"}},
+		{[]string{"hang", "hang", "a"}, 10 * time.Millisecond, "a", []string{"hang", "hang", "a"}},
+		{[]string{"fail", "fail"}, time.Hour, "", []string{"fail", "fail"}},
+	}
+	for i, test := range tests {
+		rt := &raceTest{}
+		c, err := raceDial(context.Background(), test.addrs, test.delay, rt.dial)
+		var winner string
+		if c != nil {
+			winner = c.(*raceTestConn).addr
+		}
+		if winner != test.winner || (err == nil) != (test.winner != "") {
+			t.Errorf("#%d: Bad result: %q, %v (expected %q)", i, winner, err, test.winner)
+		}
+		rt.mu.Lock()
+		if !reflect.DeepEqual(rt.started, test.started) {
+			t.Errorf("#%d: Bad attempts: %v (expected %v)", i, rt.started, test.started)
+		}
+		rt.mu.Unlock()
+	}
+
+	// A connection made after the winner's is closed.
+	rt := &raceTest{}
+	c, err := raceDial(context.Background(), []string{"slow", "a"}, time.Millisecond, rt.dial)
+	if err != nil || c.(*raceTestConn).addr != "a" {
+		t.Fatalf("Bad result: %v, %v", c, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	rt.mu.Lock()
+	if !reflect.DeepEqual(rt.closed, []string{"slow"}) {
+		t.Errorf("Bad closed: %v (expected [slow])", rt.closed)
+	}
+	rt.mu.Unlock()
+
+	// Cancelling the context stops all attempts.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := raceDial(ctx, []string{"hang", "hang"}, time.Millisecond, (&raceTest{}).dial); err != context.DeadlineExceeded {
+		t.Errorf("Bad result: %v (expected %v)", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDialTCPMultipleAddresses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	defer func(f func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = f }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host != "dc.example.com" {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		// Nothing listens on 127.0.0.2, so its attempt is refused.
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.2")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	c, err := dialTCP(context.Background(), &net.Dialer{}, net.JoinHostPort("dc.example.com", port), time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer c.Close()
+	if addr := c.RemoteAddr().String(); addr != l.Addr().String() {
+		t.Errorf("Bad result: %v (expected %v)", addr, l.Addr())
+	}
+}
@@ -1,6 +1,7 @@
 package ldap
 
 import (
+	"fmt"
 	"github.com/stesla/ldap/asn1"
 )
 
@@ -27,6 +28,21 @@ func Equals(attribute, value string) Filter {
 	return asn1.OptionValue{Opts: "tag:3", Value: val}
 }
 
+func GreaterOrEqual(attribute, value string) Filter {
+	val := attributeValueAssertion{[]byte(attribute), []byte(value)}
+	return asn1.OptionValue{Opts: "tag:5", Value: val}
+}
+
+func LessOrEqual(attribute, value string) Filter {
+	val := attributeValueAssertion{[]byte(attribute), []byte(value)}
+	return asn1.OptionValue{Opts: "tag:6", Value: val}
+}
+
+func ApproxMatch(attribute, value string) Filter {
+	val := attributeValueAssertion{[]byte(attribute), []byte(value)}
+	return asn1.OptionValue{Opts: "tag:8", Value: val}
+}
+
 type substring asn1.OptionValue
 
 type substringFilter struct {
@@ -59,6 +75,35 @@ func Substring(attribute string, substrings ...substring) Filter {
 	return asn1.OptionValue{Opts: "tag:4", Value: val}
 }
 
+// SubstringMatch returns a filter matching values of attribute that
+// start with initial, contain the strings of any in order, and end with
+// final. Empty initial and final values are left out, but at least one
+// substring is required, and none of any may be empty. The values are
+// taken literally, so a '*' in untrusted input is not a wildcard;
+// DecompileFilter escapes it if a string filter is needed.
+func SubstringMatch(attribute, initial string, any []string, final string) (Filter, error) {
+	if !validAttributeDescription(attribute) {
+		return nil, fmt.Errorf("ldap: invalid attribute description %q", attribute)
+	}
+	var subs []substring
+	if initial != "" {
+		subs = append(subs, InitialSubstring(initial))
+	}
+	for _, s := range any {
+		if s == "" {
+			return nil, fmt.Errorf("ldap: empty substring in filter on %s", attribute)
+		}
+		subs = append(subs, AnySubstring(s))
+	}
+	if final != "" {
+		subs = append(subs, FinalSubstring(final))
+	}
+	if len(subs) == 0 {
+		return nil, fmt.Errorf("ldap: substring filter on %s needs a substring", attribute)
+	}
+	return Substring(attribute, subs...), nil
+}
+
 func Present(attribute string) Filter {
 	return asn1.OptionValue{Opts: "tag:7", Value: []byte(attribute)}
 }
@@ -67,11 +112,127 @@ type matchingRuleAssertion struct {
 	MatchingRule []byte `asn1:"tag:1,optional"`
 	Type         []byte `asn1:"tag:2,optional"`
 	MatchValue   []byte `asn1:"tag:3"`
-	DnAttributes bool   `asn1:"tag:4"`
+	// DnAttributes is left out when false, its default, as RFC 4511
+	// §5.1 requires.
+	DnAttributes bool `asn1:"tag:4,optional"`
 }
 
 func Matches(rule, attribute, value string) Filter {
+	return ExtensibleMatch(rule, attribute, value, false)
+}
+
+// ExtensibleMatch is like Matches, but if dnAttributes is set the
+// attributes of the entry's DN are matched as well. Either rule or
+// attribute may be empty.
+func ExtensibleMatch(rule, attribute, value string, dnAttributes bool) Filter {
 	val := matchingRuleAssertion{
-		[]byte(rule), []byte(attribute), []byte(value), false}
+		optionalBytes(rule), optionalBytes(attribute), []byte(value), dnAttributes}
 	return asn1.OptionValue{Opts: "tag:9", Value: val}
 }
+
+// EqualityAssertion returns the attribute and value of an equality
+// filter, e.g. for a server to look them up in an index.
+func EqualityAssertion(f Filter) (attribute, value string, ok bool) {
+	return valueAssertion(f, 3)
+}
+
+// GreaterOrEqualAssertion returns the attribute and value of a
+// greater-or-equal filter.
+func GreaterOrEqualAssertion(f Filter) (attribute, value string, ok bool) {
+	return valueAssertion(f, 5)
+}
+
+// LessOrEqualAssertion returns the attribute and value of a
+// less-or-equal filter.
+func LessOrEqualAssertion(f Filter) (attribute, value string, ok bool) {
+	return valueAssertion(f, 6)
+}
+
+// ApproxAssertion returns the attribute and value of an approximate
+// match filter.
+func ApproxAssertion(f Filter) (attribute, value string, ok bool) {
+	return valueAssertion(f, 8)
+}
+
+func valueAssertion(f Filter, tag int) (attribute, value string, ok bool) {
+	ov, isOV := f.(asn1.OptionValue)
+	if t, _ := filterTag(ov); !isOV || t != tag {
+		return "", "", false
+	}
+	ava, ok := ov.Value.(attributeValueAssertion)
+	return string(ava.Attribute), string(ava.Value), ok
+}
+
+// SubstringAssertion returns the attribute and substrings of a substring
+// filter. initial and final are empty if absent.
+func SubstringAssertion(f Filter) (attribute, initial string, any []string, final string, ok bool) {
+	ov, isOV := f.(asn1.OptionValue)
+	if tag, _ := filterTag(ov); !isOV || tag != 4 {
+		return "", "", nil, "", false
+	}
+	sf, ok := ov.Value.(substringFilter)
+	if !ok {
+		return "", "", nil, "", false
+	}
+	for _, sub := range sf.Substrings {
+		tag, _ := filterTag(sub)
+		val, _ := sub.Value.([]byte)
+		switch tag {
+		case 0:
+			initial = string(val)
+		case 1:
+			any = append(any, string(val))
+		case 2:
+			final = string(val)
+		}
+	}
+	return string(sf.Attribute), initial, any, final, true
+}
+
+// ExtensibleAssertion returns the parts of an extensible match filter.
+func ExtensibleAssertion(f Filter) (rule, attribute, value string, dnAttributes, ok bool) {
+	ov, isOV := f.(asn1.OptionValue)
+	if tag, _ := filterTag(ov); !isOV || tag != 9 {
+		return "", "", "", false, false
+	}
+	mra, ok := ov.Value.(matchingRuleAssertion)
+	return string(mra.MatchingRule), string(mra.Type), string(mra.MatchValue), mra.DnAttributes, ok
+}
+
+// PresenceAssertion returns the attribute of a presence filter.
+func PresenceAssertion(f Filter) (attribute string, ok bool) {
+	ov, isOV := f.(asn1.OptionValue)
+	if tag, _ := filterTag(ov); !isOV || tag != 7 {
+		return "", false
+	}
+	attr, ok := ov.Value.([]byte)
+	return string(attr), ok
+}
+
+// AndFilters returns the subfilters of an and filter.
+func AndFilters(f Filter) ([]Filter, bool) {
+	return setFilters(f, 0)
+}
+
+// OrFilters returns the subfilters of an or filter.
+func OrFilters(f Filter) ([]Filter, bool) {
+	return setFilters(f, 1)
+}
+
+func setFilters(f Filter, tag int) ([]Filter, bool) {
+	ov, isOV := f.(asn1.OptionValue)
+	if t, _ := filterTag(ov); !isOV || t != tag {
+		return nil, false
+	}
+	filters, ok := ov.Value.([]Filter)
+	return filters, ok
+}
+
+// NotFilter returns the subfilter of a not filter.
+func NotFilter(f Filter) (Filter, bool) {
+	ov, isOV := f.(asn1.OptionValue)
+	if tag, _ := filterTag(ov); !isOV || tag != 2 {
+		return nil, false
+	}
+	return ov.Value, true
+}
@@ -0,0 +1,444 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected Filter
+	}{
+		{"(objectClass=*)", Present("objectClass")},
+		{"cn=Jane", Equals("cn", "Jane")},
+		{"(cn=a\\2ab\\29)", Equals("cn", "a*b)")},
+		{"(uid=jm*)", Substring("uid", InitialSubstring("jm"))},
+		{"(cn=*carbo)", Substring("cn", FinalSubstring("carbo"))},
+		{"(cn=a*b*c*d)", Substring("cn", InitialSubstring("a"), AnySubstring("b"), AnySubstring("c"), FinalSubstring("d"))},
+		{"(cn=*b*)", Substring("cn", AnySubstring("b"))},
+		{"(age>=21)", GreaterOrEqual("age", "21")},
+		{"(age<=65)", LessOrEqual("age", "65")},
+		{"(sn~=smith)", ApproxMatch("sn", "smith")},
+		{"(cn:caseExactMatch:=Fred)", Matches("caseExactMatch", "cn", "Fred")},
+		{"(o:dn:=Ace)", ExtensibleMatch("", "o", "Ace", true)},
+		{"(:dn:2.4.6.8.10:=Dino)", ExtensibleMatch("2.4.6.8.10", "", "Dino", true)},
+		{"(:caseIgnoreMatch:=fred)", Matches("caseIgnoreMatch", "", "fred")},
+		{"(cn:=Fred)", Matches("", "cn", "Fred")},
+		{"(o:DN:=Ace)", ExtensibleMatch("", "o", "Ace", true)},
+		{"(sn;lang-en~=sm\\2ath)", ApproxMatch("sn;lang-en", "sm*th")},
+		{
+			"(memberOf:1.2.840.113556.1.4.1941:=cn=Admins \\28EU\\29,dc=example,dc=com)",
+			Matches("1.2.840.113556.1.4.1941", "memberOf", "cn=Admins (EU),dc=example,dc=com"),
+		},
+		{"(userAccountControl:1.2.840.113556.1.4.803:=2)", Matches("1.2.840.113556.1.4.803", "userAccountControl", "2")},
+		{"(!(cn=x))", Not(Equals("cn", "x"))},
+		{"(&)", And()},
+		{
+			"(&(objectClass=person)(|(uid=jm*)(cn=*carbo)))",
+			And(Equals("objectClass", "person"), Or(
+				Substring("uid", InitialSubstring("jm")),
+				Substring("cn", FinalSubstring("carbo")))),
+		},
+	}
+	for i, test := range tests {
+		result, err := CompileFilter(test.in)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("#%d: Bad result: %#v (expected %#v)", i, result, test.expected)
+		}
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"(cn=x",
+		"(cn=x))",
+		"(=x)",
+		"(cn)",
+		"(cn=a**b)",
+		"(cn=\\2)",
+		"(cn=\\zz)",
+		"(:=x)",
+		"(c n=x)",
+		"(sn~=sm*th)",
+		"(age>=2*)",
+		"(cn:caseExactMatch:=F*)",
+		"(cn::=x)",
+		"(cn:dn::=x)",
+		"(cn:1.2..3:=x)",
+		"(cn:1.02:=x)",
+		"(cn:case_Match:=x)",
+		"(cn:x:y:=z)",
+		"(:dn:=x)",
+		"(&(cn=x)",
+	}
+	for i, test := range tests {
+		if f, err := CompileFilter(test); err == nil {
+			t.Errorf("#%d: Expected an error for %q, got %#v", i, test, f)
+		}
+	}
+}
+
+func TestDecompileFilter(t *testing.T) {
+	tests := []string{
+		"(objectClass=*)",
+		"(cn=a\\2ab\\29\\5c\\00)",
+		"(cn=caf\xc3\xa9)",
+		"(cn=\\ff)",
+		"(uid=jm*)",
+		"(cn=*b*)",
+		"(cn=a*b*c*d)",
+		"(age>=21)",
+		"(age<=65)",
+		"(sn~=smith)",
+		"(cn:caseExactMatch:=Fred)",
+		"(:dn:2.4.6.8.10:=Dino)",
+		"(:caseIgnoreMatch:=fred)",
+		"(cn:=Fred)",
+		"(o:dn:=Ace)",
+		"(sn~=sm\\2ath)",
+		"(memberOf:1.2.840.113556.1.4.1941:=cn=Admins \\28EU\\29,dc=example,dc=com)",
+		"(!(cn=x))",
+		"(&(objectClass=person)(|(uid=jm*)(cn=*carbo)))",
+	}
+	for i, test := range tests {
+		f, err := CompileFilter(test)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if result, err := DecompileFilter(f); err != nil || result != test {
+			t.Errorf("#%d: Bad result: %q, %v (expected %q)", i, result, err, test)
+		}
+	}
+}
+
+func TestDecodeFilter(t *testing.T) {
+	tests := []string{
+		"(objectClass=*)",
+		"(cn=a*b*c*d)",
+		"(cn=*b*)",
+		"(!(cn=x))",
+		"(!(!(cn=x)))",
+		"(&(objectClass=person)(|(age>=21)(!(age<=65))(sn~=smith)))",
+		"(cn:caseExactMatch:=Fred)",
+		"(:dn:2.4.6.8.10:=Dino)",
+		"(:caseIgnoreMatch:=fred)",
+		"(cn:=Fred)",
+		"(o:dn:=Ace)",
+		"(memberOf:1.2.840.113556.1.4.1941:=cn=Admins,dc=example,dc=com)",
+		"(&)",
+	}
+	for i, test := range tests {
+		f, err := CompileFilter(test)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		ber, err := encodeValue(f)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if result, err := DecodeFilter(ber); err != nil || !reflect.DeepEqual(result, f) {
+			t.Errorf("#%d: Bad result: %#v, %v (expected %#v)", i, result, err, f)
+		}
+	}
+	// dnAttributes is only encoded when true.
+	for _, dn := range []bool{false, true} {
+		ber, _ := encodeValue(ExtensibleMatch("caseExactMatch", "cn", "x", dn))
+		if has := bytes.Contains(ber, []byte{0x84, 0x01}); has != dn {
+			t.Errorf("dnAttributes %v: Bad encoding: % x", dn, ber)
+		}
+	}
+	if _, err := DecodeFilter([]byte{0x04, 0x01, 'x'}); err == nil {
+		t.Errorf("Expected an error decoding an OCTET STRING as a filter")
+	}
+
+	for i, test := range []struct {
+		filter string
+		ok     bool
+	}{
+		{"(cn=x)", true},
+		{"(!(!(cn=x)))", true},
+		{"(&(|(cn=a)(!(cn=b))))", false},
+	} {
+		f, _ := CompileFilter(test.filter)
+		ber, _ := encodeValue(f)
+		if _, err := DecodeFilterMaxDepth(ber, 3); (err == nil) != test.ok {
+			t.Errorf("#%d: Bad result: %v (expected ok %v)", i, err, test.ok)
+		}
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	e := NewEntry("uid=jmcarbo,ou=People,dc=example,dc=com", map[string][]string{
+		"objectClass": {"top", "person"},
+		"uid":         {"jmcarbo"},
+		"cn":          {"Joan  M. Carbo"},
+		"cn;lang-ca":  {"Joan Carbó"},
+		"uidNumber":   {"1001"},
+		"age":         {"9"},
+	})
+	rules := func(attr string) MatchingRule {
+		switch attr {
+		case "uidNumber", "age":
+			return IntegerMatch
+		}
+		return nil
+	}
+
+	tests := []struct {
+		filter   string
+		expected bool
+	}{
+		{"(objectClass=PERSON)", true},
+		{"(objectClass=group)", false},
+		{"(cn=joan m. carbo)", true},
+		{"(cn=Joan Carbó)", true},
+		{"(cn;lang-ca=joan carbó)", true},
+		{"(mail=*)", false},
+		{"(cn=*)", true},
+		{"(uid=jm*)", true},
+		{"(cn=*carbo)", true},
+		{"(cn=joan*m.*carbo)", true},
+		{"(cn=*carbo*joan*)", false},
+		{"(uid=jmc*arbo)", true},
+		{"(uid=jmca*carbo)", false},
+		{"(uidNumber>=1000)", true},
+		{"(uidNumber<=1000)", false},
+		{"(age>=10)", false},
+		{"(uidNumber>=x)", false},
+		{"(!(uidNumber>=x))", false},
+		{"(|(uidNumber>=x)(uid=jmcarbo))", true},
+		{"(&(objectClass=person)(|(uid=jm*)(cn=*carbo)))", true},
+		{"(&(objectClass=person)(!(uid=jm*)))", false},
+		{"(&)", true},
+		{"(|)", false},
+		{"(uid:caseExactMatch:=JMCARBO)", false},
+		{"(uid:caseIgnoreMatch:=JMCARBO)", true},
+		{"(ou:dn:=people)", true},
+		{"(ou=people)", false},
+		{"(:dn:2.5.13.5:=People)", true},
+		{"(uid:unknownMatch:=jmcarbo)", false},
+	}
+	for i, test := range tests {
+		f, err := CompileFilter(test.filter)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		result, err := FilterMatchesWithRules(f, e, rules)
+		if err != nil || result != test.expected {
+			t.Errorf("#%d: Bad result for %s: %v, %v (expected %v)", i, test.filter, result, err, test.expected)
+		}
+	}
+}
+
+func TestFilterAssertions(t *testing.T) {
+	f, err := CompileFilter("(&(uid=jdoe)(mail=*)(|(cn=a)(!(cn=b))))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, ok := AndFilters(f)
+	if !ok || len(subs) != 3 {
+		t.Fatalf("Bad and result: %v, %v", subs, ok)
+	}
+	if attr, value, ok := EqualityAssertion(subs[0]); !ok || attr != "uid" || value != "jdoe" {
+		t.Errorf("Bad equality result: %q, %q, %v", attr, value, ok)
+	}
+	if attr, ok := PresenceAssertion(subs[1]); !ok || attr != "mail" {
+		t.Errorf("Bad presence result: %q, %v", attr, ok)
+	}
+	ors, ok := OrFilters(subs[2])
+	if !ok || len(ors) != 2 {
+		t.Fatalf("Bad or result: %v, %v", ors, ok)
+	}
+	if _, _, ok := EqualityAssertion(ors[1]); ok {
+		t.Errorf("Not filter taken for an equality assertion")
+	}
+	if _, ok := PresenceAssertion(subs[0]); ok {
+		t.Errorf("Equality filter taken for a presence assertion")
+	}
+	if _, ok := AndFilters(subs[2]); ok {
+		t.Errorf("Or filter taken for an and filter")
+	}
+	if not, ok := NotFilter(ors[1]); !ok {
+		t.Errorf("Bad not result: %v", ok)
+	} else if attr, value, _ := EqualityAssertion(not); attr != "cn" || value != "b" {
+		t.Errorf("Bad not subfilter: %q, %q", attr, value)
+	}
+}
+
+func TestMoreFilterAssertions(t *testing.T) {
+	f, err := CompileFilter("(&(age>=3)(age<=9)(cn~=jon)(cn=a*b*c*d)(cn=*z)(cn:dn:2.5.13.2:=x))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, _ := AndFilters(f)
+	if attr, value, ok := GreaterOrEqualAssertion(subs[0]); !ok || attr != "age" || value != "3" {
+		t.Errorf("Bad greater-or-equal result: %q, %q, %v", attr, value, ok)
+	}
+	if attr, value, ok := LessOrEqualAssertion(subs[1]); !ok || attr != "age" || value != "9" {
+		t.Errorf("Bad less-or-equal result: %q, %q, %v", attr, value, ok)
+	}
+	if attr, value, ok := ApproxAssertion(subs[2]); !ok || attr != "cn" || value != "jon" {
+		t.Errorf("Bad approx result: %q, %q, %v", attr, value, ok)
+	}
+	if _, _, ok := ApproxAssertion(subs[0]); ok {
+		t.Errorf("Greater-or-equal filter taken for an approx assertion")
+	}
+	attr, initial, any, final, ok := SubstringAssertion(subs[3])
+	if !ok || attr != "cn" || initial != "a" || fmt.Sprint(any) != "[b c]" || final != "d" {
+		t.Errorf("Bad substring result: %q, %q, %q, %q, %v", attr, initial, any, final, ok)
+	}
+	if _, initial, _, final, _ := SubstringAssertion(subs[4]); initial != "" || final != "z" {
+		t.Errorf("Bad substring result: %q, %q", initial, final)
+	}
+	rule, attr, value, dn, ok := ExtensibleAssertion(subs[5])
+	if !ok || rule != "2.5.13.2" || attr != "cn" || value != "x" || !dn {
+		t.Errorf("Bad extensible result: %q, %q, %q, %v, %v", rule, attr, value, dn, ok)
+	}
+}
+
+func TestNormalizeFilter(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"(cn=x)", "(cn=x)"},
+		{"(&(cn=x))", "(cn=x)"},
+		{"(|(a=*))", "(a=*)"},
+		{"(&(a=1)(&(b=2)(&(c=3))))", "(&(a=1)(b=2)(c=3))"},
+		{"(|(a=1)(|(b=2)(c=3)))", "(|(a=1)(b=2)(c=3))"},
+		{"(&(a=1)(|(b=2)(c=3)))", "(&(a=1)(|(b=2)(c=3)))"},
+		{"(&(a=1)(b=2)(a=1))", "(&(a=1)(b=2))"},
+		{"(|(a=1)(a=1))", "(a=1)"},
+		{"(!(!(a=1)))", "(a=1)"},
+		{"(!(&(a=1)))", "(!(a=1))"},
+		{"(&(objectClass=*)(cn=x))", "(cn=x)"},
+		{"(&(objectclass=*)(objectClass=*))", "(objectclass=*)"},
+		{"(|(cn=x)(objectClass=*))", "(objectClass=*)"},
+		{"(&(cn=x)(&))", "(cn=x)"},
+		{"(&(cn=x)(|))", "(|)"},
+		{"(|(cn=x)(|))", "(cn=x)"},
+		{"(|(cn=x)(&))", "(&)"},
+		{"(&(&)(&))", "(&)"},
+		{"(|(|))", "(|)"},
+		{"(&(sn=*)(!(c=1))(|(d=1)(e=1))(cn=a*)(age>=3)(uid=x))", "(&(uid=x)(cn=a*)(age>=3)(sn=*)(|(d=1)(e=1))(!(c=1)))"},
+	}
+	for i, test := range tests {
+		f, err := CompileFilter(test.in)
+		if err != nil {
+			t.Fatalf("#%d: Unexpected error: %v", i, err)
+		}
+		out, err := DecompileFilter(NormalizeFilter(f))
+		if err != nil {
+			t.Fatalf("#%d: Unexpected error: %v", i, err)
+		}
+		if out != test.out {
+			t.Errorf("#%d: Bad result: %s (expected %s)", i, out, test.out)
+		}
+	}
+}
+
+func TestNormalizeFiltersOption(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{NormalizeFilters: true})
+	defer c.Close()
+
+	filters := make(chan string, 1)
+	go func() {
+		m, err := readTestMessage(server)
+		if err != nil {
+			filters <- err.Error()
+			return
+		}
+		var req struct {
+			BaseObject []byte
+			Scope      SearchScope  `asn1:"enum"`
+			Deref      DerefAliases `asn1:"enum"`
+			SizeLimit  int
+			TimeLimit  int
+			TypesOnly  bool
+			Filter     asn1.RawValue
+			Attributes [][]byte
+		}
+		if err := decodeOp(m.Op, "application,tag:3", &req); err != nil {
+			filters <- err.Error()
+			return
+		}
+		f, err := DecodeFilter(req.Filter.RawBytes)
+		if err != nil {
+			filters <- err.Error()
+			return
+		}
+		s, _ := DecompileFilter(f)
+		filters <- s
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{})
+	}()
+	f, _ := CompileFilter("(&(objectClass=*)(&(uid=x)))")
+	if _, err := c.Search(SearchRequest{Filter: f}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := <-filters; s != "(uid=x)" {
+		t.Errorf("Bad result: %s (expected (uid=x))", s)
+	}
+}
+
+func TestSubstringMatch(t *testing.T) {
+	tests := []struct {
+		initial string
+		any     []string
+		final   string
+		out     string
+	}{
+		{"jm", nil, "", "(cn=jm*)"},
+		{"", nil, "carbo", "(cn=*carbo)"},
+		{"", []string{"b"}, "", "(cn=*b*)"},
+		{"a", []string{"b", "c"}, "d", "(cn=a*b*c*d)"},
+		{"*", nil, "", "(cn=\\2a*)"},
+		{"a*", []string{"(x)"}, "\\", "(cn=a\\2a*\\28x\\29*\\5c)"},
+		{"", nil, "", ""},
+		{"", []string{""}, "x", ""},
+	}
+	for i, test := range tests {
+		f, err := SubstringMatch("cn", test.initial, test.any, test.final)
+		if test.out == "" {
+			if err == nil {
+				t.Errorf("#%d: Expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		if out, err := DecompileFilter(f); err != nil || out != test.out {
+			t.Errorf("#%d: Bad result: %s, %v (expected %s)", i, out, err, test.out)
+		}
+	}
+	if _, err := SubstringMatch("c n", "x", nil, ""); err == nil {
+		t.Errorf("Expected an error for an invalid attribute")
+	}
+
+	// A '*' from input matches only itself.
+	f, _ := SubstringMatch("cn", "*", nil, "")
+	for _, test := range []struct {
+		value string
+		ok    bool
+	}{{"*admin", true}, {"admin", false}} {
+		e := NewEntry("cn=x", map[string][]string{"cn": {test.value}})
+		if ok, err := FilterMatches(f, e); err != nil || ok != test.ok {
+			t.Errorf("%s: Bad result: %v, %v (expected %v)", test.value, ok, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+)
+
+// DecodeFilter parses the BER encoding of a Filter (RFC 4511 §4.5.1.7),
+// as found in a SearchRequest, into the form built by And, Equals and
+// the other constructors, so that it can be evaluated with
+// FilterMatches.
+func DecodeFilter(ber []byte) (Filter, error) {
+	return DecodeFilterMaxDepth(ber, 0)
+}
+
+// DecodeFilterMaxDepth is like DecodeFilter, but fails if the filter's
+// and, or and not filters nest more than maxDepth filters deep, as in
+// (&(|(cn=a)(cn=b))), which is three deep. Zero means no limit.
+func DecodeFilterMaxDepth(ber []byte, maxDepth int) (Filter, error) {
+	var raw asn1.RawValue
+	if err := decodeValue(ber, &raw); err != nil {
+		return nil, fmt.Errorf("ldap: invalid filter: %v", err)
+	}
+	f, err := decodeFilter(raw, 1, maxDepth)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid filter: %v", err)
+	}
+	return f, nil
+}
+
+// decodeFilter decodes raw, a filter depth filters deep.
+func decodeFilter(raw asn1.RawValue, depth, maxDepth int) (Filter, error) {
+	if maxDepth > 0 && depth > maxDepth {
+		return nil, fmt.Errorf("filter nested more than %d deep", maxDepth)
+	}
+	if raw.Class != asn1.ClassContextSpecific {
+		return nil, fmt.Errorf("unexpected class %d", raw.Class)
+	}
+	opts := fmt.Sprintf("tag:%d", raw.Tag)
+	switch raw.Tag {
+	case 0, 1:
+		var filters []Filter
+		r := bytes.NewReader(raw.Bytes)
+		dec := asn1.NewDecoder(r)
+		dec.Implicit = true
+		dec.MaxLength = len(raw.Bytes)
+		for r.Len() > 0 {
+			var sub asn1.RawValue
+			if err := dec.Decode(&sub); err != nil {
+				return nil, err
+			}
+			f, err := decodeFilter(sub, depth+1, maxDepth)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+		}
+		if raw.Tag == 0 {
+			return And(filters...), nil
+		}
+		return Or(filters...), nil
+	case 2:
+		var sub asn1.RawValue
+		if err := decodeValue(raw.Bytes, &sub); err != nil {
+			return nil, err
+		}
+		f, err := decodeFilter(sub, depth+1, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		return Not(f), nil
+	case 3, 5, 6, 8:
+		var ava attributeValueAssertion
+		if err := decodeValue(raw.RawBytes, asn1.OptionValue{Opts: opts, Value: &ava}); err != nil {
+			return nil, err
+		}
+		return asn1.OptionValue{Opts: opts, Value: ava}, nil
+	case 4:
+		var sf struct {
+			Attribute  []byte
+			Substrings []asn1.RawValue
+		}
+		if err := decodeValue(raw.RawBytes, asn1.OptionValue{Opts: opts, Value: &sf}); err != nil {
+			return nil, err
+		}
+		substrings := make([]substring, len(sf.Substrings))
+		for i, s := range sf.Substrings {
+			if s.Tag > 2 {
+				return nil, fmt.Errorf("unexpected substring choice %d", s.Tag)
+			}
+			substrings[i] = makeSubstring(fmt.Sprint(s.Tag), string(s.Bytes))
+		}
+		return Substring(string(sf.Attribute), substrings...), nil
+	case 7:
+		return Present(string(raw.Bytes)), nil
+	case 9:
+		var mra struct {
+			MatchingRule []byte `asn1:"tag:1,optional"`
+			Type         []byte `asn1:"tag:2,optional"`
+			MatchValue   []byte `asn1:"tag:3"`
+			DnAttributes bool   `asn1:"tag:4,optional"`
+		}
+		if err := decodeValue(raw.RawBytes, asn1.OptionValue{Opts: opts, Value: &mra}); err != nil {
+			return nil, err
+		}
+		return ExtensibleMatch(string(mra.MatchingRule), string(mra.Type), string(mra.MatchValue), mra.DnAttributes), nil
+	}
+	return nil, fmt.Errorf("unexpected choice %d", raw.Tag)
+}
@@ -0,0 +1,259 @@
+package ldap
+
+import (
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"strings"
+)
+
+// FilterMatches evaluates f against e locally, comparing values with
+// CaseIgnoreMatch. Use FilterMatchesWithRules to supply the rules a
+// schema defines.
+func FilterMatches(f Filter, e *Entry) (bool, error) {
+	return FilterMatchesWithRules(f, e, nil)
+}
+
+// FilterMatchesWithRules is like FilterMatches, but compares the values
+// of each attribute with the matching rule returned by rules. rules may
+// return nil, or be nil, to use CaseIgnoreMatch.
+//
+// As in RFC 4511 §4.5.1.7, an assertion that cannot be evaluated, e.g.
+// an ordering match on a value that is not an integer under
+// IntegerMatch, is Undefined rather than false; a filter matches only if
+// it evaluates to TRUE.
+func FilterMatchesWithRules(f Filter, e *Entry, rules func(attribute string) MatchingRule) (bool, error) {
+	ev := filterEvaluator{entry: e, rules: rules}
+	r, err := ev.eval(f)
+	return r == filterTrue, err
+}
+
+type filterResult int
+
+const (
+	filterFalse filterResult = iota
+	filterTrue
+	filterUndefined
+)
+
+type filterEvaluator struct {
+	entry *Entry
+	rules func(string) MatchingRule
+}
+
+func (ev *filterEvaluator) rule(attr string) MatchingRule {
+	if ev.rules != nil {
+		if i := strings.IndexByte(attr, ';'); i >= 0 {
+			attr = attr[:i]
+		}
+		if rule := ev.rules(attr); rule != nil {
+			return rule
+		}
+	}
+	return CaseIgnoreMatch
+}
+
+// values returns the values of attr, including those of its subtypes
+// with attribute options: "cn" matches "cn;lang-en".
+func (ev *filterEvaluator) values(attr string) (values []string, found bool) {
+	for _, a := range ev.entry.Attributes {
+		if strings.EqualFold(a.Name, attr) || len(a.Name) > len(attr) && strings.EqualFold(a.Name[:len(attr)+1], attr+";") {
+			values = append(values, a.Values...)
+			found = true
+		}
+	}
+	return
+}
+
+func (ev *filterEvaluator) eval(f Filter) (filterResult, error) {
+	ov, ok := f.(asn1.OptionValue)
+	if !ok {
+		return filterUndefined, fmt.Errorf("ldap: unsupported filter %#v", f)
+	}
+	tag, ok := filterTag(ov)
+	if !ok {
+		return filterUndefined, fmt.Errorf("ldap: unsupported filter %#v", f)
+	}
+
+	switch v := ov.Value.(type) {
+	case []Filter:
+		result := filterResult(filterTrue)
+		short := filterFalse
+		if tag == 1 {
+			result, short = filterFalse, filterTrue
+		}
+		for _, sub := range v {
+			r, err := ev.eval(sub)
+			if err != nil {
+				return filterUndefined, err
+			}
+			if r == short {
+				return short, nil
+			}
+			if r == filterUndefined {
+				result = filterUndefined
+			}
+		}
+		return result, nil
+	case attributeValueAssertion:
+		return ev.compare(tag, string(v.Attribute), string(v.Value)), nil
+	case substringFilter:
+		return ev.substrings(v), nil
+	case []byte:
+		if _, found := ev.values(string(v)); found {
+			return filterTrue, nil
+		}
+		return filterFalse, nil
+	case matchingRuleAssertion:
+		return ev.extensible(v), nil
+	}
+
+	if tag != 2 {
+		return filterUndefined, fmt.Errorf("ldap: unsupported filter %#v", f)
+	}
+	r, err := ev.eval(ov.Value)
+	switch r {
+	case filterTrue:
+		return filterFalse, err
+	case filterFalse:
+		return filterTrue, err
+	}
+	return r, err
+}
+
+// compare evaluates equality (3), ordering (5 and 6) and approximate (8)
+// assertions. Approximate matching is treated as equality.
+func (ev *filterEvaluator) compare(tag int, attr, value string) filterResult {
+	values, _ := ev.values(attr)
+	return matchValues(ev.rule(attr), values, value, func(rule MatchingRule, a, b string) bool {
+		switch tag {
+		case 5:
+			return rule.Compare(a, b) >= 0
+		case 6:
+			return rule.Compare(a, b) <= 0
+		}
+		return a == b
+	})
+}
+
+// matchValues reports whether any of values satisfies pred against the
+// assertion value, after normalizing both.
+func matchValues(rule MatchingRule, values []string, value string, pred func(rule MatchingRule, a, b string) bool) filterResult {
+	assertion, err := rule.Normalize(value)
+	if err != nil {
+		return filterUndefined
+	}
+	result := filterFalse
+	for _, v := range values {
+		norm, err := rule.Normalize(v)
+		if err != nil {
+			result = filterUndefined
+			continue
+		}
+		if pred(rule, norm, assertion) {
+			return filterTrue
+		}
+	}
+	return result
+}
+
+func (ev *filterEvaluator) substrings(sf substringFilter) filterResult {
+	attr := string(sf.Attribute)
+	rule := ev.rule(attr)
+
+	var initial, final string
+	var any []string
+	for _, sub := range sf.Substrings {
+		b, _ := sub.Value.([]byte)
+		norm, err := rule.Normalize(string(b))
+		if err != nil {
+			return filterUndefined
+		}
+		switch tag, _ := filterTag(sub); tag {
+		case 0:
+			initial = norm
+		case 1:
+			any = append(any, norm)
+		case 2:
+			final = norm
+		}
+	}
+
+	values, _ := ev.values(attr)
+	result := filterFalse
+	for _, v := range values {
+		norm, err := rule.Normalize(v)
+		if err != nil {
+			result = filterUndefined
+			continue
+		}
+		if matchSubstrings(norm, initial, any, final) {
+			return filterTrue
+		}
+	}
+	return result
+}
+
+func matchSubstrings(s, initial string, any []string, final string) bool {
+	if !strings.HasPrefix(s, initial) {
+		return false
+	}
+	s = s[len(initial):]
+	for _, sub := range any {
+		i := strings.Index(s, sub)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(sub):]
+	}
+	return len(s) >= len(final) && strings.HasSuffix(s, final)
+}
+
+func (ev *filterEvaluator) extensible(mra matchingRuleAssertion) filterResult {
+	attr := string(mra.Type)
+	var rule MatchingRule
+	if len(mra.MatchingRule) > 0 {
+		var ok bool
+		if rule, ok = LookupMatchingRule(string(mra.MatchingRule)); !ok {
+			return filterUndefined
+		}
+	}
+	equal := func(rule MatchingRule, a, b string) bool { return a == b }
+
+	type candidate struct{ attr, value string }
+	var candidates []candidate
+	for _, a := range ev.entry.Attributes {
+		if attr == "" || strings.EqualFold(a.Name, attr) {
+			for _, v := range a.Values {
+				candidates = append(candidates, candidate{a.Name, v})
+			}
+		}
+	}
+	if mra.DnAttributes {
+		if dn, err := ParseDN(ev.entry.DN); err == nil {
+			for _, rdn := range dn {
+				for _, atv := range rdn {
+					if attr == "" || strings.EqualFold(atv.Type, attr) {
+						candidates = append(candidates, candidate{atv.Type, atv.Value})
+					}
+				}
+			}
+		}
+	}
+
+	result := filterFalse
+	for _, c := range candidates {
+		r := rule
+		if r == nil {
+			r = ev.rule(c.attr)
+		}
+		switch matchValues(r, []string{c.value}, string(mra.MatchValue), equal) {
+		case filterTrue:
+			return filterTrue
+		case filterUndefined:
+			if attr != "" {
+				result = filterUndefined
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package ldap
+
+import (
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"sort"
+	"strings"
+)
+
+// NormalizeFilter returns a filter equivalent to f that is cheaper to
+// evaluate: nested and and or filters are flattened, duplicate terms
+// removed, double negations dropped, and terms that are always true,
+// such as (objectClass=*) or (&), or always false, such as (|), folded
+// away. Within each and and or the remaining terms are ordered so that
+// those most likely to be indexed, like equality, come first.
+func NormalizeFilter(f Filter) Filter {
+	ov, ok := f.(asn1.OptionValue)
+	if !ok {
+		return f
+	}
+	switch tag, _ := filterTag(ov); tag {
+	case 0, 1:
+		subs, _ := setFilters(f, tag)
+		return normalizeSet(tag, subs)
+	case 2:
+		sub := NormalizeFilter(ov.Value)
+		if inner, ok := NotFilter(sub); ok {
+			return inner
+		}
+		return Not(sub)
+	}
+	return f
+}
+
+// normalizeSet normalizes an and (tag 0) or or (tag 1) of subs.
+func normalizeSet(tag int, subs []Filter) Filter {
+	var terms []Filter
+	var flatten func(subs []Filter)
+	flatten = func(subs []Filter) {
+		for _, sub := range subs {
+			sub = NormalizeFilter(sub)
+			if nested, ok := setFilters(sub, tag); ok && len(nested) > 0 {
+				flatten(nested)
+			} else {
+				terms = append(terms, sub)
+			}
+		}
+	}
+	flatten(subs)
+
+	// For an and, the identity is true and the absorbing term false;
+	// for an or, the other way around.
+	isIdentity, isAbsorbing := isAbsoluteTrue, isAbsoluteFalse
+	if tag == 1 {
+		isIdentity, isAbsorbing = isAbsoluteFalse, isAbsoluteTrue
+	}
+	var out []Filter
+	var tautology Filter
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if isAbsorbing(term) {
+			return term
+		}
+		if isIdentity(term) {
+			if tag == 0 && tautology == nil && isObjectClassPresent(term) {
+				tautology = term
+			}
+			continue
+		}
+		key := filterKey(term)
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, term)
+		}
+	}
+
+	switch len(out) {
+	case 0:
+		// Prefer (objectClass=*) to (&), which not every server supports.
+		if tautology != nil {
+			return tautology
+		}
+		if tag == 0 {
+			return And()
+		}
+		return Or()
+	case 1:
+		return out[0]
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return filterRank(out[i]) < filterRank(out[j])
+	})
+	if tag == 0 {
+		return And(out...)
+	}
+	return Or(out...)
+}
+
+func isAbsoluteTrue(f Filter) bool {
+	subs, ok := AndFilters(f)
+	return (ok && len(subs) == 0) || isObjectClassPresent(f)
+}
+
+func isAbsoluteFalse(f Filter) bool {
+	subs, ok := OrFilters(f)
+	return ok && len(subs) == 0
+}
+
+// isObjectClassPresent reports whether f is (objectClass=*), which every
+// entry matches.
+func isObjectClassPresent(f Filter) bool {
+	attr, ok := PresenceAssertion(f)
+	return ok && strings.EqualFold(attr, "objectClass")
+}
+
+// filterKey identifies f for removing duplicates.
+func filterKey(f Filter) string {
+	if s, err := DecompileFilter(f); err == nil {
+		return s
+	}
+	return fmt.Sprintf("%#v", f)
+}
+
+// filterRank orders terms by how likely a server is to have an index
+// that narrows them down.
+func filterRank(f Filter) int {
+	ov, _ := f.(asn1.OptionValue)
+	switch tag, _ := filterTag(ov); tag {
+	case 3:
+		return 0
+	case 4:
+		return 1
+	case 5, 6:
+		return 2
+	case 8:
+		return 3
+	case 9:
+		return 4
+	case 7:
+		return 5
+	case 0, 1:
+		return 6
+	}
+	return 7
+}
@@ -0,0 +1,376 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CompileFilter parses the string representation of a search filter
+// defined by RFC 4515, e.g. "(&(objectClass=person)(uid=jm*))". The
+// outer parentheses may be omitted.
+func CompileFilter(s string) (Filter, error) {
+	if !strings.HasPrefix(s, "(") {
+		s = "(" + s + ")"
+	}
+	p := &filterParser{s: s}
+	f, err := p.filter()
+	if err == nil && p.pos < len(s) {
+		err = p.errorf("unexpected %q", s[p.pos:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("ldap: invalid filter %q at offset %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) expect(c byte) error {
+	if p.pos >= len(p.s) || p.s[p.pos] != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) filter() (Filter, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.s) {
+		return nil, p.errorf("unexpected end")
+	}
+
+	var f Filter
+	var err error
+	switch p.s[p.pos] {
+	case '&':
+		p.pos++
+		var filters []Filter
+		if filters, err = p.list(); err == nil {
+			f = And(filters...)
+		}
+	case '|':
+		p.pos++
+		var filters []Filter
+		if filters, err = p.list(); err == nil {
+			f = Or(filters...)
+		}
+	case '!':
+		p.pos++
+		var inner Filter
+		if inner, err = p.filter(); err == nil {
+			f = Not(inner)
+		}
+	default:
+		f, err = p.item()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *filterParser) list() ([]Filter, error) {
+	var filters []Filter
+	for p.pos < len(p.s) && p.s[p.pos] == '(' {
+		f, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func (p *filterParser) item() (Filter, error) {
+	start := p.pos
+	end := strings.IndexAny(p.s[start:], "()")
+	if end < 0 {
+		return nil, p.errorf("unterminated item")
+	}
+	end += start
+	item := p.s[start:end]
+
+	eq := strings.IndexByte(item, '=')
+	if eq < 0 {
+		return nil, p.errorf("missing '=' in %q", item)
+	}
+	lhs, value := item[:eq], item[eq+1:]
+	p.pos = end
+
+	op := byte('=')
+	if n := len(lhs); n > 0 && strings.IndexByte("~<>:", lhs[n-1]) >= 0 {
+		op, lhs = lhs[n-1], lhs[:n-1]
+	}
+
+	if op == ':' {
+		return p.extensible(lhs, value)
+	}
+	if !validAttributeDescription(lhs) {
+		return nil, p.errorf("invalid attribute description %q", lhs)
+	}
+
+	if op == '=' && value == "*" {
+		return Present(lhs), nil
+	}
+	if op == '=' && strings.Contains(value, "*") {
+		return p.substring(lhs, value)
+	}
+
+	if strings.Contains(value, "*") {
+		return nil, p.errorf("unescaped '*' in %q", value)
+	}
+	v, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	switch op {
+	case '~':
+		return ApproxMatch(lhs, v), nil
+	case '>':
+		return GreaterOrEqual(lhs, v), nil
+	case '<':
+		return LessOrEqual(lhs, v), nil
+	}
+	return Equals(lhs, v), nil
+}
+
+func (p *filterParser) substring(attr, value string) (Filter, error) {
+	parts := strings.Split(value, "*")
+	var subs []substring
+	for i, part := range parts {
+		if part == "" {
+			if i > 0 && i < len(parts)-1 {
+				return nil, p.errorf("empty substring in %q", value)
+			}
+			continue
+		}
+		v, err := unescapeFilterValue(part)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		switch i {
+		case 0:
+			subs = append(subs, InitialSubstring(v))
+		case len(parts) - 1:
+			subs = append(subs, FinalSubstring(v))
+		default:
+			subs = append(subs, AnySubstring(v))
+		}
+	}
+	return Substring(attr, subs...), nil
+}
+
+// extensible parses the left-hand side of an extensible match,
+// attr[:dn][:rule] or [:dn]:rule, with the trailing colon removed.
+func (p *filterParser) extensible(lhs, value string) (Filter, error) {
+	parts := strings.Split(lhs, ":")
+	attr, parts := parts[0], parts[1:]
+	if attr != "" && !validAttributeDescription(attr) {
+		return nil, p.errorf("invalid attribute description %q", attr)
+	}
+
+	dn := false
+	if len(parts) > 0 && strings.EqualFold(parts[0], "dn") {
+		dn, parts = true, parts[1:]
+	}
+	rule := ""
+	if len(parts) > 0 {
+		rule, parts = parts[0], parts[1:]
+		if !validMatchingRule(rule) {
+			return nil, p.errorf("invalid matching rule %q", rule)
+		}
+	}
+	if len(parts) > 0 {
+		return nil, p.errorf("invalid extensible match %q", lhs)
+	}
+	if attr == "" && rule == "" {
+		return nil, p.errorf("extensible match needs an attribute or a matching rule")
+	}
+
+	if strings.Contains(value, "*") {
+		return nil, p.errorf("unescaped '*' in %q", value)
+	}
+	v, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return ExtensibleMatch(rule, attr, v, dn), nil
+}
+
+func validAttributeDescription(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == ';' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// validMatchingRule reports whether s is a descriptor, such as
+// caseExactMatch, or a numeric OID, such as 1.2.840.113556.1.4.1941.
+func validMatchingRule(s string) bool {
+	if s == "" {
+		return false
+	}
+	if '0' <= s[0] && s[0] <= '9' {
+		for _, arc := range strings.Split(s, ".") {
+			if arc == "" || strings.Trim(arc, "0123456789") != "" || len(arc) > 1 && arc[0] == '0' {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && ('0' <= c && c <= '9' || c == '-')) {
+			return false
+		}
+	}
+	return true
+}
+
+func unescapeFilterValue(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			buf.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("truncated escape in %q", s)
+		}
+		b, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		buf.WriteByte(byte(b))
+		i += 2
+	}
+	return buf.String(), nil
+}
+
+// EscapeFilter escapes a value for use in a string filter: the
+// characters RFC 4515 requires, NUL, and any bytes that are not valid
+// UTF-8 are written as \XX, so arbitrary binary values are safe too.
+func EscapeFilter(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1, r == '*', r == '(', r == ')', r == '\\', r == 0:
+			fmt.Fprintf(&buf, `\%02x`, s[i])
+			size = 1
+		default:
+			buf.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return buf.String()
+}
+
+// DecompileFilter returns the RFC 4515 string representation of a
+// filter built with this package's constructors or CompileFilter.
+func DecompileFilter(f Filter) (string, error) {
+	var buf bytes.Buffer
+	if err := decompileFilter(&buf, f); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func decompileFilter(buf *bytes.Buffer, f Filter) error {
+	ov, ok := f.(asn1.OptionValue)
+	if !ok {
+		return fmt.Errorf("ldap: unsupported filter %#v", f)
+	}
+	tag, ok := filterTag(ov)
+	if !ok {
+		return fmt.Errorf("ldap: unsupported filter %#v", f)
+	}
+
+	buf.WriteByte('(')
+	switch v := ov.Value.(type) {
+	case []Filter:
+		buf.WriteByte("&|"[tag])
+		for _, sub := range v {
+			if err := decompileFilter(buf, sub); err != nil {
+				return err
+			}
+		}
+	case attributeValueAssertion:
+		op := map[int]string{3: "=", 5: ">=", 6: "<=", 8: "~="}[tag]
+		buf.WriteString(string(v.Attribute) + op + EscapeFilter(string(v.Value)))
+	case substringFilter:
+		buf.WriteString(string(v.Attribute) + "=")
+		if len(v.Substrings) == 0 {
+			return fmt.Errorf("ldap: substring filter without substrings")
+		}
+		for i, sub := range v.Substrings {
+			subTag, _ := filterTag(sub)
+			val, _ := sub.Value.([]byte)
+			if subTag != 0 || i > 0 {
+				buf.WriteByte('*')
+			}
+			buf.WriteString(EscapeFilter(string(val)))
+		}
+		if last, _ := filterTag(v.Substrings[len(v.Substrings)-1]); last != 2 {
+			buf.WriteByte('*')
+		}
+	case []byte:
+		buf.WriteString(string(v) + "=*")
+	case matchingRuleAssertion:
+		buf.Write(v.Type)
+		if v.DnAttributes {
+			buf.WriteString(":dn")
+		}
+		if len(v.MatchingRule) > 0 {
+			buf.WriteString(":" + string(v.MatchingRule))
+		}
+		buf.WriteString(":=" + EscapeFilter(string(v.MatchValue)))
+	default:
+		if tag != 2 {
+			return fmt.Errorf("ldap: unsupported filter %#v", f)
+		}
+		buf.WriteByte('!')
+		if err := decompileFilter(buf, v); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(')')
+	return nil
+}
+
+// filterTag returns the context-specific tag that identifies the kind
+// of a filter or substring.
+func filterTag(ov asn1.OptionValue) (int, bool) {
+	for _, opt := range strings.Split(ov.Opts, ",") {
+		if strings.HasPrefix(opt, "tag:") {
+			tag, err := strconv.Atoi(opt[len("tag:"):])
+			return tag, err == nil
+		}
+	}
+	return 0, false
+}
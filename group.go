@@ -0,0 +1,113 @@
+package ldap
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// groupClasses are the object classes of groups: those of RFC 4519 and
+// Active Directory's.
+var groupClasses = []string{"groupOfNames", "groupOfUniqueNames", "group"}
+
+// uniqueMemberUID matches the optional UID of a nameAndOptionalUID value
+// of uniqueMember.
+var uniqueMemberUID = regexp.MustCompile(`#'[01]*'B$`)
+
+// ExpandGroup returns the DNs of the members of the group groupDN, direct
+// or through nested groups, which are followed through their member and
+// uniqueMember values but not themselves returned. A member that cannot
+// be read is assumed not to be a group. Cycles of groups are expanded
+// once.
+func ExpandGroup(c Conn, groupDN string) ([]string, error) {
+	var members []string
+	seen := map[string]bool{normalizeDN(groupDN): true}
+	queue := []string{groupDN}
+	for len(queue) > 0 {
+		dn := queue[0]
+		queue = queue[1:]
+		e, err := readGroup(c, dn, dn != groupDN)
+		if err != nil {
+			return nil, err
+		}
+		if e == nil || dn != groupDN && !isGroup(e) {
+			members = append(members, dn)
+			continue
+		}
+		values := append(e.GetAttributeValues("member"), e.GetAttributeValues("uniqueMember")...)
+		for _, v := range values {
+			v = uniqueMemberUID.ReplaceAllString(v, "")
+			if norm := normalizeDN(v); !seen[norm] {
+				seen[norm] = true
+				queue = append(queue, v)
+			}
+		}
+	}
+	return members, nil
+}
+
+// GroupsOf returns the DNs of the groups below base that dn is a member
+// of, directly or through nested groups.
+func GroupsOf(c Conn, base, dn string) ([]string, error) {
+	var groups []string
+	seen := map[string]bool{normalizeDN(dn): true}
+	queue := []string{dn}
+	for len(queue) > 0 {
+		results, err := c.Search(SearchRequest{
+			BaseObject: []byte(base),
+			Scope:      WholeSubtree,
+			Filter:     Or(Equals("member", queue[0]), Equals("uniqueMember", queue[0])),
+			Attributes: [][]byte{[]byte("1.1")},
+		})
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, r := range results {
+			if norm := normalizeDN(r.DN); !seen[norm] {
+				seen[norm] = true
+				groups = append(groups, r.DN)
+				queue = append(queue, r.DN)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// readGroup reads the attributes of dn that tell whether it is a group
+// and what its members are, or returns nil if it does not exist and
+// missingOK.
+func readGroup(c Conn, dn string, missingOK bool) (*Entry, error) {
+	results, err := c.Search(SearchRequest{
+		BaseObject: []byte(dn),
+		Scope:      BaseObject,
+		Filter:     Present("objectClass"),
+		Attributes: [][]byte{[]byte("objectClass"), []byte("member"), []byte("uniqueMember")},
+	})
+	var e *Error
+	if missingOK && errors.As(err, &e) && e.ResultCode == NoSuchObject {
+		return nil, nil
+	}
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[0].Entry(), nil
+}
+
+func isGroup(e *Entry) bool {
+	for _, oc := range e.GetAttributeValues("objectClass") {
+		for _, g := range groupClasses {
+			if strings.EqualFold(oc, g) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func normalizeDN(dn string) string {
+	if norm, err := DistinguishedNameMatch.Normalize(dn); err == nil {
+		return norm
+	}
+	return strings.ToLower(dn)
+}
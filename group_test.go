@@ -0,0 +1,74 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// directoryConn answers searches from a fixed set of entries.
+type directoryConn struct {
+	Conn
+	entries []*Entry
+}
+
+func (c *directoryConn) Search(req SearchRequest) ([]SearchResult, error) {
+	base := strings.ToLower(string(req.BaseObject))
+	var results []SearchResult
+	found := false
+	for _, e := range c.entries {
+		dn := strings.ToLower(e.DN)
+		switch {
+		case req.Scope == BaseObject && dn != base:
+			continue
+		case req.Scope == WholeSubtree && dn != base && !strings.HasSuffix(dn, ","+base):
+			continue
+		}
+		found = true
+		if ok, _ := FilterMatches(req.Filter, e); ok {
+			results = append(results, SearchResult{DN: e.DN, Attributes: e.AttributeMap()})
+		}
+	}
+	if !found {
+		return nil, &Error{ResultCode: NoSuchObject}
+	}
+	return results, nil
+}
+
+func TestGroups(t *testing.T) {
+	const base = "dc=example,dc=com"
+	c := &directoryConn{entries: []*Entry{
+		NewEntry(base, map[string][]string{"objectClass": {"domain"}}),
+		NewEntry("cn=admins,"+base, map[string][]string{
+			"objectClass": {"groupOfNames"}, "member": {"uid=alice," + base, "cn=ops," + base}}),
+		NewEntry("cn=ops,"+base, map[string][]string{
+			"objectClass": {"groupOfUniqueNames"}, "uniqueMember": {"uid=bob," + base + "#'0101'B", "CN=Admins," + base, "uid=gone," + base}}),
+		NewEntry("cn=all,"+base, map[string][]string{
+			"objectClass": {"groupOfNames"}, "member": {"cn=admins," + base}}),
+		NewEntry("uid=alice,"+base, map[string][]string{"objectClass": {"person"}}),
+		NewEntry("uid=bob,"+base, map[string][]string{"objectClass": {"person"}}),
+	}}
+
+	tests := []struct {
+		group   string
+		members []string
+	}{
+		{"cn=all," + base, []string{"uid=alice," + base, "uid=bob," + base, "uid=gone," + base}},
+		{"cn=ops," + base, []string{"uid=bob," + base, "uid=gone," + base, "uid=alice," + base}},
+	}
+	for i, test := range tests {
+		members, err := ExpandGroup(c, test.group)
+		if err != nil || !reflect.DeepEqual(members, test.members) {
+			t.Errorf("#%d: Bad result: %v, %v (expected %v)", i, members, err, test.members)
+		}
+	}
+	if _, err := ExpandGroup(c, "cn=none,"+base); err == nil {
+		t.Error("Expected an error for a missing group")
+	}
+
+	groups, err := GroupsOf(c, base, "uid=alice,"+base)
+	expected := []string{"cn=admins," + base, "cn=ops," + base, "cn=all," + base}
+	if err != nil || !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Bad result: %v, %v (expected %v)", groups, err, expected)
+	}
+}
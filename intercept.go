@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+)
+
+// A Request is an operation as an Interceptor sees it, before it is
+// sent. An Interceptor may change DN, Search and Controls before passing
+// it on.
+type Request struct {
+	// Op names the operation: "bind", "search", "add", "modify",
+	// "delete", "modifyDN", "compare" or "extended".
+	Op string
+	// DN is the entry the operation is on, the base of a search or the
+	// name bound as. It is empty for extended operations.
+	DN string
+	// Name is the OID of an extended operation.
+	Name string
+	// Search is the request of a search. Its BaseObject is replaced by
+	// DN when it is sent.
+	Search   *SearchRequest
+	Controls []Control
+
+	op interface{}
+}
+
+// A Response is the final response to a Request. A result code other
+// than Success is not an error at this point: it is returned as an
+// *Error by the operation once the interceptors have returned.
+type Response struct {
+	ResultCode ResultCode
+	Controls   []Control
+
+	raw asn1.RawValue
+}
+
+// A RoundTripFunc performs a request.
+type RoundTripFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// An Interceptor is called around each operation of a connection, for
+// such things as audit logging, rewriting requests or refusing them; set
+// it with DialOpts.Interceptors. It performs the operation by calling
+// next, possibly with a changed request or context, or fails it by
+// returning an error without doing so. The entries of a search are
+// passed to its handler before next returns.
+//
+// StartTLS, Unbind and the Abandon and Cancel requests sent to stop
+// other operations are not intercepted.
+type Interceptor func(ctx context.Context, req *Request, next RoundTripFunc) (*Response, error)
+
+// exchange performs the operation op through the interceptors, unless
+// the session is read-only and op is a write. do sends op on l, which is
+// bound to the context an interceptor passed on, and returns the final
+// response.
+func (l *conn) exchange(op interface{}, controls []Control, do func(l *conn, op interface{}, controls []Control) (asn1.RawValue, []Control, error)) (asn1.RawValue, []Control, error) {
+	if len(l.intercept) == 0 || l.canceling {
+		if err := l.refuse(op); err != nil {
+			return asn1.RawValue{}, nil, err
+		}
+		return do(l, op, controls)
+	}
+	next := func(ctx context.Context, req *Request) (*Response, error) {
+		op := req.protocolOp()
+		if err := l.refuse(op); err != nil {
+			return nil, err
+		}
+		v := l
+		if ctx != l.ctx {
+			v = &conn{session: l.session, ctx: ctx, timeout: l.timeout, stream: l.stream}
+		}
+		raw, ctrls, err := do(v, op, req.Controls)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{ResultCode: resultCode(raw), Controls: ctrls, raw: raw}, nil
+	}
+	for i := len(l.intercept) - 1; i >= 0; i-- {
+		interceptor, inner := l.intercept[i], next
+		next = func(ctx context.Context, req *Request) (*Response, error) {
+			return interceptor(ctx, req, inner)
+		}
+	}
+	req := newRequest(op, controls)
+	resp, err := next(l.ctx, req)
+	if err != nil {
+		return asn1.RawValue{}, nil, err
+	} else if resp == nil || resp.raw.RawBytes == nil {
+		return asn1.RawValue{}, nil, fmt.Errorf("ldap: interceptor returned no response to %s", req.Op)
+	}
+	return resp.raw, resp.Controls, nil
+}
+
+func newRequest(op interface{}, controls []Control) *Request {
+	req := &Request{Controls: controls, op: op}
+	ov, _ := op.(asn1.OptionValue)
+	req.Op = opNames[opTag(op)]
+	switch v := ov.Value.(type) {
+	case bindRequest:
+		req.DN = string(v.Name)
+	case SearchRequest:
+		req.DN = string(v.BaseObject)
+		req.Search = &v
+	case []byte:
+		req.DN = string(v)
+	case addRequest:
+		req.DN = string(v.Entry)
+	case modifyRequest:
+		req.DN = string(v.Object)
+	case modifyDNRequest:
+		req.DN = string(v.Entry)
+	case compareRequest:
+		req.DN = string(v.Entry)
+	case extendedRequest:
+		req.Name = string(v.Name)
+	}
+	return req
+}
+
+// protocolOp returns the op of req with the changes an interceptor made.
+func (req *Request) protocolOp() interface{} {
+	ov, ok := req.op.(asn1.OptionValue)
+	if !ok {
+		return req.op
+	}
+	dn := []byte(req.DN)
+	switch v := ov.Value.(type) {
+	case bindRequest:
+		v.Name = dn
+		ov.Value = v
+	case SearchRequest:
+		if req.Search != nil {
+			v = *req.Search
+		}
+		v.BaseObject = dn
+		ov.Value = v
+	case []byte:
+		ov.Value = dn
+	case addRequest:
+		v.Entry = dn
+		ov.Value = v
+	case modifyRequest:
+		v.Object = dn
+		ov.Value = v
+	case modifyDNRequest:
+		v.Entry = dn
+		ov.Value = v
+	case compareRequest:
+		v.Entry = dn
+		ov.Value = v
+	}
+	return ov
+}
+
+// resultCode returns the result code of a response shaped like an
+// LDAPResult, or Other if it has none.
+func resultCode(raw asn1.RawValue) ResultCode {
+	code, _, err := asn1.ParseRawValue(raw.Bytes)
+	if err != nil {
+		return Other
+	}
+	i, err := asn1.ParseInteger(code.Bytes, 16)
+	if err != nil {
+		return Other
+	}
+	return ResultCode(i)
+}
@@ -0,0 +1,122 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	client, server := net.Pipe()
+	var calls []string
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, req *Request, next RoundTripFunc) (*Response, error) {
+			calls = append(calls, name+" "+req.Op+" "+req.DN)
+			resp, err := next(ctx, req)
+			if resp != nil {
+				calls = append(calls, name+" "+resp.ResultCode.String())
+			}
+			return resp, err
+		}
+	}
+	refused := errors.New("refused")
+	rewrite := func(ctx context.Context, req *Request, next RoundTripFunc) (*Response, error) {
+		if req.Op == "delete" {
+			return nil, refused
+		}
+		req.DN = strings.Replace(req.DN, "dc=old", "dc=new", 1)
+		req.Controls = append(req.Controls, &ControlManageDsaIT{})
+		if req.Search != nil {
+			req.Search.SizeLimit = 5
+		}
+		return next(ctx, req)
+	}
+	c := newConnWithOpts(client, DialOpts{Interceptors: []Interceptor{trace("a"), trace("b"), rewrite}})
+	defer c.Close()
+
+	seen := make(chan string, 2)
+	go func() {
+		dec := asn1.NewDecoder(server)
+		dec.Implicit = true
+		for {
+			var m struct {
+				MessageId int
+				Op        asn1.RawValue
+				Controls  []control `asn1:"tag:0,optional"`
+			}
+			if err := dec.Decode(&m); err != nil {
+				return
+			}
+			switch m.Op.Tag {
+			case 14:
+				var req compareRequest
+				decodeOp(m.Op, "application,tag:14", &req)
+				seen <- fmt.Sprintf("compare %s %d", req.Entry, len(m.Controls))
+				writeTestMessage(server, m.MessageId, "application,tag:15", ldapResult{ResultCode: CompareFalse, MatchedDN: []byte{}, Message: []byte{}})
+			case 3:
+				var req struct {
+					BaseObject []byte
+					Scope      int `asn1:"enum"`
+					Deref      int `asn1:"enum"`
+					SizeLimit  int
+					TimeLimit  int
+					TypesOnly  bool
+					Filter     asn1.RawValue
+					Attributes [][]byte
+				}
+				decodeOp(m.Op, "application,tag:3", &req)
+				seen <- fmt.Sprintf("search %s %d %d", req.BaseObject, req.SizeLimit, len(m.Controls))
+				writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x,dc=new"), []partialAttribute{}})
+				writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+			default:
+				seen <- fmt.Sprintf("unexpected op %d", m.Op.Tag)
+			}
+		}
+	}()
+
+	ok, err := c.Compare("cn=x,dc=old", "cn", "x")
+	if ok || err != nil {
+		t.Errorf("Compare: %v, %v (expected false, nil)", ok, err)
+	}
+	if s := <-seen; s != "compare cn=x,dc=new 1" {
+		t.Errorf("Bad request: %s", s)
+	}
+	if err := c.Del("cn=x,dc=old"); err != refused {
+		t.Errorf("Del: %v (expected %v)", err, refused)
+	}
+	results, err := c.Search(SearchRequest{BaseObject: []byte("dc=old"), Filter: Present("objectClass")})
+	if err != nil || len(results) != 1 {
+		t.Errorf("Search: %v, %v", results, err)
+	}
+	// The delete was never sent.
+	if s := <-seen; s != "search dc=new 5 1" {
+		t.Errorf("Bad request: %s", s)
+	}
+
+	expected := []string{
+		"a compare cn=x,dc=old", "b compare cn=x,dc=old", "b compareFalse", "a compareFalse",
+		"a delete cn=x,dc=old", "b delete cn=x,dc=old",
+		"a search dc=old", "b search dc=old", "b success", "a success",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Bad calls: %q (expected %q)", calls, expected)
+	}
+}
+
+func TestInterceptorWithoutResponse(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	drop := func(ctx context.Context, req *Request, next RoundTripFunc) (*Response, error) {
+		return &Response{ResultCode: Success}, nil
+	}
+	c := newConnWithOpts(client, DialOpts{Interceptors: []Interceptor{drop}})
+	defer c.Close()
+	if err := c.Bind("cn=x", "secret"); err == nil || !strings.Contains(err.Error(), "no response") {
+		t.Errorf("Bad result: %v", err)
+	}
+}
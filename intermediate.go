@@ -0,0 +1,43 @@
+package ldap
+
+import (
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"sync"
+)
+
+var (
+	intermediateDecodersMu sync.RWMutex
+	intermediateDecoders   = map[string]func(value []byte) (interface{}, error){}
+)
+
+// RegisterIntermediate makes the values of intermediate responses named
+// responseName decode with decode, whose result is passed on in the
+// Decoded field of the IntermediateResponse. It replaces any decoder
+// already registered for the name.
+func RegisterIntermediate(responseName string, decode func(value []byte) (interface{}, error)) {
+	intermediateDecodersMu.Lock()
+	defer intermediateDecodersMu.Unlock()
+	intermediateDecoders[responseName] = decode
+}
+
+// decodeIntermediate decodes an IntermediateResponse message, and its
+// value if a decoder is registered for its name.
+func decodeIntermediate(raw asn1.RawValue) (IntermediateResponse, error) {
+	var r intermediateResponse
+	if err := decodeOp(raw, "application,tag:25", &r); err != nil {
+		return IntermediateResponse{}, fmt.Errorf("Decode IntermediateResponse: %v", err)
+	}
+	resp := IntermediateResponse{Name: string(r.Name), Value: r.Value}
+	intermediateDecodersMu.RLock()
+	decode := intermediateDecoders[resp.Name]
+	intermediateDecodersMu.RUnlock()
+	if decode != nil {
+		v, err := decode(r.Value)
+		if err != nil {
+			return IntermediateResponse{}, fmt.Errorf("Decode IntermediateResponse %s: %v", resp.Name, err)
+		}
+		resp.Decoded = v
+	}
+	return resp, nil
+}
@@ -0,0 +1,81 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestExtendedIntermediate(t *testing.T) {
+	const progress = "1.2.3.4.5.1"
+	RegisterIntermediate(progress, func(value []byte) (interface{}, error) {
+		if len(value) == 0 {
+			return nil, fmt.Errorf("empty progress")
+		}
+		return fmt.Sprintf("%s%%", value), nil
+	})
+
+	tests := []struct {
+		fail      bool
+		responses []IntermediateResponse
+		err       bool
+	}{
+		{false, []IntermediateResponse{
+			{Name: progress, Value: []byte("50"), Decoded: "50%"},
+			{Name: "1.2.3.4.5.2", Value: []byte("other")},
+		}, false},
+		{true, []IntermediateResponse{
+			{Name: progress, Value: []byte("50"), Decoded: "50%"},
+		}, true},
+	}
+	for i, test := range tests {
+		client, server := net.Pipe()
+		c := newConn(client)
+
+		go func() {
+			m, _ := readTestMessage(server)
+			writeTestMessage(server, m.MessageId, "application,tag:25", intermediateResponse{[]byte(progress), []byte("50")})
+			if test.fail {
+				// The operation is abandoned.
+				readTestMessage(server)
+				return
+			}
+			writeTestMessage(server, m.MessageId, "application,tag:25", intermediateResponse{[]byte("1.2.3.4.5.2"), []byte("other")})
+			writeTestMessage(server, m.MessageId, "application,tag:24", extendedResponse{
+				Result: ldapResult{MatchedDN: []byte{}, Message: []byte{}},
+				Name:   []byte("1.2.3.4.5"),
+				Value:  []byte("done"),
+			})
+		}()
+
+		var responses []IntermediateResponse
+		r, err := c.Extended("1.2.3.4.5", []byte("go"), func(resp IntermediateResponse, _ []Control) error {
+			responses = append(responses, resp)
+			if test.fail {
+				return errors.New("stop")
+			}
+			return nil
+		})
+		if (err != nil) != test.err || !reflect.DeepEqual(responses, test.responses) {
+			t.Errorf("#%d: Bad result: %+v, %v (expected %+v)", i, responses, err, test.responses)
+		}
+		if !test.err && (r.Name != "1.2.3.4.5" || string(r.Value) != "done") {
+			t.Errorf("#%d: Bad response: %+v", i, r)
+		}
+		c.Close()
+	}
+
+	// A value the registered decoder rejects is an error.
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:25", intermediateResponse{[]byte(progress), nil})
+	}()
+	if _, err := c.Extended("1.2.3.4.5", nil, nil); err == nil {
+		t.Errorf("Expected error for an undecodable intermediate response")
+	}
+}
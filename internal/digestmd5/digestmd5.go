@@ -0,0 +1,88 @@
+// Package digestmd5 holds the parts of the DIGEST-MD5 SASL mechanism
+// (RFC 2831) that the client and the server share.
+package digestmd5
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Response computes the response of a client using the auth quality of
+// protection with a nonce count of 1, and the server's reply to it.
+func Response(username, realm, password, nonce, cnonce, authzID, digestURI string) (response, rspauth string) {
+	h := func(s string) []byte { sum := md5.Sum([]byte(s)); return sum[:] }
+	hexh := func(s string) string { return hex.EncodeToString(h(s)) }
+
+	a1 := string(h(username+":"+realm+":"+password)) + ":" + nonce + ":" + cnonce
+	if authzID != "" {
+		a1 += ":" + authzID
+	}
+	kd := func(a2 string) string {
+		return hexh(hexh(a1) + ":" + nonce + ":00000001:" + cnonce + ":auth:" + hexh(a2))
+	}
+	return kd("AUTHENTICATE:" + digestURI), kd(":" + digestURI)
+}
+
+// ParseDirectives parses a comma-separated list of name=value pairs,
+// whose values may be quoted strings. A name may be repeated only if it
+// is among repeatable, and then the first value is kept.
+func ParseDirectives(s string, repeatable ...string) (map[string]string, error) {
+	d := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("malformed directive %q", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimSpace(s[i+1:])
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated quoted string")
+			}
+			s = s[i+1:]
+		} else {
+			i = strings.IndexByte(s, ',')
+			if i < 0 {
+				i = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		if _, ok := d[name]; !ok {
+			d[name] = value.String()
+		} else if !contains(repeatable, name) {
+			return nil, fmt.Errorf("repeated directive %s", name)
+		}
+		s = strings.TrimSpace(s)
+		if strings.HasPrefix(s, ",") {
+			s = strings.TrimSpace(s[1:])
+		} else if s != "" {
+			return nil, fmt.Errorf("expected comma before %q", s)
+		}
+	}
+	return d, nil
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Quote returns s as a quoted string.
+func Quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,47 @@
+package digestmd5
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+	// The example of RFC 2831 §4.
+	response, rspauth := Response("chris", "elwood.innosoft.com", "secret", "OA6MG9tEQGm2hh", "OA6MHXh6VqTrRk", "", "imap/elwood.innosoft.com")
+	if response != "d388dad90d4bbd760a152321f2143af7" {
+		t.Errorf("Bad response: %s (expected %s)", response, "d388dad90d4bbd760a152321f2143af7")
+	}
+	if rspauth != "ea40f60335c427b5527b84dbabcdfffd" {
+		t.Errorf("Bad rspauth: %s (expected %s)", rspauth, "ea40f60335c427b5527b84dbabcdfffd")
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected map[string]string
+	}{
+		{`realm="elwood.innosoft.com",nonce="OA6MG9tEQGm2hh",qop="auth",algorithm=md5-sess,charset=utf-8`,
+			map[string]string{"realm": "elwood.innosoft.com", "nonce": "OA6MG9tEQGm2hh", "qop": "auth", "algorithm": "md5-sess", "charset": "utf-8"}},
+		{`realm="a", realm="b", qop="auth,auth-int"`, map[string]string{"realm": "a", "qop": "auth,auth-int"}},
+		{`Username="a \"b\" c"`, map[string]string{"username": `a "b" c`}},
+		{`rspauth=ea40f60335c427b5527b84dbabcdfffd`, map[string]string{"rspauth": "ea40f60335c427b5527b84dbabcdfffd"}},
+		{"", map[string]string{}},
+	}
+	for i, test := range tests {
+		d, err := ParseDirectives(test.in, "realm")
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+		} else if !reflect.DeepEqual(d, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, d, test.expected)
+		}
+	}
+	for i, in := range []string{`realm`, `realm="a`, `a="b" c=d`, `nonce="a",nonce="b"`} {
+		if _, err := ParseDirectives(in, "realm"); err == nil {
+			t.Errorf("#%d: Expected error for %q", i, in)
+		}
+	}
+	if _, err := ParseDirectives(`realm="a",realm="b"`); err == nil {
+		t.Error("Expected error for a repeated realm")
+	}
+}
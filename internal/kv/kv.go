@@ -0,0 +1,733 @@
+// Package kv is an embedded key-value store: a B+tree of byte string
+// keys and values in a single file. Updates are made in transactions
+// that are on disk when they commit, or leave no trace if they do not.
+//
+// The tree is copy-on-write. A transaction appends the nodes it changed
+// to the file, and commits by writing a header naming the new root in
+// one of two slots at the start of the file, so that a crash while
+// writing either leaves the last committed tree in place. The nodes a
+// transaction replaces are garbage until Compact rewrites the file.
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrClosed is returned by the methods of a closed DB.
+var ErrClosed = errors.New("kv: database closed")
+
+const (
+	magic      = "ldapkv01"
+	slotSize   = 256
+	headerSize = 2 * slotSize // where the nodes begin
+
+	// A node is split when it has more than maxItems items, or its
+	// encoding more than maxNodeSize bytes.
+	maxItems    = 128
+	maxNodeSize = 8192
+
+	// The cache of decoded nodes is emptied when it holds cacheSize.
+	cacheSize = 4096
+
+	minCompactSize = 1 << 20
+)
+
+// A DB is a store in a file. It is safe for concurrent use.
+type DB struct {
+	path string
+
+	mu      sync.RWMutex // guards the fields below; held for writing by Update
+	f       *os.File
+	seq     uint64 // of the last commit
+	root    int64  // offset of the root node, or 0 if the tree is empty
+	size    int64  // of the committed file
+	garbage int64  // bytes of nodes no longer in the tree
+	err     error  // sticky, after a failed write or Close
+
+	cacheMu sync.Mutex
+	cache   map[int64]*node
+}
+
+// A node of the tree. A leaf holds keys and their values; a branch
+// holds the first key of each of its children, whose keys are at least
+// that key and less than the next. The first key of a branch is only a
+// lower bound.
+type node struct {
+	off  int64 // where the node is stored, or 0 if it has been changed
+	size int64 // of its encoding on disk
+	leaf bool
+	keys [][]byte
+	vals [][]byte // of a leaf
+	kids []ref    // of a branch
+}
+
+// A ref names a node, on disk or changed in a transaction.
+type ref struct {
+	off int64
+	n   *node
+}
+
+func (r ref) empty() bool { return r.off == 0 && r.n == nil }
+
+// Open opens the store in the file at path, creating it if it does not
+// exist. Nodes a crash left after the last commit are truncated.
+func Open(path string) (*DB, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{path: path, f: f}
+	if err := db.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("kv: %s: %v", path, err)
+	}
+	return db, nil
+}
+
+// load reads the newer valid header, initializing an empty file.
+func (db *DB) load() error {
+	info, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		db.size = headerSize
+		return db.writeHeader()
+	}
+	buf := make([]byte, headerSize)
+	if _, err := db.f.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("reading header: %v", err)
+	}
+	found := false
+	for slot := 0; slot < 2; slot++ {
+		h, ok := decodeHeader(buf[slot*slotSize : (slot+1)*slotSize])
+		if ok && (!found || h.seq > db.seq) {
+			db.seq, db.root, db.size, db.garbage = h.seq, h.root, h.size, h.garbage
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("no valid header")
+	}
+	if info.Size() < db.size {
+		return fmt.Errorf("file is %d bytes, expected %d", info.Size(), db.size)
+	}
+	if info.Size() > db.size {
+		return db.f.Truncate(db.size)
+	}
+	return nil
+}
+
+type header struct {
+	seq        uint64
+	root, size int64
+	garbage    int64
+}
+
+// A header is the magic, the sequence number, root, size and garbage,
+// and the CRC-32 of them.
+const headerLen = len(magic) + 4*8 + 4
+
+func decodeHeader(b []byte) (header, bool) {
+	if string(b[:len(magic)]) != magic {
+		return header{}, false
+	}
+	n := headerLen - 4
+	if crc32.ChecksumIEEE(b[:n]) != binary.BigEndian.Uint32(b[n:]) {
+		return header{}, false
+	}
+	p := b[len(magic):]
+	return header{
+		seq:     binary.BigEndian.Uint64(p),
+		root:    int64(binary.BigEndian.Uint64(p[8:])),
+		size:    int64(binary.BigEndian.Uint64(p[16:])),
+		garbage: int64(binary.BigEndian.Uint64(p[24:])),
+	}, true
+}
+
+// writeHeader commits the state of db in the slot after the last.
+func (db *DB) writeHeader() error {
+	db.seq++
+	b := make([]byte, headerLen)
+	copy(b, magic)
+	p := b[len(magic):]
+	binary.BigEndian.PutUint64(p, db.seq)
+	binary.BigEndian.PutUint64(p[8:], uint64(db.root))
+	binary.BigEndian.PutUint64(p[16:], uint64(db.size))
+	binary.BigEndian.PutUint64(p[24:], uint64(db.garbage))
+	n := headerLen - 4
+	binary.BigEndian.PutUint32(b[n:], crc32.ChecksumIEEE(b[:n]))
+	if _, err := db.f.WriteAt(b, int64(db.seq%2)*slotSize); err != nil {
+		return err
+	}
+	return db.f.Sync()
+}
+
+// Close closes the file. Later calls fail with ErrClosed.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.f == nil {
+		return nil
+	}
+	err := db.f.Close()
+	db.f = nil
+	db.err = ErrClosed
+	return err
+}
+
+// Get returns the value of key, and whether it was found.
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.f == nil {
+		return nil, false, ErrClosed
+	}
+	tx := &Tx{db: db, root: ref{off: db.root}}
+	return tx.Get(key)
+}
+
+// Scan calls fn with the keys that begin with prefix, from the first
+// not less than start, and their values, in order, until fn returns
+// false. fn must not retain them or call the methods of db.
+func (db *DB) Scan(prefix, start []byte, fn func(key, value []byte) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.f == nil {
+		return ErrClosed
+	}
+	tx := &Tx{db: db, root: ref{off: db.root}}
+	return tx.Scan(prefix, start, fn)
+}
+
+// Update calls fn with a transaction, which commits if fn returns nil.
+// Other calls wait until it is done. Once more than half of a file of
+// at least minCompactSize is garbage, it is compacted.
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.err != nil {
+		return db.err
+	}
+	tx := &Tx{db: db, root: ref{off: db.root}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.commit(); err != nil {
+		return err
+	}
+	if db.size >= minCompactSize && db.garbage > db.size/2 {
+		// The transaction is committed whether or not this succeeds.
+		db.compact()
+	}
+	return nil
+}
+
+// Garbage returns the size of the file, and how much of it is nodes no
+// longer in the tree, which Compact would reclaim.
+func (db *DB) Garbage() (size, garbage int64) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.size, db.garbage
+}
+
+// Compact rewrites the file with only the nodes of the tree.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.err != nil {
+		return db.err
+	}
+	return db.compact()
+}
+
+func (db *DB) compact() error {
+	tmp := db.path + ".compact"
+	os.Remove(tmp)
+	out, err := Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	// Copy the items in transactions of a bounded size.
+	var keys, vals [][]byte
+	flush := func() error {
+		err := out.Update(func(tx *Tx) error {
+			for i, k := range keys {
+				if err := tx.Put(k, vals[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		keys, vals = keys[:0], vals[:0]
+		return err
+	}
+	var werr error
+	scan := &Tx{db: db, root: ref{off: db.root}}
+	err = scan.Scan(nil, nil, func(k, v []byte) bool {
+		keys = append(keys, append([]byte(nil), k...))
+		vals = append(vals, append([]byte(nil), v...))
+		if len(keys) == 10000 {
+			werr = flush()
+		}
+		return werr == nil
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = out.f.Close()
+	} else {
+		out.f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, db.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(db.path, os.O_RDWR, 0)
+	if err != nil {
+		// The file is the compacted one, which db no longer matches.
+		db.err = err
+		return err
+	}
+	db.f.Close()
+	db.f = f
+	db.seq, db.root, db.size, db.garbage = out.seq, out.root, out.size, out.garbage
+	db.cacheMu.Lock()
+	db.cache = nil
+	db.cacheMu.Unlock()
+	return nil
+}
+
+// read returns the node stored at off, which the caller must not change.
+func (db *DB) read(off int64) (*node, error) {
+	db.cacheMu.Lock()
+	n := db.cache[off]
+	db.cacheMu.Unlock()
+	if n != nil {
+		return n, nil
+	}
+
+	var hdr [8]byte
+	if _, err := db.f.ReadAt(hdr[:], off); err != nil {
+		return nil, fmt.Errorf("kv: reading node at %d: %v", off, err)
+	}
+	size := int64(binary.BigEndian.Uint32(hdr[:]))
+	if off+8+size > db.size {
+		return nil, fmt.Errorf("kv: bad node at %d", off)
+	}
+	body := make([]byte, size)
+	if _, err := db.f.ReadAt(body, off+8); err != nil {
+		return nil, fmt.Errorf("kv: reading node at %d: %v", off, err)
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(hdr[4:]) {
+		return nil, fmt.Errorf("kv: bad checksum of node at %d", off)
+	}
+	n, err := decodeNode(body)
+	if err != nil {
+		return nil, fmt.Errorf("kv: node at %d: %v", off, err)
+	}
+	n.off, n.size = off, 8+size
+
+	db.cacheMu.Lock()
+	if db.cache == nil || len(db.cache) >= cacheSize {
+		db.cache = map[int64]*node{}
+	}
+	db.cache[off] = n
+	db.cacheMu.Unlock()
+	return n, nil
+}
+
+// A node is encoded as whether it is a leaf, the number of items, and
+// each key followed by its value or the offset of its child.
+func (n *node) encode() []byte {
+	var buf []byte
+	if n.leaf {
+		buf = append(buf, 1)
+	} else {
+		buf = append(buf, 0)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(n.keys)))
+	for i, k := range n.keys {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		if n.leaf {
+			buf = binary.AppendUvarint(buf, uint64(len(n.vals[i])))
+			buf = append(buf, n.vals[i]...)
+		} else {
+			buf = binary.AppendUvarint(buf, uint64(n.kids[i].off))
+		}
+	}
+	return buf
+}
+
+func decodeNode(b []byte) (*node, error) {
+	errBad := errors.New("bad encoding")
+	if len(b) == 0 {
+		return nil, errBad
+	}
+	n := &node{leaf: b[0] == 1}
+	b = b[1:]
+	count, k := binary.Uvarint(b)
+	if k <= 0 || count > uint64(len(b)) {
+		return nil, errBad
+	}
+	b = b[k:]
+	bytesOf := func() ([]byte, bool) {
+		l, k := binary.Uvarint(b)
+		if k <= 0 || l > uint64(len(b)-k) {
+			return nil, false
+		}
+		v := b[k : k+int(l)]
+		b = b[k+int(l):]
+		return v, true
+	}
+	for i := uint64(0); i < count; i++ {
+		key, ok := bytesOf()
+		if !ok {
+			return nil, errBad
+		}
+		n.keys = append(n.keys, key)
+		if n.leaf {
+			v, ok := bytesOf()
+			if !ok {
+				return nil, errBad
+			}
+			n.vals = append(n.vals, v)
+		} else {
+			off, k := binary.Uvarint(b)
+			if k <= 0 || off < headerSize {
+				return nil, errBad
+			}
+			b = b[k:]
+			n.kids = append(n.kids, ref{off: int64(off)})
+		}
+	}
+	return n, nil
+}
+
+// encodedSize estimates the size of n's encoding.
+func (n *node) encodedSize() int {
+	size := 1 + binary.MaxVarintLen64
+	for i, k := range n.keys {
+		size += len(k) + 2*binary.MaxVarintLen64
+		if n.leaf {
+			size += len(n.vals[i])
+		}
+	}
+	return size
+}
+
+// A Tx is a transaction, whose changes are seen by its own methods, and
+// by others once it commits.
+type Tx struct {
+	db      *DB
+	root    ref
+	garbage int64 // bytes of the nodes it replaced
+}
+
+// load returns the node r names.
+func (tx *Tx) load(r ref) (*node, error) {
+	if r.n != nil {
+		return r.n, nil
+	}
+	if r.off == 0 {
+		return &node{leaf: true}, nil
+	}
+	return tx.db.read(r.off)
+}
+
+// writable returns the node r names, copied if it is on disk, so that
+// the transaction may change it.
+func (tx *Tx) writable(r ref) (*node, error) {
+	if r.n != nil {
+		return r.n, nil
+	}
+	n, err := tx.load(r)
+	if err != nil {
+		return nil, err
+	}
+	tx.garbage += n.size
+	c := &node{leaf: n.leaf}
+	c.keys = append(c.keys, n.keys...)
+	c.vals = append(c.vals, n.vals...)
+	c.kids = append(c.kids, n.kids...)
+	return c, nil
+}
+
+// child returns the index of the child of the branch n whose keys key
+// would be among.
+func (n *node) child(key []byte) int {
+	i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) > 0 }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// Get returns the value of key, and whether it was found.
+func (tx *Tx) Get(key []byte) ([]byte, bool, error) {
+	r := tx.root
+	for !r.empty() {
+		n, err := tx.load(r)
+		if err != nil {
+			return nil, false, err
+		}
+		if n.leaf {
+			i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+			if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
+				return n.vals[i], true, nil
+			}
+			return nil, false, nil
+		}
+		r = n.kids[n.child(key)]
+	}
+	return nil, false, nil
+}
+
+// Scan calls fn with the keys that begin with prefix, from the first
+// not less than start, and their values, in order, until fn returns
+// false. fn must not retain them.
+func (tx *Tx) Scan(prefix, start []byte, fn func(key, value []byte) bool) error {
+	if tx.root.empty() {
+		return nil
+	}
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	_, err := tx.scan(tx.root, start, func(k, v []byte) bool {
+		return bytes.HasPrefix(k, prefix) && fn(k, v)
+	})
+	return err
+}
+
+// scan calls fn with the items of the subtree r from start on, and
+// reports whether fn asked for more.
+func (tx *Tx) scan(r ref, start []byte, fn func(key, value []byte) bool) (bool, error) {
+	n, err := tx.load(r)
+	if err != nil {
+		return false, err
+	}
+	if n.leaf {
+		i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], start) >= 0 })
+		for ; i < len(n.keys); i++ {
+			if !fn(n.keys[i], n.vals[i]) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+	for i := n.child(start); i < len(n.kids); i++ {
+		if more, err := tx.scan(n.kids[i], start, fn); !more || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Put sets the value of key.
+func (tx *Tx) Put(key, value []byte) error {
+	n, right, err := tx.put(tx.root, key, value)
+	if err != nil {
+		return err
+	}
+	if right != nil {
+		n = &node{keys: [][]byte{nil, right.keys[0]}, kids: []ref{{n: n}, {n: right}}}
+	}
+	tx.root = ref{n: n}
+	return nil
+}
+
+// put sets key in the subtree r, returning its new root, and the node
+// split from it to its right, if any.
+func (tx *Tx) put(r ref, key, value []byte) (*node, *node, error) {
+	n, err := tx.writable(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	key = append([]byte(nil), key...)
+	if n.leaf {
+		i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+		value = append([]byte(nil), value...)
+		if i < len(n.keys) && bytes.Equal(n.keys[i], key) {
+			n.vals[i] = value
+		} else {
+			n.keys = append(n.keys[:i], append([][]byte{key}, n.keys[i:]...)...)
+			n.vals = append(n.vals[:i], append([][]byte{value}, n.vals[i:]...)...)
+		}
+		return n, n.split(), nil
+	}
+	i := n.child(key)
+	kid, right, err := tx.put(n.kids[i], key, value)
+	if err != nil {
+		return nil, nil, err
+	}
+	n.kids[i] = ref{n: kid}
+	if right != nil {
+		n.keys = append(n.keys[:i+1], append([][]byte{right.keys[0]}, n.keys[i+1:]...)...)
+		n.kids = append(n.kids[:i+1], append([]ref{{n: right}}, n.kids[i+1:]...)...)
+	}
+	return n, n.split(), nil
+}
+
+// split moves the second half of the items of n, if it is too large,
+// to a new node, which it returns.
+func (n *node) split() *node {
+	if len(n.keys) < 2 || (len(n.keys) <= maxItems && n.encodedSize() <= maxNodeSize) {
+		return nil
+	}
+	mid := len(n.keys) / 2
+	if n.leaf {
+		// Split by size, so that large values are spread out.
+		half, size := n.encodedSize()/2, 0
+		for mid = 0; mid < len(n.keys)-1 && size < half; mid++ {
+			size += len(n.keys[mid]) + len(n.vals[mid])
+		}
+		if mid == 0 {
+			mid = 1
+		}
+	}
+	right := &node{leaf: n.leaf}
+	right.keys = append(right.keys, n.keys[mid:]...)
+	n.keys = n.keys[:mid:mid]
+	if n.leaf {
+		right.vals = append(right.vals, n.vals[mid:]...)
+		n.vals = n.vals[:mid:mid]
+	} else {
+		right.kids = append(right.kids, n.kids[mid:]...)
+		n.kids = n.kids[:mid:mid]
+	}
+	return right
+}
+
+// Delete removes key, if it is present.
+func (tx *Tx) Delete(key []byte) error {
+	if tx.root.empty() {
+		return nil
+	}
+	if _, found, err := tx.Get(key); !found || err != nil {
+		return err
+	}
+	n, err := tx.delete(tx.root, key)
+	if err != nil {
+		return err
+	}
+	// A branch with one child is replaced by the child.
+	for n != nil && !n.leaf && len(n.kids) == 1 {
+		if n, err = tx.writable(n.kids[0]); err != nil {
+			return err
+		}
+	}
+	if n == nil || len(n.keys) == 0 {
+		tx.root = ref{}
+	} else {
+		tx.root = ref{n: n}
+	}
+	return nil
+}
+
+// delete removes key, which is present, from the subtree r, returning
+// its new root, or nil if it is empty. Nodes are not merged, so may be
+// left small until the file is compacted.
+func (tx *Tx) delete(r ref, key []byte) (*node, error) {
+	n, err := tx.writable(r)
+	if err != nil {
+		return nil, err
+	}
+	if n.leaf {
+		i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.vals = append(n.vals[:i], n.vals[i+1:]...)
+	} else {
+		i := n.child(key)
+		kid, err := tx.delete(n.kids[i], key)
+		if err != nil {
+			return nil, err
+		}
+		if kid != nil {
+			n.kids[i] = ref{n: kid}
+		} else {
+			n.keys = append(n.keys[:i], n.keys[i+1:]...)
+			n.kids = append(n.kids[:i], n.kids[i+1:]...)
+		}
+	}
+	if len(n.keys) == 0 {
+		return nil, nil
+	}
+	return n, nil
+}
+
+// commit writes the nodes the transaction changed after the end of the
+// file, then the header naming its root.
+func (tx *Tx) commit() error {
+	db := tx.db
+	if tx.root.n == nil && tx.root.off == db.root {
+		return nil
+	}
+	var buf []byte
+	var root int64
+	if tx.root.n != nil {
+		var err error
+		if root, err = tx.write(tx.root.n, &buf); err != nil {
+			return err
+		}
+	}
+	if _, err := db.f.WriteAt(buf, db.size); err != nil {
+		return err
+	}
+	if err := db.f.Sync(); err != nil {
+		return err
+	}
+
+	seq, prevRoot, size, garbage := db.seq, db.root, db.size, db.garbage
+	db.root, db.size, db.garbage = root, db.size+int64(len(buf)), db.garbage+tx.garbage
+	if err := db.writeHeader(); err != nil {
+		// The header may or may not be on disk, so refuse further
+		// updates, as a reopened store could have either tree.
+		db.seq, db.root, db.size, db.garbage = seq, prevRoot, size, garbage
+		db.err = fmt.Errorf("kv: committing: %v", err)
+		return db.err
+	}
+	return nil
+}
+
+// write appends n and the changed nodes below it to buf, which is to be
+// written at the end of the file, and returns the offset of n.
+func (tx *Tx) write(n *node, buf *[]byte) (int64, error) {
+	if !n.leaf {
+		for i, kid := range n.kids {
+			if kid.n == nil {
+				continue
+			}
+			off, err := tx.write(kid.n, buf)
+			if err != nil {
+				return 0, err
+			}
+			n.kids[i] = ref{off: off}
+		}
+	}
+	body := n.encode()
+	if len(body) > 1<<32-1 {
+		return 0, errors.New("kv: node too large")
+	}
+	off := tx.db.size + int64(len(*buf))
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(body)))
+	binary.BigEndian.PutUint32(hdr[4:], crc32.ChecksumIEEE(body))
+	*buf = append(append(*buf, hdr[:]...), body...)
+	return off, nil
+}
@@ -0,0 +1,197 @@
+package kv
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func contents(t *testing.T, db *DB, prefix string) map[string]string {
+	t.Helper()
+	m := map[string]string{}
+	var last string
+	err := db.Scan([]byte(prefix), nil, func(k, v []byte) bool {
+		if len(m) > 0 && string(k) <= last {
+			t.Errorf("Scan out of order: %q after %q", k, last)
+		}
+		last = string(k)
+		m[string(k)] = string(v)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{}
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		err := db.Update(func(tx *Tx) error {
+			for i := 0; i < 500; i++ {
+				k := fmt.Sprintf("key%04d", rnd.Intn(3000))
+				if rnd.Intn(3) == 0 {
+					delete(expected, k)
+					if err := tx.Delete([]byte(k)); err != nil {
+						return err
+					}
+					continue
+				}
+				// Some values are large, to split nodes by size.
+				v := fmt.Sprintf("%s-%d-%0*d", k, round, rnd.Intn(4)*1000, 0)
+				expected[k] = v
+				if err := tx.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m := contents(t, db, ""); !reflect.DeepEqual(m, expected) {
+		t.Fatalf("Bad contents: %d items (expected %d)", len(m), len(expected))
+	}
+	for k, v := range expected {
+		if got, ok, err := db.Get([]byte(k)); err != nil || !ok || string(got) != v {
+			t.Fatalf("Bad Get(%q): %.20q, %v, %v", k, got, ok, err)
+		}
+	}
+	if _, ok, err := db.Get([]byte("nokey")); ok || err != nil {
+		t.Errorf("Bad Get of missing key: %v, %v", ok, err)
+	}
+
+	// A failed transaction changes nothing.
+	stop := fmt.Errorf("stop")
+	err = db.Update(func(tx *Tx) error {
+		tx.Put([]byte("key0000"), []byte("changed"))
+		return stop
+	})
+	if err != stop {
+		t.Errorf("Bad Update result: %v", err)
+	}
+
+	var prefixed []string
+	for k := range expected {
+		if k[:6] == "key012" {
+			prefixed = append(prefixed, k)
+		}
+	}
+	sort.Strings(prefixed)
+	var scanned []string
+	db.Scan([]byte("key012"), nil, func(k, v []byte) bool {
+		scanned = append(scanned, string(k))
+		return true
+	})
+	if !reflect.DeepEqual(scanned, prefixed) {
+		t.Errorf("Bad prefix scan: %v (expected %v)", scanned, prefixed)
+	}
+	scanned = nil
+	db.Scan([]byte("key012"), []byte(prefixed[1]), func(k, v []byte) bool {
+		scanned = append(scanned, string(k))
+		return len(scanned) < 2
+	})
+	if !reflect.DeepEqual(scanned, prefixed[1:3]) {
+		t.Errorf("Bad scan from %q: %v (expected %v)", prefixed[1], scanned, prefixed[1:3])
+	}
+
+	// Updates compacted the file as it grew.
+	if size, garbage := db.Garbage(); size < minCompactSize || garbage > size/2 {
+		t.Errorf("Bad garbage: %d of %d bytes", garbage, size)
+	}
+	if err := db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, garbage := db.Garbage(); garbage != 0 {
+		t.Errorf("Bad garbage after Compact: %d", garbage)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := db.Get([]byte("key0000")); err != ErrClosed {
+		t.Errorf("Bad Get after Close: %v", err)
+	}
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if m := contents(t, db, ""); !reflect.DeepEqual(m, expected) {
+		t.Errorf("Bad contents after reopening: %d items (expected %d)", len(m), len(expected))
+	}
+	err = db.Update(func(tx *Tx) error {
+		for k := range expected {
+			if err := tx.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := contents(t, db, ""); len(m) != 0 {
+		t.Errorf("Bad contents after deleting all: %d items", len(m))
+	}
+}
+
+func TestDBCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(k, v string) {
+		t.Helper()
+		if err := db.Update(func(tx *Tx) error { return tx.Put([]byte(k), []byte(v)) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a", "1")
+	put("b", "2")
+	seq := db.seq
+	db.Close()
+
+	// The nodes of a transaction were written, but not its header.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("torn node"))
+	f.Close()
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if m := contents(t, db, ""); !reflect.DeepEqual(m, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("Bad contents: %v", m)
+	}
+	put("c", "3")
+	db.Close()
+
+	// The last header was torn: the tree before it is used.
+	f, err = os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("torn"), int64((seq+1)%2)*slotSize+20)
+	f.Close()
+	if db, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if m := contents(t, db, ""); !reflect.DeepEqual(m, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("Bad contents: %v", m)
+	}
+}
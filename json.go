@@ -0,0 +1,250 @@
+package ldap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// The JSON forms of entries, attributes and searches are meant to be
+// stable, for REST gateways and the like. An attribute is
+//
+//	{"name": "cn", "values": ["Barbara Jensen"]}
+//
+// with "base64": true if any of its values is not UTF-8, in which case
+// all of them are base64. An entry or search result is
+//
+//	{"dn": "cn=Barbara Jensen,dc=example,dc=com", "attributes": [...]}
+//
+// and a modification an attribute with an "operation" of "add",
+// "delete", "replace" or "increment".
+
+type jsonAttribute struct {
+	Operation string   `json:"operation,omitempty"`
+	Name      string   `json:"name"`
+	Values    []string `json:"values"`
+	Base64    bool     `json:"base64,omitempty"`
+}
+
+func newJSONAttribute(name string, values []string) jsonAttribute {
+	a := jsonAttribute{Name: name, Values: values}
+	if a.Values == nil {
+		a.Values = []string{}
+	}
+	for _, v := range values {
+		if !utf8.ValidString(v) {
+			a.Base64 = true
+		}
+	}
+	if a.Base64 {
+		a.Values = make([]string, len(values))
+		for i, v := range values {
+			a.Values[i] = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+	}
+	return a
+}
+
+func (a jsonAttribute) values() ([]string, error) {
+	if !a.Base64 {
+		return a.Values, nil
+	}
+	values := make([]string, len(a.Values))
+	for i, v := range a.Values {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: invalid base64 value of %s: %v", a.Name, err)
+		}
+		values[i] = string(b)
+	}
+	return values, nil
+}
+
+func (a Attribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newJSONAttribute(a.Type, a.Values))
+}
+
+func (a *Attribute) UnmarshalJSON(b []byte) error {
+	var j jsonAttribute
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	values, err := j.values()
+	if err != nil {
+		return err
+	}
+	*a = Attribute{Type: j.Name, Values: values}
+	return nil
+}
+
+var modifyOperationNames = map[ModifyOperation]string{
+	AddValues:      "add",
+	DeleteValues:   "delete",
+	ReplaceValues:  "replace",
+	IncrementValue: "increment",
+}
+
+func (m Modification) MarshalJSON() ([]byte, error) {
+	j := newJSONAttribute(m.Type, m.Values)
+	j.Operation = modifyOperationNames[m.Operation]
+	if j.Operation == "" {
+		return nil, fmt.Errorf("ldap: unknown modify operation %d", m.Operation)
+	}
+	return json.Marshal(j)
+}
+
+func (m *Modification) UnmarshalJSON(b []byte) error {
+	var j jsonAttribute
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	values, err := j.values()
+	if err != nil {
+		return err
+	}
+	for op, name := range modifyOperationNames {
+		if name == j.Operation {
+			*m = Modification{op, Attribute{j.Name, values}}
+			return nil
+		}
+	}
+	return fmt.Errorf("ldap: unknown modify operation %q", j.Operation)
+}
+
+type jsonEntry struct {
+	DN         string      `json:"dn"`
+	Attributes []Attribute `json:"attributes"`
+}
+
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	j := jsonEntry{DN: e.DN, Attributes: make([]Attribute, len(e.Attributes))}
+	for i, a := range e.Attributes {
+		j.Attributes[i] = Attribute{a.Name, a.Values}
+	}
+	return json.Marshal(j)
+}
+
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	var j jsonEntry
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*e = Entry{DN: j.DN}
+	for _, a := range j.Attributes {
+		e.Attributes = append(e.Attributes, NewEntryAttribute(a.Type, a.Values))
+	}
+	return nil
+}
+
+// A search result has the JSON form of an entry, with its attributes
+// sorted by name.
+func (r SearchResult) MarshalJSON() ([]byte, error) {
+	return r.Entry().MarshalJSON()
+}
+
+func (r *SearchResult) UnmarshalJSON(b []byte) error {
+	var e Entry
+	if err := e.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	*r = SearchResult{DN: e.DN, Attributes: map[string][]string{}}
+	for _, a := range e.Attributes {
+		r.Attributes[a.Name] = append(r.Attributes[a.Name], a.Values...)
+	}
+	return nil
+}
+
+// The JSON form of a search request names the scope and alias
+// dereferencing as in LDAP URLs and ldapsearch, and has the filter in
+// its string form:
+//
+//	{"base": "dc=example,dc=com", "scope": "sub", "deref": "never",
+//	 "filter": "(uid=jdoe)", "attributes": ["cn", "mail"]}
+type jsonSearchRequest struct {
+	Base       string   `json:"base"`
+	Scope      string   `json:"scope"`
+	Deref      string   `json:"deref"`
+	SizeLimit  int      `json:"sizeLimit,omitempty"`
+	TimeLimit  int      `json:"timeLimit,omitempty"`
+	TypesOnly  bool     `json:"typesOnly,omitempty"`
+	Filter     string   `json:"filter"`
+	Attributes []string `json:"attributes,omitempty"`
+}
+
+var scopeJSONNames = map[SearchScope]string{
+	BaseObject:   "base",
+	SingleLevel:  "one",
+	WholeSubtree: "sub",
+}
+
+var derefJSONNames = map[DerefAliases]string{
+	NeverDerefAliases:   "never",
+	DerefInSearching:    "search",
+	DerefFindingBaseObj: "find",
+	DerefAlways:         "always",
+}
+
+func (req SearchRequest) MarshalJSON() ([]byte, error) {
+	j := jsonSearchRequest{
+		Base:      string(req.BaseObject),
+		Scope:     scopeJSONNames[req.Scope],
+		Deref:     derefJSONNames[req.Deref],
+		SizeLimit: req.SizeLimit,
+		TimeLimit: req.TimeLimit,
+		TypesOnly: req.TypesOnly,
+		Filter:    "(objectClass=*)",
+	}
+	if j.Scope == "" || j.Deref == "" {
+		return nil, fmt.Errorf("ldap: invalid scope or alias dereferencing of search of %q", j.Base)
+	}
+	if req.Filter != nil {
+		var err error
+		if j.Filter, err = DecompileFilter(req.Filter); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range req.Attributes {
+		j.Attributes = append(j.Attributes, string(a))
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON defaults the scope to "sub", the alias dereferencing to
+// "never" and the filter to (objectClass=*).
+func (req *SearchRequest) UnmarshalJSON(b []byte) error {
+	j := jsonSearchRequest{Scope: "sub", Deref: "never", Filter: "(objectClass=*)"}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	filter, err := CompileFilter(j.Filter)
+	if err != nil {
+		return err
+	}
+	*req = SearchRequest{
+		BaseObject: []byte(j.Base),
+		Scope:      -1,
+		Deref:      -1,
+		SizeLimit:  j.SizeLimit,
+		TimeLimit:  j.TimeLimit,
+		TypesOnly:  j.TypesOnly,
+		Filter:     filter,
+	}
+	for scope, name := range scopeJSONNames {
+		if name == j.Scope {
+			req.Scope = scope
+		}
+	}
+	for deref, name := range derefJSONNames {
+		if name == j.Deref {
+			req.Deref = deref
+		}
+	}
+	if req.Scope < 0 || req.Deref < 0 {
+		return fmt.Errorf("ldap: invalid scope %q or alias dereferencing %q", j.Scope, j.Deref)
+	}
+	for _, a := range j.Attributes {
+		req.Attributes = append(req.Attributes, []byte(a))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package ldap
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEntryJSON(t *testing.T) {
+	e := &Entry{DN: "cn=a,dc=example,dc=com", Attributes: []*EntryAttribute{
+		NewEntryAttribute("objectClass", []string{"top", "person"}),
+		NewEntryAttribute("jpegPhoto", []string{"\xff\xd8\xff"}),
+	}}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"dn":"cn=a,dc=example,dc=com","attributes":[{"name":"objectClass","values":["top","person"]},{"name":"jpegPhoto","values":["/9j/"],"base64":true}]}`
+	if string(b) != expected {
+		t.Errorf("Bad result: %s (expected %s)", b, expected)
+	}
+	var actual Entry
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&actual, e) {
+		t.Errorf("Bad result: %+v (expected %+v)", actual, e)
+	}
+
+	r := SearchResult{DN: "cn=a", Attributes: map[string][]string{"sn": {"b"}, "cn": {"a"}}}
+	if b, _ = json.Marshal(r); string(b) != `{"dn":"cn=a","attributes":[{"name":"cn","values":["a"]},{"name":"sn","values":["b"]}]}` {
+		t.Errorf("Bad result: %s", b)
+	}
+	var result SearchResult
+	if err := json.Unmarshal(b, &result); err != nil || !reflect.DeepEqual(result, r) {
+		t.Errorf("Bad result: %+v, %v (expected %+v)", result, err, r)
+	}
+}
+
+func TestModificationJSON(t *testing.T) {
+	mods := []Modification{
+		{ReplaceValues, Attribute{"sn", []string{"b"}}},
+		{DeleteValues, Attribute{"mail", []string{}}},
+		{IncrementValue, Attribute{"uidNumber", []string{"1"}}},
+	}
+	b, err := json.Marshal(mods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"operation":"replace","name":"sn","values":["b"]},{"operation":"delete","name":"mail","values":[]},{"operation":"increment","name":"uidNumber","values":["1"]}]`
+	if string(b) != expected {
+		t.Errorf("Bad result: %s (expected %s)", b, expected)
+	}
+	var actual []Modification
+	if err := json.Unmarshal(b, &actual); err != nil || !reflect.DeepEqual(actual, mods) {
+		t.Errorf("Bad result: %+v, %v (expected %+v)", actual, err, mods)
+	}
+	if err := json.Unmarshal([]byte(`{"operation":"frob","name":"a","values":[]}`), &actual[0]); err == nil {
+		t.Errorf("Expected error for an unknown operation")
+	}
+}
+
+func TestSearchRequestJSON(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{`{"base":"dc=example,dc=com","filter":"(uid=jdoe)","attributes":["cn","mail"]}`,
+			`{"base":"dc=example,dc=com","scope":"sub","deref":"never","filter":"(uid=jdoe)","attributes":["cn","mail"]}`},
+		{`{"base":"cn=a","scope":"base","deref":"always","sizeLimit":5,"typesOnly":true}`,
+			`{"base":"cn=a","scope":"base","deref":"always","sizeLimit":5,"typesOnly":true,"filter":"(objectClass=*)"}`},
+	}
+	for i, test := range tests {
+		var req SearchRequest
+		if err := json.Unmarshal([]byte(test.in), &req); err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		b, err := json.Marshal(req)
+		if err != nil || string(b) != test.expected {
+			t.Errorf("#%d: Bad result: %s, %v (expected %v)", i, b, err, test.expected)
+		}
+	}
+
+	for i, in := range []string{`{"scope":"all"}`, `{"deref":"sometimes"}`, `{"filter":"(uid=jdoe"}`} {
+		var req SearchRequest
+		if err := json.Unmarshal([]byte(in), &req); err == nil {
+			t.Errorf("#%d: Expected error for %s", i, in)
+		}
+	}
+}
@@ -2,20 +2,70 @@ package ldap
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/stesla/ldap/asn1"
+	"io"
+	"log/slog"
 	"net"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// A Conn is safe for concurrent use by multiple goroutines. Requests are
+// multiplexed over the connection and their responses routed by message
+// ID, so a slow search does not delay other operations; see
+// DialOpts.MaxInFlight for the limit on outstanding requests. Binds
+// change the identity of every operation on the connection, and StartTLS
+// must not run concurrently with other operations.
 type Conn interface {
 	net.Conn
 	Bind(user, password string) error
+	BindWithControls(user, password string, controls ...Control) ([]Control, error)
+	BindWithPasswordPolicy(user, password string) (*ControlPasswordPolicy, error)
+	SASLBind(mech SASLMechanism) error
+	SASLBindAny(mechs ...SASLMechanism) error
+	ExternalBind(authzID string) error
+	NTLMBind(creds NTLMCredentials) error
+	ChannelBinding(kind string) ([]byte, error)
 	Unbind() error
+	Del(dn string, controls ...Control) error
 	Search(req SearchRequest) ([]SearchResult, error)
+	GetEntry(dn string, attrs ...string) (*Entry, error)
+	Exists(dn string) (bool, error)
+	SearchWithControls(req SearchRequest, controls ...Control) (*SearchResponse, error)
+	SearchFunc(req SearchRequest, fn func(SearchResult, []Control) error, controls ...Control) ([]Control, error)
+	SearchWithHandler(req SearchRequest, h SearchHandler, controls ...Control) ([]Control, error)
+	SearchStream(req SearchRequest, attrs []string, fn func(StreamedResult) error, controls ...Control) ([]Control, error)
+	DirSync(req SearchRequest, flags int64, cookie []byte, fn func(SearchResult) error, controls ...Control) ([]byte, error)
+	PersistentSearch(req SearchRequest, psearch *ControlPersistentSearch, fn func(SearchResult, *ControlEntryChangeNotification) error) error
+	Watch(ctx context.Context, baseDN string, filter Filter) (<-chan ChangeEvent, error)
+	SearchPage(req SearchRequest, paging *ControlPaging) ([]SearchResult, error)
+	SearchWithPaging(req SearchRequest, pageSize int) ([]SearchResult, error)
+	SearchIter(req SearchRequest, pageSize int) *SearchIterator
+	SearchSorted(req SearchRequest, keys []SortKey, controls ...Control) ([]SearchResult, error)
 	StartTLS(config *tls.Config) error
+	TLS() *tls.ConnectionState
+	WhoAmI() (string, error)
+	PasswordModify(user, oldPassword, newPassword string) (string, error)
+	Extended(name string, value []byte, fn func(IntermediateResponse, []Control) error, controls ...Control) (*ExtendedResponse, error)
+	StartTransaction() ([]byte, error)
+	EndTransaction(id []byte, commit bool) error
+	Add(dn string, attrs []Attribute, controls ...Control) error
+	AddWithParents(entry *Entry, templates map[string][]Attribute, controls ...Control) error
+	Ensure(entry *Entry) (bool, error)
+	Modify(dn string, mods []Modification, controls ...Control) error
+	ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error
+	Compare(dn, attr, value string, controls ...Control) (bool, error)
+	RootDSE() (*RootDSE, error)
+	WithContext(ctx context.Context) Conn
+	SetDebug(w io.Writer)
+	SetCapture(w io.Writer)
 }
 
 func RoundRobin(addr string, dialer func(string) (Conn, error)) (Conn, error) {
@@ -34,67 +84,475 @@ func RoundRobin(addr string, dialer func(string) (Conn, error)) (Conn, error) {
 }
 
 func Dial(addr string) (Conn, error) {
-	tcp, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
-	}
-	return newConn(tcp), nil
+	return DialContext(context.Background(), addr)
+}
+
+// DialContext is like Dial, but gives up when ctx is done. The returned
+// connection is bound to context.Background(); use WithContext to bind
+// operations to a context.
+func DialContext(ctx context.Context, addr string) (Conn, error) {
+	return dial(ctx, "tcp", addr, false, DialOpts{})
 }
 
 func DialSSL(addr string, tlsConfig *tls.Config) (Conn, error) {
-	tcp, err := tls.Dial("tcp", addr, tlsConfig)
+	return DialSSLContext(context.Background(), addr, tlsConfig)
+}
+
+func DialSSLContext(ctx context.Context, addr string, tlsConfig *tls.Config) (Conn, error) {
+	return dial(ctx, "tcp", addr, true, DialOpts{TLSConfig: tlsConfig})
+}
+
+// DialUnix connects to a server listening on the Unix domain socket at
+// path.
+func DialUnix(path string) (Conn, error) {
+	return dial(context.Background(), "unix", path, false, DialOpts{})
+}
+
+// DialURL connects to the server named by an ldap://, ldaps:// or
+// ldapi:// URL. The port defaults to 389 or 636 as appropriate, and the
+// host of an ldapi:// URL is the URL-encoded path of the socket.
+// tlsConfig is only used for ldaps://.
+func DialURL(rawurl string, tlsConfig *tls.Config) (Conn, error) {
+	return DialURLContext(context.Background(), rawurl, tlsConfig)
+}
+
+func DialURLContext(ctx context.Context, rawurl string, tlsConfig *tls.Config) (Conn, error) {
+	return DialWithOpts(ctx, rawurl, DialOpts{TLSConfig: tlsConfig})
+}
+
+// parseURL returns the network and address to dial for an LDAP URL, and
+// whether the scheme calls for TLS.
+func parseURL(rawurl string) (network, addr string, secure bool, err error) {
+	if strings.HasPrefix(strings.ToLower(rawurl), "ldapi://") {
+		// net/url rejects the escaped slashes in the host part
+		host := rawurl[len("ldapi://"):]
+		if i := strings.IndexAny(host, "/?"); i >= 0 {
+			host = host[:i]
+		}
+		path, err := url.PathUnescape(host)
+		if err != nil {
+			return "", "", false, err
+		}
+		if path == "" {
+			path = "/var/run/ldapi"
+		}
+		return "unix", path, false, nil
+	}
+
+	u, err := url.Parse(rawurl)
 	if err != nil {
-		return nil, err
+		return "", "", false, err
+	}
+	switch u.Scheme {
+	case "ldap":
+		return "tcp", hostPort(u.Host, "389"), false, nil
+	case "ldaps":
+		return "tcp", hostPort(u.Host, "636"), true, nil
 	}
-	return newConn(tcp), nil
+	return "", "", false, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+}
+
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
 }
 
 func DialTLS(addr string, tlsConfig *tls.Config) (Conn, error) {
-	tcp, err := Dial(addr)
+	return DialTLSContext(context.Background(), addr, tlsConfig)
+}
+
+func DialTLSContext(ctx context.Context, addr string, tlsConfig *tls.Config) (Conn, error) {
+	conn, err := DialContext(ctx, addr)
 	if err != nil {
 		return nil, err
 	}
-	conn := newConn(tcp)
 
-	err = conn.StartTLS(tlsConfig)
+	err = conn.WithContext(ctx).StartTLS(tlsConfigFor(tlsConfig, addr))
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
 	return conn, nil
 }
 
+// A conn is a view of a session bound to a context. All the views of a
+// session share the underlying connection and its message IDs.
 type conn struct {
+	*session
+	ctx     context.Context
+	timeout time.Duration
+	// canceling is set on the connection a Cancel is sent on, which
+	// is abandoned rather than canceled in turn.
+	canceling bool
+	// stream is set on the view a SearchStream is sent on.
+	stream *streamedSearch
+}
+
+// A session owns the network connection. A reader goroutine decodes
+// incoming messages and routes them to the request waiting on their
+// message ID.
+type session struct {
 	net.Conn
 	id sequence
+
+	wmu sync.Mutex // serializes writes
+
+	mu         sync.Mutex
+	pending    map[int]*pendingRequest
+	retired    retiredIDs // requests finished before their final response
+	err        error      // why the reader stopped
+	pauseAfter int        // the reader stops after this message, for StartTLS
+	readerDone chan struct{}
+	closed     chan struct{}
+	slots      chan struct{} // one per outstanding request
+	rootDSE    *RootDSE
+	logger     *slog.Logger
+	metrics    MetricsCollector
+	tracer     Tracer
+	hashFilter bool
+	normalize  bool
+	maxQueued  int
+	strict     bool
+	intercept  []Interceptor
+	tolerated  Quirks // guarded by mu
+	detect     bool   // whether to add the server's quirks from its root DSE
+
+	dmu       sync.Mutex // serializes debug output
+	debugText io.Writer
+	capture   *pcapWriter
+	debugging int32 // accessed atomically
+	readOnly  int32 // accessed atomically
+
+	lastActive int64 // UnixNano, accessed atomically
+}
+
+// A pendingRequest queues the responses to one request. The reader never
+// waits for the queue, so that a slow consumer, such as a search handler,
+// never holds up every other request on the connection. Instead, if limit
+// is set and more responses than that are waiting, they are dropped and
+// the request is abandoned by its consumer.
+type pendingRequest struct {
+	mu       sync.Mutex
+	queue    []message
+	failed   bool
+	limit    int
+	overflow int // how many responses were queued when they were dropped
+	ready    chan struct{}
+	stream   *streamedSearch
+	final    bool // whether the final response has arrived, guarded by session.mu
+	request  int  // the tag of the request
+
+	timer   *time.Timer
+	expired chan struct{}
+
+	start   time.Time
+	entries int // search results received, for logging
+	span    Span
+
+	// The result of the final response, guarded by mu.
+	responded bool
+	result    ResultCode
+}
+
+func (p *pendingRequest) push(m message) {
+	p.mu.Lock()
+	if p.overflow > 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.queue = append(p.queue, m)
+	if p.limit > 0 && len(p.queue) > p.limit {
+		p.overflow = len(p.queue)
+		p.queue = nil
+	}
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *pendingRequest) fail() {
+	p.mu.Lock()
+	p.failed = true
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *pendingRequest) signal() {
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next queued message, if any, and whether the
+// connection has failed.
+func (p *pendingRequest) pop() (m message, ok, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.overflow > 0 {
+		return m, false, false
+	}
+	if len(p.queue) > 0 {
+		m = p.queue[0]
+		p.queue = p.queue[1:]
+		return m, true, false
+	}
+	return m, false, p.failed
+}
+
+// dropped returns how many responses were queued when the limit was
+// exceeded, or zero if it has not been.
+func (p *pendingRequest) dropped() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.overflow
+}
+
+type message struct {
+	op       asn1.RawValue
+	controls []control
+}
+
+// NewConn returns a client of the server at the other end of c, which
+// may have been dialed by other means or be one end of a net.Pipe.
+func NewConn(c net.Conn) Conn {
+	return newConn(c)
 }
 
 func newConn(tcp net.Conn) *conn {
-	return &conn{
-		Conn: tcp,
+	return newConnWithOpts(tcp, DialOpts{})
+}
+
+func newConnWithOpts(tcp net.Conn, opts DialOpts) *conn {
+	if opts.Metrics != nil {
+		tcp = &meteredConn{tcp, opts.Metrics}
+	}
+	s := &session{
+		Conn:    tcp,
+		pending: make(map[int]*pendingRequest),
+		closed:  make(chan struct{}),
+		slots:   make(chan struct{}, opts.maxInFlight()),
+		logger:  opts.Logger,
+		metrics: opts.Metrics,
+		tracer:  opts.Tracer,
+
+		hashFilter: opts.HashFilters,
+		normalize:  opts.NormalizeFilters,
+		maxQueued:  opts.MaxQueuedResponses,
+		strict:     opts.Strict,
+		intercept:  opts.Interceptors,
+		tolerated:  opts.Quirks,
+		detect:     opts.DetectQuirks,
+	}
+	if opts.ReadOnly {
+		s.readOnly = 1
+	}
+	s.touch()
+	s.startReader()
+	l := &conn{session: s, ctx: context.Background(), timeout: opts.Timeout}
+	if opts.IdleTimeout > 0 {
+		go l.keepAlive(opts.IdleTimeout)
+	}
+	return l
+}
+
+// WithContext returns a view of the connection whose operations are
+// bound to ctx: when ctx is done, operations waiting for a response
+// return ctx.Err() at once, abandoning their request in the background.
+// Operations on the view are
+// not subject to DialOpts.Timeout.
+func (l *conn) WithContext(ctx context.Context) Conn {
+	if ctx == nil {
+		panic("nil context")
+	}
+	return &conn{session: l.session, ctx: ctx}
+}
+
+func (s *session) startReader() {
+	s.pauseAfter = -1
+	s.readerDone = make(chan struct{})
+	go s.reader(s.readerDone)
+}
+
+func (s *session) reader(done chan struct{}) {
+	defer close(done)
+
+	// Whole messages are read before decoding, so that a message split
+	// across TCP segments is never decoded in part.
+	mr := asn1.NewMessageReader(s.Conn)
+	mr.Spill, mr.SpillSize = s.spill, streamSpillSize
+	dec := asn1.NewDecoder(nil)
+	dec.Implicit = true
+	for {
+		b, err := mr.ReadMessage()
+		if err != nil {
+			s.fail(fmt.Errorf("Read Envelope: %v", err))
+			return
+		}
+		s.dump(false, b)
+		s.touch()
+
+		dec.Reset(bytes.NewReader(b))
+		var raw asn1.RawValue
+		resp := ldapMessage{ProtocolOp: &raw}
+		if err := dec.Decode(&resp); err != nil {
+			s.fail(fmt.Errorf("Decode Envelope: %v", err))
+			return
+		}
+
+		if resp.MessageId == 0 {
+			if err := s.unsolicited(raw); err != nil {
+				s.fail(err)
+				return
+			}
+			continue
+		}
+		p, pause, err := s.route(resp.MessageId, raw, resp.Controls)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if p != nil {
+			raw = s.quirks().fixResult(raw)
+		}
+		s.observe(resp.MessageId, p, raw)
+		if p != nil {
+			p.push(message{raw, resp.Controls})
+		}
+		if pause {
+			return
+		}
 	}
 }
 
+// route returns the request a response with id is for, and whether the
+// reader is to stop after it. Responses nobody is waiting for are
+// dropped: quietly if they are stragglers from an abandoned request, and
+// with a warning if their ID is not in use or they follow the final
+// response to their request, as only a misbehaving server sends them.
+// With DialOpts.Strict, those and any other nonconformant response are
+// an error instead.
+func (s *session) route(id int, raw asn1.RawValue, controls []control) (*pendingRequest, bool, error) {
+	final := raw.Tag != 4 && raw.Tag != 19 && raw.Tag != 25
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pause := id == s.pauseAfter
+	if p := s.pending[id]; p != nil {
+		if p.final {
+			return nil, pause, s.violation(id, raw, "response after the final one")
+		}
+		if s.strict {
+			if err := checkResponse(id, p.request, raw, controls); err != nil {
+				return nil, pause, err
+			}
+		}
+		p.final = final
+		return p, pause, nil
+	}
+	if s.retired.has(id) {
+		if final {
+			s.retired.remove(id)
+		}
+		return nil, pause, nil
+	}
+	return nil, pause, s.violation(id, raw, "response with unknown message ID")
+}
+
+// violation returns the error for a response that is dropped, or logs
+// it and returns nil unless DialOpts.Strict is set.
+func (s *session) violation(id int, raw asn1.RawValue, reason string) error {
+	if s.strict {
+		return &ProtocolViolationError{id, reason}
+	}
+	s.warn("ldap: dropping "+reason, id, raw)
+	return nil
+}
+
+const oidNoticeOfDisconnection = "1.3.6.1.4.1.1466.20036"
+
+// unsolicited handles an unsolicited notification (RFC 4511 §4.4). A
+// notice of disconnection returns the error the connection fails with;
+// other notifications are ignored.
+func (s *session) unsolicited(raw asn1.RawValue) error {
+	if raw.Tag != 24 {
+		return s.violation(0, raw, "unsolicited response that is not a notification")
+	}
+	var r extendedResponse
+	if err := decodeOp(raw, "application,tag:24", &r); err != nil || string(r.Name) != oidNoticeOfDisconnection {
+		return nil
+	}
+	err := r.Result.err()
+	if err == nil {
+		err = fmt.Errorf("no reason given")
+	}
+	return fmt.Errorf("ldap: server disconnected: %v", err)
+}
+
+func (s *session) warn(msg string, id int, raw asn1.RawValue) {
+	if s.logger == nil {
+		return
+	}
+	s.logger.LogAttrs(context.Background(), slog.LevelWarn, msg,
+		slog.Int("msgid", id),
+		slog.String("op", opNames[raw.Tag]),
+	)
+}
+
+// fail wakes up every pending request once the connection is unusable.
+func (s *session) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	close(s.closed)
+	for _, p := range s.pending {
+		p.fail()
+	}
+}
+
+func (s *session) touch() {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+}
+
+func (s *session) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive)))
+}
+
+// The encoders and decoders of the protocol's messages are generated.
+//go:generate go run ./cmd/asn1gen -type ldapMessage,control,ldapResult,bindRequest,saslCredentials,bindResponse,partialAttribute,addRequest,change,modifyRequest,modifyDNRequest,compareRequest,searchResultEntry,intermediateResponse,extendedRequest,extendedResponse
+
 type ldapMessage struct {
 	MessageId  int
 	ProtocolOp interface{}
-	Controls   []interface{} `asn1:"tag:0,optional"`
+	Controls   []control `asn1:"tag:0,optional"`
 }
 
-type ldapResultCode int16
-
-const (
-	Success                     ldapResultCode = 0
-	InappropriateAuthentication ldapResultCode = 48
-	InvalidCredentials          ldapResultCode = 49
-	InsufficientAccessRights    ldapResultCode = 59
-)
-
 type ldapResult struct {
-	ResultCode ldapResultCode
+	ResultCode ResultCode `asn1:"enum"`
 	MatchedDN  []byte
 	Message    []byte
-	Referral   []interface{} `asn1:"tag:3,optional"`
+	Referral   [][]byte `asn1:"tag:3,optional"`
+}
+
+func (r *ldapResult) toError() *Error {
+	e := &Error{
+		ResultCode:        r.ResultCode,
+		MatchedDN:         string(r.MatchedDN),
+		DiagnosticMessage: string(r.Message),
+	}
+	for _, url := range r.Referral {
+		e.Referrals = append(e.Referrals, string(url))
+	}
+	return e
+}
+
+// err returns nil if the operation succeeded.
+func (r *ldapResult) err() error {
+	if r.ResultCode == Success {
+		return nil
+	}
+	return r.toError()
 }
 
 type bindRequest struct {
@@ -103,79 +561,352 @@ type bindRequest struct {
 	Auth    interface{}
 }
 
-func (l *conn) Bind(user, password string) (err error) {
-	msg := ldapMessage{
-		MessageId: l.id.Next(),
-		ProtocolOp: asn1.OptionValue{
-			Opts: "application,tag:0", Value: bindRequest{
-				Version: 3,
-				Name:    []byte(user),
-				// TODO: Support SASL
-				Auth: simpleAuth(password),
-			},
+func (l *conn) Bind(user, password string) error {
+	_, err := l.BindWithControls(user, password)
+	return err
+}
+
+// BindWithControls performs a simple bind with the given request
+// controls. The response controls are returned even when the bind
+// fails, since servers often explain the failure through them.
+func (l *conn) BindWithControls(user, password string, controls ...Control) ([]Control, error) {
+	op := asn1.OptionValue{
+		Opts: "application,tag:0", Value: bindRequest{
+			Version: 3,
+			Name:    []byte(user),
+			Auth:    simpleAuth(password),
 		},
 	}
+	ctrls, err := l.request(op, "application,tag:1", controls)
+	if err != nil {
+		return ctrls, fmt.Errorf("ldap.Bind unsuccessful: %w", err)
+	}
+	return ctrls, nil
+}
 
-	enc := asn1.NewEncoder(l)
-	enc.Implicit = true
-	if err = enc.Encode(msg); err != nil {
-		return fmt.Errorf("Encode: %v", err)
+func simpleAuth(password string) interface{} {
+	return asn1.OptionValue{Opts: "tag:0", Value: []byte(password)}
+}
+
+func (l *conn) Unbind() error {
+	defer l.Close()
+
+	op := asn1.OptionValue{Opts: "application,tag:2", Value: asn1.RawValue{
+		Class: asn1.ClassUniversal,
+		Tag:   asn1.TagNull,
+	},
 	}
+	return l.notify(op)
+}
+
+// Del removes the entry named by dn. Servers that support it will
+// delete an entire subtree when ControlSubtreeDelete is supplied.
+func (l *conn) Del(dn string, controls ...Control) error {
+	op := asn1.OptionValue{Opts: "application,tag:10", Value: []byte(dn)}
+	_, err := l.request(op, "application,tag:11", controls)
+	return err
+}
 
-	var result ldapResult
-	msg = ldapMessage{
-		ProtocolOp: asn1.OptionValue{Opts: "application,tag:1", Value: &result},
+// request sends op and decodes an LDAPResult-shaped response tagged with
+// respOpts. The response controls are returned alongside any error
+// reported by the server.
+func (l *conn) request(op interface{}, respOpts string, controls []Control) ([]Control, error) {
+	raw, respControls, err := l.roundTrip(op, controls)
+	if err != nil {
+		return nil, err
 	}
-	dec := asn1.NewDecoder(l)
-	dec.Implicit = true
-	if err = dec.Decode(&msg); err != nil {
-		return fmt.Errorf("Decode: %v", err)
+
+	var r ldapResult
+	if err := decodeOp(raw, respOpts, &r); err != nil {
+		return nil, fmt.Errorf("Decode: %v", err)
 	}
 
-	if result.ResultCode != Success {
-		return fmt.Errorf("ldap.Bind unsuccessful: resultCode = %v", result.ResultCode)
+	if err := r.err(); err != nil {
+		return respControls, err
 	}
-	return nil
+	return respControls, nil
 }
 
-func simpleAuth(password string) interface{} {
-	return asn1.OptionValue{Opts: "tag:0", Value: []byte(password)}
+// roundTrip sends op and returns the single response message.
+func (l *conn) roundTrip(op interface{}, controls []Control) (asn1.RawValue, []Control, error) {
+	return l.exchange(op, controls, func(l *conn, op interface{}, controls []Control) (asn1.RawValue, []Control, error) {
+		id, err := l.send(op, controls)
+		if err != nil {
+			return asn1.RawValue{}, nil, err
+		}
+		defer l.finish(id)
+		return l.receive(id)
+	})
 }
 
-func (l *conn) Unbind() error {
-	defer l.Close()
+// send wraps op in an LDAPMessage with a fresh message ID and writes it
+// to the connection. The caller must call finish once it has received
+// the last response.
+func (l *conn) send(op interface{}, controls []Control) (int, error) {
+	id, err := l.register(op)
+	if err != nil {
+		return 0, err
+	}
+	l.startSpan(id, op)
+	if err := l.write(id, op, controls); err != nil {
+		l.finish(id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// notify sends op, which has no response.
+func (l *conn) notify(op interface{}) error {
+	l.mu.Lock()
+	id := l.nextID()
+	l.mu.Unlock()
+	return l.write(id, op, nil)
+}
+
+// register allocates a message ID for the request op, waiting while
+// DialOpts.MaxInFlight requests are outstanding.
+func (l *conn) register(op interface{}) (int, error) {
+	p := &pendingRequest{ready: make(chan struct{}, 1), limit: l.maxQueued, stream: l.stream, request: opTag(op)}
+	if l.timeout > 0 {
+		p.expired = make(chan struct{})
+		p.timer = time.AfterFunc(l.timeout, func() { close(p.expired) })
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.ctx.Done():
+		p.stop()
+		return 0, l.ctx.Err()
+	case <-p.expired:
+		return 0, ErrTimeout
+	case <-l.closed:
+		p.stop()
+		return 0, l.failure()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		<-l.slots
+		p.stop()
+		return 0, l.err
+	}
+	id := l.nextID()
+	p.start = time.Now()
+	l.pending[id] = p
+	return id, nil
+}
+
+func (p *pendingRequest) stop() {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
+
+func (l *conn) finish(id int) {
+	l.mu.Lock()
+	p, ok := l.pending[id]
+	if ok {
+		p.stop()
+		delete(l.pending, id)
+		<-l.slots
+		if !p.final {
+			l.retired.add(id)
+		}
+	}
+	failure := l.err
+	l.mu.Unlock()
+	if ok {
+		p.endSpan(l, failure)
+	}
+}
 
+func (s *session) failure() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (l *conn) write(id int, op interface{}, controls []Control) error {
+	ctrls, err := encodeControls(controls)
+	if err != nil {
+		return err
+	}
 	msg := ldapMessage{
-		MessageId: l.id.Next(),
-		ProtocolOp: asn1.OptionValue{Opts: "application,tag:2", Value: asn1.RawValue{
-			Class: asn1.ClassUniversal,
-			Tag:   asn1.TagNull,
-		},
-		},
+		MessageId:  id,
+		ProtocolOp: op,
+		Controls:   ctrls,
 	}
 
-	enc := asn1.NewEncoder(l)
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	deadline, ok := l.ctx.Deadline()
+	if !ok && l.timeout > 0 {
+		deadline, ok = time.Now().Add(l.timeout), true
+	}
+	if ok {
+		l.SetWriteDeadline(deadline)
+		defer l.SetWriteDeadline(time.Time{})
+	}
+	l.touch()
+	l.logRequest(id, op, controls)
+	var buf bytes.Buffer
+	enc := asn1.NewEncoder(&buf)
 	enc.Implicit = true
 	if err := enc.Encode(msg); err != nil {
 		return fmt.Errorf("Encode: %v", err)
 	}
+	l.dump(true, buf.Bytes())
+	_, err = l.Conn.Write(buf.Bytes())
+	return err
+}
 
-	return nil
+// receive waits for the next message addressed to id. If the context is
+// done first, the request is abandoned.
+func (l *conn) receive(id int) (asn1.RawValue, []Control, error) {
+	l.mu.Lock()
+	p := l.pending[id]
+	l.mu.Unlock()
+	if p == nil {
+		return asn1.RawValue{}, nil, fmt.Errorf("no request with message ID %d", id)
+	}
+
+	for {
+		m, ok, failed := p.pop()
+		if ok {
+			ctrls, err := decodeControls(m.controls)
+			if err != nil && l.quirks().ValuelessControls {
+				ctrls, err = decodeValuelessControls(m.controls)
+			}
+			return m.op, ctrls, err
+		}
+		if failed {
+			return asn1.RawValue{}, nil, l.failure()
+		}
+		if n := p.dropped(); n > 0 {
+			l.finish(id)
+			l.abandon(id)
+			return asn1.RawValue{}, nil, &SlowConsumerError{Queued: n}
+		}
+
+		// The caller has given up, so it does not wait for the request
+		// to be stopped.
+		select {
+		case <-p.ready:
+		case <-l.ctx.Done():
+			l.finish(id)
+			go l.abandon(id)
+			return asn1.RawValue{}, nil, l.ctx.Err()
+		case <-p.expired:
+			l.finish(id)
+			go l.abandon(id)
+			return asn1.RawValue{}, nil, ErrTimeout
+		}
+	}
+}
+
+func decodeOp(raw asn1.RawValue, opts string, out interface{}) error {
+	return decodeValue(raw.RawBytes, asn1.OptionValue{Opts: opts, Value: out})
+}
+
+// abandonTimeout bounds the wait for the response to a Cancel sent by
+// abandon on a connection without a timeout of its own.
+var abandonTimeout = 5 * time.Second
+
+// abandon stops the request with id. If the server supports the Cancel
+// operation it is used, so that the server has stopped when abandon
+// returns, unless it fails to confirm that within the connection's
+// timeout or abandonTimeout; otherwise an Abandon is sent.
+func (l *conn) abandon(id int) error {
+	// The abandon is usually sent because l.ctx is done, so it must not
+	// inherit its deadline.
+	bg := &conn{session: l.session, ctx: context.Background(), timeout: l.timeout}
+	if bg.timeout == 0 {
+		bg.timeout = abandonTimeout
+	}
+	if dse := l.cachedRootDSE(); dse != nil && dse.SupportsExtension(oidCancel) && !l.canceling {
+		bg.canceling = true
+		return bg.cancel(id)
+	}
+	return bg.notify(asn1.OptionValue{Opts: "application,tag:16", Value: id})
+}
+
+// cancel sends the Cancel operation for the request with id (RFC 3909)
+// and waits for the server to confirm that it has stopped. The canceled
+// request's own response, canceled, has been discarded by then.
+func (l *conn) cancel(id int) error {
+	value, err := encodeValue(struct{ CancelID int }{id})
+	if err != nil {
+		return err
+	}
+	_, err = l.extended(oidCancel, value)
+	return err
 }
 
+// maxMessageID is the largest message ID, maxInt in RFC 4511 §4.1.1.
+const maxMessageID = 1<<31 - 1
+
 type sequence struct {
 	next int
 	l    sync.Mutex
 }
 
+// Next returns the next message ID, counting from 1 to maxMessageID and
+// then from 1 again. Zero is reserved for unsolicited notifications.
 func (gen *sequence) Next() (id int) {
 	gen.l.Lock()
 	defer gen.l.Unlock()
+	if gen.next < 1 || gen.next > maxMessageID {
+		gen.next = 1
+	}
 	id = gen.next
 	gen.next++
 	return
 }
 
+// nextID returns the next message ID that is not in use, by a request
+// still pending or by one whose stragglers may yet arrive. s.mu must be
+// held.
+func (s *session) nextID() int {
+	for {
+		id := s.id.Next()
+		if s.pending[id] == nil && !s.retired.has(id) {
+			return id
+		}
+	}
+}
+
+// maxRetiredIDs bounds the IDs of finished requests that are kept from
+// reuse, since the server sends nothing more for an abandoned request.
+const maxRetiredIDs = 1024
+
+// retiredIDs is the set of IDs of requests that were finished before
+// their final response arrived, oldest first.
+type retiredIDs struct {
+	ids   map[int]bool
+	order []int
+}
+
+func (r *retiredIDs) add(id int) {
+	if r.ids == nil {
+		r.ids = make(map[int]bool)
+	}
+	r.ids[id] = true
+	r.order = append(r.order, id)
+	if len(r.order) > maxRetiredIDs {
+		delete(r.ids, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+func (r *retiredIDs) has(id int) bool {
+	return r.ids[id]
+}
+
+func (r *retiredIDs) remove(id int) {
+	delete(r.ids, id)
+}
+
 type SearchRequest struct {
 	BaseObject []byte
 	Scope      SearchScope  `asn1:"enum"`
@@ -209,43 +940,176 @@ type SearchResult struct {
 	Attributes map[string][]string
 }
 
+type SearchResponse struct {
+	Results  []SearchResult
+	Controls []Control
+}
+
 func (l *conn) Search(req SearchRequest) ([]SearchResult, error) {
-	msg := ldapMessage{
-		MessageId:  l.id.Next(),
-		ProtocolOp: asn1.OptionValue{Opts: "application,tag:3", Value: req},
+	resp, err := l.SearchWithControls(req)
+	if err != nil {
+		return nil, err
 	}
+	return resp.Results, nil
+}
 
-	enc := asn1.NewEncoder(l)
-	enc.Implicit = true
-	if err := enc.Encode(msg); err != nil {
-		return nil, fmt.Errorf("Encode: %v", err)
+// GetEntry reads the entry named by dn, with the given attributes or,
+// if there are none, all user attributes. It fails with ErrNotFound if
+// there is no such entry or it cannot be read, and with
+// ErrTooManyEntries if the server returns more than one.
+func (l *conn) GetEntry(dn string, attrs ...string) (*Entry, error) {
+	return getEntry(l, dn, attrs)
+}
+
+func getEntry(c Conn, dn string, attrs []string) (*Entry, error) {
+	results, err := c.Search(SearchRequest{
+		BaseObject: []byte(dn),
+		Scope:      BaseObject,
+		Filter:     Present("objectClass"),
+		Attributes: AttributeList(attrs...),
+	})
+	switch {
+	case err != nil:
+		return nil, err
+	case len(results) == 0:
+		return nil, ErrNotFound
+	case len(results) > 1:
+		return nil, ErrTooManyEntries
 	}
+	return results[0].Entry(), nil
+}
 
-	dec := asn1.NewDecoder(l)
-	dec.Implicit = true
+// Exists reports whether the entry named by dn exists and can be read.
+func (l *conn) Exists(dn string) (bool, error) {
+	return exists(l, dn)
+}
+
+func exists(c Conn, dn string) (bool, error) {
+	_, err := getEntry(c, dn, []string{"1.1"})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *conn) SearchWithControls(req SearchRequest, controls ...Control) (*SearchResponse, error) {
+	resp := &SearchResponse{Results: []SearchResult{}}
+	ctrls, err := l.search(req, controls, searchFunc(func(result SearchResult, _ []Control) error {
+		resp.Results = append(resp.Results, result)
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	resp.Controls = ctrls
+	return resp, nil
+}
+
+// A SearchHandler receives the messages of a search as they arrive.
+type SearchHandler interface {
+	Entry(result SearchResult, controls []Control) error
+	Intermediate(resp IntermediateResponse, controls []Control) error
+}
+
+// IntermediateResponse carries the content of an IntermediateResponse
+// message (RFC 4511 §4.13) sent in the course of an operation. Decoded
+// holds the value as decoded by the decoder registered for Name with
+// RegisterIntermediate, if any.
+type IntermediateResponse struct {
+	Name    string
+	Value   []byte
+	Decoded interface{}
+}
+
+type intermediateResponse struct {
+	Name  []byte `asn1:"tag:0,optional"`
+	Value []byte `asn1:"tag:1,optional"`
+}
+
+type searchResultEntry struct {
+	Name       []byte
+	Attributes []partialAttribute
+}
+
+type searchFunc func(SearchResult, []Control) error
+
+func (fn searchFunc) Entry(result SearchResult, controls []Control) error {
+	return fn(result, controls)
+}
+
+func (fn searchFunc) Intermediate(IntermediateResponse, []Control) error {
+	return nil
+}
+
+// SearchFunc performs req and calls fn for each entry as it arrives,
+// together with the controls attached to it, instead of collecting the
+// results in memory. If fn returns an error the search is abandoned and
+// the error is returned.
+func (l *conn) SearchFunc(req SearchRequest, fn func(SearchResult, []Control) error, controls ...Control) ([]Control, error) {
+	return l.search(req, controls, searchFunc(fn))
+}
+
+// SearchWithHandler is like SearchFunc, but also delivers intermediate
+// responses to h.
+func (l *conn) SearchWithHandler(req SearchRequest, h SearchHandler, controls ...Control) ([]Control, error) {
+	return l.search(req, controls, h)
+}
+
+// search sends req and passes the messages the server returns to h. It
+// returns the controls attached to the SearchResultDone message. If h
+// returns an error the search is abandoned.
+func (l *conn) search(req SearchRequest, controls []Control, h SearchHandler) ([]Control, error) {
+	if l.normalize {
+		req.Filter = NormalizeFilter(req.Filter)
+	}
+	op := asn1.OptionValue{Opts: "application,tag:3", Value: req}
+	raw, respControls, err := l.exchange(op, controls, func(l *conn, op interface{}, controls []Control) (asn1.RawValue, []Control, error) {
+		return l.searchResults(op, controls, h)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var r ldapResult
+	if err := decodeOp(raw, "application,tag:5", &r); err != nil {
+		return nil, fmt.Errorf("Decode SearchResultDone: %v", err)
+	}
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+	return respControls, nil
+}
 
-	results := []SearchResult{}
+// searchResults sends the search op, passes its entries and intermediate
+// responses to h, and returns the SearchResultDone.
+func (l *conn) searchResults(op interface{}, controls []Control, h SearchHandler) (asn1.RawValue, []Control, error) {
+	id, err := l.send(op, controls)
+	if err != nil {
+		return asn1.RawValue{}, nil, err
+	}
+	defer l.finish(id)
 
-loop:
 	for {
-		var raw asn1.RawValue
-		resp := ldapMessage{ProtocolOp: &raw}
-		if err := dec.Decode(&resp); err != nil {
-			return nil, fmt.Errorf("Decode Envelope: %v", err)
+		raw, respControls, err := l.receive(id)
+		if err != nil {
+			return asn1.RawValue{}, nil, err
 		}
-		rdec := asn1.NewDecoder(bytes.NewBuffer(raw.RawBytes))
-		rdec.Implicit = true
 		switch raw.Tag {
 		case 4:
-			var r struct {
-				Name       []byte
-				Attributes []struct {
-					Type   []byte
-					Values [][]byte `asn1:"set"`
+			if l.stream != nil {
+				result, err := l.stream.result(raw)
+				if err != nil {
+					return asn1.RawValue{}, nil, err
 				}
+				if err := l.stream.fn(result); err != nil {
+					l.finish(id)
+					l.abandon(id)
+					return asn1.RawValue{}, nil, err
+				}
+				continue
 			}
-			if err := rdec.Decode(asn1.OptionValue{Opts: "application,tag:4", Value: &r}); err != nil {
-				return nil, fmt.Errorf("Decode SearchResult: %v", err)
+			var r searchResultEntry
+			if err := decodeOp(raw, "application,tag:4", &r); err != nil {
+				return asn1.RawValue{}, nil, fmt.Errorf("Decode SearchResult: %v", err)
 			}
 			result := SearchResult{string(r.Name), make(map[string][]string)}
 			for _, a := range r.Attributes {
@@ -255,22 +1119,27 @@ loop:
 				}
 				result.Attributes[string(a.Type)] = vals
 			}
-			results = append(results, result)
-		case 5: // SearchResultDone
-			var r ldapResult
-			if err := rdec.Decode(asn1.OptionValue{Opts: "application,tag:5", Value: &r}); err != nil {
-				return nil, fmt.Errorf("Decode SearchResultDone: %v", err)
-			}
-			if r.ResultCode != Success {
-				return nil, fmt.Errorf("ResultCode = %d", r.ResultCode)
+			if err := h.Entry(result, respControls); err != nil {
+				l.finish(id)
+				l.abandon(id)
+				return asn1.RawValue{}, nil, err
 			}
-			break loop
+		case 5: // SearchResultDone
+			return raw, respControls, nil
 		case 19: // SearchResultReference
 			// TODO
+		case 25: // IntermediateResponse
+			r, err := decodeIntermediate(raw)
+			if err != nil {
+				return asn1.RawValue{}, nil, err
+			}
+			if err := h.Intermediate(r, respControls); err != nil {
+				l.finish(id)
+				l.abandon(id)
+				return asn1.RawValue{}, nil, err
+			}
 		}
 	}
-
-	return results, nil
 }
 
 type extendedRequest struct {
@@ -284,32 +1153,193 @@ type extendedResponse struct {
 	Value  []byte     `asn1:"tag:11,optional"`
 }
 
-func (l *conn) StartTLS(config *tls.Config) error {
-	msg := ldapMessage{
-		MessageId:  l.id.Next(),
-		ProtocolOp: asn1.OptionValue{Opts: "application,tag:23", Value: extendedRequest{Name: []byte("1.3.6.1.4.1.1466.20037")}},
+const (
+	oidStartTLS       = "1.3.6.1.4.1.1466.20037"
+	oidWhoAmI         = "1.3.6.1.4.1.4203.1.11.3"
+	oidPasswordModify = "1.3.6.1.4.1.4203.1.11.1"
+	oidCancel         = "1.3.6.1.1.8"
+)
+
+func (l *conn) extended(name string, value []byte) (*extendedResponse, error) {
+	r, _, err := l.extendedFunc(name, value, nil, nil)
+	return r, err
+}
+
+// ExtendedResponse is the response to an extended operation.
+type ExtendedResponse struct {
+	Name     string
+	Value    []byte
+	Controls []Control
+}
+
+// Extended performs the extended operation name with the request value
+// value, which may be nil (RFC 4511 §4.12). If fn is not nil it is called
+// with each intermediate response the server sends before the final one;
+// if it returns an error the operation is abandoned and the error is
+// returned.
+func (l *conn) Extended(name string, value []byte, fn func(IntermediateResponse, []Control) error, controls ...Control) (*ExtendedResponse, error) {
+	r, ctrls, err := l.extendedFunc(name, value, fn, controls)
+	if err != nil {
+		return nil, err
 	}
+	return &ExtendedResponse{string(r.Name), r.Value, ctrls}, nil
+}
 
-	enc := asn1.NewEncoder(l)
-	enc.Implicit = true
-	if err := enc.Encode(msg); err != nil {
-		return fmt.Errorf("Encode: %v", err)
+// extendedFunc performs an extended operation, passing intermediate
+// responses to fn, or discarding them if it is nil.
+func (l *conn) extendedFunc(name string, value []byte, fn func(IntermediateResponse, []Control) error, controls []Control) (*extendedResponse, []Control, error) {
+	op := asn1.OptionValue{Opts: "application,tag:23", Value: extendedRequest{Name: []byte(name), Value: value}}
+	raw, respControls, err := l.exchange(op, controls, func(l *conn, op interface{}, controls []Control) (asn1.RawValue, []Control, error) {
+		return l.extendedResults(op, controls, fn)
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	r, err := decodeExtendedResponse(raw)
+	return r, respControls, err
+}
 
+// extendedResults sends the extended operation op, passes its
+// intermediate responses to fn, and returns the ExtendedResponse.
+func (l *conn) extendedResults(op interface{}, controls []Control, fn func(IntermediateResponse, []Control) error) (asn1.RawValue, []Control, error) {
+	id, err := l.send(op, controls)
+	if err != nil {
+		return asn1.RawValue{}, nil, err
+	}
+	defer l.finish(id)
+
+	for {
+		raw, respControls, err := l.receive(id)
+		if err != nil {
+			return asn1.RawValue{}, nil, err
+		}
+		if raw.Tag != 25 {
+			return raw, respControls, nil
+		}
+		resp, err := decodeIntermediate(raw)
+		if err != nil {
+			return asn1.RawValue{}, nil, err
+		}
+		if fn == nil {
+			continue
+		}
+		if err := fn(resp, respControls); err != nil {
+			l.finish(id)
+			l.abandon(id)
+			return asn1.RawValue{}, nil, err
+		}
+	}
+}
+
+func decodeExtendedResponse(raw asn1.RawValue) (*extendedResponse, error) {
 	var r extendedResponse
-	resp := ldapMessage{ProtocolOp: asn1.OptionValue{Opts: "application,tag:24", Value: &r}}
+	if err := decodeOp(raw, "application,tag:24", &r); err != nil {
+		return nil, fmt.Errorf("Decode: %v", err)
+	}
 
-	dec := asn1.NewDecoder(l)
-	dec.Implicit = true
+	if err := r.Result.err(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
 
-	if err := dec.Decode(&resp); err != nil {
-		return fmt.Errorf("Decode: %v", err)
+// StartTLS upgrades the connection to TLS (RFC 4511 §4.14). There must
+// be no other operations outstanding. If the context is done before the
+// server responds the connection is closed, since its state is unknown.
+func (l *conn) StartTLS(config *tls.Config) error {
+	op := asn1.OptionValue{Opts: "application,tag:23", Value: extendedRequest{Name: []byte(oidStartTLS)}}
+	id, err := l.register(op)
+	if err != nil {
+		return err
 	}
+	defer l.finish(id)
+
+	// Stop the reader after the response so that it does not consume
+	// the server's half of the handshake.
+	l.mu.Lock()
+	l.pauseAfter = id
+	l.mu.Unlock()
 
-	if r.Result.ResultCode != Success {
-		return fmt.Errorf("ResultCode = %d", r.Result.ResultCode)
+	if err := l.write(id, op, nil); err != nil {
+		l.Close()
+		return err
+	}
+	raw, _, err := l.receive(id)
+	if err != nil {
+		l.Close()
+		return err
 	}
+	<-l.readerDone
 
-	l.Conn = tls.Client(l.Conn, config)
+	if _, err := decodeExtendedResponse(raw); err != nil {
+		l.startReader()
+		return err
+	}
+
+	tc := tls.Client(l.Conn, config)
+	if err := tc.HandshakeContext(l.ctx); err != nil {
+		l.Close()
+		return err
+	}
+	l.Conn = tc
+	l.mu.Lock()
+	l.rootDSE = nil
+	l.mu.Unlock()
+	l.startReader()
 	return nil
 }
+
+// TLS returns the state of the connection's TLS session, performing the
+// handshake first if it has not happened yet. A connection has one if it
+// was dialed with TLS or upgraded by StartTLS; otherwise TLS returns
+// nil.
+func (l *conn) TLS() *tls.ConnectionState {
+	tc, ok := l.Conn.(*tls.Conn)
+	if !ok || tc.HandshakeContext(l.ctx) != nil {
+		return nil
+	}
+	state := tc.ConnectionState()
+	return &state
+}
+
+// WhoAmI returns the authorization identity the server associates with
+// the connection (RFC 4532), typically of the form "dn:<dn>" or
+// "u:<userid>". Anonymous connections yield an empty string.
+func (l *conn) WhoAmI() (string, error) {
+	r, err := l.extended(oidWhoAmI, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(r.Value), nil
+}
+
+type passwordModifyRequest struct {
+	UserIdentity []byte `asn1:"tag:0,optional"`
+	OldPassword  []byte `asn1:"tag:1,optional"`
+	NewPassword  []byte `asn1:"tag:2,optional"`
+}
+
+// PasswordModify changes the password of user, by default the one the
+// connection is bound as, with the Password Modify operation (RFC 3062).
+// oldPassword may be required by the server. If newPassword is empty the
+// server generates one, which is returned.
+func (l *conn) PasswordModify(user, oldPassword, newPassword string) (string, error) {
+	value, err := encodeValue(passwordModifyRequest{
+		optionalBytes(user), optionalBytes(oldPassword), optionalBytes(newPassword)})
+	if err != nil {
+		return "", err
+	}
+	r, err := l.extended(oidPasswordModify, value)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		GenPassword []byte `asn1:"tag:0,optional"`
+	}
+	if len(r.Value) > 0 {
+		if err := decodeValue(r.Value, &resp); err != nil {
+			return "", fmt.Errorf("ldap: invalid password modify response: %v", err)
+		}
+	}
+	return string(resp.GenPassword), nil
+}
@@ -0,0 +1,652 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stesla/ldap/asn1"
+	"log/slog"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMessage struct {
+	MessageId int
+	Op        asn1.RawValue
+}
+
+func readTestMessage(c net.Conn) (testMessage, error) {
+	dec := asn1.NewDecoder(c)
+	dec.Implicit = true
+	var m testMessage
+	err := dec.Decode(&m)
+	return m, err
+}
+
+func writeTestMessage(c net.Conn, id int, opts string, op interface{}) error {
+	enc := asn1.NewEncoder(c)
+	enc.Implicit = true
+	return enc.Encode(ldapMessage{MessageId: id, ProtocolOp: asn1.OptionValue{Opts: opts, Value: op}})
+}
+
+// A controlMessage is a message with the controls sent with it.
+type controlMessage struct {
+	MessageId int
+	Op        asn1.RawValue
+	Controls  []control `asn1:"tag:0,optional"`
+}
+
+func readControlMessage(c net.Conn) (controlMessage, error) {
+	dec := asn1.NewDecoder(c)
+	dec.Implicit = true
+	var m controlMessage
+	err := dec.Decode(&m)
+	return m, err
+}
+
+func writeControlMessage(c net.Conn, id int, opts string, op interface{}, controls ...Control) error {
+	msg := ldapMessage{MessageId: id, ProtocolOp: asn1.OptionValue{Opts: opts, Value: op}}
+	for _, ctl := range controls {
+		value, err := ctl.ControlValue()
+		if err != nil {
+			return err
+		}
+		msg.Controls = append(msg.Controls, control{Type: []byte(ctl.ControlType()), Value: value})
+	}
+	enc := asn1.NewEncoder(c)
+	enc.Implicit = true
+	return enc.Encode(msg)
+}
+
+func TestContextCancelAbandons(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := c.WithContext(ctx).Search(SearchRequest{Filter: Present("objectClass")})
+		errc <- err
+	}()
+
+	search, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search.Op.Tag != 3 {
+		t.Fatalf("Bad request tag: %d (expected 3)", search.Op.Tag)
+	}
+	cancel()
+
+	abandon, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if abandon.Op.Tag != 16 {
+		t.Errorf("Bad request tag: %d (expected 16)", abandon.Op.Tag)
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Bad result: %v (expected %v)", err, context.Canceled)
+	}
+}
+
+func TestContextCancelCancels(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+	c.rootDSE = &RootDSE{SupportedExtension: []string{oidCancel}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := c.WithContext(ctx).Search(SearchRequest{Filter: Present("objectClass")})
+		errc <- err
+	}()
+
+	search, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	m, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req extendedRequest
+	if err := decodeOp(m.Op, "application,tag:23", &req); err != nil {
+		t.Fatal(err)
+	}
+	var value struct{ CancelID int }
+	if err := decodeValue(req.Value, &value); err != nil {
+		t.Fatal(err)
+	}
+	if string(req.Name) != oidCancel || value.CancelID != search.MessageId {
+		t.Errorf("Bad request: %s %d (expected %s %d)", req.Name, value.CancelID, oidCancel, search.MessageId)
+	}
+
+	// The search returns without waiting for the cancel to be
+	// confirmed.
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("Bad result: %v (expected %v)", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Search waited for the cancel response")
+	}
+	writeTestMessage(server, search.MessageId, "application,tag:5", ldapResult{ResultCode: Canceled, MatchedDN: []byte{}, Message: []byte{}})
+	writeTestMessage(server, m.MessageId, "application,tag:24", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+}
+
+func TestAbandonTimeout(t *testing.T) {
+	defer func(d time.Duration) { abandonTimeout = d }(abandonTimeout)
+	abandonTimeout = 20 * time.Millisecond
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+	c.rootDSE = &RootDSE{SupportedExtension: []string{oidCancel}}
+
+	// A search stopped by its handler waits for the cancel to be
+	// confirmed, but not forever.
+	stop := errors.New("stop")
+	errc := make(chan error)
+	go func() {
+		_, err := c.SearchFunc(SearchRequest{Filter: Present("objectClass")}, func(SearchResult, []Control) error { return stop })
+		errc <- err
+	}()
+	search, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestMessage(server, search.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{}})
+	if m, err := readTestMessage(server); err != nil || m.Op.Tag != 23 {
+		t.Fatalf("Bad request: %v, %v (expected a cancel)", m.Op.Tag, err)
+	}
+	// The cancel is given up on, and abandoned in turn.
+	if m, err := readTestMessage(server); err != nil || m.Op.Tag != 16 {
+		t.Errorf("Bad request: %v, %v (expected an abandon)", m.Op.Tag, err)
+	}
+	select {
+	case err := <-errc:
+		if err != stop {
+			t.Errorf("Bad result: %v (expected %v)", err, stop)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SearchFunc waited for the cancel response")
+	}
+}
+
+func TestCancelWithMaxInFlight(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{MaxInFlight: 1})
+	defer c.Close()
+	c.rootDSE = &RootDSE{SupportedExtension: []string{oidCancel}}
+
+	// The search stopped by its handler frees its slot for the cancel.
+	stop := errors.New("stop")
+	errc := make(chan error)
+	go func() {
+		_, err := c.SearchFunc(SearchRequest{Filter: Present("objectClass")}, func(SearchResult, []Control) error { return stop })
+		errc <- err
+	}()
+	search, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestMessage(server, search.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{}})
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	m, err := readTestMessage(server)
+	if err != nil || m.Op.Tag != 23 {
+		t.Fatalf("Bad request: %v, %v (expected a cancel)", m.Op.Tag, err)
+	}
+	writeTestMessage(server, search.MessageId, "application,tag:5", ldapResult{ResultCode: Canceled, MatchedDN: []byte{}, Message: []byte{}})
+	writeTestMessage(server, m.MessageId, "application,tag:24", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	select {
+	case err := <-errc:
+		if err != stop {
+			t.Errorf("Bad result: %v (expected %v)", err, stop)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SearchFunc waited for a slot to cancel")
+	}
+}
+
+func TestResponsesRoutedByMessageId(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	// Answer both requests in reverse order, comparing true only for
+	// cn=first.
+	go func() {
+		var msgs []testMessage
+		for i := 0; i < 2; i++ {
+			m, _ := readTestMessage(server)
+			msgs = append(msgs, m)
+		}
+		for i := len(msgs) - 1; i >= 0; i-- {
+			var req compareRequest
+			decodeOp(msgs[i].Op, "application,tag:14", &req)
+			code := CompareFalse
+			if string(req.Entry) == "cn=first" {
+				code = CompareTrue
+			}
+			writeTestMessage(server, msgs[i].MessageId, "application,tag:15", ldapResult{ResultCode: code, MatchedDN: []byte{}, Message: []byte{}})
+		}
+	}()
+
+	tests := []struct {
+		dn       string
+		expected bool
+	}{
+		{"cn=first", true},
+		{"cn=second", false},
+	}
+	results := make([]chan bool, len(tests))
+	for i, test := range tests {
+		results[i] = make(chan bool, 1)
+		go func(dn string, out chan bool) {
+			ok, _ := c.Compare(dn, "cn", "first")
+			out <- ok
+		}(test.dn, results[i])
+	}
+	for i, test := range tests {
+		if ok := <-results[i]; ok != test.expected {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, ok, test.expected)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{Timeout: 20 * time.Millisecond})
+	defer c.Close()
+
+	go func() {
+		for {
+			if _, err := readTestMessage(server); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, err := c.Compare("cn=x", "cn", "x"); err != ErrTimeout {
+		t.Errorf("Bad result: %v (expected %v)", err, ErrTimeout)
+	}
+}
+
+func TestIdleKeepAlive(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{IdleTimeout: 20 * time.Millisecond})
+	defer c.Close()
+
+	m, err := readTestMessage(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Op.Tag != 3 {
+		t.Errorf("Bad request tag: %d (expected 3)", m.Op.Tag)
+	}
+}
+
+func TestSlowSearchDoesNotBlockOtherRequests(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	go func() {
+		search, _ := readTestMessage(server)
+		compare, _ := readTestMessage(server)
+		for i := 0; i < 32; i++ {
+			writeTestMessage(server, search.MessageId, "application,tag:4", struct {
+				Name       []byte
+				Attributes []partialAttribute
+			}{[]byte("cn=x"), []partialAttribute{}})
+		}
+		writeTestMessage(server, compare.MessageId, "application,tag:15", ldapResult{ResultCode: CompareTrue, MatchedDN: []byte{}, Message: []byte{}})
+		writeTestMessage(server, search.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+
+	compared := make(chan struct{})
+	entries := make(chan int, 1)
+	go func() {
+		n := 0
+		c.SearchFunc(SearchRequest{Filter: Present("objectClass")}, func(SearchResult, []Control) error {
+			<-compared
+			n++
+			return nil
+		})
+		entries <- n
+	}()
+
+	// Wait for the search to be sent first.
+	for !c.busy() {
+		time.Sleep(time.Millisecond)
+	}
+	if ok, err := c.Compare("cn=x", "cn", "x"); !ok || err != nil {
+		t.Errorf("Bad result: %v, %v (expected true, <nil>)", ok, err)
+	}
+	close(compared)
+	if n := <-entries; n != 32 {
+		t.Errorf("Bad result: %d entries (expected 32)", n)
+	}
+}
+
+func TestMaxQueuedResponses(t *testing.T) {
+	tests := []struct {
+		limit, entries int
+		queued         int
+	}{
+		{0, 8, 0},
+		{16, 8, 0},
+		{4, 8, 5},
+	}
+	for i, test := range tests {
+		client, server := net.Pipe()
+		c := newConnWithOpts(client, DialOpts{MaxQueuedResponses: test.limit})
+
+		release := make(chan struct{})
+		errc := make(chan error, 1)
+		go func() {
+			_, err := c.SearchFunc(SearchRequest{Filter: Present("objectClass")}, func(SearchResult, []Control) error {
+				<-release
+				return nil
+			})
+			errc <- err
+		}()
+
+		// The handler holds the first entry while the rest are queued.
+		search, _ := readTestMessage(server)
+		for j := 0; j < test.entries; j++ {
+			writeTestMessage(server, search.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{}})
+		}
+		writeTestMessage(server, search.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+		close(release)
+
+		var slow *SlowConsumerError
+		if test.queued == 0 {
+			if err := <-errc; err != nil {
+				t.Errorf("#%d: Unexpected error: %v", i, err)
+			}
+		} else {
+			abandon, _ := readTestMessage(server)
+			if abandon.Op.Tag != 16 {
+				t.Errorf("#%d: Bad request tag: %d (expected 16)", i, abandon.Op.Tag)
+			}
+			if err := <-errc; !errors.As(err, &slow) || slow.Queued != test.queued {
+				t.Errorf("#%d: Bad result: %v (expected %d queued)", i, err, test.queued)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestMessageIDSequence(t *testing.T) {
+	tests := []struct {
+		next     int
+		expected []int
+	}{
+		{0, []int{1, 2}},
+		{maxMessageID - 1, []int{maxMessageID - 1, maxMessageID, 1}},
+	}
+	for i, test := range tests {
+		gen := sequence{next: test.next}
+		var ids []int
+		for range test.expected {
+			ids = append(ids, gen.Next())
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, ids, test.expected)
+		}
+	}
+
+	// IDs still in use are skipped.
+	s := &session{pending: map[int]*pendingRequest{1: {}}}
+	s.retired.add(2)
+	if id := s.nextID(); id != 3 {
+		t.Errorf("Bad result: %d (expected 3)", id)
+	}
+	for i := 0; i < maxRetiredIDs; i++ {
+		s.retired.add(10 + i)
+	}
+	if s.retired.has(2) || len(s.retired.ids) != maxRetiredIDs {
+		t.Errorf("Retired IDs not bounded: %d", len(s.retired.ids))
+	}
+}
+
+func TestMisbehavingServer(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{Logger: logger})
+	defer c.Close()
+
+	compareTrue := ldapResult{ResultCode: CompareTrue, MatchedDN: []byte{}, Message: []byte{}}
+	compareFalse := ldapResult{ResultCode: CompareFalse, MatchedDN: []byte{}, Message: []byte{}}
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId+100, "application,tag:15", compareFalse)
+		writeTestMessage(server, m.MessageId, "application,tag:15", compareTrue)
+		// A second response to the same request is not mistaken for
+		// the response to the next one.
+		writeTestMessage(server, m.MessageId, "application,tag:15", compareFalse)
+		m, _ = readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:15", compareTrue)
+	}()
+	for i := 0; i < 2; i++ {
+		if ok, err := c.Compare("cn=x", "cn", "x"); !ok || err != nil {
+			t.Errorf("#%d: Bad result: %v, %v (expected true, <nil>)", i, ok, err)
+		}
+	}
+	if n := strings.Count(buf.String(), `"level":"WARN"`); n != 2 {
+		t.Errorf("Bad result: %d warnings (expected 2): %s", n, buf.String())
+	}
+
+	// Stragglers from an abandoned request are dropped quietly.
+	buf.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := c.WithContext(ctx).Search(SearchRequest{Filter: Present("objectClass")})
+		errc <- err
+	}()
+	search, _ := readTestMessage(server)
+	cancel()
+	readTestMessage(server)
+	<-errc
+	writeTestMessage(server, search.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{}})
+	writeTestMessage(server, search.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+
+	// A notice of disconnection fails the connection.
+	go func() {
+		readTestMessage(server)
+		writeTestMessage(server, 0, "application,tag:24", extendedResponse{
+			Result: ldapResult{ResultCode: Unavailable, MatchedDN: []byte{}, Message: []byte("shutting down")},
+			Name:   []byte(oidNoticeOfDisconnection),
+		})
+	}()
+	if _, err := c.Compare("cn=x", "cn", "x"); err == nil || !strings.Contains(err.Error(), "server disconnected") {
+		t.Errorf("Bad result: %v (expected disconnection)", err)
+	}
+	if strings.Contains(buf.String(), "WARN") {
+		t.Errorf("Unexpected warning: %s", buf.String())
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	const maxInFlight = 4
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{MaxInFlight: maxInFlight})
+	defer c.Close()
+
+	// Collect requests until no more arrive, then answer them all.
+	go func() {
+		for {
+			var batch []testMessage
+			for len(batch) < maxInFlight+1 {
+				server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+				m, err := readTestMessage(server)
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				} else if err != nil {
+					return
+				}
+				batch = append(batch, m)
+			}
+			if len(batch) > maxInFlight {
+				t.Errorf("Bad result: %d requests in flight (expected at most %d)", len(batch), maxInFlight)
+			}
+			server.SetReadDeadline(time.Time{})
+			for _, m := range batch {
+				if err := writeTestMessage(server, m.MessageId, "application,tag:15", ldapResult{ResultCode: CompareTrue, MatchedDN: []byte{}, Message: []byte{}}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	errs := make(chan error)
+	for i := 0; i < 3*maxInFlight; i++ {
+		go func() {
+			_, err := c.Compare("cn=x", "cn", "x")
+			errs <- err
+		}()
+	}
+	for i := 0; i < 3*maxInFlight; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("#%d: Bad result: %v (expected <nil>)", i, err)
+		}
+	}
+}
+
+func TestResultError(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:11", ldapResult{
+			ResultCode: NoSuchObject,
+			MatchedDN:  []byte("dc=example,dc=com"),
+			Message:    []byte("no such entry"),
+			Referral:   [][]byte{[]byte("ldap://other/")},
+		})
+	}()
+
+	err := c.Del("cn=missing,dc=example,dc=com")
+	expected := &Error{
+		ResultCode:        NoSuchObject,
+		MatchedDN:         "dc=example,dc=com",
+		DiagnosticMessage: "no such entry",
+		Referrals:         []string{"ldap://other/"},
+	}
+	var e *Error
+	if !errors.As(err, &e) || !reflect.DeepEqual(e, expected) {
+		t.Errorf("Bad result: %#v (expected %#v)", err, expected)
+	}
+	if !errors.Is(err, &Error{ResultCode: NoSuchObject}) {
+		t.Errorf("errors.Is(%v, NoSuchObject) = false", err)
+	}
+	if !IsErrorWithCode(err, InsufficientAccessRights, NoSuchObject) {
+		t.Errorf("IsErrorWithCode(%v, NoSuchObject) = false", err)
+	}
+	if IsErrorWithCode(err, InsufficientAccessRights) {
+		t.Errorf("IsErrorWithCode(%v, InsufficientAccessRights) = true", err)
+	}
+}
+
+func TestWhoAmI(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	tests := []struct {
+		code  ResultCode
+		value []byte
+		out   string
+	}{
+		{Success, []byte("dn:cn=x,dc=example,dc=com"), "dn:cn=x,dc=example,dc=com"},
+		{Success, []byte("u:x"), "u:x"},
+		// Anonymous connections have no authorization identity.
+		{Success, nil, ""},
+		{UnwillingToPerform, nil, ""},
+	}
+	names := make(chan string, len(tests))
+	go func() {
+		for _, test := range tests {
+			m, err := readTestMessage(server)
+			if err != nil {
+				return
+			}
+			var req extendedRequest
+			decodeOp(m.Op, "application,tag:23", &req)
+			names <- string(req.Name)
+			writeTestMessage(server, m.MessageId, "application,tag:24", extendedResponse{
+				Result: ldapResult{ResultCode: test.code, MatchedDN: []byte{}, Message: []byte{}},
+				Value:  test.value,
+			})
+		}
+	}()
+	for i, test := range tests {
+		out, err := c.WhoAmI()
+		ok := err == nil
+		if test.code != Success {
+			ok = IsErrorWithCode(err, test.code)
+		}
+		if out != test.out || !ok {
+			t.Errorf("#%d: Bad result: %q, %v (expected %q, %v)", i, out, err, test.out, test.code)
+		}
+		if name := <-names; name != oidWhoAmI {
+			t.Errorf("#%d: Bad request: %s (expected %s)", i, name, oidWhoAmI)
+		}
+	}
+}
+
+func TestDelControls(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	tests := []struct {
+		controls []Control
+		out      []control
+	}{
+		{nil, nil},
+		{[]Control{&ControlManageDsaIT{}},
+			[]control{{Type: []byte(ControlTypeManageDsaIT)}}},
+		{[]Control{&ControlSubtreeDelete{Criticality: true}},
+			[]control{{Type: []byte(ControlTypeSubtreeDelete), Criticality: true}}},
+		{[]Control{&ControlManageDsaIT{Criticality: true}, &ControlSubtreeDelete{}},
+			[]control{{Type: []byte(ControlTypeManageDsaIT), Criticality: true}, {Type: []byte(ControlTypeSubtreeDelete)}}},
+	}
+	requests := make(chan controlMessage, len(tests))
+	go func() {
+		for range tests {
+			m, err := readControlMessage(server)
+			if err != nil {
+				return
+			}
+			requests <- m
+			writeTestMessage(server, m.MessageId, "application,tag:11", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+		}
+	}()
+	for i, test := range tests {
+		if err := c.Del("ou=People,dc=example,dc=com", test.controls...); err != nil {
+			t.Errorf("#%d: Del: %v", i, err)
+			continue
+		}
+		m := <-requests
+		if m.Op.Class != asn1.ClassApplication || m.Op.Tag != 10 || string(m.Op.Bytes) != "ou=People,dc=example,dc=com" {
+			t.Errorf("#%d: Bad request: %#v", i, m.Op)
+		}
+		if !reflect.DeepEqual(m.Controls, test.out) {
+			t.Errorf("#%d: Bad result: %#v (expected %#v)", i, m.Controls, test.out)
+		}
+	}
+}
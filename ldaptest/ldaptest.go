@@ -0,0 +1,194 @@
+// Package ldaptest provides an in-memory LDAP server for tests, as
+// net/http/httptest does for HTTP.
+package ldaptest
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldif"
+	"github.com/stesla/ldap/server"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// The administrator may bind to a test server with AdminPassword, and
+// set the password of any entry. The entry need not exist.
+const (
+	AdminDN       = "cn=admin,dc=example,dc=com"
+	AdminPassword = "secret"
+)
+
+// A Server is a MemoryBackend served on a loopback port or over
+// net.Pipe, which is torn down when its test ends.
+type Server struct {
+	// Addr is the host:port of a server started with StartServer. It
+	// is empty for a server started with StartPipeServer.
+	Addr string
+	// URL is Addr as an ldap:// URL.
+	URL string
+	// Backend holds the directory, for inspecting or changing it
+	// directly.
+	Backend *server.MemoryBackend
+	// Server is the server, whose Handler wraps Backend.
+	Server *server.Server
+	// Conn is a connection bound as AdminDN.
+	Conn ldap.Conn
+
+	t     testing.TB
+	pipe  bool
+	mu    sync.Mutex
+	conns []ldap.Conn
+	done  chan error
+}
+
+// StartServer starts a server on a random loopback port and loads
+// fixtures, the names of LDIF files, into it. Their content records are
+// added, without their superiors needing to exist, and their change
+// records applied, in order. The server and its
+// connections are closed when the test ends.
+func StartServer(t testing.TB, fixtures ...string) *Server {
+	t.Helper()
+	s := newServer(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ldaptest: %v", err)
+	}
+	s.Addr = l.Addr().String()
+	s.URL = "ldap://" + s.Addr
+	s.done = make(chan error, 1)
+	go func() { s.done <- s.Server.Serve(l) }()
+	s.start(fixtures)
+	return s
+}
+
+// StartPipeServer is like StartServer, but serves each connection over
+// a net.Pipe instead of the network.
+func StartPipeServer(t testing.TB, fixtures ...string) *Server {
+	t.Helper()
+	s := newServer(t)
+	s.pipe = true
+	s.start(fixtures)
+	return s
+}
+
+func newServer(t testing.TB) *Server {
+	b := server.NewMemoryBackend()
+	b.PasswordAdmin = func(bindDN, dn string) bool { return strings.EqualFold(bindDN, AdminDN) }
+	admin := server.Intercept(func(c *server.Conn, op server.Operation, next func() error) error {
+		if bind, ok := op.(*server.BindRequest); ok && bind.SASL == nil &&
+			strings.EqualFold(bind.Name, AdminDN) && bind.Password == AdminPassword {
+			return nil
+		}
+		return next()
+	})
+	return &Server{
+		Backend: b,
+		Server:  &server.Server{Handler: server.Chain(b, admin)},
+		t:       t,
+	}
+}
+
+func (s *Server) start(fixtures []string) {
+	s.t.Helper()
+	s.t.Cleanup(s.Close)
+	s.Conn = s.Dial()
+	if err := s.Conn.Bind(AdminDN, AdminPassword); err != nil {
+		s.t.Fatalf("ldaptest: bind: %v", err)
+	}
+	for _, name := range fixtures {
+		f, err := os.Open(name)
+		if err != nil {
+			s.t.Fatalf("ldaptest: %v", err)
+		}
+		err = s.load(f)
+		f.Close()
+		if err != nil {
+			s.t.Fatalf("ldaptest: %s: %v", name, err)
+		}
+	}
+}
+
+// Load loads LDIF text into the directory as StartServer loads
+// fixtures, failing the test if it cannot.
+func (s *Server) Load(text string) {
+	s.t.Helper()
+	if err := s.load(strings.NewReader(text)); err != nil {
+		s.t.Fatalf("ldaptest: %v", err)
+	}
+}
+
+func (s *Server) load(r io.Reader) error {
+	b := s.Backend
+	lr := ldif.NewReader(r)
+	for {
+		rec, err := lr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch rec.ChangeType {
+		case ldif.NoChange:
+			err = b.AddEntry(rec.Entry())
+		case ldif.Add:
+			err = b.Add(nil, &server.AddRequest{DN: rec.DN, Attributes: rec.Attributes})
+		case ldif.Modify:
+			err = b.Modify(nil, &server.ModifyRequest{DN: rec.DN, Modifications: rec.Modifications})
+		case ldif.Delete:
+			err = b.Delete(nil, &server.DeleteRequest{DN: rec.DN})
+		case ldif.ModRDN, ldif.ModDN:
+			err = b.ModifyDN(nil, &server.ModifyDNRequest{DN: rec.DN, NewRDN: rec.NewRDN,
+				DeleteOldRDN: rec.DeleteOldRDN, NewSuperior: rec.NewSuperior})
+		}
+		if err != nil && rec.ChangeType == ldif.NoChange {
+			return fmt.Errorf("adding %q: %v", rec.DN, err)
+		} else if err != nil {
+			return fmt.Errorf("%s of %q: %v", rec.ChangeType, rec.DN, err)
+		}
+	}
+}
+
+// Dial returns a new, anonymous connection to the server, which is
+// closed when the test ends.
+func (s *Server) Dial() ldap.Conn {
+	s.t.Helper()
+	var c ldap.Conn
+	if s.pipe {
+		client, nc := net.Pipe()
+		go s.Server.ServeConn(nc)
+		c = ldap.NewConn(client)
+	} else {
+		var err error
+		if c, err = ldap.Dial(s.Addr); err != nil {
+			s.t.Fatalf("ldaptest: %v", err)
+		}
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.mu.Unlock()
+	return c
+}
+
+// Close closes the server and the connections Dial returned. It is
+// called when the test ends, and may be called before.
+func (s *Server) Close() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	s.Server.Close()
+	if s.done != nil {
+		if err := <-s.done; err != server.ErrServerClosed {
+			s.t.Errorf("ldaptest: %v", err)
+		}
+		s.done = nil
+	}
+}
@@ -0,0 +1,71 @@
+package ldaptest
+
+import (
+	"github.com/stesla/ldap"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const fixture = `dn: dc=example,dc=com
+objectClass: domain
+dc: example
+
+dn: ou=People,dc=example,dc=com
+objectClass: organizationalUnit
+ou: People
+
+dn: cn=Alice,ou=People,dc=example,dc=com
+objectClass: person
+cn: Alice
+sn: Smith
+userPassword: alice
+
+dn: cn=Alice,ou=People,dc=example,dc=com
+changetype: modify
+replace: sn
+sn: Jones
+-
+`
+
+func TestStartServer(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "fixture.ldif")
+	if err := os.WriteFile(name, []byte(fixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, start := range []func(testing.TB, ...string) *Server{StartServer, StartPipeServer} {
+		s := start(t, name)
+		s.Load("dn: cn=Bob,ou=People,dc=example,dc=com\nobjectClass: person\ncn: Bob\nsn: Brown\n")
+
+		results, err := s.Conn.Search(ldap.SearchRequest{
+			BaseObject: []byte("dc=example,dc=com"),
+			Scope:      ldap.WholeSubtree,
+			Filter:     ldap.Present("sn"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sn []string
+		for _, r := range results {
+			sn = append(sn, r.Attributes["sn"]...)
+		}
+		sort.Strings(sn)
+		if expected := []string{"Brown", "Jones"}; !reflect.DeepEqual(sn, expected) {
+			t.Errorf("Bad surnames: %v (expected %v)", sn, expected)
+		}
+		if e := s.Backend.Entry("cn=Bob,ou=People,dc=example,dc=com"); e == nil {
+			t.Errorf("Loaded entry missing from backend")
+		}
+
+		c := s.Dial()
+		if err := c.Bind("cn=Alice,ou=People,dc=example,dc=com", "alice"); err != nil {
+			t.Errorf("Bind: %v", err)
+		}
+		if err := c.Bind(AdminDN, "wrong"); err == nil {
+			t.Errorf("Bind with wrong admin password succeeded")
+		}
+		s.Close()
+	}
+}
@@ -0,0 +1,330 @@
+package ldaptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record returns a connection that passes everything through to nc and
+// writes each LDAP message sent and received to w, for Replay to play
+// back. Pass it to ldap.NewConn.
+//
+// The recording has a line for each message, in hex, in the order they
+// were sent or received: requests are prefixed with "> " and responses
+// with "< ". Blank lines and lines starting with "#" are ignored, so
+// recordings may be annotated. Messages sent after StartTLS are
+// encrypted, so record over a connection that is already secure, such
+// as one made by tls.Dial, instead.
+func Record(nc net.Conn, w io.Writer) net.Conn {
+	r := &recorder{Conn: nc, w: w}
+	r.sent.prefix, r.received.prefix = "> ", "< "
+	return r
+}
+
+type recorder struct {
+	net.Conn
+	mu             sync.Mutex
+	w              io.Writer
+	sent, received framer
+}
+
+// A framer splits a byte stream into LDAP messages.
+type framer struct {
+	prefix string
+	buf    []byte
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	n, err := r.Conn.Write(b)
+	r.record(&r.sent, b[:n])
+	return n, err
+}
+
+func (r *recorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	r.record(&r.received, b[:n])
+	return n, err
+}
+
+func (r *recorder) record(f *framer, b []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f.buf = append(f.buf, b...)
+	for {
+		_, n, err := messageHeader(f.buf)
+		if err != nil || n == 0 {
+			return
+		}
+		fmt.Fprintf(r.w, "%s%x\n", f.prefix, f.buf[:n])
+		f.buf = f.buf[n:]
+	}
+}
+
+// A ReplayConn plays back the server's side of a recorded connection.
+// Each request the client sends must match the next recorded request,
+// apart from its message ID; the responses recorded after it are then
+// returned, with their message IDs rewritten to the client's.
+type ReplayConn struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	messages []replayMessage
+	ids      map[int]int // recorded message IDs to the client's
+	out      []byte
+	in       []byte
+	closed   bool
+}
+
+type replayMessage struct {
+	request bool
+	b       []byte
+}
+
+// Replay reads a recording made by Record from r, and returns a
+// connection that plays it back. Pass it to ldap.NewConn.
+func Replay(r io.Reader) (*ReplayConn, error) {
+	c := &ReplayConn{ids: map[int]int{}}
+	c.cond = sync.NewCond(&c.mu)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 16<<20)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if len(text) < 2 || (text[0] != '>' && text[0] != '<') {
+			return nil, fmt.Errorf("ldaptest: line %d: expected > or <", line)
+		}
+		b, err := hex.DecodeString(strings.TrimSpace(text[1:]))
+		if err == nil {
+			if _, _, err = messageID(b); err == nil && len(b) != messageLength(b) {
+				err = errors.New("trailing data")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ldaptest: line %d: %v", line, err)
+		}
+		c.messages = append(c.messages, replayMessage{text[0] == '>', b})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	c.respond()
+	return c, nil
+}
+
+// Finished reports whether the client has sent every recorded request.
+func (c *ReplayConn) Finished() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, m := range c.messages {
+		if m.request {
+			return false
+		}
+	}
+	return true
+}
+
+// respond queues the responses up to the next recorded request.
+func (c *ReplayConn) respond() {
+	for len(c.messages) > 0 && !c.messages[0].request {
+		m := c.messages[0].b
+		c.messages = c.messages[1:]
+		id, _, _ := messageID(m)
+		if live, ok := c.ids[id]; ok {
+			m = withMessageID(m, live)
+		}
+		c.out = append(c.out, m...)
+	}
+	c.cond.Broadcast()
+}
+
+func (c *ReplayConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.out) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if len(c.out) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+func (c *ReplayConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	c.in = append(c.in, b...)
+	for {
+		_, n, err := messageHeader(c.in)
+		if err != nil {
+			return 0, fmt.Errorf("ldaptest: %v", err)
+		}
+		if n == 0 {
+			return len(b), nil
+		}
+		if err := c.request(c.in[:n]); err != nil {
+			return 0, err
+		}
+		c.in = c.in[n:]
+	}
+}
+
+// request matches a request of the client with the next recorded one.
+func (c *ReplayConn) request(live []byte) error {
+	id, op, err := messageID(live)
+	if err != nil {
+		return fmt.Errorf("ldaptest: %v", err)
+	}
+	if len(c.messages) == 0 {
+		return fmt.Errorf("ldaptest: request %d not in the recording", id)
+	}
+	recorded := c.messages[0].b
+	recordedID, recordedOp, _ := messageID(recorded)
+	if !bytes.Equal(c.abandoned(recordedOp), op) {
+		return fmt.Errorf("ldaptest: request %d is %x, but %x was recorded", id, op, recordedOp)
+	}
+	c.messages = c.messages[1:]
+	c.ids[recordedID] = id
+	c.respond()
+	return nil
+}
+
+// abandoned rewrites the message ID in a recorded abandon request to the
+// client's.
+func (c *ReplayConn) abandoned(op []byte) []byte {
+	const abandonRequest = 0x50
+	if len(op) < 2 || op[0] != abandonRequest || int(op[1]) > len(op)-2 || op[1] > 8 {
+		return op
+	}
+	n := int(op[1])
+	id, ok := c.ids[int(decodeInt(op[2:2+n]))]
+	if !ok {
+		return op
+	}
+	v := encodeInt(id)
+	return append(append([]byte{abandonRequest, byte(len(v))}, v...), op[2+n:]...)
+}
+
+func (c *ReplayConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
+
+func (c *ReplayConn) LocalAddr() net.Addr                { return replayAddr{} }
+func (c *ReplayConn) RemoteAddr() net.Addr               { return replayAddr{} }
+func (c *ReplayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *ReplayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *ReplayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// messageHeader returns the length of the header and the total length
+// of the LDAPMessage at the start of b, or zeros if b does not hold all
+// of it yet.
+func messageHeader(b []byte) (header, total int, err error) {
+	if len(b) < 2 {
+		return 0, 0, nil
+	}
+	if b[0] != 0x30 {
+		return 0, 0, fmt.Errorf("expected an LDAPMessage, found tag %#x", b[0])
+	}
+	length, header := int(b[1]), 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return 0, 0, fmt.Errorf("unsupported length encoding %#x", b[1])
+		}
+		if len(b) < 2+n {
+			return 0, 0, nil
+		}
+		length = int(decodeInt(append([]byte{0}, b[2:2+n]...)))
+		header += n
+	}
+	if len(b) < header+length {
+		return 0, 0, nil
+	}
+	return header, header + length, nil
+}
+
+func messageLength(b []byte) int {
+	_, n, _ := messageHeader(b)
+	return n
+}
+
+// messageID splits a complete LDAPMessage into its message ID and the
+// rest of its content: the operation and any controls.
+func messageID(m []byte) (int, []byte, error) {
+	header, n, err := messageHeader(m)
+	if err == nil && n == 0 {
+		err = errors.New("truncated LDAPMessage")
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	content := m[header:n]
+	if len(content) < 3 || content[0] != 0x02 || content[1] == 0 || int(content[1]) > len(content)-2 {
+		return 0, nil, errors.New("malformed messageID")
+	}
+	end := 2 + int(content[1])
+	return int(decodeInt(content[2:end])), content[end:], nil
+}
+
+// withMessageID returns a copy of m with the message ID id.
+func withMessageID(m []byte, id int) []byte {
+	_, rest, _ := messageID(m)
+	v := encodeInt(id)
+	content := append(append([]byte{0x02, byte(len(v))}, v...), rest...)
+	return append(append([]byte{0x30}, encodeLength(len(content))...), content...)
+}
+
+func decodeInt(b []byte) int64 {
+	var i int64
+	for j, x := range b {
+		if j == 0 && x&0x80 != 0 {
+			i = -1
+		}
+		i = i<<8 | int64(x)
+	}
+	return i
+}
+
+func encodeInt(i int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(i)}, b...)
+		i >>= 8
+		if i == 0 && b[0]&0x80 == 0 || i == -1 && b[0]&0x80 != 0 {
+			return b
+		}
+	}
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
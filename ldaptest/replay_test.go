@@ -0,0 +1,112 @@
+package ldaptest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/stesla/ldap"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	s := StartServer(t)
+	s.Load(fixture)
+
+	search := ldap.SearchRequest{
+		BaseObject: []byte("ou=People,dc=example,dc=com"),
+		Scope:      ldap.WholeSubtree,
+		Filter:     ldap.Present("sn"),
+	}
+	session := func(c ldap.Conn) ([]ldap.SearchResult, error) {
+		if err := c.Bind(AdminDN, AdminPassword); err != nil {
+			return nil, err
+		}
+		if err := c.Bind("cn=Alice,ou=People,dc=example,dc=com", "wrong"); err == nil {
+			return nil, fmt.Errorf("bind with wrong password succeeded")
+		}
+		return c.Search(search)
+	}
+
+	nc, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recording bytes.Buffer
+	c := ldap.NewConn(Record(nc, &recording))
+	expected, err := session(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shift the recorded message IDs, which replay must map back to the
+	// client's.
+	var shifted strings.Builder
+	shifted.WriteString("# shifted\n")
+	for _, line := range strings.Split(strings.TrimSpace(recording.String()), "\n") {
+		b, err := hex.DecodeString(line[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _, err := messageID(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&shifted, "%s%x\n", line[:2], withMessageID(b, id+1000))
+	}
+
+	for i, text := range []string{recording.String(), shifted.String()} {
+		rc, err := Replay(strings.NewReader(text))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		c := ldap.NewConn(rc)
+		results, err := session(c)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+		} else if !reflect.DeepEqual(results, expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, results, expected)
+		}
+		if !rc.Finished() {
+			t.Errorf("#%d: Recorded requests not made", i)
+		}
+		c.Close()
+	}
+
+	// A request that differs from the recording fails.
+	rc, err := Replay(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = ldap.NewConn(rc)
+	defer c.Close()
+	if err := c.Bind(AdminDN, "other"); err == nil || !strings.Contains(err.Error(), "was recorded") {
+		t.Errorf("Bad result: %v (expected a mismatch)", err)
+	}
+}
+
+func TestEncodeInt(t *testing.T) {
+	tests := []struct {
+		in       int
+		expected []byte
+	}{
+		{0, []byte{0}},
+		{127, []byte{0x7f}},
+		{128, []byte{0, 0x80}},
+		{256, []byte{1, 0}},
+		{-1, []byte{0xff}},
+		{-129, []byte{0xff, 0x7f}},
+	}
+	for i, test := range tests {
+		out := encodeInt(test.in)
+		if !bytes.Equal(out, test.expected) {
+			t.Errorf("#%d: Bad result: %x (expected %x)", i, out, test.expected)
+		}
+		if x := decodeInt(out); x != int64(test.in) {
+			t.Errorf("#%d: Bad decoding: %d (expected %d)", i, x, test.in)
+		}
+	}
+}
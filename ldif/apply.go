@@ -0,0 +1,118 @@
+package ldif
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+	"io"
+	"strings"
+)
+
+// ApplyOptions controls how Apply performs records.
+type ApplyOptions struct {
+	// ContinueOnError applies the remaining records after one fails.
+	// The failures are returned together as ApplyErrors.
+	ContinueOnError bool
+	// DryRun reads and checks the records without sending them.
+	DryRun bool
+	// AddContentRecords treats content records as add records, like
+	// ldapmodify -a. Otherwise they are an error.
+	AddContentRecords bool
+	// OnRecord, if set, is called with each record and the outcome of
+	// applying it.
+	OnRecord func(rec *Record, err error)
+}
+
+// A RecordError reports a record that could not be applied.
+type RecordError struct {
+	Record *Record
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	changeType := e.Record.ChangeType
+	if changeType == NoChange {
+		changeType = "content"
+	}
+	return fmt.Sprintf("ldif: %s %q: %v", changeType, e.Record.DN, e.Err)
+}
+
+func (e *RecordError) Unwrap() error { return e.Err }
+
+type ApplyErrors []*RecordError
+
+func (errs ApplyErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Apply reads change records from r and performs them on conn, like
+// ldapmodify. It returns the number of records applied. Unless
+// opts.ContinueOnError is set it stops at the first failure, returning
+// a *RecordError.
+func Apply(conn ldap.Conn, r *Reader, opts ApplyOptions) (int, error) {
+	applied := 0
+	var errs ApplyErrors
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+
+		err = applyRecord(conn, rec, opts)
+		if opts.OnRecord != nil {
+			opts.OnRecord(rec, err)
+		}
+		if err == nil {
+			applied++
+			continue
+		}
+		recErr := &RecordError{rec, err}
+		if !opts.ContinueOnError {
+			return applied, recErr
+		}
+		errs = append(errs, recErr)
+	}
+	if len(errs) > 0 {
+		return applied, errs
+	}
+	return applied, nil
+}
+
+func applyRecord(conn ldap.Conn, rec *Record, opts ApplyOptions) error {
+	changeType := rec.ChangeType
+	if changeType == NoChange {
+		if !opts.AddContentRecords {
+			return fmt.Errorf("content record in change file")
+		}
+		changeType = Add
+	}
+
+	switch changeType {
+	case Add, Delete, Modify, ModRDN, ModDN:
+	default:
+		return fmt.Errorf("unknown changetype %q", changeType)
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	controls := make([]ldap.Control, len(rec.Controls))
+	for i := range rec.Controls {
+		controls[i] = &rec.Controls[i]
+	}
+	switch changeType {
+	case Add:
+		return conn.Add(rec.DN, rec.Attributes, controls...)
+	case Delete:
+		return conn.Del(rec.DN, controls...)
+	case Modify:
+		return conn.Modify(rec.DN, rec.Modifications, controls...)
+	}
+	return conn.ModifyDN(rec.DN, rec.NewRDN, rec.DeleteOldRDN, rec.NewSuperior, controls...)
+}
@@ -0,0 +1,105 @@
+package ldif
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type applyTestConn struct {
+	ldap.Conn
+	calls []string
+	fail  map[string]bool
+}
+
+func (c *applyTestConn) call(s string) error {
+	c.calls = append(c.calls, s)
+	if c.fail[s] {
+		return &ldap.Error{ResultCode: ldap.NoSuchObject}
+	}
+	return nil
+}
+
+func (c *applyTestConn) Add(dn string, attrs []ldap.Attribute, controls ...ldap.Control) error {
+	return c.call("add " + dn)
+}
+
+func (c *applyTestConn) Del(dn string, controls ...ldap.Control) error {
+	return c.call(fmt.Sprintf("delete %s %d", dn, len(controls)))
+}
+
+func (c *applyTestConn) Modify(dn string, mods []ldap.Modification, controls ...ldap.Control) error {
+	return c.call(fmt.Sprintf("modify %s %d", dn, len(mods)))
+}
+
+func (c *applyTestConn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...ldap.Control) error {
+	return c.call(fmt.Sprintf("modrdn %s %s", dn, newRDN))
+}
+
+const applyLDIF = `dn: cn=a
+changetype: add
+cn: a
+
+dn: cn=b
+control: 1.2.840.113556.1.4.805 true
+changetype: delete
+
+dn: cn=c
+changetype: modify
+replace: sn
+sn: c
+-
+
+dn: cn=d
+changetype: modrdn
+newrdn: cn=e
+deleteoldrdn: 1
+`
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		opts     ApplyOptions
+		fail     string
+		applied  int
+		calls    []string
+		failures int
+	}{
+		{ApplyOptions{}, "", 4, []string{"add cn=a", "delete cn=b 1", "modify cn=c 1", "modrdn cn=d cn=e"}, 0},
+		{ApplyOptions{}, "delete cn=b 1", 1, []string{"add cn=a", "delete cn=b 1"}, 1},
+		{ApplyOptions{ContinueOnError: true}, "delete cn=b 1", 3, []string{"add cn=a", "delete cn=b 1", "modify cn=c 1", "modrdn cn=d cn=e"}, 1},
+		{ApplyOptions{DryRun: true}, "", 4, nil, 0},
+	}
+	for i, test := range tests {
+		conn := &applyTestConn{fail: map[string]bool{test.fail: true}}
+		applied, err := Apply(conn, NewReader(strings.NewReader(applyLDIF)), test.opts)
+		if applied != test.applied || !reflect.DeepEqual(conn.calls, test.calls) {
+			t.Errorf("#%d: Bad result: %d %v (expected %d %v)", i, applied, conn.calls, test.applied, test.calls)
+		}
+		if test.failures == 0 && err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+		}
+		if test.failures > 0 && !ldap.IsErrorWithCode(err, ldap.NoSuchObject) {
+			var errs ApplyErrors
+			if !errors.As(err, &errs) || len(errs) != test.failures || !ldap.IsErrorWithCode(errs[0], ldap.NoSuchObject) {
+				t.Errorf("#%d: Bad error: %v", i, err)
+			}
+		}
+	}
+}
+
+func TestApplyContentRecords(t *testing.T) {
+	const content = "dn: cn=a\ncn: a\n"
+	conn := &applyTestConn{}
+	if _, err := Apply(conn, NewReader(strings.NewReader(content)), ApplyOptions{}); err == nil {
+		t.Errorf("Expected an error for a content record")
+	}
+	if _, err := Apply(conn, NewReader(strings.NewReader(content)), ApplyOptions{AddContentRecords: true}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if expected := []string{"add cn=a"}; !reflect.DeepEqual(conn.calls, expected) {
+		t.Errorf("Bad result: %v (expected %v)", conn.calls, expected)
+	}
+}
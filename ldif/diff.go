@@ -0,0 +1,83 @@
+package ldif
+
+import (
+	"github.com/stesla/ldap"
+	"sort"
+	"strings"
+)
+
+// Diff returns the change records that turn the entries in old into
+// those in new: adds for new entries, parents first; modifies for
+// entries whose attributes differ; and deletes for entries that are
+// gone, children first. Entries are matched by DN and attributes by
+// name, ignoring case; values are compared exactly.
+func Diff(old, new []*ldap.Entry) []*Record {
+	return DiffWithRules(old, new, nil)
+}
+
+// DiffWithRules is like Diff, but compares the values of each attribute
+// with the matching rule returned by rules, so that, for example, a
+// change in case alone is no change under caseIgnoreMatch. rules may
+// return nil, or be nil, to compare values exactly.
+func DiffWithRules(old, new []*ldap.Entry, rules func(attribute string) ldap.MatchingRule) []*Record {
+	oldByDN := make(map[string]*ldap.Entry, len(old))
+	for _, e := range old {
+		oldByDN[dnKey(e.DN)] = e
+	}
+	newByDN := make(map[string]*ldap.Entry, len(new))
+	for _, e := range new {
+		newByDN[dnKey(e.DN)] = e
+	}
+
+	var adds, modifies, deletes []*Record
+	for _, e := range new {
+		o, ok := oldByDN[dnKey(e.DN)]
+		if !ok {
+			rec := NewContentRecord(e)
+			rec.ChangeType = Add
+			adds = append(adds, rec)
+		} else if mods := ldap.DiffEntry(o, e, rules); len(mods) > 0 {
+			modifies = append(modifies, &Record{DN: e.DN, ChangeType: Modify, Modifications: mods})
+		}
+	}
+	for _, e := range old {
+		if _, ok := newByDN[dnKey(e.DN)]; !ok {
+			deletes = append(deletes, &Record{DN: e.DN, ChangeType: Delete})
+		}
+	}
+
+	sort.SliceStable(adds, func(i, j int) bool {
+		return dnDepth(adds[i].DN) < dnDepth(adds[j].DN)
+	})
+	sort.SliceStable(deletes, func(i, j int) bool {
+		return dnDepth(deletes[i].DN) > dnDepth(deletes[j].DN)
+	})
+
+	records := append(adds, modifies...)
+	return append(records, deletes...)
+}
+
+// DiffEntry returns the modifications that turn old's attributes into
+// new's. An attribute that only gains or loses values is changed value
+// by value; one whose values all change is replaced.
+func DiffEntry(old, new *ldap.Entry) []ldap.Modification {
+	return ldap.DiffEntry(old, new, nil)
+}
+
+// dnKey returns a form of dn for matching entries, falling back to dn
+// itself if it does not parse.
+func dnKey(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	return strings.ToLower(parsed.String())
+}
+
+func dnDepth(dn string) int {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.Count(dn, ",") + 1
+	}
+	return len(parsed)
+}
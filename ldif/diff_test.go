@@ -0,0 +1,102 @@
+package ldif
+
+import (
+	"bytes"
+	"github.com/stesla/ldap"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := []*ldap.Entry{
+		ldap.NewEntry("ou=people,dc=example", map[string][]string{"ou": {"people"}}),
+		ldap.NewEntry("cn=a,ou=people,dc=example", map[string][]string{
+			"cn":          {"a"},
+			"mail":        {"a@example.com", "a2@example.com"},
+			"sn":          {"A"},
+			"description": {"old"},
+		}),
+		ldap.NewEntry("cn=b,ou=people,dc=example", map[string][]string{"cn": {"b"}}),
+		ldap.NewEntry("ou=gone,dc=example", map[string][]string{"ou": {"gone"}}),
+		ldap.NewEntry("cn=x,ou=gone,dc=example", map[string][]string{"cn": {"x"}}),
+	}
+	new := []*ldap.Entry{
+		ldap.NewEntry("cn=c,ou=groups,dc=example", map[string][]string{"cn": {"c"}}),
+		ldap.NewEntry("ou=groups,dc=example", map[string][]string{"ou": {"groups"}}),
+		ldap.NewEntry("OU=People, DC=example", map[string][]string{"ou": {"people"}}),
+		ldap.NewEntry("cn=a,ou=people,dc=example", map[string][]string{
+			"cn":        {"a"},
+			"MAIL":      {"a@example.com", "a3@example.com"},
+			"sn":        {"B"},
+			"telephone": {"555"},
+		}),
+		ldap.NewEntry("cn=b,ou=people,dc=example", map[string][]string{"cn": {"b"}}),
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Version = 0
+	for _, rec := range Diff(old, new) {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const expected = `dn: ou=groups,dc=example
+changetype: add
+ou: groups
+
+dn: cn=c,ou=groups,dc=example
+changetype: add
+cn: c
+
+dn: cn=a,ou=people,dc=example
+changetype: modify
+delete: MAIL
+MAIL: a2@example.com
+-
+add: MAIL
+MAIL: a3@example.com
+-
+replace: sn
+sn: B
+-
+add: telephone
+telephone: 555
+-
+delete: description
+-
+
+dn: cn=x,ou=gone,dc=example
+changetype: delete
+
+dn: ou=gone,dc=example
+changetype: delete
+`
+	if buf.String() != expected {
+		t.Errorf("Bad result:\n%s\n(expected)\n%s", buf.String(), expected)
+	}
+}
+
+func TestDiffEqual(t *testing.T) {
+	entries := []*ldap.Entry{ldap.NewEntry("cn=a", map[string][]string{"cn": {"a", "b"}})}
+	if records := Diff(entries, entries); len(records) != 0 {
+		t.Errorf("Bad result: %v (expected none)", records)
+	}
+}
+
+func TestDiffWithRules(t *testing.T) {
+	old := []*ldap.Entry{ldap.NewEntry("cn=a", map[string][]string{"cn": {"Alice"}, "member": {"CN=Bob, DC=example"}})}
+	new := []*ldap.Entry{ldap.NewEntry("cn=a", map[string][]string{"cn": {"alice"}, "member": {"cn=bob,dc=example"}})}
+	rules := func(attr string) ldap.MatchingRule {
+		if attr == "member" {
+			return ldap.DistinguishedNameMatch
+		}
+		return ldap.CaseIgnoreMatch
+	}
+	if records := DiffWithRules(old, new, rules); len(records) != 0 {
+		t.Errorf("Bad result: %v (expected none)", records)
+	}
+	if records := Diff(old, new); len(records) != 1 {
+		t.Errorf("Bad result: %v (expected one modify)", records)
+	}
+}
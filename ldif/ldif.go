@@ -0,0 +1,73 @@
+// Package ldif reads and writes the LDAP Data Interchange Format of
+// RFC 2849.
+package ldif
+
+import (
+	"github.com/stesla/ldap"
+)
+
+type ChangeType string
+
+const (
+	// NoChange marks a content record, which describes an entry rather
+	// than a change to one.
+	NoChange ChangeType = ""
+	Add      ChangeType = "add"
+	Delete   ChangeType = "delete"
+	Modify   ChangeType = "modify"
+	ModRDN   ChangeType = "modrdn"
+	ModDN    ChangeType = "moddn"
+)
+
+// A Record is a content record or a change record. Which fields are
+// meaningful depends on ChangeType: Attributes for content and add
+// records, Modifications for modify records, and NewRDN, DeleteOldRDN
+// and NewSuperior for modrdn records.
+//
+// Records have a JSON form too, with the attributes and modifications in
+// that of the ldap package:
+//
+//	{"dn": "cn=a,dc=example,dc=com", "changeType": "modify",
+//	 "modifications": [{"operation": "replace", "name": "sn", "values": ["b"]}]}
+type Record struct {
+	DN         string     `json:"dn"`
+	ChangeType ChangeType `json:"changeType,omitempty"`
+	Controls   []Control  `json:"controls,omitempty"`
+
+	Attributes    []ldap.Attribute    `json:"attributes,omitempty"`
+	Modifications []ldap.Modification `json:"modifications,omitempty"`
+
+	NewRDN       string `json:"newRDN,omitempty"`
+	DeleteOldRDN bool   `json:"deleteOldRDN,omitempty"`
+	NewSuperior  string `json:"newSuperior,omitempty"`
+}
+
+// Entry returns the entry described by a content or add record.
+func (r *Record) Entry() *ldap.Entry {
+	e := &ldap.Entry{DN: r.DN}
+	for _, a := range r.Attributes {
+		e.Attributes = append(e.Attributes, ldap.NewEntryAttribute(a.Type, a.Values))
+	}
+	return e
+}
+
+// NewContentRecord returns a content record for e.
+func NewContentRecord(e *ldap.Entry) *Record {
+	r := &Record{DN: e.DN}
+	for _, a := range e.Attributes {
+		r.Attributes = append(r.Attributes, ldap.Attribute{Type: a.Name, Values: a.Values})
+	}
+	return r
+}
+
+// A Control is a control line of a change record. It implements
+// ldap.Control so that it can be sent along with the change.
+type Control struct {
+	OID         string `json:"type"`
+	Criticality bool   `json:"critical,omitempty"`
+	Value       []byte `json:"value,omitempty"` // base64 in JSON
+}
+
+func (c *Control) ControlType() string           { return c.OID }
+func (c *Control) Critical() bool                { return c.Criticality }
+func (c *Control) ControlValue() ([]byte, error) { return c.Value, nil }
@@ -0,0 +1,217 @@
+package ldif
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stesla/ldap"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const contentLDIF = `version: 1
+# a comment
+ that is folded
+dn: cn=Barbara Jensen, ou=Product Development, dc=airius, dc=com
+objectclass: top
+objectclass: person
+cn: Barbara Jensen
+cn: Barbara J Jensen
+description: Babs is a big sailing fan, and travels extensively in sea
+ rch of perfect sailing conditions.
+title:: IGxlYWRpbmcgc3BhY2U=
+
+dn: cn=Bjorn Jensen, ou=Accounting, dc=airius, dc=com
+objectclass: top
+cn: Bjorn Jensen
+`
+
+func TestReadContent(t *testing.T) {
+	r := NewReader(strings.NewReader(contentLDIF))
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != 1 {
+		t.Errorf("Bad version: %d (expected 1)", r.Version)
+	}
+	expected := []*Record{
+		{
+			DN: "cn=Barbara Jensen, ou=Product Development, dc=airius, dc=com",
+			Attributes: []ldap.Attribute{
+				{Type: "objectclass", Values: []string{"top", "person"}},
+				{Type: "cn", Values: []string{"Barbara Jensen", "Barbara J Jensen"}},
+				{Type: "description", Values: []string{"Babs is a big sailing fan, and travels extensively in search of perfect sailing conditions."}},
+				{Type: "title", Values: []string{" leading space"}},
+			},
+		},
+		{
+			DN: "cn=Bjorn Jensen, ou=Accounting, dc=airius, dc=com",
+			Attributes: []ldap.Attribute{
+				{Type: "objectclass", Values: []string{"top"}},
+				{Type: "cn", Values: []string{"Bjorn Jensen"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Bad result: %+v (expected %+v)", records, expected)
+	}
+}
+
+const changesLDIF = `version: 1
+
+dn: cn=Fiona Jensen, ou=Marketing, dc=airius, dc=com
+changetype: add
+objectclass: person
+cn: Fiona Jensen
+
+dn: cn=Robert Jensen, ou=Marketing, dc=airius, dc=com
+changetype: delete
+
+dn: cn=Paul Jensen, ou=Product Development, dc=airius, dc=com
+changetype: modrdn
+newrdn: cn=Paula Jensen
+deleteoldrdn: 1
+newsuperior: ou=Accounting, dc=airius, dc=com
+
+dn: cn=Paula Jensen, ou=Product Development, dc=airius, dc=com
+control: 1.2.840.113556.1.4.805 true
+changetype: modify
+add: postaladdress
+postaladdress: 123 Anystreet $ Sunnyvale, CA $ 94086
+-
+delete: description
+-
+replace: telephonenumber
+telephonenumber: +1 408 555 1234
+telephonenumber: +1 408 555 5678
+-
+`
+
+func TestReadChanges(t *testing.T) {
+	records, err := NewReader(strings.NewReader(changesLDIF)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Record{
+		{
+			DN:         "cn=Fiona Jensen, ou=Marketing, dc=airius, dc=com",
+			ChangeType: Add,
+			Attributes: []ldap.Attribute{
+				{Type: "objectclass", Values: []string{"person"}},
+				{Type: "cn", Values: []string{"Fiona Jensen"}},
+			},
+		},
+		{DN: "cn=Robert Jensen, ou=Marketing, dc=airius, dc=com", ChangeType: Delete},
+		{
+			DN:           "cn=Paul Jensen, ou=Product Development, dc=airius, dc=com",
+			ChangeType:   ModRDN,
+			NewRDN:       "cn=Paula Jensen",
+			DeleteOldRDN: true,
+			NewSuperior:  "ou=Accounting, dc=airius, dc=com",
+		},
+		{
+			DN:         "cn=Paula Jensen, ou=Product Development, dc=airius, dc=com",
+			ChangeType: Modify,
+			Controls:   []Control{{OID: "1.2.840.113556.1.4.805", Criticality: true}},
+			Modifications: []ldap.Modification{
+				{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "postaladdress", Values: []string{"123 Anystreet $ Sunnyvale, CA $ 94086"}}},
+				{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "description"}},
+				{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "telephonenumber", Values: []string{"+1 408 555 1234", "+1 408 555 5678"}}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Bad result: %+v (expected %+v)", records, expected)
+	}
+
+	// Writing the records back out reproduces the input.
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := strings.Replace(buf.String(), "version: 1\n", "version: 1\n\n", 1)
+	if result != changesLDIF {
+		t.Errorf("Bad result:\n%s\n(expected)\n%s", result, changesLDIF)
+	}
+}
+
+func TestWriteFoldsAndEncodes(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Version = 0
+	w.Width = 20
+	e := ldap.NewEntry("cn=x", map[string][]string{
+		"description": {"a value that is long enough to fold"},
+		"sn":          {"Carbó"},
+		"title":       {":colon"},
+	})
+	if err := w.WriteEntry(e); err != nil {
+		t.Fatal(err)
+	}
+	expected := "dn: cn=x\n" +
+		"description: a value\n" +
+		"  that is long enoug\n" +
+		" h to fold\n" +
+		"sn:: Q2FyYsOz\n" +
+		"title:: OmNvbG9u\n"
+	if buf.String() != expected {
+		t.Errorf("Bad result:\n%s(expected)\n%s", buf.String(), expected)
+	}
+
+	records, err := NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0].Entry(), e) {
+		t.Errorf("Bad round trip: %+v (expected %+v)", records, e)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []string{
+		"cn: no dn\n",
+		"dn: cn=x\nchangetype: bogus\n",
+		"dn: cn=x\nchangetype: delete\ncn: x\n",
+		"dn: cn=x\nchangetype: modify\nadd: cn\nsn: x\n-\n",
+		"dn: cn=x\nchangetype: modrdn\nnewrdn: cn=y\n",
+		"dn: cn=x\ncn:: !!!\n",
+		" leading continuation\n",
+		"dn: cn=x\ncontrol: 1.2.3\ncn: x\n",
+	}
+	for i, test := range tests {
+		if _, err := NewReader(strings.NewReader(test)).Read(); err == nil || err == io.EOF {
+			t.Errorf("#%d: Expected an error, got %v", i, err)
+		}
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	records := []*Record{
+		{DN: "cn=a,dc=example,dc=com", ChangeType: Add, Attributes: []ldap.Attribute{{Type: "cn", Values: []string{"a"}}}},
+		{
+			DN: "cn=a,dc=example,dc=com", ChangeType: Modify,
+			Controls:      []Control{{OID: "1.3.6.1.1.13.1", Criticality: true, Value: []byte{0x04, 0x00}}},
+			Modifications: []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"b"}}}},
+		},
+		{DN: "cn=a,dc=example,dc=com", ChangeType: ModRDN, NewRDN: "cn=b", DeleteOldRDN: true},
+	}
+	b, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"dn":"cn=a,dc=example,dc=com","changeType":"add","attributes":[{"name":"cn","values":["a"]}]},` +
+		`{"dn":"cn=a,dc=example,dc=com","changeType":"modify","controls":[{"type":"1.3.6.1.1.13.1","critical":true,"value":"BAA="}],"modifications":[{"operation":"replace","name":"sn","values":["b"]}]},` +
+		`{"dn":"cn=a,dc=example,dc=com","changeType":"modrdn","newRDN":"cn=b","deleteOldRDN":true}]`
+	if string(b) != expected {
+		t.Errorf("Bad result: %s (expected %s)", b, expected)
+	}
+	var actual []*Record
+	if err := json.Unmarshal(b, &actual); err != nil || !reflect.DeepEqual(actual, records) {
+		t.Errorf("Bad result: %+v, %v (expected %+v)", actual, err, records)
+	}
+}
@@ -0,0 +1,339 @@
+package ldif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/stesla/ldap"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A Reader reads records from an LDIF file one at a time, so that files
+// of any size can be processed in constant memory.
+type Reader struct {
+	// Version is the version given by the file's header, if any.
+	Version int
+	// OpenURL returns the value referenced by an "attr:< url" line. By
+	// default only file:// URLs are supported.
+	OpenURL func(url string) ([]byte, error)
+
+	r       *bufio.Reader
+	lineNo  int
+	started bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r), OpenURL: openFileURL}
+}
+
+func openFileURL(rawurl string) ([]byte, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	return ioutil.ReadFile(u.Path)
+}
+
+type line struct {
+	no   int
+	text string
+}
+
+// ReadAll reads every remaining record.
+func (r *Reader) ReadAll() ([]*Record, error) {
+	var records []*Record
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// Read returns the next record, or io.EOF when there are no more.
+func (r *Reader) Read() (*Record, error) {
+	for {
+		lines, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			return nil, io.EOF
+		}
+
+		if !r.started {
+			r.started = true
+			if strings.HasPrefix(lines[0].text, "version:") {
+				v, err := strconv.Atoi(strings.TrimSpace(lines[0].text[len("version:"):]))
+				if err != nil {
+					return nil, r.errorf(lines[0], "invalid version")
+				}
+				r.Version = v
+				lines = lines[1:]
+				if len(lines) == 0 {
+					continue
+				}
+			}
+		}
+		return r.parseRecord(lines)
+	}
+}
+
+func (r *Reader) errorf(l line, format string, args ...interface{}) error {
+	return fmt.Errorf("ldif: line %d: %s", l.no, fmt.Sprintf(format, args...))
+}
+
+// readBlock returns the unfolded lines of the next record, skipping
+// comments.
+func (r *Reader) readBlock() ([]line, error) {
+	var lines []line
+	inComment := false
+	for {
+		text, err := r.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if text == "" && err == io.EOF {
+			return lines, nil
+		}
+		r.lineNo++
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+
+		switch {
+		case text == "":
+			if len(lines) > 0 {
+				return lines, nil
+			}
+			inComment = false
+		case text[0] == ' ':
+			if inComment {
+				break
+			}
+			if len(lines) == 0 {
+				return nil, r.errorf(line{no: r.lineNo}, "continuation line without a preceding line")
+			}
+			lines[len(lines)-1].text += text[1:]
+		case text[0] == '#':
+			inComment = true
+		default:
+			inComment = false
+			lines = append(lines, line{r.lineNo, text})
+		}
+		if err == io.EOF {
+			return lines, nil
+		}
+	}
+}
+
+// split parses an "attr: value", "attr:: base64" or "attr:< url" line.
+func (r *Reader) split(l line) (string, []byte, error) {
+	i := strings.IndexByte(l.text, ':')
+	if i <= 0 {
+		return "", nil, r.errorf(l, "missing ':'")
+	}
+	name, rest := l.text[:i], l.text[i+1:]
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		v, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return "", nil, r.errorf(l, "invalid base64 value: %v", err)
+		}
+		return name, v, nil
+	case strings.HasPrefix(rest, "<"):
+		v, err := r.OpenURL(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return "", nil, r.errorf(l, "%v", err)
+		}
+		return name, v, nil
+	}
+	return name, []byte(strings.TrimLeft(rest, " ")), nil
+}
+
+func (r *Reader) parseRecord(lines []line) (*Record, error) {
+	name, dn, err := r.split(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(name, "dn") {
+		return nil, r.errorf(lines[0], "expected dn, got %q", name)
+	}
+	rec := &Record{DN: string(dn)}
+	first := lines[0]
+	lines = lines[1:]
+
+	for len(lines) > 0 && strings.HasPrefix(strings.ToLower(lines[0].text), "control:") {
+		c, err := r.parseControl(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		rec.Controls = append(rec.Controls, c)
+		lines = lines[1:]
+	}
+
+	if len(lines) > 0 && strings.HasPrefix(strings.ToLower(lines[0].text), "changetype:") {
+		_, v, err := r.split(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		rec.ChangeType = ChangeType(strings.ToLower(strings.TrimSpace(string(v))))
+		first, lines = lines[0], lines[1:]
+	} else if len(rec.Controls) > 0 {
+		return nil, r.errorf(first, "controls are only allowed in change records")
+	}
+
+	switch rec.ChangeType {
+	case NoChange, Add:
+		rec.Attributes, err = r.parseAttributes(lines)
+	case Delete:
+		if len(lines) > 0 {
+			return nil, r.errorf(lines[0], "unexpected line in delete record")
+		}
+	case Modify:
+		rec.Modifications, err = r.parseModifications(lines)
+	case ModRDN, ModDN:
+		err = r.parseModRDN(rec, lines)
+	default:
+		return nil, r.errorf(first, "unknown changetype %q", rec.ChangeType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *Reader) parseControl(l line) (Control, error) {
+	spec := strings.TrimSpace(l.text[len("control:"):])
+	var c Control
+
+	end := strings.IndexAny(spec, " :")
+	if end < 0 {
+		end = len(spec)
+	}
+	c.OID, spec = spec[:end], strings.TrimLeft(spec[end:], " ")
+	if c.OID == "" {
+		return c, r.errorf(l, "control without an OID")
+	}
+
+	switch {
+	case strings.HasPrefix(spec, "true"):
+		c.Criticality, spec = true, spec[len("true"):]
+	case strings.HasPrefix(spec, "false"):
+		spec = spec[len("false"):]
+	}
+	if spec == "" {
+		return c, nil
+	}
+	if spec[0] != ':' {
+		return c, r.errorf(l, "invalid control")
+	}
+	_, v, err := r.split(line{l.no, "value" + spec})
+	c.Value = v
+	return c, err
+}
+
+func (r *Reader) parseAttributes(lines []line) ([]ldap.Attribute, error) {
+	var attrs []ldap.Attribute
+	index := map[string]int{}
+	for _, l := range lines {
+		name, v, err := r.split(l)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(name)
+		i, ok := index[key]
+		if !ok {
+			i = len(attrs)
+			index[key] = i
+			attrs = append(attrs, ldap.Attribute{Type: name})
+		}
+		attrs[i].Values = append(attrs[i].Values, string(v))
+	}
+	return attrs, nil
+}
+
+var modifyOperations = map[string]ldap.ModifyOperation{
+	"add":       ldap.AddValues,
+	"delete":    ldap.DeleteValues,
+	"replace":   ldap.ReplaceValues,
+	"increment": ldap.IncrementValue,
+}
+
+func (r *Reader) parseModifications(lines []line) ([]ldap.Modification, error) {
+	var mods []ldap.Modification
+	for len(lines) > 0 {
+		op, attr, err := r.split(lines[0])
+		if err != nil {
+			return nil, err
+		}
+		operation, ok := modifyOperations[strings.ToLower(op)]
+		if !ok {
+			return nil, r.errorf(lines[0], "unknown modification %q", op)
+		}
+		mod := ldap.Modification{Operation: operation, Attribute: ldap.Attribute{Type: string(attr)}}
+		lines = lines[1:]
+
+		for len(lines) > 0 && lines[0].text != "-" {
+			name, v, err := r.split(lines[0])
+			if err != nil {
+				return nil, err
+			}
+			if !strings.EqualFold(name, mod.Type) {
+				return nil, r.errorf(lines[0], "attribute %q in modification of %q", name, mod.Type)
+			}
+			mod.Values = append(mod.Values, string(v))
+			lines = lines[1:]
+		}
+		if len(lines) > 0 {
+			lines = lines[1:] // "-"
+		}
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+func (r *Reader) parseModRDN(rec *Record, lines []line) error {
+	seen := map[string]bool{}
+	for _, l := range lines {
+		name, v, err := r.split(l)
+		if err != nil {
+			return err
+		}
+		name = strings.ToLower(name)
+		if seen[name] {
+			return r.errorf(l, "duplicate %s", name)
+		}
+		seen[name] = true
+		switch name {
+		case "newrdn":
+			rec.NewRDN = string(v)
+		case "deleteoldrdn":
+			switch string(bytes.TrimSpace(v)) {
+			case "0":
+			case "1":
+				rec.DeleteOldRDN = true
+			default:
+				return r.errorf(l, "invalid deleteoldrdn %q", v)
+			}
+		case "newsuperior":
+			rec.NewSuperior = string(v)
+		default:
+			return r.errorf(l, "unexpected %q in %s record", name, rec.ChangeType)
+		}
+	}
+	if !seen["newrdn"] || !seen["deleteoldrdn"] {
+		return fmt.Errorf("ldif: %s record for %q needs newrdn and deleteoldrdn", rec.ChangeType, rec.DN)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package ldif
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/stesla/ldap"
+	"io"
+)
+
+// A Writer writes records in LDIF.
+type Writer struct {
+	// Version is written as a header before the first record, unless it
+	// is zero.
+	Version int
+	// Width is the length at which lines are folded. Zero disables
+	// folding.
+	Width int
+
+	w       io.Writer
+	started bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{Version: 1, Width: 76, w: w}
+}
+
+// WriteEntry writes e as a content record.
+func (w *Writer) WriteEntry(e *ldap.Entry) error {
+	return w.Write(NewContentRecord(e))
+}
+
+func (w *Writer) Write(rec *Record) error {
+	var buf bytes.Buffer
+	if !w.started {
+		w.started = true
+		if w.Version != 0 {
+			fmt.Fprintf(&buf, "version: %d\n", w.Version)
+		}
+	} else {
+		buf.WriteByte('\n')
+	}
+
+	w.line(&buf, "dn", rec.DN)
+	for _, c := range rec.Controls {
+		w.control(&buf, c)
+	}
+	if rec.ChangeType != NoChange {
+		w.line(&buf, "changetype", string(rec.ChangeType))
+	}
+
+	switch rec.ChangeType {
+	case NoChange, Add:
+		for _, a := range rec.Attributes {
+			for _, v := range a.Values {
+				w.line(&buf, a.Type, v)
+			}
+		}
+	case Delete:
+	case Modify:
+		for _, m := range rec.Modifications {
+			op, ok := modifyOperationNames[m.Operation]
+			if !ok {
+				return fmt.Errorf("ldif: unknown modify operation %d", m.Operation)
+			}
+			w.line(&buf, op, m.Type)
+			for _, v := range m.Values {
+				w.line(&buf, m.Type, v)
+			}
+			buf.WriteString("-\n")
+		}
+	case ModRDN, ModDN:
+		w.line(&buf, "newrdn", rec.NewRDN)
+		deleteOldRDN := "0"
+		if rec.DeleteOldRDN {
+			deleteOldRDN = "1"
+		}
+		w.line(&buf, "deleteoldrdn", deleteOldRDN)
+		if rec.NewSuperior != "" {
+			w.line(&buf, "newsuperior", rec.NewSuperior)
+		}
+	default:
+		return fmt.Errorf("ldif: unknown changetype %q", rec.ChangeType)
+	}
+
+	_, err := w.w.Write(buf.Bytes())
+	return err
+}
+
+var modifyOperationNames = map[ldap.ModifyOperation]string{
+	ldap.AddValues:      "add",
+	ldap.DeleteValues:   "delete",
+	ldap.ReplaceValues:  "replace",
+	ldap.IncrementValue: "increment",
+}
+
+func (w *Writer) control(buf *bytes.Buffer, c Control) {
+	spec := c.OID
+	if c.Criticality {
+		spec += " true"
+	} else {
+		spec += " false"
+	}
+	switch {
+	case c.Value == nil:
+	case safeString(string(c.Value)):
+		spec += ": " + string(c.Value)
+	default:
+		spec += ":: " + base64.StdEncoding.EncodeToString(c.Value)
+	}
+	w.fold(buf, "control: "+spec)
+}
+
+func (w *Writer) line(buf *bytes.Buffer, name, value string) {
+	if safeString(value) {
+		w.fold(buf, name+": "+value)
+	} else {
+		w.fold(buf, name+":: "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+}
+
+// fold writes s, continuing it on lines starting with a space if it is
+// longer than w.Width.
+func (w *Writer) fold(buf *bytes.Buffer, s string) {
+	width := w.Width
+	if width <= 1 || len(s) <= width {
+		buf.WriteString(s + "\n")
+		return
+	}
+	buf.WriteString(s[:width] + "\n")
+	for s = s[width:]; len(s) > 0; {
+		n := width - 1
+		if n > len(s) {
+			n = len(s)
+		}
+		buf.WriteString(" " + s[:n] + "\n")
+		s = s[n:]
+	}
+}
+
+// safeString reports whether s can be written as is, rather than in
+// base64: RFC 2849 SAFE-STRING, and no trailing space.
+func safeString(s string) bool {
+	if s == "" {
+		return true
+	}
+	switch s[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if s[len(s)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == 0 || c == '\n' || c == '\r' || c > 127 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// opNames names the operations by the tags of their requests and
+// responses.
+var opNames = map[int]string{
+	0: "bind", 1: "bind",
+	2: "unbind",
+	3: "search", 4: "search", 5: "search", 19: "search",
+	6: "modify", 7: "modify",
+	8: "add", 9: "add",
+	10: "delete", 11: "delete",
+	12: "modifyDN", 13: "modifyDN",
+	14: "compare", 15: "compare",
+	16: "abandon",
+	23: "extended", 24: "extended",
+	25: "intermediate",
+}
+
+func logDial(logger *slog.Logger, network, addr string, start time.Time, err error) {
+	if logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("network", network),
+		slog.String("addr", addr),
+		slog.Duration("duration", time.Since(start)),
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(context.Background(), level, "ldap dial", attrs...)
+}
+
+// logRequest logs a request as it is sent. Passwords and SASL
+// credentials are redacted.
+func (s *session) logRequest(id int, op interface{}, controls []Control) {
+	if s.logger == nil {
+		return
+	}
+	attrs := []slog.Attr{slog.Int("msgid", id)}
+	if ov, ok := op.(asn1.OptionValue); ok {
+		attrs = append(attrs, slog.String("op", opNames[opTag(op)]))
+		attrs = append(attrs, s.requestAttrs(ov.Value)...)
+	}
+	if len(controls) > 0 {
+		types := make([]string, len(controls))
+		for i, c := range controls {
+			types[i] = c.ControlType()
+		}
+		attrs = append(attrs, slog.String("controls", strings.Join(types, ",")))
+	}
+	s.logger.LogAttrs(context.Background(), slog.LevelDebug, "ldap request", attrs...)
+}
+
+// requestAttrs describes a request for logs and traces.
+func (s *session) requestAttrs(v interface{}) []slog.Attr {
+	switch v := v.(type) {
+	case bindRequest:
+		attrs := []slog.Attr{slog.String("dn", string(v.Name))}
+		auth, _ := v.Auth.(asn1.OptionValue)
+		switch creds := auth.Value.(type) {
+		case saslCredentials:
+			attrs = append(attrs, slog.String("mechanism", string(creds.Mechanism)))
+			if len(creds.Credentials) > 0 {
+				attrs = append(attrs, slog.String("credentials", "REDACTED"))
+			}
+		case []byte:
+			attrs = append(attrs, slog.String("auth", "simple"))
+			if len(creds) > 0 {
+				attrs = append(attrs, slog.String("password", "REDACTED"))
+			}
+		default:
+			attrs = append(attrs, slog.String("credentials", "REDACTED"))
+		}
+		return attrs
+	case SearchRequest:
+		filter, _ := DecompileFilter(v.Filter)
+		if s.hashFilter {
+			filter = hashFilter(filter)
+		}
+		return []slog.Attr{
+			slog.String("dn", string(v.BaseObject)),
+			slog.Int("scope", int(v.Scope)),
+			slog.String("filter", filter),
+		}
+	case []byte:
+		return []slog.Attr{slog.String("dn", string(v))}
+	case addRequest:
+		return []slog.Attr{slog.String("dn", string(v.Entry))}
+	case modifyRequest:
+		return []slog.Attr{slog.String("dn", string(v.Object))}
+	case modifyDNRequest:
+		return []slog.Attr{slog.String("dn", string(v.Entry)), slog.String("new_rdn", string(v.NewRDN))}
+	case compareRequest:
+		return []slog.Attr{slog.String("dn", string(v.Entry)), slog.String("attr", string(v.Ava.Desc))}
+	case extendedRequest:
+		return []slog.Attr{slog.String("name", string(v.Name))}
+	case int:
+		return []slog.Attr{slog.Int("abandon", v)}
+	}
+	return nil
+}
+
+// observe logs the final response to a request, reports it to the
+// metrics collector and records it for the request's span, with its result code and the time since the
+// request was made. The entries and references of a search are counted
+// rather than logged.
+func (s *session) observe(id int, p *pendingRequest, raw asn1.RawValue) {
+	if s.logger == nil && s.metrics == nil && s.tracer == nil || p == nil {
+		return
+	}
+	switch raw.Tag {
+	case 4, 19:
+		p.entries++
+		return
+	case 25:
+		return
+	}
+	d := time.Since(p.start)
+	var r ldapResult
+	err := decodeOp(raw, fmt.Sprintf("application,tag:%d", raw.Tag), &r)
+	if err == nil && p.span != nil {
+		p.mu.Lock()
+		p.responded, p.result = true, r.ResultCode
+		p.mu.Unlock()
+	}
+	if err == nil && s.metrics != nil {
+		s.metrics.Operation(opNames[raw.Tag], r.ResultCode, d)
+	}
+	if s.logger == nil {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.Int("msgid", id),
+		slog.String("op", opNames[raw.Tag]),
+	}
+	level := slog.LevelInfo
+	if err == nil {
+		attrs = append(attrs, slog.Int("result", int(r.ResultCode)))
+		if len(r.Message) > 0 {
+			attrs = append(attrs, slog.String("message", string(r.Message)))
+		}
+		switch r.ResultCode {
+		case Success, CompareFalse, CompareTrue, SaslBindInProgress:
+		default:
+			level = slog.LevelWarn
+		}
+	}
+	if raw.Tag == 5 {
+		attrs = append(attrs, slog.Int("entries", p.entries))
+	}
+	attrs = append(attrs, slog.Duration("duration", d))
+	s.logger.LogAttrs(context.Background(), level, "ldap response", attrs...)
+}
@@ -0,0 +1,56 @@
+package ldap
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{Logger: logger})
+	defer c.Close()
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:1", ldapResult{ResultCode: InvalidCredentials, MatchedDN: []byte{}, Message: []byte{}})
+		m, _ = readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:4", struct {
+			Name       []byte
+			Attributes []partialAttribute
+		}{[]byte("cn=a"), []partialAttribute{}})
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+	c.Bind("cn=admin", "secret")
+	c.Search(SearchRequest{BaseObject: []byte("dc=example"), Filter: Present("objectClass")})
+
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("Password logged: %s", buf.String())
+	}
+	expected := []map[string]interface{}{
+		{"level": "DEBUG", "msg": "ldap request", "msgid": 1.0, "op": "bind", "dn": "cn=admin", "password": "REDACTED"},
+		{"level": "WARN", "msg": "ldap response", "msgid": 1.0, "op": "bind", "result": 49.0},
+		{"level": "DEBUG", "msg": "ldap request", "msgid": 2.0, "op": "search", "dn": "dc=example", "filter": "(objectClass=*)"},
+		{"level": "INFO", "msg": "ldap response", "msgid": 2.0, "op": "search", "result": 0.0, "entries": 1.0},
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Bad log: %s (expected %d records)", buf.String(), len(expected))
+	}
+	for i, line := range lines {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range expected[i] {
+			if record[k] != v {
+				t.Errorf("#%d: Bad %s: %v (expected %v)", i, k, record[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package ldap
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// A MatchingRule defines how the values of an attribute are compared
+// when evaluating filters locally.
+type MatchingRule interface {
+	// Normalize returns the canonical form of a value. Two values match
+	// for equality when their canonical forms are identical, and
+	// substring assertions are evaluated against canonical forms.
+	Normalize(value string) (string, error)
+	// Compare orders two canonical values, returning -1, 0 or 1.
+	Compare(a, b string) int
+}
+
+var (
+	CaseIgnoreMatch  MatchingRule = caseIgnoreMatch{}
+	CaseExactMatch   MatchingRule = caseExactMatch{}
+	OctetStringMatch MatchingRule = octetStringMatch{}
+	IntegerMatch     MatchingRule = integerMatch{}
+	BooleanMatch     MatchingRule = booleanMatch{}
+
+	DistinguishedNameMatch MatchingRule = distinguishedNameMatch{}
+	GeneralizedTimeMatch   MatchingRule = generalizedTimeMatch{}
+	TelephoneNumberMatch   MatchingRule = telephoneNumberMatch{}
+	ObjectIdentifierMatch  MatchingRule = objectIdentifierMatch{}
+)
+
+// matchingRules maps the names and OIDs usable in extensible match
+// filters to rules, keyed in lower case.
+var matchingRules = map[string]MatchingRule{
+	"caseignorematch":                CaseIgnoreMatch,
+	"2.5.13.2":                       CaseIgnoreMatch,
+	"caseignoreorderingmatch":        CaseIgnoreMatch,
+	"2.5.13.3":                       CaseIgnoreMatch,
+	"caseignoresubstringsmatch":      CaseIgnoreMatch,
+	"2.5.13.4":                       CaseIgnoreMatch,
+	"caseignoreia5match":             CaseIgnoreMatch,
+	"1.3.6.1.4.1.1466.109.114.2":     CaseIgnoreMatch,
+	"caseignoreia5substringsmatch":   CaseIgnoreMatch,
+	"1.3.6.1.4.1.1466.109.114.3":     CaseIgnoreMatch,
+	"caseexactmatch":                 CaseExactMatch,
+	"2.5.13.5":                       CaseExactMatch,
+	"caseexactorderingmatch":         CaseExactMatch,
+	"2.5.13.6":                       CaseExactMatch,
+	"caseexactsubstringsmatch":       CaseExactMatch,
+	"2.5.13.7":                       CaseExactMatch,
+	"caseexactia5match":              CaseExactMatch,
+	"1.3.6.1.4.1.1466.109.114.1":     CaseExactMatch,
+	"octetstringmatch":               OctetStringMatch,
+	"2.5.13.17":                      OctetStringMatch,
+	"octetstringorderingmatch":       OctetStringMatch,
+	"2.5.13.18":                      OctetStringMatch,
+	"integermatch":                   IntegerMatch,
+	"2.5.13.14":                      IntegerMatch,
+	"integerorderingmatch":           IntegerMatch,
+	"2.5.13.15":                      IntegerMatch,
+	"booleanmatch":                   BooleanMatch,
+	"2.5.13.13":                      BooleanMatch,
+	"numericstringmatch":             numericStringMatch{},
+	"2.5.13.8":                       numericStringMatch{},
+	"numericstringorderingmatch":     numericStringMatch{},
+	"2.5.13.9":                       numericStringMatch{},
+	"numericstringsubstringsmatch":   numericStringMatch{},
+	"2.5.13.10":                      numericStringMatch{},
+	"distinguishednamematch":         DistinguishedNameMatch,
+	"2.5.13.1":                       DistinguishedNameMatch,
+	"generalizedtimematch":           GeneralizedTimeMatch,
+	"2.5.13.27":                      GeneralizedTimeMatch,
+	"generalizedtimeorderingmatch":   GeneralizedTimeMatch,
+	"2.5.13.28":                      GeneralizedTimeMatch,
+	"telephonenumbermatch":           TelephoneNumberMatch,
+	"2.5.13.20":                      TelephoneNumberMatch,
+	"telephonenumbersubstringsmatch": TelephoneNumberMatch,
+	"2.5.13.21":                      TelephoneNumberMatch,
+	"objectidentifiermatch":          ObjectIdentifierMatch,
+	"2.5.13.0":                       ObjectIdentifierMatch,
+}
+
+// LookupMatchingRule returns the rule with the given name or OID.
+func LookupMatchingRule(name string) (MatchingRule, bool) {
+	rule, ok := matchingRules[strings.ToLower(name)]
+	return rule, ok
+}
+
+// collapseSpace applies the insignificant space handling of RFC 4518
+// §2.6.1: leading and trailing spaces are dropped and inner runs of
+// spaces become one.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+type caseIgnoreMatch struct{}
+
+func (caseIgnoreMatch) Normalize(v string) (string, error) {
+	return strings.ToLower(collapseSpace(v)), nil
+}
+func (caseIgnoreMatch) Compare(a, b string) int { return strings.Compare(a, b) }
+
+type caseExactMatch struct{}
+
+func (caseExactMatch) Normalize(v string) (string, error) { return collapseSpace(v), nil }
+func (caseExactMatch) Compare(a, b string) int            { return strings.Compare(a, b) }
+
+type octetStringMatch struct{}
+
+func (octetStringMatch) Normalize(v string) (string, error) { return v, nil }
+func (octetStringMatch) Compare(a, b string) int            { return strings.Compare(a, b) }
+
+type numericStringMatch struct{}
+
+func (numericStringMatch) Normalize(v string) (string, error) {
+	return strings.Replace(v, " ", "", -1), nil
+}
+func (numericStringMatch) Compare(a, b string) int { return strings.Compare(a, b) }
+
+type integerMatch struct{}
+
+func (integerMatch) Normalize(v string) (string, error) {
+	n, ok := new(big.Int).SetString(strings.TrimSpace(v), 10)
+	if !ok {
+		return "", fmt.Errorf("invalid integer %q", v)
+	}
+	return n.String(), nil
+}
+
+func (integerMatch) Compare(a, b string) int {
+	x, _ := new(big.Int).SetString(a, 10)
+	y, _ := new(big.Int).SetString(b, 10)
+	if x == nil || y == nil {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
+
+type booleanMatch struct{}
+
+func (booleanMatch) Normalize(v string) (string, error) {
+	if _, err := ParseBoolean(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+func (booleanMatch) Compare(a, b string) int { return strings.Compare(a, b) }
+
+// distinguishedNameMatch compares DNs RDN by RDN, ignoring case and
+// insignificant spaces in values and the order of the values of
+// multi-valued RDNs.
+type distinguishedNameMatch struct{}
+
+func (distinguishedNameMatch) Normalize(v string) (string, error) {
+	dn, err := ParseDN(v)
+	if err != nil {
+		return "", err
+	}
+	for _, rdn := range dn {
+		for i := range rdn {
+			rdn[i].Type = strings.ToLower(rdn[i].Type)
+			rdn[i].Value = strings.ToLower(collapseSpace(rdn[i].Value))
+		}
+		sort.Slice(rdn, func(i, j int) bool {
+			if rdn[i].Type != rdn[j].Type {
+				return rdn[i].Type < rdn[j].Type
+			}
+			return rdn[i].Value < rdn[j].Value
+		})
+	}
+	return dn.String(), nil
+}
+func (distinguishedNameMatch) Compare(a, b string) int { return strings.Compare(a, b) }
+
+// generalizedTimeMatch compares the instants that GeneralizedTime values
+// denote, whatever their time zone and precision.
+type generalizedTimeMatch struct{}
+
+func (generalizedTimeMatch) Normalize(v string) (string, error) {
+	t, err := ParseGeneralizedTime(v)
+	if err != nil {
+		return "", err
+	}
+	return FormatGeneralizedTime(t), nil
+}
+
+func (generalizedTimeMatch) Compare(a, b string) int {
+	x, errx := ParseGeneralizedTime(a)
+	y, erry := ParseGeneralizedTime(b)
+	switch {
+	case errx != nil || erry != nil:
+		return strings.Compare(a, b)
+	case x.Before(y):
+		return -1
+	case x.After(y):
+		return 1
+	}
+	return 0
+}
+
+type telephoneNumberMatch struct{}
+
+func (telephoneNumberMatch) Normalize(v string) (string, error) {
+	return strings.ToLower(NormalizeTelephoneNumber(v)), nil
+}
+func (telephoneNumberMatch) Compare(a, b string) int { return strings.Compare(a, b) }
+
+// objectIdentifierMatch compares OIDs, and descriptors without regard to
+// case. Without a schema it cannot tell that a descriptor and an OID
+// name the same thing.
+type objectIdentifierMatch struct{}
+
+func (objectIdentifierMatch) Normalize(v string) (string, error) {
+	if err := validateOID(strings.TrimSpace(v)); err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(v)), nil
+}
+func (objectIdentifierMatch) Compare(a, b string) int { return strings.Compare(a, b) }
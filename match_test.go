@@ -0,0 +1,63 @@
+package ldap
+
+import (
+	"testing"
+)
+
+func TestMatchingRules(t *testing.T) {
+	tests := []struct {
+		rule  string
+		a, b  string
+		order int
+	}{
+		{"caseIgnoreMatch", "Hello  World", "hello world", 0},
+		{"caseExactMatch", "Hello", "hello", -1},
+		{"distinguishedNameMatch", "CN=John  Smith, DC=Example", "cn=john smith,dc=example", 0},
+		{"2.5.13.1", "cn=a+sn=b,dc=x", "SN=B+CN=A,dc=x", 0},
+		{"distinguishedNameMatch", "cn=a,dc=x", "cn=b,dc=x", -1},
+		{"generalizedTimeMatch", "20150102030405Z", "20150102040405+0100", 0},
+		{"generalizedTimeOrderingMatch", "20150102030405.5Z", "20150102030405Z", 1},
+		{"generalizedTimeOrderingMatch", "201501020304Z", "20150102030405Z", -1},
+		{"telephoneNumberMatch", "+1 555-0100", "+15550100", 0},
+		{"integerOrderingMatch", "9", "10", -1},
+		{"objectIdentifierMatch", "Person", "person", 0},
+	}
+	for i, test := range tests {
+		rule, ok := LookupMatchingRule(test.rule)
+		if !ok {
+			t.Errorf("#%d: No rule %q", i, test.rule)
+			continue
+		}
+		a, err := rule.Normalize(test.a)
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		b, err := rule.Normalize(test.b)
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		if order := rule.Compare(a, b); order != test.order {
+			t.Errorf("#%d: Bad result: %d comparing %q and %q (expected %d)", i, order, a, b, test.order)
+		}
+	}
+}
+
+func TestMatchingRuleInvalidValues(t *testing.T) {
+	tests := []struct {
+		rule  MatchingRule
+		value string
+	}{
+		{DistinguishedNameMatch, "not a dn"},
+		{GeneralizedTimeMatch, "yesterday"},
+		{IntegerMatch, "ten"},
+		{BooleanMatch, "yes"},
+		{ObjectIdentifierMatch, "1..2"},
+	}
+	for i, test := range tests {
+		if _, err := test.rule.Normalize(test.value); err == nil {
+			t.Errorf("#%d: Expected an error normalizing %q", i, test.value)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ldap
+
+import (
+	"encoding/binary"
+)
+
+// md4Sum computes the MD4 digest of msg (RFC 1320). MD4 is broken and
+// only present because the NTLM password hash is defined in terms of it.
+func md4Sum(msg []byte) []byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	buf := make([]byte, len(msg), len(msg)+72)
+	copy(buf, msg)
+	buf = append(buf, 0x80)
+	for len(buf)%64 != 56 {
+		buf = append(buf, 0)
+	}
+	var length [8]byte
+	binary.LittleEndian.PutUint64(length[:], uint64(len(msg))*8)
+	buf = append(buf, length[:]...)
+
+	rotl := func(x uint32, s uint) uint32 { return x<<s | x>>(32-s) }
+	shifts := [3][4]uint{{3, 7, 11, 19}, {3, 5, 9, 13}, {3, 9, 11, 15}}
+	order := [3][16]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+		{0, 4, 8, 12, 1, 5, 9, 13, 2, 6, 10, 14, 3, 7, 11, 15},
+		{0, 8, 4, 12, 2, 10, 6, 14, 1, 9, 5, 13, 3, 11, 7, 15},
+	}
+
+	var x [16]uint32
+	for len(buf) > 0 {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(buf[4*i:])
+		}
+		buf = buf[64:]
+
+		aa, bb, cc, dd := a, b, c, d
+		for round := 0; round < 3; round++ {
+			for i, k := range order[round] {
+				var f, add uint32
+				switch round {
+				case 0:
+					f = b&c | ^b&d
+				case 1:
+					f, add = b&c|b&d|c&d, 0x5a827999
+				case 2:
+					f, add = b^c^d, 0x6ed9eba1
+				}
+				t := rotl(a+f+x[k]+add, shifts[round][i%4])
+				a, b, c, d = d, t, b, c
+			}
+		}
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+
+	out := make([]byte, 16)
+	binary.LittleEndian.PutUint32(out[0:], a)
+	binary.LittleEndian.PutUint32(out[4:], b)
+	binary.LittleEndian.PutUint32(out[8:], c)
+	binary.LittleEndian.PutUint32(out[12:], d)
+	return out
+}
@@ -0,0 +1,200 @@
+package ldap
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MetricsCollector receives measurements of the traffic of the
+// connections and pools it is set on with DialOpts.Metrics and
+// PoolOptions.Metrics. Its methods are called concurrently, and must
+// not block.
+type MetricsCollector interface {
+	// Operation is called when the response that completes an
+	// operation arrives, with the name of the operation, e.g. "bind"
+	// or "search", its result code and the time since the request.
+	Operation(op string, code ResultCode, d time.Duration)
+	// BytesSent and BytesReceived are called with the number of bytes
+	// written to and read from a connection, as they are, including
+	// the overhead of TLS.
+	BytesSent(n int)
+	BytesReceived(n int)
+	// Pool is called when the connections of a pool change, with the
+	// number handed out by Get and the number waiting to be.
+	Pool(inUse, idle int)
+}
+
+// meteredConn reports the bytes passing through a connection.
+type meteredConn struct {
+	net.Conn
+	metrics MetricsCollector
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.metrics.BytesReceived(n)
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.metrics.BytesSent(n)
+	}
+	return n, err
+}
+
+// DefaultBuckets are the upper bounds, in seconds, of the operation
+// latency histogram of PrometheusMetrics.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics is a MetricsCollector that serves what it collects
+// in the Prometheus text exposition format, as these metrics:
+//
+//	ldap_operations_total{op, result}      counter
+//	ldap_operation_duration_seconds{op}    histogram
+//	ldap_sent_bytes_total                  counter
+//	ldap_received_bytes_total              counter
+//	ldap_pool_connections{state}           gauge, state is in_use or idle
+//
+// Register it with a Prometheus client's registry through its text
+// format, or serve it directly as an http.Handler. A zero
+// PrometheusMetrics is ready to use.
+type PrometheusMetrics struct {
+	// Buckets are the upper bounds of the latency histogram, in
+	// seconds and increasing. If nil, DefaultBuckets are used.
+	Buckets []float64
+
+	mu           sync.Mutex
+	operations   map[[2]string]uint64
+	durations    map[string]*histogram
+	sent         uint64
+	received     uint64
+	inUse, idle  int
+	poolReported bool
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (m *PrometheusMetrics) Operation(op string, code ResultCode, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.operations == nil {
+		m.operations = map[[2]string]uint64{}
+		m.durations = map[string]*histogram{}
+	}
+	m.operations[[2]string{op, code.String()}]++
+	buckets := m.buckets()
+	h := m.durations[op]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(buckets))}
+		m.durations[op] = h
+	}
+	s := d.Seconds()
+	if i := sort.SearchFloat64s(buckets, s); i < len(buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += s
+}
+
+func (m *PrometheusMetrics) buckets() []float64 {
+	if m.Buckets != nil {
+		return m.Buckets
+	}
+	return DefaultBuckets
+}
+
+func (m *PrometheusMetrics) BytesSent(n int) {
+	m.mu.Lock()
+	m.sent += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) BytesReceived(n int) {
+	m.mu.Lock()
+	m.received += uint64(n)
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) Pool(inUse, idle int) {
+	m.mu.Lock()
+	m.inUse, m.idle, m.poolReported = inUse, idle, true
+	m.mu.Unlock()
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition
+// format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.mu.Lock()
+	b.WriteString("# HELP ldap_operations_total LDAP operations completed, by operation and result.\n")
+	b.WriteString("# TYPE ldap_operations_total counter\n")
+	keys := make([][2]string, 0, len(m.operations))
+	for k := range m.operations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "ldap_operations_total{op=%q,result=%q} %d\n", k[0], k[1], m.operations[k])
+	}
+
+	b.WriteString("# HELP ldap_operation_duration_seconds Latency of LDAP operations.\n")
+	b.WriteString("# TYPE ldap_operation_duration_seconds histogram\n")
+	ops := make([]string, 0, len(m.durations))
+	for op := range m.durations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := m.durations[op]
+		var cumulative uint64
+		for i, le := range m.buckets() {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "ldap_operation_duration_seconds_bucket{op=%q,le=%q} %d\n",
+				op, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "ldap_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.count)
+		fmt.Fprintf(&b, "ldap_operation_duration_seconds_sum{op=%q} %s\n", op, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "ldap_operation_duration_seconds_count{op=%q} %d\n", op, h.count)
+	}
+
+	b.WriteString("# HELP ldap_sent_bytes_total Bytes written to LDAP connections.\n")
+	b.WriteString("# TYPE ldap_sent_bytes_total counter\n")
+	fmt.Fprintf(&b, "ldap_sent_bytes_total %d\n", m.sent)
+	b.WriteString("# HELP ldap_received_bytes_total Bytes read from LDAP connections.\n")
+	b.WriteString("# TYPE ldap_received_bytes_total counter\n")
+	fmt.Fprintf(&b, "ldap_received_bytes_total %d\n", m.received)
+
+	if m.poolReported {
+		b.WriteString("# HELP ldap_pool_connections Connections of the LDAP pool, by state.\n")
+		b.WriteString("# TYPE ldap_pool_connections gauge\n")
+		fmt.Fprintf(&b, "ldap_pool_connections{state=\"in_use\"} %d\n", m.inUse)
+		fmt.Fprintf(&b, "ldap_pool_connections{state=\"idle\"} %d\n", m.idle)
+	}
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics, for scraping by Prometheus.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
@@ -0,0 +1,67 @@
+package ldap
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := &PrometheusMetrics{Buckets: []float64{.5, 1}}
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{Metrics: m})
+	defer c.Close()
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:1", ldapResult{ResultCode: InvalidCredentials, MatchedDN: []byte{}, Message: []byte{}})
+		m, _ = readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:11", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+	c.Bind("cn=admin", "secret")
+	c.Del("cn=x")
+	m.Operation("search", Success, 750*time.Millisecond)
+
+	pool, err := NewPool(func() (Conn, error) {
+		client, _ := net.Pipe()
+		return newConn(client), nil
+	}, PoolOptions{Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	pc, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	m.WriteTo(&buf)
+	out := buf.String()
+	for _, expected := range []string{
+		`ldap_operations_total{op="bind",result="invalidCredentials"} 1`,
+		`ldap_operations_total{op="delete",result="success"} 1`,
+		`ldap_operation_duration_seconds_bucket{op="search",le="0.5"} 0`,
+		`ldap_operation_duration_seconds_bucket{op="search",le="1"} 1`,
+		`ldap_operation_duration_seconds_bucket{op="search",le="+Inf"} 1`,
+		`ldap_operation_duration_seconds_sum{op="search"} 0.75`,
+		`ldap_pool_connections{state="in_use"} 1`,
+		`ldap_pool_connections{state="idle"} 0`,
+	} {
+		if !strings.Contains(out, expected+"\n") {
+			t.Errorf("Missing %s in:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "ldap_sent_bytes_total 0\n") || strings.Contains(out, "ldap_received_bytes_total 0\n") {
+		t.Errorf("Bytes not counted:\n%s", out)
+	}
+
+	pool.Put(pc)
+	buf.Reset()
+	m.WriteTo(&buf)
+	if !strings.Contains(buf.String(), `ldap_pool_connections{state="idle"} 1`) {
+		t.Errorf("Bad pool gauge after Put:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,176 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+)
+
+type Attribute struct {
+	Type   string
+	Values []string
+}
+
+type ModifyOperation int
+
+const (
+	AddValues     ModifyOperation = 0
+	DeleteValues  ModifyOperation = 1
+	ReplaceValues ModifyOperation = 2
+	// IncrementValue is defined by RFC 4525.
+	IncrementValue ModifyOperation = 3
+)
+
+type Modification struct {
+	Operation ModifyOperation
+	Attribute
+}
+
+type partialAttribute struct {
+	Type   []byte
+	Values [][]byte `asn1:"set"`
+}
+
+func encodeAttribute(a Attribute) partialAttribute {
+	pa := partialAttribute{Type: []byte(a.Type), Values: [][]byte{}}
+	for _, v := range a.Values {
+		pa.Values = append(pa.Values, []byte(v))
+	}
+	return pa
+}
+
+type addRequest struct {
+	Entry      []byte
+	Attributes []partialAttribute
+}
+
+// Add creates the entry named by dn with the given attributes.
+func (l *conn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	req := addRequest{Entry: []byte(dn), Attributes: []partialAttribute{}}
+	for _, a := range attrs {
+		req.Attributes = append(req.Attributes, encodeAttribute(a))
+	}
+	op := asn1.OptionValue{Opts: "application,tag:8", Value: req}
+	_, err := l.request(op, "application,tag:9", controls)
+	return err
+}
+
+// Ensure makes the entry named by entry.DN have the values of entry's
+// attributes, adding the entry if it does not exist or else modifying
+// those of its attributes whose values differ, compared exactly. Other
+// attributes are left alone, and one given without values is removed.
+// Ensure reports whether it changed the directory.
+func (l *conn) Ensure(entry *Entry) (bool, error) {
+	return ensure(l, entry)
+}
+
+func ensure(c Conn, entry *Entry) (bool, error) {
+	names := []string{"1.1"}
+	for _, a := range entry.Attributes {
+		names = append(names, a.Name)
+	}
+	current, err := getEntry(c, entry.DN, names)
+	if errors.Is(err, ErrNotFound) {
+		var attrs []Attribute
+		for _, a := range entry.Attributes {
+			if len(a.Values) > 0 {
+				attrs = append(attrs, Attribute{Type: a.Name, Values: a.Values})
+			}
+		}
+		err := c.Add(entry.DN, attrs)
+		return err == nil, err
+	} else if err != nil {
+		return false, err
+	}
+
+	// Compare only the attributes asked for, not subtypes of them the
+	// server returned too.
+	old := &Entry{DN: current.DN}
+	for _, a := range entry.Attributes {
+		if o := current.GetAttribute(a.Name); o != nil {
+			old.Attributes = append(old.Attributes, o)
+		}
+	}
+	mods := DiffEntry(old, entry, nil)
+	if len(mods) == 0 {
+		return false, nil
+	}
+	err = c.Modify(entry.DN, mods)
+	return err == nil, err
+}
+
+type change struct {
+	Operation    ModifyOperation `asn1:"enum"`
+	Modification partialAttribute
+}
+
+type modifyRequest struct {
+	Object  []byte
+	Changes []change
+}
+
+// Modify applies mods to the entry named by dn. The server applies them
+// in order and atomically: either all succeed or none do.
+func (l *conn) Modify(dn string, mods []Modification, controls ...Control) error {
+	req := modifyRequest{Object: []byte(dn), Changes: []change{}}
+	for _, m := range mods {
+		req.Changes = append(req.Changes, change{m.Operation, encodeAttribute(m.Attribute)})
+	}
+	op := asn1.OptionValue{Opts: "application,tag:6", Value: req}
+	_, err := l.request(op, "application,tag:7", controls)
+	return err
+}
+
+type modifyDNRequest struct {
+	Entry        []byte
+	NewRDN       []byte
+	DeleteOldRDN bool
+	NewSuperior  []byte `asn1:"tag:0,optional"`
+}
+
+// ModifyDN renames the entry named by dn to newRDN, moving it below
+// newSuperior unless that is empty.
+func (l *conn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error {
+	req := modifyDNRequest{
+		Entry:        []byte(dn),
+		NewRDN:       []byte(newRDN),
+		DeleteOldRDN: deleteOldRDN,
+		NewSuperior:  optionalBytes(newSuperior),
+	}
+	op := asn1.OptionValue{Opts: "application,tag:12", Value: req}
+	_, err := l.request(op, "application,tag:13", controls)
+	return err
+}
+
+type compareRequest struct {
+	Entry []byte
+	Ava   struct {
+		Desc  []byte
+		Value []byte
+	}
+}
+
+// Compare reports whether the entry named by dn has the given value for
+// attr, using the attribute's equality matching rule on the server.
+func (l *conn) Compare(dn, attr, value string, controls ...Control) (bool, error) {
+	req := compareRequest{Entry: []byte(dn)}
+	req.Ava.Desc = []byte(attr)
+	req.Ava.Value = []byte(value)
+	op := asn1.OptionValue{Opts: "application,tag:14", Value: req}
+
+	raw, _, err := l.roundTrip(op, controls)
+	if err != nil {
+		return false, err
+	}
+	var r ldapResult
+	if err := decodeOp(raw, "application,tag:15", &r); err != nil {
+		return false, fmt.Errorf("Decode: %v", err)
+	}
+	switch r.ResultCode {
+	case CompareTrue:
+		return true, nil
+	case CompareFalse:
+		return false, nil
+	}
+	return false, r.toError()
+}
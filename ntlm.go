@@ -0,0 +1,341 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLMCredentials identify an account for NTLM authentication. When
+// Hash, the 16 byte NT hash of the password, is set it is used instead
+// of Password ("pass-the-hash").
+type NTLMCredentials struct {
+	Domain      string
+	Username    string
+	Password    string
+	Hash        []byte
+	Workstation string
+}
+
+const ( // NTLM negotiate flags (MS-NLMP §2.2.2.5)
+	ntlmNegotiateUnicode                 = 0x00000001
+	ntlmRequestTarget                    = 0x00000004
+	ntlmNegotiateNTLM                    = 0x00000200
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo              = 0x00800000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiate56                      = 0x80000000
+
+	ntlmDefaultFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSessionSecurity |
+		ntlmNegotiateTargetInfo | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+const ( // AV pair IDs (MS-NLMP §2.2.2.1)
+	ntlmAvEOL             = 0x0000
+	ntlmAvTimestamp       = 0x0007
+	ntlmAvChannelBindings = 0x000a
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+func ntlmNegotiateMessage() []byte {
+	b := make([]byte, 32)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 1)
+	binary.LittleEndian.PutUint32(b[12:], ntlmDefaultFlags)
+	return b
+}
+
+type ntlmChallenge struct {
+	flags      uint32
+	challenge  []byte
+	targetInfo []byte
+}
+
+func parseNTLMChallenge(b []byte) (*ntlmChallenge, error) {
+	if len(b) < 48 || !bytes.Equal(b[:8], ntlmSignature) || binary.LittleEndian.Uint32(b[8:]) != 2 {
+		return nil, fmt.Errorf("NTLM: malformed challenge message")
+	}
+	c := &ntlmChallenge{
+		flags:     binary.LittleEndian.Uint32(b[20:]),
+		challenge: b[24:32],
+	}
+	length := int(binary.LittleEndian.Uint16(b[40:]))
+	offset := int(binary.LittleEndian.Uint32(b[44:]))
+	if offset+length > len(b) {
+		return nil, fmt.Errorf("NTLM: target info out of range")
+	}
+	c.targetInfo = b[offset : offset+length]
+	return c, nil
+}
+
+func ntlmUnicode(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, r := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], r)
+	}
+	return b
+}
+
+func ntlmHMAC(key []byte, data ...[]byte) []byte {
+	h := hmac.New(md5.New, key)
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// ntowfv2 derives the NTLMv2 response key from the NT hash.
+func ntowfv2(ntHash []byte, user, domain string) []byte {
+	return ntlmHMAC(ntHash, ntlmUnicode(strings.ToUpper(user)+domain))
+}
+
+func (c NTLMCredentials) ntHash() []byte {
+	if c.Hash != nil {
+		return c.Hash
+	}
+	return md4Sum(ntlmUnicode(c.Password))
+}
+
+// ntlmAuthenticate computes the NTLMv2 authenticate message answering
+// challenge. A non-nil channelBinding, as returned by
+// Conn.ChannelBinding for tls-server-end-point, is folded into the
+// response so servers enforcing channel binding accept it.
+func ntlmAuthenticate(creds NTLMCredentials, challenge *ntlmChallenge, channelBinding []byte) ([]byte, error) {
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+
+	targetInfo, timestamp := ntlmTargetInfo(challenge.targetInfo, channelBinding)
+	if timestamp == nil {
+		timestamp = make([]byte, 8)
+		ft := uint64(time.Now().UnixNano()/100) + 116444736000000000
+		binary.LittleEndian.PutUint64(timestamp, ft)
+	}
+
+	key := ntowfv2(creds.ntHash(), creds.Username, creds.Domain)
+	ntResponse, lmResponse := ntlmv2Response(key, challenge.challenge, clientChallenge, timestamp, targetInfo)
+
+	fields := [][]byte{
+		lmResponse,
+		ntResponse,
+		ntlmUnicode(creds.Domain),
+		ntlmUnicode(creds.Username),
+		ntlmUnicode(creds.Workstation),
+		nil, // EncryptedRandomSessionKey
+	}
+	const headerLen = 64
+	b := make([]byte, headerLen)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 3)
+	for i, f := range fields {
+		pos := 12 + 8*i
+		binary.LittleEndian.PutUint16(b[pos:], uint16(len(f)))
+		binary.LittleEndian.PutUint16(b[pos+2:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(b[pos+4:], uint32(len(b)))
+		b = append(b, f...)
+	}
+	binary.LittleEndian.PutUint32(b[60:], challenge.flags&ntlmDefaultFlags)
+	return b, nil
+}
+
+func ntlmv2Response(key, serverChallenge, clientChallenge, timestamp, targetInfo []byte) (nt, lm []byte) {
+	var temp bytes.Buffer
+	temp.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	temp.Write(timestamp)
+	temp.Write(clientChallenge)
+	temp.Write([]byte{0, 0, 0, 0})
+	temp.Write(targetInfo)
+	temp.Write([]byte{0, 0, 0, 0})
+
+	nt = append(ntlmHMAC(key, serverChallenge, temp.Bytes()), temp.Bytes()...)
+	lm = append(ntlmHMAC(key, serverChallenge, clientChallenge), clientChallenge...)
+	return
+}
+
+// ntlmTargetInfo rewrites the server's AV pairs for the client's
+// response, adding the channel binding hash when there is one. It also
+// returns the server's timestamp, if it sent one.
+func ntlmTargetInfo(info, channelBinding []byte) (out, timestamp []byte) {
+	var buf bytes.Buffer
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+		if id == ntlmAvEOL || 4+length > len(info) {
+			break
+		}
+		if id == ntlmAvTimestamp {
+			timestamp = info[4 : 4+length]
+		}
+		buf.Write(info[:4+length])
+		info = info[4+length:]
+	}
+	if channelBinding != nil {
+		// MD5 of a gss_channel_bindings_struct with empty addresses
+		// and the binding as application data.
+		h := md5.New()
+		var header [20]byte
+		appData := append([]byte(ChannelBindingTLSServerEndPoint+":"), channelBinding...)
+		binary.LittleEndian.PutUint32(header[16:], uint32(len(appData)))
+		h.Write(header[:])
+		h.Write(appData)
+		var pair [4]byte
+		binary.LittleEndian.PutUint16(pair[:], ntlmAvChannelBindings)
+		binary.LittleEndian.PutUint16(pair[2:], md5.Size)
+		buf.Write(pair[:])
+		buf.Write(h.Sum(nil))
+	}
+	buf.Write([]byte{0, 0, 0, 0})
+	return buf.Bytes(), timestamp
+}
+
+// NTLMBind authenticates with NTLMv2 using Microsoft's Sicily bind
+// sequence, the way Active Directory expects it outside of SASL.
+// Over TLS the handshake is bound to the channel.
+func (l *conn) NTLMBind(creds NTLMCredentials) error {
+	var cb []byte
+	if l.isTLS() {
+		var err error
+		if cb, err = l.ChannelBinding(ChannelBindingTLSServerEndPoint); err != nil {
+			return err
+		}
+	}
+
+	r, err := l.sicilyBind("tag:10", ntlmNegotiateMessage())
+	if err != nil {
+		return err
+	}
+	// The challenge comes back in the matchedDN field.
+	challenge, err := parseNTLMChallenge(r.MatchedDN)
+	if err != nil {
+		return err
+	}
+	auth, err := ntlmAuthenticate(creds, challenge, cb)
+	if err != nil {
+		return err
+	}
+	_, err = l.sicilyBind("tag:11", auth)
+	return err
+}
+
+func (l *conn) sicilyBind(tag string, token []byte) (*ldapResult, error) {
+	op := asn1.OptionValue{
+		Opts: "application,tag:0", Value: bindRequest{
+			Version: 3,
+			Auth:    asn1.OptionValue{Opts: tag, Value: token},
+		},
+	}
+	raw, _, err := l.roundTrip(op, nil)
+	if err != nil {
+		return nil, err
+	}
+	var r ldapResult
+	if err := decodeOp(raw, "application,tag:1", &r); err != nil {
+		return nil, fmt.Errorf("Decode: %v", err)
+	}
+	if err := r.err(); err != nil {
+		return nil, fmt.Errorf("ldap.NTLMBind unsuccessful: %w", err)
+	}
+	return &r, nil
+}
+
+var (
+	oidSPNEGO  = asn1.RawValue{Tag: 6, Bytes: []byte{0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}}
+	oidNTLMSSP = asn1.RawValue{Tag: 6, Bytes: []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}}
+)
+
+// SASLGSSSPNEGO implements the GSS-SPNEGO SASL mechanism with NTLMv2 as
+// the negotiated mechanism. It supports channel binding, but no SASL
+// security layer, so use it over TLS.
+type SASLGSSSPNEGO struct {
+	Credentials NTLMCredentials
+
+	channelBinding []byte
+	done           bool
+}
+
+func (m *SASLGSSSPNEGO) Name() string               { return "GSS-SPNEGO" }
+func (m *SASLGSSSPNEGO) ChannelBindingType() string { return ChannelBindingTLSServerEndPoint }
+
+func (m *SASLGSSSPNEGO) SetChannelBinding(data []byte) {
+	m.channelBinding = data
+}
+
+func (m *SASLGSSSPNEGO) Start() ([]byte, error) {
+	m.done = false
+	negTokenInit := []interface{}{
+		asn1.OptionValue{Opts: "tag:0", Value: []interface{}{oidNTLMSSP}},
+		asn1.OptionValue{Opts: "tag:2", Value: ntlmNegotiateMessage()},
+	}
+	return encodeExplicit(asn1.OptionValue{Opts: "application,tag:0,implicit", Value: []interface{}{
+		oidSPNEGO,
+		asn1.OptionValue{Opts: "tag:0", Value: negTokenInit},
+	}})
+}
+
+func (m *SASLGSSSPNEGO) Next(challenge []byte) ([]byte, error) {
+	token, err := spnegoResponseToken(challenge)
+	if err != nil {
+		return nil, err
+	}
+	if m.done || token == nil {
+		// The final NegTokenResp merely confirms completion.
+		return nil, nil
+	}
+	c, err := parseNTLMChallenge(token)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := ntlmAuthenticate(m.Credentials, c, m.channelBinding)
+	if err != nil {
+		return nil, err
+	}
+	m.done = true
+	return encodeExplicit(asn1.OptionValue{Opts: "tag:1", Value: []interface{}{
+		asn1.OptionValue{Opts: "tag:2", Value: auth},
+	}})
+}
+
+// spnegoResponseToken extracts the responseToken from a NegTokenResp.
+func spnegoResponseToken(b []byte) ([]byte, error) {
+	var resp asn1.RawValue
+	if err := decodeValue(b, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Class != asn1.ClassContextSpecific || resp.Tag != 1 {
+		return nil, fmt.Errorf("SPNEGO: expected NegTokenResp")
+	}
+	var elements []asn1.RawValue
+	if err := decodeValue(resp.Bytes, &elements); err != nil {
+		return nil, err
+	}
+	for _, e := range elements {
+		if e.Class == asn1.ClassContextSpecific && e.Tag == 2 {
+			var token []byte
+			if err := decodeValue(e.Bytes, &token); err != nil {
+				return nil, err
+			}
+			return token, nil
+		}
+	}
+	return nil, nil
+}
+
+func encodeExplicit(in interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := asn1.NewEncoder(&buf).Encode(in); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,74 @@
+package ldap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/stesla/ldap/asn1"
+	"testing"
+)
+
+func TestMD4(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", "31d6cfe0d16ae931b73c59d7e0c089c0"},
+		{"abc", "a448017aaf21d8525fc10ae87aa6729d"},
+		{"message digest", "d9130a8164549fe818874806e1c7014b"},
+		{"12345678901234567890123456789012345678901234567890123456789012345678901234567890",
+			"e33b4ddc9c38f2199c3e7b164fcc0536"},
+	}
+	for i, test := range tests {
+		if actual := hex.EncodeToString(md4Sum([]byte(test.in))); actual != test.out {
+			t.Errorf("#%d: Bad result: %s (expected %s)", i, actual, test.out)
+		}
+	}
+}
+
+// Test vectors from MS-NLMP §4.2.4.
+func TestNTLMv2Response(t *testing.T) {
+	creds := NTLMCredentials{Domain: "Domain", Username: "User", Password: "Password"}
+	key := ntowfv2(creds.ntHash(), creds.Username, creds.Domain)
+	if expected, _ := hex.DecodeString("0c868a403bfd7a93a3001ef22ef02e3f"); !bytes.Equal(key, expected) {
+		t.Errorf("Bad NTOWFv2: %x (expected %x)", key, expected)
+	}
+
+	var targetInfo bytes.Buffer
+	targetInfo.Write([]byte{0x02, 0x00, 0x0c, 0x00})
+	targetInfo.Write(ntlmUnicode("Domain"))
+	targetInfo.Write([]byte{0x01, 0x00, 0x0c, 0x00})
+	targetInfo.Write(ntlmUnicode("Server"))
+	targetInfo.Write([]byte{0x00, 0x00, 0x00, 0x00})
+
+	serverChallenge, _ := hex.DecodeString("0123456789abcdef")
+	clientChallenge := bytes.Repeat([]byte{0xaa}, 8)
+	nt, lm := ntlmv2Response(key, serverChallenge, clientChallenge, make([]byte, 8), targetInfo.Bytes())
+	if expected, _ := hex.DecodeString("68cd0ab851e51c96aabc927bebef6a1c"); !bytes.Equal(nt[:16], expected) {
+		t.Errorf("Bad NTProofStr: %x (expected %x)", nt[:16], expected)
+	}
+	if expected, _ := hex.DecodeString("86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"); !bytes.Equal(lm, expected) {
+		t.Errorf("Bad LMv2 response: %x (expected %x)", lm, expected)
+	}
+}
+
+func TestSPNEGOResponseToken(t *testing.T) {
+	m := &SASLGSSSPNEGO{}
+	init, err := m.Start()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if init[0] != 0x60 {
+		t.Errorf("Bad initial token: %x", init)
+	}
+
+	resp, _ := encodeExplicit(asn1.OptionValue{Opts: "tag:1", Value: []interface{}{
+		asn1.OptionValue{Opts: "tag:0", Value: asn1.RawValue{Tag: asn1.TagEnumerated, Bytes: []byte{1}}},
+		asn1.OptionValue{Opts: "tag:2", Value: ntlmNegotiateMessage()},
+	}})
+	token, err := spnegoResponseToken(resp)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(token, ntlmNegotiateMessage()) {
+		t.Errorf("Bad token: %x", token)
+	}
+}
@@ -0,0 +1,161 @@
+package ldap
+
+import (
+	"fmt"
+	"iter"
+)
+
+// SearchPage retrieves a single page of results using the Simple Paged
+// Results control. On return paging.Cookie holds the cookie needed to
+// fetch the next page; it is empty once the last page has been read. A
+// cookie saved from an earlier call may be used to resume the search on
+// the same connection. If RootDSE has been read and the server does not
+// advertise the control, SearchPage fails without sending the search.
+func (l *conn) SearchPage(req SearchRequest, paging *ControlPaging) ([]SearchResult, error) {
+	if dse := l.cachedRootDSE(); dse != nil && !dse.SupportsControl(ControlTypePaging) {
+		return nil, fmt.Errorf("ldap: server does not support paged results")
+	}
+	resp, err := l.SearchWithControls(req, paging)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := FindControl(resp.Controls, ControlTypePaging).(*ControlPaging)
+	if !ok {
+		if len(paging.Cookie) == 0 && !paging.Criticality {
+			// The server ignored the non-critical control and
+			// returned everything in one go.
+			paging.Cookie = nil
+			return resp.Results, nil
+		}
+		return nil, fmt.Errorf("server did not return a paging control")
+	}
+	paging.Cookie = c.Cookie
+	return resp.Results, nil
+}
+
+// SearchWithPaging performs req by repeatedly requesting pages of at
+// most pageSize entries until the server reports that the result set is
+// exhausted, and returns the combined results.
+func (l *conn) SearchWithPaging(req SearchRequest, pageSize int) ([]SearchResult, error) {
+	paging := &ControlPaging{Size: pageSize}
+	results := []SearchResult{}
+	for {
+		page, err := l.SearchPage(req, paging)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, page...)
+		if len(paging.Cookie) == 0 {
+			return results, nil
+		}
+	}
+}
+
+// A SearchIterator steps through the results of a search, fetching the
+// next page from the server whenever the last one has been consumed:
+//
+//	it := conn.SearchIter(req, 500)
+//	defer it.Close()
+//	for it.Next() {
+//		e := it.Entry()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type SearchIterator struct {
+	conn   Conn
+	req    SearchRequest
+	paging *ControlPaging
+
+	page    []SearchResult
+	current SearchResult
+	started bool
+	done    bool
+	err     error
+}
+
+// SearchIter returns an iterator over the results of req, fetched in
+// pages of pageSize entries, or all at once if pageSize is zero.
+func (l *conn) SearchIter(req SearchRequest, pageSize int) *SearchIterator {
+	return searchIter(l, req, pageSize)
+}
+
+func searchIter(c Conn, req SearchRequest, pageSize int) *SearchIterator {
+	it := &SearchIterator{conn: c, req: req}
+	if pageSize > 0 {
+		it.paging = &ControlPaging{Size: pageSize}
+	}
+	return it
+}
+
+// Next advances to the next result, fetching a page if needed. It
+// returns false when the results are exhausted or an error occurs.
+func (it *SearchIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil || !it.fetch() {
+			return false
+		}
+	}
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// fetch reads the next page into it.page.
+func (it *SearchIterator) fetch() bool {
+	if it.paging == nil {
+		it.page, it.err = it.conn.Search(it.req)
+		it.done = true
+		return it.err == nil
+	}
+	it.started = true
+	it.page, it.err = it.conn.SearchPage(it.req, it.paging)
+	// After an error the server has no search to abandon either.
+	if it.err != nil || len(it.paging.Cookie) == 0 {
+		it.done = true
+	}
+	return it.err == nil
+}
+
+// Result returns the current result.
+func (it *SearchIterator) Result() SearchResult {
+	return it.current
+}
+
+// Entry returns the current result as an Entry.
+func (it *SearchIterator) Entry() *Entry {
+	return it.current.Entry()
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *SearchIterator) Err() error {
+	return it.err
+}
+
+// Close stops the iteration. If the server holds further pages, it is
+// asked to discard them by a request for an empty page (RFC 2696).
+func (it *SearchIterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.done, it.page = true, nil
+	if it.paging == nil || !it.started || len(it.paging.Cookie) == 0 {
+		return nil
+	}
+	it.paging.Size = 0
+	_, err := it.conn.SearchPage(it.req, it.paging)
+	return err
+}
+
+// All returns the results as a sequence for use with range. Breaking
+// out of the loop closes the iterator; check Err afterwards.
+func (it *SearchIterator) All() iter.Seq[SearchResult] {
+	return func(yield func(SearchResult) bool) {
+		for it.Next() {
+			if !yield(it.Result()) {
+				it.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package ldap
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// pagingConn serves results a page at a time, with the index of the next
+// result as the cookie, and fails the page starting at failAt if set.
+type pagingConn struct {
+	Conn
+	results []SearchResult
+	failAt  int
+	sizes   []int
+}
+
+func (c *pagingConn) SearchPage(req SearchRequest, paging *ControlPaging) ([]SearchResult, error) {
+	c.sizes = append(c.sizes, paging.Size)
+	start := 0
+	if len(paging.Cookie) > 0 {
+		start, _ = strconv.Atoi(string(paging.Cookie))
+	}
+	if c.failAt > 0 && start == c.failAt {
+		return nil, fmt.Errorf("page failed")
+	}
+	end := start + paging.Size
+	if paging.Size == 0 || end >= len(c.results) {
+		end = len(c.results)
+	}
+	if paging.Size == 0 {
+		paging.Cookie = nil
+		return nil, nil
+	}
+	paging.Cookie = nil
+	if end < len(c.results) {
+		paging.Cookie = []byte(strconv.Itoa(end))
+	}
+	return c.results[start:end], nil
+}
+
+func (c *pagingConn) Search(req SearchRequest) ([]SearchResult, error) {
+	c.sizes = append(c.sizes, -1)
+	return c.results, nil
+}
+
+func newPagingConn(n int) *pagingConn {
+	c := &pagingConn{}
+	for i := 0; i < n; i++ {
+		c.results = append(c.results, SearchResult{DN: fmt.Sprintf("cn=%d", i), Attributes: map[string][]string{"cn": {strconv.Itoa(i)}}})
+	}
+	return c
+}
+
+func TestSearchIter(t *testing.T) {
+	tests := []struct {
+		n, pageSize, failAt int
+		dns                 int
+		sizes               []int
+		err                 bool
+	}{
+		{5, 2, 0, 5, []int{2, 2, 2}, false},
+		{4, 2, 0, 4, []int{2, 2}, false},
+		{0, 2, 0, 0, []int{2}, false},
+		{5, 0, 0, 5, []int{-1}, false},
+		{5, 2, 4, 4, []int{2, 2, 2}, true},
+	}
+	for i, test := range tests {
+		c := newPagingConn(test.n)
+		c.failAt = test.failAt
+		it := searchIter(c, SearchRequest{}, test.pageSize)
+		var dns []string
+		for it.Next() {
+			dns = append(dns, it.Entry().DN)
+		}
+		if len(dns) != test.dns || (it.Err() != nil) != test.err {
+			t.Errorf("#%d: Bad result: %v, %v", i, dns, it.Err())
+		}
+		for j, dn := range dns {
+			if dn != fmt.Sprintf("cn=%d", j) {
+				t.Errorf("#%d: Bad result: %v", i, dns)
+				break
+			}
+		}
+		if err := it.Close(); err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(c.sizes, test.sizes) {
+			t.Errorf("#%d: Bad pages: %v (expected %v)", i, c.sizes, test.sizes)
+		}
+	}
+}
+
+func TestSearchIterClose(t *testing.T) {
+	c := newPagingConn(10)
+	it := searchIter(c, SearchRequest{}, 3)
+	var seen []string
+	for r := range it.All() {
+		seen = append(seen, r.Attributes["cn"][0])
+		if len(seen) == 4 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(seen, []string{"0", "1", "2", "3"}) {
+		t.Errorf("Bad result: %v", seen)
+	}
+	// Breaking out abandons the rest with a page of size 0.
+	if expected := []int{3, 3, 0}; !reflect.DeepEqual(c.sizes, expected) {
+		t.Errorf("Bad pages: %v (expected %v)", c.sizes, expected)
+	}
+	if it.Next() {
+		t.Errorf("Next after Close returned true")
+	}
+	if err := it.Close(); err != nil || len(c.sizes) != 3 {
+		t.Errorf("Second Close: %v, %v", err, c.sizes)
+	}
+}
+
+// servePages answers searches on server from results, a page at a time,
+// with the index of the next result as the cookie. It sends the cookies
+// it receives on cookies. If ignore is set it answers as a server that
+// does not support paging.
+func servePages(server net.Conn, results []string, ignore bool, cookies chan<- string) {
+	for {
+		m, err := readControlMessage(server)
+		if err != nil {
+			return
+		}
+		ctls, _ := decodeControls(m.Controls)
+		paging, _ := FindControl(ctls, ControlTypePaging).(*ControlPaging)
+		if paging == nil {
+			cookies <- "no paging control"
+			return
+		}
+		cookies <- string(paging.Cookie)
+		start, _ := strconv.Atoi(string(paging.Cookie))
+		end := start + paging.Size
+		if ignore || end > len(results) {
+			end = len(results)
+		}
+		for _, dn := range results[start:end] {
+			writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte(dn), []partialAttribute{}})
+		}
+		done := ldapResult{MatchedDN: []byte{}, Message: []byte{}}
+		if ignore {
+			writeTestMessage(server, m.MessageId, "application,tag:5", done)
+			continue
+		}
+		resp := &ControlPaging{Cookie: []byte{}}
+		if end < len(results) {
+			resp.Cookie = []byte(strconv.Itoa(end))
+		}
+		writeControlMessage(server, m.MessageId, "application,tag:5", done, resp)
+	}
+}
+
+func TestSearchWithPaging(t *testing.T) {
+	results := []string{"cn=0", "cn=1", "cn=2", "cn=3", "cn=4"}
+	tests := []struct {
+		pageSize int
+		ignore   bool
+		cookies  []string
+	}{
+		{2, false, []string{"", "2", "4"}},
+		{5, false, []string{""}},
+		{10, false, []string{""}},
+		// A server that ignores the control returns everything at once.
+		{2, true, []string{""}},
+	}
+	for i, test := range tests {
+		client, server := net.Pipe()
+		c := newConn(client)
+		cookies := make(chan string, 10)
+		go servePages(server, results, test.ignore, cookies)
+		out, err := c.SearchWithPaging(SearchRequest{Filter: Present("objectClass")}, test.pageSize)
+		c.Close()
+		server.Close()
+		var dns []string
+		for _, r := range out {
+			dns = append(dns, r.DN)
+		}
+		if err != nil || !reflect.DeepEqual(dns, results) {
+			t.Errorf("#%d: Bad result: %v, %v (expected %v)", i, dns, err, results)
+		}
+		close(cookies)
+		var sent []string
+		for cookie := range cookies {
+			sent = append(sent, cookie)
+		}
+		if !reflect.DeepEqual(sent, test.cookies) {
+			t.Errorf("#%d: Bad cookies: %q (expected %q)", i, sent, test.cookies)
+		}
+	}
+}
+
+func TestSearchPage(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+	cookies := make(chan string, 10)
+	go servePages(server, []string{"cn=0", "cn=1", "cn=2"}, false, cookies)
+
+	// A saved cookie resumes the search.
+	paging := &ControlPaging{Size: 2, Cookie: []byte("1")}
+	out, err := c.SearchPage(SearchRequest{Filter: Present("objectClass")}, paging)
+	if err != nil || len(out) != 2 || out[0].DN != "cn=1" || len(paging.Cookie) != 0 {
+		t.Errorf("Bad result: %v, %v, %q", out, err, paging.Cookie)
+	}
+	if cookie := <-cookies; cookie != "1" {
+		t.Errorf("Bad cookie: %q (expected %q)", cookie, "1")
+	}
+
+	// A server that does not advertise the control is not sent it.
+	c.rootDSE = &RootDSE{}
+	if _, err := c.SearchPage(SearchRequest{Filter: Present("objectClass")}, &ControlPaging{Size: 2}); err == nil {
+		t.Error("Expected an error from a server without paged results")
+	}
+	select {
+	case cookie := <-cookies:
+		t.Errorf("Unexpected search with cookie %q", cookie)
+	default:
+	}
+}
@@ -0,0 +1,296 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// Schemes of hashed userPassword values, for HashPassword.
+const (
+	PasswordSSHA         = "SSHA"
+	PasswordSSHA256      = "SSHA256"
+	PasswordSSHA512      = "SSHA512"
+	PasswordSHA512Crypt  = "SHA512-CRYPT"
+	PasswordPBKDF2       = "PBKDF2"
+	PasswordPBKDF2SHA256 = "PBKDF2-SHA256"
+	PasswordPBKDF2SHA512 = "PBKDF2-SHA512"
+	PasswordArgon2       = "ARGON2"
+)
+
+// Parameters of new password hashes. Verification uses those recorded in
+// the hash.
+const (
+	sha512CryptRounds = 5000
+	pbkdf2Iterations  = 210000
+	argon2Time        = 2
+	argon2Memory      = 19 * 1024 // KiB
+	argon2Threads     = 1
+)
+
+// HashPassword hashes password for storage as a userPassword value in
+// the given scheme, with a random salt:
+//
+//	{SSHA}, {SSHA256}, {SSHA512}  base64 of the salted digest and the salt
+//	{SHA512-CRYPT}                $6$salt$hash, as crypt(3)
+//	{PBKDF2}, {PBKDF2-SHA256},    iterations$salt$hash, as the pw-pbkdf2
+//	{PBKDF2-SHA512}               module of OpenLDAP
+//	{ARGON2}                      the argon2id PHC string, as the argon2
+//	                              module of OpenLDAP
+func HashPassword(scheme, password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	var hashed string
+	switch strings.ToUpper(scheme) {
+	case PasswordSSHA, PasswordSSHA256, PasswordSSHA512:
+		hashed = sshaHash(saltedHashes[strings.ToUpper(scheme)], password, salt[:8])
+	case PasswordSHA512Crypt:
+		for i, b := range salt {
+			salt[i] = cryptAlphabet[b%64]
+		}
+		hashed = "$6$" + string(salt) + "$" + sha512Crypt(password, string(salt), sha512CryptRounds)
+	case PasswordPBKDF2, PasswordPBKDF2SHA256, PasswordPBKDF2SHA512:
+		dk, err := pbkdf2Hash(strings.ToUpper(scheme), password, salt, pbkdf2Iterations)
+		if err != nil {
+			return "", err
+		}
+		hashed = fmt.Sprintf("%d$%s$%s", pbkdf2Iterations, ab64Encode(salt), ab64Encode(dk))
+	case PasswordArgon2:
+		key := argon2Key(argon2id, []byte(password), salt, nil, nil, argon2Time, argon2Memory, argon2Threads, 32)
+		hashed = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2Version,
+			argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	default:
+		return "", fmt.Errorf("ldap: unsupported password scheme %q", scheme)
+	}
+	return "{" + strings.ToUpper(scheme) + "}" + hashed, nil
+}
+
+// VerifyPassword reports whether password matches a userPassword value,
+// which is either hashed in one of the schemes of HashPassword, or
+// {CRYPT} with a $6$ hash, or plain text. The comparison takes constant
+// time. An error is returned for a malformed value or unsupported scheme.
+func VerifyPassword(stored, password string) (bool, error) {
+	if !strings.HasPrefix(stored, "{") {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, nil
+	}
+	end := strings.IndexByte(stored, '}')
+	if end < 0 {
+		return false, fmt.Errorf("ldap: malformed password hash")
+	}
+	scheme, hashed := strings.ToUpper(stored[1:end]), stored[end+1:]
+
+	var expected, actual []byte
+	switch scheme {
+	case PasswordSSHA, PasswordSSHA256, PasswordSSHA512:
+		h := saltedHashes[scheme]
+		b, err := base64.StdEncoding.DecodeString(hashed)
+		if err != nil || len(b) < h().Size() {
+			return false, fmt.Errorf("ldap: malformed %s password hash", scheme)
+		}
+		expected = []byte(hashed)
+		actual = []byte(sshaHash(h, password, b[h().Size():]))
+	case PasswordSHA512Crypt, "CRYPT":
+		salt, rounds, ok := parseSHA512Crypt(hashed)
+		if !ok {
+			return false, fmt.Errorf("ldap: unsupported %s password hash", scheme)
+		}
+		expected = []byte(hashed[strings.LastIndexByte(hashed, '$')+1:])
+		actual = []byte(sha512Crypt(password, salt, rounds))
+	case PasswordPBKDF2, PasswordPBKDF2SHA256, PasswordPBKDF2SHA512:
+		parts := strings.Split(hashed, "$")
+		if len(parts) != 3 {
+			return false, fmt.Errorf("ldap: malformed %s password hash", scheme)
+		}
+		iterations, err := strconv.Atoi(parts[0])
+		salt, err1 := ab64Decode(parts[1])
+		dk, err2 := ab64Decode(parts[2])
+		if err != nil || err1 != nil || err2 != nil || iterations < 1 || len(dk) == 0 {
+			return false, fmt.Errorf("ldap: malformed %s password hash", scheme)
+		}
+		if actual, err = pbkdf2Hash(scheme, password, salt, iterations); err != nil {
+			return false, err
+		}
+		expected = dk
+	case PasswordArgon2:
+		var err error
+		if expected, actual, err = verifyArgon2(hashed, password); err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("ldap: unsupported password scheme %q", scheme)
+	}
+	return subtle.ConstantTimeCompare(expected, actual) == 1, nil
+}
+
+var saltedHashes = map[string]func() hash.Hash{
+	PasswordSSHA:    sha1.New,
+	PasswordSSHA256: sha256.New,
+	PasswordSSHA512: sha512.New,
+}
+
+func sshaHash(h func() hash.Hash, password string, salt []byte) string {
+	d := h()
+	d.Write([]byte(password))
+	d.Write(salt)
+	return base64.StdEncoding.EncodeToString(append(d.Sum(nil), salt...))
+}
+
+func pbkdf2Hash(scheme, password string, salt []byte, iterations int) ([]byte, error) {
+	switch scheme {
+	case PasswordPBKDF2SHA256:
+		return pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	case PasswordPBKDF2SHA512:
+		return pbkdf2.Key(sha512.New, password, salt, iterations, sha512.Size)
+	}
+	return pbkdf2.Key(sha1.New, password, salt, iterations, sha1.Size)
+}
+
+// ab64Encode is the base64 variant of the pw-pbkdf2 module: unpadded,
+// with '.' for '+'.
+func ab64Encode(b []byte) string {
+	return strings.ReplaceAll(base64.RawStdEncoding.EncodeToString(b), "+", ".")
+}
+
+func ab64Decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.ReplaceAll(s, ".", "+"))
+}
+
+// verifyArgon2 returns the tag of an argon2 PHC string and that of
+// password with the same parameters.
+func verifyArgon2(phc, password string) (expected, actual []byte, err error) {
+	malformed := fmt.Errorf("ldap: malformed ARGON2 password hash")
+	parts := strings.Split(phc, "$")
+	if len(parts) != 6 || parts[0] != "" {
+		return nil, nil, malformed
+	}
+	modes := map[string]int{"argon2d": argon2d, "argon2i": argon2i, "argon2id": argon2id}
+	mode, ok := modes[parts[1]]
+	if !ok || parts[2] != "v=19" {
+		return nil, nil, fmt.Errorf("ldap: unsupported ARGON2 password hash")
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil ||
+		time < 1 || threads < 1 {
+		return nil, nil, malformed
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[4])
+	expected, err2 := base64.RawStdEncoding.DecodeString(parts[5])
+	if err1 != nil || err2 != nil || len(expected) < 4 {
+		return nil, nil, malformed
+	}
+	actual = argon2Key(mode, []byte(password), salt, nil, nil, time, memory, threads, uint32(len(expected)))
+	return expected, actual, nil
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// parseSHA512Crypt returns the salt and rounds of a $6$ crypt(3) hash.
+func parseSHA512Crypt(s string) (salt string, rounds int, ok bool) {
+	if !strings.HasPrefix(s, "$6$") {
+		return "", 0, false
+	}
+	parts := strings.Split(s[3:], "$")
+	rounds = sha512CryptRounds
+	if len(parts) == 3 && strings.HasPrefix(parts[0], "rounds=") {
+		n, err := strconv.Atoi(parts[0][len("rounds="):])
+		if err != nil {
+			return "", 0, false
+		}
+		rounds = min(max(n, 1000), 999999999)
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return "", 0, false
+	}
+	return parts[0], rounds, true
+}
+
+// sha512Crypt computes the hash part of a SHA-512 crypt(3) password
+// hash, as specified by Ulrich Drepper.
+func sha512Crypt(password, salt string, rounds int) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	key := []byte(password)
+	sum := func(parts ...[]byte) []byte {
+		h := sha512.New()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return h.Sum(nil)
+	}
+	repeat := func(b []byte, n int) []byte {
+		return bytes.Repeat(b, n/len(b)+1)[:n]
+	}
+
+	b := sum(key, []byte(salt), key)
+	a := sha512.New()
+	a.Write(key)
+	a.Write([]byte(salt))
+	a.Write(repeat(b, len(key)))
+	for n := len(key); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			a.Write(b)
+		} else {
+			a.Write(key)
+		}
+	}
+	c := a.Sum(nil)
+
+	p := repeat(sum(bytes.Repeat(key, len(key))), len(key))
+	s := repeat(sum(bytes.Repeat([]byte(salt), 16+int(c[0]))), len(salt))
+	for i := 0; i < rounds; i++ {
+		h := sha512.New()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	encode := func(b2, b1, b0 byte, n int) {
+		w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+		for ; n > 0; n-- {
+			out.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	for i := 0; i < 21; i++ {
+		x, y, z := c[i], c[i+21], c[i+42]
+		switch i % 3 {
+		case 1:
+			x, y, z = y, z, x
+		case 2:
+			x, y, z = z, x, y
+		}
+		encode(x, y, z, 4)
+	}
+	encode(0, 0, c[63], 2)
+	return out.String()
+}
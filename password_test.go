@@ -0,0 +1,94 @@
+package ldap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	tests := []struct {
+		stored, password string
+		ok               bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"{SSHA}NGAMOZHhQA/L7qBLdB7d3v8jcA/HG8OGfpdiIw==", "secret", true},
+		{"{ssha}NGAMOZHhQA/L7qBLdB7d3v8jcA/HG8OGfpdiIw==", "secreT", false},
+		{"{SHA512-CRYPT}$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!", true},
+		{"{CRYPT}$6$rounds=10000$saltstringsaltstring$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!", true},
+		{"{CRYPT}$6$rounds=10000$saltstringsaltstring$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world", false},
+		{"{PBKDF2}60000$Y6ZHtTTbeUgpIbIW0QDmDA$j/aU7jFKUSbH4UobNQDm9OEIwuw", "secret", true},
+		{"{ARGON2}$argon2id$v=19$m=19456,t=2,p=1$Xso28CG6W3BiOBThv6n4/A$1broIxJ9WiXOhFjdqTjGIBeAZCYH2X6QOIHz5+2Crok", "secret", true},
+		{"{ARGON2}$argon2id$v=19$m=19456,t=2,p=1$Xso28CG6W3BiOBThv6n4/A$1broIxJ9WiXOhFjdqTjGIBeAZCYH2X6QOIHz5+2Crok", "secrets", false},
+	}
+	for i, test := range tests {
+		ok, err := VerifyPassword(test.stored, test.password)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+		} else if ok != test.ok {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, ok, test.ok)
+		}
+	}
+
+	for i, stored := range []string{"{MD5}X03MO1qnZdYdgyfeuILPmQ==", "{SSHA}!", "{CRYPT}abc", "{PBKDF2}1$$", "{ARGON2}$argon2id$v=16$m=8,t=1,p=1$c2FsdA$AAAAAA"} {
+		if _, err := VerifyPassword(stored, "password"); err == nil {
+			t.Errorf("#%d: Expected an error for %q", i, stored)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	schemes := []string{
+		PasswordSSHA, PasswordSSHA256, PasswordSSHA512, PasswordSHA512Crypt,
+		PasswordPBKDF2, PasswordPBKDF2SHA256, PasswordPBKDF2SHA512, PasswordArgon2,
+	}
+	for _, scheme := range schemes {
+		hashed, err := HashPassword(scheme, "secret")
+		if err != nil {
+			t.Errorf("%s: %v", scheme, err)
+			continue
+		}
+		if again, _ := HashPassword(scheme, "secret"); again == hashed {
+			t.Errorf("%s: Hashes are not salted: %s", scheme, hashed)
+		}
+		for _, test := range []struct {
+			password string
+			ok       bool
+		}{{"secret", true}, {"Secret", false}, {"", false}} {
+			if ok, err := VerifyPassword(hashed, test.password); ok != test.ok || err != nil {
+				t.Errorf("%s: Bad result for %q: %v, %v (expected %v)", scheme, test.password, ok, err, test.ok)
+			}
+		}
+	}
+	if _, err := HashPassword("MD5", "secret"); err == nil {
+		t.Error("Expected an error for an unsupported scheme")
+	}
+}
+
+func TestArgon2(t *testing.T) {
+	// The test vectors of RFC 9106.
+	password := bytes.Repeat([]byte{1}, 32)
+	salt := bytes.Repeat([]byte{2}, 16)
+	key := bytes.Repeat([]byte{3}, 8)
+	data := bytes.Repeat([]byte{4}, 12)
+	tests := []struct {
+		mode     int
+		expected string
+	}{
+		{argon2d, "512b391b6f1162975371d30919734294f868e3be3984f3c1a13a4db9fabe4acb"},
+		{argon2i, "c814d9d1dc7f37aa13f0d77f2494bda1c8de6b016dd388d29952a4c4672b6ce8"},
+		{argon2id, "0d640df58d78766c08c037a34a8b53c9d01ef0452d75b65eb52520e96b01e659"},
+	}
+	for i, test := range tests {
+		tag := hex.EncodeToString(argon2Key(test.mode, password, salt, key, data, 3, 32, 4, 32))
+		if tag != test.expected {
+			t.Errorf("#%d: Bad result: %s (expected %s)", i, tag, test.expected)
+		}
+	}
+
+	sum := hex.EncodeToString(blake2bSum(64, []byte("abc")))
+	if expected := "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"; sum != expected {
+		t.Errorf("Bad BLAKE2b result: %s (expected %s)", sum, expected)
+	}
+}
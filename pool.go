@@ -0,0 +1,288 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type PoolOptions struct {
+	// MinConns connections are opened up front and kept open.
+	MinConns int
+	// MaxConns limits the number of open connections; Get blocks
+	// while the limit is reached. Zero means no limit.
+	MaxConns int
+	// Bind, if set, is called on every newly dialed connection, so
+	// that connections replacing failed ones are bound the same way.
+	Bind func(Conn) error
+	// HealthCheck verifies that a connection is usable. It defaults to
+	// reading the root DSE.
+	HealthCheck func(Conn) error
+	// HealthCheckInterval is how often idle connections are checked.
+	// Zero disables periodic checks.
+	HealthCheckInterval time.Duration
+	// Metrics, if set, is told the number of connections in use and
+	// idle whenever they change.
+	Metrics MetricsCollector
+	// ReadOnly makes every connection the pool dials read-only, as
+	// DialOpts.ReadOnly does. The dial function must then return
+	// connections made by this package.
+	ReadOnly bool
+}
+
+// A Pool maintains a set of connections to be shared between
+// goroutines. Each connection is used by one goroutine at a time: take
+// one with Get and hand it back with Put, or use WithConn.
+type Pool struct {
+	dial func() (Conn, error)
+	opts PoolOptions
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	idle   []Conn
+	open   int
+	closed bool
+	done   chan struct{}
+}
+
+var ErrPoolClosed = fmt.Errorf("ldap: pool closed")
+
+// NewPool creates a pool of connections opened with dial.
+func NewPool(dial func() (Conn, error), opts PoolOptions) (*Pool, error) {
+	if opts.MaxConns > 0 && opts.MinConns > opts.MaxConns {
+		return nil, fmt.Errorf("ldap: MinConns (%d) exceeds MaxConns (%d)", opts.MinConns, opts.MaxConns)
+	}
+	if opts.HealthCheck == nil {
+		opts.HealthCheck = readRootDSE
+	}
+	p := &Pool{
+		dial: dial,
+		opts: opts,
+		done: make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	for i := 0; i < opts.MinConns; i++ {
+		c, err := p.connect()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.idle = append(p.idle, c)
+		p.open++
+	}
+	p.report()
+
+	if opts.HealthCheckInterval > 0 {
+		go p.healthCheckLoop()
+	}
+	return p, nil
+}
+
+func readRootDSE(c Conn) error {
+	_, err := c.Search(SearchRequest{
+		BaseObject: []byte{},
+		Scope:      BaseObject,
+		Filter:     Present("objectClass"),
+		Attributes: [][]byte{[]byte("1.1")},
+	})
+	return err
+}
+
+func (p *Pool) connect() (Conn, error) {
+	c, err := p.Dial()
+	if err != nil {
+		return nil, err
+	}
+	if p.opts.Bind != nil {
+		if err := p.opts.Bind(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Dial opens a connection the way the pool does, but without binding it
+// or counting it against MaxConns, for operations that must not disturb
+// the pool's connections, such as binding as another user.
+func (p *Pool) Dial() (Conn, error) {
+	c, err := p.dial()
+	if err != nil || !p.opts.ReadOnly {
+		return c, err
+	}
+	if err := setReadOnly(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get returns an idle connection, dialing a new one if there is none
+// and the pool is below MaxConns, or waiting for one to be returned.
+func (p *Pool) Get() (Conn, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.report()
+			p.mu.Unlock()
+			return c, nil
+		}
+		if p.opts.MaxConns <= 0 || p.open < p.opts.MaxConns {
+			break
+		}
+		p.cond.Wait()
+	}
+	p.open++
+	p.report()
+	p.mu.Unlock()
+
+	c, err := p.connect()
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Put returns a connection obtained from Get to the pool. Connections
+// that were re-bound as a different identity or are otherwise in doubt
+// should be passed to Discard instead.
+func (p *Pool) Put(c Conn) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		c.Close()
+		p.release()
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.report()
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// Discard closes a connection obtained from Get instead of returning it
+// to the pool.
+func (p *Pool) Discard(c Conn) {
+	c.Close()
+	p.release()
+}
+
+func (p *Pool) release() {
+	p.mu.Lock()
+	p.open--
+	p.report()
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+// report tells the metrics collector how many connections are in use
+// and idle. p.mu must be held.
+func (p *Pool) report() {
+	if p.opts.Metrics != nil {
+		p.opts.Metrics.Pool(p.open-len(p.idle), len(p.idle))
+	}
+}
+
+// WithConn calls fn with a connection from the pool. If fn fails, the
+// connection is health checked before being reused.
+func (p *Pool) WithConn(fn func(Conn) error) error {
+	c, err := p.Get()
+	if err != nil {
+		return err
+	}
+	err = fn(c)
+	if err != nil && p.opts.HealthCheck(c) != nil {
+		p.Discard(c)
+		return err
+	}
+	p.Put(c)
+	return err
+}
+
+// WithConnContext is like WithConn, but binds the connection fn is
+// called with to ctx, so that its operations are cancelled with ctx and
+// traced as part of any span in it.
+func (p *Pool) WithConnContext(ctx context.Context, fn func(Conn) error) error {
+	return p.WithConn(func(c Conn) error {
+		return fn(c.WithContext(ctx))
+	})
+}
+
+// Close closes the idle connections and makes further calls to Get
+// fail. Connections currently in use are closed when they are returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	p.report()
+	p.mu.Unlock()
+
+	close(p.done)
+	p.cond.Broadcast()
+	for _, c := range idle {
+		c.Close()
+	}
+	return nil
+}
+
+func (p *Pool) healthCheckLoop() {
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkIdle()
+		}
+	}
+}
+
+// checkIdle health checks every idle connection, dropping the broken
+// ones, and tops the pool back up to MinConns.
+func (p *Pool) checkIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		if err := p.opts.HealthCheck(c); err != nil {
+			p.Discard(c)
+			continue
+		}
+		p.Put(c)
+	}
+
+	for {
+		p.mu.Lock()
+		if p.closed || p.open >= p.opts.MinConns {
+			p.mu.Unlock()
+			return
+		}
+		p.open++
+		p.mu.Unlock()
+
+		c, err := p.connect()
+		if err != nil {
+			p.release()
+			return
+		}
+		p.Put(c)
+	}
+}
@@ -0,0 +1,118 @@
+package ldap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type poolTestConn struct {
+	Conn
+	id      int
+	healthy bool
+	closed  bool
+}
+
+func (c *poolTestConn) Close() error { c.closed = true; return nil }
+
+func newPoolTestDialer() (func() (Conn, error), *[]*poolTestConn) {
+	var conns []*poolTestConn
+	return func() (Conn, error) {
+		c := &poolTestConn{id: len(conns), healthy: true}
+		conns = append(conns, c)
+		return c, nil
+	}, &conns
+}
+
+func poolTestHealthCheck(c Conn) error {
+	if !c.(*poolTestConn).healthy {
+		return fmt.Errorf("unhealthy")
+	}
+	return nil
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	dial, conns := newPoolTestDialer()
+	p, err := NewPool(dial, PoolOptions{MinConns: 1, MaxConns: 2, HealthCheck: poolTestHealthCheck})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer p.Close()
+
+	a, _ := p.Get()
+	b, _ := p.Get()
+	if a == b || len(*conns) != 2 {
+		t.Errorf("Expected two distinct connections, dialed %d", len(*conns))
+	}
+
+	got := make(chan Conn)
+	go func() {
+		c, _ := p.Get()
+		got <- c
+	}()
+	select {
+	case <-got:
+		t.Fatalf("Get did not block at MaxConns")
+	case <-time.After(10 * time.Millisecond):
+	}
+	p.Put(a)
+	if c := <-got; c != a {
+		t.Errorf("Expected returned connection to be reused")
+	}
+}
+
+func TestPoolWithConnDiscardsBrokenConnections(t *testing.T) {
+	dial, conns := newPoolTestDialer()
+	var binds int
+	p, _ := NewPool(dial, PoolOptions{
+		MaxConns:    1,
+		HealthCheck: poolTestHealthCheck,
+		Bind:        func(Conn) error { binds++; return nil },
+	})
+	defer p.Close()
+
+	err := p.WithConn(func(c Conn) error {
+		c.(*poolTestConn).healthy = false
+		return fmt.Errorf("broken")
+	})
+	if err == nil {
+		t.Errorf("Expected error from WithConn")
+	}
+	if !(*conns)[0].closed {
+		t.Errorf("Expected broken connection to be closed")
+	}
+
+	p.WithConn(func(c Conn) error {
+		if c.(*poolTestConn).id != 1 {
+			t.Errorf("Expected a fresh connection")
+		}
+		return nil
+	})
+	if binds != 2 {
+		t.Errorf("Expected every new connection to be bound (binds = %d)", binds)
+	}
+}
+
+func TestPoolHealthCheckRefills(t *testing.T) {
+	dial, conns := newPoolTestDialer()
+	p, _ := NewPool(dial, PoolOptions{MinConns: 2, HealthCheck: poolTestHealthCheck})
+	defer p.Close()
+
+	(*conns)[0].healthy = false
+	p.checkIdle()
+	if !(*conns)[0].closed || len(*conns) != 3 || len(p.idle) != 2 {
+		t.Errorf("Expected unhealthy connection to be replaced (dialed %d, idle %d)", len(*conns), len(p.idle))
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	dial, conns := newPoolTestDialer()
+	p, _ := NewPool(dial, PoolOptions{MinConns: 1})
+	p.Close()
+	if !(*conns)[0].closed {
+		t.Errorf("Expected idle connection to be closed")
+	}
+	if _, err := p.Get(); err != ErrPoolClosed {
+		t.Errorf("Expected ErrPoolClosed, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package ldap
+
+import (
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+)
+
+const ControlTypePasswordPolicy = "1.3.6.1.4.1.42.2.27.8.5.1"
+
+type PasswordPolicyError int
+
+const (
+	PasswordExpired             PasswordPolicyError = 0
+	AccountLocked               PasswordPolicyError = 1
+	ChangeAfterReset            PasswordPolicyError = 2
+	PasswordModNotAllowed       PasswordPolicyError = 3
+	MustSupplyOldPassword       PasswordPolicyError = 4
+	InsufficientPasswordQuality PasswordPolicyError = 5
+	PasswordTooShort            PasswordPolicyError = 6
+	PasswordTooYoung            PasswordPolicyError = 7
+	PasswordInHistory           PasswordPolicyError = 8
+)
+
+var passwordPolicyErrors = map[PasswordPolicyError]string{
+	PasswordExpired:             "passwordExpired",
+	AccountLocked:               "accountLocked",
+	ChangeAfterReset:            "changeAfterReset",
+	PasswordModNotAllowed:       "passwordModNotAllowed",
+	MustSupplyOldPassword:       "mustSupplyOldPassword",
+	InsufficientPasswordQuality: "insufficientPasswordQuality",
+	PasswordTooShort:            "passwordTooShort",
+	PasswordTooYoung:            "passwordTooYoung",
+	PasswordInHistory:           "passwordInHistory",
+}
+
+func (e PasswordPolicyError) String() string {
+	if s, ok := passwordPolicyErrors[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("PasswordPolicyError(%d)", int(e))
+}
+
+// ControlPasswordPolicy implements the password policy control from
+// draft-behera-ldap-password-policy. Sent with an empty value on
+// requests; on responses the fields that the server did not report are
+// set to -1.
+type ControlPasswordPolicy struct {
+	TimeBeforeExpiration int
+	GraceAuthNsRemaining int
+	Error                PasswordPolicyError
+	Criticality          bool
+}
+
+// NewControlPasswordPolicy returns a control with no warning or error
+// set, suitable for attaching to a request.
+func NewControlPasswordPolicy() *ControlPasswordPolicy {
+	return &ControlPasswordPolicy{
+		TimeBeforeExpiration: -1,
+		GraceAuthNsRemaining: -1,
+		Error:                -1,
+	}
+}
+
+func (c *ControlPasswordPolicy) ControlType() string { return ControlTypePasswordPolicy }
+func (c *ControlPasswordPolicy) Critical() bool      { return c.Criticality }
+
+func (c *ControlPasswordPolicy) ControlValue() ([]byte, error) {
+	var v []interface{}
+	if c.TimeBeforeExpiration >= 0 {
+		v = append(v, asn1.OptionValue{Opts: "tag:0", Value: []interface{}{
+			asn1.OptionValue{Opts: "tag:0", Value: c.TimeBeforeExpiration}}})
+	} else if c.GraceAuthNsRemaining >= 0 {
+		v = append(v, asn1.OptionValue{Opts: "tag:0", Value: []interface{}{
+			asn1.OptionValue{Opts: "tag:1", Value: c.GraceAuthNsRemaining}}})
+	}
+	if c.Error >= 0 {
+		v = append(v, asn1.OptionValue{Opts: "tag:1,enum", Value: int(c.Error)})
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return encodeValue(v)
+}
+
+func decodeControlPasswordPolicy(c control) (Control, error) {
+	ctrl := NewControlPasswordPolicy()
+	ctrl.Criticality = c.Criticality
+	if len(c.Value) == 0 {
+		return ctrl, nil
+	}
+	var elements []asn1.RawValue
+	if err := decodeValue(c.Value, &elements); err != nil {
+		return nil, err
+	}
+	for _, e := range elements {
+		if e.Class != asn1.ClassContextSpecific {
+			return nil, fmt.Errorf("unexpected element (class = %d, tag = %d)", e.Class, e.Tag)
+		}
+		switch e.Tag {
+		case 0: // warning is a CHOICE, so its tag is explicit
+			var w asn1.RawValue
+			if err := decodeValue(e.Bytes, &w); err != nil {
+				return nil, err
+			}
+			var n int
+			if err := decodeValue(w.RawBytes, asn1.OptionValue{Opts: fmt.Sprintf("tag:%d", w.Tag), Value: &n}); err != nil {
+				return nil, err
+			}
+			switch w.Tag {
+			case 0:
+				ctrl.TimeBeforeExpiration = n
+			case 1:
+				ctrl.GraceAuthNsRemaining = n
+			}
+		case 1:
+			var n int
+			if err := decodeValue(e.RawBytes, asn1.OptionValue{Opts: "tag:1", Value: &n}); err != nil {
+				return nil, err
+			}
+			ctrl.Error = PasswordPolicyError(n)
+		}
+	}
+	return ctrl, nil
+}
+
+// BindWithPasswordPolicy performs a simple bind carrying the password
+// policy control. The server's policy response, if any, is returned even
+// when the bind fails so callers can tell an expired password from a
+// locked account.
+func (l *conn) BindWithPasswordPolicy(user, password string) (*ControlPasswordPolicy, error) {
+	ctrls, err := l.BindWithControls(user, password, NewControlPasswordPolicy())
+	ppolicy, _ := FindControl(ctrls, ControlTypePasswordPolicy).(*ControlPasswordPolicy)
+	return ppolicy, err
+}
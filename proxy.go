@@ -0,0 +1,203 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ProxyDialer returns a function for DialOpts.DialContext that connects
+// through the proxy named by rawurl: socks5://host:port (RFC 1928) or
+// http://host:port, which must allow CONNECT. Credentials may be given
+// as user:password@ in the URL. Host names are resolved by the proxy.
+func ProxyDialer(rawurl string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: bad proxy URL: %v", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("ldap: bad proxy URL %q: no host", rawurl)
+	}
+	var handshake func(c net.Conn, addr string, u *url.URL) error
+	var port string
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		handshake, port = socks5Connect, "1080"
+	case "http":
+		handshake, port = httpConnect, "8080"
+	default:
+		return nil, fmt.Errorf("ldap: unsupported proxy scheme %q", u.Scheme)
+	}
+	proxy := hostPort(u.Host, port)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network != "tcp" {
+			return nil, fmt.Errorf("ldap: cannot proxy %s connections", network)
+		}
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "tcp", proxy)
+		if err != nil {
+			return nil, err
+		}
+		if err := withContext(ctx, c, func() error { return handshake(c, addr, u) }); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("ldap: proxy %s: %v", proxy, err)
+		}
+		return c, nil
+	}, nil
+}
+
+// withContext calls fn, interrupting its I/O on c if ctx is done.
+func withContext(ctx context.Context, c net.Conn, fn func() error) error {
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	err := fn()
+	if !stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
+var socks5Errors = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect asks a SOCKS5 proxy on c to connect to addr, with
+// username and password authentication (RFC 1929) if u has a user.
+func socks5Connect(c net.Conn, addr string, u *url.URL) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("bad port %q", portStr)
+	}
+
+	method := byte(0x00)
+	if u.User != nil {
+		method = 0x02
+	}
+	if _, err := c.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		return err
+	}
+	if buf[0] != 5 || buf[1] != method {
+		return fmt.Errorf("authentication method refused")
+	}
+	if method == 0x02 {
+		username := u.User.Username()
+		password, _ := u.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return fmt.Errorf("credentials too long")
+		}
+		req := []byte{1, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := c.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return fmt.Errorf("authentication failed")
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long")
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := c.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(c, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 {
+		return fmt.Errorf("bad reply version %d", reply[0])
+	}
+	if reply[1] != 0 {
+		if msg, ok := socks5Errors[reply[1]]; ok {
+			return fmt.Errorf("%s", msg)
+		}
+		return fmt.Errorf("connect failed (%d)", reply[1])
+	}
+	// Discard the bound address and port.
+	var n int
+	switch reply[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err := io.ReadFull(c, reply[:1]); err != nil {
+			return err
+		}
+		n = int(reply[0])
+	default:
+		return fmt.Errorf("bad address type %d", reply[3])
+	}
+	_, err = io.ReadFull(c, make([]byte, n+2))
+	return err
+}
+
+// httpConnect asks an HTTP proxy on c to connect to addr, with basic
+// authentication if u has a user.
+func httpConnect(c net.Conn, addr string, u *url.URL) error {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(c); err != nil {
+		return err
+	}
+	// The server does not speak first, so nothing past the response
+	// can have been buffered.
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT refused: %s", resp.Status)
+	}
+	return nil
+}
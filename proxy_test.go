@@ -0,0 +1,208 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// proxyTest runs a proxy on a local listener with serve, which returns
+// the address asked for, and echoes whatever is sent after.
+type proxyTest struct {
+	l     net.Listener
+	addrs chan string
+}
+
+func startProxyTest(t *testing.T, serve func(c net.Conn) (string, error)) *proxyTest {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxyTest{l: l, addrs: make(chan string, 1)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				addr, err := serve(c)
+				if err != nil {
+					p.addrs <- "error: " + err.Error()
+					return
+				}
+				p.addrs <- addr
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return p
+}
+
+// serveSOCKS5 accepts the user "user" with password "secret" if auth is
+// set, and refuses to connect to port 1.
+func serveSOCKS5(auth bool) func(c net.Conn) (string, error) {
+	return func(c net.Conn) (string, error) {
+		buf := make([]byte, 3)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return "", err
+		}
+		method := byte(0)
+		if auth {
+			method = 2
+		}
+		if buf[2] != method {
+			c.Write([]byte{5, 0xff})
+			return "", fmt.Errorf("bad method %d", buf[2])
+		}
+		c.Write([]byte{5, method})
+		if auth {
+			r := bufio.NewReader(c)
+			r.ReadByte()
+			n, _ := r.ReadByte()
+			user := make([]byte, n)
+			io.ReadFull(r, user)
+			n, _ = r.ReadByte()
+			password := make([]byte, n)
+			io.ReadFull(r, password)
+			if string(user) != "user" || string(password) != "secret" {
+				c.Write([]byte{1, 1})
+				return "", fmt.Errorf("bad credentials %s:%s", user, password)
+			}
+			c.Write([]byte{1, 0})
+		}
+		if _, err := io.ReadFull(c, buf[:3]); err != nil {
+			return "", err
+		}
+		var host string
+		atyp := make([]byte, 1)
+		io.ReadFull(c, atyp)
+		switch atyp[0] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(c, ip)
+			host = net.IP(ip).String()
+		case 3:
+			n := make([]byte, 1)
+			io.ReadFull(c, n)
+			name := make([]byte, n[0])
+			io.ReadFull(c, name)
+			host = string(name)
+		}
+		port := make([]byte, 2)
+		io.ReadFull(c, port)
+		addr := net.JoinHostPort(host, fmt.Sprint(binary.BigEndian.Uint16(port)))
+		if binary.BigEndian.Uint16(port) == 1 {
+			c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return addr, nil
+		}
+		c.Write([]byte{5, 0, 0, 3, 5, 'p', 'r', 'o', 'x', 'y', 0, 1})
+		return addr, nil
+	}
+}
+
+// serveHTTPConnect requires basic credentials user:secret if auth is
+// set.
+func serveHTTPConnect(auth bool) func(c net.Conn) (string, error) {
+	return func(c net.Conn) (string, error) {
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return "", err
+		}
+		if req.Method != "CONNECT" {
+			return "", fmt.Errorf("bad method %s", req.Method)
+		}
+		if user, password, _ := (&http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}).BasicAuth(); auth && (user != "user" || password != "secret") {
+			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return req.Host, nil
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return req.Host, nil
+	}
+}
+
+func TestProxyDialer(t *testing.T) {
+	tests := []struct {
+		scheme, userinfo string
+		serve            func(c net.Conn) (string, error)
+		target           string
+		err              string
+	}{
+		{"socks5", "", serveSOCKS5(false), "dc.example.com:389", ""},
+		{"socks5", "", serveSOCKS5(false), "10.0.0.1:636", ""},
+		{"socks5h", "user:secret@", serveSOCKS5(true), "dc.example.com:389", ""},
+		{"socks5", "user:wrong@", serveSOCKS5(true), "dc.example.com:389", "authentication failed"},
+		{"socks5", "", serveSOCKS5(true), "dc.example.com:389", "method refused"},
+		{"socks5", "", serveSOCKS5(false), "dc.example.com:1", "connection refused"},
+		{"http", "", serveHTTPConnect(false), "dc.example.com:389", ""},
+		{"http", "user:secret@", serveHTTPConnect(true), "dc.example.com:389", ""},
+		{"http", "", serveHTTPConnect(true), "dc.example.com:389", "407"},
+	}
+	for i, test := range tests {
+		p := startProxyTest(t, test.serve)
+		dial, err := ProxyDialer(test.scheme + "://" + test.userinfo + p.l.Addr().String())
+		if err != nil {
+			t.Fatalf("#%d: Unexpected error: %v", i, err)
+		}
+		c, err := dial(context.Background(), "tcp", test.target)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("#%d: Bad result: %v (expected %q)", i, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+		} else {
+			if addr := <-p.addrs; addr != test.target {
+				t.Errorf("#%d: Bad address: %q (expected %q)", i, addr, test.target)
+			}
+			io.WriteString(c, "ping")
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("#%d: Bad echo: %q, %v", i, buf, err)
+			}
+			c.Close()
+		}
+		p.l.Close()
+	}
+
+	for _, rawurl := range []string{"ftp://proxy", "socks5://", "://"} {
+		if _, err := ProxyDialer(rawurl); err == nil {
+			t.Errorf("%s: Expected error", rawurl)
+		}
+	}
+}
+
+func TestDialContextOption(t *testing.T) {
+	var network, addr string
+	server := make(chan net.Conn, 1)
+	opts := DialOpts{DialContext: func(ctx context.Context, n, a string) (net.Conn, error) {
+		network, addr = n, a
+		client, s := net.Pipe()
+		server <- s
+		return client, nil
+	}}
+	c, err := DialWithOpts(context.Background(), "ldap://dc.example.com", opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer c.Close()
+	if network != "tcp" || addr != "dc.example.com:389" {
+		t.Errorf("Bad result: %s %s (expected tcp dc.example.com:389)", network, addr)
+	}
+
+	s := <-server
+	go func() {
+		m, _ := readTestMessage(s)
+		writeTestMessage(s, m.MessageId, "application,tag:1", ldapResult{})
+	}()
+	if err := c.Bind("", ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package ldap
+
+const (
+	ControlTypePersistentSearch        = "2.16.840.1.113730.3.4.3"
+	ControlTypeEntryChangeNotification = "2.16.840.1.113730.3.4.7"
+)
+
+type ChangeType int
+
+const (
+	ChangeAdd    ChangeType = 1
+	ChangeDelete ChangeType = 2
+	ChangeModify ChangeType = 4
+	ChangeModDN  ChangeType = 8
+
+	ChangeAny = ChangeAdd | ChangeDelete | ChangeModify | ChangeModDN
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdd:
+		return "add"
+	case ChangeDelete:
+		return "delete"
+	case ChangeModify:
+		return "modify"
+	case ChangeModDN:
+		return "modDN"
+	}
+	return "unknown"
+}
+
+// ControlPersistentSearch turns a search into a subscription: after the
+// initial results (unless ChangesOnly is set) the server keeps sending
+// entries as they change. ChangeTypes is a mask of the changes of
+// interest; with ReturnECs set every entry carries an
+// EntryChangeNotification control describing the change.
+type ControlPersistentSearch struct {
+	ChangeTypes ChangeType
+	ChangesOnly bool
+	ReturnECs   bool
+	Criticality bool
+}
+
+type persistentSearchValue struct {
+	ChangeTypes int
+	ChangesOnly bool
+	ReturnECs   bool
+}
+
+func (c *ControlPersistentSearch) ControlType() string { return ControlTypePersistentSearch }
+func (c *ControlPersistentSearch) Critical() bool      { return c.Criticality }
+
+func (c *ControlPersistentSearch) ControlValue() ([]byte, error) {
+	return encodeValue(persistentSearchValue{int(c.ChangeTypes), c.ChangesOnly, c.ReturnECs})
+}
+
+type ControlEntryChangeNotification struct {
+	ChangeType   ChangeType
+	PreviousDN   string
+	ChangeNumber int
+}
+
+type entryChangeNotificationValue struct {
+	ChangeType   int    `asn1:"enum"`
+	PreviousDN   []byte `asn1:"optional"`
+	ChangeNumber int    `asn1:"optional"`
+}
+
+func (c *ControlEntryChangeNotification) ControlType() string {
+	return ControlTypeEntryChangeNotification
+}
+func (c *ControlEntryChangeNotification) Critical() bool { return false }
+
+func (c *ControlEntryChangeNotification) ControlValue() ([]byte, error) {
+	return encodeValue(entryChangeNotificationValue{int(c.ChangeType), optionalBytes(c.PreviousDN), c.ChangeNumber})
+}
+
+func decodeControlEntryChangeNotification(c control) (Control, error) {
+	var v entryChangeNotificationValue
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, err
+	}
+	return &ControlEntryChangeNotification{ChangeType(v.ChangeType), string(v.PreviousDN), v.ChangeNumber}, nil
+}
+
+// PersistentSearch runs req with the persistent search control and
+// calls fn for every entry the server sends. The notification is nil for
+// entries from the initial result set or when ReturnECs is not set. The
+// call blocks until fn returns an error, at which point the search is
+// abandoned and the error returned, or until the connection fails. The
+// connection cannot be used for other operations in the meantime. Only
+// the first notification attached to an entry is passed to fn; SearchFunc
+// with FindAllControls sees them all.
+func (l *conn) PersistentSearch(req SearchRequest, psearch *ControlPersistentSearch, fn func(SearchResult, *ControlEntryChangeNotification) error) error {
+	_, err := l.search(req, []Control{psearch}, searchFunc(func(result SearchResult, controls []Control) error {
+		ecn, _ := FindControl(controls, ControlTypeEntryChangeNotification).(*ControlEntryChangeNotification)
+		return fn(result, ecn)
+	}))
+	return err
+}
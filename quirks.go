@@ -0,0 +1,148 @@
+package ldap
+
+import (
+	"github.com/stesla/ldap/asn1"
+	"strings"
+	"unicode/utf8"
+)
+
+// Quirks are deviations from RFC 4511 by known servers that a connection
+// tolerates rather than treating them as errors.
+type Quirks struct {
+	// Latin1Diagnostics decodes diagnostic messages that are not valid
+	// UTF-8 as ISO 8859-1.
+	Latin1Diagnostics bool
+	// OmittedResultFields accepts results that leave out an empty
+	// matchedDN or diagnosticMessage rather than sending an empty
+	// string.
+	OmittedResultFields bool
+	// ValuelessControls returns response controls that lack the value
+	// their type calls for as a ControlRaw, instead of failing.
+	ValuelessControls bool
+}
+
+// merge returns the quirks of q and r together.
+func (q Quirks) merge(r Quirks) Quirks {
+	return Quirks{
+		Latin1Diagnostics:   q.Latin1Diagnostics || r.Latin1Diagnostics,
+		OmittedResultFields: q.OmittedResultFields || r.OmittedResultFields,
+		ValuelessControls:   q.ValuelessControls || r.ValuelessControls,
+	}
+}
+
+var vendorQuirks = []struct {
+	match  func(dse *RootDSE) bool
+	quirks Quirks
+}{
+	{isActiveDirectory, Quirks{OmittedResultFields: true, ValuelessControls: true}},
+	{vendorIs("Novell"), Quirks{Latin1Diagnostics: true}},
+}
+
+// RegisterQuirks adds quirks to those QuirksFor returns for the servers
+// whose root DSE match accepts.
+func RegisterQuirks(match func(dse *RootDSE) bool, quirks Quirks) {
+	vendorQuirks = append(vendorQuirks, struct {
+		match  func(dse *RootDSE) bool
+		quirks Quirks
+	}{match, quirks})
+}
+
+// QuirksFor returns the quirks known of the server with the root DSE
+// dse. DialOpts.DetectQuirks applies them when the root DSE is read.
+func QuirksFor(dse *RootDSE) Quirks {
+	var q Quirks
+	for _, v := range vendorQuirks {
+		if v.match(dse) {
+			q = q.merge(v.quirks)
+		}
+	}
+	return q
+}
+
+const oidActiveDirectoryCapability = "1.2.840.113556.1.4.800"
+
+func isActiveDirectory(dse *RootDSE) bool {
+	return dse.Entry != nil && contains(dse.Entry.GetAttributeValues("supportedCapabilities"), oidActiveDirectoryCapability)
+}
+
+func vendorIs(prefix string) func(dse *RootDSE) bool {
+	return func(dse *RootDSE) bool {
+		return strings.HasPrefix(dse.VendorName, prefix)
+	}
+}
+
+func (s *session) quirks() Quirks {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tolerated
+}
+
+// resultTags are the tags of the responses that are an LDAPResult with,
+// perhaps, more fields.
+var resultTags = map[int]bool{1: true, 5: true, 7: true, 9: true, 11: true, 13: true, 15: true, 24: true}
+
+// fixResult rewrites the response raw, if it is a result that q
+// tolerates, as the server should have sent it.
+func (q Quirks) fixResult(raw asn1.RawValue) asn1.RawValue {
+	if !q.Latin1Diagnostics && !q.OmittedResultFields || raw.Class != asn1.ClassApplication || !resultTags[raw.Tag] {
+		return raw
+	}
+	code, rest, err := asn1.ParseRawValue(raw.Bytes)
+	if err != nil || code.Tag != asn1.TagEnumerated {
+		return raw
+	}
+	// matchedDN and diagnosticMessage
+	var fields [2][]byte
+	changed := false
+	for i := range fields {
+		v, next, err := asn1.ParseRawValue(rest)
+		if err == nil && v.Class == asn1.ClassUniversal && v.Tag == asn1.TagOctetString {
+			fields[i], rest = v.Bytes, next
+			continue
+		}
+		if !q.OmittedResultFields {
+			return raw
+		}
+		changed = true
+	}
+	if q.Latin1Diagnostics && !utf8.Valid(fields[1]) {
+		fields[1] = latin1ToUTF8(fields[1])
+		changed = true
+	}
+	if !changed {
+		return raw
+	}
+	b := append([]byte{}, code.RawBytes...)
+	for _, f := range fields {
+		b = asn1.AppendOctetString(b, asn1.ClassUniversal, asn1.TagOctetString, f)
+	}
+	b = append(b, rest...)
+	raw.Bytes = b
+	raw.RawBytes = append(asn1.AppendHeader(nil, raw.Class, raw.Tag, true, len(b)), b...)
+	return raw
+}
+
+func latin1ToUTF8(b []byte) []byte {
+	out := make([]byte, 0, 2*len(b))
+	for _, c := range b {
+		out = utf8.AppendRune(out, rune(c))
+	}
+	return out
+}
+
+// decodeValuelessControls decodes controls as decodeControls does, but
+// returns those without a value that fail to decode as a ControlRaw.
+func decodeValuelessControls(controls []control) ([]Control, error) {
+	out := make([]Control, 0, len(controls))
+	for _, c := range controls {
+		ctrls, err := decodeControls([]control{c})
+		if err != nil && c.Value == nil {
+			ctrls, err = []Control{&ControlRaw{string(c.Type), c.Criticality, nil}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ctrls...)
+	}
+	return out, nil
+}
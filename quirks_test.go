@@ -0,0 +1,104 @@
+package ldap
+
+import (
+	"github.com/stesla/ldap/asn1"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type terseResult struct {
+	ResultCode ResultCode `asn1:"enum"`
+}
+
+type latin1Result struct {
+	ResultCode ResultCode `asn1:"enum"`
+	MatchedDN  []byte
+	Message    []byte
+}
+
+func TestQuirksFixResult(t *testing.T) {
+	tests := []struct {
+		quirks Quirks
+		op     interface{}
+		out    ldapResult
+		err    bool
+	}{
+		{Quirks{}, terseResult{NoSuchObject}, ldapResult{}, true},
+		{Quirks{OmittedResultFields: true}, terseResult{NoSuchObject},
+			ldapResult{ResultCode: NoSuchObject, MatchedDN: []byte{}, Message: []byte{}}, false},
+		{Quirks{}, latin1Result{Other, []byte{}, []byte("r\xe9ponse")},
+			ldapResult{ResultCode: Other, MatchedDN: []byte{}, Message: []byte("r\xe9ponse")}, false},
+		{Quirks{Latin1Diagnostics: true}, latin1Result{Other, []byte{}, []byte("r\xe9ponse")},
+			ldapResult{ResultCode: Other, MatchedDN: []byte{}, Message: []byte("réponse")}, false},
+		{Quirks{Latin1Diagnostics: true, OmittedResultFields: true}, ldapResult{Other, []byte("dc=x"), []byte("ok"), [][]byte{[]byte("ldap://y")}},
+			ldapResult{Other, []byte("dc=x"), []byte("ok"), [][]byte{[]byte("ldap://y")}}, false},
+	}
+	for i, test := range tests {
+		b, err := encodeValue(asn1.OptionValue{Opts: "application,tag:15", Value: test.op})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _, _ := asn1.ParseRawValue(b)
+		var out ldapResult
+		err = decodeOp(test.quirks.fixResult(raw), "application,tag:15", &out)
+		if (err != nil) != test.err || !test.err && !reflect.DeepEqual(out, test.out) {
+			t.Errorf("#%d: Bad result: %+v, %v (expected %+v)", i, out, err, test.out)
+		}
+	}
+}
+
+func TestQuirksValuelessControls(t *testing.T) {
+	for i, quirks := range []Quirks{{}, {ValuelessControls: true}} {
+		client, server := net.Pipe()
+		c := newConnWithOpts(client, DialOpts{Quirks: quirks})
+		go func() {
+			m, _ := readTestMessage(server)
+			enc := asn1.NewEncoder(server)
+			enc.Implicit = true
+			enc.Encode(ldapMessage{
+				MessageId:  m.MessageId,
+				ProtocolOp: asn1.OptionValue{Opts: "application,tag:5", Value: ldapResult{MatchedDN: []byte{}, Message: []byte{}}},
+				Controls:   []control{{Type: []byte(ControlTypePaging)}},
+			})
+		}()
+		resp, err := c.SearchWithControls(SearchRequest{Filter: Present("objectClass")})
+		if quirks.ValuelessControls {
+			if err != nil || len(resp.Controls) != 1 || resp.Controls[0].ControlType() != ControlTypePaging {
+				t.Errorf("#%d: Bad result: %v, %v", i, resp, err)
+			}
+		} else if err == nil {
+			t.Errorf("#%d: Expected error", i)
+		}
+		c.Close()
+	}
+}
+
+func TestDetectQuirks(t *testing.T) {
+	ad := &RootDSE{Entry: NewEntry("", map[string][]string{"supportedCapabilities": {oidActiveDirectoryCapability}})}
+	if q := QuirksFor(ad); !q.OmittedResultFields || !q.ValuelessControls || q.Latin1Diagnostics {
+		t.Errorf("Bad result: %+v", q)
+	}
+	if q := QuirksFor(&RootDSE{VendorName: "OpenLDAP"}); q != (Quirks{}) {
+		t.Errorf("Bad result: %+v", q)
+	}
+
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{DetectQuirks: true})
+	defer c.Close()
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte{}, []partialAttribute{
+			{[]byte("supportedCapabilities"), [][]byte{[]byte(oidActiveDirectoryCapability)}},
+		}})
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+		m, _ = readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:15", terseResult{CompareTrue})
+	}()
+	if _, err := c.RootDSE(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Compare("cn=x", "cn", "x"); !ok || err != nil {
+		t.Errorf("Bad result: %v, %v (expected true, <nil>)", ok, err)
+	}
+}
@@ -0,0 +1,45 @@
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"sync/atomic"
+)
+
+// ErrReadOnly is returned, wrapped with the operation and its DN, by the
+// writes made on a read-only connection: see DialOpts.ReadOnly.
+var ErrReadOnly = errors.New("ldap: connection is read-only")
+
+// refuse returns an error wrapping ErrReadOnly if the session is
+// read-only and op would change the directory.
+func (s *session) refuse(op interface{}) error {
+	if atomic.LoadInt32(&s.readOnly) == 0 {
+		return nil
+	}
+	switch opTag(op) {
+	case 6, 8, 10, 12: // modify, add, delete, modifyDN
+	case 23:
+		r, _ := op.(asn1.OptionValue).Value.(extendedRequest)
+		if string(r.Name) != oidPasswordModify {
+			return nil
+		}
+	default:
+		return nil
+	}
+	req := newRequest(op, nil)
+	if req.DN == "" {
+		return fmt.Errorf("%w: %s", ErrReadOnly, req.Op)
+	}
+	return fmt.Errorf("%w: %s %s", ErrReadOnly, req.Op, req.DN)
+}
+
+// setReadOnly makes c read-only, if it is a connection of this package.
+func setReadOnly(c Conn) error {
+	l, ok := c.(*conn)
+	if !ok {
+		return fmt.Errorf("ldap: cannot make %T read-only", c)
+	}
+	atomic.StoreInt32(&l.readOnly, 1)
+	return nil
+}
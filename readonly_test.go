@@ -0,0 +1,77 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{ReadOnly: true})
+	defer c.Close()
+
+	writes := []func(Conn) error{
+		func(c Conn) error { return c.Add("cn=x", []Attribute{{"cn", []string{"x"}}}) },
+		func(c Conn) error {
+			return c.Modify("cn=x", []Modification{{ReplaceValues, Attribute{"sn", []string{"y"}}}})
+		},
+		func(c Conn) error { return c.Del("cn=x") },
+		func(c Conn) error { return c.ModifyDN("cn=x", "cn=y", true, "") },
+		func(c Conn) error { _, err := c.PasswordModify("cn=x", "old", "new"); return err },
+		func(c Conn) error { return c.WithContext(context.Background()).Del("cn=x") },
+	}
+	for i, write := range writes {
+		if err := write(c); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, err, ErrReadOnly)
+		}
+	}
+
+	// Reads are sent, and are the first thing the server sees.
+	go func() {
+		m, _ := readTestMessage(server)
+		if m.Op.Tag != 14 {
+			t.Errorf("Bad request: %d (expected 14)", m.Op.Tag)
+		}
+		writeTestMessage(server, m.MessageId, "application,tag:15", ldapResult{ResultCode: CompareTrue, MatchedDN: []byte{}, Message: []byte{}})
+	}()
+	if ok, err := c.Compare("cn=x", "cn", "x"); !ok || err != nil {
+		t.Errorf("Compare: %v, %v (expected true, nil)", ok, err)
+	}
+}
+
+func TestReadOnlyPool(t *testing.T) {
+	dial := func() (Conn, error) {
+		client, _ := net.Pipe()
+		return NewConn(client), nil
+	}
+	p, err := NewPool(dial, PoolOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer p.Close()
+	c, err := p.Get()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := c.Del("cn=x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Bad result: %v (expected %v)", err, ErrReadOnly)
+	}
+	p.Put(c)
+	c, err = p.Dial()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer c.Close()
+	if err := c.Del("cn=x"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Dial: Bad result: %v (expected %v)", err, ErrReadOnly)
+	}
+
+	testDial, _ := newPoolTestDialer()
+	p, err = NewPool(testDial, PoolOptions{ReadOnly: true, MinConns: 1})
+	if err == nil {
+		p.Close()
+		t.Errorf("Expected error for a connection that cannot be made read-only")
+	}
+}
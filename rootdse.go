@@ -0,0 +1,131 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RootDSE describes a server's capabilities, as published in the entry
+// with the empty DN (RFC 4512 §5.1).
+type RootDSE struct {
+	SupportedLDAPVersion    []int
+	NamingContexts          []string
+	SupportedControl        []string
+	SupportedExtension      []string
+	SupportedFeatures       []string
+	SupportedSASLMechanisms []string
+	SubschemaSubentry       string
+	VendorName              string
+	VendorVersion           string
+
+	// Entry holds every attribute returned, including those without a
+	// field above.
+	Entry *Entry
+}
+
+var rootDSEAttributes = [][]byte{
+	[]byte("*"),
+	[]byte("+"),
+	[]byte("supportedLDAPVersion"),
+	[]byte("namingContexts"),
+	[]byte("supportedControl"),
+	[]byte("supportedExtension"),
+	[]byte("supportedFeatures"),
+	[]byte("supportedCapabilities"),
+	[]byte("supportedSASLMechanisms"),
+	[]byte("subschemaSubentry"),
+	[]byte("vendorName"),
+	[]byte("vendorVersion"),
+}
+
+// RootDSE reads the server's root DSE. The result is remembered until
+// StartTLS, which may change what the server offers, and is consulted
+// by operations that depend on optional server features.
+func (l *conn) RootDSE() (*RootDSE, error) {
+	results, err := l.Search(SearchRequest{
+		BaseObject: []byte{},
+		Scope:      BaseObject,
+		Filter:     Present("objectClass"),
+		Attributes: rootDSEAttributes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("ldap: root DSE search returned %d entries", len(results))
+	}
+	dse := newRootDSE(results[0].Entry())
+
+	l.mu.Lock()
+	l.rootDSE = dse
+	if l.detect {
+		l.tolerated = l.tolerated.merge(QuirksFor(dse))
+	}
+	l.mu.Unlock()
+	return dse, nil
+}
+
+func newRootDSE(e *Entry) *RootDSE {
+	dse := &RootDSE{
+		NamingContexts:          e.GetAttributeValues("namingContexts"),
+		SupportedControl:        e.GetAttributeValues("supportedControl"),
+		SupportedExtension:      e.GetAttributeValues("supportedExtension"),
+		SupportedFeatures:       e.GetAttributeValues("supportedFeatures"),
+		SupportedSASLMechanisms: e.GetAttributeValues("supportedSASLMechanisms"),
+		SubschemaSubentry:       e.GetAttributeValue("subschemaSubentry"),
+		VendorName:              e.GetAttributeValue("vendorName"),
+		VendorVersion:           e.GetAttributeValue("vendorVersion"),
+		Entry:                   e,
+	}
+	for _, v := range e.GetAttributeValues("supportedLDAPVersion") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			dse.SupportedLDAPVersion = append(dse.SupportedLDAPVersion, n)
+		}
+	}
+	return dse
+}
+
+// cachedRootDSE returns the root DSE read by the last call to RootDSE,
+// or nil if there has been none.
+func (s *session) cachedRootDSE() *RootDSE {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rootDSE
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dse *RootDSE) SupportsControl(oid string) bool {
+	return contains(dse.SupportedControl, oid)
+}
+
+func (dse *RootDSE) SupportsExtension(oid string) bool {
+	return contains(dse.SupportedExtension, oid)
+}
+
+func (dse *RootDSE) SupportsFeature(oid string) bool {
+	return contains(dse.SupportedFeatures, oid)
+}
+
+func (dse *RootDSE) SupportsSASLMechanism(name string) bool {
+	return contains(dse.SupportedSASLMechanisms, name)
+}
+
+// ChooseSASLMechanism returns the first of mechs, in order of
+// preference, that the server supports, or nil if there is none.
+func (dse *RootDSE) ChooseSASLMechanism(mechs ...SASLMechanism) SASLMechanism {
+	for _, m := range mechs {
+		if dse.SupportsSASLMechanism(m.Name()) {
+			return m
+		}
+	}
+	return nil
+}
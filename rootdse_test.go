@@ -0,0 +1,54 @@
+package ldap
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestRootDSE(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:4", struct {
+			Name       []byte
+			Attributes []partialAttribute
+		}{[]byte{}, []partialAttribute{
+			{[]byte("supportedLDAPVersion"), [][]byte{[]byte("3")}},
+			{[]byte("namingContexts"), [][]byte{[]byte("dc=example,dc=com")}},
+			{[]byte("supportedControl"), [][]byte{[]byte(ControlTypeServerSideSort)}},
+			{[]byte("supportedSASLMechanisms"), [][]byte{[]byte("EXTERNAL"), []byte("GSS-SPNEGO")}},
+			{[]byte("vendorName"), [][]byte{[]byte("Example")}},
+		}})
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+
+	dse, err := c.RootDSE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int{3}; !reflect.DeepEqual(dse.SupportedLDAPVersion, expected) {
+		t.Errorf("Bad result: %v (expected %v)", dse.SupportedLDAPVersion, expected)
+	}
+	if expected := []string{"dc=example,dc=com"}; !reflect.DeepEqual(dse.NamingContexts, expected) {
+		t.Errorf("Bad result: %v (expected %v)", dse.NamingContexts, expected)
+	}
+	if dse.VendorName != "Example" {
+		t.Errorf("Bad result: %q (expected %q)", dse.VendorName, "Example")
+	}
+	if !dse.SupportsControl(ControlTypeServerSideSort) || dse.SupportsControl(ControlTypePaging) {
+		t.Errorf("Bad result: %v", dse.SupportedControl)
+	}
+	external := &SASLExternal{}
+	if m := dse.ChooseSASLMechanism(&SASLGSSSPNEGO{}, external); m == nil || m.Name() != "GSS-SPNEGO" {
+		t.Errorf("Bad result: %v (expected GSS-SPNEGO)", m)
+	}
+
+	// The server does not advertise paging, so no search is sent.
+	if _, err := c.SearchWithPaging(SearchRequest{Filter: Present("objectClass")}, 10); err == nil {
+		t.Errorf("Expected an error searching with paging")
+	}
+}
@@ -0,0 +1,155 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+)
+
+// A SASLMechanism implements the client side of a SASL authentication
+// mechanism (RFC 4422) for use with SASLBind.
+type SASLMechanism interface {
+	// Name returns the registered name of the mechanism, e.g. "EXTERNAL".
+	Name() string
+	// Start returns the initial response, or nil if there is none.
+	Start() ([]byte, error)
+	// Next computes the response to a server challenge. It is also
+	// called with the additional data sent along with a successful
+	// outcome, if any, so the mechanism can verify the server.
+	Next(challenge []byte) ([]byte, error)
+}
+
+type saslCredentials struct {
+	Mechanism   []byte
+	Credentials []byte `asn1:"optional"`
+}
+
+type bindResponse struct {
+	Result          ldapResult `asn1:"components"`
+	ServerSaslCreds []byte     `asn1:"tag:7,optional"`
+}
+
+// SASLBind authenticates using mech, carrying out as many rounds of the
+// exchange as the mechanism requires.
+func (l *conn) SASLBind(mech SASLMechanism) error {
+	if cbm, ok := mech.(ChannelBindingMechanism); ok && l.isTLS() {
+		data, err := l.ChannelBinding(cbm.ChannelBindingType())
+		if err != nil {
+			return err
+		}
+		cbm.SetChannelBinding(data)
+	}
+
+	creds, err := mech.Start()
+	if err != nil {
+		return err
+	}
+	for {
+		op := asn1.OptionValue{
+			Opts: "application,tag:0", Value: bindRequest{
+				Version: 3,
+				Auth: asn1.OptionValue{Opts: "tag:3", Value: saslCredentials{
+					Mechanism:   []byte(mech.Name()),
+					Credentials: creds,
+				}},
+			},
+		}
+		raw, _, err := l.roundTrip(op, nil)
+		if err != nil {
+			return err
+		}
+		var r bindResponse
+		if err := decodeOp(raw, "application,tag:1", &r); err != nil {
+			return fmt.Errorf("Decode: %v", err)
+		}
+
+		switch r.Result.ResultCode {
+		case Success:
+			if r.ServerSaslCreds != nil {
+				if _, err := mech.Next(r.ServerSaslCreds); err != nil {
+					return err
+				}
+			}
+			return nil
+		case SaslBindInProgress:
+			if creds, err = mech.Next(r.ServerSaslCreds); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("ldap.SASLBind unsuccessful: %w", r.Result.toError())
+		}
+	}
+}
+
+// SASLBindAny binds with the first of mechs, in order of preference,
+// that the server advertises. It reads the root DSE if RootDSE has not
+// been called already.
+func (l *conn) SASLBindAny(mechs ...SASLMechanism) error {
+	dse := l.cachedRootDSE()
+	if dse == nil {
+		var err error
+		if dse, err = l.RootDSE(); err != nil {
+			return err
+		}
+	}
+	mech := dse.ChooseSASLMechanism(mechs...)
+	if mech == nil {
+		return fmt.Errorf("ldap: server supports none of the SASL mechanisms offered")
+	}
+	return l.SASLBind(mech)
+}
+
+func (l *conn) isTLS() bool {
+	_, ok := l.Conn.(*tls.Conn)
+	return ok
+}
+
+// SASLExternal implements the EXTERNAL mechanism, in which the server
+// derives the client's identity from the transport: the TLS client
+// certificate, or the peer credentials of an ldapi:// socket. AuthzID
+// optionally requests a different authorization identity.
+type SASLExternal struct {
+	AuthzID string
+}
+
+func (m *SASLExternal) Name() string { return "EXTERNAL" }
+
+func (m *SASLExternal) Start() ([]byte, error) {
+	return optionalBytes(m.AuthzID), nil
+}
+
+func (m *SASLExternal) Next(challenge []byte) ([]byte, error) {
+	if len(challenge) > 0 {
+		return nil, fmt.Errorf("EXTERNAL: unexpected challenge")
+	}
+	return nil, nil
+}
+
+// ExternalBind performs a SASL EXTERNAL bind. Use DialSSL or StartTLS
+// with a tls.Config carrying a client certificate, or DialURL with an
+// ldapi:// URL, to give the server an identity to work with.
+func (l *conn) ExternalBind(authzID string) error {
+	return l.SASLBind(&SASLExternal{authzID})
+}
+
+// SASLPlain implements the PLAIN mechanism (RFC 4616), which sends the
+// password in the clear, so use it over TLS. AuthzID optionally requests
+// a different authorization identity.
+type SASLPlain struct {
+	AuthzID  string
+	Username string
+	Password string
+}
+
+func (m *SASLPlain) Name() string { return "PLAIN" }
+
+func (m *SASLPlain) Start() ([]byte, error) {
+	return []byte(m.AuthzID + "\x00" + m.Username + "\x00" + m.Password), nil
+}
+
+func (m *SASLPlain) Next(challenge []byte) ([]byte, error) {
+	if len(challenge) > 0 {
+		return nil, fmt.Errorf("PLAIN: unexpected challenge")
+	}
+	return nil, nil
+}
@@ -0,0 +1,77 @@
+package ldap
+
+import (
+	"net"
+	"testing"
+)
+
+type saslBindRequest struct {
+	Version int8
+	Name    []byte
+	Auth    saslCredentials `asn1:"tag:3"`
+}
+
+func TestExternalBind(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	tests := []struct {
+		authzID string
+		code    ResultCode
+		creds   []byte
+	}{
+		// Without an authzId the credentials are omitted altogether, so
+		// that the server uses the identity of the transport.
+		{"", Success, nil},
+		{"dn:cn=alice,dc=example,dc=com", Success, []byte("dn:cn=alice,dc=example,dc=com")},
+		{"u:bob", InvalidCredentials, []byte("u:bob")},
+	}
+	requests := make(chan saslBindRequest, len(tests))
+	go func() {
+		for _, test := range tests {
+			m, err := readTestMessage(server)
+			if err != nil {
+				return
+			}
+			var req saslBindRequest
+			decodeOp(m.Op, "application,tag:0", &req)
+			requests <- req
+			writeTestMessage(server, m.MessageId, "application,tag:1", ldapResult{ResultCode: test.code, MatchedDN: []byte{}, Message: []byte{}})
+		}
+	}()
+	for i, test := range tests {
+		err := c.ExternalBind(test.authzID)
+		ok := err == nil
+		if test.code != Success {
+			ok = IsErrorWithCode(err, test.code)
+		}
+		if !ok {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, err, test.code)
+		}
+		req := <-requests
+		if req.Version != 3 || len(req.Name) != 0 || string(req.Auth.Mechanism) != "EXTERNAL" {
+			t.Errorf("#%d: Bad request: %+v", i, req)
+		}
+		if test.creds == nil && req.Auth.Credentials != nil || string(req.Auth.Credentials) != string(test.creds) {
+			t.Errorf("#%d: Bad credentials: %q (expected %q)", i, req.Auth.Credentials, test.creds)
+		}
+	}
+}
+
+func TestExternalBindChallenge(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:1", bindResponse{
+			Result:          ldapResult{ResultCode: SaslBindInProgress, MatchedDN: []byte{}, Message: []byte{}},
+			ServerSaslCreds: []byte("challenge"),
+		})
+	}()
+	if err := c.ExternalBind(""); err == nil {
+		t.Errorf("ExternalBind accepted a challenge")
+	}
+}
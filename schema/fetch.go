@@ -0,0 +1,67 @@
+package schema
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+)
+
+// Fetch reads the schema that applies to the root DSE from the
+// subschema subentry it names, or from cn=subschema if it names none.
+func Fetch(conn ldap.Conn) (*Schema, error) {
+	dn := "cn=subschema"
+	if dse, err := conn.RootDSE(); err == nil && dse.SubschemaSubentry != "" {
+		dn = dse.SubschemaSubentry
+	}
+	results, err := conn.Search(ldap.SearchRequest{
+		BaseObject: []byte(dn),
+		Scope:      ldap.BaseObject,
+		Filter:     ldap.Equals("objectClass", "subschema"),
+		Attributes: [][]byte{
+			[]byte("attributeTypes"),
+			[]byte("objectClasses"),
+			[]byte("matchingRules"),
+			[]byte("ldapSyntaxes"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("schema: %s: subschema search returned %d entries", dn, len(results))
+	}
+	return ParseEntry(results[0].Entry())
+}
+
+// ParseEntry parses the definitions held by a subschema subentry.
+func ParseEntry(e *ldap.Entry) (*Schema, error) {
+	s := New()
+	for _, v := range e.GetAttributeValues("ldapSyntaxes") {
+		syn, err := ParseSyntax(v)
+		if err != nil {
+			return nil, err
+		}
+		s.AddSyntax(syn)
+	}
+	for _, v := range e.GetAttributeValues("matchingRules") {
+		r, err := ParseMatchingRule(v)
+		if err != nil {
+			return nil, err
+		}
+		s.AddMatchingRule(r)
+	}
+	for _, v := range e.GetAttributeValues("attributeTypes") {
+		a, err := ParseAttributeType(v)
+		if err != nil {
+			return nil, err
+		}
+		s.AddAttributeType(a)
+	}
+	for _, v := range e.GetAttributeValues("objectClasses") {
+		c, err := ParseObjectClass(v)
+		if err != nil {
+			return nil, err
+		}
+		s.AddObjectClass(c)
+	}
+	return s, nil
+}
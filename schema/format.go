@@ -0,0 +1,204 @@
+package schema
+
+import (
+	"github.com/stesla/ldap"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// descriptionWriter builds a definition in the form parseDescription
+// reads.
+type descriptionWriter struct {
+	b strings.Builder
+}
+
+func newDescription(oid string) *descriptionWriter {
+	w := &descriptionWriter{}
+	w.b.WriteString("( " + oid)
+	return w
+}
+
+func (w *descriptionWriter) flag(keyword string, set bool) {
+	if set {
+		w.b.WriteString(" " + keyword)
+	}
+}
+
+// word writes a value that is an OID or a name.
+func (w *descriptionWriter) word(keyword, value string) {
+	if value != "" {
+		w.b.WriteString(" " + keyword + " " + value)
+	}
+}
+
+func (w *descriptionWriter) words(keyword string, values []string) {
+	switch len(values) {
+	case 0:
+	case 1:
+		w.word(keyword, values[0])
+	default:
+		w.b.WriteString(" " + keyword + " ( " + strings.Join(values, " $ ") + " )")
+	}
+}
+
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\5C`, `'`, `\27`).Replace(s) + "'"
+}
+
+func (w *descriptionWriter) quoted(keyword string, values ...string) {
+	switch len(values) {
+	case 0:
+	case 1:
+		w.b.WriteString(" " + keyword + " " + quote(values[0]))
+	default:
+		w.b.WriteString(" " + keyword + " (")
+		for _, v := range values {
+			w.b.WriteString(" " + quote(v))
+		}
+		w.b.WriteString(" )")
+	}
+}
+
+func (w *descriptionWriter) description(desc string) {
+	if desc != "" {
+		w.quoted("DESC", desc)
+	}
+}
+
+func (w *descriptionWriter) extensions(ext map[string][]string) {
+	keys := make([]string, 0, len(ext))
+	for k := range ext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.quoted(k, ext[k]...)
+	}
+}
+
+func (w *descriptionWriter) String() string {
+	return w.b.String() + " )"
+}
+
+// String returns a in the form of an AttributeTypeDescription (RFC 4512
+// §4.1.2).
+func (a *AttributeType) String() string {
+	w := newDescription(a.OID)
+	w.quoted("NAME", a.Names...)
+	w.description(a.Description)
+	w.flag("OBSOLETE", a.Obsolete)
+	w.word("SUP", a.Superior)
+	w.word("EQUALITY", a.Equality)
+	w.word("ORDERING", a.Ordering)
+	w.word("SUBSTR", a.Substring)
+	if a.SyntaxLength > 0 {
+		w.word("SYNTAX", a.Syntax+"{"+strconv.Itoa(a.SyntaxLength)+"}")
+	} else {
+		w.word("SYNTAX", a.Syntax)
+	}
+	w.flag("SINGLE-VALUE", a.SingleValue)
+	w.flag("COLLECTIVE", a.Collective)
+	w.flag("NO-USER-MODIFICATION", a.NoUserModification)
+	if a.Usage != "" && a.Usage != UserApplications {
+		w.word("USAGE", string(a.Usage))
+	}
+	w.extensions(a.Extensions)
+	return w.String()
+}
+
+// String returns c in the form of an ObjectClassDescription (RFC 4512
+// §4.1.1).
+func (c *ObjectClass) String() string {
+	w := newDescription(c.OID)
+	w.quoted("NAME", c.Names...)
+	w.description(c.Description)
+	w.flag("OBSOLETE", c.Obsolete)
+	w.words("SUP", c.Superiors)
+	if c.Kind != "" {
+		w.flag(string(c.Kind), true)
+	}
+	w.words("MUST", c.Must)
+	w.words("MAY", c.May)
+	w.extensions(c.Extensions)
+	return w.String()
+}
+
+// String returns r in the form of a MatchingRuleDescription (RFC 4512
+// §4.1.3).
+func (r *MatchingRule) String() string {
+	w := newDescription(r.OID)
+	w.quoted("NAME", r.Names...)
+	w.description(r.Description)
+	w.flag("OBSOLETE", r.Obsolete)
+	w.word("SYNTAX", r.Syntax)
+	w.extensions(r.Extensions)
+	return w.String()
+}
+
+// String returns syn in the form of a SyntaxDescription (RFC 4512
+// §4.1.5).
+func (syn *Syntax) String() string {
+	w := newDescription(syn.OID)
+	w.description(syn.Description)
+	w.extensions(syn.Extensions)
+	return w.String()
+}
+
+// Entry returns a subschema subentry named dn that publishes the
+// schema's definitions, as ParseEntry reads them.
+func (s *Schema) Entry(dn string) *ldap.Entry {
+	name := dn
+	if rdn, err := ldap.ParseDN(dn); err == nil && len(rdn) > 0 && len(rdn[0]) == 1 {
+		name = rdn[0][0].Value
+	}
+	e := &ldap.Entry{DN: dn}
+	add := func(attr string, values []string) {
+		if len(values) > 0 {
+			e.Attributes = append(e.Attributes, ldap.NewEntryAttribute(attr, values))
+		}
+	}
+	add("objectClass", []string{"top", "subentry", "subschema", "extensibleObject"})
+	add("cn", []string{name})
+	var values []string
+	for _, syn := range s.Syntaxes {
+		if s.syntaxes[strings.ToLower(syn.OID)] == syn {
+			values = append(values, syn.String())
+		}
+	}
+	add("ldapSyntaxes", values)
+	values = nil
+	for _, r := range s.MatchingRules {
+		if current(r.Names, r.OID, func(k string) bool { return s.matchingRules[k] == r }) {
+			values = append(values, r.String())
+		}
+	}
+	add("matchingRules", values)
+	values = nil
+	for _, a := range s.AttributeTypes {
+		if current(a.Names, a.OID, func(k string) bool { return s.attributeTypes[k] == a }) {
+			values = append(values, a.String())
+		}
+	}
+	add("attributeTypes", values)
+	values = nil
+	for _, c := range s.ObjectClasses {
+		if current(c.Names, c.OID, func(k string) bool { return s.objectClasses[k] == c }) {
+			values = append(values, c.String())
+		}
+	}
+	add("objectClasses", values)
+	return e
+}
+
+// current reports whether a definition has not been replaced by a
+// later one with one of its names or its OID: whether each of its keys
+// still refers to it.
+func current(names []string, oid string, same func(key string) bool) bool {
+	for _, k := range keys(names, oid) {
+		if !same(k) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,301 @@
+package schema
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// description is a definition in the generic form of RFC 4512 §4.1:
+// "( oid KEYWORD value ... )", where a value is a word, a quoted
+// string, or a parenthesized list of either.
+type description struct {
+	oid    string
+	fields map[string][]string
+	order  []string
+}
+
+// flags are the keywords that take no value.
+var flags = map[string]bool{
+	"OBSOLETE":             true,
+	"SINGLE-VALUE":         true,
+	"COLLECTIVE":           true,
+	"NO-USER-MODIFICATION": true,
+	"ABSTRACT":             true,
+	"STRUCTURAL":           true,
+	"AUXILIARY":            true,
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '$':
+			tokens = append(tokens, token{text: s[i : i+1]})
+			i++
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{unescapeQuoted(s[i+1 : i+1+end]), true})
+			i += end + 2
+		default:
+			end := strings.IndexAny(s[i:], " \t\n\r()$'")
+			if end < 0 {
+				end = len(s) - i
+			}
+			tokens = append(tokens, token{text: s[i : i+end]})
+			i += end
+		}
+	}
+	return tokens, nil
+}
+
+// unescapeQuoted undoes the \27 and \5C escapes of RFC 4512 qdstrings.
+func unescapeQuoted(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	r := strings.NewReplacer(`\27`, `'`, `\5C`, `\`, `\5c`, `\`)
+	return r.Replace(s)
+}
+
+func parseDescription(s string) (*description, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 3 || tokens[0].text != "(" || tokens[0].quoted || tokens[len(tokens)-1].text != ")" {
+		return nil, fmt.Errorf("not enclosed in parentheses")
+	}
+	tokens = tokens[1 : len(tokens)-1]
+
+	d := &description{oid: tokens[0].text, fields: map[string][]string{}}
+	if tokens[0].quoted || d.oid == "(" || d.oid == "$" {
+		return nil, fmt.Errorf("missing OID")
+	}
+	for tokens = tokens[1:]; len(tokens) > 0; {
+		kw := tokens[0]
+		if kw.quoted || kw.text == "(" || kw.text == ")" || kw.text == "$" {
+			return nil, fmt.Errorf("expected a keyword, got %q", kw.text)
+		}
+		key := strings.ToUpper(kw.text)
+		if _, dup := d.fields[key]; dup {
+			return nil, fmt.Errorf("duplicate %s", key)
+		}
+		tokens = tokens[1:]
+		var values []string
+		if !flags[key] {
+			if values, tokens, err = parseValue(tokens); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		d.fields[key] = values
+		d.order = append(d.order, key)
+	}
+	return d, nil
+}
+
+// parseValue returns the values of a word, a quoted string, or a list
+// of them, and the tokens after it.
+func parseValue(tokens []token) ([]string, []token, error) {
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("missing value")
+	}
+	if t := tokens[0]; t.quoted || (t.text != "(" && t.text != ")" && t.text != "$") {
+		return []string{t.text}, tokens[1:], nil
+	} else if t.text != "(" {
+		return nil, nil, fmt.Errorf("unexpected %q", t.text)
+	}
+
+	values := []string{}
+	for tokens = tokens[1:]; len(tokens) > 0; tokens = tokens[1:] {
+		switch t := tokens[0]; {
+		case t.quoted:
+			values = append(values, t.text)
+		case t.text == ")":
+			return values, tokens[1:], nil
+		case t.text == "$":
+		case t.text == "(":
+			return nil, nil, fmt.Errorf("nested list")
+		default:
+			values = append(values, t.text)
+		}
+	}
+	return nil, nil, fmt.Errorf("unterminated list")
+}
+
+func (d *description) has(key string) bool {
+	_, ok := d.fields[key]
+	return ok
+}
+
+func (d *description) one(key string) string {
+	if v := d.fields[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (d *description) extensions() map[string][]string {
+	var ext map[string][]string
+	for _, key := range d.order {
+		if strings.HasPrefix(key, "X-") {
+			if ext == nil {
+				ext = map[string][]string{}
+			}
+			ext[key] = d.fields[key]
+		}
+	}
+	return ext
+}
+
+// check reports an error for any keyword not in known.
+func (d *description) check(known ...string) error {
+	for _, key := range d.order {
+		if strings.HasPrefix(key, "X-") {
+			continue
+		}
+		ok := false
+		for _, k := range known {
+			if key == k {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unknown keyword %s", key)
+		}
+	}
+	return nil
+}
+
+func invalid(kind, s string, err error) error {
+	return fmt.Errorf("schema: invalid %s %q: %v", kind, s, err)
+}
+
+// ParseAttributeType parses an AttributeTypeDescription (RFC 4512
+// §4.1.2).
+func ParseAttributeType(s string) (*AttributeType, error) {
+	d, err := parseDescription(s)
+	if err == nil {
+		err = d.check("NAME", "DESC", "OBSOLETE", "SUP", "EQUALITY", "ORDERING", "SUBSTR", "SYNTAX",
+			"SINGLE-VALUE", "COLLECTIVE", "NO-USER-MODIFICATION", "USAGE")
+	}
+	if err != nil {
+		return nil, invalid("attribute type", s, err)
+	}
+	at := &AttributeType{
+		OID:                d.oid,
+		Names:              d.fields["NAME"],
+		Description:        d.one("DESC"),
+		Obsolete:           d.has("OBSOLETE"),
+		Superior:           d.one("SUP"),
+		Equality:           d.one("EQUALITY"),
+		Ordering:           d.one("ORDERING"),
+		Substring:          d.one("SUBSTR"),
+		SingleValue:        d.has("SINGLE-VALUE"),
+		Collective:         d.has("COLLECTIVE"),
+		NoUserModification: d.has("NO-USER-MODIFICATION"),
+		Usage:              Usage(d.one("USAGE")),
+		Extensions:         d.extensions(),
+	}
+	if syntax := d.one("SYNTAX"); syntax != "" {
+		at.Syntax = syntax
+		if i := strings.IndexByte(syntax, '{'); i >= 0 && strings.HasSuffix(syntax, "}") {
+			n, err := strconv.Atoi(syntax[i+1 : len(syntax)-1])
+			if err != nil {
+				return nil, invalid("attribute type", s, fmt.Errorf("invalid syntax length"))
+			}
+			at.Syntax, at.SyntaxLength = syntax[:i], n
+		}
+	}
+	if at.Usage == "" {
+		at.Usage = UserApplications
+	}
+	switch at.Usage {
+	case UserApplications, DirectoryOperation, DistributedOperation, DSAOperation:
+	default:
+		return nil, invalid("attribute type", s, fmt.Errorf("unknown usage %q", at.Usage))
+	}
+	if at.Superior == "" && at.Syntax == "" {
+		return nil, invalid("attribute type", s, fmt.Errorf("needs SUP or SYNTAX"))
+	}
+	return at, nil
+}
+
+// ParseObjectClass parses an ObjectClassDescription (RFC 4512 §4.1.1).
+func ParseObjectClass(s string) (*ObjectClass, error) {
+	d, err := parseDescription(s)
+	if err == nil {
+		err = d.check("NAME", "DESC", "OBSOLETE", "SUP", "ABSTRACT", "STRUCTURAL", "AUXILIARY", "MUST", "MAY")
+	}
+	if err != nil {
+		return nil, invalid("object class", s, err)
+	}
+	oc := &ObjectClass{
+		OID:         d.oid,
+		Names:       d.fields["NAME"],
+		Description: d.one("DESC"),
+		Obsolete:    d.has("OBSOLETE"),
+		Superiors:   d.fields["SUP"],
+		Kind:        Structural,
+		Must:        d.fields["MUST"],
+		May:         d.fields["MAY"],
+		Extensions:  d.extensions(),
+	}
+	kinds := 0
+	for _, k := range []ObjectClassKind{Abstract, Structural, Auxiliary} {
+		if d.has(string(k)) {
+			oc.Kind = k
+			kinds++
+		}
+	}
+	if kinds > 1 {
+		return nil, invalid("object class", s, fmt.Errorf("more than one kind"))
+	}
+	return oc, nil
+}
+
+// ParseMatchingRule parses a MatchingRuleDescription (RFC 4512
+// §4.1.3).
+func ParseMatchingRule(s string) (*MatchingRule, error) {
+	d, err := parseDescription(s)
+	if err == nil {
+		err = d.check("NAME", "DESC", "OBSOLETE", "SYNTAX")
+	}
+	if err == nil && !d.has("SYNTAX") {
+		err = fmt.Errorf("missing SYNTAX")
+	}
+	if err != nil {
+		return nil, invalid("matching rule", s, err)
+	}
+	return &MatchingRule{
+		OID:         d.oid,
+		Names:       d.fields["NAME"],
+		Description: d.one("DESC"),
+		Obsolete:    d.has("OBSOLETE"),
+		Syntax:      d.one("SYNTAX"),
+		Extensions:  d.extensions(),
+	}, nil
+}
+
+// ParseSyntax parses a SyntaxDescription (RFC 4512 §4.1.5).
+func ParseSyntax(s string) (*Syntax, error) {
+	d, err := parseDescription(s)
+	if err == nil {
+		err = d.check("DESC")
+	}
+	if err != nil {
+		return nil, invalid("syntax", s, err)
+	}
+	return &Syntax{OID: d.oid, Description: d.one("DESC"), Extensions: d.extensions()}, nil
+}
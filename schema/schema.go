@@ -0,0 +1,291 @@
+// Package schema reads a directory's schema (RFC 4512) and answers
+// questions about it: what an attribute type's matching rules and syntax
+// are, and which attributes an object class requires and allows.
+package schema
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+	"strings"
+)
+
+type Usage string
+
+const (
+	UserApplications     Usage = "userApplications"
+	DirectoryOperation   Usage = "directoryOperation"
+	DistributedOperation Usage = "distributedOperation"
+	DSAOperation         Usage = "dSAOperation"
+)
+
+type AttributeType struct {
+	OID                string
+	Names              []string
+	Description        string
+	Obsolete           bool
+	Superior           string
+	Equality           string
+	Ordering           string
+	Substring          string
+	Syntax             string
+	SyntaxLength       int
+	SingleValue        bool
+	Collective         bool
+	NoUserModification bool
+	Usage              Usage
+	Extensions         map[string][]string
+}
+
+// Name returns the first name of a, or its OID if it has none.
+func (a *AttributeType) Name() string { return name(a.Names, a.OID) }
+
+type ObjectClassKind string
+
+const (
+	Abstract   ObjectClassKind = "ABSTRACT"
+	Structural ObjectClassKind = "STRUCTURAL"
+	Auxiliary  ObjectClassKind = "AUXILIARY"
+)
+
+type ObjectClass struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Superiors   []string
+	Kind        ObjectClassKind
+	Must        []string
+	May         []string
+	Extensions  map[string][]string
+}
+
+func (c *ObjectClass) Name() string { return name(c.Names, c.OID) }
+
+type MatchingRule struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Syntax      string
+	Extensions  map[string][]string
+}
+
+func (r *MatchingRule) Name() string { return name(r.Names, r.OID) }
+
+type Syntax struct {
+	OID         string
+	Description string
+	Extensions  map[string][]string
+}
+
+func name(names []string, oid string) string {
+	if len(names) > 0 {
+		return names[0]
+	}
+	return oid
+}
+
+// A Schema is a set of definitions indexed by name and OID. Names are
+// matched case-insensitively.
+type Schema struct {
+	AttributeTypes []*AttributeType
+	ObjectClasses  []*ObjectClass
+	MatchingRules  []*MatchingRule
+	Syntaxes       []*Syntax
+
+	attributeTypes map[string]*AttributeType
+	objectClasses  map[string]*ObjectClass
+	matchingRules  map[string]*MatchingRule
+	syntaxes       map[string]*Syntax
+}
+
+func New() *Schema {
+	return &Schema{
+		attributeTypes: map[string]*AttributeType{},
+		objectClasses:  map[string]*ObjectClass{},
+		matchingRules:  map[string]*MatchingRule{},
+		syntaxes:       map[string]*Syntax{},
+	}
+}
+
+func keys(names []string, oid string) []string {
+	keys := []string{strings.ToLower(oid)}
+	for _, n := range names {
+		keys = append(keys, strings.ToLower(n))
+	}
+	return keys
+}
+
+// AddAttributeType adds a to the schema, replacing any definition with
+// the same name or OID.
+func (s *Schema) AddAttributeType(a *AttributeType) {
+	s.AttributeTypes = append(s.AttributeTypes, a)
+	for _, k := range keys(a.Names, a.OID) {
+		s.attributeTypes[k] = a
+	}
+}
+
+func (s *Schema) AddObjectClass(c *ObjectClass) {
+	s.ObjectClasses = append(s.ObjectClasses, c)
+	for _, k := range keys(c.Names, c.OID) {
+		s.objectClasses[k] = c
+	}
+}
+
+func (s *Schema) AddMatchingRule(r *MatchingRule) {
+	s.MatchingRules = append(s.MatchingRules, r)
+	for _, k := range keys(r.Names, r.OID) {
+		s.matchingRules[k] = r
+	}
+}
+
+func (s *Schema) AddSyntax(syn *Syntax) {
+	s.Syntaxes = append(s.Syntaxes, syn)
+	s.syntaxes[strings.ToLower(syn.OID)] = syn
+}
+
+// AttributeType returns the attribute type with the given name or OID.
+// Attribute options such as ";binary" are ignored.
+func (s *Schema) AttributeType(name string) *AttributeType {
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	return s.attributeTypes[strings.ToLower(name)]
+}
+
+func (s *Schema) ObjectClass(name string) *ObjectClass {
+	return s.objectClasses[strings.ToLower(name)]
+}
+
+func (s *Schema) MatchingRule(name string) *MatchingRule {
+	return s.matchingRules[strings.ToLower(name)]
+}
+
+func (s *Schema) Syntax(oid string) *Syntax {
+	return s.syntaxes[strings.ToLower(oid)]
+}
+
+// Resolve returns a copy of a with the matching rules and syntax it
+// inherits from its superior types filled in.
+func (s *Schema) Resolve(a *AttributeType) *AttributeType {
+	r := *a
+	seen := map[*AttributeType]bool{a: true}
+	for sup := s.AttributeType(a.Superior); sup != nil && !seen[sup]; sup = s.AttributeType(sup.Superior) {
+		seen[sup] = true
+		if r.Equality == "" {
+			r.Equality = sup.Equality
+		}
+		if r.Ordering == "" {
+			r.Ordering = sup.Ordering
+		}
+		if r.Substring == "" {
+			r.Substring = sup.Substring
+		}
+		if r.Syntax == "" {
+			r.Syntax, r.SyntaxLength = sup.Syntax, sup.SyntaxLength
+		}
+	}
+	return &r
+}
+
+// EqualityRule returns the equality matching rule of the named
+// attribute type, or nil if the type or its rule is unknown. It suits
+// ldap.FilterMatchesWithRules and ldif.DiffWithRules.
+func (s *Schema) EqualityRule(attr string) ldap.MatchingRule {
+	a := s.AttributeType(attr)
+	if a == nil {
+		return nil
+	}
+	name := s.Resolve(a).Equality
+	if r := s.MatchingRule(name); r != nil {
+		name = r.OID
+	}
+	rule, _ := ldap.LookupMatchingRule(name)
+	return rule
+}
+
+// IsSubtype reports whether a is b or derives from it.
+func (s *Schema) IsSubtype(a, b *AttributeType) bool {
+	seen := map[*AttributeType]bool{}
+	for ; a != nil && !seen[a]; a = s.AttributeType(a.Superior) {
+		if a == b {
+			return true
+		}
+		seen[a] = true
+	}
+	return false
+}
+
+// Superclasses returns the named classes followed by every class they
+// inherit from, each once.
+func (s *Schema) Superclasses(classes ...string) ([]*ObjectClass, error) {
+	var result []*ObjectClass
+	seen := map[*ObjectClass]bool{}
+	for len(classes) > 0 {
+		name := classes[0]
+		classes = classes[1:]
+		c := s.ObjectClass(name)
+		if c == nil {
+			return nil, fmt.Errorf("schema: undefined object class %q", name)
+		}
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		result = append(result, c)
+		classes = append(classes, c.Superiors...)
+	}
+	return result, nil
+}
+
+// Must returns the attribute types required by the named classes and
+// the classes they inherit from.
+func (s *Schema) Must(classes ...string) ([]*AttributeType, error) {
+	return s.attributes(classes, func(c *ObjectClass) []string { return c.Must })
+}
+
+// May returns the attribute types allowed, but not required, by the
+// named classes and the classes they inherit from.
+func (s *Schema) May(classes ...string) ([]*AttributeType, error) {
+	must, err := s.Must(classes...)
+	if err != nil {
+		return nil, err
+	}
+	may, err := s.attributes(classes, func(c *ObjectClass) []string { return c.May })
+	if err != nil {
+		return nil, err
+	}
+	required := map[*AttributeType]bool{}
+	for _, a := range must {
+		required[a] = true
+	}
+	result := may[:0]
+	for _, a := range may {
+		if !required[a] {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+func (s *Schema) attributes(classes []string, list func(*ObjectClass) []string) ([]*AttributeType, error) {
+	supers, err := s.Superclasses(classes...)
+	if err != nil {
+		return nil, err
+	}
+	var result []*AttributeType
+	seen := map[*AttributeType]bool{}
+	for _, c := range supers {
+		for _, name := range list(c) {
+			a := s.AttributeType(name)
+			if a == nil {
+				return nil, fmt.Errorf("schema: object class %q uses undefined attribute type %q", c.Name(), name)
+			}
+			if !seen[a] {
+				seen[a] = true
+				result = append(result, a)
+			}
+		}
+	}
+	return result, nil
+}
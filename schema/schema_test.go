@@ -0,0 +1,258 @@
+package schema
+
+import (
+	"github.com/stesla/ldap"
+	"reflect"
+	"testing"
+)
+
+func TestParseAttributeType(t *testing.T) {
+	tests := []struct {
+		in  string
+		out *AttributeType
+	}{
+		{"( 2.5.4.41 NAME 'name' EQUALITY caseIgnoreMatch SUBSTR caseIgnoreSubstringsMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{32768} )",
+			&AttributeType{OID: "2.5.4.41", Names: []string{"name"}, Equality: "caseIgnoreMatch", Substring: "caseIgnoreSubstringsMatch",
+				Syntax: "1.3.6.1.4.1.1466.115.121.1.15", SyntaxLength: 32768, Usage: UserApplications}},
+		{"( 2.5.4.3 NAME ( 'cn' 'commonName' ) DESC 'RFC4519: common name(s) for which the entity is known by' SUP name )",
+			&AttributeType{OID: "2.5.4.3", Names: []string{"cn", "commonName"}, Description: "RFC4519: common name(s) for which the entity is known by",
+				Superior: "name", Usage: UserApplications}},
+		{"( 2.5.18.1 NAME 'createTimestamp' EQUALITY generalizedTimeMatch ORDERING generalizedTimeOrderingMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.24 SINGLE-VALUE NO-USER-MODIFICATION USAGE directoryOperation X-ORIGIN 'RFC 4512' )",
+			&AttributeType{OID: "2.5.18.1", Names: []string{"createTimestamp"}, Equality: "generalizedTimeMatch", Ordering: "generalizedTimeOrderingMatch",
+				Syntax: "1.3.6.1.4.1.1466.115.121.1.24", SingleValue: true, NoUserModification: true, Usage: DirectoryOperation,
+				Extensions: map[string][]string{"X-ORIGIN": {"RFC 4512"}}}},
+		{"( 1.2.3 NAME 'x' )", nil},
+		{"( 1.2.3 SYNTAX 1.2 USAGE other )", nil},
+		{"( 1.2.3 SYNTAX 1.2 BOGUS )", nil},
+		{"( 1.2.3 NAME 'x SYNTAX 1.2 )", nil},
+		{"1.2.3 SYNTAX 1.2", nil},
+	}
+	for i, test := range tests {
+		out, err := ParseAttributeType(test.in)
+		if test.out == nil {
+			if err == nil {
+				t.Errorf("#%d: Expected an error parsing %q", i, test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+		} else if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("#%d: Bad result: %+v (expected %+v)", i, out, test.out)
+		}
+	}
+}
+
+func TestParseObjectClass(t *testing.T) {
+	in := "( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) MAY ( userPassword $ telephoneNumber $ seeAlso $ description ) )"
+	expected := &ObjectClass{OID: "2.5.6.6", Names: []string{"person"}, Superiors: []string{"top"}, Kind: Structural,
+		Must: []string{"sn", "cn"}, May: []string{"userPassword", "telephoneNumber", "seeAlso", "description"}}
+	out, err := ParseObjectClass(in)
+	if err != nil || !reflect.DeepEqual(out, expected) {
+		t.Errorf("Bad result: %+v, %v (expected %+v)", out, err, expected)
+	}
+	if _, err := ParseObjectClass("( 1.2 ABSTRACT AUXILIARY )"); err == nil {
+		t.Errorf("Expected an error for a class of two kinds")
+	}
+}
+
+var testSchema = ldap.NewEntry("cn=subschema", map[string][]string{
+	"ldapSyntaxes": {
+		"( 1.3.6.1.4.1.1466.115.121.1.15 DESC 'Directory String' )",
+	},
+	"matchingRules": {
+		"( 2.5.13.2 NAME 'caseIgnoreMatch' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+	},
+	"attributeTypes": {
+		"( 2.5.4.0 NAME 'objectClass' EQUALITY objectIdentifierMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.38 )",
+		"( 2.5.4.41 NAME 'name' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{32768} )",
+		"( 2.5.4.3 NAME ( 'cn' 'commonName' ) SUP name )",
+		"( 2.5.4.4 NAME ( 'sn' 'surname' ) SUP name )",
+		"( 2.5.4.13 NAME 'description' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+		"( 2.5.4.12 NAME 'title' SUP name )",
+	},
+	"objectClasses": {
+		"( 2.5.6.0 NAME 'top' ABSTRACT MUST objectClass )",
+		"( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) MAY description )",
+		"( 2.5.6.7 NAME 'organizationalPerson' SUP person STRUCTURAL MAY ( title $ description ) )",
+	},
+})
+
+func names(attrs []*AttributeType) []string {
+	var result []string
+	for _, a := range attrs {
+		result = append(result, a.Name())
+	}
+	return result
+}
+
+func TestSchema(t *testing.T) {
+	s, err := ParseEntry(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cn := s.AttributeType("commonName")
+	if cn == nil || cn != s.AttributeType("2.5.4.3") || cn != s.AttributeType("CN;lang-en") {
+		t.Fatalf("Bad result: %v", cn)
+	}
+	if r := s.Resolve(cn); r.Equality != "caseIgnoreMatch" || r.SyntaxLength != 32768 || cn.Equality != "" {
+		t.Errorf("Bad result: %+v", r)
+	}
+	if !s.IsSubtype(cn, s.AttributeType("name")) || s.IsSubtype(s.AttributeType("name"), cn) {
+		t.Errorf("Bad result for IsSubtype")
+	}
+	if s.MatchingRule("CASEIGNOREMATCH") == nil || s.Syntax("1.3.6.1.4.1.1466.115.121.1.15") == nil {
+		t.Errorf("Missing matching rule or syntax")
+	}
+
+	must, err := s.Must("organizationalPerson")
+	if expected := []string{"sn", "cn", "objectClass"}; err != nil || !reflect.DeepEqual(names(must), expected) {
+		t.Errorf("Bad result: %v, %v (expected %v)", names(must), err, expected)
+	}
+	may, err := s.May("organizationalPerson")
+	if expected := []string{"title", "description"}; err != nil || !reflect.DeepEqual(names(may), expected) {
+		t.Errorf("Bad result: %v, %v (expected %v)", names(may), err, expected)
+	}
+	if _, err := s.Must("nonexistent"); err == nil {
+		t.Errorf("Expected an error for an undefined class")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s, err := ParseEntry(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddAttributeType(&AttributeType{OID: "1.1.1", Names: []string{"flag"}, Syntax: "1.3.6.1.4.1.1466.115.121.1.7", SingleValue: true, Usage: UserApplications})
+	s.AddObjectClass(&ObjectClass{OID: "1.1.2", Names: []string{"flagged"}, Kind: Auxiliary, May: []string{"flag"}})
+	s.AddObjectClass(&ObjectClass{OID: "1.1.3", Names: []string{"device"}, Superiors: []string{"top"}, Kind: Structural, May: []string{"cn"}})
+
+	tests := []struct {
+		attrs    map[string][]string
+		problems int
+	}{
+		{map[string][]string{"objectClass": {"top", "person"}, "cn": {"a"}, "sn": {"b"}}, 0},
+		{map[string][]string{"objectClass": {"organizationalPerson", "flagged"}, "commonName": {"a"}, "surname": {"b"}, "title": {"x"}, "flag": {"TRUE"}}, 0},
+		// missing sn, title not allowed
+		{map[string][]string{"objectClass": {"person"}, "cn": {"a"}, "title": {"x"}}, 2},
+		// undefined class and attribute
+		{map[string][]string{"objectClass": {"person", "bogus"}, "cn": {"a"}, "sn": {"b"}, "mystery": {"x"}}, 2},
+		// two values for a single-valued attribute, neither a Boolean
+		{map[string][]string{"objectClass": {"person", "flagged"}, "cn": {"a"}, "sn": {"b"}, "flag": {"yes", "no"}}, 3},
+		// two structural chains
+		{map[string][]string{"objectClass": {"person", "device"}, "cn": {"a"}, "sn": {"b"}}, 1},
+		// no structural class
+		{map[string][]string{"objectClass": {"top", "flagged"}}, 1},
+	}
+	for i, test := range tests {
+		err := s.Validate(ldap.NewEntry("cn=a", test.attrs))
+		problems := 0
+		if err != nil {
+			problems = len(err.(*ValidationError).Problems)
+		}
+		if problems != test.problems {
+			t.Errorf("#%d: Bad result: %v (expected %d problems)", i, err, test.problems)
+		}
+	}
+}
+
+func TestValidateModifications(t *testing.T) {
+	s, err := ParseEntry(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods := []ldap.Modification{
+		{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "description", Values: []string{""}}},
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "mystery", Values: []string{"x"}}},
+		{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "cn", Values: []string{"a"}}},
+	}
+	err = s.ValidateModifications("cn=a", mods)
+	if v, ok := err.(*ValidationError); !ok || len(v.Problems) != 2 {
+		t.Errorf("Bad result: %v (expected 2 problems)", err)
+	}
+}
+
+type addTestConn struct {
+	ldap.Conn
+	added []string
+}
+
+func (c *addTestConn) Add(dn string, attrs []ldap.Attribute, controls ...ldap.Control) error {
+	c.added = append(c.added, dn)
+	return nil
+}
+
+func TestValidating(t *testing.T) {
+	s, err := ParseEntry(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &addTestConn{}
+	c := Validating(conn, s)
+	if err := c.Add("cn=a", []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}, {Type: "cn", Values: []string{"a"}}}); err == nil {
+		t.Errorf("Expected an error adding an entry without sn")
+	}
+	if err := c.Add("cn=b", []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}, {Type: "cn", Values: []string{"b"}}, {Type: "sn", Values: []string{"b"}}}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if expected := []string{"cn=b"}; !reflect.DeepEqual(conn.added, expected) {
+		t.Errorf("Bad result: %v (expected %v)", conn.added, expected)
+	}
+}
+
+func TestEqualityRule(t *testing.T) {
+	s, err := ParseEntry(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := s.EqualityRule("cn"); r != ldap.CaseIgnoreMatch {
+		t.Errorf("Bad result: %v (expected caseIgnoreMatch)", r)
+	}
+	if r := s.EqualityRule("description"); r != nil {
+		t.Errorf("Bad result: %v (expected nil)", r)
+	}
+	e := ldap.NewEntry("cn=a", map[string][]string{"cn": {"Alice  Smith"}})
+	if ok, err := ldap.FilterMatchesWithRules(ldap.Equals("cn", "alice smith"), e, s.EqualityRule); !ok || err != nil {
+		t.Errorf("Bad result: %v, %v (expected true)", ok, err)
+	}
+}
+
+func TestSchemaEntry(t *testing.T) {
+	s, err := ParseEntry(testSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := s.Entry("cn=Subschema")
+	if cn := e.GetAttributeValue("cn"); cn != "Subschema" {
+		t.Errorf("Bad cn: %q (expected %q)", cn, "Subschema")
+	}
+	out, err := ParseEntry(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.AttributeTypes, s.AttributeTypes) {
+		t.Errorf("Bad attribute types: %v (expected %v)", out.AttributeTypes, s.AttributeTypes)
+	}
+	if !reflect.DeepEqual(out.ObjectClasses, s.ObjectClasses) {
+		t.Errorf("Bad object classes: %v (expected %v)", out.ObjectClasses, s.ObjectClasses)
+	}
+	if !reflect.DeepEqual(out.MatchingRules, s.MatchingRules) || !reflect.DeepEqual(out.Syntaxes, s.Syntaxes) {
+		t.Errorf("Bad matching rules or syntaxes: %v %v", out.MatchingRules, out.Syntaxes)
+	}
+
+	// Replaced definitions are not published.
+	n := len(e.GetAttributeValues("attributeTypes"))
+	at, err := ParseAttributeType(`( 2.5.4.3 NAME ( 'cn' 'commonName' ) DESC 'it\27s \5C here' SUP name X-ORIGIN ( 'a' 'b' ) )`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddAttributeType(at)
+	values := s.Entry("cn=Subschema").GetAttributeValues("attributeTypes")
+	if len(values) != n || values[len(values)-1] != at.String() {
+		t.Errorf("Bad attribute types: %q", values)
+	}
+	if out, err := ParseAttributeType(at.String()); err != nil || !reflect.DeepEqual(out, at) {
+		t.Errorf("Bad round trip: %+v, %v (expected %+v)", out, err, at)
+	}
+}
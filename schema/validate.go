@@ -0,0 +1,217 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"github.com/stesla/ldap"
+	"strings"
+)
+
+// A ValidationError lists every way an entry breaks the schema.
+type ValidationError struct {
+	DN       string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("schema: entry %q: %s", e.DN, strings.Join(e.Problems, "; "))
+}
+
+// Validate checks e against the schema: that its object classes are
+// defined and have a single structural chain, that it has every
+// attribute they require and no attribute they do not allow, that
+// single-valued attributes have one value, and that values conform to
+// their syntax. It returns nil or a *ValidationError.
+func (s *Schema) Validate(e *ldap.Entry) error {
+	v := &ValidationError{DN: e.DN}
+	problem := func(format string, args ...interface{}) {
+		v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+	}
+
+	classes := e.GetAttributeValues("objectClass")
+	if len(classes) == 0 {
+		problem("no objectClass")
+	}
+	var defined []string
+	extensible := false
+	for _, name := range classes {
+		c := s.ObjectClass(name)
+		if c == nil {
+			problem("undefined object class %q", name)
+			continue
+		}
+		if strings.EqualFold(c.Name(), "extensibleObject") {
+			extensible = true
+		}
+		defined = append(defined, name)
+	}
+	supers, err := s.Superclasses(defined...)
+	if err != nil {
+		problem("%v", strings.TrimPrefix(err.Error(), "schema: "))
+	}
+	s.checkStructural(defined, problem)
+
+	allowed := map[*AttributeType]bool{}
+	if must, err := s.Must(defined...); err != nil {
+		problem("%v", strings.TrimPrefix(err.Error(), "schema: "))
+	} else {
+		for _, a := range must {
+			allowed[a] = true
+			if !s.hasAttribute(e, a) {
+				problem("missing required attribute %q", a.Name())
+			}
+		}
+	}
+	for _, c := range supers {
+		for _, name := range c.May {
+			if a := s.AttributeType(name); a != nil {
+				allowed[a] = true
+			}
+		}
+	}
+
+	for _, attr := range e.Attributes {
+		a := s.AttributeType(attr.Name)
+		if a == nil {
+			problem("undefined attribute type %q", attr.Name)
+			continue
+		}
+		if !allowed[a] && !extensible && a.Usage == UserApplications && !s.allowedBySupertype(a, allowed) {
+			problem("attribute %q not allowed by the entry's object classes", attr.Name)
+		}
+		if a.SingleValue && len(attr.Values) > 1 {
+			problem("single-valued attribute %q has %d values", attr.Name, len(attr.Values))
+		}
+		s.checkValues(attr.Name, a, attr.Values, problem)
+	}
+
+	if len(v.Problems) > 0 {
+		return v
+	}
+	return nil
+}
+
+// ValidateModifications checks what can be checked about mods without
+// the entry they apply to: that the attributes are defined and may be
+// modified by users, that single-valued attributes are not given
+// several values, and that the values conform to their syntax.
+func (s *Schema) ValidateModifications(dn string, mods []ldap.Modification) error {
+	v := &ValidationError{DN: dn}
+	problem := func(format string, args ...interface{}) {
+		v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+	}
+	for _, m := range mods {
+		a := s.AttributeType(m.Type)
+		if a == nil {
+			problem("undefined attribute type %q", m.Type)
+			continue
+		}
+		if a.NoUserModification {
+			problem("attribute %q cannot be modified", m.Type)
+		}
+		if a.SingleValue && len(m.Values) > 1 {
+			problem("single-valued attribute %q given %d values", m.Type, len(m.Values))
+		}
+		if m.Operation != ldap.DeleteValues {
+			s.checkValues(m.Type, a, m.Values, problem)
+		}
+	}
+	if len(v.Problems) > 0 {
+		return v
+	}
+	return nil
+}
+
+// checkStructural reports a problem unless the structural classes among
+// classes form a single chain of inheritance.
+func (s *Schema) checkStructural(classes []string, problem func(string, ...interface{})) {
+	var structural []*ObjectClass
+	for _, name := range classes {
+		if c := s.ObjectClass(name); c != nil && c.Kind == Structural {
+			structural = append(structural, c)
+		}
+	}
+	if len(structural) == 0 {
+		problem("no structural object class")
+		return
+	}
+	for _, c := range structural {
+		supers, err := s.Superclasses(c.Name())
+		if err != nil {
+			continue
+		}
+		chain := map[*ObjectClass]bool{}
+		for _, sup := range supers {
+			chain[sup] = true
+		}
+		all := true
+		for _, other := range structural {
+			all = all && chain[other]
+		}
+		if all {
+			return
+		}
+	}
+	problem("structural object classes do not form a single chain")
+}
+
+func (s *Schema) hasAttribute(e *ldap.Entry, a *AttributeType) bool {
+	for _, attr := range e.Attributes {
+		if t := s.AttributeType(attr.Name); t != nil && s.IsSubtype(t, a) && len(attr.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) allowedBySupertype(a *AttributeType, allowed map[*AttributeType]bool) bool {
+	for t := range allowed {
+		if s.IsSubtype(a, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) checkValues(name string, a *AttributeType, values []string, problem func(string, ...interface{})) {
+	syntax := s.Resolve(a).Syntax
+	for _, value := range values {
+		if err := ldap.ValidateSyntax(syntax, value); err != nil {
+			problem("attribute %q: %v", name, err)
+		}
+	}
+}
+
+// Validating returns a view of conn that validates Add and Modify
+// requests against s, and fails them with a *ValidationError without
+// sending them if they break it.
+func Validating(conn ldap.Conn, s *Schema) ldap.Conn {
+	return &validatingConn{conn, s}
+}
+
+type validatingConn struct {
+	ldap.Conn
+	schema *Schema
+}
+
+func (c *validatingConn) Add(dn string, attrs []ldap.Attribute, controls ...ldap.Control) error {
+	e := &ldap.Entry{DN: dn}
+	for _, a := range attrs {
+		e.Attributes = append(e.Attributes, ldap.NewEntryAttribute(a.Type, a.Values))
+	}
+	if err := c.schema.Validate(e); err != nil {
+		return err
+	}
+	return c.Conn.Add(dn, attrs, controls...)
+}
+
+func (c *validatingConn) Modify(dn string, mods []ldap.Modification, controls ...ldap.Control) error {
+	if err := c.schema.ValidateModifications(dn, mods); err != nil {
+		return err
+	}
+	return c.Conn.Modify(dn, mods, controls...)
+}
+
+func (c *validatingConn) WithContext(ctx context.Context) ldap.Conn {
+	return &validatingConn{c.Conn.WithContext(ctx), c.schema}
+}
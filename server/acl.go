@@ -0,0 +1,557 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/schema"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// A Right is a set of operations an ACL grants.
+type Right int
+
+const (
+	// Read lets a subject see entries and attributes in search
+	// results.
+	Read Right = 1 << iota
+	// Search lets a subject search from an entry.
+	Search
+	// Compare lets a subject compare the values of an attribute.
+	Compare
+	// Write lets a subject change attributes, or with the entry
+	// pseudo-attribute, add, delete and rename entries.
+	Write
+
+	NoRights  Right = 0
+	AllRights       = Read | Search | Compare | Write
+)
+
+var rightNames = []struct {
+	right Right
+	name  string
+}{{Read, "read"}, {Search, "search"}, {Compare, "compare"}, {Write, "write"}}
+
+func (r Right) String() string {
+	var names []string
+	for _, rn := range rightNames {
+		if r&rn.right != 0 {
+			names = append(names, rn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// EntryAttribute is the pseudo-attribute that stands for an entry as a
+// whole: Read on it lets a subject see the entry in search results,
+// Search lets it search from the entry, and Write lets it add, delete
+// and rename the entry.
+const EntryAttribute = "entry"
+
+// An ACL grants rights to subjects. As in OpenLDAP, the first rule that
+// applies to an attribute decides access to it, by its first grant
+// whose subject matches the client. If there is none, access is denied.
+//
+// An ACL is applied to a Handler with its Middleware, which checks each
+// operation before the handler sees it and removes what the client may
+// not read from search results.
+type ACL struct {
+	Rules []ACLRule
+	// IsMember reports whether dn is a member of group, for subjects
+	// that name a group. MemoryBackend.IsMember may be used. If nil, no
+	// one is a member of any group.
+	IsMember func(group, dn string) bool
+	// Schema, if set, is used to compare attribute types, so that a rule
+	// naming an attribute also applies to its OID and other names.
+	// Attribute options are ignored either way.
+	Schema *schema.Schema
+}
+
+// An ACLRule grants rights on the entries of a subtree, or only on some
+// of their attributes.
+type ACLRule struct {
+	// Subtree is the root of the entries the rule applies to, or "" for
+	// all entries.
+	Subtree string
+	// Attributes are the attributes the rule applies to, which may
+	// include EntryAttribute. If empty, it applies to all of them.
+	Attributes []string
+	Grants     []ACLGrant
+}
+
+// An ACLGrant gives rights to a subject, which is "*" for everyone,
+// "anonymous" for clients that have not bound, "users" for those that
+// have, "self" for a client bound as the target entry, "dn=<dn>" for a
+// client bound as dn, or "group=<dn>" for a client bound as a member of
+// the group dn.
+type ACLGrant struct {
+	Subject string
+	Rights  Right
+}
+
+// Allowed reports whether a client bound as bindDN, which is "" for
+// anonymous clients, has right on attribute of the entry dn.
+func (a *ACL) Allowed(bindDN, dn, attribute string, right Right) bool {
+	name, err := normalizeDN(dn)
+	if err != nil {
+		return false
+	}
+	for _, rule := range a.Rules {
+		if !a.applies(&rule, name, attribute) {
+			continue
+		}
+		for _, g := range rule.Grants {
+			if a.matches(g.Subject, bindDN, name) {
+				return g.Rights&right == right
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func (a *ACL) applies(r *ACLRule, name ldap.DN, attribute string) bool {
+	if r.Subtree != "" {
+		base, err := normalizeDN(r.Subtree)
+		if err != nil || !isUnder(name, base) {
+			return false
+		}
+	}
+	if len(r.Attributes) == 0 {
+		return true
+	}
+	for _, attr := range r.Attributes {
+		if a.sameType(attr, attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameType reports whether x and y, with any options, name the same
+// attribute type.
+func (a *ACL) sameType(x, y string) bool {
+	x, y = baseType(x), baseType(y)
+	if strings.EqualFold(x, y) {
+		return true
+	}
+	if a.Schema == nil {
+		return false
+	}
+	t := a.Schema.AttributeType(x)
+	return t != nil && t == a.Schema.AttributeType(y)
+}
+
+// baseType returns attr without its options.
+func baseType(attr string) string {
+	if i := strings.IndexByte(attr, ';'); i >= 0 {
+		return attr[:i]
+	}
+	return attr
+}
+
+func (a *ACL) matches(subject, bindDN string, target ldap.DN) bool {
+	sameDN := func(dn string) bool {
+		name, err := normalizeDN(dn)
+		return err == nil && len(name) > 0 && name.String() == target.String()
+	}
+	switch {
+	case subject == "*":
+		return true
+	case subject == "anonymous":
+		return bindDN == ""
+	case subject == "users":
+		return bindDN != ""
+	case subject == "self":
+		return bindDN != "" && sameDN(bindDN)
+	case strings.HasPrefix(subject, "dn="):
+		if bindDN == "" {
+			return false
+		}
+		name, err := normalizeDN(strings.TrimPrefix(subject, "dn="))
+		bound, err2 := normalizeDN(bindDN)
+		return err == nil && err2 == nil && name.String() == bound.String()
+	case strings.HasPrefix(subject, "group="):
+		return bindDN != "" && a.IsMember != nil && a.IsMember(strings.TrimPrefix(subject, "group="), bindDN)
+	}
+	return false
+}
+
+func (a *ACL) check(c *Conn, dn, attribute string, right Right) error {
+	if a.Allowed(c.BindDN(), dn, attribute, right) {
+		return nil
+	}
+	return ldapError(ldap.InsufficientAccessRights, "no %s access to %s of %q", right, attribute, dn)
+}
+
+// Middleware returns middleware that enforces the ACL. Binds are not
+// checked, and of extended operations only Password Modify, as a write
+// to the userPassword of the entry whose password it sets.
+func (a *ACL) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return &aclHandler{Handler: next, acl: a}
+	}
+}
+
+type aclHandler struct {
+	Handler
+	acl *ACL
+}
+
+func (h *aclHandler) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	if err := h.acl.check(c, req.BaseDN(), EntryAttribute, Search); err != nil {
+		return err
+	}
+	return h.Handler.Search(c, req, &aclWriter{w, h.acl, c.BindDN(), req.Filter})
+}
+
+func (h *aclHandler) Add(c *Conn, req *AddRequest) error {
+	if err := h.acl.check(c, req.DN, EntryAttribute, Write); err != nil {
+		return err
+	}
+	for _, a := range req.Attributes {
+		if err := h.acl.check(c, req.DN, a.Type, Write); err != nil {
+			return err
+		}
+	}
+	return h.Handler.Add(c, req)
+}
+
+func (h *aclHandler) Modify(c *Conn, req *ModifyRequest) error {
+	for _, m := range req.Modifications {
+		if err := h.acl.check(c, req.DN, m.Attribute.Type, Write); err != nil {
+			return err
+		}
+	}
+	return h.Handler.Modify(c, req)
+}
+
+func (h *aclHandler) Delete(c *Conn, req *DeleteRequest) error {
+	if err := h.acl.check(c, req.DN, EntryAttribute, Write); err != nil {
+		return err
+	}
+	return h.Handler.Delete(c, req)
+}
+
+func (h *aclHandler) ModifyDN(c *Conn, req *ModifyDNRequest) error {
+	if err := h.acl.check(c, req.DN, EntryAttribute, Write); err != nil {
+		return err
+	}
+	newDN, err := renamedDN(req)
+	if err != nil {
+		return err
+	}
+	if err := h.acl.check(c, newDN, EntryAttribute, Write); err != nil {
+		return err
+	}
+	return h.Handler.ModifyDN(c, req)
+}
+
+// renamedDN returns the DN an entry has after req.
+func renamedDN(req *ModifyDNRequest) (string, error) {
+	rdn, err := ldap.ParseDN(req.NewRDN)
+	if err != nil || len(rdn) != 1 {
+		return "", ldapError(ldap.InvalidDNSyntax, "invalid RDN %q", req.NewRDN)
+	}
+	superior := req.NewSuperior
+	if superior == "" {
+		name, err := ldap.ParseDN(req.DN)
+		if err != nil || len(name) == 0 {
+			return "", ldapError(ldap.InvalidDNSyntax, "invalid DN %q", req.DN)
+		}
+		superior = name[1:].String()
+	}
+	if superior == "" {
+		return rdn.String(), nil
+	}
+	return rdn.String() + "," + superior, nil
+}
+
+func (h *aclHandler) Compare(c *Conn, req *CompareRequest) (bool, error) {
+	if err := h.acl.check(c, req.DN, req.Attribute, Compare); err != nil {
+		return false, err
+	}
+	return h.Handler.Compare(c, req)
+}
+
+func (h *aclHandler) Extended(c *Conn, req *ExtendedRequest) (*ExtendedResponse, error) {
+	if req.Name == oidPasswordModify {
+		dn, err := passwordTarget(h.Handler, c, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := h.acl.check(c, dn, "userPassword", Write); err != nil {
+			return nil, err
+		}
+	}
+	return h.Handler.Extended(c, req)
+}
+
+// aclWriter leaves out of search results the entries and attributes the
+// client may not read, and the entries that match the filter only
+// through attributes the client may not search.
+type aclWriter struct {
+	SearchWriter
+	acl    *ACL
+	bindDN string
+	filter ldap.Filter
+}
+
+func (w *aclWriter) WriteEntry(e *ldap.Entry, controls ...ldap.Control) error {
+	if !w.acl.Allowed(w.bindDN, e.DN, EntryAttribute, Read) {
+		return nil
+	}
+	// Otherwise the values of unreadable attributes could be found one
+	// search at a time. Entries are matched against the filter again,
+	// comparing values with CaseIgnoreMatch, with its assertions on such
+	// attributes Undefined.
+	if f, changed := w.searchable(w.filter, e); changed {
+		if ok, err := ldap.FilterMatches(f, e); err != nil || !ok {
+			return nil
+		}
+	}
+	readable := &ldap.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		if w.acl.Allowed(w.bindDN, e.DN, a.Name, Read) {
+			readable.Attributes = append(readable.Attributes, a)
+		}
+	}
+	return w.SearchWriter.WriteEntry(readable, controls...)
+}
+
+// undefinedFilter is Undefined for every entry, there being no matching
+// rule with the OID 1.1.
+var undefinedFilter = ldap.Matches("1.1", "", "")
+
+// searchable returns f with the assertions on attributes the client may
+// not search in e replaced by undefinedFilter, and whether there were
+// any.
+func (w *aclWriter) searchable(f ldap.Filter, e *ldap.Entry) (ldap.Filter, bool) {
+	set := func(filters []ldap.Filter, combine func(...ldap.Filter) ldap.Filter) (ldap.Filter, bool) {
+		var changed bool
+		subs := make([]ldap.Filter, len(filters))
+		for i, sub := range filters {
+			var c bool
+			subs[i], c = w.searchable(sub, e)
+			changed = changed || c
+		}
+		if !changed {
+			return f, false
+		}
+		return combine(subs...), true
+	}
+	if filters, ok := ldap.AndFilters(f); ok {
+		return set(filters, ldap.And)
+	}
+	if filters, ok := ldap.OrFilters(f); ok {
+		return set(filters, ldap.Or)
+	}
+	if sub, ok := ldap.NotFilter(f); ok {
+		if sub, changed := w.searchable(sub, e); changed {
+			return ldap.Not(sub), true
+		}
+		return f, false
+	}
+	if attrs := filterAttributes(f, e); attrs != nil {
+		for _, attr := range attrs {
+			if !w.acl.Allowed(w.bindDN, e.DN, attr, Search) {
+				return undefinedFilter, true
+			}
+		}
+		return f, false
+	}
+	return undefinedFilter, true
+}
+
+// filterAttributes returns the attributes of e the assertion f is
+// evaluated against, or nil if f is not an assertion.
+func filterAttributes(f ldap.Filter, e *ldap.Entry) []string {
+	var attr string
+	if a, _, ok := ldap.EqualityAssertion(f); ok {
+		attr = a
+	} else if a, _, ok := ldap.GreaterOrEqualAssertion(f); ok {
+		attr = a
+	} else if a, _, ok := ldap.LessOrEqualAssertion(f); ok {
+		attr = a
+	} else if a, _, ok := ldap.ApproxAssertion(f); ok {
+		attr = a
+	} else if a, _, _, _, ok := ldap.SubstringAssertion(f); ok {
+		attr = a
+	} else if a, ok := ldap.PresenceAssertion(f); ok {
+		attr = a
+	} else if _, a, _, _, ok := ldap.ExtensibleAssertion(f); ok {
+		if a == "" {
+			// An extensible match without a type is evaluated against
+			// every attribute.
+			attrs := []string{}
+			for _, a := range e.Attributes {
+				attrs = append(attrs, a.Name)
+			}
+			return attrs
+		}
+		attr = a
+	} else {
+		return nil
+	}
+	return []string{attr}
+}
+
+// ParseACL reads an ACL in a format modeled on OpenLDAP's access
+// directives. Each rule is of the form
+//
+//	access to <what> [attrs=<attr>,...] by <subject> <rights> [by ...]
+//
+// where <what> is * or dn.subtree=<dn>, <subject> is as for ACLGrant,
+// and <rights> is none, all, or a comma-separated list of read, search,
+// compare and write. A rule may continue on lines that begin with white
+// space. DNs may be quoted, and lines beginning with # are comments.
+func ParseACL(r io.Reader) (*ACL, error) {
+	acl := &ACL{}
+	var rule []string
+	var start, n int
+	flush := func() error {
+		if rule == nil {
+			return nil
+		}
+		r, err := parseACLRule(rule)
+		if err != nil {
+			return fmt.Errorf("ldap: acl line %d: %v", start, err)
+		}
+		acl.Rules = append(acl.Rules, r)
+		rule = nil
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if !unicode.IsSpace(rune(line[0])) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			start = n
+		} else if rule == nil {
+			return nil, fmt.Errorf("ldap: acl line %d: continuation without a rule", n)
+		}
+		tokens, err := aclTokens(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: acl line %d: %v", n, err)
+		}
+		rule = append(rule, tokens...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// aclTokens splits a line at white space outside double quotes, and
+// removes the quotes.
+func aclTokens(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	inToken, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inToken = true
+		case unicode.IsSpace(r) && !quoted:
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+func parseACLRule(tokens []string) (rule ACLRule, err error) {
+	if len(tokens) < 3 || tokens[0] != "access" || tokens[1] != "to" {
+		return rule, fmt.Errorf("expected \"access to\"")
+	}
+	switch what := tokens[2]; {
+	case what == "*":
+	case strings.HasPrefix(what, "dn.subtree="):
+		rule.Subtree = strings.TrimPrefix(what, "dn.subtree=")
+		if _, err := normalizeDN(rule.Subtree); err != nil {
+			return rule, fmt.Errorf("invalid DN %q", rule.Subtree)
+		}
+	default:
+		return rule, fmt.Errorf("invalid target %q", what)
+	}
+	tokens = tokens[3:]
+	if len(tokens) > 0 && strings.HasPrefix(tokens[0], "attrs=") {
+		rule.Attributes = strings.Split(strings.TrimPrefix(tokens[0], "attrs="), ",")
+		tokens = tokens[1:]
+	}
+	for len(tokens) > 0 {
+		if tokens[0] != "by" || len(tokens) < 3 {
+			return rule, fmt.Errorf("expected \"by <subject> <rights>\"")
+		}
+		subject, rights := tokens[1], tokens[2]
+		switch {
+		case subject == "*", subject == "anonymous", subject == "users", subject == "self":
+		case strings.HasPrefix(subject, "dn="), strings.HasPrefix(subject, "group="):
+			dn := subject[strings.IndexByte(subject, '=')+1:]
+			if _, err := normalizeDN(dn); err != nil {
+				return rule, fmt.Errorf("invalid DN %q", dn)
+			}
+		default:
+			return rule, fmt.Errorf("invalid subject %q", subject)
+		}
+		right, err := parseRights(rights)
+		if err != nil {
+			return rule, err
+		}
+		rule.Grants = append(rule.Grants, ACLGrant{subject, right})
+		tokens = tokens[3:]
+	}
+	if len(rule.Grants) == 0 {
+		return rule, fmt.Errorf("rule grants nothing")
+	}
+	return rule, nil
+}
+
+func parseRights(s string) (Right, error) {
+	switch s {
+	case "none":
+		return NoRights, nil
+	case "all":
+		return AllRights, nil
+	}
+	var right Right
+outer:
+	for _, name := range strings.Split(s, ",") {
+		for _, rn := range rightNames {
+			if rn.name == name {
+				right |= rn.right
+				continue outer
+			}
+		}
+		return 0, fmt.Errorf("invalid right %q", name)
+	}
+	return right, nil
+}
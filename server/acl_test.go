@@ -0,0 +1,213 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/schema"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testACL = `
+# Users manage their own passwords, which no one may read.
+access to dn.subtree="ou=People,dc=example,dc=com" attrs=userPassword
+	by self write
+	by * none
+access to *
+	by group="cn=Admins,dc=example,dc=com" all
+	by users read,search,compare
+	by anonymous search
+access to dn.subtree="ou=People,dc=example,dc=com"
+	by anonymous read
+`
+
+func newACLBackend(t *testing.T) (*MemoryBackend, *ACL) {
+	b := newTestBackend(t)
+	admins := ldap.NewEntry("cn=Admins,dc=example,dc=com", map[string][]string{
+		"objectClass": {"groupOfNames"}, "cn": {"Admins"}, "member": {"CN=Alice, ou=People, dc=example, dc=com"}})
+	if err := b.AddEntry(admins); err != nil {
+		t.Fatal(err)
+	}
+	acl, err := ParseACL(strings.NewReader(testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl.IsMember = b.IsMember
+	return b, acl
+}
+
+func TestACLAllowed(t *testing.T) {
+	_, acl := newACLBackend(t)
+	const alice, bob = "cn=Alice,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"
+	tests := []struct {
+		bindDN, dn, attr string
+		right            Right
+		expected         bool
+	}{
+		{"", bob, "cn", Search, true},
+		// The first rule that applies decides.
+		{"", bob, "cn", Read, false},
+		{bob, bob, "cn", Read, true},
+		{bob, bob, "cn", Write, false},
+		{bob, bob, "userPassword", Write, true},
+		{bob, bob, "userPassword", Read, false},
+		// Rules apply to attributes with options too.
+		{bob, bob, "userPassword;x-hist", Read, false},
+		{bob, bob, "USERPASSWORD;x-hist", Write, true},
+		{bob, bob, "2.5.4.35", Read, true},
+		{bob, alice, "userPassword", Write, false},
+		{alice, bob, "cn", Write, true},
+		{alice, bob, EntryAttribute, Read | Write, true},
+		{alice, bob, "userPassword", Write, false},
+		{"", "not a dn", "cn", Search, false},
+	}
+	for i, test := range tests {
+		if result := acl.Allowed(test.bindDN, test.dn, test.attr, test.right); result != test.expected {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, result, test.expected)
+		}
+	}
+
+	// With a schema, rules apply to an attribute by any of its names.
+	acl.Schema = schema.New()
+	acl.Schema.AddAttributeType(&schema.AttributeType{OID: "2.5.4.35", Names: []string{"userPassword"}})
+	if acl.Allowed(bob, bob, "2.5.4.35;x-hist", Read) {
+		t.Errorf("Bad result for userPassword by OID: true (expected false)")
+	}
+}
+
+func TestParseACL(t *testing.T) {
+	acl, err := ParseACL(strings.NewReader(testACL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ACLRule{
+		{"ou=People,dc=example,dc=com", []string{"userPassword"}, []ACLGrant{{"self", Write}, {"*", NoRights}}},
+		{"", nil, []ACLGrant{{"group=cn=Admins,dc=example,dc=com", AllRights}, {"users", Read | Search | Compare}, {"anonymous", Search}}},
+		{"ou=People,dc=example,dc=com", nil, []ACLGrant{{"anonymous", Read}}},
+	}
+	if !reflect.DeepEqual(acl.Rules, expected) {
+		t.Errorf("Bad result: %v (expected %v)", acl.Rules, expected)
+	}
+
+	for i, config := range []string{
+		"allow to *",
+		"access to *",
+		"access to dn.base=dc=com by * read",
+		"access to * by someone read",
+		"access to * by * fly",
+		`access to dn.subtree="dc=com by * read`,
+		"\tby * read",
+	} {
+		if _, err := ParseACL(strings.NewReader(config)); err == nil {
+			t.Errorf("#%d: Expected error for %q", i, config)
+		}
+	}
+}
+
+func TestACLMiddleware(t *testing.T) {
+	const alice, bob = "cn=Alice,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"
+	b, acl := newACLBackend(t)
+	c, stop := startTestServer(t, Chain(b, acl.Middleware()))
+	defer stop()
+
+	// Anonymous clients may search, but read nothing.
+	if dns := searchDNs(t, c, "(objectClass=*)"); len(dns) != 0 {
+		t.Errorf("Bad anonymous search result: %v (expected none)", dns)
+	}
+	if _, err := c.Compare(bob, "sn", "Jones"); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad anonymous compare result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+
+	err := b.Modify(nil, &ModifyRequest{DN: bob, Modifications: []ldap.Modification{
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "userPassword", Values: []string{"hunter2"}}},
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "userPassword;x-hist", Values: []string{"oldsecret"}}},
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "uid", Values: []string{"bob"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Modify(nil, &ModifyRequest{DN: alice, Modifications: []ldap.Modification{
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "uid", Values: []string{"alice"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Bind(bob, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.Search(ldap.SearchRequest{BaseObject: []byte(bob), Filter: ldap.Present("objectClass")})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Bad search result: %v, %v", results, err)
+	}
+	attrs := results[0].Entry().AttributeMap()
+	if _, ok := attrs["userpassword"]; ok || attrs["sn"] == nil {
+		t.Errorf("Bad attributes: %v (expected sn without userPassword)", attrs)
+	}
+	if _, ok := attrs["userpassword;x-hist"]; ok {
+		t.Errorf("Bad attributes: %v (expected no userPassword;x-hist)", attrs)
+	}
+	// Filters reveal nothing of the attributes they may not search.
+	for i, test := range []struct {
+		filter string
+		dns    []string
+	}{
+		{"(sn=smith)", []string{alice}},
+		{"(userPassword=s*)", []string{}},
+		// Only outside ou=People may users search userPassword.
+		{"(!(userPassword=x*))", []string{"cn=Admins,dc=example,dc=com", "dc=example,dc=com"}},
+		{"(&(sn=smith)(userPassword=secret))", []string{}},
+		{"(|(sn=smith)(userPassword=x*))", []string{alice}},
+		{"(:caseExactMatch:=secret)", []string{}},
+	} {
+		if dns := searchDNs(t, c, test.filter); !reflect.DeepEqual(dns, test.dns) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, dns, test.dns)
+		}
+	}
+	mods := func(attr string) []ldap.Modification {
+		return []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: attr, Values: []string{"x"}}}}
+	}
+	if err := c.Modify(bob, mods("userPassword")); err != nil {
+		t.Errorf("Bad password change result: %v", err)
+	}
+	if err := c.Modify(bob, mods("sn")); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad modify result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if err := c.Del(alice); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad delete result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	// Password Modify is a write to userPassword.
+	if _, err := c.PasswordModify("dn:"+alice, "", "new"); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad password modify result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if _, err := c.PasswordModify("", "", "y"); err != nil {
+		t.Errorf("Bad own password modify result: %v", err)
+	}
+	// The user identity names the entry as the backend finds it.
+	if _, err := c.PasswordModify("u:bob", "", "z"); err != nil {
+		t.Errorf("Bad password modify result for u:bob: %v", err)
+	}
+	if _, err := c.PasswordModify("alice", "", "new"); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad password modify result for alice: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+
+	// Admins may do anything.
+	if err := c.Bind(alice, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Modify(bob, mods("sn")); err != nil {
+		t.Errorf("Bad admin modify result: %v", err)
+	}
+	// Adding an entry writes each of its attributes.
+	carol := []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}, {Type: "cn", Values: []string{"Carol"}}}
+	withPassword := append(carol, ldap.Attribute{Type: "userPassword;x-hist", Values: []string{"x"}})
+	if err := c.Add("cn=Carol,ou=People,dc=example,dc=com", withPassword); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad admin add result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if err := c.Add("cn=Carol,ou=People,dc=example,dc=com", carol); err != nil {
+		t.Errorf("Bad admin add result: %v", err)
+	}
+	if err := c.ModifyDN(bob, "cn=Robert", true, ""); err != nil {
+		t.Errorf("Bad admin rename result: %v", err)
+	}
+	if err := c.Del("cn=Robert,ou=People,dc=example,dc=com"); err != nil {
+		t.Errorf("Bad admin delete result: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+)
+
+// A PasswordVerifier checks the password of a simple bind as dn,
+// returning nil if it is right and otherwise an error, usually
+// ldap.InvalidCredentials.
+type PasswordVerifier interface {
+	VerifyPassword(c *Conn, dn, password string) error
+}
+
+// PasswordVerifierFunc adapts a function to a PasswordVerifier, to
+// check passwords against an external store.
+type PasswordVerifierFunc func(c *Conn, dn, password string) error
+
+func (f PasswordVerifierFunc) VerifyPassword(c *Conn, dn, password string) error {
+	return f(c, dn, password)
+}
+
+// SimpleBind returns middleware that checks the passwords of simple
+// binds with v instead of passing them on. SASL binds, and simple binds
+// without a password, are passed on.
+func SimpleBind(v PasswordVerifier) Middleware {
+	return func(next Handler) Handler {
+		return &simpleBindHandler{next, v}
+	}
+}
+
+type simpleBindHandler struct {
+	Handler
+	v PasswordVerifier
+}
+
+func (h *simpleBindHandler) Bind(c *Conn, req *BindRequest) error {
+	if req.SASL != nil || req.Password == "" {
+		return h.Handler.Bind(c, req)
+	}
+	return h.v.VerifyPassword(c, req.Name, req.Password)
+}
+
+// UserPasswords verifies passwords against the userPassword values of
+// an entry in a custom store, which may be hashed as by
+// ldap.HashPassword.
+type UserPasswords struct {
+	// Lookup returns the userPassword values of the entry dn. An
+	// ldap.NoSuchObject error is reported as invalid credentials.
+	Lookup func(dn string) ([]string, error)
+}
+
+func (u *UserPasswords) VerifyPassword(c *Conn, dn, password string) error {
+	passwords, err := u.Lookup(dn)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.NoSuchObject) {
+		return err
+	}
+	for _, pw := range passwords {
+		if ok, _ := ldap.VerifyPassword(pw, password); ok {
+			return nil
+		}
+	}
+	return &ldap.Error{ResultCode: ldap.InvalidCredentials}
+}
+
+// PassThrough verifies passwords by binding to an upstream directory
+// with them, on a connection of its own for each bind.
+type PassThrough struct {
+	// Dial opens a connection to the upstream directory. Pool.Dial may
+	// be used.
+	Dial func() (ldap.Conn, error)
+	// MapDN, if set, returns the DN to bind to the upstream directory
+	// as for a bind as dn.
+	MapDN func(dn string) string
+}
+
+func (p *PassThrough) VerifyPassword(c *Conn, dn, password string) error {
+	if p.MapDN != nil {
+		dn = p.MapDN(dn)
+	}
+	upstream, err := p.Dial()
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer upstream.Close()
+	return upstreamError(upstream.Bind(dn, password))
+}
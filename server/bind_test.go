@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+	"strings"
+	"testing"
+)
+
+func TestSimpleBind(t *testing.T) {
+	const alice = "cn=Alice,ou=People,dc=example,dc=com"
+	hashed, err := ldap.HashPassword(ldap.PasswordSSHA, "hashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamAddr, stopUpstream := serveTest(t, newTestBackend(t))
+	defer stopUpstream()
+
+	verifiers := []struct {
+		name     string
+		v        PasswordVerifier
+		password string
+	}{
+		{"func", PasswordVerifierFunc(func(c *Conn, dn, password string) error {
+			if strings.EqualFold(dn, alice) && password == "external" {
+				return nil
+			}
+			return &ldap.Error{ResultCode: ldap.InvalidCredentials}
+		}), "external"},
+		{"userPassword", &UserPasswords{Lookup: func(dn string) ([]string, error) {
+			if !strings.EqualFold(dn, alice) {
+				return nil, &ldap.Error{ResultCode: ldap.NoSuchObject}
+			}
+			return []string{hashed}, nil
+		}}, "hashed"},
+		{"pass-through", &PassThrough{
+			Dial:  func() (ldap.Conn, error) { return ldap.Dial(upstreamAddr) },
+			MapDN: func(dn string) string { return strings.Replace(dn, "Alicia", "Alice", 1) },
+		}, "secret"},
+	}
+	for _, v := range verifiers {
+		c, stop := startTestServer(t, Chain(newTestBackend(t), SimpleBind(v.v)))
+		tests := []struct {
+			dn, password string
+			code         ldap.ResultCode
+		}{
+			{alice, v.password, ldap.Success},
+			{alice, "wrong", ldap.InvalidCredentials},
+			{"cn=Nobody,dc=example,dc=com", v.password, ldap.InvalidCredentials},
+			{"", "", ldap.Success},
+		}
+		if v.name == "pass-through" {
+			tests = append(tests, struct {
+				dn, password string
+				code         ldap.ResultCode
+			}{"cn=Alicia,ou=People,dc=example,dc=com", v.password, ldap.Success})
+		}
+		for i, test := range tests {
+			code := ldap.Success
+			if err := c.Bind(test.dn, test.password); err != nil {
+				code = resultCode(err)
+			}
+			if code != test.code {
+				t.Errorf("%s #%d: Bad result: %v (expected %v)", v.name, i, code, test.code)
+			}
+		}
+		stop()
+	}
+}
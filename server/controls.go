@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+)
+
+type pagingValue struct {
+	Size   int
+	Cookie []byte
+}
+
+type sortKey struct {
+	AttributeType []byte
+	OrderingRule  []byte `asn1:"tag:0,optional"`
+	ReverseOrder  bool   `asn1:"tag:1,optional"`
+}
+
+// PagingControl returns the Simple Paged Results control sent with the
+// request, or nil.
+func (r *Request) PagingControl() (*ldap.ControlPaging, error) {
+	c := r.Control(ldap.ControlTypePaging)
+	if c == nil {
+		return nil, nil
+	}
+	var v pagingValue
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, ldapError(ldap.ProtocolError, "invalid paged results control: %v", err)
+	}
+	return &ldap.ControlPaging{Size: v.Size, Cookie: v.Cookie, Criticality: c.Criticality}, nil
+}
+
+// SortControl returns the server side sort control sent with the
+// request, or nil.
+func (r *Request) SortControl() (*ldap.ControlServerSideSort, error) {
+	c := r.Control(ldap.ControlTypeServerSideSort)
+	if c == nil {
+		return nil, nil
+	}
+	var keys []sortKey
+	if err := decodeValue(c.Value, &keys); err != nil {
+		return nil, ldapError(ldap.ProtocolError, "invalid sort control: %v", err)
+	}
+	ctl := &ldap.ControlServerSideSort{Criticality: c.Criticality}
+	for _, k := range keys {
+		ctl.SortKeys = append(ctl.SortKeys, ldap.SortKey{
+			AttributeType: string(k.AttributeType),
+			MatchingRule:  string(k.OrderingRule),
+			Reverse:       k.ReverseOrder,
+		})
+	}
+	return ctl, nil
+}
+
+// SortEntries sorts entries by keys as ldap.SortEntries does.
+func SortEntries(entries []*ldap.Entry, keys []ldap.SortKey, rules func(attribute string) ldap.MatchingRule) error {
+	return ldap.SortEntries(entries, keys, rules)
+}
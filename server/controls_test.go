@@ -0,0 +1,149 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newPagingBackend(t *testing.T, n int) *MemoryBackend {
+	b := NewMemoryBackend()
+	if err := b.AddEntry(ldap.NewEntry("dc=example", map[string][]string{"dc": {"example"}, "objectClass": {"domain"}})); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		e := ldap.NewEntry("uid="+strconv.Itoa(i)+",dc=example", map[string][]string{
+			"uid": {strconv.Itoa(i)}, "sn": {string(rune('z' - i%26))}, "objectClass": {"person"}})
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func TestPagedSearch(t *testing.T) {
+	c, stop := startTestServer(t, newPagingBackend(t, 7))
+	defer stop()
+
+	req := ldap.SearchRequest{BaseObject: []byte("dc=example"), Scope: ldap.SingleLevel, Filter: ldap.Present("uid")}
+	paging := &ldap.ControlPaging{Size: 3}
+	pages := []int{}
+	for {
+		results, err := c.SearchPage(req, paging)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, len(results))
+		if len(paging.Cookie) == 0 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(pages, []int{3, 3, 1}) {
+		t.Errorf("Bad pages: %v (expected [3 3 1])", pages)
+	}
+
+	paging = &ldap.ControlPaging{Size: 2}
+	if _, err := c.SearchPage(req, paging); err != nil {
+		t.Fatal(err)
+	}
+	paging.Size = 0
+	if results, err := c.SearchPage(req, paging); err != nil || len(results) != 0 || len(paging.Cookie) != 0 {
+		t.Errorf("Bad result ending paged search: %v, %q, %v", results, paging.Cookie, err)
+	}
+	paging = &ldap.ControlPaging{Size: 2, Cookie: []byte("bogus")}
+	if _, err := c.SearchPage(req, paging); resultCode(err) != ldap.UnwillingToPerform {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.UnwillingToPerform)
+	}
+}
+
+func TestSortedSearch(t *testing.T) {
+	c, stop := startTestServer(t, newPagingBackend(t, 4))
+	defer stop()
+
+	req := ldap.SearchRequest{BaseObject: []byte("dc=example"), Scope: ldap.WholeSubtree, Filter: ldap.Present("objectClass"), Attributes: [][]byte{[]byte("uid")}}
+	tests := []struct {
+		keys []ldap.SortKey
+		uids []string
+		code ldap.ResultCode
+	}{
+		{[]ldap.SortKey{{AttributeType: "sn"}}, []string{"3", "2", "1", "0", ""}, ldap.Success},
+		{[]ldap.SortKey{{AttributeType: "uid", Reverse: true}}, []string{"", "3", "2", "1", "0"}, ldap.Success},
+		{[]ldap.SortKey{{AttributeType: "uid", MatchingRule: "integerOrderingMatch"}}, []string{"0", "1", "2", "3", ""}, ldap.Success},
+		{[]ldap.SortKey{{AttributeType: "uid", MatchingRule: "noSuchRule"}}, []string{"", "0", "1", "2", "3"}, ldap.InappropriateMatching},
+	}
+	for i, test := range tests {
+		resp, err := c.SearchWithControls(req, &ldap.ControlServerSideSort{SortKeys: test.keys})
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		uids := []string{}
+		for _, r := range resp.Results {
+			uids = append(uids, r.Entry().GetAttributeValue("uid"))
+		}
+		if !reflect.DeepEqual(uids, test.uids) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, uids, test.uids)
+		}
+		ctl, ok := resp.Controls[0].(*ldap.ControlServerSideSortResponse)
+		if !ok || ctl.Result != test.code {
+			t.Errorf("#%d: Bad response control: %#v (expected %v)", i, resp.Controls[0], test.code)
+		}
+	}
+
+	keys := []ldap.SortKey{{AttributeType: "uid", MatchingRule: "noSuchRule"}}
+	_, err := c.SearchWithControls(req, &ldap.ControlServerSideSort{SortKeys: keys, Criticality: true})
+	if resultCode(err) != ldap.UnavailableCriticalExtension {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.UnavailableCriticalExtension)
+	}
+}
+
+type blockingHandler struct {
+	BaseHandler
+}
+
+func (blockingHandler) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	<-req.Context().Done()
+	return req.Context().Err()
+}
+
+func TestSearchLimits(t *testing.T) {
+	addr, stop := serveTestServer(t, &Server{Handler: newPagingBackend(t, 5), SizeLimit: 4})
+	defer stop()
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		sizeLimit int
+		code      ldap.ResultCode
+	}{
+		{0, ldap.SizeLimitExceeded},
+		{2, ldap.SizeLimitExceeded},
+		{6, ldap.SizeLimitExceeded},
+	}
+	for i, test := range tests {
+		req := ldap.SearchRequest{BaseObject: []byte("dc=example"), Scope: ldap.SingleLevel, SizeLimit: test.sizeLimit, Filter: ldap.Present("uid")}
+		if _, err := c.Search(req); resultCode(err) != test.code {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, err, test.code)
+		}
+	}
+	req := ldap.SearchRequest{BaseObject: []byte("dc=example"), Scope: ldap.SingleLevel, SizeLimit: 5, Filter: ldap.Equals("uid", "1")}
+	if results, err := c.Search(req); err != nil || len(results) != 1 {
+		t.Errorf("Bad result: %v, %v (expected 1 entry)", results, err)
+	}
+
+	addr, stopBlocking := serveTestServer(t, &Server{Handler: blockingHandler{}, TimeLimit: 10 * time.Millisecond})
+	defer stopBlocking()
+	c2, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if _, err := c2.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: ldap.Present("objectClass")}); resultCode(err) != ldap.TimeLimitExceeded {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.TimeLimitExceeded)
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/ldif"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// A FileBackend is a MemoryBackend whose directory persists in a file,
+// for small directories and durable test fixtures.
+//
+// The file is in LDIF: the entries as of the last compaction, followed
+// by the entries each update since changed, or a delete record for
+// those it removed. Each batch of records ends with a comment holding
+// its checksum, and is synced to disk before the update takes effect,
+// so a batch a crash left incomplete is recognized and ignored. Opening
+// the file replays the batches and compacts it. The whole directory is
+// kept in memory, and searches can use the indexes of
+// MemoryBackend.Index and IndexAttribute.
+//
+// The indexes are not persisted but rebuilt on every open, so the time
+// to open a FileBackend and the memory it uses grow with the size of
+// the directory; a KVBackend suits larger directories.
+type FileBackend struct {
+	*MemoryBackend
+
+	path string
+	mu   sync.Mutex // guards f and err
+	f    *os.File
+	err  error // the journal's first write error
+}
+
+// OpenFileBackend opens the directory kept in the file at path,
+// creating it if it does not exist. mem, if not nil, is an empty
+// MemoryBackend whose Rules and indexes are used.
+func OpenFileBackend(path string, mem *MemoryBackend) (*FileBackend, error) {
+	if mem == nil {
+		mem = NewMemoryBackend()
+	}
+	b := &FileBackend{MemoryBackend: mem, path: path}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := b.replay(data); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	if err := b.Compact(); err != nil {
+		return nil, err
+	}
+	mem.persist = b.journal
+	return b, nil
+}
+
+// batchMarker begins the comment that ends each batch of records in the
+// file, followed by the batch's CRC-32 in hexadecimal.
+const batchMarker = "# end "
+
+// batches returns the batches of records in data. A last batch without
+// its marker, or whose checksum does not match, was being written when
+// the journal stopped, and is left out. A file without markers is
+// taken as a single batch.
+func batches(data []byte) ([][]byte, error) {
+	var batches [][]byte
+	framed := false
+	start := 0
+	for pos := 0; pos < len(data); {
+		n := bytes.IndexByte(data[pos:], '\n')
+		if n < 0 {
+			break
+		}
+		line, next := data[pos:pos+n], pos+n+1
+		if sum, ok := parseBatchMarker(line); ok {
+			framed = true
+			if crc32.ChecksumIEEE(data[start:pos]) != sum {
+				if len(bytes.TrimSpace(data[next:])) == 0 {
+					break
+				}
+				return nil, fmt.Errorf("bad checksum at byte %d", pos)
+			}
+			batches = append(batches, data[start:pos])
+			start = next
+		}
+		pos = next
+	}
+	if !framed {
+		return [][]byte{data}, nil
+	}
+	return batches, nil
+}
+
+func parseBatchMarker(line []byte) (uint32, bool) {
+	if !bytes.HasPrefix(line, []byte(batchMarker)) || len(line) != len(batchMarker)+8 {
+		return 0, false
+	}
+	sum, err := strconv.ParseUint(string(line[len(batchMarker):]), 16, 32)
+	return uint32(sum), err == nil
+}
+
+// frame ends the batch of records in buf with its marker.
+func frame(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s%08x\n", batchMarker, crc32.ChecksumIEEE(buf.Bytes()))
+}
+
+func (b *FileBackend) replay(data []byte) error {
+	batches, err := batches(data)
+	if err != nil {
+		return fmt.Errorf("ldap: %s: %v", b.path, err)
+	}
+	for _, batch := range batches {
+		lr := ldif.NewReader(bytes.NewReader(batch))
+		for {
+			rec, err := lr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("ldap: %s: %v", b.path, err)
+			}
+			if err := b.replayRecord(rec); err != nil {
+				return fmt.Errorf("ldap: %s: replaying %s of %q: %v", b.path, rec.ChangeType, rec.DN, err)
+			}
+		}
+	}
+	return nil
+}
+
+// replayRecord applies a record of the file. Entries and deletes are
+// applied as they are; other change records, which earlier versions
+// wrote, are performed as updates.
+func (b *FileBackend) replayRecord(rec *ldif.Record) error {
+	switch rec.ChangeType {
+	case ldif.NoChange:
+		if b.MemoryBackend.Entry(rec.DN) == nil {
+			return b.MemoryBackend.AddEntry(rec.Entry())
+		}
+		return b.restore(rec.DN, rec.Entry())
+	case ldif.Add:
+		return b.MemoryBackend.Add(nil, &AddRequest{DN: rec.DN, Attributes: rec.Attributes})
+	case ldif.Modify:
+		return b.MemoryBackend.Modify(nil, &ModifyRequest{DN: rec.DN, Modifications: rec.Modifications})
+	case ldif.Delete:
+		if b.MemoryBackend.Entry(rec.DN) == nil {
+			return ldapError(ldap.NoSuchObject, "no such entry %q", rec.DN)
+		}
+		return b.restore(rec.DN, nil)
+	case ldif.ModRDN, ldif.ModDN:
+		return b.MemoryBackend.ModifyDN(nil, &ModifyDNRequest{DN: rec.DN, NewRDN: rec.NewRDN,
+			DeleteOldRDN: rec.DeleteOldRDN, NewSuperior: rec.NewSuperior})
+	}
+	return nil
+}
+
+// restore replaces the entry dn with e, or deletes it if e is nil.
+func (b *FileBackend) restore(dn string, e *ldap.Entry) error {
+	name, err := normalizeDN(dn)
+	if err != nil {
+		return err
+	}
+	b.MemoryBackend.mu.Lock()
+	defer b.MemoryBackend.mu.Unlock()
+	return b.MemoryBackend.apply(entryChange{name, e})
+}
+
+// Compact rewrites the file with the current entries, dropping the
+// batches of changes.
+func (b *FileBackend) Compact() error {
+	// Updates wait, as their batches would be lost.
+	b.MemoryBackend.mu.RLock()
+	defer b.MemoryBackend.mu.RUnlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+
+	entries, err := b.MemoryBackend.allEntries()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	w := ldif.NewWriter(&buf)
+	for _, e := range entries {
+		if err := w.WriteEntry(e); err != nil {
+			return err
+		}
+	}
+	if buf.Len() > 0 {
+		buf.WriteByte('\n')
+	}
+	frame(&buf)
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(b.path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if b.f != nil {
+		b.f.Close()
+	}
+	b.f = f
+	return nil
+}
+
+// Close closes the file. Later updates fail.
+func (b *FileBackend) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.f == nil {
+		return nil
+	}
+	err := b.f.Close()
+	b.f = nil
+	if b.err == nil {
+		b.err = ldapError(ldap.Unavailable, "directory closed")
+	}
+	return err
+}
+
+// journal records changes in the file as a batch, and syncs it, before
+// the MemoryBackend applies them.
+func (b *FileBackend) journal(changes []entryChange) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+
+	var buf bytes.Buffer
+	w := ldif.NewWriter(&buf)
+	w.Version = 0
+	for _, ch := range changes {
+		rec := &ldif.Record{DN: ch.name.String(), ChangeType: ldif.Delete}
+		if ch.entry != nil {
+			rec = ldif.NewContentRecord(ch.entry)
+		}
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('\n')
+	frame(&buf)
+	_, err := b.f.Write(buf.Bytes())
+	if err == nil {
+		err = b.f.Sync()
+	}
+	if err != nil {
+		// Whether the batch reached the file is unknown, so stop taking
+		// updates.
+		b.err = ldapError(ldap.Unavailable, "directory not writable")
+		return ldapError(ldap.Other, "writing %s: %v", b.path, err)
+	}
+	return nil
+}
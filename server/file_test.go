@@ -0,0 +1,195 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFileBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory.ldif")
+	b, err := OpenFileBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range newTestBackend(t).Entries() {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, stop := startTestServer(t, b)
+	const bob = "cn=Bob,ou=People,dc=example,dc=com"
+	err = c.Add("cn=Carol,ou=People,dc=example,dc=com", []ldap.Attribute{
+		{Type: "objectClass", Values: []string{"person"}}, {Type: "sn", Values: []string{"White"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods := []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Builder"}}}}
+	if err := c.Modify(bob, mods); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ModifyDN(bob, "cn=Robert", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("cn=Alice,ou=People,dc=example,dc=com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("cn=Nobody,dc=example,dc=com"); resultCode(err) != ldap.NoSuchObject {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.NoSuchObject)
+	}
+	stop()
+	expected := b.Entries()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddEntry(ldap.NewEntry("dc=org", nil)); resultCode(err) != ldap.Unavailable {
+		t.Errorf("Bad result after Close: %v (expected %v)", err, ldap.Unavailable)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "changetype: delete") || !strings.Contains(string(data), batchMarker) {
+		t.Errorf("Bad journal: %s", data)
+	}
+
+	mem := NewMemoryBackend()
+	mem.Index("sn")
+	b, err = OpenFileBackend(path, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if entries := b.Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Bad entries: %v (expected %v)", entries, expected)
+	}
+	// Opening the file compacted it.
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "changetype") {
+		t.Errorf("Bad compacted file: %s", data)
+	}
+
+	c, stop = startTestServer(t, b)
+	defer stop()
+	if dns := searchDNs(t, c, "(sn=builder)"); !reflect.DeepEqual(dns, []string{"cn=Robert,ou=People,dc=example,dc=com"}) {
+		t.Errorf("Bad search result: %v", dns)
+	}
+}
+
+func TestFileBackendTornBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory.ldif")
+	b, err := OpenFileBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range newTestBackend(t).Entries() {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := b.Entries()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddEntry(ldap.NewEntry("cn=Carol,ou=People,dc=example,dc=com", map[string][]string{
+		"objectClass": {"person"}, "sn": {"White"}})); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash left the last batch half written.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int64{int64(len(data)) - 3, (info.Size() + int64(len(data))) / 2} {
+		if err := os.WriteFile(path, data[:n], 0o600); err != nil {
+			t.Fatal(err)
+		}
+		b, err = OpenFileBackend(path, nil)
+		if err != nil {
+			t.Fatalf("truncated to %d bytes: %v", n, err)
+		}
+		if entries := b.Entries(); !reflect.DeepEqual(entries, expected) {
+			t.Errorf("Bad entries truncated to %d bytes: %v (expected %v)", n, entries, expected)
+		}
+		b.Close()
+	}
+
+	// A damaged batch before the last is an error.
+	if err := os.WriteFile(path, append([]byte("dn: dc=org\n\n"+batchMarker+"00000000\n"), data...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFileBackend(path, nil); err == nil {
+		t.Error("Expected error opening a damaged file")
+	}
+}
+
+func TestFileBackendWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory.ldif")
+	b, err := OpenFileBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.f.Close()
+	if err := b.AddEntry(ldap.NewEntry("dc=com", nil)); resultCode(err) != ldap.Other {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.Other)
+	}
+	// The change the file did not record was not made.
+	if e := b.Entry("dc=com"); e != nil {
+		t.Errorf("Bad entry after write error: %v", e)
+	}
+	if err := b.AddEntry(ldap.NewEntry("dc=org", nil)); resultCode(err) != ldap.Unavailable {
+		t.Errorf("Bad result after write error: %v (expected %v)", err, ldap.Unavailable)
+	}
+}
+
+func TestFileBackendPasswordModify(t *testing.T) {
+	const alice = "cn=Alice,ou=People,dc=example,dc=com"
+	path := filepath.Join(t.TempDir(), "directory.ldif")
+	b, err := OpenFileBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range newTestBackend(t).Entries() {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, stop := startTestServer(t, b)
+	if err := c.Bind(alice, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PasswordModify("", "secret", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	expected := b.Entry(alice)
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenFileBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if e := b.Entry(alice); !reflect.DeepEqual(e, expected) {
+		t.Errorf("Bad entry: %v (expected %v)", e, expected)
+	}
+	if err := b.VerifyPassword(nil, alice, "changed"); err != nil {
+		t.Errorf("Bad password after reopening: %v", err)
+	}
+}
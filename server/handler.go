@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/stesla/ldap"
+)
+
+// A Handler performs the operations a server receives. Each method
+// fails the operation by returning an error: an *ldap.Error is sent with
+// its result code, and any other error is sent as ldap.Other.
+//
+// Handlers are called concurrently for the operations of a connection,
+// except Bind, which runs once the operations before it have finished.
+type Handler interface {
+	Bind(c *Conn, req *BindRequest) error
+	Search(c *Conn, req *SearchRequest, w SearchWriter) error
+	Add(c *Conn, req *AddRequest) error
+	Modify(c *Conn, req *ModifyRequest) error
+	Delete(c *Conn, req *DeleteRequest) error
+	ModifyDN(c *Conn, req *ModifyDNRequest) error
+	Compare(c *Conn, req *CompareRequest) (bool, error)
+	Extended(c *Conn, req *ExtendedRequest) (*ExtendedResponse, error)
+}
+
+// Request holds what is common to every request.
+type Request struct {
+	MessageID int
+	Controls  []*Control
+	// ResponseControls are sent with the response.
+	ResponseControls []ldap.Control
+
+	ctx context.Context
+}
+
+// Context returns a context that is canceled when the client abandons
+// the operation or the connection closes.
+func (r *Request) Context() context.Context { return r.ctx }
+
+// Control returns the request control of the given type, or nil.
+func (r *Request) Control(oid string) *Control {
+	for _, c := range r.Controls {
+		if c.OID == oid {
+			return c
+		}
+	}
+	return nil
+}
+
+// A Control is a control sent with a request. It implements
+// ldap.Control, so it can be passed on to another server unchanged.
+type Control struct {
+	OID         string
+	Criticality bool
+	Value       []byte
+}
+
+func (c *Control) ControlType() string           { return c.OID }
+func (c *Control) Critical() bool                { return c.Criticality }
+func (c *Control) ControlValue() ([]byte, error) { return c.Value, nil }
+
+// A BindRequest is a simple bind if SASL is nil. If the handler accepts
+// a simple bind, the connection's BindDN becomes Name; after a SASL bind
+// the handler sets it with Conn.SetBindDN.
+type BindRequest struct {
+	Request
+	Version  int
+	Name     string
+	Password string
+	SASL     *SASLCredentials
+	// VerifiedChains are the client's certificate chains, if it sent a
+	// certificate over TLS and the server's tls.Config verified it, for
+	// use by e.g. SASL EXTERNAL.
+	VerifiedChains [][]*x509.Certificate
+	// ServerSASLCredentials are sent with the response to a SASL bind,
+	// e.g. a challenge along with ldap.SaslBindInProgress.
+	ServerSASLCredentials []byte
+}
+
+type SASLCredentials struct {
+	Mechanism   string
+	Credentials []byte
+}
+
+// A SearchRequest carries the same fields the client sends, with the
+// filter decoded as by ldap.DecodeFilter.
+type SearchRequest struct {
+	Request
+	ldap.SearchRequest
+}
+
+// BaseDN returns the base of the search as a string.
+func (r *SearchRequest) BaseDN() string { return string(r.BaseObject) }
+
+// AttributeList returns the requested attributes as strings.
+func (r *SearchRequest) AttributeList() []string {
+	attrs := make([]string, len(r.Attributes))
+	for i, a := range r.Attributes {
+		attrs[i] = string(a)
+	}
+	return attrs
+}
+
+// A SearchWriter returns the results of a search to the client.
+type SearchWriter interface {
+	WriteEntry(e *ldap.Entry, controls ...ldap.Control) error
+	WriteReference(urls ...string) error
+}
+
+type AddRequest struct {
+	Request
+	DN         string
+	Attributes []ldap.Attribute
+}
+
+type ModifyRequest struct {
+	Request
+	DN            string
+	Modifications []ldap.Modification
+}
+
+type DeleteRequest struct {
+	Request
+	DN string
+}
+
+type ModifyDNRequest struct {
+	Request
+	DN           string
+	NewRDN       string
+	DeleteOldRDN bool
+	NewSuperior  string
+}
+
+type CompareRequest struct {
+	Request
+	DN        string
+	Attribute string
+	Value     string
+}
+
+type ExtendedRequest struct {
+	Request
+	Name  string
+	Value []byte
+
+	user string // the DN the user identity of a Password Modify resolved to
+}
+
+type ExtendedResponse struct {
+	Name  string
+	Value []byte
+}
+
+// An Operation is any of the requests passed to a Handler, as seen by
+// middleware.
+type Operation interface {
+	// OperationName returns "bind", "search", "add", "modify",
+	// "delete", "modifyDN", "compare" or "extended".
+	OperationName() string
+	// TargetDN returns the DN the operation names: the bind name, the
+	// search base or the entry, or "" for extended operations.
+	TargetDN() string
+	// BaseRequest returns what is common to every request.
+	BaseRequest() *Request
+}
+
+func (r *Request) BaseRequest() *Request { return r }
+
+func (*BindRequest) OperationName() string     { return "bind" }
+func (*SearchRequest) OperationName() string   { return "search" }
+func (*AddRequest) OperationName() string      { return "add" }
+func (*ModifyRequest) OperationName() string   { return "modify" }
+func (*DeleteRequest) OperationName() string   { return "delete" }
+func (*ModifyDNRequest) OperationName() string { return "modifyDN" }
+func (*CompareRequest) OperationName() string  { return "compare" }
+func (*ExtendedRequest) OperationName() string { return "extended" }
+
+func (r *BindRequest) TargetDN() string     { return r.Name }
+func (r *SearchRequest) TargetDN() string   { return r.BaseDN() }
+func (r *AddRequest) TargetDN() string      { return r.DN }
+func (r *ModifyRequest) TargetDN() string   { return r.DN }
+func (r *DeleteRequest) TargetDN() string   { return r.DN }
+func (r *ModifyDNRequest) TargetDN() string { return r.DN }
+func (r *CompareRequest) TargetDN() string  { return r.DN }
+func (r *ExtendedRequest) TargetDN() string { return "" }
+
+// BaseHandler refuses every operation. Embed it in a handler to
+// implement only some of them.
+type BaseHandler struct{}
+
+func unwilling() error {
+	return &ldap.Error{ResultCode: ldap.UnwillingToPerform, DiagnosticMessage: "operation not supported"}
+}
+
+func (BaseHandler) Bind(c *Conn, req *BindRequest) error {
+	return &ldap.Error{ResultCode: ldap.AuthMethodNotSupported}
+}
+
+func (BaseHandler) Search(c *Conn, req *SearchRequest, w SearchWriter) error { return unwilling() }
+func (BaseHandler) Add(c *Conn, req *AddRequest) error                       { return unwilling() }
+func (BaseHandler) Modify(c *Conn, req *ModifyRequest) error                 { return unwilling() }
+func (BaseHandler) Delete(c *Conn, req *DeleteRequest) error                 { return unwilling() }
+func (BaseHandler) ModifyDN(c *Conn, req *ModifyDNRequest) error             { return unwilling() }
+func (BaseHandler) Compare(c *Conn, req *CompareRequest) (bool, error)       { return false, unwilling() }
+
+func (BaseHandler) Extended(c *Conn, req *ExtendedRequest) (*ExtendedResponse, error) {
+	return nil, &ldap.Error{ResultCode: ldap.ProtocolError, DiagnosticMessage: "unsupported extended operation " + req.Name}
+}
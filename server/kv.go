@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/internal/kv"
+	"github.com/stesla/ldap/ldif"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A KVBackend is a MemoryBackend whose entries, and the postings of its
+// indexes, are kept in a key-value store in a file rather than in
+// memory, for directories too large to load, or to index, on every
+// start. Operations read the entries they need from the file, and each
+// update is committed to it before it takes effect.
+//
+// The kinds of index kept of each attribute are recorded in the file
+// too. Searches use them as for a MemoryBackend; a subtree search that
+// no index answers reads the entries below its base.
+type KVBackend struct {
+	*MemoryBackend
+
+	db *kv.DB
+}
+
+// OpenKVBackend opens the directory kept in the file at path, creating
+// it if it does not exist. mem, if not nil, is an empty MemoryBackend
+// whose Rules are used, and whose indexes are kept in addition to those
+// the file records; new ones are built from the entries in the file.
+func OpenKVBackend(path string, mem *MemoryBackend) (*KVBackend, error) {
+	if mem == nil {
+		mem = NewMemoryBackend()
+	}
+	db, err := kv.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &kvStore{db}
+	indexes, err := s.indexes()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	requested := mem.indexes
+	mem.store, mem.indexes = s, indexes
+	for attr, types := range requested {
+		mem.IndexAttribute(attr, types)
+	}
+	return &KVBackend{mem, db}, nil
+}
+
+// Compact rewrites the file without the space that updates have left
+// unused. Operations wait until it is done.
+func (b *KVBackend) Compact() error {
+	b.MemoryBackend.mu.Lock()
+	defer b.MemoryBackend.mu.Unlock()
+	return kvError(b.db.Compact())
+}
+
+// Close closes the file. Later operations fail.
+func (b *KVBackend) Close() error {
+	return b.db.Close()
+}
+
+// kvStore is the entryStore of a KVBackend. An entry is stored as LDIF
+// under "e" and the key of its name, a posting as an empty value under
+// "p", the length of the term, the term and the key of the name, and
+// the kinds of index of an attribute under "x" and the attribute.
+type kvStore struct {
+	db *kv.DB
+}
+
+// nameKey returns the key of a name: its RDNs, from the root, each
+// ended by a zero byte, which normalized RDNs do not contain. The keys
+// of the entries below an entry begin with its key.
+func nameKey(name ldap.DN) []byte {
+	var key []byte
+	for i := len(name) - 1; i >= 0; i-- {
+		key = append(append(key, name[i].String()...), 0)
+	}
+	return key
+}
+
+// keyName returns the normalized name whose key is key.
+func keyName(key []byte) string {
+	rdns := strings.Split(strings.TrimSuffix(string(key), "\x00"), "\x00")
+	for i, j := 0, len(rdns)-1; i < j; i, j = i+1, j-1 {
+		rdns[i], rdns[j] = rdns[j], rdns[i]
+	}
+	return strings.Join(rdns, ",")
+}
+
+func entryKey(name ldap.DN) []byte {
+	return append([]byte("e"), nameKey(name)...)
+}
+
+func termKey(term string) []byte {
+	key := binary.AppendUvarint([]byte("p"), uint64(len(term)))
+	return append(key, term...)
+}
+
+// kvError reports that the store is closed as the directory being
+// unavailable.
+func kvError(err error) error {
+	if err == kv.ErrClosed {
+		return ldapError(ldap.Unavailable, "directory closed")
+	}
+	return err
+}
+
+func encodeEntry(e *ldap.Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	w := ldif.NewWriter(&buf)
+	w.Version, w.Width = 0, 0
+	err := w.WriteEntry(e)
+	return buf.Bytes(), err
+}
+
+func decodeEntry(data []byte) (*memoryEntry, error) {
+	rec, err := ldif.NewReader(bytes.NewReader(data)).Read()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, ldapError(ldap.Other, "bad stored entry: %v", err)
+	}
+	name, err := normalizeDN(rec.DN)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryEntry{name, rec.Entry()}, nil
+}
+
+func (s *kvStore) entry(name ldap.DN) (*memoryEntry, error) {
+	data, ok, err := s.db.Get(entryKey(name))
+	if err != nil || !ok {
+		return nil, kvError(err)
+	}
+	return decodeEntry(data)
+}
+
+// walk reads the entries in batches, so that fn may use the store.
+func (s *kvStore) walk(base ldap.DN, fn func(me *memoryEntry) bool) error {
+	const batch = 256
+	prefix := entryKey(base)
+	start := prefix
+	for {
+		var found []*memoryEntry
+		var derr error
+		err := s.db.Scan(prefix, start, func(key, value []byte) bool {
+			var me *memoryEntry
+			if me, derr = decodeEntry(value); derr != nil {
+				return false
+			}
+			found = append(found, me)
+			start = append(append(start[:0:0], key...), 0)
+			return len(found) < batch
+		})
+		if err == nil {
+			err = derr
+		}
+		if err != nil {
+			return kvError(err)
+		}
+		for _, me := range found {
+			if !fn(me) {
+				return nil
+			}
+		}
+		if len(found) < batch {
+			return nil
+		}
+	}
+}
+
+func (s *kvStore) posted(term string, limit int) (map[string]bool, bool, error) {
+	prefix := termKey(term)
+	names := map[string]bool{}
+	err := s.db.Scan(prefix, nil, func(key, value []byte) bool {
+		names[keyName(key[len(prefix):])] = true
+		return limit < 0 || len(names) <= limit
+	})
+	return names, limit < 0 || len(names) <= limit, kvError(err)
+}
+
+// indexes returns the kinds of index the store records.
+func (s *kvStore) indexes() (map[string]IndexType, error) {
+	indexes := map[string]IndexType{}
+	err := s.db.Scan([]byte("x"), nil, func(key, value []byte) bool {
+		types, _ := strconv.Atoi(string(value))
+		indexes[string(key[1:])] = IndexType(types)
+		return true
+	})
+	return indexes, kvError(err)
+}
+
+func (s *kvStore) write(u *storeUpdate) error {
+	return kvError(s.db.Update(func(tx *kv.Tx) error {
+		for _, ch := range u.changes {
+			if ch.entry == nil {
+				if err := tx.Delete(entryKey(ch.name)); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := encodeEntry(ch.entry)
+			if err != nil {
+				return err
+			}
+			if err := tx.Put(entryKey(ch.name), data); err != nil {
+				return err
+			}
+		}
+		for p, add := range u.postings {
+			key := append(termKey(p.term), nameKey(mustParseDN(p.name))...)
+			var err error
+			if add {
+				err = tx.Put(key, nil)
+			} else {
+				err = tx.Delete(key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if u.indexes != nil {
+			for attr, types := range u.indexes {
+				if err := tx.Put([]byte("x"+attr), []byte(strconv.Itoa(int(types)))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKVBackend(t *testing.T) {
+	const bob = "cn=Bob,ou=People,dc=example,dc=com"
+	path := filepath.Join(t.TempDir(), "directory.db")
+	mem := NewMemoryBackend()
+	mem.Index("sn")
+	b, err := OpenKVBackend(path, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range newTestBackend(t).Entries() {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, stop := startTestServer(t, b)
+	err = c.Add("cn=Carol,ou=People,dc=example,dc=com", []ldap.Attribute{
+		{Type: "objectClass", Values: []string{"person"}}, {Type: "sn", Values: []string{"White"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods := []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Builder"}}}}
+	if err := c.Modify(bob, mods); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ModifyDN(bob, "cn=Robert", false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("cn=Alice,ou=People,dc=example,dc=com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("ou=People,dc=example,dc=com"); resultCode(err) != ldap.NotAllowedOnNonLeaf {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.NotAllowedOnNonLeaf)
+	}
+	if dns := searchDNs(t, c, "(sn=jones)"); len(dns) != 0 {
+		t.Errorf("Bad search result: %v", dns)
+	}
+	stop()
+	expected := b.Entries()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddEntry(ldap.NewEntry("dc=org", nil)); resultCode(err) != ldap.Unavailable {
+		t.Errorf("Bad result after Close: %v (expected %v)", err, ldap.Unavailable)
+	}
+
+	// The file records the entries and the index of sn.
+	b, err = OpenKVBackend(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if entries := b.Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Bad entries: %v (expected %v)", entries, expected)
+	}
+	b.IndexAttribute("objectClass", IndexEquality)
+	for i, test := range []struct {
+		filter string
+		count  int
+	}{
+		{"(sn=builder)", 1},
+		{"(sn=*)", 2},
+		{"(&(objectClass=person)(sn=white))", 1},
+		{"(objectClass=person)", 2},
+	} {
+		f, err := ldap.CompileFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names, ok := b.candidates(ldap.NormalizeFilter(f)); !ok || len(names) != test.count {
+			t.Errorf("#%d: Bad candidates: %v, %v (expected %d)", i, names, ok, test.count)
+		}
+	}
+
+	if err := b.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	c, stop = startTestServer(t, b)
+	defer stop()
+	if dns := searchDNs(t, c, "(sn=builder)"); !reflect.DeepEqual(dns, []string{"cn=Robert,ou=People,dc=example,dc=com"}) {
+		t.Errorf("Bad search result: %v", dns)
+	}
+	dse, err := c.RootDSE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dse.NamingContexts, []string{"dc=example,dc=com"}) {
+		t.Errorf("Bad naming contexts: %v", dse.NamingContexts)
+	}
+}
@@ -0,0 +1,1412 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/stesla/ldap"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MemoryBackend is a Handler that keeps a directory in memory, for
+// tests and small embedded directories. It does no schema checking, and
+// supports only simple binds, against the entry's userPassword values,
+// which may be hashed as by ldap.HashPassword.
+// Its Authenticate and Password methods let the SASL middleware check
+// the same passwords.
+//
+// Like a real server, it maintains createTimestamp, creatorsName,
+// entryUUID, modifyTimestamp, modifiersName and entryCSN on its
+// entries, which clients may not set, and which searches return only
+// if requested by name or with "+".
+type MemoryBackend struct {
+	BaseHandler
+	// Rules returns the equality matching rule of an attribute, used to
+	// evaluate filters and compare values. If it is nil or returns nil,
+	// CaseIgnoreMatch is used.
+	Rules func(attribute string) ldap.MatchingRule
+	// PasswordAdmin, if set, reports whether a client bound as bindDN
+	// may set the password of the entry dn with Password Modify. Without
+	// it clients may set only their own.
+	PasswordAdmin func(bindDN, dn string) bool
+
+	mu      sync.RWMutex
+	store   entryStore
+	indexes map[string]IndexType // by lowercased attribute
+	// persist, if set, records changes before they are applied, as for
+	// a FileBackend.
+	persist func(changes []entryChange) error
+
+	csnTime  time.Time // of the last entryCSN
+	csnCount int
+
+	pageMu     sync.Mutex
+	pages      map[string]*pagedResults
+	pagedConns map[*Conn]bool
+	lastCookie int
+}
+
+// pagedResults holds the rest of a paged search between pages.
+type pagedResults struct {
+	conn    *Conn
+	entries []*ldap.Entry
+}
+
+type memoryEntry struct {
+	name  ldap.DN // normalized
+	entry *ldap.Entry
+}
+
+// An entryChange replaces the entry named name with entry, or deletes it
+// if entry is nil.
+type entryChange struct {
+	name  ldap.DN
+	entry *ldap.Entry
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{store: newMemStore()}
+}
+
+func ldapError(code ldap.ResultCode, format string, args ...interface{}) error {
+	return &ldap.Error{ResultCode: code, DiagnosticMessage: fmt.Sprintf(format, args...)}
+}
+
+func normalizeDN(dn string) (ldap.DN, error) {
+	norm, err := ldap.DistinguishedNameMatch.Normalize(dn)
+	if err != nil {
+		return nil, ldapError(ldap.InvalidDNSyntax, "%v", err)
+	}
+	name, _ := ldap.ParseDN(norm)
+	return name, nil
+}
+
+func (b *MemoryBackend) rule(attr string) ldap.MatchingRule {
+	if b.Rules != nil {
+		if rule := b.Rules(attr); rule != nil {
+			return rule
+		}
+	}
+	return ldap.CaseIgnoreMatch
+}
+
+// equal reports whether two values of attr match for equality. Values
+// the rule cannot normalize are compared as they are.
+func (b *MemoryBackend) equal(attr, x, y string) bool {
+	rule := b.rule(attr)
+	nx, errx := rule.Normalize(x)
+	ny, erry := rule.Normalize(y)
+	if errx != nil || erry != nil {
+		return x == y
+	}
+	return nx == ny
+}
+
+func (b *MemoryBackend) indexOf(attr string, values []string, v string) int {
+	for i, w := range values {
+		if b.equal(attr, w, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddEntry stores a copy of e without checking that its parent exists,
+// to seed the directory with naming contexts and test data. The
+// operational attributes e lacks are filled in.
+func (b *MemoryBackend) AddEntry(e *ldap.Entry) error {
+	name, err := normalizeDN(e.DN)
+	if err != nil {
+		return err
+	}
+	if len(name) == 0 {
+		return ldapError(ldap.UnwillingToPerform, "cannot add the root DSE")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if me, err := b.store.entry(name); err != nil {
+		return err
+	} else if me != nil {
+		return ldapError(ldap.EntryAlreadyExists, "entry %q already exists", e.DN)
+	}
+	c := copyEntry(e)
+	if err := b.stamp(nil, c, true, attributeNames(e)); err != nil {
+		return err
+	}
+	return b.apply(entryChange{name, c})
+}
+
+// apply makes changes to the directory, once persist, if set, has
+// recorded them. The caller holds b.mu for writing, so no one sees the
+// changes before they are recorded.
+func (b *MemoryBackend) apply(changes ...entryChange) error {
+	u := &storeUpdate{changes: changes, postings: map[posting]bool{}}
+	// The entries as the changes before each leave them.
+	current := map[string]*ldap.Entry{}
+	for _, ch := range changes {
+		key := ch.name.String()
+		old, ok := current[key]
+		if !ok {
+			me, err := b.store.entry(ch.name)
+			if err != nil {
+				return err
+			}
+			if me != nil {
+				old = me.entry
+			}
+		}
+		if old != nil {
+			for attr, types := range b.indexes {
+				for _, term := range b.terms(old, attr, types) {
+					u.postings[posting{term, key}] = false
+				}
+			}
+		}
+		if ch.entry != nil {
+			for attr, types := range b.indexes {
+				for _, term := range b.terms(ch.entry, attr, types) {
+					u.postings[posting{term, key}] = true
+				}
+			}
+		}
+		current[key] = ch.entry
+	}
+	if b.persist != nil {
+		if err := b.persist(changes); err != nil {
+			return err
+		}
+	}
+	return b.store.write(u)
+}
+
+// Entry returns a copy of the entry with the given DN, or nil.
+func (b *MemoryBackend) Entry(dn string) *ldap.Entry {
+	name, err := normalizeDN(dn)
+	if err != nil {
+		return nil
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if me, _ := b.store.entry(name); me != nil {
+		return copyEntry(me.entry)
+	}
+	return nil
+}
+
+func copyEntry(e *ldap.Entry) *ldap.Entry {
+	c := &ldap.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		c.Attributes = append(c.Attributes, ldap.NewEntryAttribute(a.Name, append([]string(nil), a.Values...)))
+	}
+	return c
+}
+
+// noSuchObject reports that name does not exist, with the DN of its
+// nearest existing superior as the matched DN.
+func (b *MemoryBackend) noSuchObject(name ldap.DN, dn string) error {
+	err := &ldap.Error{ResultCode: ldap.NoSuchObject, DiagnosticMessage: fmt.Sprintf("no such entry %q", dn)}
+	for i := 1; i < len(name); i++ {
+		if me, _ := b.store.entry(name[i:]); me != nil {
+			err.MatchedDN = me.entry.DN
+			break
+		}
+	}
+	return err
+}
+
+// lookup returns the entry named dn. The caller holds b.mu.
+func (b *MemoryBackend) lookup(dn string) (*memoryEntry, error) {
+	name, err := normalizeDN(dn)
+	if err != nil {
+		return nil, err
+	}
+	me, err := b.store.entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if me == nil {
+		return nil, b.noSuchObject(name, dn)
+	}
+	return me, nil
+}
+
+// isUnder reports whether name is base or one of its descendants.
+func isUnder(name, base ldap.DN) bool {
+	return len(name) >= len(base) && name[len(name)-len(base):].String() == base.String()
+}
+
+func (b *MemoryBackend) hasChildren(name ldap.DN) (bool, error) {
+	found := false
+	err := b.store.walk(name, func(me *memoryEntry) bool {
+		found = len(me.name) > len(name)
+		return !found
+	})
+	return found, err
+}
+
+func (b *MemoryBackend) Bind(c *Conn, req *BindRequest) error {
+	if req.SASL != nil {
+		return b.BaseHandler.Bind(c, req)
+	}
+	if req.Name == "" && req.Password == "" {
+		return nil
+	}
+	if req.Password == "" {
+		return ldapError(ldap.UnwillingToPerform, "unauthenticated bind not allowed")
+	}
+
+	return b.VerifyPassword(c, req.Name, req.Password)
+}
+
+// VerifyPassword checks password against the userPassword values of the
+// entry dn, so that the backend can be the PasswordVerifier of the
+// SimpleBind middleware in front of another handler.
+func (b *MemoryBackend) VerifyPassword(c *Conn, dn, password string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if me, err := b.lookup(dn); err == nil && hasPassword(me.entry, password) {
+		return nil
+	}
+	return &ldap.Error{ResultCode: ldap.InvalidCredentials}
+}
+
+func hasPassword(e *ldap.Entry, password string) bool {
+	for _, pw := range e.GetAttributeValues("userPassword") {
+		if ok, _ := ldap.VerifyPassword(pw, password); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate checks password against the userPassword values of the
+// entry authcID names, as Password finds it, and returns its DN. It may
+// be used as SASLPlain.Authenticate.
+func (b *MemoryBackend) Authenticate(authcID, password string) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if me := b.user(authcID); me != nil && password != "" && hasPassword(me.entry, password) {
+		return me.entry.DN, nil
+	}
+	return "", &ldap.Error{ResultCode: ldap.InvalidCredentials}
+}
+
+// Password returns the DN and the first cleartext userPassword value of
+// the entry username names: a DN, optionally prefixed with "dn:", or
+// otherwise the uid of an entry, optionally prefixed with "u:". Values
+// with a {scheme} prefix are hashed and skipped; if the entry has no
+// other value, inappropriateAuthentication is returned. It may be used
+// as SASLDigestMD5.Password.
+func (b *MemoryBackend) Password(username string) (dn, password string, err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	me := b.user(username)
+	if me == nil {
+		return "", "", &ldap.Error{ResultCode: ldap.InvalidCredentials}
+	}
+	for _, pw := range me.entry.GetAttributeValues("userPassword") {
+		if !isHashedPassword(pw) {
+			return me.entry.DN, pw, nil
+		}
+	}
+	return "", "", ldapError(ldap.InappropriateAuthentication, "no cleartext password stored")
+}
+
+// isHashedPassword reports whether a userPassword value has a {scheme}
+// prefix, as ldap.VerifyPassword would treat it.
+func isHashedPassword(pw string) bool {
+	return strings.HasPrefix(pw, "{") && strings.Contains(pw, "}")
+}
+
+// user returns the entry username names, as for Password, or nil. The
+// caller holds b.mu.
+func (b *MemoryBackend) user(username string) *memoryEntry {
+	if strings.HasPrefix(username, "dn:") || (strings.Contains(username, "=") && !strings.HasPrefix(username, "u:")) {
+		me, _ := b.lookup(strings.TrimPrefix(username, "dn:"))
+		return me
+	}
+	uid := strings.TrimPrefix(username, "u:")
+	var found *memoryEntry
+	b.scan(ldap.DN{}, ldap.Equals("uid", uid), func(me *memoryEntry) bool {
+		if b.indexOf("uid", me.entry.GetAttributeValues("uid"), uid) >= 0 {
+			found = me
+		}
+		return found == nil
+	})
+	return found
+}
+
+const oidPasswordModify = "1.3.6.1.4.1.4203.1.11.1"
+
+// Extended supports the Who am I? and Password Modify operations. A
+// client may set its own password, and those PasswordAdmin allows the
+// passwords of other entries; the new password is stored hashed with
+// {SSHA}, and one is generated if none is given.
+func (b *MemoryBackend) Extended(c *Conn, req *ExtendedRequest) (*ExtendedResponse, error) {
+	switch req.Name {
+	case oidWhoAmI:
+		if c.BindDN() == "" {
+			return &ExtendedResponse{}, nil
+		}
+		return &ExtendedResponse{Value: []byte("dn:" + c.BindDN())}, nil
+	case oidPasswordModify:
+		resp, _, err := b.passwordModify(c, req)
+		return resp, err
+	}
+	return b.BaseHandler.Extended(c, req)
+}
+
+// passwordModifyRequest is the value of a Password Modify request.
+type passwordModifyRequest struct {
+	UserIdentity []byte `asn1:"tag:0,optional"`
+	OldPassword  []byte `asn1:"tag:1,optional"`
+	NewPassword  []byte `asn1:"tag:2,optional"`
+}
+
+func decodePasswordModify(req *ExtendedRequest) (r passwordModifyRequest, err error) {
+	if len(req.Value) > 0 {
+		if err := decodeValue(req.Value, &r); err != nil {
+			return r, ldapError(ldap.ProtocolError, "invalid password modify request: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// user returns the user whose password r sets, as its user identity
+// names it, or the client's bind DN.
+func (r *passwordModifyRequest) user(c *Conn) (string, error) {
+	user := string(r.UserIdentity)
+	if user == "" {
+		user = c.BindDN()
+	}
+	if c.BindDN() == "" || user == "" {
+		return "", ldapError(ldap.UnwillingToPerform, "password modify requires authentication")
+	}
+	return user, nil
+}
+
+// A UserResolver is a Handler that resolves the user identity of a
+// Password Modify request, such as "u:alice", to the DN of the entry
+// whose password it sets, so that the ACL middleware checks access to
+// that entry. Other handlers take the identity to be a DN, optionally
+// prefixed with "dn:".
+//
+// The middleware of this package passes ResolveUser on to the handlers
+// it wraps.
+type UserResolver interface {
+	ResolveUser(c *Conn, identity string) (string, error)
+}
+
+func resolveUser(h Handler, c *Conn, identity string) (string, error) {
+	if r, ok := h.(UserResolver); ok {
+		return r.ResolveUser(c, identity)
+	}
+	dn := strings.TrimPrefix(identity, "dn:")
+	if _, err := normalizeDN(dn); err != nil || !strings.Contains(dn, "=") {
+		return "", ldapError(ldap.UnwillingToPerform, "user identity %q is not a DN", identity)
+	}
+	return dn, nil
+}
+
+// ResolveUser returns the DN of the entry identity names, as Password
+// finds it.
+func (b *MemoryBackend) ResolveUser(c *Conn, identity string) (string, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	me := b.user(identity)
+	if me == nil {
+		return "", ldapError(ldap.NoSuchObject, "no such user %s", identity)
+	}
+	return me.entry.DN, nil
+}
+
+func (h *interceptor) ResolveUser(c *Conn, identity string) (string, error) {
+	return resolveUser(h.next, c, identity)
+}
+
+func (h *aclHandler) ResolveUser(c *Conn, identity string) (string, error) {
+	return resolveUser(h.Handler, c, identity)
+}
+
+func (h *simpleBindHandler) ResolveUser(c *Conn, identity string) (string, error) {
+	return resolveUser(h.Handler, c, identity)
+}
+
+func (h *saslHandler) ResolveUser(c *Conn, identity string) (string, error) {
+	return resolveUser(h.Handler, c, identity)
+}
+
+// passwordTarget returns the DN of the entry whose password the
+// Password Modify request req sets, as h resolves the client's own or
+// that its user identity names. h, if it sets the password, sets that
+// entry's rather than resolving the identity again.
+func passwordTarget(h Handler, c *Conn, req *ExtendedRequest) (string, error) {
+	r, err := decodePasswordModify(req)
+	if err != nil {
+		return "", err
+	}
+	user, err := r.user(c)
+	if err != nil {
+		return "", err
+	}
+	dn, err := resolveUser(h, c, user)
+	if err != nil {
+		return "", err
+	}
+	req.user = dn
+	return dn, nil
+}
+
+// passwordModify performs the Password Modify request req, returning
+// the DN of the entry whose password it set.
+func (b *MemoryBackend) passwordModify(c *Conn, req *ExtendedRequest) (*ExtendedResponse, string, error) {
+	r, err := decodePasswordModify(req)
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := r.user(c)
+	if err != nil {
+		return nil, "", err
+	}
+	if req.user != "" {
+		user = "dn:" + req.user
+	}
+
+	resp := &ExtendedResponse{}
+	password := string(r.NewPassword)
+	if password == "" {
+		random := make([]byte, 9)
+		if _, err := rand.Read(random); err != nil {
+			return nil, "", err
+		}
+		password = base64.RawURLEncoding.EncodeToString(random)
+		value, err := encodeValue(struct {
+			GenPassword []byte `asn1:"tag:0"`
+		}{[]byte(password)})
+		if err != nil {
+			return nil, "", err
+		}
+		resp.Value = value
+	}
+	hashed, err := ldap.HashPassword(ldap.PasswordSSHA, password)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me := b.user(user)
+	if me == nil {
+		return nil, "", ldapError(ldap.NoSuchObject, "no such user %s", user)
+	}
+	if !b.maySetPassword(c.BindDN(), me) {
+		return nil, "", ldapError(ldap.InsufficientAccessRights, "no access to the password of %q", me.entry.DN)
+	}
+	if len(r.OldPassword) > 0 && !hasPassword(me.entry, string(r.OldPassword)) {
+		return nil, "", &ldap.Error{ResultCode: ldap.InvalidCredentials}
+	}
+	e := copyEntry(me.entry)
+	b.modify(e, ldap.Modification{Operation: ldap.ReplaceValues,
+		Attribute: ldap.Attribute{Type: "userPassword", Values: []string{hashed}}})
+	if err := b.stamp(c, e, false, nil); err != nil {
+		return nil, "", err
+	}
+	if err := b.apply(entryChange{me.name, e}); err != nil {
+		return nil, "", err
+	}
+	return resp, e.DN, nil
+}
+
+// maySetPassword reports whether a client bound as bindDN may set the
+// password of me.
+func (b *MemoryBackend) maySetPassword(bindDN string, me *memoryEntry) bool {
+	if name, err := normalizeDN(bindDN); err == nil && name.String() == me.name.String() {
+		return true
+	}
+	return b.PasswordAdmin != nil && b.PasswordAdmin(bindDN, me.entry.DN)
+}
+
+// Search supports the Simple Paged Results and server side sort
+// controls.
+func (b *MemoryBackend) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	paging, err := req.PagingControl()
+	if err != nil {
+		return err
+	}
+
+	var entries []*ldap.Entry
+	if paging != nil && len(paging.Cookie) > 0 {
+		if entries, err = b.resume(c, paging.Cookie); err != nil {
+			return err
+		}
+	} else {
+		if entries, err = b.search(req); err != nil {
+			return err
+		}
+		if err := b.sort(req, entries); err != nil {
+			return err
+		}
+		for i, e := range entries {
+			entries[i] = selectAttributes(e, req.AttributeList(), req.TypesOnly)
+		}
+	}
+	if paging != nil {
+		entries = b.page(c, req, paging.Size, entries)
+	}
+
+	for _, e := range entries {
+		if err := w.WriteEntry(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sort applies the request's sort control, if any, to entries.
+func (b *MemoryBackend) sort(req *SearchRequest, entries []*ldap.Entry) error {
+	ctl, err := req.SortControl()
+	if err != nil || ctl == nil {
+		return err
+	}
+	resp := &ldap.ControlServerSideSortResponse{}
+	if err := SortEntries(entries, ctl.SortKeys, b.Rules); err != nil {
+		if ctl.Criticality {
+			return ldapError(ldap.UnavailableCriticalExtension, "%v", err)
+		}
+		resp.Result = err.(*ldap.Error).ResultCode
+	}
+	req.ResponseControls = append(req.ResponseControls, resp)
+	return nil
+}
+
+// page returns the first size entries, keeping the rest for the next
+// page under a new cookie. A size of zero ends the paged search.
+func (b *MemoryBackend) page(c *Conn, req *SearchRequest, size int, entries []*ldap.Entry) []*ldap.Entry {
+	resp := &ldap.ControlPaging{Size: len(entries)}
+	req.ResponseControls = append(req.ResponseControls, resp)
+	if size == 0 {
+		return nil
+	}
+	if len(entries) <= size {
+		return entries
+	}
+
+	b.pageMu.Lock()
+	defer b.pageMu.Unlock()
+	if b.pages == nil {
+		b.pages = map[string]*pagedResults{}
+		b.pagedConns = map[*Conn]bool{}
+	}
+	b.lastCookie++
+	cookie := strconv.Itoa(b.lastCookie)
+	b.pages[cookie] = &pagedResults{c, entries[size:]}
+	resp.Cookie = []byte(cookie)
+	if !b.pagedConns[c] {
+		b.pagedConns[c] = true
+		c.OnClose(func() { b.endPaging(c) })
+	}
+	return entries[:size]
+}
+
+// resume returns the entries left by the page whose cookie is given.
+func (b *MemoryBackend) resume(c *Conn, cookie []byte) ([]*ldap.Entry, error) {
+	b.pageMu.Lock()
+	defer b.pageMu.Unlock()
+	p := b.pages[string(cookie)]
+	if p == nil || p.conn != c {
+		return nil, ldapError(ldap.UnwillingToPerform, "invalid paged results cookie")
+	}
+	delete(b.pages, string(cookie))
+	return p.entries, nil
+}
+
+// endPaging drops the paged searches of a closed connection.
+func (b *MemoryBackend) endPaging(c *Conn) {
+	b.pageMu.Lock()
+	defer b.pageMu.Unlock()
+	for cookie, p := range b.pages {
+		if p.conn == c {
+			delete(b.pages, cookie)
+		}
+	}
+	delete(b.pagedConns, c)
+}
+
+// search returns copies of the entries in the scope of req that match
+// its filter, parents first, dereferencing aliases as req asks.
+func (b *MemoryBackend) search(req *SearchRequest) ([]*ldap.Entry, error) {
+	base, err := normalizeDN(req.BaseDN())
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(base) > 0 {
+		me, err := b.lookup(req.BaseDN())
+		if err != nil {
+			return nil, err
+		}
+		if req.Deref == ldap.DerefFindingBaseObj || req.Deref == ldap.DerefAlways {
+			if me, err = b.dereference(me); err != nil {
+				return nil, err
+			}
+			base = me.name
+		}
+	}
+
+	found := map[string]*memoryEntry{}
+	if err := b.searchScope(req, base, req.Scope, found, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	matches := make([]*memoryEntry, 0, len(found))
+	for _, me := range found {
+		matches = append(matches, me)
+	}
+	return sortedEntries(matches), nil
+}
+
+// searchScope adds the entries within scope of base that match the
+// filter of req to found. If req dereferences aliases in searching, an
+// alias below base is searched in its place: the entry it names, and
+// for a subtree search the subtree below that. Aliases already followed
+// are skipped, and so are those that cannot be dereferenced.
+func (b *MemoryBackend) searchScope(req *SearchRequest, base ldap.DN, scope ldap.SearchScope, found map[string]*memoryEntry, followed map[string]bool) error {
+	deref := req.Deref == ldap.DerefInSearching || req.Deref == ldap.DerefAlways
+	var scan []*memoryEntry
+	collect := func(me *memoryEntry) bool {
+		scan = append(scan, me)
+		return true
+	}
+	var err error
+	switch {
+	case scope == ldap.BaseObject:
+		var me *memoryEntry
+		if me, err = b.store.entry(base); me != nil {
+			scan = append(scan, me)
+		}
+	case deref:
+		err = b.store.walk(base, collect)
+	default:
+		err = b.scan(base, ldap.NormalizeFilter(req.Filter), collect)
+	}
+	if err != nil {
+		return err
+	}
+	for _, me := range scan {
+		if !isUnder(me.name, base) {
+			continue
+		}
+		depth := len(me.name) - len(base)
+		switch {
+		case scope == ldap.BaseObject && depth != 0:
+			continue
+		case scope == ldap.SingleLevel && depth != 1:
+			continue
+		}
+		if deref && depth > 0 && isAlias(me.entry) {
+			if followed[me.name.String()] {
+				continue
+			}
+			followed[me.name.String()] = true
+			target, err := b.dereference(me)
+			if err != nil {
+				continue
+			}
+			targetScope := ldap.BaseObject
+			if scope == ldap.WholeSubtree {
+				targetScope = ldap.WholeSubtree
+			}
+			if err := b.searchScope(req, target.name, targetScope, found, followed); err != nil {
+				return err
+			}
+			continue
+		}
+		ok, err := ldap.FilterMatchesWithRules(req.Filter, me.entry, b.Rules)
+		if err != nil {
+			return ldapError(ldap.ProtocolError, "%v", err)
+		}
+		if ok {
+			found[me.name.String()] = me
+		}
+	}
+	return nil
+}
+
+// scan calls fn with the entries named base or below it that may match
+// f, as the indexes tell, or with all of them, until fn returns false.
+// The caller holds b.mu.
+func (b *MemoryBackend) scan(base ldap.DN, f ldap.Filter, fn func(me *memoryEntry) bool) error {
+	names, ok := b.candidates(f)
+	if !ok {
+		return b.store.walk(base, fn)
+	}
+	for key := range names {
+		name := mustParseDN(key)
+		if !isUnder(name, base) {
+			continue
+		}
+		me, err := b.store.entry(name)
+		if err != nil {
+			return err
+		}
+		if me != nil && !fn(me) {
+			break
+		}
+	}
+	return nil
+}
+
+func isAlias(e *ldap.Entry) bool {
+	for _, oc := range e.GetAttributeValues("objectClass") {
+		if strings.EqualFold(oc, "alias") {
+			return true
+		}
+	}
+	return false
+}
+
+// dereference follows me while it is an alias to the entry its
+// aliasedObjectName names. The caller holds b.mu.
+func (b *MemoryBackend) dereference(me *memoryEntry) (*memoryEntry, error) {
+	seen := map[string]bool{}
+	for isAlias(me.entry) {
+		if seen[me.name.String()] {
+			return nil, ldapError(ldap.AliasProblem, "alias loop at %q", me.entry.DN)
+		}
+		seen[me.name.String()] = true
+		names := me.entry.GetAttributeValues("aliasedObjectName")
+		if len(names) != 1 {
+			return nil, ldapError(ldap.AliasDereferencingProblem, "alias %q must have one aliasedObjectName", me.entry.DN)
+		}
+		name, err := normalizeDN(names[0])
+		if err != nil {
+			return nil, ldapError(ldap.AliasDereferencingProblem, "alias %q: %v", me.entry.DN, err)
+		}
+		target, err := b.store.entry(name)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			return nil, ldapError(ldap.AliasProblem, "alias %q names no entry %q", me.entry.DN, names[0])
+		}
+		me = target
+	}
+	return me, nil
+}
+
+// sortedEntries returns copies of the entries of ms, superiors first.
+func sortedEntries(ms []*memoryEntry) []*ldap.Entry {
+	sort.Slice(ms, func(i, j int) bool {
+		if len(ms[i].name) != len(ms[j].name) {
+			return len(ms[i].name) < len(ms[j].name)
+		}
+		return ms[i].name.String() < ms[j].name.String()
+	})
+	entries := make([]*ldap.Entry, len(ms))
+	for i, me := range ms {
+		entries[i] = copyEntry(me.entry)
+	}
+	return entries
+}
+
+// Entries returns copies of all entries, superiors first.
+func (b *MemoryBackend) Entries() []*ldap.Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entries, _ := b.allEntries()
+	return entries
+}
+
+// allEntries returns copies of all entries, superiors first. The caller
+// holds b.mu.
+func (b *MemoryBackend) allEntries() ([]*ldap.Entry, error) {
+	var ms []*memoryEntry
+	err := b.store.walk(ldap.DN{}, func(me *memoryEntry) bool {
+		ms = append(ms, me)
+		return true
+	})
+	return sortedEntries(ms), err
+}
+
+// An IndexType is a set of kinds of index a MemoryBackend keeps of an
+// attribute.
+type IndexType int
+
+const (
+	// IndexEquality finds the entries with a value of the attribute,
+	// for equality filters.
+	IndexEquality IndexType = 1 << iota
+	// IndexPresence finds the entries with the attribute, for presence
+	// filters.
+	IndexPresence
+
+	IndexAll = IndexEquality | IndexPresence
+)
+
+// Index maintains equality and presence indexes of attrs, from which
+// searches find the entries that may match their filters rather than
+// examining every entry.
+func (b *MemoryBackend) Index(attrs ...string) {
+	for _, attr := range attrs {
+		b.IndexAttribute(attr, IndexAll)
+	}
+}
+
+// IndexAttribute maintains the given kinds of index of attr, in
+// addition to any it already has. Each kind costs memory and time on
+// every update, so only those that searches use are worth keeping.
+//
+// A filter is answered from the indexes if it is an equality or
+// presence assertion on an attribute with that kind of index, a
+// conjunction with at least one such subfilter, whose candidates are
+// intersected, or a disjunction of only such subfilters. The long lists
+// of candidates of some subfilters of a conjunction are not read if
+// another subfilter has few.
+//
+// A KVBackend records the kinds of index in its file, and builds them
+// only when they are added.
+func (b *MemoryBackend) IndexAttribute(attr string, types IndexType) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := strings.ToLower(attr)
+	old := b.indexes[key]
+	added := types &^ old
+	if added == 0 {
+		return
+	}
+	indexes := map[string]IndexType{key: old | added}
+	for a, t := range b.indexes {
+		if a != key {
+			indexes[a] = t
+		}
+	}
+	u := &storeUpdate{postings: map[posting]bool{}, indexes: indexes}
+	err := b.store.walk(ldap.DN{}, func(me *memoryEntry) bool {
+		for _, term := range b.terms(me.entry, key, added) {
+			u.postings[posting{term, me.name.String()}] = true
+		}
+		return true
+	})
+	if err == nil {
+		err = b.store.write(u)
+	}
+	if err == nil {
+		b.indexes = indexes
+	}
+}
+
+// terms returns the index terms of the given kinds under which e is
+// posted for attr, from its values of attr and its subtypes.
+func (b *MemoryBackend) terms(e *ldap.Entry, attr string, types IndexType) []string {
+	rule := b.rule(attr)
+	var terms []string
+	for _, a := range e.Attributes {
+		name := a.Name
+		if i := strings.IndexByte(name, ';'); i >= 0 {
+			name = name[:i]
+		}
+		if !strings.EqualFold(name, attr) {
+			continue
+		}
+		if types&IndexPresence != 0 {
+			terms = append(terms, presenceTerm(attr))
+		}
+		if types&IndexEquality == 0 {
+			continue
+		}
+		for _, v := range a.Values {
+			if n, err := rule.Normalize(v); err == nil {
+				terms = append(terms, equalityTerm(attr, n))
+			}
+		}
+	}
+	return terms
+}
+
+// fewCandidates bounds the candidates read for the indexed subfilters
+// of a conjunction while another has no more: reading a long posting
+// list, as from a KVBackend's file, costs more than intersecting with it
+// saves.
+const fewCandidates = 1000
+
+// candidates returns the normalized names of the entries that may match
+// f, if the indexes can tell. The caller holds b.mu.
+func (b *MemoryBackend) candidates(f ldap.Filter) (map[string]bool, bool) {
+	return b.candidatesUpTo(f, -1)
+}
+
+// candidatesUpTo is like candidates, but reports that the indexes
+// cannot tell if there are more than limit candidates, unless limit is
+// negative.
+func (b *MemoryBackend) candidatesUpTo(f ldap.Filter, limit int) (map[string]bool, bool) {
+	if attr, value, ok := ldap.EqualityAssertion(f); ok {
+		key := strings.ToLower(attr)
+		if b.indexes[key]&IndexEquality == 0 {
+			return nil, false
+		}
+		n, err := b.rule(attr).Normalize(value)
+		if err != nil {
+			return nil, false
+		}
+		return b.posted(equalityTerm(key, n), limit)
+	}
+	if attr, ok := ldap.PresenceAssertion(f); ok {
+		key := strings.ToLower(attr)
+		if b.indexes[key]&IndexPresence == 0 {
+			return nil, false
+		}
+		return b.posted(presenceTerm(key), limit)
+	}
+	if filters, ok := ldap.AndFilters(f); ok {
+		// Intersect the candidates of the indexed subfilters, smallest
+		// first, reading long lists only if no subfilter has few.
+		few := fewCandidates
+		if limit >= 0 && limit < few {
+			few = limit
+		}
+		sets := b.candidateSets(filters, few)
+		if len(sets) == 0 && few != limit {
+			sets = b.candidateSets(filters, limit)
+		}
+		if len(sets) == 0 {
+			return nil, false
+		}
+		sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+		if len(sets) == 1 {
+			return sets[0], true
+		}
+		result := map[string]bool{}
+		for name := range sets[0] {
+			in := true
+			for _, c := range sets[1:] {
+				if in = c[name]; !in {
+					break
+				}
+			}
+			if in {
+				result[name] = true
+			}
+		}
+		return result, true
+	}
+	if filters, ok := ldap.OrFilters(f); ok && len(filters) > 0 {
+		union := map[string]bool{}
+		for _, sub := range filters {
+			c, ok := b.candidatesUpTo(sub, limit)
+			if !ok {
+				return nil, false
+			}
+			for name := range c {
+				union[name] = true
+			}
+			if limit >= 0 && len(union) > limit {
+				return nil, false
+			}
+		}
+		return union, true
+	}
+	return nil, false
+}
+
+// candidateSets returns the candidates of those of filters the indexes
+// answer with at most limit.
+func (b *MemoryBackend) candidateSets(filters []ldap.Filter, limit int) []map[string]bool {
+	var sets []map[string]bool
+	for _, sub := range filters {
+		if c, ok := b.candidatesUpTo(sub, limit); ok {
+			sets = append(sets, c)
+		}
+	}
+	return sets
+}
+
+// posted returns the names posted under term, if there are at most
+// limit, unless limit is negative.
+func (b *MemoryBackend) posted(term string, limit int) (map[string]bool, bool) {
+	names, complete, err := b.store.posted(term, limit)
+	return names, complete && err == nil
+}
+
+// selectAttributes returns a copy of e with the attributes a search asked
+// for: all user attributes for an empty list or "*", all operational
+// ones for "+", none for "1.1".
+func selectAttributes(e *ldap.Entry, attrs []string, typesOnly bool) *ldap.Entry {
+	all, operational := len(attrs) == 0, false
+	for _, a := range attrs {
+		switch a {
+		case "*":
+			all = true
+		case "+":
+			operational = true
+		}
+	}
+
+	c := &ldap.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		user := !isOperational(a.Name)
+		if !requested(a.Name, attrs) && !(user && all) && !(!user && operational) {
+			continue
+		}
+		if typesOnly {
+			c.Attributes = append(c.Attributes, &ldap.EntryAttribute{Name: a.Name})
+		} else {
+			c.Attributes = append(c.Attributes, ldap.NewEntryAttribute(a.Name, append([]string(nil), a.Values...)))
+		}
+	}
+	return c
+}
+
+// requested reports whether the attribute name is in attrs. A name with
+// options, like "cn;lang-en", is also requested by its base type.
+func requested(name string, attrs []string) bool {
+	base := name
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		base = name[:i]
+	}
+	for _, a := range attrs {
+		if strings.EqualFold(a, name) || strings.EqualFold(a, base) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *MemoryBackend) Add(c *Conn, req *AddRequest) error {
+	name, err := normalizeDN(req.DN)
+	if err != nil {
+		return err
+	}
+	if len(name) == 0 {
+		return ldapError(ldap.UnwillingToPerform, "cannot add the root DSE")
+	}
+	e := &ldap.Entry{DN: req.DN}
+	var given []string
+	for _, a := range req.Attributes {
+		if err := userModifiable(c, a.Type); err != nil {
+			return err
+		}
+		given = append(given, a.Type)
+		for _, v := range a.Values {
+			if err := b.addValue(e, a.Type, v, false); err != nil {
+				return err
+			}
+		}
+	}
+	// Clients should include the values of the RDN, but add them if
+	// they did not.
+	for _, atv := range mustParseDN(req.DN)[0] {
+		b.addValue(e, atv.Type, atv.Value, true)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if me, err := b.store.entry(name); err != nil {
+		return err
+	} else if me != nil {
+		return ldapError(ldap.EntryAlreadyExists, "entry %q already exists", req.DN)
+	}
+	if len(name) > 1 {
+		if parent, err := b.store.entry(name[1:]); err != nil {
+			return err
+		} else if parent == nil {
+			return b.noSuchObject(name, req.DN)
+		}
+	}
+	if err := b.stamp(c, e, true, given); err != nil {
+		return err
+	}
+	return b.apply(entryChange{name, e})
+}
+
+// mustParseDN parses a DN that normalizeDN has already accepted.
+func mustParseDN(dn string) ldap.DN {
+	name, _ := ldap.ParseDN(dn)
+	return name
+}
+
+// addValue adds v to the attribute attr of e, failing if it is already
+// there unless ignoreExisting is set.
+func (b *MemoryBackend) addValue(e *ldap.Entry, attr, v string, ignoreExisting bool) error {
+	a := e.GetAttribute(attr)
+	if a == nil {
+		e.Attributes = append(e.Attributes, ldap.NewEntryAttribute(attr, []string{v}))
+		return nil
+	}
+	if b.indexOf(attr, a.Values, v) >= 0 {
+		if ignoreExisting {
+			return nil
+		}
+		return ldapError(ldap.AttributeOrValueExists, "%s: value %q already exists", attr, v)
+	}
+	*a = *ldap.NewEntryAttribute(a.Name, append(a.Values, v))
+	return nil
+}
+
+func attributeNames(e *ldap.Entry) []string {
+	names := make([]string, len(e.Attributes))
+	for i, a := range e.Attributes {
+		names[i] = a.Name
+	}
+	return names
+}
+
+func removeAttribute(e *ldap.Entry, attr string) {
+	for i, a := range e.Attributes {
+		if strings.EqualFold(a.Name, attr) {
+			e.Attributes = append(e.Attributes[:i], e.Attributes[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *MemoryBackend) Modify(c *Conn, req *ModifyRequest) error {
+	var given []string
+	for _, mod := range req.Modifications {
+		if err := userModifiable(c, mod.Type); err != nil {
+			return err
+		}
+		given = append(given, mod.Type)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me, err := b.lookup(req.DN)
+	if err != nil {
+		return err
+	}
+
+	e := copyEntry(me.entry)
+	for _, mod := range req.Modifications {
+		if err := b.modify(e, mod); err != nil {
+			return err
+		}
+	}
+	for _, atv := range mustParseDN(e.DN)[0] {
+		if b.indexOf(atv.Type, e.GetAttributeValues(atv.Type), atv.Value) < 0 {
+			return ldapError(ldap.NotAllowedOnRDN, "cannot remove RDN value %s=%s", atv.Type, atv.Value)
+		}
+	}
+	if err := b.stamp(c, e, false, given); err != nil {
+		return err
+	}
+	return b.apply(entryChange{me.name, e})
+}
+
+func (b *MemoryBackend) modify(e *ldap.Entry, mod ldap.Modification) error {
+	a := e.GetAttribute(mod.Type)
+	switch mod.Operation {
+	case ldap.AddValues:
+		for _, v := range mod.Values {
+			if err := b.addValue(e, mod.Type, v, false); err != nil {
+				return err
+			}
+		}
+
+	case ldap.DeleteValues:
+		if a == nil {
+			return ldapError(ldap.NoSuchAttribute, "no attribute %s", mod.Type)
+		}
+		if len(mod.Values) == 0 {
+			removeAttribute(e, mod.Type)
+			return nil
+		}
+		values := append([]string(nil), a.Values...)
+		for _, v := range mod.Values {
+			i := b.indexOf(mod.Type, values, v)
+			if i < 0 {
+				return ldapError(ldap.NoSuchAttribute, "%s: no value %q", mod.Type, v)
+			}
+			values = append(values[:i], values[i+1:]...)
+		}
+		if len(values) == 0 {
+			removeAttribute(e, mod.Type)
+		} else {
+			*a = *ldap.NewEntryAttribute(a.Name, values)
+		}
+
+	case ldap.ReplaceValues:
+		removeAttribute(e, mod.Type)
+		for _, v := range mod.Values {
+			if err := b.addValue(e, mod.Type, v, false); err != nil {
+				return err
+			}
+		}
+
+	case ldap.IncrementValue:
+		if a == nil {
+			return ldapError(ldap.NoSuchAttribute, "no attribute %s", mod.Type)
+		}
+		if len(mod.Values) != 1 {
+			return ldapError(ldap.ProtocolError, "%s: increment needs one value", mod.Type)
+		}
+		delta, err := ldap.ParseInteger(mod.Values[0])
+		if err != nil {
+			return ldapError(ldap.InvalidAttributeSyntax, "%s: %v", mod.Type, err)
+		}
+		values := make([]string, len(a.Values))
+		for i, v := range a.Values {
+			n, err := ldap.ParseInteger(v)
+			if err != nil {
+				return ldapError(ldap.ConstraintViolation, "%s: cannot increment %q", mod.Type, v)
+			}
+			values[i] = strconv.FormatInt(n+delta, 10)
+		}
+		*a = *ldap.NewEntryAttribute(a.Name, values)
+
+	default:
+		return ldapError(ldap.ProtocolError, "unknown modify operation %d", mod.Operation)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Delete(c *Conn, req *DeleteRequest) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me, err := b.lookup(req.DN)
+	if err != nil {
+		return err
+	}
+	if children, err := b.hasChildren(me.name); err != nil {
+		return err
+	} else if children {
+		return ldapError(ldap.NotAllowedOnNonLeaf, "entry %q has subordinates", req.DN)
+	}
+	return b.apply(entryChange{me.name, nil})
+}
+
+func (b *MemoryBackend) ModifyDN(c *Conn, req *ModifyDNRequest) error {
+	_, err := b.rename(c, req)
+	return err
+}
+
+// rename performs a ModifyDN request, returning the new DN.
+func (b *MemoryBackend) rename(c *Conn, req *ModifyDNRequest) (string, error) {
+	newRDN, err := ldap.ParseDN(req.NewRDN)
+	if err != nil || len(newRDN) != 1 {
+		return "", ldapError(ldap.InvalidDNSyntax, "invalid RDN %q", req.NewRDN)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	me, err := b.lookup(req.DN)
+	if err != nil {
+		return "", err
+	}
+
+	// The new DN keeps the spelling of the superior it is given.
+	superior := mustParseDN(me.entry.DN)[1:]
+	if req.NewSuperior != "" {
+		sup, err := b.lookup(req.NewSuperior)
+		if err != nil {
+			return "", err
+		}
+		if isUnder(sup.name, me.name) {
+			return "", ldapError(ldap.UnwillingToPerform, "cannot move %q under itself", req.DN)
+		}
+		superior = mustParseDN(sup.entry.DN)
+	}
+	newDN := append(ldap.DN{newRDN[0]}, superior...).String()
+	newName, err := normalizeDN(newDN)
+	if err != nil {
+		return "", err
+	}
+	if other, err := b.store.entry(newName); err != nil {
+		return "", err
+	} else if other != nil && other.name.String() != me.name.String() {
+		return "", ldapError(ldap.EntryAlreadyExists, "entry %q already exists", newDN)
+	}
+
+	e := copyEntry(me.entry)
+	if req.DeleteOldRDN {
+		for _, atv := range mustParseDN(me.entry.DN)[0] {
+			if b.indexOf(atv.Type, rdnValues(newRDN[0], atv.Type), atv.Value) >= 0 {
+				continue
+			}
+			b.modify(e, ldap.Modification{Operation: ldap.DeleteValues,
+				Attribute: ldap.Attribute{Type: atv.Type, Values: []string{atv.Value}}})
+		}
+	}
+	for _, atv := range newRDN[0] {
+		b.addValue(e, atv.Type, atv.Value, true)
+	}
+	if err := b.stamp(c, e, false, nil); err != nil {
+		return "", err
+	}
+
+	// Rename the entry and its subtree, deleting the old names first.
+	e.DN = newDN
+	var subtree []*memoryEntry
+	if err := b.store.walk(me.name, func(sub *memoryEntry) bool {
+		subtree = append(subtree, sub)
+		return true
+	}); err != nil {
+		return "", err
+	}
+	var deleted, added []entryChange
+	for _, sub := range subtree {
+		depth := len(sub.name) - len(me.name)
+		moved := e
+		if depth > 0 {
+			moved = copyEntry(sub.entry)
+			moved.DN = append(mustParseDN(sub.entry.DN)[:depth], mustParseDN(newDN)...).String()
+		}
+		deleted = append(deleted, entryChange{sub.name, nil})
+		added = append(added, entryChange{append(append(ldap.DN{}, sub.name[:depth]...), newName...), moved})
+	}
+	if err := b.apply(append(deleted, added...)...); err != nil {
+		return "", err
+	}
+	return newDN, nil
+}
+
+func rdnValues(rdn ldap.RDN, attr string) []string {
+	var values []string
+	for _, atv := range rdn {
+		if strings.EqualFold(atv.Type, attr) {
+			values = append(values, atv.Value)
+		}
+	}
+	return values
+}
+
+func (b *MemoryBackend) Compare(c *Conn, req *CompareRequest) (bool, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	me, err := b.lookup(req.DN)
+	if err != nil {
+		return false, err
+	}
+	a := me.entry.GetAttribute(req.Attribute)
+	if a == nil {
+		return false, ldapError(ldap.NoSuchAttribute, "no attribute %s", req.Attribute)
+	}
+	return b.indexOf(req.Attribute, a.Values, req.Value) >= 0, nil
+}
+
+// IsMember reports whether dn is listed in the member or uniqueMember
+// attribute of the entry group, for use as ACL.IsMember.
+func (b *MemoryBackend) IsMember(group, dn string) bool {
+	name, err := normalizeDN(dn)
+	if err != nil {
+		return false
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	me, err := b.lookup(group)
+	if err != nil {
+		return false
+	}
+	for _, attr := range []string{"member", "uniqueMember"} {
+		for _, v := range me.entry.GetAttributeValues(attr) {
+			if m, err := normalizeDN(v); err == nil && m.String() == name.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,639 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestBackend(t *testing.T) *MemoryBackend {
+	b := NewMemoryBackend()
+	entries := []*ldap.Entry{
+		ldap.NewEntry("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}, "dc": {"example"}}),
+		ldap.NewEntry("ou=People,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"People"}}),
+		ldap.NewEntry("cn=Alice,ou=People,dc=example,dc=com", map[string][]string{
+			"objectClass": {"person"}, "cn": {"Alice"}, "sn": {"Smith"}, "userPassword": {"secret"}}),
+		ldap.NewEntry("cn=Bob,ou=People,dc=example,dc=com", map[string][]string{
+			"objectClass": {"person"}, "cn": {"Bob"}, "sn": {"Jones"}, "uidNumber": {"1000"}}),
+	}
+	for _, e := range entries {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+// userAttributes returns the attribute map of e without the operational
+// attributes the backend maintains.
+func userAttributes(e *ldap.Entry) map[string][]string {
+	m := e.AttributeMap()
+	for _, a := range operationalAttributes {
+		delete(m, strings.ToLower(a))
+	}
+	return m
+}
+
+func entryDNs(entries []*ldap.Entry) []string {
+	dns := []string{}
+	for _, e := range entries {
+		dns = append(dns, e.DN)
+	}
+	return dns
+}
+
+func TestMemoryBackendSearch(t *testing.T) {
+	c, stop := startTestServer(t, newTestBackend(t))
+	defer stop()
+
+	tests := []struct {
+		base   string
+		scope  ldap.SearchScope
+		filter string
+		dns    []string
+	}{
+		{"dc=example,dc=com", ldap.BaseObject, "(objectClass=*)", []string{"dc=example,dc=com"}},
+		{"ou=people,DC=Example,dc=com", ldap.SingleLevel, "(objectClass=*)",
+			[]string{"cn=Alice,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.WholeSubtree, "(objectClass=person)",
+			[]string{"cn=Alice,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"}},
+		{"dc=example,dc=com", ldap.WholeSubtree, "(|(sn=smith)(ou=*))",
+			[]string{"ou=People,dc=example,dc=com", "cn=Alice,ou=People,dc=example,dc=com"}},
+		{"", ldap.WholeSubtree, "(sn<=k)", []string{"cn=Bob,ou=People,dc=example,dc=com"}},
+	}
+	for i, test := range tests {
+		f, err := ldap.CompileFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := c.Search(ldap.SearchRequest{BaseObject: []byte(test.base), Scope: test.scope, Filter: f})
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		dns := []string{}
+		for _, r := range results {
+			dns = append(dns, r.DN)
+		}
+		if !reflect.DeepEqual(dns, test.dns) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, dns, test.dns)
+		}
+	}
+
+	results, err := c.Search(ldap.SearchRequest{
+		BaseObject: []byte("cn=alice,ou=people,dc=example,dc=com"),
+		Filter:     ldap.Present("objectClass"),
+		Attributes: [][]byte{[]byte("SN"), []byte("mail")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ldap.SearchResult{{DN: "cn=Alice,ou=People,dc=example,dc=com", Attributes: map[string][]string{"sn": {"Smith"}}}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Bad result: %v (expected %v)", results, expected)
+	}
+
+	_, err = c.Search(ldap.SearchRequest{BaseObject: []byte("cn=Carol,ou=People,dc=example,dc=com"), Filter: ldap.Present("objectClass")})
+	if e, ok := err.(*ldap.Error); !ok || e.ResultCode != ldap.NoSuchObject || e.MatchedDN != "ou=People,dc=example,dc=com" {
+		t.Errorf("Bad search result: %#v (expected noSuchObject matching ou=People)", err)
+	}
+}
+
+func TestMemoryBackendBind(t *testing.T) {
+	b := newTestBackend(t)
+	b.AddEntry(ldap.NewEntry("cn=Dave,ou=People,dc=example,dc=com", map[string][]string{
+		"objectClass": {"person"}, "cn": {"Dave"}, "userPassword": {"{SSHA}NGAMOZHhQA/L7qBLdB7d3v8jcA/HG8OGfpdiIw=="}}))
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	tests := []struct {
+		dn, password string
+		code         ldap.ResultCode
+	}{
+		{"", "", ldap.Success},
+		{"cn=alice,ou=people,dc=example,dc=com", "secret", ldap.Success},
+		{"cn=Alice,ou=People,dc=example,dc=com", "wrong", ldap.InvalidCredentials},
+		{"cn=Bob,ou=People,dc=example,dc=com", "secret", ldap.InvalidCredentials},
+		{"cn=Carol,ou=People,dc=example,dc=com", "secret", ldap.InvalidCredentials},
+		{"cn=Alice,ou=People,dc=example,dc=com", "", ldap.UnwillingToPerform},
+		{"cn=Dave,ou=People,dc=example,dc=com", "secret", ldap.Success},
+		{"cn=Dave,ou=People,dc=example,dc=com", "{SSHA}NGAMOZHhQA/L7qBLdB7d3v8jcA/HG8OGfpdiIw==", ldap.InvalidCredentials},
+	}
+	for i, test := range tests {
+		code := ldap.Success
+		if err := c.Bind(test.dn, test.password); err != nil {
+			code = resultCode(err)
+		}
+		if code != test.code {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, code, test.code)
+		}
+	}
+}
+
+func TestMemoryBackendPasswordModify(t *testing.T) {
+	b := newTestBackend(t)
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	const alice, bob = "cn=Alice,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"
+	if _, err := c.PasswordModify("", "", "new"); resultCode(err) != ldap.UnwillingToPerform {
+		t.Errorf("Bad anonymous result: %v (expected %v)", err, ldap.UnwillingToPerform)
+	}
+	if err := c.Bind(alice, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "dn:"+alice {
+		t.Errorf("Bad WhoAmI result: %q, %v", id, err)
+	}
+	if _, err := c.PasswordModify("", "wrong", "new"); resultCode(err) != ldap.InvalidCredentials {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.InvalidCredentials)
+	}
+	if gen, err := c.PasswordModify("", "secret", "new"); err != nil || gen != "" {
+		t.Errorf("Bad result: %q, %v", gen, err)
+	}
+	if err := c.Bind(alice, "new"); err != nil {
+		t.Errorf("Bind with new password: %v", err)
+	}
+	gen, err := c.PasswordModify("dn:"+alice, "", "")
+	if err != nil || gen == "" {
+		t.Fatalf("Bad generated password: %q, %v", gen, err)
+	}
+	if err := c.Bind(alice, gen); err != nil {
+		t.Errorf("Bind with generated password: %v", err)
+	}
+	if pw := b.Entry(alice).GetAttributeValues("userPassword"); len(pw) != 1 || pw[0][:6] != "{SSHA}" {
+		t.Errorf("Bad stored password: %v", pw)
+	}
+	if _, err := c.PasswordModify("dn:"+bob, "", "new"); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+
+	// PasswordAdmin may let a client set the passwords of others.
+	b = newTestBackend(t)
+	b.PasswordAdmin = func(bindDN, dn string) bool { return bindDN == alice }
+	c, stop = startTestServer(t, b)
+	defer stop()
+	if err := c.Bind(alice, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.PasswordModify("dn:"+bob, "", "new"); err != nil {
+		t.Errorf("Bad admin result: %v", err)
+	}
+	if err := c.Bind(bob, "new"); err != nil {
+		t.Errorf("Bind with new password: %v", err)
+	}
+}
+
+func TestMemoryBackendUpdate(t *testing.T) {
+	b := newTestBackend(t)
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	const people = "ou=People,dc=example,dc=com"
+	err := c.Add("cn=Carol,ou=Nowhere,dc=example,dc=com", []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}})
+	if resultCode(err) != ldap.NoSuchObject {
+		t.Errorf("Bad add result: %v (expected %v)", err, ldap.NoSuchObject)
+	}
+	err = c.Add("cn=Carol,"+people, []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := b.Entry("cn=carol," + people).GetAttributeValues("cn"); !reflect.DeepEqual(cn, []string{"Carol"}) {
+		t.Errorf("Bad RDN value: %v (expected [Carol])", cn)
+	}
+	if err := c.Add("CN=carol,"+people, nil); resultCode(err) != ldap.EntryAlreadyExists {
+		t.Errorf("Bad add result: %v (expected %v)", err, ldap.EntryAlreadyExists)
+	}
+
+	mods := []struct {
+		mod  ldap.Modification
+		code ldap.ResultCode
+	}{
+		{ldap.Modification{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "mail", Values: []string{"bob@example.com"}}}, ldap.Success},
+		{ldap.Modification{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "mail", Values: []string{"BOB@example.com"}}}, ldap.AttributeOrValueExists},
+		{ldap.Modification{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Smith"}}}, ldap.NoSuchAttribute},
+		{ldap.Modification{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"jones"}}}, ldap.Success},
+		{ldap.Modification{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Builder"}}}, ldap.Success},
+		{ldap.Modification{Operation: ldap.IncrementValue, Attribute: ldap.Attribute{Type: "uidNumber", Values: []string{"5"}}}, ldap.Success},
+		{ldap.Modification{Operation: ldap.DeleteValues, Attribute: ldap.Attribute{Type: "cn"}}, ldap.NotAllowedOnRDN},
+	}
+	for i, test := range mods {
+		code := ldap.Success
+		if err := c.Modify("cn=Bob,"+people, []ldap.Modification{test.mod}); err != nil {
+			code = resultCode(err)
+		}
+		if code != test.code {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, code, test.code)
+		}
+	}
+	bob := userAttributes(b.Entry("cn=Bob," + people))
+	expected := map[string][]string{
+		"objectclass": {"person"}, "cn": {"Bob"}, "mail": {"bob@example.com"}, "sn": {"Builder"}, "uidnumber": {"1005"},
+	}
+	if !reflect.DeepEqual(bob, expected) {
+		t.Errorf("Bad entry: %v (expected %v)", bob, expected)
+	}
+
+	if ok, err := c.Compare("cn=Bob,"+people, "sn", "builder"); err != nil || !ok {
+		t.Errorf("Bad compare result: %v, %v (expected true)", ok, err)
+	}
+	if _, err := c.Compare("cn=Bob,"+people, "title", "x"); resultCode(err) != ldap.NoSuchAttribute {
+		t.Errorf("Bad compare result: %v (expected %v)", err, ldap.NoSuchAttribute)
+	}
+
+	if err := c.Del(people); resultCode(err) != ldap.NotAllowedOnNonLeaf {
+		t.Errorf("Bad delete result: %v (expected %v)", err, ldap.NotAllowedOnNonLeaf)
+	}
+	if err := c.Del("cn=Carol," + people); err != nil {
+		t.Fatal(err)
+	}
+	if b.Entry("cn=Carol,"+people) != nil {
+		t.Errorf("Entry not deleted")
+	}
+}
+
+func TestMemoryBackendOperationalAttributes(t *testing.T) {
+	const alice, carol = "cn=Alice,ou=People,dc=example,dc=com", "cn=Carol,ou=People,dc=example,dc=com"
+	c, stop := startTestServer(t, newTestBackend(t))
+	defer stop()
+	if err := c.Bind(alice, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Add(carol, []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}, {Type: "entryUUID", Values: []string{"x"}}})
+	if resultCode(err) != ldap.ConstraintViolation {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.ConstraintViolation)
+	}
+	if err := c.Add(carol, []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(attrs ...string) map[string][]string {
+		e, err := c.GetEntry(carol, attrs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.AttributeMap()
+	}
+	if e := get(); !reflect.DeepEqual(e, map[string][]string{"objectclass": {"person"}, "cn": {"Carol"}}) {
+		t.Errorf("Bad entry: %v (expected user attributes only)", e)
+	}
+	if e := get("modifyTimestamp"); len(e) != 1 || e["modifytimestamp"] == nil {
+		t.Errorf("Bad entry: %v (expected modifyTimestamp only)", e)
+	}
+	created := get("*", "+")
+	if len(created) != 8 || created["creatorsname"][0] != alice || created["modifiersname"][0] != alice {
+		t.Errorf("Bad entry: %v (expected all attributes)", created)
+	}
+	if _, err := ldap.ParseGeneralizedTime(created["createtimestamp"][0]); err != nil {
+		t.Errorf("Bad createTimestamp: %v", err)
+	}
+
+	mods := []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "modifiersName", Values: []string{"cn=x"}}}}
+	if err := c.Modify(carol, mods); resultCode(err) != ldap.ConstraintViolation {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.ConstraintViolation)
+	}
+	mods = []ldap.Modification{{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"White"}}}}
+	if err := c.Modify(carol, mods); err != nil {
+		t.Fatal(err)
+	}
+	modified := get("+")
+	if modified["entryuuid"][0] != created["entryuuid"][0] || modified["entrycsn"][0] <= created["entrycsn"][0] {
+		t.Errorf("Bad entry: %v (expected the same entryUUID and a later entryCSN than %v)", modified, created)
+	}
+}
+
+func TestMemoryBackendModifyDN(t *testing.T) {
+	b := newTestBackend(t)
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	if err := c.ModifyDN("cn=Alice,ou=People,dc=example,dc=com", "cn=Alicia", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	alicia := b.Entry("cn=Alicia,ou=People,dc=example,dc=com")
+	if alicia == nil || b.Entry("cn=Alice,ou=People,dc=example,dc=com") != nil {
+		t.Fatalf("Entry not renamed")
+	}
+	if cn := alicia.GetAttributeValues("cn"); !reflect.DeepEqual(cn, []string{"Alicia"}) {
+		t.Errorf("Bad cn: %v (expected [Alicia])", cn)
+	}
+
+	err := b.AddEntry(ldap.NewEntry("ou=Staff,dc=example,dc=com", map[string][]string{"ou": {"Staff"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ModifyDN("ou=People,dc=example,dc=com", "ou=Users", false, "ou=Staff,dc=example,dc=com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, dn := range []string{
+		"ou=Users,ou=Staff,dc=example,dc=com",
+		"cn=Alicia,ou=Users,ou=Staff,dc=example,dc=com",
+		"cn=Bob,ou=Users,ou=Staff,dc=example,dc=com",
+	} {
+		if e := b.Entry(dn); e == nil || e.DN != dn {
+			t.Errorf("Bad entry for %s: %v", dn, e)
+		}
+	}
+	if ou := b.Entry("ou=Users,ou=Staff,dc=example,dc=com").GetAttributeValues("ou"); !reflect.DeepEqual(ou, []string{"People", "Users"}) {
+		t.Errorf("Bad ou: %v (expected [People Users])", ou)
+	}
+
+	err = c.ModifyDN("ou=Staff,dc=example,dc=com", "ou=Staff", false, "ou=Users,ou=Staff,dc=example,dc=com")
+	if resultCode(err) != ldap.UnwillingToPerform {
+		t.Errorf("Bad modify DN result: %v (expected %v)", err, ldap.UnwillingToPerform)
+	}
+	err = c.ModifyDN("cn=Bob,ou=Users,ou=Staff,dc=example,dc=com", "cn=Alicia", false, "")
+	if resultCode(err) != ldap.EntryAlreadyExists {
+		t.Errorf("Bad modify DN result: %v (expected %v)", err, ldap.EntryAlreadyExists)
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	const bob = "cn=Bob,ou=People,dc=example,dc=com"
+	plain, indexed, partial := newTestBackend(t), newTestBackend(t), newTestBackend(t)
+	indexed.Index("sn", "objectClass", "uidNumber")
+	partial.IndexAttribute("sn", IndexEquality)
+	partial.IndexAttribute("uidNumber", IndexPresence)
+	update := func(b *MemoryBackend) {
+		mods := []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Builder"}}}}
+		if err := b.Modify(nil, &ModifyRequest{DN: bob, Modifications: mods}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.ModifyDN(nil, &ModifyDNRequest{DN: bob, NewRDN: "cn=Robert", DeleteOldRDN: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete(nil, &DeleteRequest{DN: "cn=Alice,ou=People,dc=example,dc=com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filters := []string{
+		"(sn=smith)", "(sn=jones)", "(sn=BUILDER)", "(uidNumber=*)", "(objectClass=person)",
+		"(&(objectClass=person)(cn=r*))", "(|(sn=smith)(sn=jones))", "(|(sn=jones)(cn=alice))", "(cn=robert)",
+		"(&(objectClass=person)(sn=builder)(uidNumber=*))", "(&(sn=*)(uidNumber=1005))", "(!(sn=smith))",
+	}
+	check := func(stage string) {
+		for i, s := range filters {
+			f, err := ldap.CompileFilter(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := &SearchRequest{SearchRequest: ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: f}}
+			expected, err := plain.search(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range []*MemoryBackend{indexed, partial} {
+				result, err := b.search(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(entryDNs(result), entryDNs(expected)) {
+					t.Errorf("%s #%d: Bad result: %v (expected %v)", stage, i, entryDNs(result), entryDNs(expected))
+				}
+			}
+		}
+	}
+	check("before")
+	update(plain)
+	update(indexed)
+	update(partial)
+	check("after")
+
+	// The indexes answer only the assertions of their kinds.
+	for i, test := range []struct {
+		filter  string
+		indexed bool
+		count   int
+	}{
+		{"(sn=builder)", true, 1},
+		{"(sn=*)", false, 0},
+		{"(uidNumber=*)", true, 1},
+		{"(uidNumber=1005)", false, 0},
+		{"(&(sn=builder)(uidNumber=*)(cn=x))", true, 1},
+		{"(&(sn=jones)(uidNumber=*))", true, 0},
+		{"(|(sn=builder)(uidNumber=1005))", false, 0},
+		{"(!(!(sn=builder)))", true, 1},
+		{"(&(objectClass=*)(|(sn=builder)(sn=builder)))", true, 1},
+	} {
+		f, err := ldap.CompileFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// As searches plan them.
+		c, ok := partial.candidates(ldap.NormalizeFilter(f))
+		if ok != test.indexed || len(c) != test.count {
+			t.Errorf("#%d: Bad result: %v, %d (expected %v, %d)", i, ok, len(c), test.indexed, test.count)
+		}
+	}
+}
+
+// postingCounter counts the postings the searches of a backend read.
+type postingCounter struct {
+	entryStore
+	read int
+}
+
+func (s *postingCounter) posted(term string, limit int) (map[string]bool, bool, error) {
+	names, complete, err := s.entryStore.posted(term, limit)
+	if complete {
+		s.read += len(names)
+	} else {
+		s.read += limit + 1
+	}
+	return names, complete, err
+}
+
+func TestMemoryIndexConjunction(t *testing.T) {
+	const base = "ou=People,dc=example,dc=com"
+	b := NewMemoryBackend()
+	b.Index("objectClass", "uid")
+	b.AddEntry(ldap.NewEntry(base, map[string][]string{"objectClass": {"organizationalUnit"}}))
+	for i := 0; i < 2*fewCandidates; i++ {
+		uid := fmt.Sprintf("user%d", i)
+		b.AddEntry(ldap.NewEntry("uid="+uid+","+base, map[string][]string{"objectClass": {"person"}, "uid": {uid}}))
+	}
+	counter := &postingCounter{entryStore: b.store}
+	b.store = counter
+	for i, test := range []struct {
+		filter      string
+		count, read int
+	}{
+		{"(objectClass=person)", 2 * fewCandidates, 2 * fewCandidates},
+		// The list of persons is not read in full.
+		{"(&(objectClass=person)(uid=user7))", 1, fewCandidates + 2},
+		{"(&(objectClass=person)(cn=x))", 2 * fewCandidates, 3*fewCandidates + 1},
+	} {
+		f, err := ldap.CompileFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counter.read = 0
+		c, ok := b.candidates(ldap.NormalizeFilter(f))
+		if !ok || len(c) != test.count || counter.read != test.read {
+			t.Errorf("#%d: Bad result: %v, %d candidates, %d read (expected %d, %d)", i, ok, len(c), counter.read, test.count, test.read)
+		}
+	}
+}
+
+func BenchmarkMemoryIndex(b *testing.B) {
+	const base = "ou=People,dc=example,dc=com"
+	mem := NewMemoryBackend()
+	mem.Index("uid")
+	mem.AddEntry(ldap.NewEntry(base, map[string][]string{"objectClass": {"organizationalUnit"}}))
+	for i := 0; i < 200000; i++ {
+		uid := fmt.Sprintf("user%d", i)
+		mem.AddEntry(ldap.NewEntry("uid="+uid+","+base, map[string][]string{"objectClass": {"person"}, "uid": {uid}}))
+	}
+	f, err := ldap.CompileFilter("(&(objectClass=person)(uid=user12345))")
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := &SearchRequest{SearchRequest: ldap.SearchRequest{BaseObject: []byte(base), Scope: ldap.WholeSubtree, Filter: f}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if entries, err := mem.search(req); err != nil || len(entries) != 1 {
+			b.Fatalf("Bad result: %v, %v", entries, err)
+		}
+	}
+}
+
+func TestMemoryBackendAliases(t *testing.T) {
+	b := newTestBackend(t)
+	aliases := []*ldap.Entry{
+		ldap.NewEntry("ou=Groups,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"Groups"}}),
+		ldap.NewEntry("cn=Staff,ou=Groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"alias", "extensibleObject"}, "cn": {"Staff"}, "aliasedObjectName": {"ou=People,dc=example,dc=com"}}),
+		ldap.NewEntry("cn=Root,ou=Groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"alias", "extensibleObject"}, "cn": {"Root"}, "aliasedObjectName": {"dc=example,dc=com"}}),
+		ldap.NewEntry("cn=Gone,dc=example,dc=com", map[string][]string{
+			"objectClass": {"alias", "extensibleObject"}, "cn": {"Gone"}, "aliasedObjectName": {"cn=Nobody,dc=example,dc=com"}}),
+	}
+	for _, e := range aliases {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	const (
+		people = "ou=People,dc=example,dc=com"
+		alice  = "cn=Alice,ou=People,dc=example,dc=com"
+		bob    = "cn=Bob,ou=People,dc=example,dc=com"
+		staff  = "cn=Staff,ou=Groups,dc=example,dc=com"
+	)
+	tests := []struct {
+		base   string
+		scope  ldap.SearchScope
+		deref  ldap.DerefAliases
+		filter string
+		dns    []string
+	}{
+		{staff, ldap.BaseObject, ldap.NeverDerefAliases, "(objectClass=*)", []string{staff}},
+		{staff, ldap.BaseObject, ldap.DerefInSearching, "(objectClass=*)", []string{staff}},
+		{staff, ldap.BaseObject, ldap.DerefFindingBaseObj, "(objectClass=*)", []string{people}},
+		{staff, ldap.SingleLevel, ldap.DerefAlways, "(objectClass=*)", []string{alice, bob}},
+		{"ou=Groups,dc=example,dc=com", ldap.SingleLevel, ldap.NeverDerefAliases, "(cn=*)",
+			[]string{"cn=Root,ou=Groups,dc=example,dc=com", staff}},
+		{"ou=Groups,dc=example,dc=com", ldap.SingleLevel, ldap.DerefInSearching, "(objectClass=*)", []string{"dc=example,dc=com", people}},
+		{"ou=Groups,dc=example,dc=com", ldap.WholeSubtree, ldap.DerefFindingBaseObj, "(objectClass=person)", []string{}},
+		{"ou=Groups,dc=example,dc=com", ldap.WholeSubtree, ldap.DerefAlways, "(objectClass=person)", []string{alice, bob}},
+		{"dc=example,dc=com", ldap.WholeSubtree, ldap.DerefAlways, "(cn=*)", []string{alice, bob}},
+	}
+	for i, test := range tests {
+		f, err := ldap.CompileFilter(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := c.Search(ldap.SearchRequest{BaseObject: []byte(test.base), Scope: test.scope, Deref: test.deref, Filter: f})
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		dns := []string{}
+		for _, r := range results {
+			dns = append(dns, r.DN)
+		}
+		if !reflect.DeepEqual(dns, test.dns) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, dns, test.dns)
+		}
+	}
+
+	_, err := c.Search(ldap.SearchRequest{BaseObject: []byte("cn=Gone,dc=example,dc=com"), Deref: ldap.DerefFindingBaseObj, Filter: ldap.Present("objectClass")})
+	if e, ok := err.(*ldap.Error); !ok || e.ResultCode != ldap.AliasProblem {
+		t.Errorf("Bad search result: %#v (expected aliasProblem)", err)
+	}
+	_, err = c.Search(ldap.SearchRequest{BaseObject: []byte(staff), Deref: 4, Filter: ldap.Present("objectClass")})
+	if e, ok := err.(*ldap.Error); !ok || e.ResultCode != ldap.ProtocolError {
+		t.Errorf("Bad search result: %#v (expected protocolError)", err)
+	}
+}
+
+func TestMemoryBackendAddWithParents(t *testing.T) {
+	b := newTestBackend(t)
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	const dn = "uid=carol,ou=Contractors,ou=Staff,dc=example,dc=com"
+	templates := map[string][]ldap.Attribute{"ou": {{Type: "objectClass", Values: []string{"organizationalUnit"}}}}
+	err := c.Add(dn, []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}})
+	if matched, ok := ldap.MatchedDN(err); !ok || matched != "dc=example,dc=com" {
+		t.Errorf("Bad matched DN: %q, %v (expected dc=example,dc=com)", matched, err)
+	}
+	if err := c.AddWithParents(ldap.NewEntry(dn, map[string][]string{"objectClass": {"person"}}), templates); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ou=Staff,dc=example,dc=com", "ou=Contractors,ou=Staff,dc=example,dc=com", dn} {
+		if e := b.Entry(name); e == nil {
+			t.Errorf("Missing entry %q", name)
+		}
+	}
+	if e := b.Entry("ou=Staff,dc=example,dc=com"); e != nil && e.GetAttributeValue("objectClass") != "organizationalUnit" {
+		t.Errorf("Bad entry: %v", e.AttributeMap())
+	}
+}
+
+func TestMemoryBackendEnsure(t *testing.T) {
+	b := newTestBackend(t)
+	c, stop := startTestServer(t, b)
+	defer stop()
+
+	const bob, carol = "cn=Bob,ou=People,dc=example,dc=com", "cn=Carol,ou=People,dc=example,dc=com"
+	for _, test := range []struct {
+		dn     string
+		exists bool
+	}{{bob, true}, {carol, false}, {"cn=Dave,ou=Nobody,dc=example,dc=com", false}} {
+		if exists, err := c.Exists(test.dn); err != nil || exists != test.exists {
+			t.Errorf("%s: Bad result: %v, %v (expected %v)", test.dn, exists, err, test.exists)
+		}
+	}
+
+	tests := []struct {
+		entry    *ldap.Entry
+		changed  bool
+		expected map[string][]string
+	}{
+		{ldap.NewEntry(bob, map[string][]string{"sn": {"Jones"}}), false, nil},
+		{ldap.NewEntry(bob, map[string][]string{"sn": {"Jones", "Smith"}, "uidNumber": {}, "mail": {"bob@example.com"}}), true,
+			map[string][]string{"objectclass": {"person"}, "cn": {"Bob"}, "sn": {"Jones", "Smith"}, "mail": {"bob@example.com"}}},
+		{ldap.NewEntry(bob, map[string][]string{"sn": {"Jones", "Smith"}, "uidNumber": {}}), false, nil},
+		{ldap.NewEntry(carol, map[string][]string{"objectClass": {"person"}, "cn": {"Carol"}, "sn": {"Brown"}, "mail": {}}), true,
+			map[string][]string{"objectclass": {"person"}, "cn": {"Carol"}, "sn": {"Brown"}}},
+		{ldap.NewEntry(carol, map[string][]string{"objectClass": {"person"}, "cn": {"Carol"}, "sn": {"Brown"}}), false, nil},
+	}
+	for i, test := range tests {
+		changed, err := c.Ensure(test.entry)
+		if err != nil || changed != test.changed {
+			t.Errorf("#%d: Bad result: %v, %v (expected %v)", i, changed, err, test.changed)
+			continue
+		}
+		if test.expected == nil {
+			continue
+		}
+		if e := b.Entry(test.entry.DN); e == nil || !reflect.DeepEqual(userAttributes(e), test.expected) {
+			t.Errorf("#%d: Bad entry: %v (expected %v)", i, e, test.expected)
+		}
+	}
+}
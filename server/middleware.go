@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"github.com/stesla/ldap"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// A Middleware wraps a Handler to add behavior to some or all of its
+// operations.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h in middleware, the first of which sees each request
+// first.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Intercept returns middleware that calls fn around every operation.
+// fn performs the operation by calling next, or fails it by returning
+// an error without doing so.
+func Intercept(fn func(c *Conn, op Operation, next func() error) error) Middleware {
+	return func(next Handler) Handler {
+		return &interceptor{next, fn}
+	}
+}
+
+type interceptor struct {
+	next Handler
+	fn   func(c *Conn, op Operation, next func() error) error
+}
+
+func (h *interceptor) Bind(c *Conn, req *BindRequest) error {
+	return h.fn(c, req, func() error { return h.next.Bind(c, req) })
+}
+
+func (h *interceptor) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	return h.fn(c, req, func() error { return h.next.Search(c, req, w) })
+}
+
+func (h *interceptor) Add(c *Conn, req *AddRequest) error {
+	return h.fn(c, req, func() error { return h.next.Add(c, req) })
+}
+
+func (h *interceptor) Modify(c *Conn, req *ModifyRequest) error {
+	return h.fn(c, req, func() error { return h.next.Modify(c, req) })
+}
+
+func (h *interceptor) Delete(c *Conn, req *DeleteRequest) error {
+	return h.fn(c, req, func() error { return h.next.Delete(c, req) })
+}
+
+func (h *interceptor) ModifyDN(c *Conn, req *ModifyDNRequest) error {
+	return h.fn(c, req, func() error { return h.next.ModifyDN(c, req) })
+}
+
+func (h *interceptor) Compare(c *Conn, req *CompareRequest) (ok bool, err error) {
+	err = h.fn(c, req, func() (err error) {
+		ok, err = h.next.Compare(c, req)
+		return err
+	})
+	return ok, err
+}
+
+func (h *interceptor) Extended(c *Conn, req *ExtendedRequest) (resp *ExtendedResponse, err error) {
+	err = h.fn(c, req, func() (err error) {
+		resp, err = h.next.Extended(c, req)
+		return err
+	})
+	return resp, err
+}
+
+// AccessLog returns middleware that logs each operation once it
+// completes, with its result code and duration. Passwords and SASL
+// credentials are not logged.
+func AccessLog(logger *slog.Logger) Middleware {
+	return Intercept(func(c *Conn, op Operation, next func() error) error {
+		start := time.Now()
+		err := next()
+		r := result(err)
+		attrs := []slog.Attr{
+			slog.String("remote", c.RemoteAddr().String()),
+			slog.Int("msgid", op.BaseRequest().MessageID),
+			slog.String("op", op.OperationName()),
+			slog.String("dn", op.TargetDN()),
+			slog.String("bind_dn", c.BindDN()),
+			slog.Int("result", int(r.ResultCode)),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if len(r.Message) > 0 {
+			attrs = append(attrs, slog.String("message", string(r.Message)))
+		}
+		level := slog.LevelInfo
+		if err != nil {
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(context.Background(), level, "ldap operation", attrs...)
+		return err
+	})
+}
+
+// Authorize returns middleware that lets an operation through only if
+// allow returns true for it and the connection's bind DN, which is ""
+// for anonymous connections. Other operations fail with
+// ldap.InsufficientAccessRights. Binds are always let through.
+func Authorize(allow func(bindDN string, op Operation) bool) Middleware {
+	return Intercept(func(c *Conn, op Operation, next func() error) error {
+		if _, ok := op.(*BindRequest); !ok && !allow(c.BindDN(), op) {
+			return ldapError(ldap.InsufficientAccessRights, "%s not allowed", op.OperationName())
+		}
+		return next()
+	})
+}
+
+// WriteAccess returns a rule for Authorize that lets anyone read, but
+// only the given DNs add, modify, delete and rename entries, and set
+// passwords with Password Modify.
+func WriteAccess(dns ...string) func(bindDN string, op Operation) bool {
+	writers := map[string]bool{}
+	for _, dn := range dns {
+		if name, err := normalizeDN(dn); err == nil {
+			writers[name.String()] = true
+		}
+	}
+	isWriter := func(bindDN string) bool {
+		name, err := normalizeDN(bindDN)
+		return err == nil && bindDN != "" && writers[name.String()]
+	}
+	return func(bindDN string, op Operation) bool {
+		switch op := op.(type) {
+		case *AddRequest, *ModifyRequest, *DeleteRequest, *ModifyDNRequest:
+			return isWriter(bindDN)
+		case *ExtendedRequest:
+			return op.Name != oidPasswordModify || isWriter(bindDN)
+		}
+		return true
+	}
+}
+
+// RateLimit returns middleware that limits each connection to rate
+// operations per second on average, with bursts of up to burst
+// operations. Operations over the limit fail with ldap.Busy.
+func RateLimit(rate float64, burst int) Middleware {
+	var mu sync.Mutex
+	buckets := map[*Conn]*tokenBucket{}
+	return Intercept(func(c *Conn, op Operation, next func() error) error {
+		mu.Lock()
+		b := buckets[c]
+		if b == nil {
+			b = &tokenBucket{tokens: float64(burst), last: time.Now()}
+			buckets[c] = b
+			c.OnClose(func() {
+				mu.Lock()
+				delete(buckets, c)
+				mu.Unlock()
+			})
+		}
+		ok := b.take(rate, float64(burst))
+		mu.Unlock()
+		if !ok {
+			return ldapError(ldap.Busy, "rate limit exceeded")
+		}
+		return next()
+	})
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) take(rate, burst float64) bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
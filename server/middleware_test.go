@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"github.com/stesla/ldap"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return Intercept(func(c *Conn, op Operation, next func() error) error {
+			calls = append(calls, name+" "+op.OperationName())
+			return next()
+		})
+	}
+	c, stop := startTestServer(t, Chain(newTestBackend(t), trace("a"), trace("b")))
+	defer stop()
+
+	if ok, err := c.Compare("cn=Alice,ou=People,dc=example,dc=com", "sn", "smith"); err != nil || !ok {
+		t.Errorf("Bad compare result: %v, %v (expected true)", ok, err)
+	}
+	if expected := []string{"a compare", "b compare"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Bad calls: %v (expected %v)", calls, expected)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	c, stop := startTestServer(t, Chain(newTestBackend(t), AccessLog(logger)))
+	defer stop()
+
+	if err := c.Bind("cn=Alice,ou=People,dc=example,dc=com", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	c.Del("cn=Nobody,dc=example,dc=com")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Bad log: %q (expected 2 lines)", buf.String())
+	}
+	for i, expected := range [][]string{
+		{"level=INFO", "op=bind", `dn="cn=Alice,ou=People,dc=example,dc=com"`, "result=0"},
+		{"level=WARN", "op=delete", `bind_dn="cn=Alice,ou=People,dc=example,dc=com"`, "result=32"},
+	} {
+		for _, s := range expected {
+			if !strings.Contains(lines[i], s) {
+				t.Errorf("#%d: Bad log line: %q (expected %s)", i, lines[i], s)
+			}
+		}
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("Password logged: %q", buf.String())
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	const alice, bob = "cn=Alice,ou=People,dc=example,dc=com", "cn=Bob,ou=People,dc=example,dc=com"
+	b := newTestBackend(t)
+	c, stop := startTestServer(t, Chain(b, Authorize(WriteAccess("CN=alice, ou=people, dc=example, dc=com"))))
+	defer stop()
+
+	mods := []ldap.Modification{{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Builder"}}}}
+	if err := c.Modify(bob, mods); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad anonymous modify result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if _, err := c.PasswordModify("dn:"+alice, "", "x"); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad anonymous password modify result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if dns := searchDNs(t, c, "(cn=bob)"); !reflect.DeepEqual(dns, []string{bob}) {
+		t.Errorf("Bad search result: %v (expected [%s])", dns, bob)
+	}
+	if err := c.Bind(alice, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Modify(bob, mods); err != nil {
+		t.Errorf("Bad modify result: %v", err)
+	}
+	if _, err := c.PasswordModify("", "secret", "new"); err != nil {
+		t.Errorf("Bad password modify result: %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c, stop := startTestServer(t, Chain(newTestBackend(t), RateLimit(0.001, 2)))
+	defer stop()
+
+	var codes []ldap.ResultCode
+	for i := 0; i < 3; i++ {
+		code := ldap.Success
+		if _, err := c.Compare("cn=Bob,ou=People,dc=example,dc=com", "sn", "jones"); err != nil {
+			code = resultCode(err)
+		}
+		codes = append(codes, code)
+	}
+	if expected := []ldap.ResultCode{ldap.Success, ldap.Success, ldap.Busy}; !reflect.DeepEqual(codes, expected) {
+		t.Errorf("Bad result: %v (expected %v)", codes, expected)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/stesla/ldap"
+	"strings"
+	"time"
+)
+
+// operationalAttributes are the attributes a MemoryBackend maintains on
+// its entries. Clients may not set them, and searches return them only
+// if requested by name or with "+".
+var operationalAttributes = []string{
+	"createTimestamp", "creatorsName", "entryUUID",
+	"modifyTimestamp", "modifiersName", "entryCSN",
+}
+
+// modificationAttributes are the operational attributes that change
+// with every update of an entry.
+var modificationAttributes = operationalAttributes[3:]
+
+func isOperational(attr string) bool {
+	if i := strings.IndexByte(attr, ';'); i >= 0 {
+		attr = attr[:i]
+	}
+	for _, a := range operationalAttributes {
+		if strings.EqualFold(a, attr) {
+			return true
+		}
+	}
+	return false
+}
+
+// userModifiable fails if a client update by c sets attr. Updates with
+// no connection, like those a FileBackend replays, may set it.
+func userModifiable(c *Conn, attr string) error {
+	if c != nil && isOperational(attr) {
+		return ldapError(ldap.ConstraintViolation, "%s: no user modification allowed", attr)
+	}
+	return nil
+}
+
+// stamp sets the operational attributes of e for an update by c, which
+// may be nil, and its creation attributes too if created is set. The
+// attributes named in given were set by the update and are kept.
+// creatorsName and modifiersName are left out for anonymous updates.
+// The caller must hold b.mu for writing.
+func (b *MemoryBackend) stamp(c *Conn, e *ldap.Entry, created bool, given []string) error {
+	now := time.Now().UTC()
+	values := map[string]string{
+		"modifyTimestamp": ldap.FormatGeneralizedTime(now.Truncate(time.Second)),
+		"entryCSN":        b.nextCSN(now),
+	}
+	if c != nil && c.BindDN() != "" {
+		values["modifiersName"] = c.BindDN()
+	}
+	if created {
+		uuid, err := newUUID()
+		if err != nil {
+			return err
+		}
+		values["createTimestamp"] = values["modifyTimestamp"]
+		values["entryUUID"] = uuid
+		if name, ok := values["modifiersName"]; ok {
+			values["creatorsName"] = name
+		}
+	}
+	// The operational attributes go last, in a fixed order.
+	for _, attr := range operationalAttributes {
+		a := e.GetAttribute(attr)
+		if v, ok := values[attr]; ok && !requested(attr, given) {
+			a = ldap.NewEntryAttribute(attr, []string{v})
+		}
+		removeAttribute(e, attr)
+		if a != nil {
+			e.Attributes = append(e.Attributes, a)
+		}
+	}
+	return nil
+}
+
+// nextCSN returns an entryCSN for a change at now, in the format of
+// OpenLDAP, later than any returned before. The caller must hold b.mu
+// for writing.
+func (b *MemoryBackend) nextCSN(now time.Time) string {
+	now = now.Truncate(time.Microsecond)
+	if now.After(b.csnTime) {
+		b.csnTime, b.csnCount = now, 0
+	} else {
+		b.csnCount++
+	}
+	return fmt.Sprintf("%s#%06x#000#000000", b.csnTime.Format("20060102150405.000000Z"), b.csnCount)
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	u := make([]byte, 16)
+	if _, err := rand.Read(u); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// operationalValues returns the attributes of e named in attrs.
+func operationalValues(e *ldap.Entry, attrs []string) []ldap.Attribute {
+	var out []ldap.Attribute
+	for _, attr := range attrs {
+		if a := e.GetAttribute(attr); a != nil {
+			out = append(out, ldap.Attribute{Type: a.Name, Values: append([]string(nil), a.Values...)})
+		}
+	}
+	return out
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/asn1"
+)
+
+// The wire forms of the messages of RFC 4511 §4, as seen from the
+// server's side. Their encoders and decoders are generated.
+
+//go:generate go run ../cmd/asn1gen -type ldapMessage,control,ldapResult,bindRequest,saslCredentials,bindResponse,searchRequest,partialAttribute,searchResultEntry,change,modifyRequest,addRequest,modifyDNRequest,compareRequest,attributeValueAssertion,extendedRequest,extendedResponse -output protocol_asn1.go
+
+type ldapMessage struct {
+	MessageId  int
+	ProtocolOp interface{}
+	Controls   []control `asn1:"tag:0,optional"`
+}
+
+type control struct {
+	Type        []byte
+	Criticality bool   `asn1:"optional"`
+	Value       []byte `asn1:"optional"`
+}
+
+type ldapResult struct {
+	ResultCode ldap.ResultCode `asn1:"enum"`
+	MatchedDN  []byte
+	Message    []byte
+	Referral   [][]byte `asn1:"tag:3,optional"`
+}
+
+type bindRequest struct {
+	Version int
+	Name    []byte
+	Auth    asn1.RawValue
+}
+
+type saslCredentials struct {
+	Mechanism   []byte
+	Credentials []byte `asn1:"optional"`
+}
+
+type bindResponse struct {
+	Result          ldapResult `asn1:"components"`
+	ServerSaslCreds []byte     `asn1:"tag:7,optional"`
+}
+
+type searchRequest struct {
+	BaseObject []byte
+	Scope      ldap.SearchScope  `asn1:"enum"`
+	Deref      ldap.DerefAliases `asn1:"enum"`
+	SizeLimit  int
+	TimeLimit  int
+	TypesOnly  bool
+	Filter     asn1.RawValue
+	Attributes [][]byte
+}
+
+type partialAttribute struct {
+	Type   []byte
+	Values [][]byte `asn1:"set"`
+}
+
+type searchResultEntry struct {
+	Name       []byte
+	Attributes []partialAttribute
+}
+
+type change struct {
+	Operation    ldap.ModifyOperation `asn1:"enum"`
+	Modification partialAttribute
+}
+
+type modifyRequest struct {
+	Object  []byte
+	Changes []change
+}
+
+type addRequest struct {
+	Entry      []byte
+	Attributes []partialAttribute
+}
+
+type modifyDNRequest struct {
+	Entry        []byte
+	NewRDN       []byte
+	DeleteOldRDN bool
+	NewSuperior  []byte `asn1:"tag:0,optional"`
+}
+
+type compareRequest struct {
+	Entry []byte
+	Ava   attributeValueAssertion
+}
+
+type attributeValueAssertion struct {
+	Attribute, Value []byte
+}
+
+type extendedRequest struct {
+	Name  []byte `asn1:"tag:0"`
+	Value []byte `asn1:"tag:1,optional"`
+}
+
+type extendedResponse struct {
+	Result ldapResult `asn1:"components"`
+	Name   []byte     `asn1:"tag:10,optional"`
+	Value  []byte     `asn1:"tag:11,optional"`
+}
+
+// Protocol operation tags.
+const (
+	opBindRequest           = 0
+	opBindResponse          = 1
+	opUnbindRequest         = 2
+	opSearchRequest         = 3
+	opSearchResultEntry     = 4
+	opSearchResultDone      = 5
+	opModifyRequest         = 6
+	opModifyResponse        = 7
+	opAddRequest            = 8
+	opAddResponse           = 9
+	opDelRequest            = 10
+	opDelResponse           = 11
+	opModifyDNRequest       = 12
+	opModifyDNResponse      = 13
+	opCompareRequest        = 14
+	opCompareResponse       = 15
+	opAbandonRequest        = 16
+	opSearchResultReference = 19
+	opExtendedRequest       = 23
+	opExtendedResponse      = 24
+)
+
+func application(tag int) string {
+	return fmt.Sprintf("application,tag:%d", tag)
+}
+
+// decodeValue decodes b, which must hold exactly one element, into out.
+func decodeValue(b []byte, out interface{}) error {
+	dec := asn1.NewDecoder(bytes.NewReader(b))
+	dec.Implicit = true
+	// No element in b can be longer than b.
+	dec.MaxLength = len(b)
+	if err := dec.Decode(out); err != nil {
+		return err
+	}
+	if n := dec.InputOffset(); n != int64(len(b)) {
+		return fmt.Errorf("%d bytes of trailing data", int64(len(b))-n)
+	}
+	return nil
+}
+
+func encodeValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := asn1.NewEncoder(&buf)
+	enc.Implicit = true
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeOp(raw asn1.RawValue, out interface{}) error {
+	return decodeValue(raw.RawBytes, asn1.OptionValue{Opts: application(raw.Tag), Value: out})
+}
+
+func encodeAttributes(attrs []ldap.Attribute) []partialAttribute {
+	out := []partialAttribute{}
+	for _, a := range attrs {
+		pa := partialAttribute{Type: []byte(a.Type), Values: [][]byte{}}
+		for _, v := range a.Values {
+			pa.Values = append(pa.Values, []byte(v))
+		}
+		out = append(out, pa)
+	}
+	return out
+}
+
+func decodeAttribute(pa partialAttribute) ldap.Attribute {
+	a := ldap.Attribute{Type: string(pa.Type), Values: []string{}}
+	for _, v := range pa.Values {
+		a.Values = append(a.Values, string(v))
+	}
+	return a
+}
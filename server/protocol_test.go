@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/asn1"
+	"reflect"
+	"testing"
+)
+
+// The plain types have the fields of the generated ones but not their
+// methods, so that they are encoded by reflection.
+type (
+	plainMessage        ldapMessage
+	plainBindResponse   bindResponse
+	plainSearchRequest  searchRequest
+	plainModifyRequest  modifyRequest
+	plainModifyDN       modifyDNRequest
+	plainExtendedResult extendedResponse
+)
+
+func TestGeneratedEncoding(t *testing.T) {
+	filter, _ := encodeValue(asn1.OptionValue{Opts: "tag:7", Value: []byte("cn")})
+	raw := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 7, Bytes: []byte("cn"), RawBytes: filter}
+	result := ldapResult{ResultCode: ldap.Referral, MatchedDN: []byte("dc=com"), Message: []byte{}, Referral: [][]byte{[]byte("ldap://b/")}}
+	tests := []struct {
+		generated, plain interface{}
+		out              interface{}
+	}{
+		{ldapMessage{MessageId: 300, ProtocolOp: asn1.OptionValue{Opts: application(opDelRequest), Value: []byte("cn=a")},
+			Controls: []control{{Type: []byte("1.2.3"), Criticality: true}, {Type: []byte("1.2.4"), Value: []byte{}}}},
+			nil, &ldapMessage{ProtocolOp: &asn1.RawValue{}}},
+		{bindResponse{Result: result, ServerSaslCreds: []byte("creds")}, nil, &bindResponse{}},
+		{searchRequest{BaseObject: []byte("dc=com"), Scope: ldap.WholeSubtree, SizeLimit: 1000, TypesOnly: true,
+			Filter: raw, Attributes: [][]byte{}}, nil, &searchRequest{}},
+		{modifyRequest{Object: []byte("cn=a"), Changes: []change{
+			{ldap.ReplaceValues, partialAttribute{[]byte("sn"), [][]byte{[]byte("b"), bytes.Repeat([]byte("c"), 200)}}}}},
+			nil, &modifyRequest{}},
+		{modifyDNRequest{Entry: []byte("cn=a"), NewRDN: []byte("cn=b"), DeleteOldRDN: true, NewSuperior: []byte("dc=org")},
+			nil, &modifyDNRequest{}},
+		{extendedResponse{Result: ldapResult{Message: []byte{}, MatchedDN: []byte{}}, Value: []byte{1}}, nil, &extendedResponse{}},
+	}
+	tests[0].plain = plainMessage(tests[0].generated.(ldapMessage))
+	tests[1].plain = plainBindResponse(tests[1].generated.(bindResponse))
+	tests[2].plain = plainSearchRequest(tests[2].generated.(searchRequest))
+	tests[3].plain = plainModifyRequest(tests[3].generated.(modifyRequest))
+	tests[4].plain = plainModifyDN(tests[4].generated.(modifyDNRequest))
+	tests[5].plain = plainExtendedResult(tests[5].generated.(extendedResponse))
+
+	for i, test := range tests {
+		expected, err := encodeValue(test.plain)
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		b, err := encodeValue(test.generated)
+		if err != nil || !bytes.Equal(b, expected) {
+			t.Errorf("#%d: Bad result: % x, %v (expected % x)", i, b, err, expected)
+			continue
+		}
+		if err := decodeValue(b, test.out); err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		if m, ok := test.out.(*ldapMessage); ok {
+			m.ProtocolOp = test.generated.(ldapMessage).ProtocolOp
+		}
+		if out := reflect.ValueOf(test.out).Elem().Interface(); !reflect.DeepEqual(out, test.generated) {
+			t.Errorf("#%d: Bad result: %+v (expected %+v)", i, out, test.generated)
+		}
+	}
+
+	var r bindRequest
+	for i, in := range [][]byte{
+		{0x30, 0x03, 0x02, 0x01, 0x03},
+		{0x30, 0x06, 0x04, 0x01, 'a', 0x02, 0x01, 0x03},
+		{0x30, 0x0a, 0x02, 0x01, 0x03, 0x04, 0x00, 0x80, 0x00, 0x05, 0x00},
+		{0x30, 0x07, 0x02, 0x01, 0x03, 0x04, 0x00, 0x80, 0x00, 0x00},
+	} {
+		if err := decodeValue(in, &r); err == nil {
+			t.Errorf("#%d: Expected error decoding % x", i, in)
+		}
+	}
+}
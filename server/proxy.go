@@ -0,0 +1,277 @@
+package server
+
+import (
+	"errors"
+	"github.com/stesla/ldap"
+	"sync"
+)
+
+// A Proxy is a Handler that forwards operations to upstream servers.
+//
+// A client that binds is given a connection of its own, dialed as Pool
+// dials them and bound as the client, which serves its operations until
+// it binds again or disconnects, and is then closed. It does not count
+// against the pool's MaxConns. Operations of clients that have not
+// bound, or whose last bind failed, are refused unless AllowAnonymous
+// is set, in which case they use connections from Pool. To spread load
+// over several servers, dial the pool's connections with
+// ldap.RoundRobin or a server set.
+//
+// Request controls in Controls are forwarded with each operation, and
+// the response controls of binds and searches are returned to the
+// client.
+type Proxy struct {
+	BaseHandler
+	Pool *ldap.Pool
+	// AllowAnonymous lets clients that have not bound operate on the
+	// pool's connections. Those must then not be bound, as by
+	// PoolOptions.Bind, or the clients would act with their rights.
+	AllowAnonymous bool
+	// Controls are the OIDs of the request controls forwarded upstream:
+	// DefaultProxyControls if nil. Others are dropped, unless they are
+	// critical, when the operation fails with
+	// ldap.UnavailableCriticalExtension.
+	Controls []string
+	// RewriteRequest, if set, may change each request before it is
+	// forwarded, e.g. to map DNs or attribute names to those of the
+	// upstream directory. req is one of *BindRequest, *SearchRequest,
+	// *AddRequest, *ModifyRequest, *DeleteRequest, *ModifyDNRequest,
+	// *CompareRequest and *ExtendedRequest. An error fails the operation.
+	RewriteRequest func(c *Conn, req interface{}) error
+	// RewriteEntry, if set, may change each entry a search returns
+	// before it is sent to the client.
+	RewriteEntry func(c *Conn, e *ldap.Entry) error
+
+	mu       sync.Mutex
+	sessions map[*Conn]ldap.Conn
+}
+
+// DefaultProxyControls are the request controls a Proxy forwards if its
+// Controls are nil. Controls that change whose authority an operation
+// is performed with, such as proxied authorization, are not among them.
+var DefaultProxyControls = []string{
+	ldap.ControlTypePaging,
+	ldap.ControlTypeServerSideSort,
+	ldap.ControlTypeVLV,
+	ldap.ControlTypeManageDsaIT,
+	ldap.ControlTypeSubtreeDelete,
+}
+
+func NewProxy(pool *ldap.Pool) *Proxy {
+	return &Proxy{Pool: pool}
+}
+
+func (p *Proxy) rewrite(c *Conn, req interface{}) error {
+	if p.RewriteRequest == nil {
+		return nil
+	}
+	return p.RewriteRequest(c, req)
+}
+
+// upstream calls fn with the connection that serves c, its session if it
+// has bound or one from the pool, and the controls of req to forward.
+func (p *Proxy) upstream(c *Conn, req *Request, fn func(ldap.Conn, []ldap.Control) error) error {
+	ctls, err := p.controls(req)
+	if err != nil {
+		return err
+	}
+	if session := p.session(c); session != nil {
+		return upstreamError(fn(session.WithContext(req.Context()), ctls))
+	}
+	if !p.AllowAnonymous {
+		return ldapError(ldap.InsufficientAccessRights, "anonymous operations not allowed")
+	}
+	return upstreamError(p.Pool.WithConn(func(conn ldap.Conn) error {
+		return fn(conn.WithContext(req.Context()), ctls)
+	}))
+}
+
+// upstreamError passes LDAP errors from upstream on to the client, and
+// reports any other failure as the upstream being unavailable.
+func upstreamError(err error) error {
+	var e *ldap.Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return ldapError(ldap.Unavailable, "upstream: %v", err)
+}
+
+// controls returns the request's controls that are forwarded, failing
+// if a critical one is not.
+func (p *Proxy) controls(req *Request) ([]ldap.Control, error) {
+	forwarded := p.Controls
+	if forwarded == nil {
+		forwarded = DefaultProxyControls
+	}
+	var ctls []ldap.Control
+next:
+	for _, c := range req.Controls {
+		for _, oid := range forwarded {
+			if c.OID == oid {
+				ctls = append(ctls, c)
+				continue next
+			}
+		}
+		if c.Criticality {
+			return nil, ldapError(ldap.UnavailableCriticalExtension, "unsupported critical control %s", c.OID)
+		}
+	}
+	return ctls, nil
+}
+
+func (p *Proxy) session(c *Conn) ldap.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sessions[c]
+}
+
+// endSession discards the session of c. It stays registered, with a nil
+// session, until the connection closes.
+func (p *Proxy) endSession(c *Conn, closed bool) {
+	p.mu.Lock()
+	session := p.sessions[c]
+	if closed {
+		delete(p.sessions, c)
+	} else if session != nil {
+		p.sessions[c] = nil
+	}
+	p.mu.Unlock()
+	if session != nil {
+		session.Close()
+	}
+}
+
+func (p *Proxy) Bind(c *Conn, req *BindRequest) error {
+	if req.SASL != nil {
+		return p.BaseHandler.Bind(c, req)
+	}
+	if err := p.rewrite(c, req); err != nil {
+		return err
+	}
+	p.endSession(c, false)
+	if req.Name == "" && req.Password == "" {
+		return nil
+	}
+	if req.Password == "" {
+		return ldapError(ldap.UnwillingToPerform, "unauthenticated bind not allowed")
+	}
+
+	ctls, err := p.controls(&req.Request)
+	if err != nil {
+		return err
+	}
+	session, err := p.Pool.Dial()
+	if err != nil {
+		return upstreamError(err)
+	}
+	resp, err := session.WithContext(req.Context()).BindWithControls(req.Name, req.Password, ctls...)
+	req.ResponseControls = resp
+	if err != nil {
+		session.Close()
+		return upstreamError(err)
+	}
+
+	p.mu.Lock()
+	if p.sessions == nil {
+		p.sessions = map[*Conn]ldap.Conn{}
+	}
+	_, registered := p.sessions[c]
+	p.sessions[c] = session
+	p.mu.Unlock()
+	if !registered {
+		c.OnClose(func() { p.endSession(c, true) })
+	}
+	return nil
+}
+
+func (p *Proxy) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	if err := p.rewrite(c, req); err != nil {
+		return err
+	}
+	return p.upstream(c, &req.Request, func(conn ldap.Conn, ctls []ldap.Control) error {
+		resp, err := conn.SearchFunc(req.SearchRequest, func(r ldap.SearchResult, entryCtls []ldap.Control) error {
+			e := r.Entry()
+			if p.RewriteEntry != nil {
+				if err := p.RewriteEntry(c, e); err != nil {
+					return err
+				}
+			}
+			return w.WriteEntry(e, entryCtls...)
+		}, ctls...)
+		req.ResponseControls = resp
+		return err
+	})
+}
+
+func (p *Proxy) Add(c *Conn, req *AddRequest) error {
+	if err := p.rewrite(c, req); err != nil {
+		return err
+	}
+	return p.upstream(c, &req.Request, func(conn ldap.Conn, ctls []ldap.Control) error {
+		return conn.Add(req.DN, req.Attributes, ctls...)
+	})
+}
+
+func (p *Proxy) Modify(c *Conn, req *ModifyRequest) error {
+	if err := p.rewrite(c, req); err != nil {
+		return err
+	}
+	return p.upstream(c, &req.Request, func(conn ldap.Conn, ctls []ldap.Control) error {
+		return conn.Modify(req.DN, req.Modifications, ctls...)
+	})
+}
+
+func (p *Proxy) Delete(c *Conn, req *DeleteRequest) error {
+	if err := p.rewrite(c, req); err != nil {
+		return err
+	}
+	return p.upstream(c, &req.Request, func(conn ldap.Conn, ctls []ldap.Control) error {
+		return conn.Del(req.DN, ctls...)
+	})
+}
+
+func (p *Proxy) ModifyDN(c *Conn, req *ModifyDNRequest) error {
+	if err := p.rewrite(c, req); err != nil {
+		return err
+	}
+	return p.upstream(c, &req.Request, func(conn ldap.Conn, ctls []ldap.Control) error {
+		return conn.ModifyDN(req.DN, req.NewRDN, req.DeleteOldRDN, req.NewSuperior, ctls...)
+	})
+}
+
+func (p *Proxy) Compare(c *Conn, req *CompareRequest) (bool, error) {
+	if err := p.rewrite(c, req); err != nil {
+		return false, err
+	}
+	var ok bool
+	err := p.upstream(c, &req.Request, func(conn ldap.Conn, ctls []ldap.Control) (err error) {
+		ok, err = conn.Compare(req.DN, req.Attribute, req.Value, ctls...)
+		return err
+	})
+	return ok, err
+}
+
+const oidWhoAmI = "1.3.6.1.4.1.4203.1.11.3"
+
+// Extended forwards the Who am I? operation of bound clients. Other
+// extended operations are refused, as they may change the state of the
+// upstream connection.
+func (p *Proxy) Extended(c *Conn, req *ExtendedRequest) (*ExtendedResponse, error) {
+	if err := p.rewrite(c, req); err != nil {
+		return nil, err
+	}
+	if req.Name != oidWhoAmI {
+		return p.BaseHandler.Extended(c, req)
+	}
+	// Pooled connections may be bound as a service account, which is
+	// not the identity of an anonymous client.
+	session := p.session(c)
+	if session == nil {
+		return &ExtendedResponse{}, nil
+	}
+	authzID, err := session.WithContext(req.Context()).WhoAmI()
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	return &ExtendedResponse{Value: []byte(authzID)}, nil
+}
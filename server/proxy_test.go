@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stesla/ldap"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type controlRecorder struct {
+	*MemoryBackend
+	controls []string
+}
+
+func (h *controlRecorder) Add(c *Conn, req *AddRequest) error {
+	for _, ctl := range req.Controls {
+		h.controls = append(h.controls, ctl.OID)
+	}
+	return h.MemoryBackend.Add(c, req)
+}
+
+func startTestProxy(t *testing.T, upstream Handler, configure func(p *Proxy)) (ldap.Conn, func()) {
+	addr, stopUpstream := serveTest(t, upstream)
+	pool, err := ldap.NewPool(func() (ldap.Conn, error) { return ldap.Dial(addr) }, ldap.PoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewProxy(pool)
+	if configure != nil {
+		configure(p)
+	}
+	c, stopProxy := startTestServer(t, p)
+	return c, func() {
+		stopProxy()
+		pool.Close()
+		stopUpstream()
+	}
+}
+
+func TestProxy(t *testing.T) {
+	backend := &controlRecorder{MemoryBackend: newTestBackend(t)}
+	c, stop := startTestProxy(t, backend, nil)
+	defer stop()
+
+	const people = "ou=People,dc=example,dc=com"
+	f := ldap.Present("objectClass")
+	if _, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: f}); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad anonymous search result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if err := c.Bind("cn=Alice,"+people, "wrong"); resultCode(err) != ldap.InvalidCredentials {
+		t.Errorf("Bad bind result: %v (expected %v)", err, ldap.InvalidCredentials)
+	}
+	// A failed bind leaves the client anonymous.
+	if _, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: f}); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad search result after failed bind: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if err := c.Bind("cn=Alice,"+people, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	dns := searchDNs(t, c, "(objectClass=person)")
+	expected := []string{"cn=Alice," + people, "cn=Bob," + people}
+	if !reflect.DeepEqual(dns, expected) {
+		t.Errorf("Bad result: %v (expected %v)", dns, expected)
+	}
+
+	// Only known controls are forwarded, and unknown critical ones fail
+	// the operation.
+	attrs := []ldap.Attribute{{Type: "objectClass", Values: []string{"person"}}}
+	err := c.Add("cn=Dave,"+people, attrs, &Control{OID: "1.2.3.4", Criticality: true})
+	if resultCode(err) != ldap.UnavailableCriticalExtension {
+		t.Errorf("Bad add result: %v (expected %v)", err, ldap.UnavailableCriticalExtension)
+	}
+	err = c.Add("cn=Carol,"+people, attrs, &Control{OID: "1.2.3.4"}, &Control{OID: ldap.ControlTypeManageDsaIT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(backend.controls, []string{ldap.ControlTypeManageDsaIT}) {
+		t.Errorf("Bad controls: %v (expected [%s])", backend.controls, ldap.ControlTypeManageDsaIT)
+	}
+	err = c.Modify("cn=Carol,"+people, []ldap.Modification{
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Brown"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Compare("cn=Carol,"+people, "sn", "brown"); err != nil || !ok {
+		t.Errorf("Bad compare result: %v, %v (expected true)", ok, err)
+	}
+	if err := c.ModifyDN("cn=Carol,"+people, "cn=Caroline", true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("cn=Caroline," + people); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("cn=Caroline," + people); resultCode(err) != ldap.NoSuchObject {
+		t.Errorf("Bad delete result: %v (expected %v)", err, ldap.NoSuchObject)
+	}
+}
+
+func TestProxyRewrite(t *testing.T) {
+	const client, upstream = "o=proxy", "dc=example,dc=com"
+	rewriteDN := func(dn, from, to string) string {
+		if strings.HasSuffix(strings.ToLower(dn), from) {
+			return dn[:len(dn)-len(from)] + to
+		}
+		return dn
+	}
+	c, stop := startTestProxy(t, newTestBackend(t), func(p *Proxy) {
+		p.RewriteRequest = func(c *Conn, req interface{}) error {
+			switch req := req.(type) {
+			case *BindRequest:
+				req.Name = rewriteDN(req.Name, client, upstream)
+			case *SearchRequest:
+				req.BaseObject = []byte(rewriteDN(req.BaseDN(), client, upstream))
+			}
+			return nil
+		}
+		p.RewriteEntry = func(c *Conn, e *ldap.Entry) error {
+			e.DN = rewriteDN(e.DN, upstream, client)
+			return nil
+		}
+	})
+	defer stop()
+
+	if err := c.Bind("cn=Alice,ou=People,o=proxy", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.Search(ldap.SearchRequest{
+		BaseObject: []byte("ou=People,o=proxy"),
+		Scope:      ldap.SingleLevel,
+		Filter:     ldap.Equals("cn", "bob"),
+		Attributes: [][]byte{[]byte("sn")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ldap.SearchResult{{DN: "cn=Bob,ou=People,o=proxy", Attributes: map[string][]string{"sn": {"Jones"}}}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Bad result: %v (expected %v)", results, expected)
+	}
+}
+
+// unauthenticatedBackend accepts unauthenticated binds, as some
+// directories do.
+type unauthenticatedBackend struct {
+	*MemoryBackend
+}
+
+func (b unauthenticatedBackend) Bind(c *Conn, req *BindRequest) error {
+	if req.SASL == nil && req.Password == "" {
+		return nil
+	}
+	return b.MemoryBackend.Bind(c, req)
+}
+
+func TestProxyUnauthenticatedBind(t *testing.T) {
+	c, stop := startTestProxy(t, unauthenticatedBackend{newTestBackend(t)}, nil)
+	defer stop()
+
+	if err := c.Bind("cn=Alice,ou=People,dc=example,dc=com", ""); resultCode(err) != ldap.UnwillingToPerform {
+		t.Errorf("Bad bind result: %v (expected %v)", err, ldap.UnwillingToPerform)
+	}
+	if _, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: ldap.Present("objectClass")}); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad search result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+}
+
+func TestProxyAnonymous(t *testing.T) {
+	addr, stopUpstream := serveTest(t, newTestBackend(t))
+	defer stopUpstream()
+	pool, err := ldap.NewPool(func() (ldap.Conn, error) { return ldap.Dial(addr) }, ldap.PoolOptions{MaxConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	p := NewProxy(pool)
+	p.AllowAnonymous = true
+	proxyAddr, stop := serveTest(t, p)
+	defer stop()
+
+	// Bound clients do not hold the pool's connections.
+	for i := 0; i < 2; i++ {
+		c, err := ldap.Dial(proxyAddr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.Bind("cn=Alice,ou=People,dc=example,dc=com", "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := ldap.Dial(proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	done := make(chan error)
+	go func() {
+		results, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: ldap.Equals("cn", "bob")})
+		if err == nil && len(results) != 1 {
+			err = fmt.Errorf("%d results (expected 1)", len(results))
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Bad anonymous search result: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Anonymous search blocked on the pool")
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "" {
+		t.Errorf("Bad WhoAmI result: %q, %v (expected anonymous)", id, err)
+	}
+}
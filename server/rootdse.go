@@ -0,0 +1,163 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+	"strconv"
+)
+
+// SubschemaDN is the DN at which a Server publishes its Schema.
+const SubschemaDN = "cn=Subschema"
+
+// A Describer is a Handler that publishes what it supports in the root
+// DSE: the naming contexts it holds, and the controls, extended
+// operations, features and SASL mechanisms it implements. Describe adds
+// them to dse, which already holds what the Server itself supports.
+//
+// The middleware of this package passes Describe on to the handlers it
+// wraps.
+type Describer interface {
+	Describe(c *Conn, dse *ldap.RootDSE)
+}
+
+func describe(h Handler, c *Conn, dse *ldap.RootDSE) {
+	if d, ok := h.(Describer); ok {
+		d.Describe(c, dse)
+	}
+}
+
+// published returns the entry the server publishes itself at the base
+// of req, if it is a base object search of the root DSE or of the
+// subschema subentry, or nil.
+func (c *Conn) published(req *SearchRequest) *ldap.Entry {
+	if req.Scope != ldap.BaseObject {
+		return nil
+	}
+	base, err := normalizeDN(req.BaseDN())
+	if err != nil {
+		return nil
+	}
+	if len(base) == 0 {
+		return c.rootDSE()
+	}
+	if s := c.server.Schema; s != nil {
+		if name, _ := normalizeDN(SubschemaDN); base.String() == name.String() {
+			return s.Entry(SubschemaDN)
+		}
+	}
+	return nil
+}
+
+func (c *Conn) rootDSE() *ldap.Entry {
+	dse := &ldap.RootDSE{SupportedLDAPVersion: []int{3}, SupportedExtension: []string{oidCancel}}
+	if c.server.TLSConfig != nil {
+		dse.SupportedExtension = append(dse.SupportedExtension, oidStartTLS)
+	}
+	if c.server.Schema != nil {
+		dse.SubschemaSubentry = SubschemaDN
+	}
+	h := c.server.Handler
+	if h == nil {
+		h = BaseHandler{}
+	}
+	describe(h, c, dse)
+
+	e := &ldap.Entry{}
+	add := func(attr string, values ...string) {
+		if len(values) > 0 && values[0] != "" {
+			e.Attributes = append(e.Attributes, ldap.NewEntryAttribute(attr, values))
+		}
+	}
+	add("objectClass", "top")
+	var versions []string
+	for _, v := range dse.SupportedLDAPVersion {
+		versions = append(versions, strconv.Itoa(v))
+	}
+	add("supportedLDAPVersion", versions...)
+	add("namingContexts", dse.NamingContexts...)
+	add("supportedControl", dse.SupportedControl...)
+	add("supportedExtension", dse.SupportedExtension...)
+	add("supportedFeatures", dse.SupportedFeatures...)
+	add("supportedSASLMechanisms", dse.SupportedSASLMechanisms...)
+	add("subschemaSubentry", dse.SubschemaSubentry)
+	add("vendorName", dse.VendorName)
+	add("vendorVersion", dse.VendorVersion)
+	return e
+}
+
+// searchPublished returns e to the client if it matches req. All its
+// attributes but objectClass and cn are operational, so they are
+// returned only if requested by name or with "+".
+func searchPublished(e *ldap.Entry, req *SearchRequest, w SearchWriter) error {
+	ok, err := ldap.FilterMatches(req.Filter, e)
+	if err != nil {
+		return ldapError(ldap.ProtocolError, "%v", err)
+	}
+	if !ok {
+		return nil
+	}
+	attrs := req.AttributeList()
+	all, operational := len(attrs) == 0, false
+	for _, a := range attrs {
+		switch a {
+		case "*":
+			all = true
+		case "+":
+			operational = true
+		}
+	}
+	out := &ldap.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		user := a.Name == "objectClass" || a.Name == "cn"
+		if !requested(a.Name, attrs) && !(user && all) && !(!user && operational) {
+			continue
+		}
+		if req.TypesOnly {
+			out.Attributes = append(out.Attributes, &ldap.EntryAttribute{Name: a.Name})
+		} else {
+			out.Attributes = append(out.Attributes, a)
+		}
+	}
+	return w.WriteEntry(out)
+}
+
+// Describe publishes the DNs of the entries without superiors as naming
+// contexts, and the paged results and sort controls.
+func (b *MemoryBackend) Describe(c *Conn, dse *ldap.RootDSE) {
+	b.mu.RLock()
+	b.store.walk(ldap.DN{}, func(me *memoryEntry) bool {
+		if superior, _ := b.store.entry(me.name[1:]); superior == nil {
+			dse.NamingContexts = append(dse.NamingContexts, me.entry.DN)
+		}
+		return true
+	})
+	b.mu.RUnlock()
+	dse.SupportedControl = append(dse.SupportedControl, ldap.ControlTypePaging, ldap.ControlTypeServerSideSort)
+}
+
+// Describe publishes the naming contexts, controls and features of the
+// upstream server. The Who am I? operation is published if it is
+// supported upstream.
+func (p *Proxy) Describe(c *Conn, dse *ldap.RootDSE) {
+	var upstream *ldap.RootDSE
+	p.Pool.WithConn(func(conn ldap.Conn) (err error) {
+		upstream, err = conn.RootDSE()
+		return err
+	})
+	if upstream == nil {
+		return
+	}
+	dse.NamingContexts = append(dse.NamingContexts, upstream.NamingContexts...)
+	dse.SupportedControl = append(dse.SupportedControl, upstream.SupportedControl...)
+	dse.SupportedFeatures = append(dse.SupportedFeatures, upstream.SupportedFeatures...)
+	if upstream.SupportsExtension(oidWhoAmI) {
+		dse.SupportedExtension = append(dse.SupportedExtension, oidWhoAmI)
+	}
+}
+
+func (h *interceptor) Describe(c *Conn, dse *ldap.RootDSE) { describe(h.next, c, dse) }
+func (h *aclHandler) Describe(c *Conn, dse *ldap.RootDSE)  { describe(h.Handler, c, dse) }
+
+func (h *saslHandler) Describe(c *Conn, dse *ldap.RootDSE) {
+	describe(h.Handler, c, dse)
+	dse.SupportedSASLMechanisms = append(dse.SupportedSASLMechanisms, h.names...)
+}
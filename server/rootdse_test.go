@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/tls"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/schema"
+	"reflect"
+	"testing"
+)
+
+func TestRootDSE(t *testing.T) {
+	b := newTestBackend(t)
+	s := schema.New()
+	for _, def := range []string{
+		`( 2.5.4.41 NAME 'name' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )`,
+		`( 2.5.4.3 NAME ( 'cn' 'commonName' ) SUP name )`,
+	} {
+		at, err := schema.ParseAttributeType(def)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.AddAttributeType(at)
+	}
+	oc, err := schema.ParseObjectClass(`( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) )`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddObjectClass(oc)
+
+	server := &Server{
+		Handler:   Chain(b, SASL(&SASLPlain{Authenticate: b.Authenticate})),
+		Schema:    s,
+		TLSConfig: &tls.Config{},
+	}
+	addr, stop := serveTestServer(t, server)
+	defer stop()
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	dse, err := c.RootDSE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dse.SupportedLDAPVersion, []int{3}) {
+		t.Errorf("Bad versions: %v (expected %v)", dse.SupportedLDAPVersion, []int{3})
+	}
+	if expected := []string{"dc=example,dc=com"}; !reflect.DeepEqual(dse.NamingContexts, expected) {
+		t.Errorf("Bad naming contexts: %v (expected %v)", dse.NamingContexts, expected)
+	}
+	for _, oid := range []string{ldap.ControlTypePaging, ldap.ControlTypeServerSideSort} {
+		if !dse.SupportsControl(oid) {
+			t.Errorf("Control %s not supported", oid)
+		}
+	}
+	if !dse.SupportsExtension(oidStartTLS) {
+		t.Errorf("StartTLS not supported")
+	}
+	if expected := []string{"PLAIN"}; !reflect.DeepEqual(dse.SupportedSASLMechanisms, expected) {
+		t.Errorf("Bad SASL mechanisms: %v (expected %v)", dse.SupportedSASLMechanisms, expected)
+	}
+	if dse.SubschemaSubentry != SubschemaDN {
+		t.Errorf("Bad subschema subentry: %q (expected %q)", dse.SubschemaSubentry, SubschemaDN)
+	}
+
+	out, err := schema.Fetch(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.AttributeTypes, s.AttributeTypes) || !reflect.DeepEqual(out.ObjectClasses, s.ObjectClasses) {
+		t.Errorf("Bad schema: %v %v (expected %v %v)", out.AttributeTypes, out.ObjectClasses, s.AttributeTypes, s.ObjectClasses)
+	}
+
+	// Operational attributes are returned only on request.
+	results, err := c.Search(ldap.SearchRequest{Filter: ldap.Present("objectClass")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Attributes) != 1 || results[0].Attributes["objectClass"] == nil {
+		t.Errorf("Bad root DSE: %v (expected only objectClass)", results)
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/internal/digestmd5"
+	"strings"
+	"sync"
+)
+
+// A SASLMechanism implements the server side of a SASL authentication
+// mechanism (RFC 4422) for the SASL middleware.
+type SASLMechanism interface {
+	// Name returns the registered name of the mechanism, e.g. "PLAIN".
+	Name() string
+	// Start begins an exchange with a client of c.
+	Start(c *Conn) SASLExchange
+}
+
+// A SASLExchange is the server's side of one authentication.
+type SASLExchange interface {
+	// Next is passed each response of the client, starting with its
+	// initial response, which is nil if it sent none. If the exchange
+	// goes on, it returns the challenge to send. Once it is done, it
+	// returns the DN the client authenticated as, and any additional
+	// data to send with the outcome.
+	Next(response []byte) (challenge []byte, dn string, done bool, err error)
+}
+
+// SASL returns middleware that performs SASL binds with mechs, setting
+// the connection's bind DN when they succeed. Binds with other
+// mechanisms, and simple binds, are passed on.
+func SASL(mechs ...SASLMechanism) Middleware {
+	return func(next Handler) Handler {
+		h := &saslHandler{Handler: next, mechs: map[string]SASLMechanism{}, exchanges: map[*Conn]*saslState{}}
+		for _, m := range mechs {
+			h.mechs[m.Name()] = m
+			h.names = append(h.names, m.Name())
+		}
+		return h
+	}
+}
+
+type saslHandler struct {
+	Handler
+	mechs map[string]SASLMechanism
+	names []string // in order of preference
+
+	mu        sync.Mutex
+	exchanges map[*Conn]*saslState // exchanges in progress
+}
+
+type saslState struct {
+	mech     string
+	exchange SASLExchange
+}
+
+func (h *saslHandler) Bind(c *Conn, req *BindRequest) error {
+	h.mu.Lock()
+	state, registered := h.exchanges[c]
+	h.exchanges[c] = nil
+	if !registered {
+		c.OnClose(func() {
+			h.mu.Lock()
+			delete(h.exchanges, c)
+			h.mu.Unlock()
+		})
+	}
+	h.mu.Unlock()
+
+	var mech SASLMechanism
+	if req.SASL != nil {
+		mech = h.mechs[req.SASL.Mechanism]
+	}
+	if mech == nil {
+		return h.Handler.Bind(c, req)
+	}
+	// A bind with a different mechanism aborts the exchange in
+	// progress.
+	if state == nil || state.mech != mech.Name() {
+		state = &saslState{mech.Name(), mech.Start(c)}
+	}
+
+	challenge, dn, done, err := state.exchange.Next(req.SASL.Credentials)
+	req.ServerSASLCredentials = challenge
+	switch {
+	case err != nil:
+		return err
+	case !done:
+		h.mu.Lock()
+		h.exchanges[c] = state
+		h.mu.Unlock()
+		return &ldap.Error{ResultCode: ldap.SaslBindInProgress}
+	}
+	c.SetBindDN(dn)
+	return nil
+}
+
+// authorize checks that a client authenticated as dn, under the name
+// authcID if the mechanism has one, may act as authzID.
+func authorize(authzID, authcID, dn string) error {
+	if authzID == "" || authzID == authcID || authzID == "u:"+authcID {
+		return nil
+	}
+	if strings.HasPrefix(authzID, "dn:") {
+		x, err := normalizeDN(strings.TrimPrefix(authzID, "dn:"))
+		y, err2 := normalizeDN(dn)
+		if err == nil && err2 == nil && x.String() == y.String() {
+			return nil
+		}
+	}
+	return ldapError(ldap.InsufficientAccessRights, "not authorized as %q", authzID)
+}
+
+// SASLPlain implements the PLAIN mechanism (RFC 4616). As the password
+// is sent in the clear, clients should only use it over TLS.
+type SASLPlain struct {
+	// Authenticate checks a password, returning the DN of the user
+	// authcID names. MemoryBackend.Authenticate may be used.
+	Authenticate func(authcID, password string) (dn string, err error)
+}
+
+func (m *SASLPlain) Name() string             { return "PLAIN" }
+func (m *SASLPlain) Start(*Conn) SASLExchange { return m }
+
+func (m *SASLPlain) Next(response []byte) ([]byte, string, bool, error) {
+	fields := bytes.Split(response, []byte{0})
+	if len(fields) != 3 || len(fields[1]) == 0 {
+		return nil, "", false, ldapError(ldap.InvalidCredentials, "PLAIN: malformed response")
+	}
+	authzID, authcID, password := string(fields[0]), string(fields[1]), string(fields[2])
+	dn, err := m.Authenticate(authcID, password)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if err := authorize(authzID, authcID, dn); err != nil {
+		return nil, "", false, err
+	}
+	return nil, dn, true, nil
+}
+
+// SASLExternal implements the EXTERNAL mechanism (RFC 4422 appendix A)
+// for clients that present a certificate over TLS.
+type SASLExternal struct {
+	// Identify returns the DN of the client that presented chains, the
+	// certificate chains the server's tls.Config verified. If nil, the
+	// DN is the subject of the client's certificate.
+	Identify func(c *Conn, chains [][]*x509.Certificate) (dn string, err error)
+}
+
+func (m *SASLExternal) Name() string { return "EXTERNAL" }
+
+func (m *SASLExternal) Start(c *Conn) SASLExchange {
+	return &externalExchange{m, c}
+}
+
+type externalExchange struct {
+	*SASLExternal
+	c *Conn
+}
+
+func (x *externalExchange) Next(response []byte) ([]byte, string, bool, error) {
+	state := x.c.TLS()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, "", false, ldapError(ldap.InappropriateAuthentication, "EXTERNAL: no client certificate")
+	}
+	var dn string
+	if x.Identify != nil {
+		var err error
+		if dn, err = x.Identify(x.c, state.VerifiedChains); err != nil {
+			return nil, "", false, err
+		}
+	} else {
+		dn = state.VerifiedChains[0][0].Subject.String()
+	}
+	if err := authorize(string(response), "", dn); err != nil {
+		return nil, "", false, err
+	}
+	return nil, dn, true, nil
+}
+
+// SASLDigestMD5 implements the DIGEST-MD5 mechanism (RFC 2831) for
+// initial authentication, with the auth quality of protection only.
+type SASLDigestMD5 struct {
+	// Realm is offered to clients. It may be empty.
+	Realm string
+	// Password returns the DN and cleartext password of the user
+	// username names. MemoryBackend.Password may be used, for entries
+	// whose userPassword is stored in the clear.
+	Password func(username string) (dn, password string, err error)
+}
+
+func (m *SASLDigestMD5) Name() string { return "DIGEST-MD5" }
+
+func (m *SASLDigestMD5) Start(*Conn) SASLExchange {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return &digestExchange{SASLDigestMD5: m, err: err}
+	}
+	return &digestExchange{SASLDigestMD5: m, nonce: base64.RawStdEncoding.EncodeToString(nonce)}
+}
+
+type digestExchange struct {
+	*SASLDigestMD5
+	nonce      string
+	err        error // making the nonce
+	challenged bool
+}
+
+func (x *digestExchange) Next(response []byte) ([]byte, string, bool, error) {
+	fail := func(format string, args ...interface{}) ([]byte, string, bool, error) {
+		return nil, "", false, ldapError(ldap.InvalidCredentials, "DIGEST-MD5: "+format, args...)
+	}
+	if x.err != nil {
+		return nil, "", false, ldapError(ldap.Other, "DIGEST-MD5: %v", x.err)
+	}
+	if !x.challenged {
+		if len(response) > 0 {
+			return fail("subsequent authentication not supported")
+		}
+		x.challenged = true
+		challenge := fmt.Sprintf(`nonce=%s,qop="auth",charset=utf-8,algorithm=md5-sess`, digestmd5.Quote(x.nonce))
+		if x.Realm != "" {
+			challenge = "realm=" + digestmd5.Quote(x.Realm) + "," + challenge
+		}
+		return []byte(challenge), "", false, nil
+	}
+
+	// RFC 2831 §2.1.2 allows each directive of the response once.
+	d, err := digestmd5.ParseDirectives(string(response))
+	if err != nil {
+		return fail("%v", err)
+	}
+	switch {
+	case d["nonce"] != x.nonce:
+		return fail("wrong nonce")
+	case d["nc"] != "00000001":
+		return fail("wrong nonce count %q", d["nc"])
+	case d["qop"] != "" && d["qop"] != "auth":
+		return fail("unsupported qop %q", d["qop"])
+	case d["realm"] != x.Realm:
+		return fail("wrong realm %q", d["realm"])
+	case !strings.HasPrefix(d["digest-uri"], "ldap/"):
+		return fail("wrong digest-uri %q", d["digest-uri"])
+	case d["username"] == "" || d["cnonce"] == "":
+		return fail("missing username or cnonce")
+	}
+	dn, password, err := x.Password(d["username"])
+	if err != nil {
+		return nil, "", false, err
+	}
+	expected, rspauth := digestmd5.Response(d["username"], d["realm"], password, x.nonce, d["cnonce"], d["authzid"], d["digest-uri"])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(d["response"])) != 1 {
+		return nil, "", false, &ldap.Error{ResultCode: ldap.InvalidCredentials}
+	}
+	if err := authorize(d["authzid"], d["username"], dn); err != nil {
+		return nil, "", false, err
+	}
+	return []byte("rspauth=" + rspauth), dn, true, nil
+}
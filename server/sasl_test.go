@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/internal/digestmd5"
+	"testing"
+)
+
+func TestSASL(t *testing.T) {
+	const alice = "cn=Alice,ou=People,dc=example,dc=com"
+	b := newTestBackend(t)
+	err := b.Modify(nil, &ModifyRequest{DN: alice, Modifications: []ldap.Modification{
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "uid", Values: []string{"alice"}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bindDN string
+	record := Intercept(func(c *Conn, op Operation, next func() error) error {
+		bindDN = c.BindDN()
+		return next()
+	})
+	h := Chain(b, SASL(
+		&SASLPlain{Authenticate: b.Authenticate},
+		&SASLDigestMD5{Realm: "example.com", Password: b.Password},
+		&SASLExternal{},
+	), record)
+	c, stop := startTestServer(t, h)
+	defer stop()
+
+	tests := []struct {
+		mech     ldap.SASLMechanism
+		code     ldap.ResultCode
+		expected string
+	}{
+		{&ldap.SASLPlain{Username: "alice", Password: "secret"}, ldap.Success, alice},
+		{&ldap.SASLPlain{Username: "dn:" + alice, Password: "secret", AuthzID: "dn:CN=alice,ou=people,dc=example,dc=com"}, ldap.Success, alice},
+		{&ldap.SASLPlain{Username: "alice", Password: "wrong"}, ldap.InvalidCredentials, ""},
+		{&ldap.SASLPlain{Username: "alice", Password: "secret", AuthzID: "u:bob"}, ldap.InsufficientAccessRights, ""},
+		{&ldap.SASLDigestMD5{Username: "alice", Password: "secret", Host: "localhost"}, ldap.Success, alice},
+		{&ldap.SASLDigestMD5{Username: "alice", Password: "secret", Host: "localhost", AuthzID: "u:alice"}, ldap.Success, alice},
+		{&ldap.SASLDigestMD5{Username: "alice", Password: "wrong", Host: "localhost"}, ldap.InvalidCredentials, ""},
+		{&ldap.SASLDigestMD5{Username: "alice", Password: "secret", Host: "localhost", Realm: "example.org"}, ldap.InvalidCredentials, ""},
+		{&ldap.SASLExternal{}, ldap.InappropriateAuthentication, ""},
+		{&ldap.SASLGSSSPNEGO{}, ldap.AuthMethodNotSupported, ""},
+	}
+	for i, test := range tests {
+		code := ldap.Success
+		if err := c.SASLBind(test.mech); err != nil {
+			code = resultCode(err)
+		}
+		if code != test.code {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, code, test.code)
+			continue
+		}
+		c.Compare(alice, "cn", "Alice")
+		if bindDN != test.expected {
+			t.Errorf("#%d: Bad bind DN: %q (expected %q)", i, bindDN, test.expected)
+		}
+	}
+}
+
+func TestSASLDigestMD5Hashed(t *testing.T) {
+	const bob = "cn=Bob,ou=People,dc=example,dc=com"
+	b := newTestBackend(t)
+	hashed, err := ldap.HashPassword(ldap.PasswordSSHA, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Modify(nil, &ModifyRequest{DN: bob, Modifications: []ldap.Modification{
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "uid", Values: []string{"bob"}}},
+		{Operation: ldap.AddValues, Attribute: ldap.Attribute{Type: "userPassword", Values: []string{hashed}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Chain(b, SASL(
+		&SASLPlain{Authenticate: b.Authenticate},
+		&SASLDigestMD5{Password: b.Password},
+	))
+	c, stop := startTestServer(t, h)
+	defer stop()
+
+	tests := []struct {
+		mech ldap.SASLMechanism
+		code ldap.ResultCode
+	}{
+		{&ldap.SASLPlain{Username: "bob", Password: "secret"}, ldap.Success},
+		{&ldap.SASLDigestMD5{Username: "bob", Password: "secret", Host: "localhost"}, ldap.InappropriateAuthentication},
+		{&ldap.SASLDigestMD5{Username: "bob", Password: hashed, Host: "localhost"}, ldap.InappropriateAuthentication},
+	}
+	for i, test := range tests {
+		code := ldap.Success
+		if err := c.SASLBind(test.mech); err != nil {
+			code = resultCode(err)
+		}
+		if code != test.code {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, code, test.code)
+		}
+	}
+}
+
+func TestSASLDigestMD5Directives(t *testing.T) {
+	const alice = "cn=Alice,ou=People,dc=example,dc=com"
+	m := &SASLDigestMD5{Password: func(username string) (string, string, error) {
+		return alice, "secret", nil
+	}}
+	for i, repeat := range []string{"", `,nonce="other"`, `,username="bob"`, `,response=0`, `,cnonce="x"`} {
+		x := m.Start(nil)
+		challenge, _, _, err := x.Next(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := digestmd5.ParseDirectives(string(challenge))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, _ := digestmd5.Response("alice", "", "secret", d["nonce"], "abc", "", "ldap/localhost")
+		r := fmt.Sprintf(`username="alice",realm="",nonce=%s,nc=00000001,cnonce="abc",digest-uri="ldap/localhost",response=%s,qop=auth`,
+			digestmd5.Quote(d["nonce"]), response)
+		_, dn, done, err := x.Next([]byte(r + repeat))
+		if repeat == "" {
+			if err != nil || !done || dn != alice {
+				t.Errorf("#%d: Bad result: %q, %v, %v", i, dn, done, err)
+			}
+		} else if resultCode(err) != ldap.InvalidCredentials {
+			t.Errorf("#%d: Bad result for %s: %v (expected %v)", i, repeat, err, ldap.InvalidCredentials)
+		}
+	}
+}
+
+func TestSASLExternal(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	var bindDN string
+	record := Intercept(func(c *Conn, op Operation, next func() error) error {
+		bindDN = c.BindDN()
+		return next()
+	})
+	s := &Server{Handler: Chain(newTestBackend(t), SASL(&SASLExternal{}), record), TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{testCert(t, "server", &ca)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	}}
+	addr, stop := serveTestServer(t, s)
+	defer stop()
+
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	err = c.StartTLS(&tls.Config{ServerName: "127.0.0.1", RootCAs: pool, Certificates: []tls.Certificate{testCert(t, "alice", &ca)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ExternalBind("dn:cn=bob"); resultCode(err) != ldap.InsufficientAccessRights {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.InsufficientAccessRights)
+	}
+	if err := c.ExternalBind(""); err != nil {
+		t.Fatal(err)
+	}
+	c.Compare("cn=Alice,ou=People,dc=example,dc=com", "cn", "Alice")
+	if bindDN != "CN=alice" {
+		t.Errorf("Bad bind DN: %q (expected %q)", bindDN, "CN=alice")
+	}
+}
@@ -0,0 +1,960 @@
+// Package server implements an LDAP server. A Server decodes the
+// requests of each connection into the types the client package uses
+// and passes them to a Handler, from which custom directory frontends
+// and test doubles can be built in pure Go.
+//
+// MemoryBackend keeps a directory in memory, and FileBackend persists
+// one in an LDIF journal, loading it into memory when opened. KVBackend
+// keeps the entries and the postings of its equality and presence
+// indexes in a key-value store on disk, reading them as needed.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/asn1"
+	"github.com/stesla/ldap/schema"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+// or Shutdown.
+var ErrServerClosed = errors.New("ldap: server closed")
+
+// shutdownPollInterval is how often Shutdown checks for connections
+// that have finished their operations.
+var shutdownPollInterval = 10 * time.Millisecond
+
+// DefaultMaxRequestSize is the limit on the length of a request of a
+// Server whose MaxRequestSize is zero.
+const DefaultMaxRequestSize = 4 << 20
+
+type Server struct {
+	// Addr is the address ListenAndServe and ListenAndServeTLS listen
+	// on: ":389" or ":636" if empty.
+	Addr    string
+	Handler Handler
+	// TLSConfig configures ServeTLS and ListenAndServeTLS, and StartTLS
+	// (RFC 4511 §4.14), which is refused if it is nil. To receive
+	// client certificates, set its ClientAuth and ClientCAs.
+	TLSConfig *tls.Config
+	// SizeLimit and TimeLimit, if not zero, cap the limits clients
+	// request for searches.
+	SizeLimit int
+	TimeLimit time.Duration
+	// MaxConns, if not zero, limits the connections served at once.
+	// Those over the limit are sent a Notice of Disconnection with
+	// ldap.Busy and closed.
+	MaxConns int
+	// OperationTimeout, if not zero, limits the time handlers have for
+	// operations other than searches, whose time TimeLimit limits.
+	// Their contexts are canceled when it passes, and if they return
+	// the context's error the client gets ldap.TimeLimitExceeded.
+	OperationTimeout time.Duration
+	// MaxRequestSize limits the length of a request in bytes:
+	// DefaultMaxRequestSize if zero, none if negative. A client that
+	// sends a longer one is sent a Notice of Disconnection with
+	// ldap.ProtocolError before it is read.
+	MaxRequestSize int
+	// MaxFilterDepth, if not zero, limits how deeply the and, or and not
+	// filters of a search may nest; deeper ones fail with
+	// ldap.ProtocolError.
+	MaxFilterDepth int
+	// IdleTimeout, if not zero, is how long a connection with no
+	// outstanding operations may go without a request before it is sent
+	// a Notice of Disconnection with ldap.Unavailable and closed.
+	IdleTimeout time.Duration
+	// Schema, if set, is published in the subschema subentry
+	// SubschemaDN.
+	Schema *schema.Schema
+	// ErrorLog receives errors from connections, such as undecodable
+	// requests and handler panics. If nil the log package's standard
+	// logger is used.
+	ErrorLog *log.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[*Conn]bool
+	closed    bool
+	draining  bool // by Shutdown
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) maxRequestSize() int {
+	if s.MaxRequestSize == 0 {
+		return DefaultMaxRequestSize
+	}
+	return s.MaxRequestSize
+}
+
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":389"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS is like ListenAndServe, but serves LDAPS with the
+// given certificate, or with s.TLSConfig if both file names are empty.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":636"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+// ServeTLS is like Serve, but wraps the connections accepted from l in
+// TLS, using the given certificate, or s.TLSConfig if both file names
+// are empty.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return s.Serve(tls.NewListener(l, config))
+}
+
+// Serve accepts connections from l and serves each in its own
+// goroutine, until l fails or the server is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]bool{}
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(nc)
+	}
+}
+
+// ServeConn serves a single connection, returning when it closes.
+func (s *Server) ServeConn(nc net.Conn) {
+	c, err := s.newConn(nc)
+	if err == errTooManyConns {
+		c.disconnect(ldap.Busy, err.Error())
+	}
+	if err != nil {
+		nc.Close()
+		return
+	}
+	c.serve()
+}
+
+// Close closes the server's listeners and connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	listeners := s.listeners
+	conns := s.conns
+	s.listeners, s.conns = nil, nil
+	s.mu.Unlock()
+
+	for l := range listeners {
+		l.Close()
+	}
+	for c := range conns {
+		c.Close()
+	}
+	return nil
+}
+
+// Shutdown shuts the server down gracefully. It closes the listeners,
+// and then each connection once its outstanding operations have
+// finished, sending it a Notice of Disconnection with ldap.Unavailable;
+// requests that arrive in the meantime are not performed. It returns
+// once all connections have closed, or if ctx is done first, closes
+// the rest as Close does and returns the context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed, s.draining = true, true
+	listeners := s.listeners
+	s.listeners = nil
+	s.mu.Unlock()
+	for l := range listeners {
+		l.Close()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		conns := make([]*Conn, 0, len(s.conns))
+		for c := range s.conns {
+			conns = append(conns, c)
+		}
+		s.mu.Unlock()
+		if len(conns) == 0 {
+			return nil
+		}
+		for _, c := range conns {
+			c.closeIfIdle("server shutting down")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Server) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// A Conn is a client connection to the server.
+type Conn struct {
+	server *Server
+	rwc    net.Conn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // outstanding operations
+	wmu    sync.Mutex     // serializes writes
+	idle   *time.Timer    // nil without an IdleTimeout
+
+	mu      sync.Mutex
+	tls     *tls.ConnectionState
+	bindDN  string
+	ops     map[int]*operation
+	active  int  // outstanding operations
+	closing bool // by closeIfIdle
+	onClose []func()
+}
+
+var errTooManyConns = errors.New("too many connections")
+
+// newConn returns a connection for nc, which fails with ErrServerClosed
+// if the server is closed, or with errTooManyConns if it is serving
+// MaxConns connections already.
+func (s *Server) newConn(nc net.Conn) (*Conn, error) {
+	c := &Conn{server: s, rwc: nc, ops: map[int]*operation{}}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrServerClosed
+	}
+	if s.MaxConns > 0 && len(s.conns) >= s.MaxConns {
+		return c, errTooManyConns
+	}
+	if s.conns == nil {
+		s.conns = map[*Conn]bool{}
+	}
+	s.conns[c] = true
+	return c, nil
+}
+
+func (c *Conn) RemoteAddr() net.Addr { return c.netConn().RemoteAddr() }
+func (c *Conn) LocalAddr() net.Addr  { return c.netConn().LocalAddr() }
+
+func (c *Conn) netConn() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rwc
+}
+
+// TLS returns the state of the connection's TLS session. A connection
+// has one if it was accepted by ServeTLS or upgraded by StartTLS;
+// otherwise TLS returns nil.
+func (c *Conn) TLS() *tls.ConnectionState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tls
+}
+
+// BindDN returns the DN the connection is bound as, or "" if it is
+// anonymous, including after an unauthenticated bind.
+func (c *Conn) BindDN() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bindDN
+}
+
+func (c *Conn) SetBindDN(dn string) {
+	c.mu.Lock()
+	c.bindDN = dn
+	c.mu.Unlock()
+}
+
+// OnClose registers fn to be called once the connection has closed and
+// its outstanding operations have finished, e.g. to release state a
+// handler keeps for the connection.
+func (c *Conn) OnClose(fn func()) {
+	c.mu.Lock()
+	c.onClose = append(c.onClose, fn)
+	c.mu.Unlock()
+}
+
+// Close closes the connection, canceling its outstanding operations.
+func (c *Conn) Close() error {
+	c.cancel()
+	return c.netConn().Close()
+}
+
+// begin records the start of an operation, unless the connection is
+// being closed or the server shut down.
+func (c *Conn) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing || c.server.isDraining() {
+		return false
+	}
+	c.active++
+	c.wg.Add(1)
+	return true
+}
+
+// end records the end of an operation.
+func (c *Conn) end() {
+	c.mu.Lock()
+	if c.active--; c.active == 0 && c.idle != nil {
+		c.idle.Reset(c.server.IdleTimeout)
+	}
+	c.mu.Unlock()
+	c.wg.Done()
+}
+
+// closeIfIdle sends a Notice of Disconnection and closes the connection
+// if it has no outstanding operations.
+func (c *Conn) closeIfIdle(msg string) {
+	c.mu.Lock()
+	idle := c.active == 0 && !c.closing
+	if idle {
+		c.closing = true
+	}
+	c.mu.Unlock()
+	if idle {
+		c.disconnect(ldap.Unavailable, msg)
+		c.Close()
+	}
+}
+
+func (c *Conn) serve() {
+	defer func() {
+		// A panic reading a request takes down only its connection.
+		if r := recover(); r != nil {
+			c.server.logf("ldap: %v: panic serving connection: %v\n%s", c.RemoteAddr(), r, debug.Stack())
+		}
+		c.Close()
+		c.wg.Wait()
+		c.server.mu.Lock()
+		delete(c.server.conns, c)
+		c.server.mu.Unlock()
+
+		c.mu.Lock()
+		onClose := c.onClose
+		c.onClose = nil
+		c.mu.Unlock()
+		for _, fn := range onClose {
+			fn()
+		}
+	}()
+
+	if tc, ok := c.rwc.(*tls.Conn); ok {
+		if err := c.handshake(tc); err != nil {
+			c.server.logf("ldap: %v: TLS handshake: %v", c.RemoteAddr(), err)
+			return
+		}
+	}
+
+	if d := c.server.IdleTimeout; d > 0 {
+		c.idle = time.AfterFunc(d, func() { c.closeIfIdle("idle timeout") })
+		defer c.idle.Stop()
+	}
+
+	// Requests are framed by a MessageReader, which grows its buffer as
+	// the bytes arrive rather than trusting the length a client claims.
+	mr := asn1.NewMessageReader(c.rwc)
+	mr.MaxSize = c.server.maxRequestSize()
+	for {
+		b, err := mr.ReadMessage()
+		if err == asn1.ErrMessageTooLarge {
+			c.disconnect(ldap.ProtocolError, "request too large")
+			return
+		} else if err != nil {
+			if err != io.EOF && c.ctx.Err() == nil {
+				c.server.logf("ldap: %v: reading request: %v", c.RemoteAddr(), err)
+				c.disconnect(ldap.ProtocolError, "undecodable message")
+			}
+			return
+		}
+		var raw asn1.RawValue
+		msg := ldapMessage{ProtocolOp: &raw}
+		if err := decodeValue(b, &msg); err != nil {
+			c.server.logf("ldap: %v: reading request: %v", c.RemoteAddr(), err)
+			c.disconnect(ldap.ProtocolError, "undecodable message")
+			return
+		}
+		if raw.Class != asn1.ClassApplication {
+			c.disconnect(ldap.ProtocolError, "unexpected protocol operation")
+			return
+		}
+		if c.idle != nil {
+			c.idle.Reset(c.server.IdleTimeout)
+		}
+		// A bind changes the identity of later operations, and no other
+		// operations may be outstanding while TLS is negotiated, so
+		// these wait for the operations before them.
+		if raw.Tag == opBindRequest || raw.Tag == opExtendedRequest && isStartTLS(raw) {
+			c.wg.Wait()
+		}
+		// Once the server is shutting down, no more operations are
+		// started; those outstanding are let finish.
+		if raw.Tag != opUnbindRequest && raw.Tag != opAbandonRequest && !c.begin() {
+			c.wg.Wait()
+			c.disconnect(ldap.Unavailable, "server shutting down")
+			return
+		}
+
+		req := Request{MessageID: msg.MessageId}
+		for _, ctl := range msg.Controls {
+			req.Controls = append(req.Controls, &Control{string(ctl.Type), ctl.Criticality, ctl.Value})
+		}
+
+		switch raw.Tag {
+		case opUnbindRequest:
+			return
+		case opAbandonRequest:
+			var id int
+			if err := decodeOp(raw, &id); err == nil {
+				c.abandon(id)
+			}
+		case opBindRequest:
+			c.handle(req, raw)
+		case opExtendedRequest:
+			if id, ok := isCancel(raw); ok {
+				go func() {
+					defer c.end()
+					c.cancelOp(req, id)
+				}()
+				break
+			}
+			if !isStartTLS(raw) {
+				go c.handle(req, raw)
+				break
+			}
+			ok := c.startTLS(req)
+			c.end()
+			if !ok {
+				return
+			}
+			mr = asn1.NewMessageReader(c.netConn())
+			mr.MaxSize = c.server.maxRequestSize()
+		case opSearchRequest, opModifyRequest, opAddRequest, opDelRequest,
+			opModifyDNRequest, opCompareRequest:
+			go c.handle(req, raw)
+		default:
+			c.end()
+			c.disconnect(ldap.ProtocolError, "unexpected protocol operation")
+			return
+		}
+	}
+}
+
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+func isStartTLS(raw asn1.RawValue) bool {
+	var r extendedRequest
+	return decodeOp(raw, &r) == nil && string(r.Name) == oidStartTLS
+}
+
+// startTLS performs the StartTLS operation of req, returning whether the
+// connection may go on.
+func (c *Conn) startTLS(req Request) bool {
+	respond := func(err error) bool {
+		resp := extendedResponse{Result: result(err), Name: []byte(oidStartTLS)}
+		return c.write(req.MessageID, opExtendedResponse, resp, nil) == nil
+	}
+	config := c.server.TLSConfig
+	switch {
+	case config == nil:
+		return respond(ldapError(ldap.ProtocolError, "StartTLS not supported"))
+	case c.TLS() != nil:
+		return respond(ldapError(ldap.OperationsError, "TLS already established"))
+	}
+	if !respond(nil) {
+		return false
+	}
+
+	c.wmu.Lock()
+	c.mu.Lock()
+	tc := tls.Server(c.rwc, config.Clone())
+	c.rwc = tc
+	c.mu.Unlock()
+	c.wmu.Unlock()
+	if err := c.handshake(tc); err != nil {
+		c.server.logf("ldap: %v: StartTLS handshake: %v", c.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
+func (c *Conn) handshake(tc *tls.Conn) error {
+	if err := tc.HandshakeContext(c.ctx); err != nil {
+		return err
+	}
+	state := tc.ConnectionState()
+	c.mu.Lock()
+	c.tls = &state
+	c.mu.Unlock()
+	return nil
+}
+
+// An operation is a request being handled, which may be abandoned or
+// canceled.
+type operation struct {
+	cancel   context.CancelFunc
+	done     chan struct{}
+	canceled bool // by the Cancel operation, so a response is due
+	stopped  bool // answered with canceled
+}
+
+func (c *Conn) abandon(id int) {
+	c.mu.Lock()
+	op := c.ops[id]
+	c.mu.Unlock()
+	if op != nil {
+		op.cancel()
+	}
+}
+
+const oidCancel = "1.3.6.1.1.8"
+
+func isCancel(raw asn1.RawValue) (int, bool) {
+	var r extendedRequest
+	if decodeOp(raw, &r) != nil || string(r.Name) != oidCancel {
+		return 0, false
+	}
+	var v struct{ CancelID int }
+	if err := decodeValue(r.Value, &v); err != nil {
+		return -1, true
+	}
+	return v.CancelID, true
+}
+
+// cancelOp performs the Cancel operation of req (RFC 3909): the
+// operation with id is stopped and answered with canceled, and only then
+// is the Cancel answered.
+func (c *Conn) cancelOp(req Request, id int) {
+	c.mu.Lock()
+	op := c.ops[id]
+	if op != nil {
+		op.canceled = true
+	}
+	c.mu.Unlock()
+
+	var err error
+	switch {
+	case id < 0:
+		err = ldapError(ldap.ProtocolError, "malformed cancel request")
+	case op == nil:
+		err = ldapError(ldap.NoSuchOperation, "no operation with message ID %d", id)
+	default:
+		op.cancel()
+		<-op.done
+		if !op.stopped {
+			err = ldapError(ldap.TooLate, "operation %d completed", id)
+		}
+	}
+	if c.write(req.MessageID, opExtendedResponse, extendedResponse{Result: result(err)}, nil) != nil {
+		c.Close()
+	}
+}
+
+// handle performs one operation and sends its response.
+func (c *Conn) handle(req Request, raw asn1.RawValue) {
+	defer c.end()
+
+	op := &operation{done: make(chan struct{})}
+	req.ctx, op.cancel = context.WithCancel(c.ctx)
+	c.mu.Lock()
+	c.ops[req.MessageID] = op
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.ops, req.MessageID)
+		c.mu.Unlock()
+		op.cancel()
+		close(op.done)
+	}()
+
+	ctx := req.ctx
+	if d := c.server.OperationTimeout; d > 0 && raw.Tag != opSearchRequest {
+		var cancel context.CancelFunc
+		req.ctx, cancel = context.WithTimeout(req.ctx, d)
+		defer cancel()
+	}
+	respTag, resp := c.dispatch(&req, raw)
+	if ctx.Err() != nil {
+		c.mu.Lock()
+		op.stopped = op.canceled && c.ctx.Err() == nil
+		c.mu.Unlock()
+		// Abandoned operations get no response, canceled ones are
+		// answered with canceled.
+		if !op.stopped {
+			return
+		}
+		r := ldapResult{ResultCode: ldap.Canceled, MatchedDN: []byte{}, Message: []byte{}}
+		if resp = r; respTag == opExtendedResponse {
+			resp = extendedResponse{Result: r}
+		}
+	}
+	if err := c.write(req.MessageID, respTag, resp, req.ResponseControls); err != nil {
+		c.Close()
+	}
+}
+
+// responseTags maps each request to the tag of its response.
+var responseTags = map[int]int{
+	opBindRequest:     opBindResponse,
+	opSearchRequest:   opSearchResultDone,
+	opModifyRequest:   opModifyResponse,
+	opAddRequest:      opAddResponse,
+	opDelRequest:      opDelResponse,
+	opModifyDNRequest: opModifyDNResponse,
+	opCompareRequest:  opCompareResponse,
+	opExtendedRequest: opExtendedResponse,
+}
+
+// dispatch decodes the request in raw and calls the handler, returning
+// the response to send.
+func (c *Conn) dispatch(req *Request, raw asn1.RawValue) (tag int, resp interface{}) {
+	tag = responseTags[raw.Tag]
+	defer func() {
+		if r := recover(); r != nil {
+			c.server.logf("ldap: %v: panic serving message %d: %v\n%s", c.RemoteAddr(), req.MessageID, r, debug.Stack())
+			resp = result(&ldap.Error{ResultCode: ldap.Other, DiagnosticMessage: "internal error"})
+		}
+	}()
+
+	h := c.server.Handler
+	if h == nil {
+		h = BaseHandler{}
+	}
+	protocolError := func(err error) interface{} {
+		return result(&ldap.Error{ResultCode: ldap.ProtocolError, DiagnosticMessage: err.Error()})
+	}
+
+	switch raw.Tag {
+	case opBindRequest:
+		var r bindRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		bind := &BindRequest{Request: *req, Version: r.Version, Name: string(r.Name)}
+		if state := c.TLS(); state != nil {
+			bind.VerifiedChains = state.VerifiedChains
+		}
+		switch r.Auth.Tag {
+		case 0:
+			bind.Password = string(r.Auth.Bytes)
+		case 3:
+			var creds saslCredentials
+			if err := decodeValue(r.Auth.RawBytes, asn1.OptionValue{Opts: "tag:3", Value: &creds}); err != nil {
+				return tag, protocolError(err)
+			}
+			bind.SASL = &SASLCredentials{string(creds.Mechanism), creds.Credentials}
+		default:
+			return tag, result(&ldap.Error{ResultCode: ldap.AuthMethodNotSupported})
+		}
+
+		c.SetBindDN("")
+		err := h.Bind(c, bind)
+		// An unauthenticated bind, with a name but no password, proves
+		// nothing, so the client stays anonymous.
+		if err == nil && bind.SASL == nil && bind.Password != "" {
+			c.SetBindDN(bind.Name)
+		}
+		*req = bind.Request
+		return tag, bindResponse{result(err), bind.ServerSASLCredentials}
+
+	case opSearchRequest:
+		var r searchRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		if r.Deref < ldap.NeverDerefAliases || r.Deref > ldap.DerefAlways {
+			return tag, protocolError(fmt.Errorf("invalid derefAliases %d", r.Deref))
+		}
+		filter, err := ldap.DecodeFilterMaxDepth(r.Filter.RawBytes, c.server.MaxFilterDepth)
+		if err != nil {
+			return tag, protocolError(err)
+		}
+		search := &SearchRequest{Request: *req, SearchRequest: ldap.SearchRequest{
+			BaseObject: r.BaseObject,
+			Scope:      r.Scope,
+			Deref:      r.Deref,
+			SizeLimit:  limit(r.SizeLimit, c.server.SizeLimit),
+			TimeLimit:  r.TimeLimit,
+			TypesOnly:  r.TypesOnly,
+			Filter:     filter,
+			Attributes: r.Attributes,
+		}}
+		timeLimit := time.Duration(r.TimeLimit) * time.Second
+		if max := c.server.TimeLimit; max > 0 && (timeLimit <= 0 || timeLimit > max) {
+			timeLimit = max
+		}
+		if timeLimit > 0 {
+			search.TimeLimit = int((timeLimit + time.Second - 1) / time.Second)
+			var cancel context.CancelFunc
+			search.ctx, cancel = context.WithTimeout(search.ctx, timeLimit)
+			defer cancel()
+		}
+		w := &searchWriter{c: c, req: search}
+		// The server publishes the root DSE and the subschema subentry
+		// itself.
+		if e := c.published(search); e != nil {
+			err = searchPublished(e, search, w)
+		} else {
+			err = h.Search(c, search, w)
+		}
+		*req = search.Request
+		return tag, result(err)
+
+	case opModifyRequest:
+		var r modifyRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		modify := &ModifyRequest{Request: *req, DN: string(r.Object)}
+		for _, ch := range r.Changes {
+			modify.Modifications = append(modify.Modifications, ldap.Modification{
+				Operation: ch.Operation, Attribute: decodeAttribute(ch.Modification)})
+		}
+		err := h.Modify(c, modify)
+		*req = modify.Request
+		return tag, result(err)
+
+	case opAddRequest:
+		var r addRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		add := &AddRequest{Request: *req, DN: string(r.Entry)}
+		for _, pa := range r.Attributes {
+			add.Attributes = append(add.Attributes, decodeAttribute(pa))
+		}
+		err := h.Add(c, add)
+		*req = add.Request
+		return tag, result(err)
+
+	case opDelRequest:
+		var dn []byte
+		if err := decodeOp(raw, &dn); err != nil {
+			return tag, protocolError(err)
+		}
+		del := &DeleteRequest{Request: *req, DN: string(dn)}
+		err := h.Delete(c, del)
+		*req = del.Request
+		return tag, result(err)
+
+	case opModifyDNRequest:
+		var r modifyDNRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		moddn := &ModifyDNRequest{Request: *req, DN: string(r.Entry), NewRDN: string(r.NewRDN),
+			DeleteOldRDN: r.DeleteOldRDN, NewSuperior: string(r.NewSuperior)}
+		err := h.ModifyDN(c, moddn)
+		*req = moddn.Request
+		return tag, result(err)
+
+	case opCompareRequest:
+		var r compareRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		compare := &CompareRequest{Request: *req, DN: string(r.Entry),
+			Attribute: string(r.Ava.Attribute), Value: string(r.Ava.Value)}
+		ok, err := h.Compare(c, compare)
+		*req = compare.Request
+		if err == nil {
+			code := ldap.CompareFalse
+			if ok {
+				code = ldap.CompareTrue
+			}
+			return tag, ldapResult{ResultCode: code, MatchedDN: []byte{}, Message: []byte{}}
+		}
+		return tag, result(err)
+
+	case opExtendedRequest:
+		var r extendedRequest
+		if err := decodeOp(raw, &r); err != nil {
+			return tag, protocolError(err)
+		}
+		ext := &ExtendedRequest{Request: *req, Name: string(r.Name), Value: r.Value}
+		resp, err := h.Extended(c, ext)
+		*req = ext.Request
+		out := extendedResponse{Result: result(err)}
+		if resp != nil {
+			out.Name, out.Value = optionalBytes(resp.Name), resp.Value
+		}
+		return tag, out
+	}
+	return tag, protocolError(errors.New("unexpected protocol operation"))
+}
+
+// limit returns the lower of a requested limit and the server's, where
+// zero means no limit.
+func limit(requested, max int) int {
+	if max > 0 && (requested <= 0 || requested > max) {
+		return max
+	}
+	return requested
+}
+
+// result converts the error returned by a handler into an LDAPResult.
+func result(err error) ldapResult {
+	r := ldapResult{MatchedDN: []byte{}, Message: []byte{}}
+	if err == nil {
+		return r
+	}
+	var e *ldap.Error
+	if errors.Is(err, context.DeadlineExceeded) {
+		e = &ldap.Error{ResultCode: ldap.TimeLimitExceeded}
+	} else if !errors.As(err, &e) {
+		e = &ldap.Error{ResultCode: ldap.Other, DiagnosticMessage: err.Error()}
+	}
+	r.ResultCode = e.ResultCode
+	r.MatchedDN = []byte(e.MatchedDN)
+	r.Message = []byte(e.DiagnosticMessage)
+	for _, url := range e.Referrals {
+		r.Referral = append(r.Referral, []byte(url))
+	}
+	return r
+}
+
+func optionalBytes(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
+
+func (c *Conn) write(id, tag int, op interface{}, controls []ldap.Control) error {
+	msg := ldapMessage{MessageId: id, ProtocolOp: asn1.OptionValue{Opts: application(tag), Value: op}}
+	for _, ctl := range controls {
+		value, err := ctl.ControlValue()
+		if err != nil {
+			return err
+		}
+		msg.Controls = append(msg.Controls, control{[]byte(ctl.ControlType()), ctl.Critical(), value})
+	}
+
+	var buf bytes.Buffer
+	enc := asn1.NewEncoder(&buf)
+	enc.Implicit = true
+	if err := enc.Encode(msg); err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.netConn().Write(buf.Bytes())
+	return err
+}
+
+const oidNoticeOfDisconnection = "1.3.6.1.4.1.1466.20036"
+
+// disconnect sends a Notice of Disconnection (RFC 4511 §4.4.1).
+func (c *Conn) disconnect(code ldap.ResultCode, msg string) {
+	r := result(&ldap.Error{ResultCode: code, DiagnosticMessage: msg})
+	c.write(0, opExtendedResponse, extendedResponse{Result: r, Name: []byte(oidNoticeOfDisconnection)}, nil)
+}
+
+type searchWriter struct {
+	c       *Conn
+	req     *SearchRequest
+	entries int
+}
+
+// WriteEntry fails with ldap.SizeLimitExceeded once the search's size
+// limit is reached, and with the context's error once it is done.
+func (w *searchWriter) WriteEntry(e *ldap.Entry, controls ...ldap.Control) error {
+	if err := w.req.Context().Err(); err != nil {
+		return err
+	}
+	if w.req.SizeLimit > 0 && w.entries >= w.req.SizeLimit {
+		return &ldap.Error{ResultCode: ldap.SizeLimitExceeded}
+	}
+	w.entries++
+	entry := searchResultEntry{Name: []byte(e.DN), Attributes: []partialAttribute{}}
+	for _, a := range e.Attributes {
+		pa := partialAttribute{Type: []byte(a.Name), Values: [][]byte{}}
+		for _, v := range a.Values {
+			pa.Values = append(pa.Values, []byte(v))
+		}
+		entry.Attributes = append(entry.Attributes, pa)
+	}
+	return w.c.write(w.req.MessageID, opSearchResultEntry, entry, controls)
+}
+
+func (w *searchWriter) WriteReference(urls ...string) error {
+	if err := w.req.Context().Err(); err != nil {
+		return err
+	}
+	ref := make([][]byte, len(urls))
+	for i, url := range urls {
+		ref[i] = []byte(url)
+	}
+	return w.c.write(w.req.MessageID, opSearchResultReference, ref, nil)
+}
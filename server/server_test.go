@@ -0,0 +1,623 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/stesla/ldap"
+	"github.com/stesla/ldap/asn1"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testHandler struct {
+	BaseHandler
+	mu      sync.Mutex
+	entries map[string]*ldap.Entry
+}
+
+// Bind accepts cn=admin with its password, or without one as an
+// unauthenticated bind.
+func (h *testHandler) Bind(c *Conn, req *BindRequest) error {
+	if req.Name == "cn=admin" && (req.Password == "secret" || req.Password == "") {
+		return nil
+	}
+	return &ldap.Error{ResultCode: ldap.InvalidCredentials}
+}
+
+func (h *testHandler) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range h.entries {
+		ok, err := ldap.FilterMatches(req.Filter, e)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err := w.WriteEntry(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *testHandler) Add(c *Conn, req *AddRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.entries[req.DN]; ok {
+		return &ldap.Error{ResultCode: ldap.EntryAlreadyExists}
+	}
+	attrs := map[string][]string{}
+	for _, a := range req.Attributes {
+		attrs[a.Type] = a.Values
+	}
+	h.entries[req.DN] = ldap.NewEntry(req.DN, attrs)
+	return nil
+}
+
+func (h *testHandler) Modify(c *Conn, req *ModifyRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[req.DN]
+	if !ok {
+		return &ldap.Error{ResultCode: ldap.NoSuchObject}
+	}
+	attrs := e.AttributeMap()
+	for _, m := range req.Modifications {
+		if m.Operation != ldap.ReplaceValues {
+			return unwilling()
+		}
+		attrs[m.Type] = m.Values
+	}
+	h.entries[req.DN] = ldap.NewEntry(req.DN, attrs)
+	return nil
+}
+
+func (h *testHandler) Delete(c *Conn, req *DeleteRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.entries[req.DN]; !ok {
+		return &ldap.Error{ResultCode: ldap.NoSuchObject}
+	}
+	delete(h.entries, req.DN)
+	return nil
+}
+
+func (h *testHandler) Compare(c *Conn, req *CompareRequest) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.entries[req.DN]
+	if !ok {
+		return false, &ldap.Error{ResultCode: ldap.NoSuchObject}
+	}
+	for _, v := range e.GetAttributeValues(req.Attribute) {
+		if v == req.Value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (h *testHandler) Extended(c *Conn, req *ExtendedRequest) (*ExtendedResponse, error) {
+	if req.Name == "1.3.6.1.4.1.4203.1.11.3" {
+		var authzID string
+		if dn := c.BindDN(); dn != "" {
+			authzID = "dn:" + dn
+		}
+		return &ExtendedResponse{Value: []byte(authzID)}, nil
+	}
+	return h.BaseHandler.Extended(c, req)
+}
+
+// serveTest serves h on a local port, returning its address and a
+// function that stops it.
+func serveTest(t *testing.T, h Handler) (string, func()) {
+	return serveTestServer(t, &Server{Handler: h})
+}
+
+func serveTestServer(t *testing.T, s *Server) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	return l.Addr().String(), func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Bad Serve result: %v (expected %v)", err, ErrServerClosed)
+		}
+	}
+}
+
+func startTestServer(t *testing.T, h Handler) (ldap.Conn, func()) {
+	addr, stop := serveTest(t, h)
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close()
+		stop()
+	}
+}
+
+func resultCode(err error) ldap.ResultCode {
+	var e *ldap.Error
+	if errors.As(err, &e) {
+		return e.ResultCode
+	}
+	return -1
+}
+
+func searchDNs(t *testing.T, c ldap.Conn, filter string) []string {
+	f, err := ldap.CompileFilter(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: f})
+	if err != nil {
+		t.Fatalf("%s: %v", filter, err)
+	}
+	dns := []string{}
+	for _, r := range results {
+		dns = append(dns, r.DN)
+	}
+	sort.Strings(dns)
+	return dns
+}
+
+func TestServer(t *testing.T) {
+	h := &testHandler{entries: map[string]*ldap.Entry{}}
+	c, stop := startTestServer(t, h)
+	defer stop()
+
+	if err := c.Bind("cn=admin", "wrong"); resultCode(err) != ldap.InvalidCredentials {
+		t.Errorf("Bad bind result: %v (expected %v)", err, ldap.InvalidCredentials)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "" {
+		t.Errorf("Bad WhoAmI result: %q, %v (expected \"\")", id, err)
+	}
+	// An unauthenticated bind leaves the client anonymous.
+	if err := c.Bind("cn=admin", ""); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "" {
+		t.Errorf("Bad WhoAmI result after unauthenticated bind: %q, %v (expected \"\")", id, err)
+	}
+	if err := c.Bind("cn=admin", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := c.WhoAmI(); err != nil || id != "dn:cn=admin" {
+		t.Errorf("Bad WhoAmI result: %q, %v (expected \"dn:cn=admin\")", id, err)
+	}
+
+	for _, cn := range []string{"alice", "bob", "carol"} {
+		err := c.Add("cn="+cn+",dc=example", []ldap.Attribute{
+			{Type: "objectClass", Values: []string{"person"}},
+			{Type: "cn", Values: []string{cn}},
+			{Type: "sn", Values: []string{strings.ToUpper(cn)}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Add("cn=alice,dc=example", nil); resultCode(err) != ldap.EntryAlreadyExists {
+		t.Errorf("Bad add result: %v (expected %v)", err, ldap.EntryAlreadyExists)
+	}
+
+	tests := []struct {
+		filter string
+		dns    []string
+	}{
+		{"(objectClass=*)", []string{"cn=alice,dc=example", "cn=bob,dc=example", "cn=carol,dc=example"}},
+		{"(cn=bob)", []string{"cn=bob,dc=example"}},
+		{"(!(cn=bob))", []string{"cn=alice,dc=example", "cn=carol,dc=example"}},
+		{"(|(cn=alice)(sn=CAROL))", []string{"cn=alice,dc=example", "cn=carol,dc=example"}},
+		{"(&(cn=a*)(!(sn=BOB)))", []string{"cn=alice,dc=example"}},
+		{"(cn=*o*)", []string{"cn=bob,dc=example", "cn=carol,dc=example"}},
+	}
+	for i, test := range tests {
+		if dns := searchDNs(t, c, test.filter); !reflect.DeepEqual(dns, test.dns) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, dns, test.dns)
+		}
+	}
+
+	err := c.Modify("cn=bob,dc=example", []ldap.Modification{
+		{Operation: ldap.ReplaceValues, Attribute: ldap.Attribute{Type: "sn", Values: []string{"Builder"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Compare("cn=bob,dc=example", "sn", "Builder"); err != nil || !ok {
+		t.Errorf("Bad compare result: %v, %v (expected true)", ok, err)
+	}
+	if ok, err := c.Compare("cn=bob,dc=example", "sn", "BOB"); err != nil || ok {
+		t.Errorf("Bad compare result: %v, %v (expected false)", ok, err)
+	}
+
+	if err := c.Del("cn=bob,dc=example"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Del("cn=bob,dc=example"); resultCode(err) != ldap.NoSuchObject {
+		t.Errorf("Bad delete result: %v (expected %v)", err, ldap.NoSuchObject)
+	}
+	if err := c.ModifyDN("cn=alice,dc=example", "cn=alicia", true, ""); resultCode(err) != ldap.UnwillingToPerform {
+		t.Errorf("Bad modify DN result: %v (expected %v)", err, ldap.UnwillingToPerform)
+	}
+}
+
+func TestBaseHandler(t *testing.T) {
+	c, stop := startTestServer(t, BaseHandler{})
+	defer stop()
+
+	if err := c.Bind("cn=admin", "secret"); resultCode(err) != ldap.AuthMethodNotSupported {
+		t.Errorf("Bad bind result: %v (expected %v)", err, ldap.AuthMethodNotSupported)
+	}
+	if _, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: ldap.Present("objectClass")}); resultCode(err) != ldap.UnwillingToPerform {
+		t.Errorf("Bad search result: %v (expected %v)", err, ldap.UnwillingToPerform)
+	}
+	if _, err := c.WhoAmI(); resultCode(err) != ldap.ProtocolError {
+		t.Errorf("Bad extended result: %v (expected %v)", err, ldap.ProtocolError)
+	}
+}
+
+// testCert returns a certificate for cn signed by parent, or self-signed
+// if parent is nil.
+func testCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type certHandler struct {
+	BaseHandler
+	subjects chan []string
+}
+
+func (h *certHandler) Bind(c *Conn, req *BindRequest) error {
+	var subjects []string
+	for _, chain := range req.VerifiedChains {
+		subjects = append(subjects, chain[0].Subject.CommonName)
+	}
+	h.subjects <- subjects
+	return nil
+}
+
+func TestStartTLS(t *testing.T) {
+	ca := testCert(t, "ca", nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{testCert(t, "server", &ca)},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	}
+	h := &certHandler{subjects: make(chan []string, 1)}
+	s := &Server{Handler: h, TLSConfig: config}
+	addr, stop := serveTestServer(t, s)
+	defer stop()
+
+	tests := []struct {
+		certs    []tls.Certificate
+		expected []string
+	}{
+		{nil, nil},
+		{[]tls.Certificate{testCert(t, "alice", &ca)}, []string{"alice"}},
+	}
+	for i, test := range tests {
+		c, err := ldap.Dial(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientConfig := &tls.Config{ServerName: "127.0.0.1", RootCAs: pool, Certificates: test.certs}
+		if err := c.StartTLS(clientConfig); err != nil {
+			t.Fatalf("#%d: StartTLS: %v", i, err)
+		}
+		if err := c.StartTLS(clientConfig); resultCode(err) != ldap.OperationsError {
+			t.Errorf("#%d: Bad second StartTLS result: %v (expected %v)", i, err, ldap.OperationsError)
+		}
+		if err := c.Bind("", ""); err != nil {
+			t.Fatalf("#%d: Bind: %v", i, err)
+		}
+		if subjects := <-h.subjects; !reflect.DeepEqual(subjects, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, subjects, test.expected)
+		}
+		c.Close()
+	}
+
+	// Without a TLSConfig the server refuses StartTLS.
+	c, stop2 := startTestServer(t, h)
+	defer stop2()
+	if err := c.StartTLS(&tls.Config{}); resultCode(err) != ldap.ProtocolError {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.ProtocolError)
+	}
+	if err := c.Bind("", ""); err != nil {
+		t.Errorf("Bind after refused StartTLS: %v", err)
+	}
+	<-h.subjects
+}
+
+type cancelHandler struct {
+	BaseHandler
+	stopped chan struct{}
+}
+
+func (h cancelHandler) Search(c *Conn, req *SearchRequest, w SearchWriter) error {
+	<-req.Context().Done()
+	close(h.stopped)
+	return req.Context().Err()
+}
+
+func TestCancel(t *testing.T) {
+	h := cancelHandler{stopped: make(chan struct{})}
+	addr, stop := serveTestServer(t, &Server{Handler: h})
+	defer stop()
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.RootDSE(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	f, _ := ldap.CompileFilter("(objectClass=*)")
+	req := ldap.SearchRequest{BaseObject: []byte("dc=example,dc=com"), Scope: ldap.WholeSubtree, Filter: f}
+	if _, err := c.WithContext(ctx).Search(req); err != context.DeadlineExceeded {
+		t.Errorf("Bad result: %v (expected %v)", err, context.DeadlineExceeded)
+	}
+	// The search is canceled in the background, which stops the
+	// handler.
+	select {
+	case <-h.stopped:
+	case <-time.After(time.Second):
+		t.Error("Handler not stopped")
+	}
+}
+
+// readNotice reads a Notice of Disconnection from nc, returning its
+// result code.
+func readNotice(nc net.Conn) (ldap.ResultCode, error) {
+	nc.SetReadDeadline(time.Now().Add(time.Second))
+	dec := asn1.NewDecoder(nc)
+	dec.Implicit = true
+	var raw asn1.RawValue
+	msg := ldapMessage{ProtocolOp: &raw}
+	if err := dec.Decode(&msg); err != nil {
+		return 0, err
+	}
+	var r extendedResponse
+	if err := decodeOp(raw, &r); err != nil {
+		return 0, err
+	}
+	if msg.MessageId != 0 || string(r.Name) != oidNoticeOfDisconnection {
+		return 0, fmt.Errorf("unexpected message %d: %q", msg.MessageId, r.Name)
+	}
+	return r.Result.ResultCode, nil
+}
+
+type modifyBlocker struct {
+	BaseHandler
+	started, release chan struct{}
+}
+
+func (h modifyBlocker) Modify(c *Conn, req *ModifyRequest) error {
+	h.started <- struct{}{}
+	select {
+	case <-h.release:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	addr, stop := serveTestServer(t, &Server{MaxConns: 1})
+	defer stop()
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.RootDSE(); err != nil {
+		t.Fatal(err)
+	}
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if code, err := readNotice(nc); code != ldap.Busy {
+		t.Errorf("Bad notice: %v, %v (expected %v)", code, err, ldap.Busy)
+	}
+
+	addr, stop = serveTestServer(t, &Server{IdleTimeout: 20 * time.Millisecond})
+	defer stop()
+	nc, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if code, err := readNotice(nc); code != ldap.Unavailable {
+		t.Errorf("Bad notice: %v, %v (expected %v)", code, err, ldap.Unavailable)
+	}
+
+	h := modifyBlocker{started: make(chan struct{}, 1), release: make(chan struct{})}
+	addr, stop = serveTestServer(t, &Server{Handler: h, OperationTimeout: 20 * time.Millisecond})
+	defer stop()
+	c, err = ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Modify("cn=x", nil); resultCode(err) != ldap.TimeLimitExceeded {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.TimeLimitExceeded)
+	}
+
+	addr, stop = serveTestServer(t, &Server{Handler: newTestBackend(t), MaxRequestSize: 256, MaxFilterDepth: 2})
+	defer stop()
+	c, err = ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if dns := searchDNs(t, c, "(&(cn=alice)(sn=smith))"); len(dns) != 1 {
+		t.Errorf("Bad result: %v (expected Alice)", dns)
+	}
+	f, _ := ldap.CompileFilter("(&(cn=alice)(!(sn=jones)))")
+	if _, err := c.Search(ldap.SearchRequest{Scope: ldap.WholeSubtree, Filter: f}); resultCode(err) != ldap.ProtocolError {
+		t.Errorf("Bad result: %v (expected %v)", err, ldap.ProtocolError)
+	}
+	nc, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	// A message claiming a gigabyte of content is refused unread.
+	nc.Write([]byte{0x30, 0x84, 0x40, 0x00, 0x00, 0x00})
+	if code, err := readNotice(nc); code != ldap.ProtocolError {
+		t.Errorf("Bad notice: %v, %v (expected %v)", code, err, ldap.ProtocolError)
+	}
+}
+
+func TestMalformedLength(t *testing.T) {
+	addr, stop := serveTestServer(t, &Server{Handler: newTestBackend(t)})
+	defer stop()
+	for i, in := range [][]byte{
+		// A length wider than any int.
+		{0x30, 0x88, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		// Two gigabytes of content that never arrive.
+		{0x30, 0x84, 0x7f, 0xff, 0xff, 0xf0, 0x02, 0x01, 0x01},
+	} {
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nc.Write(in)
+		if i == 0 {
+			if code, err := readNotice(nc); code != ldap.ProtocolError {
+				t.Errorf("#%d: Bad notice: %v, %v (expected %v)", i, code, err, ldap.ProtocolError)
+			}
+		}
+		nc.Close()
+	}
+	// The server is still serving.
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.RootDSE(); err != nil {
+		t.Errorf("RootDSE: %v", err)
+	}
+}
+
+func TestDefaultMaxRequestSize(t *testing.T) {
+	addr, stop := serveTestServer(t, &Server{Handler: newTestBackend(t)})
+	defer stop()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	// A message a byte over the limit is refused unread.
+	n := DefaultMaxRequestSize - 5
+	nc.Write([]byte{0x30, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	if code, err := readNotice(nc); code != ldap.ProtocolError {
+		t.Errorf("Bad notice: %v, %v (expected %v)", code, err, ldap.ProtocolError)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h := modifyBlocker{started: make(chan struct{}), release: make(chan struct{})}
+	s := &Server{Handler: h}
+	addr, stop := serveTestServer(t, s)
+	defer stop()
+	c, err := ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	modified := make(chan error)
+	go func() { modified <- c.Modify("cn=x", nil) }()
+	<-h.started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with an operation outstanding", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err := ldap.Dial(addr); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+	close(h.release)
+	if err := <-modified; err != nil {
+		t.Errorf("Bad result: %v (expected the operation to finish)", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Bad Shutdown result: %v", err)
+	}
+
+	h = modifyBlocker{started: make(chan struct{}), release: make(chan struct{})}
+	s = &Server{Handler: h}
+	addr, stop = serveTestServer(t, s)
+	defer stop()
+	c, err = ldap.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	go func() { modified <- c.Modify("cn=x", nil) }()
+	<-h.started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Bad Shutdown result: %v (expected %v)", err, context.DeadlineExceeded)
+	}
+	if err := <-modified; err == nil {
+		t.Error("Expected the operation to fail when its connection closed")
+	}
+}
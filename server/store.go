@@ -0,0 +1,93 @@
+package server
+
+import (
+	"github.com/stesla/ldap"
+)
+
+// An entryStore holds the entries of a MemoryBackend and the postings
+// of its indexes: the names of the entries filed under each index term.
+// The backend's mu guards it.
+type entryStore interface {
+	// entry returns the entry named name, or nil.
+	entry(name ldap.DN) (*memoryEntry, error)
+	// walk calls fn with base, if it exists, and the entries below it,
+	// until fn returns false.
+	walk(base ldap.DN, fn func(me *memoryEntry) bool) error
+	// posted returns the normalized names of the entries posted under
+	// term, and whether they are all there: it may stop after more than
+	// limit, unless limit is negative. The caller must not change them.
+	posted(term string, limit int) (map[string]bool, bool, error)
+	// write makes the changes and postings of u.
+	write(u *storeUpdate) error
+}
+
+// A storeUpdate changes the entries of a store and the postings of its
+// indexes, and records the kinds of index kept of each attribute.
+type storeUpdate struct {
+	changes  []entryChange
+	postings map[posting]bool     // added if true, removed if false
+	indexes  map[string]IndexType // if not nil, the new kinds of index
+}
+
+// A posting files the entry name under an index term.
+type posting struct {
+	term string
+	name string // normalized
+}
+
+// equalityTerm and presenceTerm are the index terms of a normalized
+// value of attr, and of the presence of attr.
+func equalityTerm(attr, value string) string { return "=" + attr + "=" + value }
+func presenceTerm(attr string) string        { return "*" + attr }
+
+// memStore is the entryStore that keeps entries in memory.
+type memStore struct {
+	entries  map[string]*memoryEntry
+	postings map[string]map[string]bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{entries: map[string]*memoryEntry{}, postings: map[string]map[string]bool{}}
+}
+
+func (s *memStore) entry(name ldap.DN) (*memoryEntry, error) {
+	return s.entries[name.String()], nil
+}
+
+func (s *memStore) walk(base ldap.DN, fn func(me *memoryEntry) bool) error {
+	for _, me := range s.entries {
+		if isUnder(me.name, base) && !fn(me) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memStore) posted(term string, limit int) (map[string]bool, bool, error) {
+	names := s.postings[term]
+	return names, limit < 0 || len(names) <= limit, nil
+}
+
+func (s *memStore) write(u *storeUpdate) error {
+	for _, ch := range u.changes {
+		if ch.entry == nil {
+			delete(s.entries, ch.name.String())
+		} else {
+			s.entries[ch.name.String()] = &memoryEntry{ch.name, ch.entry}
+		}
+	}
+	for p, add := range u.postings {
+		switch names := s.postings[p.term]; {
+		case add && names == nil:
+			s.postings[p.term] = map[string]bool{p.name: true}
+		case add:
+			names[p.name] = true
+		default:
+			delete(names, p.name)
+			if len(names) == 0 {
+				delete(s.postings, p.term)
+			}
+		}
+	}
+	return nil
+}
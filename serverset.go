@@ -0,0 +1,164 @@
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Strategy int
+
+const (
+	// StrategyFailover tries the servers in the order given.
+	StrategyFailover Strategy = iota
+	// StrategyRoundRobin starts with a different server on every dial.
+	StrategyRoundRobin
+	// StrategyLeastConnections prefers the server with the fewest
+	// connections opened through the set that are still open.
+	StrategyLeastConnections
+)
+
+// A ServerSet dials one of several equivalent directory servers,
+// skipping servers that have recently failed. Its Dial method can be
+// used as the dial function of a Pool.
+type ServerSet struct {
+	// URLs lists the servers as ldap://, ldaps:// or ldapi:// URLs.
+	URLs []string
+	// Resolver, if set, is called on every dial to obtain the current
+	// list of URLs instead of using URLs, e.g. from a DNS SRV lookup.
+	Resolver func() ([]string, error)
+	Strategy Strategy
+	// TLSConfig is used for ldaps:// URLs.
+	TLSConfig *tls.Config
+	// DialURL dials a single server. It defaults to DialURL.
+	DialURL func(rawurl string, tlsConfig *tls.Config) (Conn, error)
+
+	// After FailureThreshold consecutive failed dials a server is not
+	// tried again until Cooldown has passed, unless no other server is
+	// available. A zero FailureThreshold disables circuit breaking.
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mu    sync.Mutex
+	next  int
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	failures  int
+	openUntil time.Time
+	active    int
+}
+
+func (s *ServerSet) host(url string) *hostState {
+	if s.hosts == nil {
+		s.hosts = make(map[string]*hostState)
+	}
+	h, ok := s.hosts[url]
+	if !ok {
+		h = &hostState{}
+		s.hosts[url] = h
+	}
+	return h
+}
+
+// Dial connects to the first available server in the order dictated by
+// the strategy.
+func (s *ServerSet) Dial() (Conn, error) {
+	urls := s.URLs
+	if s.Resolver != nil {
+		var err error
+		if urls, err = s.Resolver(); err != nil {
+			return nil, err
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("ldap: no servers to dial")
+	}
+
+	dial := s.DialURL
+	if dial == nil {
+		dial = DialURL
+	}
+
+	var lastErr error
+	for _, url := range s.order(urls) {
+		c, err := dial(url, s.TLSConfig)
+		s.mu.Lock()
+		h := s.host(url)
+		if err != nil {
+			h.failures++
+			if s.FailureThreshold > 0 && h.failures >= s.FailureThreshold {
+				h.openUntil = time.Now().Add(s.Cooldown)
+			}
+			s.mu.Unlock()
+			lastErr = err
+			continue
+		}
+		h.failures = 0
+		h.openUntil = time.Time{}
+		h.active++
+		s.mu.Unlock()
+		var once sync.Once
+		return &serverSetConn{Conn: c, release: func() {
+			once.Do(func() {
+				s.mu.Lock()
+				h.active--
+				s.mu.Unlock()
+			})
+		}}, nil
+	}
+	return nil, fmt.Errorf("ldap: could not connect to any server: %v", lastErr)
+}
+
+// order returns urls in the order they should be tried, with servers
+// whose circuit is open moved to the end.
+func (s *ServerSet) order(urls []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ordered := make([]string, len(urls))
+	switch s.Strategy {
+	case StrategyRoundRobin:
+		start := s.next % len(urls)
+		s.next++
+		for i := range urls {
+			ordered[i] = urls[(start+i)%len(urls)]
+		}
+	case StrategyLeastConnections:
+		copy(ordered, urls)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return s.host(ordered[i]).active < s.host(ordered[j]).active
+		})
+	default:
+		copy(ordered, urls)
+	}
+
+	now := time.Now()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !s.host(ordered[i]).openUntil.After(now) && s.host(ordered[j]).openUntil.After(now)
+	})
+	return ordered
+}
+
+type serverSetConn struct {
+	Conn
+	release func()
+}
+
+func (c *serverSetConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
+
+func (c *serverSetConn) Unbind() error {
+	c.release()
+	return c.Conn.Unbind()
+}
+
+func (c *serverSetConn) WithContext(ctx context.Context) Conn {
+	return &serverSetConn{Conn: c.Conn.WithContext(ctx), release: c.release}
+}
@@ -0,0 +1,78 @@
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type serverSetTest struct {
+	down   map[string]bool
+	dialed []string
+}
+
+func (t *serverSetTest) dial(url string, _ *tls.Config) (Conn, error) {
+	t.dialed = append(t.dialed, url)
+	if t.down[url] {
+		return nil, fmt.Errorf("%s is down", url)
+	}
+	return &poolTestConn{}, nil
+}
+
+func TestServerSetStrategies(t *testing.T) {
+	urls := []string{"ldap://a", "ldap://b", "ldap://c"}
+	tests := []struct {
+		strategy Strategy
+		close    bool
+		out      []string
+	}{
+		{StrategyFailover, false, []string{"ldap://a", "ldap://a", "ldap://a"}},
+		{StrategyRoundRobin, false, []string{"ldap://a", "ldap://b", "ldap://c"}},
+		{StrategyLeastConnections, false, []string{"ldap://a", "ldap://b", "ldap://c"}},
+		{StrategyLeastConnections, true, []string{"ldap://a", "ldap://a", "ldap://a"}},
+	}
+	for i, test := range tests {
+		st := &serverSetTest{}
+		s := &ServerSet{URLs: urls, Strategy: test.strategy, DialURL: st.dial}
+		for j := 0; j < 3; j++ {
+			c, err := s.Dial()
+			if err != nil {
+				t.Fatalf("#%d: Unexpected error: %v", i, err)
+			}
+			if test.close {
+				c.Close()
+			}
+		}
+		if !reflect.DeepEqual(st.dialed, test.out) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, st.dialed, test.out)
+		}
+	}
+}
+
+func TestServerSetCircuitBreaker(t *testing.T) {
+	st := &serverSetTest{down: map[string]bool{"ldap://a": true}}
+	s := &ServerSet{
+		URLs:             []string{"ldap://a", "ldap://b"},
+		DialURL:          st.dial,
+		FailureThreshold: 1,
+		Cooldown:         time.Hour,
+	}
+	s.Dial()
+	s.Dial()
+	expected := []string{"ldap://a", "ldap://b", "ldap://b"}
+	if !reflect.DeepEqual(st.dialed, expected) {
+		t.Errorf("Bad result: %v (expected %v)", st.dialed, expected)
+	}
+
+	st.down["ldap://b"] = true
+	st.dialed = nil
+	if _, err := s.Dial(); err == nil {
+		t.Errorf("Expected error when every server is down")
+	}
+	expected = []string{"ldap://b", "ldap://a"}
+	if !reflect.DeepEqual(st.dialed, expected) {
+		t.Errorf("Bad result: %v (expected %v)", st.dialed, expected)
+	}
+}
@@ -0,0 +1,173 @@
+package ldap
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SortEntries sorts entries by keys as RFC 2891 describes: by the least
+// value of each key's attribute, with entries lacking the attribute
+// last. Values are ordered by the key's matching rule, if it names one,
+// or by the rule rules returns for the attribute. rules may return nil,
+// or be nil, to use CaseIgnoreMatch. An unknown matching rule fails with
+// InappropriateMatching.
+func SortEntries(entries []*Entry, keys []SortKey, rules func(attribute string) MatchingRule) error {
+	order, err := sortOrder(entries, keys, rules)
+	if err != nil {
+		return err
+	}
+	sorted := make([]*Entry, len(entries))
+	for i, j := range order {
+		sorted[i] = entries[j]
+	}
+	copy(entries, sorted)
+	return nil
+}
+
+// SortResults sorts results as SortEntries does, with the default
+// matching rules, for servers that cannot sort.
+func SortResults(results []SearchResult, keys []SortKey) error {
+	entries := make([]*Entry, len(results))
+	for i, r := range results {
+		entries[i] = r.Entry()
+	}
+	order, err := sortOrder(entries, keys, nil)
+	if err != nil {
+		return err
+	}
+	sorted := make([]SearchResult, len(results))
+	for i, j := range order {
+		sorted[i] = results[j]
+	}
+	copy(results, sorted)
+	return nil
+}
+
+// sortOrder returns the indexes of entries in sorted order.
+func sortOrder(entries []*Entry, keys []SortKey, rules func(attribute string) MatchingRule) ([]int, error) {
+	ordering := make([]MatchingRule, len(keys))
+	for i, k := range keys {
+		switch {
+		case k.MatchingRule != "":
+			rule, ok := LookupMatchingRule(k.MatchingRule)
+			if !ok {
+				return nil, &Error{ResultCode: InappropriateMatching,
+					DiagnosticMessage: fmt.Sprintf("%s: unknown matching rule %q", k.AttributeType, k.MatchingRule)}
+			}
+			ordering[i] = rule
+		case rules != nil:
+			ordering[i] = rules(k.AttributeType)
+		}
+		if ordering[i] == nil {
+			ordering[i] = CaseIgnoreMatch
+		}
+	}
+
+	// The least value of each key, or nil if there is none.
+	least := make([][]*string, len(entries))
+	order := make([]int, len(entries))
+	for j, e := range entries {
+		values := make([]*string, len(keys))
+		for i, k := range keys {
+			for _, v := range e.GetAttributeValues(k.AttributeType) {
+				n, err := ordering[i].Normalize(v)
+				if err != nil {
+					continue
+				}
+				if values[i] == nil || ordering[i].Compare(n, *values[i]) < 0 {
+					values[i] = &n
+				}
+			}
+		}
+		least[j], order[j] = values, j
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		x, y := least[order[i]], least[order[j]]
+		for k, key := range keys {
+			var cmp int
+			switch {
+			case x[k] == nil && y[k] == nil:
+			case x[k] == nil:
+				cmp = 1
+			case y[k] == nil:
+				cmp = -1
+			default:
+				cmp = ordering[k].Compare(*x[k], *y[k])
+			}
+			if key.Reverse {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	return order, nil
+}
+
+// DedupResults returns results without the repeats of an entry, which
+// a search can return when it follows referrals or aliases, keeping the
+// first result for each normalized DN.
+func DedupResults(results []SearchResult) []SearchResult {
+	seen := make(map[string]bool, len(results))
+	out := make([]SearchResult, 0, len(results))
+	for _, r := range results {
+		if dn := normalizeDN(r.DN); !seen[dn] {
+			seen[dn] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// ProjectResults returns copies of results with only the attributes
+// that attrs describe, as a search requesting them would: "cn" selects
+// "CN" and "cn;lang-en" too. With no attributes, or "*", it returns results as they
+// are; "1.1" selects none.
+func ProjectResults(results []SearchResult, attrs []string) []SearchResult {
+	if len(attrs) == 0 || contains(attrs, "*") {
+		return results
+	}
+	out := make([]SearchResult, len(results))
+	for i, r := range results {
+		out[i] = SearchResult{DN: r.DN, Attributes: map[string][]string{}}
+		for name, values := range r.Attributes {
+			for _, a := range attrs {
+				if describes(a, name) {
+					out[i].Attributes[name] = values
+					break
+				}
+			}
+		}
+	}
+	return out
+}
+
+// SearchSorted performs req and returns its results sorted by keys,
+// without repeated entries. It asks the server to sort with the Server
+// Side Sort control unless RootDSE has been read and shows that the
+// server does not support it, and sorts the results itself if the
+// server did not.
+func (l *conn) SearchSorted(req SearchRequest, keys []SortKey, controls ...Control) ([]SearchResult, error) {
+	serverSide := true
+	if dse := l.cachedRootDSE(); dse != nil && !dse.SupportsControl(ControlTypeServerSideSort) {
+		serverSide = false
+	}
+	if serverSide {
+		controls = append(controls[:len(controls):len(controls)], &ControlServerSideSort{SortKeys: keys})
+	}
+	resp, err := l.SearchWithControls(req, controls...)
+	if err != nil {
+		return nil, err
+	}
+	results := DedupResults(resp.Results)
+	c, ok := FindControl(resp.Controls, ControlTypeServerSideSortResponse).(*ControlServerSideSortResponse)
+	if !serverSide || !ok || c.Result != Success {
+		if err := SortResults(results, keys); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,93 @@
+package ldap
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSortResults(t *testing.T) {
+	results := []SearchResult{
+		{"cn=c", map[string][]string{"sn": {"Brown"}, "uidNumber": {"10"}}},
+		{"cn=a", map[string][]string{"SN": {"adams", "Zed"}, "uidNumber": {"9"}}},
+		{"cn=b", map[string][]string{"uidNumber": {"100"}}},
+		{"cn=d", map[string][]string{"sn": {"brown"}, "uidNumber": {"2"}}},
+	}
+	tests := []struct {
+		keys     []SortKey
+		expected []string
+	}{
+		{[]SortKey{{AttributeType: "sn"}}, []string{"cn=a", "cn=c", "cn=d", "cn=b"}},
+		{[]SortKey{{AttributeType: "sn", Reverse: true}}, []string{"cn=b", "cn=c", "cn=d", "cn=a"}},
+		{[]SortKey{{AttributeType: "sn"}, {AttributeType: "uidNumber", MatchingRule: "integerOrderingMatch"}},
+			[]string{"cn=a", "cn=d", "cn=c", "cn=b"}},
+	}
+	for i, test := range tests {
+		r := append([]SearchResult{}, results...)
+		if err := SortResults(r, test.keys); err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		var dns []string
+		for _, r := range r {
+			dns = append(dns, r.DN)
+		}
+		if !reflect.DeepEqual(dns, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, dns, test.expected)
+		}
+	}
+	if err := SortResults(results, []SortKey{{AttributeType: "sn", MatchingRule: "noSuchMatch"}}); !IsErrorWithCode(err, InappropriateMatching) {
+		t.Errorf("Bad result: %v (expected InappropriateMatching)", err)
+	}
+}
+
+func TestDedupAndProjectResults(t *testing.T) {
+	results := []SearchResult{
+		{"cn=A,dc=com", map[string][]string{"cn": {"A"}, "mail": {"a@example.com"}}},
+		{"CN=a, DC=com", map[string][]string{"cn": {"a"}}},
+		{"cn=b,dc=com", map[string][]string{"cn": {"b"}}},
+	}
+	deduped := DedupResults(results)
+	if len(deduped) != 2 || deduped[0].DN != "cn=A,dc=com" || deduped[1].DN != "cn=b,dc=com" {
+		t.Errorf("Bad result: %v", deduped)
+	}
+
+	tests := []struct {
+		attrs    []string
+		expected map[string][]string
+	}{
+		{nil, results[0].Attributes},
+		{[]string{"*"}, results[0].Attributes},
+		{[]string{"MAIL"}, map[string][]string{"mail": {"a@example.com"}}},
+		{[]string{"1.1"}, map[string][]string{}},
+	}
+	for i, test := range tests {
+		if out := ProjectResults(results, test.attrs); len(out) != 3 || !reflect.DeepEqual(out[0].Attributes, test.expected) {
+			t.Errorf("#%d: Bad result: %v (expected %v)", i, out, test.expected)
+		}
+	}
+}
+
+func TestSearchSorted(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	// The server ignores the sort control, so the client sorts.
+	go func() {
+		m, _ := readTestMessage(server)
+		for _, dn := range []string{"cn=b", "cn=a", "CN=B"} {
+			writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{
+				[]byte(dn), []partialAttribute{{[]byte("cn"), [][]byte{[]byte(dn[3:])}}}})
+		}
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+
+	results, err := c.SearchSorted(SearchRequest{Filter: Present("objectClass")}, []SortKey{{AttributeType: "cn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].DN != "cn=a" || results[1].DN != "cn=b" {
+		t.Errorf("Bad result: %v", results)
+	}
+}
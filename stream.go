@@ -0,0 +1,206 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// streamSpillSize is the size above which a value of a streamed
+// attribute is written to a temporary file as it arrives rather than
+// read into memory.
+const streamSpillSize = 64 * 1024
+
+// A StreamedResult is an entry returned by SearchStream. The values of
+// the streamed attributes are in Streams rather than Attributes.
+type StreamedResult struct {
+	SearchResult
+	Streams map[string][]*io.SectionReader
+}
+
+// SearchStream performs req as SearchFunc does, but returns the values
+// of attrs, such as jpegPhoto or userCertificate, as readers. Values
+// over 64 KiB are copied from the connection to a temporary file as they
+// arrive, so they are never held in memory. The readers are valid until
+// SearchStream returns, when the file is removed.
+func (l *conn) SearchStream(req SearchRequest, attrs []string, fn func(StreamedResult) error, controls ...Control) ([]Control, error) {
+	s := &streamedSearch{attrs: make(map[string]bool), fn: fn}
+	for _, a := range attrs {
+		s.attrs[streamKey(a)] = true
+	}
+	defer s.close()
+	v := &conn{session: l.session, ctx: l.ctx, timeout: l.timeout, stream: s}
+	return v.search(req, controls, searchFunc(nil))
+}
+
+// A streamedSearch holds the values of a SearchStream's entries that the
+// reader spilled to a temporary file.
+type streamedSearch struct {
+	attrs map[string]bool // by streamKey
+	fn    func(StreamedResult) error
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	spilled []spilledValue
+	closed  bool
+	err     error // why a value could not be spilled
+}
+
+type spilledValue struct {
+	off, n int64
+}
+
+// streamKey is the attribute type of the description desc, lower-cased,
+// so that "userCertificate;binary" is streamed for "usercertificate".
+func streamKey(desc string) string {
+	if i := strings.IndexByte(desc, ';'); i >= 0 {
+		desc = desc[:i]
+	}
+	return strings.ToLower(desc)
+}
+
+// spill diverts a large attribute value of an entry of a SearchStream to
+// its temporary file, so that it is never held in memory. path is as
+// asn1.MessageReader.Spill gives it.
+func (s *session) spill(path []asn1.RawValue, length int) (io.Writer, []byte) {
+	// LDAPMessage, SearchResultEntry, attributes, PartialAttribute, vals,
+	// value.
+	if len(path) != 6 || path[1].Class != 1 || path[1].Tag != 4 {
+		return nil, nil
+	}
+	raw, _, err := asn1.ParseRawValue(path[0].Bytes)
+	if err != nil {
+		return nil, nil
+	}
+	id, err := asn1.ParseInteger(raw.Bytes, 0)
+	if err != nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	p := s.pending[int(id)]
+	s.mu.Unlock()
+	if p == nil || p.stream == nil {
+		return nil, nil
+	}
+	typ, _, err := asn1.ParseRawValue(path[3].Bytes)
+	if err != nil {
+		return nil, nil
+	}
+	return p.stream.spill(string(typ.Bytes), length)
+}
+
+// spill returns where to write a value of attr that is length bytes
+// long, and the element that stands in for it in the message: the index
+// of the value with context tag 0.
+func (s *streamedSearch) spill(attr string, length int) (io.Writer, []byte) {
+	if !s.attrs[streamKey(attr)] {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil
+	}
+	if s.file == nil {
+		f, err := os.CreateTemp("", "ldap-values-")
+		if err != nil {
+			// The value is read into memory after all.
+			return nil, nil
+		}
+		s.file = f
+	}
+	index := len(s.spilled)
+	s.spilled = append(s.spilled, spilledValue{s.size, int64(length)})
+	w := &spillWriter{s, s.size}
+	s.size += int64(length)
+	return w, asn1.AppendInteger(nil, 2, 0, int64(index))
+}
+
+// A spillWriter writes a value to the file of a streamedSearch. Its
+// errors are kept for the consumer, so that the connection is not lost
+// over a full disk.
+type spillWriter struct {
+	s   *streamedSearch
+	off int64
+}
+
+func (w *spillWriter) Write(b []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	if w.s.closed || w.s.err != nil {
+		return len(b), nil
+	}
+	n, err := w.s.file.WriteAt(b, w.off)
+	w.off += int64(n)
+	if err != nil {
+		w.s.err = err
+	}
+	return len(b), nil
+}
+
+type streamedEntry struct {
+	Name       []byte
+	Attributes []struct {
+		Type   []byte
+		Values []asn1.RawValue `asn1:"set"`
+	}
+}
+
+// result decodes a SearchResultEntry whose large values may have been
+// spilled.
+func (s *streamedSearch) result(raw asn1.RawValue) (StreamedResult, error) {
+	var e streamedEntry
+	if err := decodeOp(raw, "application,tag:4", &e); err != nil {
+		return StreamedResult{}, fmt.Errorf("Decode SearchResult: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return StreamedResult{}, fmt.Errorf("ldap: spilling attribute value: %v", s.err)
+	}
+	r := StreamedResult{
+		SearchResult: SearchResult{string(e.Name), make(map[string][]string)},
+		Streams:      make(map[string][]*io.SectionReader),
+	}
+	for _, a := range e.Attributes {
+		name := string(a.Type)
+		if !s.attrs[streamKey(name)] {
+			vals := []string{}
+			for _, v := range a.Values {
+				vals = append(vals, string(v.Bytes))
+			}
+			r.Attributes[name] = vals
+			continue
+		}
+		readers := []*io.SectionReader{}
+		for _, v := range a.Values {
+			if v.Class != 2 {
+				readers = append(readers, io.NewSectionReader(bytes.NewReader(v.Bytes), 0, int64(len(v.Bytes))))
+				continue
+			}
+			index, err := asn1.ParseInteger(v.Bytes, 0)
+			if err != nil || index < 0 || int(index) >= len(s.spilled) {
+				return StreamedResult{}, fmt.Errorf("ldap: bad spilled value of %s", name)
+			}
+			sv := s.spilled[index]
+			readers = append(readers, io.NewSectionReader(s.file, sv.off, sv.n))
+		}
+		r.Streams[name] = readers
+	}
+	return r, nil
+}
+
+func (s *streamedSearch) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
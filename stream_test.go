@@ -0,0 +1,80 @@
+package ldap
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestSearchStream(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	photo := bytes.Repeat([]byte("jpeg"), 50000)
+	cert := bytes.Repeat([]byte("cert"), 40000)
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{
+			{[]byte("cn"), [][]byte{[]byte("x")}},
+			{[]byte("jpegPhoto"), [][]byte{photo, []byte("small")}},
+			{[]byte("userCertificate;binary"), [][]byte{cert}},
+			{[]byte("description"), [][]byte{cert}},
+		}})
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+
+	var n int
+	_, err := c.SearchStream(SearchRequest{Filter: Present("objectClass")}, []string{"jpegphoto", "userCertificate"}, func(r StreamedResult) error {
+		n++
+		if r.DN != "cn=x" || r.Attributes["cn"][0] != "x" || r.Attributes["description"][0] != string(cert) {
+			t.Errorf("Bad result: %s %v", r.DN, r.Attributes["cn"])
+		}
+		if _, ok := r.Attributes["jpegPhoto"]; ok {
+			t.Errorf("Streamed attribute in Attributes")
+		}
+		expected := map[string][][]byte{
+			"jpegPhoto":              {photo, []byte("small")},
+			"userCertificate;binary": {cert},
+		}
+		for attr, vals := range expected {
+			if len(r.Streams[attr]) != len(vals) {
+				t.Fatalf("Bad result: %d %s values (expected %d)", len(r.Streams[attr]), attr, len(vals))
+			}
+			for i, v := range vals {
+				b, err := io.ReadAll(r.Streams[attr][i])
+				if err != nil || !bytes.Equal(b, v) {
+					t.Errorf("%s #%d: Bad result: %d bytes, %v (expected %d)", attr, i, len(b), err, len(v))
+				}
+			}
+		}
+		// The large values were spilled to a file.
+		if files, _ := os.ReadDir(dir); len(files) != 1 {
+			t.Errorf("Bad result: %d temporary files (expected 1)", len(files))
+		}
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("Bad result: %d, %v", n, err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Temporary file not removed: %v", files)
+	}
+
+	// Values of other searches are not spilled.
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{
+			{[]byte("jpegPhoto"), [][]byte{photo}},
+		}})
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+	}()
+	results, err := c.Search(SearchRequest{Filter: Present("objectClass")})
+	if err != nil || len(results) != 1 || results[0].Attributes["jpegPhoto"][0] != string(photo) {
+		t.Errorf("Bad result: %d, %v", len(results), err)
+	}
+}
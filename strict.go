@@ -0,0 +1,64 @@
+package ldap
+
+import (
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+	"strconv"
+	"strings"
+)
+
+// A ProtocolViolationError reports a response that does not conform to
+// RFC 4511, found with DialOpts.Strict. The connection is unusable after
+// one.
+type ProtocolViolationError struct {
+	MessageID int
+	Reason    string
+}
+
+func (e *ProtocolViolationError) Error() string {
+	return fmt.Sprintf("ldap: protocol violation by server in message %d: %s", e.MessageID, e.Reason)
+}
+
+// responseTags lists the responses each request may be answered with,
+// by the tag of the request, besides IntermediateResponse.
+var responseTags = map[int][]int{
+	0:  {1},        // bind
+	3:  {4, 5, 19}, // search
+	6:  {7},        // modify
+	8:  {9},        // add
+	10: {11},       // delete
+	12: {13},       // modify DN
+	14: {15},       // compare
+	23: {24},       // extended
+}
+
+// opTag returns the tag of the request op, or -1.
+func opTag(op interface{}) int {
+	ov, _ := op.(asn1.OptionValue)
+	if s, ok := strings.CutPrefix(ov.Opts, "application,tag:"); ok {
+		if tag, err := strconv.Atoi(s); err == nil {
+			return tag
+		}
+	}
+	return -1
+}
+
+// checkResponse checks a response to the request with tag request: that
+// it is of a type the request may be answered with, and that its
+// controls are not critical, which only requests' may be (RFC 4511
+// §4.1.11).
+func checkResponse(id, request int, raw asn1.RawValue, controls []control) error {
+	ok := raw.Class == 1 && raw.Tag == 25
+	for _, tag := range responseTags[request] {
+		ok = ok || raw.Class == 1 && raw.Tag == tag
+	}
+	if !ok {
+		return &ProtocolViolationError{id, fmt.Sprintf("response with tag %d to %s request", raw.Tag, opNames[request])}
+	}
+	for _, c := range controls {
+		if c.Criticality {
+			return &ProtocolViolationError{id, fmt.Sprintf("critical response control %s", c.Type)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package ldap
+
+import (
+	"errors"
+	"github.com/stesla/ldap/asn1"
+	"net"
+	"testing"
+)
+
+func TestStrict(t *testing.T) {
+	compareTrue := ldapResult{ResultCode: CompareTrue, MatchedDN: []byte{}, Message: []byte{}}
+	respond := func(id int, opts string, op interface{}, controls ...control) ldapMessage {
+		return ldapMessage{MessageId: id, ProtocolOp: asn1.OptionValue{Opts: opts, Value: op}, Controls: controls}
+	}
+	tests := []struct {
+		responses func(id int) []ldapMessage
+		reason    string
+	}{
+		{func(id int) []ldapMessage {
+			return []ldapMessage{respond(id, "application,tag:15", compareTrue)}
+		}, ""},
+		{func(id int) []ldapMessage {
+			return []ldapMessage{respond(id, "application,tag:15", compareTrue, control{Type: []byte("1.2.3")})}
+		}, ""},
+		{func(id int) []ldapMessage {
+			return []ldapMessage{respond(id, "application,tag:7", compareTrue)}
+		}, "response with tag 7 to compare request"},
+		{func(id int) []ldapMessage {
+			return []ldapMessage{respond(id, "application,tag:15", compareTrue, control{Type: []byte("1.2.3"), Criticality: true})}
+		}, "critical response control 1.2.3"},
+		{func(id int) []ldapMessage {
+			return []ldapMessage{respond(id+1, "application,tag:15", compareTrue)}
+		}, "response with unknown message ID"},
+		{func(id int) []ldapMessage {
+			return []ldapMessage{respond(0, "application,tag:15", compareTrue)}
+		}, "unsolicited response that is not a notification"},
+	}
+	for i, test := range tests {
+		client, server := net.Pipe()
+		c := newConnWithOpts(client, DialOpts{Strict: true})
+		go func() {
+			m, _ := readTestMessage(server)
+			enc := asn1.NewEncoder(server)
+			enc.Implicit = true
+			for _, resp := range test.responses(m.MessageId) {
+				enc.Encode(resp)
+			}
+		}()
+		_, err := c.Compare("cn=x", "cn", "x")
+		var violation *ProtocolViolationError
+		if test.reason == "" && err != nil || test.reason != "" && (!errors.As(err, &violation) || violation.Reason != test.reason) {
+			t.Errorf("#%d: Bad result: %v (expected %q)", i, err, test.reason)
+		}
+		c.Close()
+	}
+}
+
+func TestStrictSearchSequence(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{Strict: true})
+	defer c.Close()
+
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=x"), []partialAttribute{}})
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+		writeTestMessage(server, m.MessageId, "application,tag:4", searchResultEntry{[]byte("cn=y"), []partialAttribute{}})
+	}()
+	results, err := c.Search(SearchRequest{Filter: Present("objectClass")})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Bad result: %v, %v", results, err)
+	}
+	// The entry after the SearchResultDone fails the connection.
+	<-c.closed
+	var violation *ProtocolViolationError
+	if _, err := c.Compare("cn=x", "cn", "x"); !errors.As(err, &violation) || violation.MessageID != 1 {
+		t.Errorf("Bad result: %v (expected a protocol violation)", err)
+	}
+}
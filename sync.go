@@ -0,0 +1,326 @@
+package ldap
+
+import (
+	"fmt"
+	"github.com/stesla/ldap/asn1"
+)
+
+const (
+	ControlTypeSyncRequest = "1.3.6.1.4.1.4203.1.9.1.1"
+	ControlTypeSyncState   = "1.3.6.1.4.1.4203.1.9.1.2"
+	ControlTypeSyncDone    = "1.3.6.1.4.1.4203.1.9.1.3"
+
+	syncInfoName = "1.3.6.1.4.1.4203.1.9.1.4"
+)
+
+type SyncMode int
+
+const (
+	SyncRefreshOnly       SyncMode = 1
+	SyncRefreshAndPersist SyncMode = 3
+)
+
+type SyncState int
+
+const (
+	SyncPresent SyncState = 0
+	SyncAdd     SyncState = 1
+	SyncModify  SyncState = 2
+	SyncDelete  SyncState = 3
+)
+
+func (s SyncState) String() string {
+	switch s {
+	case SyncPresent:
+		return "present"
+	case SyncAdd:
+		return "add"
+	case SyncModify:
+		return "modify"
+	case SyncDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// ControlSyncRequest initiates a Content Synchronization operation
+// (RFC 4533) when attached to a search.
+type ControlSyncRequest struct {
+	Mode        SyncMode
+	Cookie      []byte
+	ReloadHint  bool
+	Criticality bool
+}
+
+type syncRequestValue struct {
+	Mode       int    `asn1:"enum"`
+	Cookie     []byte `asn1:"optional"`
+	ReloadHint bool   `asn1:"optional"`
+}
+
+func (c *ControlSyncRequest) ControlType() string { return ControlTypeSyncRequest }
+func (c *ControlSyncRequest) Critical() bool      { return c.Criticality }
+
+func (c *ControlSyncRequest) ControlValue() ([]byte, error) {
+	return encodeValue(syncRequestValue{int(c.Mode), c.Cookie, c.ReloadHint})
+}
+
+// ControlSyncState accompanies every entry returned by a sync operation.
+type ControlSyncState struct {
+	State     SyncState
+	EntryUUID []byte
+	Cookie    []byte
+}
+
+type syncStateValue struct {
+	State     int `asn1:"enum"`
+	EntryUUID []byte
+	Cookie    []byte `asn1:"optional"`
+}
+
+func (c *ControlSyncState) ControlType() string { return ControlTypeSyncState }
+func (c *ControlSyncState) Critical() bool      { return false }
+
+func (c *ControlSyncState) ControlValue() ([]byte, error) {
+	return encodeValue(syncStateValue{int(c.State), c.EntryUUID, c.Cookie})
+}
+
+func decodeControlSyncState(c control) (Control, error) {
+	var v syncStateValue
+	if err := decodeValue(c.Value, &v); err != nil {
+		return nil, err
+	}
+	return &ControlSyncState{SyncState(v.State), v.EntryUUID, v.Cookie}, nil
+}
+
+// ControlSyncDone is attached to the SearchResultDone message that ends
+// a refreshOnly sync operation.
+type ControlSyncDone struct {
+	Cookie         []byte
+	RefreshDeletes bool
+}
+
+type syncDoneValue struct {
+	Cookie         []byte `asn1:"optional"`
+	RefreshDeletes bool   `asn1:"optional"`
+}
+
+func (c *ControlSyncDone) ControlType() string { return ControlTypeSyncDone }
+func (c *ControlSyncDone) Critical() bool      { return false }
+
+func (c *ControlSyncDone) ControlValue() ([]byte, error) {
+	return encodeValue(syncDoneValue{c.Cookie, c.RefreshDeletes})
+}
+
+func decodeControlSyncDone(c control) (Control, error) {
+	var v syncDoneValue
+	if len(c.Value) > 0 {
+		if err := decodeValue(c.Value, &v); err != nil {
+			return nil, err
+		}
+	}
+	return &ControlSyncDone{v.Cookie, v.RefreshDeletes}, nil
+}
+
+type syncInfoKind int
+
+const (
+	syncNewCookie      syncInfoKind = 0
+	syncRefreshDelete  syncInfoKind = 1
+	syncRefreshPresent syncInfoKind = 2
+	syncIdSet          syncInfoKind = 3
+)
+
+// syncInfo is the decoded syncInfoValue of a Sync Info Message.
+type syncInfo struct {
+	kind           syncInfoKind
+	cookie         []byte
+	refreshDone    bool
+	refreshDeletes bool
+	uuids          [][]byte
+}
+
+func decodeSyncInfo(b []byte) (*syncInfo, error) {
+	var raw asn1.RawValue
+	if err := decodeValue(b, &raw); err != nil {
+		return nil, err
+	}
+	if raw.Class != asn1.ClassContextSpecific || raw.Tag > int(syncIdSet) {
+		return nil, fmt.Errorf("unexpected syncInfoValue (class = %d, tag = %d)", raw.Class, raw.Tag)
+	}
+	info := &syncInfo{kind: syncInfoKind(raw.Tag), refreshDone: true}
+	if info.kind == syncNewCookie {
+		info.cookie = raw.Bytes
+		return info, nil
+	}
+
+	var elements []asn1.RawValue
+	if err := decodeValue(raw.RawBytes, asn1.OptionValue{Opts: fmt.Sprintf("tag:%d", raw.Tag), Value: &elements}); err != nil {
+		return nil, err
+	}
+	for _, e := range elements {
+		if e.Class != asn1.ClassUniversal {
+			return nil, fmt.Errorf("unexpected element (class = %d, tag = %d)", e.Class, e.Tag)
+		}
+		switch e.Tag {
+		case asn1.TagOctetString:
+			info.cookie = e.Bytes
+		case asn1.TagBoolean:
+			var flag bool
+			if err := decodeValue(e.RawBytes, &flag); err != nil {
+				return nil, err
+			}
+			if info.kind == syncIdSet {
+				info.refreshDeletes = flag
+			} else {
+				info.refreshDone = flag
+			}
+		case asn1.TagSet:
+			if err := decodeValue(e.RawBytes, asn1.OptionValue{Opts: "set", Value: &info.uuids}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return info, nil
+}
+
+// A SyncHandler applies the content sent by a sync provider to the
+// consumer's copy of the data. UUIDs identify entries independently of
+// their DN and are given as the raw 16 byte entryUUID values.
+type SyncHandler interface {
+	// Update is called for every entry the provider sends. Entries in
+	// the delete state carry only their DN; entries in the present
+	// state may carry no attributes.
+	Update(state SyncState, uuid []byte, entry SearchResult) error
+
+	// Delete is called with entries the provider reports as deleted
+	// during a delete phase.
+	Delete(uuids [][]byte) error
+
+	// PresentDone is called at the end of a present phase with every
+	// entry the provider reported as present, added or modified. The
+	// consumer should delete any entry it holds that is not in the set.
+	// The keys are the raw UUIDs converted to strings.
+	PresentDone(present map[string]bool) error
+
+	// RefreshDone is called once the refresh stage is complete. In
+	// refreshAndPersist mode updates continue to arrive afterwards.
+	RefreshDone() error
+
+	// Cookie is called whenever the provider sends a new
+	// synchronization state. Persisting it allows a later SyncClient to
+	// resume where this one left off.
+	Cookie(cookie []byte) error
+}
+
+// SyncClient is a Content Synchronization (syncrepl) consumer.
+type SyncClient struct {
+	Conn    Conn
+	Request SearchRequest
+	Mode    SyncMode
+	// Cookie holds the synchronization state to resume from. It is
+	// updated as the provider sends new cookies.
+	Cookie  []byte
+	Handler SyncHandler
+
+	present map[string]bool
+}
+
+// Run performs a sync operation. In refreshOnly mode it returns once the
+// consumer is up to date; in refreshAndPersist mode it runs until the
+// handler returns an error or the connection fails.
+func (s *SyncClient) Run() error {
+	s.present = make(map[string]bool)
+	ctrl := &ControlSyncRequest{Mode: s.Mode, Cookie: s.Cookie, Criticality: true}
+	ctrls, err := s.Conn.SearchWithHandler(s.Request, syncSearchHandler{s}, ctrl)
+	if err != nil {
+		return err
+	}
+	done, ok := FindControl(ctrls, ControlTypeSyncDone).(*ControlSyncDone)
+	if !ok {
+		return s.Handler.RefreshDone()
+	}
+	if err := s.setCookie(done.Cookie); err != nil {
+		return err
+	}
+	if !done.RefreshDeletes {
+		if err := s.presentDone(); err != nil {
+			return err
+		}
+	}
+	return s.Handler.RefreshDone()
+}
+
+type syncSearchHandler struct {
+	s *SyncClient
+}
+
+func (h syncSearchHandler) Entry(entry SearchResult, controls []Control) error {
+	return h.s.entry(entry, controls)
+}
+
+func (h syncSearchHandler) Intermediate(resp IntermediateResponse, controls []Control) error {
+	return h.s.intermediate(resp, controls)
+}
+
+func (s *SyncClient) entry(entry SearchResult, controls []Control) error {
+	state, ok := FindControl(controls, ControlTypeSyncState).(*ControlSyncState)
+	if !ok {
+		return fmt.Errorf("ldap: sync entry %q without sync state control", entry.DN)
+	}
+	if state.State != SyncDelete {
+		s.present[string(state.EntryUUID)] = true
+	}
+	if err := s.Handler.Update(state.State, state.EntryUUID, entry); err != nil {
+		return err
+	}
+	return s.setCookie(state.Cookie)
+}
+
+func (s *SyncClient) intermediate(resp IntermediateResponse, controls []Control) error {
+	if resp.Name != syncInfoName {
+		return nil
+	}
+	info, err := decodeSyncInfo(resp.Value)
+	if err != nil {
+		return fmt.Errorf("Decode syncInfoValue: %v", err)
+	}
+	switch info.kind {
+	case syncRefreshPresent:
+		if err := s.presentDone(); err != nil {
+			return err
+		}
+	case syncIdSet:
+		if info.refreshDeletes {
+			err = s.Handler.Delete(info.uuids)
+		} else {
+			for _, uuid := range info.uuids {
+				s.present[string(uuid)] = true
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.setCookie(info.cookie); err != nil {
+		return err
+	}
+	if info.refreshDone && (info.kind == syncRefreshPresent || info.kind == syncRefreshDelete) {
+		return s.Handler.RefreshDone()
+	}
+	return nil
+}
+
+func (s *SyncClient) presentDone() error {
+	present := s.present
+	s.present = make(map[string]bool)
+	return s.Handler.PresentDone(present)
+}
+
+func (s *SyncClient) setCookie(cookie []byte) error {
+	if cookie == nil {
+		return nil
+	}
+	s.Cookie = cookie
+	return s.Handler.Cookie(cookie)
+}
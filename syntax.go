@@ -0,0 +1,288 @@
+package ldap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// OIDs of the attribute syntaxes of RFC 4517.
+const (
+	SyntaxBitString       = "1.3.6.1.4.1.1466.115.121.1.6"
+	SyntaxBoolean         = "1.3.6.1.4.1.1466.115.121.1.7"
+	SyntaxCountryString   = "1.3.6.1.4.1.1466.115.121.1.11"
+	SyntaxDN              = "1.3.6.1.4.1.1466.115.121.1.12"
+	SyntaxDirectoryString = "1.3.6.1.4.1.1466.115.121.1.15"
+	SyntaxGeneralizedTime = "1.3.6.1.4.1.1466.115.121.1.24"
+	SyntaxIA5String       = "1.3.6.1.4.1.1466.115.121.1.26"
+	SyntaxInteger         = "1.3.6.1.4.1.1466.115.121.1.27"
+	SyntaxJPEG            = "1.3.6.1.4.1.1466.115.121.1.28"
+	SyntaxNumericString   = "1.3.6.1.4.1.1466.115.121.1.36"
+	SyntaxOID             = "1.3.6.1.4.1.1466.115.121.1.38"
+	SyntaxOctetString     = "1.3.6.1.4.1.1466.115.121.1.40"
+	SyntaxPostalAddress   = "1.3.6.1.4.1.1466.115.121.1.41"
+	SyntaxPrintableString = "1.3.6.1.4.1.1466.115.121.1.44"
+	SyntaxTelephoneNumber = "1.3.6.1.4.1.1466.115.121.1.50"
+)
+
+var syntaxValidators = map[string]func(string) error{
+	SyntaxBitString:       validateBitString,
+	SyntaxBoolean:         func(v string) error { _, err := ParseBoolean(v); return err },
+	SyntaxCountryString:   validateCountryString,
+	SyntaxDN:              func(v string) error { _, err := ParseDN(v); return err },
+	SyntaxDirectoryString: validateDirectoryString,
+	SyntaxGeneralizedTime: func(v string) error { _, err := ParseGeneralizedTime(v); return err },
+	SyntaxIA5String:       validateIA5String,
+	SyntaxInteger:         func(v string) error { _, err := ParseInteger(v); return err },
+	SyntaxNumericString:   validateNumericString,
+	SyntaxOID:             validateOID,
+	SyntaxPostalAddress:   func(v string) error { _, err := ParsePostalAddress(v); return err },
+	SyntaxPrintableString: validatePrintableString,
+	SyntaxTelephoneNumber: validatePrintableString,
+}
+
+// ValidateSyntax reports whether value conforms to the syntax with the
+// given OID. Values of syntaxes it does not know are accepted.
+func ValidateSyntax(oid, value string) error {
+	if validate, ok := syntaxValidators[oid]; ok {
+		return validate(value)
+	}
+	return nil
+}
+
+func ParseBoolean(s string) (bool, error) {
+	switch s {
+	case "TRUE":
+		return true, nil
+	case "FALSE":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid Boolean %q", s)
+}
+
+func FormatBoolean(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// ParseInteger parses the Integer syntax: an optional minus sign and
+// digits without leading zeros.
+func ParseInteger(s string) (int64, error) {
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || (digits[0] == '0' && (len(digits) > 1 || s[0] == '-')) || strings.Trim(digits, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid Integer %q", s)
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// FormatGeneralizedTime formats t in UTC, with a fraction of a second
+// only if it has one.
+func FormatGeneralizedTime(t time.Time) string {
+	return t.UTC().Format("20060102150405.999999999Z")
+}
+
+// ParsePostalAddress splits a Postal Address value into its lines,
+// which are separated by "$" and may contain "\24" and "\5C" escapes.
+func ParsePostalAddress(s string) ([]string, error) {
+	lines := strings.Split(s, "$")
+	for i, line := range lines {
+		var buf strings.Builder
+		for j := 0; j < len(line); j++ {
+			if line[j] != '\\' {
+				buf.WriteByte(line[j])
+				continue
+			}
+			switch esc := strings.ToUpper(line[j+1 : min(j+3, len(line))]); esc {
+			case "24":
+				buf.WriteByte('$')
+			case "5C":
+				buf.WriteByte('\\')
+			default:
+				return nil, fmt.Errorf("invalid Postal Address %q", s)
+			}
+			j += 2
+		}
+		lines[i] = buf.String()
+		if lines[i] == "" || !utf8.ValidString(lines[i]) {
+			return nil, fmt.Errorf("invalid Postal Address %q", s)
+		}
+	}
+	return lines, nil
+}
+
+func FormatPostalAddress(lines []string) string {
+	escaped := make([]string, len(lines))
+	r := strings.NewReplacer(`\`, `\5C`, `$`, `\24`)
+	for i, line := range lines {
+		escaped[i] = r.Replace(line)
+	}
+	return strings.Join(escaped, "$")
+}
+
+// NormalizeTelephoneNumber returns s without the spaces and hyphens that
+// telephoneNumberMatch ignores.
+func NormalizeTelephoneNumber(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func validateBitString(v string) error {
+	if len(v) < 3 || v[0] != '\'' || !strings.HasSuffix(v, "'B") || strings.Trim(v[1:len(v)-2], "01") != "" {
+		return fmt.Errorf("invalid Bit String %q", v)
+	}
+	return nil
+}
+
+func validateCountryString(v string) error {
+	if len(v) != 2 || validatePrintableString(v) != nil {
+		return fmt.Errorf("invalid Country String %q", v)
+	}
+	return nil
+}
+
+func validateDirectoryString(v string) error {
+	if v == "" || !utf8.ValidString(v) {
+		return fmt.Errorf("invalid Directory String %q", v)
+	}
+	return nil
+}
+
+func validateIA5String(v string) error {
+	for i := 0; i < len(v); i++ {
+		if v[i] > 127 {
+			return fmt.Errorf("invalid IA5 String %q", v)
+		}
+	}
+	return nil
+}
+
+func validateNumericString(v string) error {
+	if v == "" || strings.Trim(v, "0123456789 ") != "" {
+		return fmt.Errorf("invalid Numeric String %q", v)
+	}
+	return nil
+}
+
+// validateOID accepts a numeric OID or a descr: a letter followed by
+// letters, digits and hyphens.
+func validateOID(v string) error {
+	if v != "" && isLetter(v[0]) {
+		for i := 1; i < len(v); i++ {
+			if c := v[i]; !isLetter(c) && !isDigit(c) && c != '-' {
+				return fmt.Errorf("invalid OID %q", v)
+			}
+		}
+		return nil
+	}
+	for _, arc := range strings.Split(v, ".") {
+		if arc == "" || strings.Trim(arc, "0123456789") != "" || (len(arc) > 1 && arc[0] == '0') {
+			return fmt.Errorf("invalid OID %q", v)
+		}
+	}
+	return nil
+}
+
+func validatePrintableString(v string) error {
+	if v == "" {
+		return fmt.Errorf("invalid Printable String %q", v)
+	}
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; !isLetter(c) && !isDigit(c) && !strings.ContainsRune(`'()+,-./:? =`, rune(c)) {
+			return fmt.Errorf("invalid Printable String %q", v)
+		}
+	}
+	return nil
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// ParseGeneralizedTime parses the GeneralizedTime syntax of RFC 4517
+// §3.3.13: YYYYMMDDHH[MM[SS]][(.|,)fraction](Z|(+|-)HH[MM]). A fraction
+// applies to the last unit given.
+func ParseGeneralizedTime(s string) (time.Time, error) {
+	bad := fmt.Errorf("invalid GeneralizedTime %q", s)
+	digits := func(n int) (int, bool) {
+		if len(s) < n {
+			return 0, false
+		}
+		v, err := strconv.Atoi(s[:n])
+		if err != nil || strings.ContainsAny(s[:n], "+-") {
+			return 0, false
+		}
+		s = s[n:]
+		return v, true
+	}
+
+	var fields [6]int // year, month, day, hour, minute, second
+	widths := []int{4, 2, 2, 2, 2, 2}
+	n := 0
+	for ; n < len(widths); n++ {
+		if n >= 4 && (s == "" || s[0] < '0' || s[0] > '9') {
+			break
+		}
+		v, ok := digits(widths[n])
+		if !ok {
+			return time.Time{}, bad
+		}
+		fields[n] = v
+	}
+	if n < 4 {
+		return time.Time{}, bad
+	}
+
+	var frac time.Duration
+	if s != "" && (s[0] == '.' || s[0] == ',') {
+		i := 1
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 1 {
+			return time.Time{}, bad
+		}
+		f, _ := strconv.ParseFloat("0."+s[1:i], 64)
+		unit := []time.Duration{time.Hour, time.Minute, time.Second}[n-4]
+		frac = time.Duration(f * float64(unit))
+		s = s[i:]
+	}
+
+	loc := time.UTC
+	switch {
+	case s == "Z":
+	case len(s) == 3 || len(s) == 5:
+		sign := 1
+		if s[0] == '-' {
+			sign = -1
+		} else if s[0] != '+' {
+			return time.Time{}, bad
+		}
+		s = s[1:]
+		hh, ok := digits(2)
+		if !ok {
+			return time.Time{}, bad
+		}
+		mm := 0
+		if s != "" {
+			if mm, ok = digits(2); !ok {
+				return time.Time{}, bad
+			}
+		}
+		loc = time.FixedZone("", sign*(hh*3600+mm*60))
+	default:
+		return time.Time{}, bad
+	}
+
+	t := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, loc)
+	if t.Month() != time.Month(fields[1]) || t.Day() != fields[2] || t.Hour() != fields[3] || t.Minute() != fields[4] {
+		return time.Time{}, bad
+	}
+	return t.Add(frac), nil
+}
@@ -0,0 +1,101 @@
+package ldap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateSyntax(t *testing.T) {
+	tests := []struct {
+		syntax, value string
+		ok            bool
+	}{
+		{SyntaxBoolean, "TRUE", true},
+		{SyntaxBoolean, "true", false},
+		{SyntaxInteger, "-42", true},
+		{SyntaxInteger, "042", false},
+		{SyntaxInteger, "-0", false},
+		{SyntaxDN, "cn=a,dc=example", true},
+		{SyntaxDN, "cn", false},
+		{SyntaxDirectoryString, "", false},
+		{SyntaxGeneralizedTime, "20150102030405Z", true},
+		{SyntaxGeneralizedTime, "yesterday", false},
+		{SyntaxIA5String, "caf\xc3\xa9", false},
+		{SyntaxNumericString, "12 34", true},
+		{SyntaxOID, "1.2.840.113556", true},
+		{SyntaxOID, "cn", true},
+		{SyntaxOID, "1..2", false},
+		{SyntaxPostalAddress, `1 Main St$Springfield\24`, true},
+		{SyntaxPostalAddress, `1 Main St$$Springfield`, false},
+		{SyntaxPrintableString, "Hello, world.", true},
+		{SyntaxPrintableString, "a@b", false},
+		{SyntaxTelephoneNumber, "+1 555-0100", true},
+		{SyntaxCountryString, "USA", false},
+		{SyntaxBitString, "'0101'B", true},
+		{"1.2.3.4", "anything", true},
+	}
+	for i, test := range tests {
+		if err := ValidateSyntax(test.syntax, test.value); (err == nil) != test.ok {
+			t.Errorf("#%d: Bad result: %v for %q", i, err, test.value)
+		}
+	}
+}
+
+func TestPostalAddress(t *testing.T) {
+	lines := []string{"1 Main St", "Costs $5", `a\b`}
+	s := FormatPostalAddress(lines)
+	if expected := `1 Main St$Costs \245$a\5Cb`; s != expected {
+		t.Errorf("Bad result: %q (expected %q)", s, expected)
+	}
+	if result, err := ParsePostalAddress(s); err != nil || !reflect.DeepEqual(result, lines) {
+		t.Errorf("Bad result: %q, %v (expected %q)", result, err, lines)
+	}
+}
+
+func TestFormatGeneralizedTime(t *testing.T) {
+	tests := []struct {
+		in       time.Time
+		expected string
+	}{
+		{time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC), "20150102030405Z"},
+		{time.Date(2015, 1, 2, 3, 4, 5, 5e8, time.FixedZone("", 3600)), "20150102020405.5Z"},
+	}
+	for i, test := range tests {
+		if s := FormatGeneralizedTime(test.in); s != test.expected {
+			t.Errorf("#%d: Bad result: %q (expected %q)", i, s, test.expected)
+		}
+	}
+}
+
+func TestNormalizeTelephoneNumber(t *testing.T) {
+	if s := NormalizeTelephoneNumber("+1 555-0100"); s != "+15550100" {
+		t.Errorf("Bad result: %q (expected %q)", s, "+15550100")
+	}
+}
+
+func TestParseGeneralizedTime(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Time
+		ok       bool
+	}{
+		{"20150102030405Z", time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"201501020304Z", time.Date(2015, 1, 2, 3, 4, 0, 0, time.UTC), true},
+		{"2015010203Z", time.Date(2015, 1, 2, 3, 0, 0, 0, time.UTC), true},
+		{"20150102030405.5Z", time.Date(2015, 1, 2, 3, 4, 5, 5e8, time.UTC), true},
+		{"2015010203,25Z", time.Date(2015, 1, 2, 3, 15, 0, 0, time.UTC), true},
+		{"20150102030405+0130", time.Date(2015, 1, 2, 3, 4, 5, 0, time.FixedZone("", 5400)), true},
+		{"20150102030405-05", time.Date(2015, 1, 2, 3, 4, 5, 0, time.FixedZone("", -18000)), true},
+		{"20150102030405", time.Time{}, false},
+		{"20151302030405Z", time.Time{}, false},
+		{"2015010225Z", time.Time{}, false},
+		{"2015Z", time.Time{}, false},
+	}
+	for i, test := range tests {
+		result, err := ParseGeneralizedTime(test.in)
+		if (err == nil) != test.ok || !result.Equal(test.expected) {
+			t.Errorf("#%d: Bad result: %v, %v (expected %v)", i, result, err, test.expected)
+		}
+	}
+}
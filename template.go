@@ -0,0 +1,230 @@
+package ldap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A FilterTemplate is a string filter with named parameters, such as
+// "(&(objectClass=person)(uid={username}))", for filters that come from
+// configuration. Parameter values are escaped as RFC 4515 requires when
+// the template is expanded, so they cannot change its structure.
+// Parameters may only appear in assertion values, and a literal brace is
+// written doubled: "{{" or "}}".
+type FilterTemplate struct {
+	text     string
+	segments []templateSegment
+}
+
+// A templateSegment is literal text, or a parameter if param is set.
+type templateSegment struct {
+	text  string
+	param bool
+}
+
+// ParseFilterTemplate parses s and checks that it expands to a valid
+// filter.
+func ParseFilterTemplate(s string) (*FilterTemplate, error) {
+	segments, err := parseTemplate(s)
+	if err != nil {
+		return nil, err
+	}
+	// Each parameter must follow the '=' of the item it is in.
+	inValue := false
+	for _, seg := range segments {
+		if seg.param {
+			if !inValue {
+				return nil, fmt.Errorf("ldap: parameter {%s} outside an assertion value in %q", seg.text, s)
+			}
+			continue
+		}
+		for i := 0; i < len(seg.text); i++ {
+			switch seg.text[i] {
+			case '(', ')':
+				inValue = false
+			case '=':
+				inValue = true
+			}
+		}
+	}
+	t := &FilterTemplate{text: s, segments: segments}
+	if _, err := CompileFilter(t.expand(func(string) string { return "x" })); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *FilterTemplate) String() string {
+	return t.text
+}
+
+// Params returns the names of the parameters of t, in the order they
+// first appear.
+func (t *FilterTemplate) Params() []string {
+	return templateParams(t.segments)
+}
+
+// Expand returns the filter string with each parameter replaced by its
+// escaped value. Every parameter must be given and not be empty, since
+// an empty value could turn e.g. (cn={name}*) into a presence filter.
+func (t *FilterTemplate) Expand(params map[string]string) (string, error) {
+	if err := checkParams(t.segments, params); err != nil {
+		return "", err
+	}
+	for _, name := range t.Params() {
+		if params[name] == "" {
+			return "", fmt.Errorf("ldap: empty template parameter %q", name)
+		}
+	}
+	return t.expand(func(name string) string { return EscapeFilter(params[name]) }), nil
+}
+
+// Filter expands t and compiles the result.
+func (t *FilterTemplate) Filter(params map[string]string) (Filter, error) {
+	s, err := t.Expand(params)
+	if err != nil {
+		return nil, err
+	}
+	return CompileFilter(s)
+}
+
+func (t *FilterTemplate) expand(value func(name string) string) string {
+	var buf bytes.Buffer
+	for _, seg := range t.segments {
+		if seg.param {
+			buf.WriteString(value(seg.text))
+		} else {
+			buf.WriteString(seg.text)
+		}
+	}
+	return buf.String()
+}
+
+// A SearchTemplate describes a search whose base DN and filter have
+// named parameters, as in a FilterTemplate. Parameters in BaseDN are
+// escaped as attribute values of a DN (RFC 4514).
+type SearchTemplate struct {
+	BaseDN     string
+	Scope      SearchScope
+	Filter     string
+	Attributes []string
+}
+
+// Request expands t into a search request.
+func (t *SearchTemplate) Request(params map[string]string) (SearchRequest, error) {
+	base, err := expandDNTemplate(t.BaseDN, params)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	ft, err := ParseFilterTemplate(t.Filter)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	f, err := ft.Filter(params)
+	if err != nil {
+		return SearchRequest{}, err
+	}
+	attrs := make([][]byte, len(t.Attributes))
+	for i, a := range t.Attributes {
+		attrs[i] = []byte(a)
+	}
+	return SearchRequest{
+		BaseObject: []byte(base),
+		Scope:      t.Scope,
+		Filter:     f,
+		Attributes: attrs,
+	}, nil
+}
+
+func expandDNTemplate(s string, params map[string]string) (string, error) {
+	segments, err := parseTemplate(s)
+	if err != nil {
+		return "", err
+	}
+	if err := checkParams(segments, params); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	for _, seg := range segments {
+		if seg.param {
+			buf.WriteString(EscapeDN(params[seg.text]))
+		} else {
+			buf.WriteString(seg.text)
+		}
+	}
+	if _, err := ParseDN(buf.String()); err != nil {
+		return "", fmt.Errorf("ldap: base DN template %q: %v", s, err)
+	}
+	return buf.String(), nil
+}
+
+// parseTemplate splits s into literal text and {name} parameters.
+func parseTemplate(s string) ([]templateSegment, error) {
+	var segments []templateSegment
+	var lit bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case (c == '{' || c == '}') && i+1 < len(s) && s[i+1] == c:
+			lit.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("ldap: unterminated parameter in template %q", s)
+			}
+			name := s[i+1 : i+end]
+			if !validParamName(name) {
+				return nil, fmt.Errorf("ldap: invalid parameter name %q in template %q", name, s)
+			}
+			if lit.Len() > 0 {
+				segments = append(segments, templateSegment{text: lit.String()})
+				lit.Reset()
+			}
+			segments = append(segments, templateSegment{text: name, param: true})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("ldap: unmatched '}' in template %q", s)
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	if lit.Len() > 0 {
+		segments = append(segments, templateSegment{text: lit.String()})
+	}
+	return segments, nil
+}
+
+func validParamName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+func templateParams(segments []templateSegment) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, seg := range segments {
+		if seg.param && !seen[seg.text] {
+			seen[seg.text] = true
+			names = append(names, seg.text)
+		}
+	}
+	return names
+}
+
+func checkParams(segments []templateSegment, params map[string]string) error {
+	for _, name := range templateParams(segments) {
+		if _, ok := params[name]; !ok {
+			return fmt.Errorf("ldap: missing template parameter %q", name)
+		}
+	}
+	return nil
+}
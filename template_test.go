@@ -0,0 +1,108 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilterTemplate(t *testing.T) {
+	tests := []struct {
+		in     string
+		params []string
+		err    string
+	}{
+		{"(&(objectClass=person)(uid={username}))", []string{"username"}, ""},
+		{"(|(uid={user})(mail={user})(cn={first} {last}*))", []string{"user", "first", "last"}, ""},
+		{"(cn={{literal}})", nil, ""},
+		{"(objectClass=*)", nil, ""},
+		{"({attr}=x)", nil, "outside an assertion value"},
+		{"(&{clause})", nil, "outside an assertion value"},
+		{"(uid={us er})", nil, "invalid parameter name"},
+		{"(uid={user", nil, "unterminated"},
+		{"(uid=user})", nil, "unmatched"},
+		{"(uid={})", nil, "invalid parameter name"},
+		{"(uid={user}", nil, "unterminated item"},
+	}
+	for i, test := range tests {
+		ft, err := ParseFilterTemplate(test.in)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("#%d: Bad error: %v (expected %q)", i, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+			continue
+		}
+		if params := ft.Params(); !reflect.DeepEqual(params, test.params) {
+			t.Errorf("#%d: Bad params: %v (expected %v)", i, params, test.params)
+		}
+	}
+}
+
+func TestFilterTemplateExpand(t *testing.T) {
+	ft, err := ParseFilterTemplate("(&(objectClass=person)(|(uid={user})(cn={user}*))(o={{x}}))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, out, err string
+	}{
+		{"jdoe", "(&(objectClass=person)(|(uid=jdoe)(cn=jdoe*))(o={x}))", ""},
+		{"*", "(&(objectClass=person)(|(uid=\\2a)(cn=\\2a*))(o={x}))", ""},
+		{"x)(uid=*))(|(uid=*", "(&(objectClass=person)(|(uid=x\\29\\28uid=\\2a\\29\\29\\28|\\28uid=\\2a)(cn=x\\29\\28uid=\\2a\\29\\29\\28|\\28uid=\\2a*))(o={x}))", ""},
+		{"", "", "empty template parameter"},
+	}
+	for i, test := range tests {
+		out, err := ft.Expand(map[string]string{"user": test.user})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("#%d: Bad error: %v (expected %q)", i, err, test.err)
+			}
+			continue
+		}
+		if err != nil || out != test.out {
+			t.Errorf("#%d: Bad result: %s, %v (expected %s)", i, out, err, test.out)
+		}
+		f, err := ft.Filter(map[string]string{"user": test.user})
+		if err != nil {
+			t.Errorf("#%d: Unexpected error: %v", i, err)
+		} else if s, _ := DecompileFilter(f); s != test.out {
+			t.Errorf("#%d: Bad filter: %s (expected %s)", i, s, test.out)
+		}
+	}
+	if _, err := ft.Expand(nil); err == nil || !strings.Contains(err.Error(), `"user"`) {
+		t.Errorf("Bad error for a missing parameter: %v", err)
+	}
+}
+
+func TestSearchTemplate(t *testing.T) {
+	st := &SearchTemplate{
+		BaseDN:     "ou=people,o={tenant},dc=example,dc=com",
+		Scope:      WholeSubtree,
+		Filter:     "(uid={user})",
+		Attributes: []string{"cn", "mail"},
+	}
+	req, err := st.Request(map[string]string{"tenant": "Acme, Inc.", "user": "j*"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := SearchRequest{
+		BaseObject: []byte(`ou=people,o=Acme\, Inc.,dc=example,dc=com`),
+		Scope:      WholeSubtree,
+		Filter:     Equals("uid", "j*"),
+		Attributes: [][]byte{[]byte("cn"), []byte("mail")},
+	}
+	if !reflect.DeepEqual(req, expected) {
+		t.Errorf("Bad result: %#v (expected %#v)", req, expected)
+	}
+	if _, err := st.Request(map[string]string{"user": "j"}); err == nil {
+		t.Errorf("Expected an error for a missing parameter")
+	}
+	st.Filter = "({attr}=x)"
+	if _, err := st.Request(map[string]string{"tenant": "a", "attr": "cn"}); err == nil {
+		t.Errorf("Expected an error for a parameter outside a value")
+	}
+}
@@ -0,0 +1,168 @@
+package ldap
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+)
+
+type TLSOptions struct {
+	// CAFile names a PEM bundle of the certificate authorities trusted
+	// to sign server certificates, in place of the system pool.
+	CAFile string
+	// CertFile and KeyFile name the PEM certificate and key presented
+	// to servers that ask for a client certificate.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name verified against the server's
+	// certificate, which is otherwise the host dialed.
+	ServerName string
+	// MinVersion defaults to TLS 1.2.
+	MinVersion uint16
+	// InsecureSkipVerify accepts any server certificate. It is meant
+	// for testing only; every connection made this way is logged as a
+	// warning to Logger, or to slog.Default if Logger is nil.
+	InsecureSkipVerify bool
+	Logger             *slog.Logger
+	// Pins maps server names to the pins one of the certificates each
+	// presents must match, after the usual verification: "sha256/"
+	// followed by the base64 SHA-256 hash of a subject public key, as
+	// returned by SPKIPin, or "cert-sha256/" and the hash of a whole
+	// certificate. Servers with no pins are not checked.
+	Pins map[string][]string
+	// VerifyPeerCertificate, if set, is called after the usual
+	// verification and pinning, e.g. to check revocation by CRL or
+	// OCSP. Its arguments are as for tls.Config.VerifyPeerCertificate,
+	// and the chains are empty if InsecureSkipVerify is set.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+}
+
+// NewTLSConfig builds a tls.Config for ldaps:// and StartTLS from opts.
+// Servers are verified as crypto/tls does: against the subject
+// alternative names of their certificate only, so a server dialed by IP
+// address, such as a domain controller, needs an IP address SAN.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: opts.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: reading CA bundle: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ldap: no certificates found in %s", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	for host, pins := range opts.Pins {
+		for _, pin := range pins {
+			if !strings.HasPrefix(pin, "sha256/") && !strings.HasPrefix(pin, "cert-sha256/") {
+				return nil, fmt.Errorf("ldap: bad pin %q for %s", pin, host)
+			}
+		}
+	}
+	var logger *slog.Logger
+	if opts.InsecureSkipVerify {
+		logger = opts.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Warn("ldap: TLS certificate verification is disabled; connections are open to interception")
+		config.InsecureSkipVerify = true
+	}
+	if logger != nil || len(opts.Pins) > 0 || opts.VerifyPeerCertificate != nil {
+		// VerifyConnection rather than VerifyPeerCertificate, which
+		// knows neither the server name nor runs after our own checks.
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if logger != nil {
+				logger.LogAttrs(context.Background(), slog.LevelWarn, "ldap: accepting unverified TLS certificate",
+					slog.String("server_name", cs.ServerName))
+			}
+			if err := checkPins(opts.Pins, cs); err != nil {
+				return err
+			}
+			if opts.VerifyPeerCertificate == nil {
+				return nil
+			}
+			raw := make([][]byte, len(cs.PeerCertificates))
+			for i, cert := range cs.PeerCertificates {
+				raw[i] = cert.Raw
+			}
+			return opts.VerifyPeerCertificate(raw, cs.VerifiedChains)
+		}
+	}
+	return config, nil
+}
+
+// SPKIPin returns the pin of the public key of cert for TLSOptions.Pins.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func certPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "cert-sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkPins fails unless a certificate of cs matches a pin for its
+// server, if there are any.
+func checkPins(pins map[string][]string, cs tls.ConnectionState) error {
+	var want []string
+	for host, p := range pins {
+		if strings.EqualFold(host, cs.ServerName) {
+			want = append(want, p...)
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	for _, cert := range cs.PeerCertificates {
+		spki, whole := SPKIPin(cert), certPin(cert)
+		for _, pin := range want {
+			if pin == spki || pin == whole {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("ldap: certificate of %s does not match its pins", cs.ServerName)
+}
+
+// tlsConfigFor returns config with its ServerName set to the host of
+// addr if it has none, as tls.Dial does, for upgrading a connection to
+// addr with StartTLS. The name is needed for pinning even if
+// verification is disabled.
+func tlsConfigFor(config *tls.Config, addr string) *tls.Config {
+	if config != nil && config.ServerName != "" {
+		return config
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if config == nil {
+		return &tls.Config{ServerName: host}
+	}
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
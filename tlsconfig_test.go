@@ -0,0 +1,282 @@
+package ldap
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tlsTestCert returns a certificate built from template, signed by
+// parent, or self-signed as a CA if parent is nil.
+func tlsTestCert(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM writes cert, and its key if withKey is set, to files in dir.
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate, withKey bool) (certFile, keyFile string) {
+	certFile = filepath.Join(dir, name+".crt")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(certFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if withKey {
+		der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFile = filepath.Join(dir, name+".key")
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(keyFile, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+// tlsHandshake connects a client using config to a server presenting
+// cert, requiring a client certificate signed by clientCAs if it is
+// set, and returns the client's error.
+func tlsHandshake(config *tls.Config, cert tls.Certificate, clientCAs *x509.CertPool) error {
+	// A TCP connection rather than net.Pipe, whose unbuffered writes
+	// deadlock when both sides send at once after a failure.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	s, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		serverConfig.ClientCAs = clientCAs
+	}
+	server := tls.Server(s, serverConfig)
+	go func() {
+		// The client learns whether its certificate was accepted on
+		// its first read: it gets an alert or EOF.
+		server.Handshake()
+		server.Close()
+	}()
+	client := tls.Client(c, config)
+	if err := client.Handshake(); err != nil {
+		return err
+	}
+	_, err = client.Read(make([]byte, 1))
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}}, nil)
+	caFile, _ := writePEM(t, dir, "ca", ca, false)
+	client := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}, &ca)
+	certFile, keyFile := writePEM(t, dir, "client", client, true)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+
+	ipOnly := tlsTestCert(t, &x509.Certificate{IPAddresses: []net.IP{net.ParseIP("10.0.0.1")}}, &ca)
+	sanOnly := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"dc1.example.com"}}, &ca)
+	cnOnly := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "dc1.example.com"}}, &ca)
+	untrusted := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"dc1.example.com"}}, nil)
+
+	tests := []struct {
+		opts      TLSOptions
+		cert      tls.Certificate
+		clientCAs *x509.CertPool
+		ok        bool
+	}{
+		{TLSOptions{CAFile: caFile, ServerName: "10.0.0.1"}, ipOnly, nil, true},
+		{TLSOptions{CAFile: caFile, ServerName: "10.0.0.2"}, ipOnly, nil, false},
+		{TLSOptions{CAFile: caFile, ServerName: "dc1.example.com"}, ipOnly, nil, false},
+		{TLSOptions{CAFile: caFile, ServerName: "dc1.example.com"}, sanOnly, nil, true},
+		{TLSOptions{CAFile: caFile, ServerName: "dc1.example.com"}, cnOnly, nil, false},
+		{TLSOptions{CAFile: caFile, ServerName: "dc1.example.com"}, untrusted, nil, false},
+		{TLSOptions{CAFile: caFile, ServerName: "dc1.example.com"}, sanOnly, clientCAs, false},
+		{TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "dc1.example.com"}, sanOnly, clientCAs, true},
+	}
+	for i, test := range tests {
+		config, err := NewTLSConfig(test.opts)
+		if err != nil {
+			t.Fatalf("#%d: Unexpected error: %v", i, err)
+		}
+		if err := tlsHandshake(config, test.cert, test.clientCAs); (err == nil) != test.ok {
+			t.Errorf("#%d: Bad result: %v (expected ok=%v)", i, err, test.ok)
+		}
+	}
+
+	if _, err := NewTLSConfig(TLSOptions{CAFile: keyFile}); err == nil {
+		t.Errorf("Expected error for a CA bundle with no certificates")
+	}
+	if _, err := NewTLSConfig(TLSOptions{CertFile: certFile}); err == nil {
+		t.Errorf("Expected error for a client certificate without a key")
+	}
+}
+
+func TestNewTLSConfigInsecure(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	config, err := NewTLSConfig(TLSOptions{InsecureSkipVerify: true, Logger: logger})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "verification is disabled") {
+		t.Errorf("Bad log: %q", buf.String())
+	}
+	cert := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"other"}}, nil)
+	config.ServerName = "dc1.example.com"
+	if err := tlsHandshake(config, cert, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "accepting unverified TLS certificate") {
+		t.Errorf("Bad log: %q", buf.String())
+	}
+}
+
+func TestTLSConfigFor(t *testing.T) {
+	tests := []struct {
+		config *tls.Config
+		addr   string
+		out    string
+	}{
+		{nil, "dc1.example.com:389", "dc1.example.com"},
+		{nil, "10.0.0.1:389", "10.0.0.1"},
+		{nil, "[::1]:389", "::1"},
+		{&tls.Config{ServerName: "other"}, "10.0.0.1:389", "other"},
+		{&tls.Config{}, "10.0.0.1:389", "10.0.0.1"},
+	}
+	for i, test := range tests {
+		if out := tlsConfigFor(test.config, test.addr).ServerName; out != test.out {
+			t.Errorf("#%d: Bad result: %q (expected %q)", i, out, test.out)
+		}
+	}
+}
+
+func TestTLSPins(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}}, nil)
+	caFile, _ := writePEM(t, dir, "ca", ca, false)
+	server := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"dc1.example.com"}}, &ca)
+	other := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"dc1.example.com"}}, &ca)
+	selfSigned := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"dc1.example.com"}}, nil)
+
+	tests := []struct {
+		pins     []string
+		cert     tls.Certificate
+		insecure bool
+		ok       bool
+	}{
+		{[]string{SPKIPin(server.Leaf)}, server, false, true},
+		{[]string{certPin(server.Leaf)}, server, false, true},
+		{[]string{SPKIPin(server.Leaf)}, other, false, false},
+		{[]string{SPKIPin(other.Leaf), SPKIPin(server.Leaf)}, server, false, true},
+		{[]string{SPKIPin(selfSigned.Leaf)}, selfSigned, false, false},
+		{[]string{SPKIPin(selfSigned.Leaf)}, selfSigned, true, true},
+		{[]string{SPKIPin(server.Leaf)}, selfSigned, true, false},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for i, test := range tests {
+		config, err := NewTLSConfig(TLSOptions{
+			CAFile:             caFile,
+			ServerName:         "dc1.example.com",
+			InsecureSkipVerify: test.insecure,
+			Logger:             logger,
+			Pins:               map[string][]string{"DC1.example.com": test.pins, "dc2.example.com": {"sha256/x"}},
+		})
+		if err != nil {
+			t.Fatalf("#%d: Unexpected error: %v", i, err)
+		}
+		if err := tlsHandshake(config, test.cert, nil); (err == nil) != test.ok {
+			t.Errorf("#%d: Bad result: %v (expected ok=%v)", i, err, test.ok)
+		}
+	}
+
+	// Servers without pins are only verified.
+	config, _ := NewTLSConfig(TLSOptions{CAFile: caFile, ServerName: "dc1.example.com", Pins: map[string][]string{"dc2.example.com": {"sha256/x"}}})
+	if err := tlsHandshake(config, other, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if _, err := NewTLSConfig(TLSOptions{Pins: map[string][]string{"dc1": {"md5/x"}}}); err == nil {
+		t.Errorf("Expected error for a bad pin")
+	}
+}
+
+func TestTLSVerifyPeerCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlsTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "Test CA"}}, nil)
+	caFile, _ := writePEM(t, dir, "ca", ca, false)
+	server := tlsTestCert(t, &x509.Certificate{DNSNames: []string{"dc1.example.com"}}, &ca)
+
+	var chains [][]*x509.Certificate
+	revoked := fmt.Errorf("revoked")
+	config, err := NewTLSConfig(TLSOptions{
+		CAFile:     caFile,
+		ServerName: "dc1.example.com",
+		VerifyPeerCertificate: func(raw [][]byte, verified [][]*x509.Certificate) error {
+			chains = verified
+			if len(raw) != 1 || !bytes.Equal(raw[0], server.Certificate[0]) {
+				return fmt.Errorf("bad raw certificates")
+			}
+			return revoked
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := tlsHandshake(config, server, nil); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("Bad result: %v (expected %v)", err, revoked)
+	}
+	if len(chains) != 1 || len(chains[0]) != 2 {
+		t.Errorf("Bad verified chains: %v", chains)
+	}
+}
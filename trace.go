@@ -0,0 +1,105 @@
+package ldap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/stesla/ldap/asn1"
+	"log/slog"
+)
+
+// A Tracer creates a span for each request a connection sends, for
+// distributed tracing; set it with DialOpts.Tracer. The span is a child
+// of any span in the context the connection is bound to, so use
+// WithContext, or Pool.WithConnContext, to trace an operation as part
+// of a larger one. An OpenTelemetry adapter is a few lines:
+//
+//	func (t otelTracer) Start(ctx context.Context, op string, attrs []slog.Attr) ldap.Span {
+//		kvs := make([]attribute.KeyValue, len(attrs))
+//		for i, a := range attrs {
+//			kvs[i] = attribute.String("ldap."+a.Key, a.Value.String())
+//		}
+//		_, span := t.tracer.Start(ctx, "ldap "+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(kvs...))
+//		return otelSpan{span}
+//	}
+//
+//	func (s otelSpan) End(code ldap.ResultCode, err error) {
+//		s.span.SetAttributes(attribute.Int("ldap.result", int(code)))
+//		if err != nil || code != ldap.Success {
+//			s.span.SetStatus(codes.Error, code.String())
+//		}
+//		s.span.End()
+//	}
+type Tracer interface {
+	// Start begins a span for an operation, e.g. "bind" or "search",
+	// with attributes describing the request, such as its DN and, for
+	// searches, scope and filter. Credentials are never included.
+	Start(ctx context.Context, op string, attrs []slog.Attr) Span
+}
+
+// A Span is the trace of one request.
+type Span interface {
+	// End ends the span with the result code of the response, or with
+	// err if the request failed without one.
+	End(code ResultCode, err error)
+}
+
+var errNoResponse = errors.New("ldap: no response")
+
+// startSpan begins a span for a request sent with id.
+func (l *conn) startSpan(id int, op interface{}) {
+	if l.tracer == nil {
+		return
+	}
+	l.mu.Lock()
+	p := l.pending[id]
+	l.mu.Unlock()
+	if p == nil {
+		return
+	}
+	ov, _ := op.(asn1.OptionValue)
+	span := l.tracer.Start(l.ctx, opNames[p.request], l.requestAttrs(ov.Value))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p.span = span
+}
+
+// endSpan ends the span of a finished request, with the reason there
+// was no response if there was none: the context, the connection's
+// failure, or the timeout.
+func (p *pendingRequest) endSpan(l *conn, failure error) {
+	if p.span == nil {
+		return
+	}
+	p.mu.Lock()
+	responded, result := p.responded, p.result
+	p.mu.Unlock()
+	if responded {
+		p.span.End(result, nil)
+		return
+	}
+	err := l.ctx.Err()
+	if err == nil {
+		err = failure
+	}
+	if err == nil && p.expired != nil {
+		select {
+		case <-p.expired:
+			err = ErrTimeout
+		default:
+		}
+	}
+	if err == nil {
+		err = errNoResponse
+	}
+	p.span.End(0, err)
+}
+
+// hashFilter returns the hex SHA-256 hash of a filter, for
+// DialOpts.HashFilters.
+func hashFilter(filter string) string {
+	sum := sha256.Sum256([]byte(filter))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,80 @@
+package ldap
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type testSpan struct {
+	op     string
+	parent interface{}
+	attrs  map[string]string
+	code   ResultCode
+	err    error
+}
+
+type testTracer struct {
+	ended chan *testSpan
+}
+
+type spanKey struct{}
+
+func (t *testTracer) Start(ctx context.Context, op string, attrs []slog.Attr) Span {
+	s := &testSpan{op: op, parent: ctx.Value(spanKey{}), attrs: map[string]string{}}
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value.String()
+	}
+	return testSpanEnder{t, s}
+}
+
+type testSpanEnder struct {
+	t *testTracer
+	s *testSpan
+}
+
+func (e testSpanEnder) End(code ResultCode, err error) {
+	e.s.code, e.s.err = code, err
+	e.t.ended <- e.s
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &testTracer{ended: make(chan *testSpan, 3)}
+	client, server := net.Pipe()
+	c := newConnWithOpts(client, DialOpts{Tracer: tracer, HashFilters: true})
+	defer c.Close()
+
+	received := make(chan bool)
+	go func() {
+		m, _ := readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:1", ldapResult{ResultCode: InvalidCredentials, MatchedDN: []byte{}, Message: []byte{}})
+		m, _ = readTestMessage(server)
+		writeTestMessage(server, m.MessageId, "application,tag:5", ldapResult{MatchedDN: []byte{}, Message: []byte{}})
+		readTestMessage(server)
+		received <- true
+		readTestMessage(server) // the abandon
+	}()
+
+	c.Bind("cn=admin", "secret")
+	ctx := context.WithValue(context.Background(), spanKey{}, "parent")
+	c.WithContext(ctx).Search(SearchRequest{BaseObject: []byte("dc=example"), Scope: WholeSubtree, Filter: Present("uid")})
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-received
+		cancel()
+	}()
+	c.WithContext(ctx).Del("cn=x")
+
+	expected := []*testSpan{
+		{op: "bind", attrs: map[string]string{"dn": "cn=admin", "auth": "simple", "password": "REDACTED"}, code: InvalidCredentials},
+		{op: "search", parent: "parent", attrs: map[string]string{"dn": "dc=example", "scope": "2", "filter": hashFilter("(uid=*)")}},
+		{op: "delete", parent: "parent", attrs: map[string]string{"dn": "cn=x"}, err: context.Canceled},
+	}
+	for i, e := range expected {
+		if s := <-tracer.ended; !reflect.DeepEqual(s, e) {
+			t.Errorf("#%d: Bad span: %+v (expected %+v)", i, s, e)
+		}
+	}
+}
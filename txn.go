@@ -0,0 +1,156 @@
+package ldap
+
+import (
+	"fmt"
+)
+
+const (
+	oidStartTxn            = "1.3.6.1.1.21.1"
+	ControlTypeTransaction = "1.3.6.1.1.21.2"
+	oidEndTxn              = "1.3.6.1.1.21.3"
+)
+
+// ControlTransaction is the Transaction Specification control (RFC
+// 5805), which makes an update part of the transaction with ID rather
+// than applying it at once. It is always critical.
+type ControlTransaction struct {
+	ID []byte
+}
+
+func (c *ControlTransaction) ControlType() string { return ControlTypeTransaction }
+func (c *ControlTransaction) Critical() bool      { return true }
+
+func (c *ControlTransaction) ControlValue() ([]byte, error) {
+	return c.ID, nil
+}
+
+// StartTransaction begins a transaction (RFC 5805) and returns its
+// identifier. If RootDSE has been read and the server does not advertise
+// transactions, StartTransaction fails without sending the request.
+func (l *conn) StartTransaction() ([]byte, error) {
+	if dse := l.cachedRootDSE(); dse != nil && !dse.SupportsExtension(oidStartTxn) {
+		return nil, fmt.Errorf("ldap: server does not support transactions")
+	}
+	r, err := l.extended(oidStartTxn, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Value) == 0 {
+		return nil, fmt.Errorf("ldap: no transaction identifier in response")
+	}
+	return r.Value, nil
+}
+
+// txnEndRequest is the value of an End Transaction request, whose
+// commit field defaults to true and so is only sent to abort.
+type txnEndRequest struct {
+	ID []byte
+}
+
+type txnAbortRequest struct {
+	Commit bool
+	ID     []byte
+}
+
+// EndTransaction commits or aborts the transaction with id. The updates
+// of a committed transaction are applied together or not at all; if any
+// fails, the error is that of the update.
+func (l *conn) EndTransaction(id []byte, commit bool) error {
+	var req interface{} = txnEndRequest{id}
+	if !commit {
+		req = txnAbortRequest{false, id}
+	}
+	value, err := encodeValue(req)
+	if err != nil {
+		return err
+	}
+	_, err = l.extended(oidEndTxn, value)
+	return err
+}
+
+// A Txn groups updates into a transaction, which the server applies
+// atomically when it is committed.
+//
+//	txn, err := ldap.StartTxn(conn)
+//	if err != nil {
+//		return err
+//	}
+//	if err := txn.Add(dn, attrs); err != nil {
+//		txn.Abort()
+//		return err
+//	}
+//	return txn.Commit()
+type Txn struct {
+	conn  Conn
+	id    []byte
+	ended bool
+}
+
+// StartTxn starts a transaction on c.
+func StartTxn(c Conn) (*Txn, error) {
+	id, err := c.StartTransaction()
+	if err != nil {
+		return nil, err
+	}
+	return &Txn{conn: c, id: id}, nil
+}
+
+// ID returns the transaction's identifier.
+func (t *Txn) ID() []byte { return t.id }
+
+func (t *Txn) controls(controls []Control) ([]Control, error) {
+	if t.ended {
+		return nil, fmt.Errorf("ldap: transaction has ended")
+	}
+	return append(controls, &ControlTransaction{t.id}), nil
+}
+
+func (t *Txn) Add(dn string, attrs []Attribute, controls ...Control) error {
+	controls, err := t.controls(controls)
+	if err != nil {
+		return err
+	}
+	return t.conn.Add(dn, attrs, controls...)
+}
+
+func (t *Txn) Modify(dn string, mods []Modification, controls ...Control) error {
+	controls, err := t.controls(controls)
+	if err != nil {
+		return err
+	}
+	return t.conn.Modify(dn, mods, controls...)
+}
+
+func (t *Txn) ModifyDN(dn, newRDN string, deleteOldRDN bool, newSuperior string, controls ...Control) error {
+	controls, err := t.controls(controls)
+	if err != nil {
+		return err
+	}
+	return t.conn.ModifyDN(dn, newRDN, deleteOldRDN, newSuperior, controls...)
+}
+
+func (t *Txn) Del(dn string, controls ...Control) error {
+	controls, err := t.controls(controls)
+	if err != nil {
+		return err
+	}
+	return t.conn.Del(dn, controls...)
+}
+
+// Commit applies the transaction's updates.
+func (t *Txn) Commit() error {
+	return t.end(true)
+}
+
+// Abort discards the transaction's updates.
+func (t *Txn) Abort() error {
+	return t.end(false)
+}
+
+func (t *Txn) end(commit bool) error {
+	if t.ended {
+		return fmt.Errorf("ldap: transaction has ended")
+	}
+	t.ended = true
+	return t.conn.EndTransaction(t.id, commit)
+}
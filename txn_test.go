@@ -0,0 +1,99 @@
+package ldap
+
+import (
+	"bytes"
+	"github.com/stesla/ldap/asn1"
+	"net"
+	"testing"
+)
+
+func TestTxn(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	type request struct {
+		name, value string
+		controls    []control
+	}
+	requests := make(chan request)
+	go func() {
+		for {
+			var op asn1.RawValue
+			m := ldapMessage{ProtocolOp: &op}
+			dec := asn1.NewDecoder(server)
+			dec.Implicit = true
+			if err := dec.Decode(&m); err != nil {
+				close(requests)
+				return
+			}
+			ok := ldapResult{MatchedDN: []byte{}, Message: []byte{}}
+			switch op.Tag {
+			case 23:
+				var req extendedRequest
+				decodeOp(op, "application,tag:23", &req)
+				requests <- request{string(req.Name), string(req.Value), m.Controls}
+				resp := extendedResponse{Result: ok}
+				if string(req.Name) == oidStartTxn {
+					resp.Value = []byte("txn1")
+				}
+				writeTestMessage(server, m.MessageId, "application,tag:24", resp)
+			case 10:
+				requests <- request{"del", string(op.Bytes), m.Controls}
+				writeTestMessage(server, m.MessageId, "application,tag:11", ok)
+			}
+		}
+	}()
+
+	errc := make(chan error, 1)
+	go func() {
+		txn, err := StartTxn(c)
+		if err == nil {
+			err = txn.Del("cn=one")
+		}
+		if err == nil {
+			err = txn.Commit()
+		}
+		if err == nil {
+			if txn.Del("cn=two") == nil {
+				t.Error("Del succeeded after Commit")
+			}
+			if txn.Abort() == nil {
+				t.Error("Abort succeeded after Commit")
+			}
+		}
+		errc <- err
+	}()
+
+	expected := []request{
+		{oidStartTxn, "", nil},
+		{"del", "cn=one", []control{{[]byte(ControlTypeTransaction), true, []byte("txn1")}}},
+		{oidEndTxn, "\x30\x06\x04\x04txn1", nil},
+	}
+	for i, e := range expected {
+		r := <-requests
+		if r.name != e.name || r.value != e.value || len(r.controls) != len(e.controls) {
+			t.Errorf("#%d: Bad result: %v %q (expected %v %q)", i, r.name, r.value, e.name, e.value)
+			continue
+		}
+		for j := range e.controls {
+			if string(r.controls[j].Type) != string(e.controls[j].Type) || !r.controls[j].Criticality ||
+				!bytes.Equal(r.controls[j].Value, e.controls[j].Value) {
+				t.Errorf("#%d: Bad control: %v (expected %v)", i, r.controls[j], e.controls[j])
+			}
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndTransactionAbort(t *testing.T) {
+	value, err := encodeValue(txnAbortRequest{false, []byte("txn1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\x30\x09\x01\x01\x00\x04\x04txn1"; string(value) != expected {
+		t.Errorf("Bad result: %x (expected %x)", value, expected)
+	}
+}
@@ -0,0 +1,128 @@
+package ldap
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unmarshaler is implemented by types that can decode themselves from
+// the values of an attribute.
+type Unmarshaler interface {
+	UnmarshalLDAPAttr(values []string) error
+}
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+// Unmarshal stores the entry's attributes in the struct pointed to by v.
+// Each exported field is filled from the attribute named by its `ldap`
+// tag, or by the field name if it has none; the tag "dn" selects the
+// entry's DN and "-" skips the field. Fields whose attribute is missing
+// are left alone.
+//
+// Fields may be strings, integers, bools (TRUE or FALSE), time.Time
+// (GeneralizedTime), []byte, slices of those, or implement Unmarshaler.
+// Single-valued fields take the first value.
+func (e *Entry) Unmarshal(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("ldap: Unmarshal needs a non-nil struct pointer, not %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("ldap"); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+
+		var values []string
+		if strings.EqualFold(name, "dn") {
+			values = []string{e.DN}
+		} else if a := e.GetAttribute(name); a != nil {
+			values = a.Values
+		} else {
+			continue
+		}
+		if err := unmarshalField(rv.Field(i), values); err != nil {
+			return fmt.Errorf("ldap: cannot unmarshal %s into field %s: %v", name, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func unmarshalField(f reflect.Value, values []string) error {
+	if f.CanAddr() && f.Addr().Type().Implements(unmarshalerType) {
+		return f.Addr().Interface().(Unmarshaler).UnmarshalLDAPAttr(values)
+	}
+
+	if f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8 {
+		s := reflect.MakeSlice(f.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := unmarshalValue(s.Index(i), value); err != nil {
+				return err
+			}
+		}
+		f.Set(s)
+		return nil
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	return unmarshalValue(f, values[0])
+}
+
+func unmarshalValue(f reflect.Value, value string) error {
+	if f.Type() == timeType {
+		t, err := ParseGeneralizedTime(value)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Slice:
+		if f.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %v", f.Type())
+		}
+		f.SetBytes([]byte(value))
+	case reflect.Bool:
+		b, err := ParseBoolean(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %v", f.Type())
+	}
+	return nil
+}
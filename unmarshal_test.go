@@ -0,0 +1,70 @@
+package ldap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type upperCase string
+
+func (u *upperCase) UnmarshalLDAPAttr(values []string) error {
+	*u = upperCase(strings.ToUpper(strings.Join(values, ",")))
+	return nil
+}
+
+func TestUnmarshal(t *testing.T) {
+	type user struct {
+		DN       string    `ldap:"dn"`
+		Mail     string    `ldap:"mail"`
+		Groups   []string  `ldap:"memberOf"`
+		UID      int       `ldap:"uidNumber"`
+		Locked   bool      `ldap:"pwdLocked"`
+		Changed  time.Time `ldap:"modifyTimestamp"`
+		Photo    []byte    `ldap:"jpegPhoto"`
+		Initials upperCase `ldap:"initials"`
+		Missing  string    `ldap:"missing"`
+		Cn       string
+		Ignored  string `ldap:"-"`
+	}
+	e := NewEntry("uid=jane,dc=example,dc=com", map[string][]string{
+		"mail":            {"jane@example.com", "j@example.com"},
+		"memberOf":        {"cn=a", "cn=b"},
+		"uidNumber":       {"1001"},
+		"pwdLocked":       {"TRUE"},
+		"modifyTimestamp": {"20150102030405Z"},
+		"jpegPhoto":       {"\xff\xd8"},
+		"initials":        {"j", "d"},
+		"cn":              {"Jane"},
+		"ignored":         {"x"},
+	})
+
+	var u user
+	u.Missing = "default"
+	if err := e.Unmarshal(&u); err != nil {
+		t.Fatal(err)
+	}
+	expected := user{
+		DN:       "uid=jane,dc=example,dc=com",
+		Mail:     "jane@example.com",
+		Groups:   []string{"cn=a", "cn=b"},
+		UID:      1001,
+		Locked:   true,
+		Changed:  time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+		Photo:    []byte("\xff\xd8"),
+		Initials: "J,D",
+		Missing:  "default",
+		Cn:       "Jane",
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("Bad result: %+v (expected %+v)", u, expected)
+	}
+
+	var bad struct {
+		UID int `ldap:"uidNumber"`
+	}
+	if err := NewEntry("", map[string][]string{"uidNumber": {"x"}}).Unmarshal(&bad); err == nil {
+		t.Errorf("Expected an error for a non-numeric integer")
+	}
+}
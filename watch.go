@@ -0,0 +1,249 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A ChangeEvent is a change to an entry, reported by Watch.
+type ChangeEvent struct {
+	Type ChangeType
+	DN   string
+	// PreviousDN is the DN of a renamed entry before the change.
+	PreviousDN string
+	// Before is the entry as Watch last saw it, if it did. After is the
+	// entry after the change, or nil for deletes.
+	Before *Entry
+	After  *Entry
+	// Err is set on the last event sent if the watch failed.
+	Err error
+}
+
+// watchPollInterval is how often Watch polls with DirSync.
+var watchPollInterval = 30 * time.Second
+
+// Watch reports the changes to the entries below baseDN that match
+// filter, from the best mechanism the server's root DSE lists: Content
+// Synchronization (RFC 4533), persistent search, or Active Directory's
+// DirSync, which is polled every 30 seconds and applies the filter to
+// changed attributes only. The channel is closed when ctx is done or
+// the search ends; if the watch fails, the last event carries the error.
+//
+// Watch keeps a copy of every entry in scope, from which it fills in
+// the Before of events, and the After of DirSync changes, which carry
+// only the attributes that changed.
+func (l *conn) Watch(ctx context.Context, baseDN string, filter Filter) (<-chan ChangeEvent, error) {
+	c := l.WithContext(ctx)
+	dse := l.cachedRootDSE()
+	if dse == nil {
+		var err error
+		if dse, err = c.RootDSE(); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &watcher{ctx: ctx, events: make(chan ChangeEvent), entries: map[string]*Entry{}}
+	req := SearchRequest{BaseObject: []byte(baseDN), Scope: WholeSubtree, Filter: filter}
+	var run func() error
+	switch {
+	case dse.SupportsControl(ControlTypeSyncRequest):
+		run = func() error {
+			s := &SyncClient{Conn: c, Request: req, Mode: SyncRefreshAndPersist, Handler: &syncWatcher{watcher: w}}
+			return s.Run()
+		}
+	case dse.SupportsControl(ControlTypePersistentSearch):
+		run = func() error {
+			psearch := &ControlPersistentSearch{ChangeTypes: ChangeAny, ReturnECs: true, Criticality: true}
+			return c.PersistentSearch(req, psearch, w.persistentSearchResult)
+		}
+	case dse.SupportsControl(ControlTypeDirSync):
+		run = func() error { return w.dirSync(c, req) }
+	default:
+		return nil, fmt.Errorf("ldap: server supports no change notification mechanism")
+	}
+
+	go func() {
+		defer close(w.events)
+		if err := run(); err != nil && ctx.Err() == nil {
+			w.send(ChangeEvent{Err: err})
+		}
+	}()
+	return w.events, nil
+}
+
+// A watcher turns the results of a change notification mechanism into
+// ChangeEvents. entries holds the entries in scope, keyed by entryUUID
+// or objectGUID, or by normalized DN for persistent searches.
+type watcher struct {
+	ctx     context.Context
+	events  chan ChangeEvent
+	entries map[string]*Entry
+}
+
+func (w *watcher) send(ev ChangeEvent) error {
+	select {
+	case w.events <- ev:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// persistentSearchResult handles an entry of a persistent search. The
+// initial results, without a notification, are not changes.
+func (w *watcher) persistentSearchResult(r SearchResult, ecn *ControlEntryChangeNotification) error {
+	e, key := r.Entry(), normalizeDN(r.DN)
+	if ecn == nil {
+		w.entries[key] = e
+		return nil
+	}
+	ev := ChangeEvent{Type: ecn.ChangeType, DN: r.DN, After: e}
+	old := key
+	if ecn.ChangeType == ChangeModDN {
+		ev.PreviousDN, old = ecn.PreviousDN, normalizeDN(ecn.PreviousDN)
+	}
+	ev.Before = w.entries[old]
+	delete(w.entries, old)
+	if ecn.ChangeType == ChangeDelete {
+		if ev.Before == nil {
+			ev.Before = e
+		}
+		ev.After = nil
+	} else {
+		w.entries[key] = e
+	}
+	return w.send(ev)
+}
+
+// A syncWatcher is the SyncHandler of a Watch. The entries sent before
+// the refresh is done are not changes.
+type syncWatcher struct {
+	*watcher
+	refreshed bool
+}
+
+func (w *syncWatcher) Update(state SyncState, uuid []byte, entry SearchResult) error {
+	key := string(uuid)
+	before := w.entries[key]
+	e := entry.Entry()
+	switch {
+	case state == SyncDelete:
+		delete(w.entries, key)
+		if w.refreshed {
+			return w.send(ChangeEvent{Type: ChangeDelete, DN: entry.DN, Before: before})
+		}
+		return nil
+	case state == SyncPresent && len(e.Attributes) == 0:
+		return nil
+	}
+	w.entries[key] = e
+	if !w.refreshed {
+		return nil
+	}
+	return w.send(changeFrom(before, e))
+}
+
+func (w *syncWatcher) Delete(uuids [][]byte) error {
+	for _, uuid := range uuids {
+		if err := w.remove(string(uuid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *syncWatcher) PresentDone(present map[string]bool) error {
+	for key := range w.entries {
+		if !present[key] {
+			if err := w.remove(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove forgets the entry key, reporting its deletion once refreshed.
+func (w *syncWatcher) remove(key string) error {
+	before := w.entries[key]
+	if before == nil {
+		return nil
+	}
+	delete(w.entries, key)
+	if !w.refreshed {
+		return nil
+	}
+	return w.send(ChangeEvent{Type: ChangeDelete, DN: before.DN, Before: before})
+}
+
+func (w *syncWatcher) RefreshDone() error {
+	w.refreshed = true
+	return nil
+}
+
+func (w *syncWatcher) Cookie([]byte) error { return nil }
+
+// dirSync polls with DirSync until ctx is done. The results of the first
+// search, with no cookie, are not changes.
+func (w *watcher) dirSync(c Conn, req SearchRequest) error {
+	var cookie []byte
+	for first := true; ; first = false {
+		var err error
+		cookie, err = c.DirSync(req, 0, cookie, func(r SearchResult) error {
+			return w.dirSyncResult(r, first)
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(watchPollInterval):
+		case <-w.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// dirSyncResult handles an object returned by DirSync, which carries
+// only the attributes that changed and, if it was deleted, isDeleted.
+func (w *watcher) dirSyncResult(r SearchResult, initial bool) error {
+	changes := r.Entry()
+	key := changes.GetAttributeValue("objectGUID")
+	before := w.entries[key]
+	if strings.EqualFold(changes.GetAttributeValue("isDeleted"), "TRUE") {
+		delete(w.entries, key)
+		if initial || before == nil {
+			return nil
+		}
+		return w.send(ChangeEvent{Type: ChangeDelete, DN: before.DN, Before: before})
+	}
+
+	after := &Entry{DN: changes.DN}
+	if before != nil {
+		for _, a := range before.Attributes {
+			if changes.GetAttribute(a.Name) == nil {
+				after.Attributes = append(after.Attributes, a)
+			}
+		}
+	}
+	after.Attributes = append(after.Attributes, changes.Attributes...)
+	w.entries[key] = after
+	if initial {
+		return nil
+	}
+	return w.send(changeFrom(before, after))
+}
+
+// changeFrom returns the event that turned before, which may be nil,
+// into after.
+func changeFrom(before, after *Entry) ChangeEvent {
+	ev := ChangeEvent{Type: ChangeModify, DN: after.DN, Before: before, After: after}
+	switch {
+	case before == nil:
+		ev.Type = ChangeAdd
+	case normalizeDN(before.DN) != normalizeDN(after.DN):
+		ev.Type, ev.PreviousDN = ChangeModDN, before.DN
+	}
+	return ev
+}
@@ -0,0 +1,181 @@
+package ldap
+
+import (
+	"context"
+	"github.com/stesla/ldap/asn1"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func newTestWatcher() *watcher {
+	return &watcher{ctx: context.Background(), events: make(chan ChangeEvent, 10), entries: map[string]*Entry{}}
+}
+
+// watchEvents returns the events w has sent, as type, DN, previous DN
+// and the cn of the entry before and after.
+func watchEvents(w *watcher) [][]string {
+	out := [][]string{}
+	for len(w.events) > 0 {
+		ev := <-w.events
+		before, after := "", ""
+		if ev.Before != nil {
+			before = ev.Before.GetAttributeValue("cn")
+		}
+		if ev.After != nil {
+			after = ev.After.GetAttributeValue("cn")
+		}
+		out = append(out, []string{ev.Type.String(), ev.DN, ev.PreviousDN, before, after})
+	}
+	return out
+}
+
+func cnResult(dn, cn string, attrs ...string) SearchResult {
+	r := SearchResult{DN: dn, Attributes: map[string][]string{"cn": {cn}}}
+	for i := 0; i < len(attrs); i += 2 {
+		r.Attributes[attrs[i]] = []string{attrs[i+1]}
+	}
+	return r
+}
+
+func TestWatchPersistentSearch(t *testing.T) {
+	w := newTestWatcher()
+	steps := []struct {
+		result SearchResult
+		ecn    *ControlEntryChangeNotification
+	}{
+		{cnResult("cn=a,dc=x", "a"), nil},
+		{cnResult("cn=b,dc=x", "b"), nil},
+		{cnResult("cn=a,dc=x", "a2"), &ControlEntryChangeNotification{ChangeType: ChangeModify}},
+		{cnResult("cn=c,dc=x", "c"), &ControlEntryChangeNotification{ChangeType: ChangeModDN, PreviousDN: "CN=B,dc=x"}},
+		{cnResult("cn=c,dc=x", "c"), &ControlEntryChangeNotification{ChangeType: ChangeDelete}},
+		{cnResult("cn=d,dc=x", "d"), &ControlEntryChangeNotification{ChangeType: ChangeAdd}},
+	}
+	for _, s := range steps {
+		if err := w.persistentSearchResult(s.result, s.ecn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := [][]string{
+		{"modify", "cn=a,dc=x", "", "a", "a2"},
+		{"modDN", "cn=c,dc=x", "CN=B,dc=x", "b", "c"},
+		{"delete", "cn=c,dc=x", "", "c", ""},
+		{"add", "cn=d,dc=x", "", "", "d"},
+	}
+	if events := watchEvents(w); !reflect.DeepEqual(events, expected) {
+		t.Errorf("Bad result: %v (expected %v)", events, expected)
+	}
+}
+
+func TestWatchSync(t *testing.T) {
+	w := &syncWatcher{watcher: newTestWatcher()}
+	steps := []func() error{
+		func() error { return w.Update(SyncAdd, []byte("1"), cnResult("cn=a,dc=x", "a")) },
+		func() error { return w.Update(SyncAdd, []byte("2"), cnResult("cn=b,dc=x", "b")) },
+		func() error { return w.Update(SyncAdd, []byte("3"), cnResult("cn=c,dc=x", "c")) },
+		func() error { return w.Update(SyncPresent, []byte("1"), SearchResult{DN: "cn=a,dc=x"}) },
+		func() error { return w.PresentDone(map[string]bool{"1": true, "2": true}) },
+		w.RefreshDone,
+		func() error { return w.Update(SyncModify, []byte("1"), cnResult("cn=a,dc=x", "a2")) },
+		func() error { return w.Update(SyncModify, []byte("2"), cnResult("cn=e,dc=x", "b")) },
+		func() error { return w.Update(SyncAdd, []byte("4"), cnResult("cn=d,dc=x", "d")) },
+		func() error { return w.Update(SyncDelete, []byte("1"), SearchResult{DN: "cn=a,dc=x"}) },
+		func() error { return w.Delete([][]byte{[]byte("4"), []byte("5")}) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := [][]string{
+		{"modify", "cn=a,dc=x", "", "a", "a2"},
+		{"modDN", "cn=e,dc=x", "cn=b,dc=x", "b", "b"},
+		{"add", "cn=d,dc=x", "", "", "d"},
+		{"delete", "cn=a,dc=x", "", "a2", ""},
+		{"delete", "cn=d,dc=x", "", "d", ""},
+	}
+	if events := watchEvents(w.watcher); !reflect.DeepEqual(events, expected) {
+		t.Errorf("Bad result: %v (expected %v)", events, expected)
+	}
+}
+
+func TestWatchDirSync(t *testing.T) {
+	w := newTestWatcher()
+	steps := []struct {
+		result  SearchResult
+		initial bool
+	}{
+		{cnResult("cn=a,dc=x", "a", "objectGUID", "1", "sn", "A"), true},
+		{cnResult("cn=b,dc=x", "b", "objectGUID", "2"), true},
+		{SearchResult{DN: "cn=a,dc=x", Attributes: map[string][]string{"objectGUID": {"1"}, "sn": {"Z"}}}, false},
+		{SearchResult{DN: "cn=b\\0ADEL:2,cn=Deleted Objects,dc=x", Attributes: map[string][]string{"objectGUID": {"2"}, "isDeleted": {"TRUE"}}}, false},
+		{cnResult("cn=c,dc=x", "c", "objectGUID", "3"), false},
+	}
+	for _, s := range steps {
+		if err := w.dirSyncResult(s.result, s.initial); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := [][]string{
+		{"modify", "cn=a,dc=x", "", "a", "a"},
+		{"delete", "cn=b,dc=x", "", "b", ""},
+		{"add", "cn=c,dc=x", "", "", "c"},
+	}
+	if events := watchEvents(w); !reflect.DeepEqual(events, expected) {
+		t.Errorf("Bad result: %v (expected %v)", events, expected)
+	}
+	if sn := w.entries["1"].GetAttributeValue("sn"); sn != "Z" {
+		t.Errorf("Bad merged value: %q (expected %q)", sn, "Z")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConn(client)
+	defer c.Close()
+
+	c.rootDSE = &RootDSE{}
+	if _, err := c.Watch(context.Background(), "dc=x", Present("objectClass")); err == nil {
+		t.Error("Expected an error without a change notification mechanism")
+	}
+
+	c.rootDSE = &RootDSE{SupportedControl: []string{ControlTypePersistentSearch}}
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := c.Watch(ctx, "dc=x", Present("objectClass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := asn1.NewDecoder(server)
+	dec.Implicit = true
+	var m struct {
+		MessageId int
+		Op        asn1.RawValue
+		Controls  []control `asn1:"tag:0,optional"`
+	}
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	ecn, _ := (&ControlEntryChangeNotification{ChangeType: ChangeAdd}).ControlValue()
+	for _, msg := range []ldapMessage{
+		{MessageId: m.MessageId, ProtocolOp: asn1.OptionValue{Opts: "application,tag:4", Value: searchResultEntry{[]byte("cn=a,dc=x"), []partialAttribute{}}}},
+		{MessageId: m.MessageId, ProtocolOp: asn1.OptionValue{Opts: "application,tag:4", Value: searchResultEntry{[]byte("cn=b,dc=x"), []partialAttribute{}}},
+			Controls: []control{{Type: []byte(ControlTypeEntryChangeNotification), Value: ecn}}},
+	} {
+		enc := asn1.NewEncoder(server)
+		enc.Implicit = true
+		if err := enc.Encode(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ev := <-events; ev.Type != ChangeAdd || ev.DN != "cn=b,dc=x" || ev.Err != nil {
+		t.Errorf("Bad event: %+v", ev)
+	}
+
+	cancel()
+	if abandon, err := readTestMessage(server); err != nil || abandon.Op.Tag != 16 {
+		t.Errorf("Bad request: %v, %v (expected an abandon)", abandon.Op.Tag, err)
+	}
+	if ev, ok := <-events; ok {
+		t.Errorf("Bad event: %+v (expected the channel to be closed)", ev)
+	}
+}